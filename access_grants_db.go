@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+const accessGrantColumns = `id, organization_id, user_id, permissions, granted_by, expires_at, created_at`
+
+// PutAccessGrant creates or replaces an organization's grant to a user,
+// filling in its ID and creation time from the stored grant
+func (db *DB) PutAccessGrant(ctx context.Context, grant *AccessGrant) error {
+	return db.tenantTx(ctx, grant.OrganizationID, func(tx *sqlx.Tx) error {
+		return tx.GetContext(ctx, grant, `
+			INSERT INTO access_grants (id, organization_id, user_id, permissions, granted_by, expires_at)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (organization_id, user_id) DO UPDATE
+			SET permissions = EXCLUDED.permissions, granted_by = EXCLUDED.granted_by,
+			    expires_at = EXCLUDED.expires_at, created_at = CURRENT_TIMESTAMP
+			RETURNING `+accessGrantColumns,
+			grant.ID, grant.OrganizationID, grant.UserID, grant.Permissions, grant.GrantedBy, grant.ExpiresAt)
+	})
+}
+
+func (db *DB) GetAccessGrant(ctx context.Context, orgID, userID uuid.UUID) (*AccessGrant, error) {
+	grant := &AccessGrant{}
+	err := db.tenantRead(ctx, orgID, func(q sqlx.QueryerContext) error {
+		return sqlx.GetContext(ctx, q, grant, `
+			SELECT `+accessGrantColumns+` FROM access_grants
+			WHERE organization_id = $1 AND user_id = $2 AND expires_at > NOW()
+		`, orgID, userID)
+	})
+	if err == sql.ErrNoRows {
+		return nil, ErrAccessGrantNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return grant, nil
+}
+
+func (db *DB) ListAccessGrants(ctx context.Context, orgID uuid.UUID) ([]AccessGrant, error) {
+	grants := []AccessGrant{}
+	err := db.tenantRead(ctx, orgID, func(q sqlx.QueryerContext) error {
+		return sqlx.SelectContext(ctx, q, &grants, `
+			SELECT `+accessGrantColumns+` FROM access_grants
+			WHERE organization_id = $1 AND expires_at > NOW()
+			ORDER BY expires_at, id
+		`, orgID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return grants, nil
+}
+
+// ListUserAccessGrants looks across organizations, so it reads outside any
+// tenant
+func (db *DB) ListUserAccessGrants(ctx context.Context, userID uuid.UUID) ([]AccessGrant, error) {
+	ctx = unscoped(ctx)
+	grants := []AccessGrant{}
+	err := db.read(ctx, func(q sqlx.QueryerContext) error {
+		return sqlx.SelectContext(ctx, q, &grants, `
+			SELECT `+accessGrantColumns+` FROM access_grants
+			WHERE user_id = $1 AND expires_at > NOW()
+			ORDER BY expires_at, id
+		`, userID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return grants, nil
+}
+
+// DeleteAccessGrant revokes one of an organization's grants. It takes
+// effect on the grantee's next request.
+func (db *DB) DeleteAccessGrant(ctx context.Context, orgID, id uuid.UUID) error {
+	return db.tenantTx(ctx, orgID, func(tx *sqlx.Tx) error {
+		result, err := tx.ExecContext(ctx, `
+			DELETE FROM access_grants WHERE id = $1 AND organization_id = $2
+		`, id, orgID)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrAccessGrantNotFound
+		}
+		return nil
+	})
+}
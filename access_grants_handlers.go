@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// handleCreateAccessGrant lets a user from another organization act on this
+// one until the grant expires. Only the owner can grant access, and
+// granting a user again replaces their grant.
+func (s *Server) handleCreateAccessGrant(w http.ResponseWriter, r *http.Request) {
+	orgID := pathOrgID(r)
+	owner, _ := GetUserFromContext(r.Context())
+	if owner.Role != "owner" {
+		http.Error(w, "Only the owner can grant access", http.StatusForbidden)
+		return
+	}
+
+	var req AccessGrantRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if err := ValidateAccessGrantRequest(&req); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+
+	grantee, err := s.store.GetUserByEmail(r.Context(), req.Email)
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to look up grantee", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if grantee == nil {
+		writeValidationError(w, &ValidationError{Field: "email", Message: ErrUserNotFound.Error()})
+		return
+	}
+	member, err := s.auth.hierarchy.Contains(r.Context(), grantee.OrganizationID, orgID)
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to check organization hierarchy", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if member {
+		writeValidationError(w, &ValidationError{Field: "email", Message: "already has access as a member"})
+		return
+	}
+
+	grant := &AccessGrant{
+		ID:             uuid.New(),
+		OrganizationID: orgID,
+		UserID:         grantee.ID,
+		Permissions:    req.Permissions,
+		GrantedBy:      owner.ID,
+		ExpiresAt:      time.Now().UTC().Add(time.Duration(req.Duration)).Truncate(time.Microsecond),
+	}
+	if err := s.store.PutAccessGrant(r.Context(), grant); err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to create access grant", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	s.recordAudit(r, "access_grant.created", orgID, grantee.ID.String(), AuditMetadata{
+		"grant_id":    grant.ID.String(),
+		"permissions": joinPermissions(grant.Permissions),
+		"expires_at":  grant.ExpiresAt.Format(time.RFC3339),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(grant)
+}
+
+func (s *Server) handleListAccessGrants(w http.ResponseWriter, r *http.Request) {
+	grants, err := s.store.ListAccessGrants(r.Context(), pathOrgID(r))
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to list access grants", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(grants)
+}
+
+// handleDeleteAccessGrant revokes a grant before it expires
+func (s *Server) handleDeleteAccessGrant(w http.ResponseWriter, r *http.Request) {
+	orgID := pathOrgID(r)
+	grantID, err := uuid.Parse(r.PathValue("grantID"))
+	if err != nil {
+		http.Error(w, "Invalid grant ID format", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.store.DeleteAccessGrant(r.Context(), orgID, grantID); err != nil {
+		switch err {
+		case ErrAccessGrantNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			s.logger.ErrorContext(r.Context(), "failed to delete access grant", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	s.recordAudit(r, "access_grant.revoked", orgID, grantID.String(), nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleListMyAccessGrants lists the organizations the authenticated user
+// has been granted access to
+func (s *Server) handleListMyAccessGrants(w http.ResponseWriter, r *http.Request) {
+	user, err := GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	grants, err := s.store.ListUserAccessGrants(r.Context(), user.ID)
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to list access grants", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(grants)
+}
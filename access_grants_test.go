@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateAccessGrantRequest(t *testing.T) {
+	req := &AccessGrantRequest{
+		Email:       " partner@agency.test ",
+		Permissions: []Permission{PermReadOrg, PermInviteUser, PermReadOrg},
+		Duration:    Duration(24 * time.Hour),
+	}
+	require.NoError(t, ValidateAccessGrantRequest(req))
+	require.Equal(t, "partner@agency.test", req.Email)
+	require.Equal(t, []Permission{PermInviteUser, PermReadOrg}, req.Permissions)
+
+	err := ValidateAccessGrantRequest(&AccessGrantRequest{
+		Email:       "not an address",
+		Permissions: []Permission{PermDeleteOrg, PermPlatformAdmin},
+		Duration:    Duration(MaxAccessGrantDuration + time.Hour),
+	})
+	var errs ValidationErrors
+	require.ErrorAs(t, err, &errs)
+	require.Len(t, errs, 4, "the owner's and operators' permissions cannot be granted")
+}
+
+func TestAccessGrants(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	srv, err := NewServer(store, newTestConfig(t))
+	require.NoError(t, err)
+
+	customer, err := store.CreateOrganization(ctx, "Customer", "owner@customer.test", "Owner")
+	require.NoError(t, err)
+	agency, err := store.CreateOrganization(ctx, "Agency", "owner@agency.test", "Owner")
+	require.NoError(t, err)
+	admin, err := store.AddUserToOrganization(ctx, customer.ID, "admin@customer.test", "Admin")
+	require.NoError(t, err)
+	_, err = store.UpdateUserRole(ctx, customer.ID, admin.ID, "admin")
+	require.NoError(t, err)
+
+	tokenFor := func(id uuid.UUID) string {
+		user, err := store.GetUser(ctx, id)
+		require.NoError(t, err)
+		token, err := srv.tokenManager.GenerateToken(user)
+		require.NoError(t, err)
+		return token
+	}
+	ownerToken, adminToken, partnerToken := tokenFor(customer.OwnerID), tokenFor(admin.ID), tokenFor(agency.OwnerID)
+
+	do := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.RemoteAddr = "203.0.113.7:4000"
+		if method != http.MethodGet {
+			req.Header.Set("Content-Type", "application/json")
+			addCSRFToken(t, srv, req)
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return w
+	}
+	grants := "/organizations/" + customer.ID.String() + "/access-grants"
+	users := "/organizations/" + customer.ID.String() + "/users"
+	grant := func(token, email, permissions string) *httptest.ResponseRecorder {
+		return do(http.MethodPost, grants, token, `{"email":"`+email+`","permissions":`+permissions+`,"duration":"24h"}`)
+	}
+
+	require.Equal(t, http.StatusForbidden, do(http.MethodGet, users, partnerToken, "").Code)
+
+	require.Equal(t, http.StatusForbidden, grant(adminToken, "owner@agency.test", `["read:org"]`).Code, "only the owner grants access")
+	require.Equal(t, http.StatusBadRequest, grant(ownerToken, "admin@customer.test", `["read:org"]`).Code, "members need no grant")
+	require.Equal(t, http.StatusBadRequest, grant(ownerToken, "nobody@agency.test", `["read:org"]`).Code)
+	require.Equal(t, http.StatusBadRequest, grant(ownerToken, "owner@agency.test", `["delete:org"]`).Code)
+
+	w := grant(ownerToken, "owner@agency.test", `["read:org"]`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var created AccessGrant
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&created))
+	require.Equal(t, agency.OwnerID, created.UserID)
+	require.WithinDuration(t, time.Now().Add(24*time.Hour), created.ExpiresAt, time.Minute)
+
+	t.Run("Delegates act within the grant", func(t *testing.T) {
+		require.Equal(t, http.StatusOK, do(http.MethodGet, users, partnerToken, "").Code)
+		w := do(http.MethodPost, users, partnerToken, `{"email":"new@customer.test","name":"New"}`)
+		require.Equal(t, http.StatusForbidden, w.Code, "invite:user was not granted")
+		require.Equal(t, http.StatusForbidden, grant(partnerToken, "someone@agency.test", `["read:org"]`).Code,
+			"an owner elsewhere is not an owner here")
+
+		w = do(http.MethodGet, "/me/access-grants", partnerToken, "")
+		require.Equal(t, http.StatusOK, w.Code)
+		var mine []AccessGrant
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&mine))
+		require.Len(t, mine, 1)
+		require.Equal(t, customer.ID, mine[0].OrganizationID)
+	})
+
+	t.Run("Granting again replaces the grant", func(t *testing.T) {
+		w := grant(ownerToken, "owner@agency.test", `["read:org","invite:user"]`)
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		var replaced AccessGrant
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&replaced))
+		require.Equal(t, created.ID, replaced.ID)
+
+		w = do(http.MethodPost, users, partnerToken, `{"email":"new@customer.test","name":"New"}`)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		w = do(http.MethodGet, grants, ownerToken, "")
+		require.Equal(t, http.StatusOK, w.Code)
+		var listed []AccessGrant
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&listed))
+		require.Len(t, listed, 1)
+	})
+
+	t.Run("The organization's IP allowlist applies", func(t *testing.T) {
+		_, version, err := store.GetOrganizationSettings(ctx, customer.ID)
+		require.NoError(t, err)
+		_, err = store.UpdateOrganizationSettings(ctx, customer.ID, version, &OrganizationSettings{IPAllowlist: []string{"198.51.100.0/24"}})
+		require.NoError(t, err)
+		srv.auth.allowlists.Invalidate()
+		defer func() {
+			_, version, err := store.GetOrganizationSettings(ctx, customer.ID)
+			require.NoError(t, err)
+			_, err = store.UpdateOrganizationSettings(ctx, customer.ID, version, &OrganizationSettings{})
+			require.NoError(t, err)
+			srv.auth.allowlists.Invalidate()
+		}()
+
+		w := do(http.MethodGet, users, partnerToken, "")
+		require.Equal(t, http.StatusForbidden, w.Code)
+		require.Contains(t, w.Body.String(), ErrOutsideIPAllowlist.Error())
+	})
+
+	t.Run("Expired and revoked grants no longer apply", func(t *testing.T) {
+		store.accessGrants[created.ID].ExpiresAt = time.Now().Add(-time.Second)
+		require.Equal(t, http.StatusForbidden, do(http.MethodGet, users, partnerToken, "").Code)
+		store.accessGrants[created.ID].ExpiresAt = time.Now().Add(time.Hour)
+
+		require.Equal(t, http.StatusNoContent, do(http.MethodDelete, grants+"/"+created.ID.String(), adminToken, "").Code)
+		require.Equal(t, http.StatusForbidden, do(http.MethodGet, users, partnerToken, "").Code)
+		require.Equal(t, http.StatusNotFound, do(http.MethodDelete, grants+"/"+created.ID.String(), adminToken, "").Code)
+	})
+}
+
+func TestAccessGrantStore(t *testing.T) {
+	testdb := setupTestDB(t)
+	defer testdb.teardown(t)
+
+	ctx := context.Background()
+	customer, err := testdb.DB.CreateOrganization(ctx, "Grants Customer", "owner@grants-customer.test", "Owner")
+	require.NoError(t, err)
+	agency, err := testdb.DB.CreateOrganization(ctx, "Grants Agency", "owner@grants-agency.test", "Owner")
+	require.NoError(t, err)
+
+	grant := &AccessGrant{
+		ID:             uuid.New(),
+		OrganizationID: customer.ID,
+		UserID:         agency.OwnerID,
+		Permissions:    GrantPermissions{PermReadOrg},
+		GrantedBy:      customer.OwnerID,
+		ExpiresAt:      time.Now().UTC().Add(time.Hour),
+	}
+	require.NoError(t, testdb.DB.PutAccessGrant(ctx, grant))
+
+	replacement := *grant
+	replacement.ID = uuid.New()
+	replacement.Permissions = GrantPermissions{PermInviteUser, PermReadOrg}
+	require.NoError(t, testdb.DB.PutAccessGrant(ctx, &replacement))
+	require.Equal(t, grant.ID, replacement.ID, "granting again keeps the grant")
+
+	got, err := testdb.DB.GetAccessGrant(ctx, customer.ID, agency.OwnerID)
+	require.NoError(t, err)
+	require.Equal(t, replacement.Permissions, got.Permissions)
+
+	mine, err := testdb.DB.ListUserAccessGrants(ctx, agency.OwnerID)
+	require.NoError(t, err)
+	require.Len(t, mine, 1)
+
+	_, err = testdb.DB.GetAccessGrant(ctx, agency.ID, customer.OwnerID)
+	require.ErrorIs(t, err, ErrAccessGrantNotFound)
+
+	require.NoError(t, testdb.DB.DeleteAccessGrant(ctx, customer.ID, grant.ID))
+	require.ErrorIs(t, testdb.DB.DeleteAccessGrant(ctx, customer.ID, grant.ID), ErrAccessGrantNotFound)
+	granted, err := testdb.DB.ListAccessGrants(ctx, customer.ID)
+	require.NoError(t, err)
+	require.Empty(t, granted)
+}
@@ -0,0 +1,455 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	ErrUnknownTier = errors.New("unknown subscription tier")
+	ErrDeleteOwner = errors.New("organization owner cannot be deleted")
+)
+
+// DefaultSubscriptionTier is the tier new organizations start on
+const DefaultSubscriptionTier = "free"
+
+// defaultSubscriptionTiers is the tier catalog unless SUBSCRIPTION_TIERS
+// replaces it
+var defaultSubscriptionTiers = map[string]int{
+	DefaultSubscriptionTier: 5,
+	"pro":                   25,
+	"enterprise":            250,
+}
+
+// SubscriptionTiers is the catalog of subscription tiers in use
+var SubscriptionTiers = NewTierCatalog(defaultSubscriptionTiers)
+
+// TierCatalog maps each subscription tier to its default sub-account limit.
+// A configuration reload replaces it while the server runs; organizations
+// keep the limits they were given.
+type TierCatalog struct {
+	mu     sync.RWMutex
+	limits map[string]int
+}
+
+func NewTierCatalog(limits map[string]int) *TierCatalog {
+	return &TierCatalog{limits: maps.Clone(limits)}
+}
+
+// Limit returns the default sub-account limit of tier, and whether the
+// tier exists
+func (c *TierCatalog) Limit(tier string) (int, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	limit, ok := c.limits[tier]
+	return limit, ok
+}
+
+// DefaultLimit is the sub-account limit of new organizations
+func (c *TierCatalog) DefaultLimit() int {
+	limit, _ := c.Limit(DefaultSubscriptionTier)
+	return limit
+}
+
+// Set replaces the catalog
+func (c *TierCatalog) Set(limits map[string]int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.limits = maps.Clone(limits)
+}
+
+// PlatformStats summarizes the whole installation for platform operators
+type PlatformStats struct {
+	Organizations          int            `json:"organizations"`
+	SuspendedOrganizations int            `json:"suspended_organizations"`
+	Users                  int            `json:"users"`
+	ActiveSessions         int            `json:"active_sessions"`
+	OrganizationsByTier    map[string]int `json:"organizations_by_tier"`
+}
+
+// ListOrganizations retrieves a page of the organizations matching filter,
+// newest first, and how many there are in total. Deleted organizations are
+// left out unless filter.IncludeDeleted is set.
+func (db *DB) ListOrganizations(ctx context.Context, filter OrganizationFilter, limit, offset int) ([]Organization, int, error) {
+	var rows []struct {
+		Organization
+		Total int `db:"total"`
+	}
+	err := db.read(ctx, func(q sqlx.QueryerContext) error {
+		return sqlx.SelectContext(ctx, q, &rows, `
+			SELECT id, name, owner_id, subscription_tier, max_sub_accounts, seat_overage, slug, parent_id, suspended_at, custom_fields, created_at, deleted_at, version, updated_at,
+				COUNT(*) OVER () AS total
+			FROM organizations
+			WHERE ($1 OR deleted_at IS NULL) AND custom_fields @> $4
+			ORDER BY created_at DESC, id
+			LIMIT $2 OFFSET $3
+		`, filter.IncludeDeleted, limit, offset, filter.CustomFields)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	orgs := make([]Organization, len(rows))
+	total := 0
+	for i, row := range rows {
+		orgs[i], total = row.Organization, row.Total
+	}
+	return orgs, total, nil
+}
+
+// SearchOrganizations finds at most limit organizations whose slug or name
+// contains query, exact matches first
+func (db *DB) SearchOrganizations(ctx context.Context, query string, limit int) ([]Organization, error) {
+	orgs := []Organization{}
+	err := db.read(ctx, func(q sqlx.QueryerContext) error {
+		return sqlx.SelectContext(ctx, q, &orgs, `
+			SELECT id, name, owner_id, subscription_tier, max_sub_accounts, seat_overage, slug, parent_id, suspended_at, custom_fields, created_at, deleted_at, version, updated_at
+			FROM organizations
+			WHERE deleted_at IS NULL AND (slug ILIKE $2 ESCAPE '\' OR name ILIKE $2 ESCAPE '\')
+			ORDER BY (slug IS NOT NULL AND slug = lower($1)) DESC, (lower(name) = lower($1)) DESC, name, id
+			LIMIT $3
+		`, query, likePattern(query), limit)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return orgs, nil
+}
+
+// SearchUsers finds a page of users across all organizations whose email or
+// name contains query, and how many match in total. Deleted users are left
+// out unless includeDeleted is set. Encrypted users are only found by their
+// whole email address.
+func (db *DB) SearchUsers(ctx context.Context, query string, includeDeleted bool, limit, offset int) ([]User, int, error) {
+	var rows []struct {
+		User
+		Total int `db:"total"`
+	}
+	err := db.read(ctx, func(q sqlx.QueryerContext) error {
+		return sqlx.SelectContext(ctx, q, &rows, `
+			SELECT id, email, name, organization_id, role, permissions, metadata, created_at, deleted_at, version, updated_at,
+				COUNT(*) OVER () AS total
+			FROM users
+			WHERE (email_hash = $5
+			       OR (NOT starts_with(email, 'pii:') AND email ILIKE $1 ESCAPE '\')
+			       OR (NOT starts_with(name, 'pii:') AND name ILIKE $1 ESCAPE '\'))
+			  AND ($2 OR deleted_at IS NULL)
+			ORDER BY email
+			LIMIT $3 OFFSET $4
+		`, likePattern(query), includeDeleted, limit, offset, db.pii.EmailHash(query))
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	users := make([]User, len(rows))
+	total := 0
+	for i, row := range rows {
+		users[i], total = row.User, row.Total
+	}
+	if err := db.openUserList(users); err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+// SetOrganizationSuspended suspends or reinstates an organization
+func (db *DB) SetOrganizationSuspended(ctx context.Context, id uuid.UUID, suspended bool) (*Organization, error) {
+	org := &Organization{}
+	err := db.GetContext(ctx, org, `
+		UPDATE organizations
+		SET suspended_at = CASE WHEN $2 THEN COALESCE(suspended_at, NOW()) ELSE NULL END,
+			version = version + 1, updated_at = NOW()
+		WHERE id = $1 AND deleted_at IS NULL
+		RETURNING id, name, owner_id, subscription_tier, max_sub_accounts, seat_overage, slug, parent_id, suspended_at, custom_fields, created_at, deleted_at, version, updated_at
+	`, id, suspended)
+	if err == sql.ErrNoRows {
+		return nil, ErrOrganizationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return org, nil
+}
+
+// IsOrganizationSuspended reports whether an organization is currently suspended
+func (db *DB) IsOrganizationSuspended(ctx context.Context, id uuid.UUID) (bool, error) {
+	var suspended bool
+	err := db.GetContext(ctx, &suspended, `
+		SELECT suspended_at IS NOT NULL FROM organizations WHERE id = $1 AND deleted_at IS NULL
+	`, id)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return suspended, nil
+}
+
+// UpdateOrganizationTier moves an organization at expectedVersion to a new
+// subscription tier. A maxSubAccounts of zero applies the tier's default
+// limit, and an empty seatOverage keeps the current overage policy.
+func (db *DB) UpdateOrganizationTier(ctx context.Context, id uuid.UUID, expectedVersion int, tier string, maxSubAccounts int, seatOverage string) (*Organization, error) {
+	defaultLimit, ok := SubscriptionTiers.Limit(tier)
+	if !ok {
+		return nil, ErrUnknownTier
+	}
+	if maxSubAccounts == 0 {
+		maxSubAccounts = defaultLimit
+	}
+	if seatOverage != "" && !slices.Contains(SeatOveragePolicies, seatOverage) {
+		return nil, ErrUnknownSeatOverage
+	}
+
+	org := &Organization{}
+	err := db.transact(ctx, func(tx *sqlx.Tx) error {
+		if err := lockOrganizationVersion(ctx, tx, id, expectedVersion); err != nil {
+			return err
+		}
+		return tx.GetContext(ctx, org, `
+			UPDATE organizations
+			SET subscription_tier = $2, max_sub_accounts = $3, seat_overage = COALESCE(NULLIF($4, ''), seat_overage),
+				version = version + 1, updated_at = NOW()
+			WHERE id = $1
+			RETURNING id, name, owner_id, subscription_tier, max_sub_accounts, seat_overage, slug, parent_id, suspended_at, custom_fields, created_at, deleted_at, version, updated_at
+		`, id, tier, maxSubAccounts, seatOverage)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return org, nil
+}
+
+// SetOrganizationParent places an organization at expectedVersion under
+// parentID, or under no organization if parentID is nil. It refuses a
+// parent that is the organization itself or one of its sub-organizations.
+func (db *DB) SetOrganizationParent(ctx context.Context, id uuid.UUID, expectedVersion int, parentID *uuid.UUID) (*Organization, error) {
+	org := &Organization{}
+	err := db.transact(ctx, func(tx *sqlx.Tx) error {
+		if err := lockOrganizationVersion(ctx, tx, id, expectedVersion); err != nil {
+			return err
+		}
+		if parentID != nil {
+			// The parent and the organizations above it
+			var ancestors []uuid.UUID
+			err := tx.SelectContext(ctx, &ancestors, `
+				WITH RECURSIVE ancestors(id, parent_id) AS (
+					SELECT id, parent_id FROM organizations WHERE id = $1 AND deleted_at IS NULL
+					UNION
+					SELECT o.id, o.parent_id FROM organizations o JOIN ancestors a ON o.id = a.parent_id
+				)
+				SELECT id FROM ancestors
+			`, *parentID)
+			if err != nil {
+				return err
+			}
+			if len(ancestors) == 0 {
+				return ErrParentNotFound
+			}
+			if slices.Contains(ancestors, id) {
+				return ErrOrganizationCycle
+			}
+		}
+		return tx.GetContext(ctx, org, `
+			UPDATE organizations
+			SET parent_id = $2, version = version + 1, updated_at = NOW()
+			WHERE id = $1
+			RETURNING id, name, owner_id, subscription_tier, max_sub_accounts, seat_overage, slug, parent_id, suspended_at, custom_fields, created_at, deleted_at, version, updated_at
+		`, id, parentID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return org, nil
+}
+
+// DeleteOrganization soft-deletes an organization together with its members
+// and signs the members out. The rows stay until retention purges them;
+// pending invitations are deleted outright.
+func (db *DB) DeleteOrganization(ctx context.Context, id uuid.UUID) error {
+	return db.transact(ctx, func(tx *sqlx.Tx) error {
+		result, err := tx.ExecContext(ctx, `
+			UPDATE organizations SET deleted_at = NOW(), version = version + 1, updated_at = NOW()
+			WHERE id = $1 AND deleted_at IS NULL
+		`, id)
+		if err != nil {
+			return err
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			return ErrOrganizationNotFound
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			UPDATE users SET deleted_at = NOW(), version = version + 1, updated_at = NOW()
+			WHERE organization_id = $1 AND deleted_at IS NULL
+		`, id)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			DELETE FROM refresh_tokens
+			WHERE user_id IN (SELECT id FROM users WHERE organization_id = $1)
+		`, id)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM invitations WHERE organization_id = $1`, id)
+		return err
+	})
+}
+
+// DeleteUser soft-deletes a sub-account and signs it out. Owners go with
+// their organization instead.
+func (db *DB) DeleteUser(ctx context.Context, id uuid.UUID) error {
+	return db.transact(ctx, func(tx *sqlx.Tx) error {
+		var user struct {
+			Role           string    `db:"role"`
+			OrganizationID uuid.UUID `db:"organization_id"`
+		}
+		err := tx.GetContext(ctx, &user, `
+			SELECT role, organization_id FROM users WHERE id = $1 AND deleted_at IS NULL FOR UPDATE
+		`, id)
+		if err == sql.ErrNoRows {
+			return ErrUserNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if user.Role == "owner" {
+			return ErrDeleteOwner
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			UPDATE users SET deleted_at = NOW(), version = version + 1, updated_at = NOW()
+			WHERE id = $1
+		`, id)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			DELETE FROM refresh_tokens WHERE user_id = $1
+		`, id)
+		if err != nil {
+			return err
+		}
+
+		if user.Role == "sub_account" {
+			return recordSeatChange(ctx, tx, user.OrganizationID, id, -1)
+		}
+		return nil
+	})
+}
+
+// MoveUser moves a member to another organization. It works across
+// tenants, so it runs outside any.
+func (db *DB) MoveUser(ctx context.Context, userID, fromOrgID, toOrgID uuid.UUID, role string) (*User, error) {
+	if !slices.Contains(AssignableRoles, role) {
+		return nil, ErrUnknownRole
+	}
+
+	user := &User{}
+	err := db.transact(ctx, func(tx *sqlx.Tx) error {
+		// Lock both organizations, in a fixed order, so seats are counted one
+		// change at a time
+		var locked []uuid.UUID
+		err := tx.SelectContext(ctx, &locked, `
+			SELECT id FROM organizations WHERE id IN ($1, $2) AND deleted_at IS NULL ORDER BY id FOR UPDATE
+		`, fromOrgID, toOrgID)
+		if err != nil {
+			return err
+		}
+		if !slices.Contains(locked, toOrgID) {
+			return ErrOrganizationNotFound
+		}
+
+		var previous string
+		err = tx.GetContext(ctx, &previous, `
+			SELECT role FROM users WHERE id = $1 AND organization_id = $2 AND deleted_at IS NULL FOR UPDATE
+		`, userID, fromOrgID)
+		if err == sql.ErrNoRows {
+			return ErrUserNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		err = tx.GetContext(ctx, user, `
+			UPDATE users SET organization_id = $2, role = $3, version = version + 1, updated_at = NOW()
+			WHERE id = $1
+			RETURNING id, email, name, organization_id, role, permissions, metadata, created_at, deleted_at, version, updated_at
+		`, userID, toOrgID, role)
+		if err != nil {
+			return err
+		}
+		if previous == "sub_account" {
+			if err := recordSeatChange(ctx, tx, fromOrgID, userID, -1); err != nil {
+				return err
+			}
+		}
+		if role == "sub_account" {
+			return recordSeatChange(ctx, tx, toOrgID, userID, 1)
+		}
+		return nil
+	})
+	if err == nil {
+		err = db.openUsers(user)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// GetPlatformStats aggregates installation-wide counters
+func (db *DB) GetPlatformStats(ctx context.Context) (*PlatformStats, error) {
+	stats := &PlatformStats{OrganizationsByTier: make(map[string]int)}
+
+	err := db.read(ctx, func(q sqlx.QueryerContext) error {
+		return q.QueryRowxContext(ctx, `
+			SELECT
+				(SELECT COUNT(*) FROM organizations WHERE deleted_at IS NULL),
+				(SELECT COUNT(*) FROM organizations WHERE suspended_at IS NOT NULL AND deleted_at IS NULL),
+				(SELECT COUNT(*) FROM users WHERE deleted_at IS NULL),
+				(SELECT COUNT(*) FROM refresh_tokens WHERE expires_at > NOW() AND revoked_at IS NULL)
+		`).Scan(&stats.Organizations, &stats.SuspendedOrganizations, &stats.Users, &stats.ActiveSessions)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var tiers []struct {
+		Tier  string `db:"subscription_tier"`
+		Count int    `db:"count"`
+	}
+	err = db.read(ctx, func(q sqlx.QueryerContext) error {
+		return sqlx.SelectContext(ctx, q, &tiers, `
+			SELECT subscription_tier, COUNT(*) AS count
+			FROM organizations
+			WHERE deleted_at IS NULL
+			GROUP BY subscription_tier
+		`)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tiers {
+		stats.OrganizationsByTier[t.Tier] = t.Count
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,550 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+
+	// TotalCountHeader tells clients of paginated listings how many items
+	// match across all pages
+	TotalCountHeader = "X-Total-Count"
+)
+
+type UpdateTierRequest struct {
+	SubscriptionTier string `json:"subscription_tier"`
+	MaxSubAccounts   int    `json:"max_sub_accounts"`
+	SeatOverage      string `json:"seat_overage,omitempty"` // block or allow; unchanged if empty
+}
+
+type SetParentRequest struct {
+	ParentID *uuid.UUID `json:"parent_id"` // null detaches the organization from its parent
+}
+
+// parsePagination reads limit and offset query parameters, applying defaults and bounds
+func parsePagination(r *http.Request) (limit, offset int, ok bool) {
+	limit, offset = defaultPageSize, 0
+
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return 0, 0, false
+		}
+		limit = min(n, maxPageSize)
+	}
+
+	if v := r.URL.Query().Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, 0, false
+		}
+		offset = n
+	}
+
+	return limit, offset, true
+}
+
+// parseIncludeDeleted reads the include_deleted query parameter, which lets
+// platform operators see soft-deleted records
+func parseIncludeDeleted(r *http.Request) (includeDeleted, ok bool) {
+	v := r.URL.Query().Get("include_deleted")
+	if v == "" {
+		return false, true
+	}
+	includeDeleted, err := strconv.ParseBool(v)
+	return includeDeleted, err == nil
+}
+
+func (s *Server) handleAdminListOrganizations(w http.ResponseWriter, r *http.Request) {
+	limit, offset, ok := parsePagination(r)
+	if !ok {
+		http.Error(w, "Invalid pagination parameters", http.StatusBadRequest)
+		return
+	}
+
+	includeDeleted, ok := parseIncludeDeleted(r)
+	if !ok {
+		http.Error(w, "Invalid include_deleted parameter", http.StatusBadRequest)
+		return
+	}
+
+	fields, err := s.store.ListOrganizationFields(r.Context())
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to list organization fields", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	customFields, err := parseOrganizationFieldFilter(r, fields)
+	if err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	filter := OrganizationFilter{IncludeDeleted: includeDeleted, CustomFields: customFields}
+
+	orgs, total, err := s.store.ListOrganizations(r.Context(), filter, limit, offset)
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to list organizations", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set(TotalCountHeader, strconv.Itoa(total))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(orgs)
+}
+
+func (s *Server) handleAdminSearchUsers(w http.ResponseWriter, r *http.Request) {
+	limit, offset, ok := parsePagination(r)
+	if !ok {
+		http.Error(w, "Invalid pagination parameters", http.StatusBadRequest)
+		return
+	}
+
+	includeDeleted, ok := parseIncludeDeleted(r)
+	if !ok {
+		http.Error(w, "Invalid include_deleted parameter", http.StatusBadRequest)
+		return
+	}
+
+	users, total, err := s.store.SearchUsers(r.Context(), r.URL.Query().Get("q"), includeDeleted, limit, offset)
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to search users", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set(TotalCountHeader, strconv.Itoa(total))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(users)
+}
+
+func (s *Server) handleAdminSuspendOrganization(w http.ResponseWriter, r *http.Request) {
+	s.setOrganizationSuspended(w, r, pathOrgID(r), true)
+}
+
+func (s *Server) handleAdminUnsuspendOrganization(w http.ResponseWriter, r *http.Request) {
+	s.setOrganizationSuspended(w, r, pathOrgID(r), false)
+}
+
+func (s *Server) setOrganizationSuspended(w http.ResponseWriter, r *http.Request, orgID uuid.UUID, suspended bool) {
+	org, err := s.store.SetOrganizationSuspended(r.Context(), orgID, suspended)
+	if err != nil {
+		switch err {
+		case ErrOrganizationNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			s.logger.ErrorContext(r.Context(), "failed to update organization suspension", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	s.logger.InfoContext(r.Context(), "organization suspension changed",
+		"organization_id", orgID,
+		"suspended", suspended,
+	)
+
+	action := "organization.suspended"
+	if !suspended {
+		action = "organization.reinstated"
+	}
+	s.recordAudit(r, action, orgID, orgID.String(), nil)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(org)
+}
+
+func (s *Server) handleAdminUpdateTier(w http.ResponseWriter, r *http.Request) {
+	orgID := pathOrgID(r)
+
+	expectedVersion, ok := ifMatchVersion(w, r)
+	if !ok {
+		return
+	}
+
+	var req UpdateTierRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	if req.MaxSubAccounts < 0 {
+		http.Error(w, "max_sub_accounts: must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	org, err := s.store.UpdateOrganizationTier(r.Context(), orgID, expectedVersion, req.SubscriptionTier, req.MaxSubAccounts, req.SeatOverage)
+	if err != nil {
+		switch err {
+		case ErrUnknownTier, ErrUnknownSeatOverage:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case ErrOrganizationNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case ErrVersionConflict:
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			s.logger.ErrorContext(r.Context(), "failed to update organization tier", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	s.recordAudit(r, "organization.tier_changed", orgID, orgID.String(), AuditMetadata{
+		"subscription_tier": org.SubscriptionTier,
+		"max_sub_accounts":  strconv.Itoa(org.MaxSubAccounts),
+		"seat_overage":      org.SeatOverage,
+	})
+
+	w.Header().Set("ETag", versionETag(org.Version))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(org)
+}
+
+// handleAdminSetParent places an organization under another, whose members
+// may then act on it, or detaches it
+func (s *Server) handleAdminSetParent(w http.ResponseWriter, r *http.Request) {
+	orgID := pathOrgID(r)
+
+	expectedVersion, ok := ifMatchVersion(w, r)
+	if !ok {
+		return
+	}
+
+	var req SetParentRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	org, err := s.store.SetOrganizationParent(r.Context(), orgID, expectedVersion, req.ParentID)
+	if err != nil {
+		switch err {
+		case ErrParentNotFound, ErrOrganizationCycle:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case ErrOrganizationNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case ErrVersionConflict:
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			s.logger.ErrorContext(r.Context(), "failed to set organization parent", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+	s.auth.hierarchy.Invalidate()
+
+	parent := ""
+	if org.ParentID != nil {
+		parent = org.ParentID.String()
+	}
+	s.recordAudit(r, "organization.parent_changed", orgID, orgID.String(), AuditMetadata{"parent_id": parent})
+
+	w.Header().Set("ETag", versionETag(org.Version))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(org)
+}
+
+// handleAdminGetRetention returns an organization's retention override
+func (s *Server) handleAdminGetRetention(w http.ResponseWriter, r *http.Request) {
+	override, version, err := s.store.GetRetentionOverride(r.Context(), pathOrgID(r))
+	if err != nil {
+		switch err {
+		case ErrOrganizationNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			s.logger.ErrorContext(r.Context(), "failed to get retention override", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("ETag", versionETag(version))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(override)
+}
+
+// handleAdminUpdateRetention replaces an organization's retention override.
+// Windows left out of the request follow the installation default.
+func (s *Server) handleAdminUpdateRetention(w http.ResponseWriter, r *http.Request) {
+	orgID := pathOrgID(r)
+
+	expectedVersion, ok := ifMatchVersion(w, r)
+	if !ok {
+		return
+	}
+
+	var override RetentionOverride
+	if !decodeJSON(w, r, &override) {
+		return
+	}
+
+	for field, window := range map[string]*Duration{
+		"refresh_tokens":   override.RefreshTokens,
+		"revoked_sessions": override.RevokedSessions,
+		"audit_events":     override.AuditEvents,
+		"deleted_records":  override.DeletedRecords,
+	} {
+		if window != nil && *window < 0 {
+			http.Error(w, field+": must not be negative", http.StatusBadRequest)
+			return
+		}
+	}
+
+	version, err := s.store.UpdateRetentionOverride(r.Context(), orgID, expectedVersion, &override)
+	if err != nil {
+		switch err {
+		case ErrOrganizationNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case ErrVersionConflict:
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			s.logger.ErrorContext(r.Context(), "failed to update retention override", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	retention, _ := json.Marshal(override)
+	s.recordAudit(r, "organization.retention_changed", orgID, orgID.String(), AuditMetadata{
+		"retention": string(retention),
+	})
+
+	w.Header().Set("ETag", versionETag(version))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(override)
+}
+
+// handleAdminDeleteOrganization soft-deletes an organization and its members.
+// They are purged for good once the retention window has passed.
+func (s *Server) handleAdminDeleteOrganization(w http.ResponseWriter, r *http.Request) {
+	orgID := pathOrgID(r)
+
+	if err := s.store.DeleteOrganization(r.Context(), orgID); err != nil {
+		switch err {
+		case ErrOrganizationNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			s.logger.ErrorContext(r.Context(), "failed to delete organization", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	s.recordAudit(r, "organization.deleted", orgID, orgID.String(), nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAdminMergeOrganization merges an organization into another in the
+// background, or with dry_run set only returns what the merge would do. A
+// merge whose plan has problems is refused until they are dealt with.
+func (s *Server) handleAdminMergeOrganization(w http.ResponseWriter, r *http.Request) {
+	orgID := pathOrgID(r)
+
+	var req MergeRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.OwnerRole == "" {
+		req.OwnerRole = "admin"
+	}
+	if !slices.Contains(AssignableRoles, req.OwnerRole) {
+		writeValidationError(w, &ValidationError{Field: "owner_role", Message: ErrUnknownRole.Error()})
+		return
+	}
+
+	plan, err := s.planMerge(r.Context(), orgID, req.Into, req.OwnerRole)
+	if err != nil {
+		switch err {
+		case ErrOrganizationNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case ErrMergeTargetNotFound:
+			writeValidationError(w, &ValidationError{Field: "into", Message: err.Error()})
+		default:
+			s.logger.ErrorContext(r.Context(), "failed to plan organization merge", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if req.DryRun {
+		json.NewEncoder(w).Encode(MergeResponse{Plan: plan})
+		return
+	}
+	if len(plan.Problems) > 0 {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(MergeResponse{Plan: plan})
+		return
+	}
+
+	admin, _ := GetUserFromContext(r.Context())
+	job, err := s.jobs.Enqueue(r.Context(), mergeOrganizationsJob, mergePayload{
+		SourceID:    orgID,
+		TargetID:    req.Into,
+		OwnerRole:   req.OwnerRole,
+		RequestedBy: &admin.ID,
+	}, time.Time{})
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to enqueue organization merge", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	s.recordAudit(r, "organization.merge_started", orgID, orgID.String(), AuditMetadata{
+		"merge_target_id": req.Into.String(),
+		"job_id":          job.ID.String(),
+	})
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(MergeResponse{Plan: plan, Job: job})
+}
+
+// handleAdminDeleteUser soft-deletes a sub-account
+func (s *Server) handleAdminDeleteUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		http.Error(w, "Invalid user ID format", http.StatusBadRequest)
+		return
+	}
+
+	user, err := s.store.GetUser(r.Context(), userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, ErrUserNotFound.Error(), http.StatusNotFound)
+			return
+		}
+		s.logger.ErrorContext(r.Context(), "failed to get user", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.store.DeleteUser(r.Context(), userID); err != nil {
+		switch err {
+		case ErrUserNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case ErrDeleteOwner:
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			s.logger.ErrorContext(r.Context(), "failed to delete user", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	s.recordAudit(r, "user.deleted", user.OrganizationID, userID.String(), nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleAdminListJobs(w http.ResponseWriter, r *http.Request) {
+	limit, offset, ok := parsePagination(r)
+	if !ok {
+		http.Error(w, "Invalid pagination parameters", http.StatusBadRequest)
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	if status != "" && !slices.Contains(JobStatuses, status) {
+		http.Error(w, "Invalid status parameter", http.StatusBadRequest)
+		return
+	}
+
+	jobs, total, err := s.store.ListJobs(r.Context(), status, limit, offset)
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to list jobs", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set(TotalCountHeader, strconv.Itoa(total))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(jobs)
+}
+
+func (s *Server) handleAdminGetJob(w http.ResponseWriter, r *http.Request) {
+	jobID, err := uuid.Parse(r.PathValue("jobID"))
+	if err != nil {
+		http.Error(w, "Invalid job ID format", http.StatusBadRequest)
+		return
+	}
+
+	job, err := s.store.GetJob(r.Context(), jobID)
+	if err != nil {
+		switch err {
+		case ErrJobNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			s.logger.ErrorContext(r.Context(), "failed to get job", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// handleAdminRetryJob requeues a dead job once whatever made it fail is fixed
+func (s *Server) handleAdminRetryJob(w http.ResponseWriter, r *http.Request) {
+	jobID, err := uuid.Parse(r.PathValue("jobID"))
+	if err != nil {
+		http.Error(w, "Invalid job ID format", http.StatusBadRequest)
+		return
+	}
+
+	job, err := s.store.RetryJob(r.Context(), jobID)
+	if err != nil {
+		switch err {
+		case ErrJobNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case ErrJobNotDead:
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			s.logger.ErrorContext(r.Context(), "failed to retry job", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	s.recordAudit(r, "job.retried", uuid.Nil, jobID.String(), AuditMetadata{"kind": job.Kind})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+func (s *Server) handleAdminStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := s.store.GetPlatformStats(r.Context())
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to get platform stats", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+func (s *Server) handleAdminVerifyAudit(w http.ResponseWriter, r *http.Request) {
+	result, err := s.audit.Verify(r.Context())
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to verify audit log", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !result.Valid {
+		s.logger.WarnContext(r.Context(), "audit log verification failed",
+			"first_invalid_event_id", result.FirstInvalidEventID,
+			"error", result.Error,
+		)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminSearch(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	srv, err := NewServer(store, newTestConfig(t))
+	require.NoError(t, err)
+
+	slug := "acme"
+	acme := &Organization{ID: uuid.New(), Name: "Acme Corporation", Slug: &slug, SubscriptionTier: DefaultSubscriptionTier, Version: 1}
+	owner := &User{ID: uuid.New(), Email: "owner@acme.test", Name: "Wile E. Coyote", OrganizationID: acme.ID, Role: "owner", Permissions: Permissions{"admin": true}}
+	acme.OwnerID = owner.ID
+	require.NoError(t, store.CreateOrganizationWithOwner(ctx, acme, owner))
+	acmeLabs, err := store.CreateOrganization(ctx, "Acme Labs", "owner@labs.test", "Labs Owner")
+	require.NoError(t, err)
+	ownerToken, err := srv.tokenManager.GenerateToken(owner)
+	require.NoError(t, err)
+
+	operators, err := store.CreateOrganization(ctx, "Operators", "ops@example.com", "Ops")
+	require.NoError(t, err)
+	store.users[operators.OwnerID].Permissions[string(PermPlatformAdmin)] = true
+	admin, err := store.GetUser(ctx, operators.OwnerID)
+	require.NoError(t, err)
+	adminToken, err := srv.tokenManager.GenerateToken(admin)
+	require.NoError(t, err)
+
+	search := func(token, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/admin/search?q="+url.QueryEscape(query), nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return w
+	}
+	results := func(query string) AdminSearchResults {
+		w := search(adminToken, query)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var results AdminSearchResults
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&results))
+		return results
+	}
+
+	require.Equal(t, http.StatusForbidden, search(ownerToken, "acme").Code)
+	require.Equal(t, http.StatusBadRequest, search(adminToken, " ").Code)
+
+	found := results("acme")
+	require.Len(t, found.Organizations, 2)
+	require.Equal(t, acme.ID, found.Organizations[0].ID, "the exact slug comes first")
+	require.Equal(t, acmeLabs.ID, found.Organizations[1].ID)
+	require.Len(t, found.Users, 1)
+	require.Equal(t, owner.ID, found.Users[0].ID)
+
+	found = results("coyote")
+	require.Len(t, found.Users, 1)
+	require.Empty(t, found.Organizations)
+
+	found = results(owner.ID.String())
+	require.Len(t, found.Users, 1)
+	require.Empty(t, found.Organizations)
+	found = results(acmeLabs.ID.String())
+	require.Empty(t, found.Users)
+	require.Len(t, found.Organizations, 1)
+
+	found = results(uuid.NewString())
+	require.Empty(t, found.Users)
+	require.Empty(t, found.Organizations)
+}
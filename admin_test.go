@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePagination(t *testing.T) {
+	tests := []struct {
+		name           string
+		query          string
+		expectedLimit  int
+		expectedOffset int
+		expectedOK     bool
+	}{
+		{name: "Defaults", query: "", expectedLimit: defaultPageSize, expectedOffset: 0, expectedOK: true},
+		{name: "Explicit values", query: "limit=10&offset=20", expectedLimit: 10, expectedOffset: 20, expectedOK: true},
+		{name: "Limit capped", query: "limit=100000", expectedLimit: maxPageSize, expectedOffset: 0, expectedOK: true},
+		{name: "Zero limit", query: "limit=0", expectedOK: false},
+		{name: "Negative offset", query: "offset=-1", expectedOK: false},
+		{name: "Non-numeric limit", query: "limit=abc", expectedOK: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/admin/organizations?"+tc.query, nil)
+			limit, offset, ok := parsePagination(req)
+			require.Equal(t, tc.expectedOK, ok)
+			if tc.expectedOK {
+				require.Equal(t, tc.expectedLimit, limit)
+				require.Equal(t, tc.expectedOffset, offset)
+			}
+		})
+	}
+}
+
+func TestAdminAPI(t *testing.T) {
+	suite := setupIntegrationTest(t)
+	defer suite.cleanupDB.teardown(t)
+
+	// Create a platform admin in its own organization
+	_, err := suite.db.CreateOrganization(context.Background(), "Platform Ops", "ops@platform.test", "Platform Operator")
+	require.NoError(t, err)
+
+	admin, err := suite.db.GetUserByEmail(context.Background(), "ops@platform.test")
+	require.NoError(t, err)
+
+	_, err = suite.db.ExecContext(context.Background(), `
+		UPDATE users SET permissions = $1 WHERE id = $2
+	`, Permissions{string(PermPlatformAdmin): true}, admin.ID)
+	require.NoError(t, err)
+
+	adminToken, err := suite.server.tokenManager.GenerateToken(admin)
+	require.NoError(t, err)
+	originalToken := suite.token
+
+	t.Run("Non-admin is forbidden", func(t *testing.T) {
+		w := suite.makeRequest(t, http.MethodGet, "/admin/stats", nil)
+		require.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("List organizations", func(t *testing.T) {
+		suite.token = adminToken
+		defer func() { suite.token = originalToken }()
+
+		w := suite.makeRequest(t, http.MethodGet, "/admin/organizations", nil)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var orgs []Organization
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&orgs))
+		require.GreaterOrEqual(t, len(orgs), 2)
+	})
+
+	t.Run("Search users across organizations", func(t *testing.T) {
+		suite.token = adminToken
+		defer func() { suite.token = originalToken }()
+
+		w := suite.makeRequest(t, http.MethodGet, "/admin/users?q=initial", nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "1", w.Header().Get(TotalCountHeader))
+
+		var users []User
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&users))
+		require.Len(t, users, 1)
+		require.Equal(t, suite.initialUser.Email, users[0].Email)
+	})
+
+	t.Run("Search the platform", func(t *testing.T) {
+		suite.token = adminToken
+		defer func() { suite.token = originalToken }()
+
+		w := suite.makeRequest(t, http.MethodGet, "/admin/search?q="+suite.initialUser.ID.String(), nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		var results AdminSearchResults
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&results))
+		require.Len(t, results.Users, 1)
+		require.Empty(t, results.Organizations)
+
+		var searches int
+		require.NoError(t, suite.db.GetContext(context.Background(), &searches, `
+			SELECT COUNT(*) FROM audit_events WHERE action = 'platform.searched' AND actor_id = $1
+		`, admin.ID))
+		require.Equal(t, 1, searches, "every search is audited")
+	})
+
+	t.Run("Adjust tier", func(t *testing.T) {
+		suite.token = adminToken
+		defer func() { suite.token = originalToken }()
+
+		path := fmt.Sprintf("/admin/organizations/%s/tier", suite.initialOrg.ID)
+		ifMatch := http.Header{"If-Match": {versionETag(1)}}
+
+		w := suite.makeRequest(t, http.MethodPut, path, UpdateTierRequest{SubscriptionTier: "pro"})
+		require.Equal(t, http.StatusPreconditionRequired, w.Code)
+
+		w = suite.makeRequestWithHeader(t, http.MethodPut, path, UpdateTierRequest{SubscriptionTier: "pro"}, ifMatch)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, versionETag(2), w.Header().Get("ETag"))
+
+		var org Organization
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&org))
+		require.Equal(t, "pro", org.SubscriptionTier)
+		require.Equal(t, 25, org.MaxSubAccounts)
+		require.Equal(t, 2, org.Version)
+
+		// A second admin working from the old version is refused
+		w = suite.makeRequestWithHeader(t, http.MethodPut, path, UpdateTierRequest{SubscriptionTier: "enterprise"}, ifMatch)
+		require.Equal(t, http.StatusConflict, w.Code)
+
+		w = suite.makeRequestWithHeader(t, http.MethodPut, path, UpdateTierRequest{SubscriptionTier: "platinum"},
+			http.Header{"If-Match": {versionETag(org.Version)}})
+		require.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = suite.makeRequestWithHeader(t, http.MethodPut, path, UpdateTierRequest{SubscriptionTier: "pro", SeatOverage: "unlimited"},
+			http.Header{"If-Match": {versionETag(org.Version)}})
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("Override retention", func(t *testing.T) {
+		suite.token = adminToken
+		defer func() { suite.token = originalToken }()
+
+		path := fmt.Sprintf("/admin/organizations/%s/retention", suite.initialOrg.ID)
+		w := suite.makeRequest(t, http.MethodGet, path, nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.JSONEq(t, `{}`, w.Body.String())
+		etag := w.Header().Get("ETag")
+
+		sevenYears := Duration(7 * 365 * 24 * time.Hour)
+		override := RetentionOverride{AuditEvents: &sevenYears}
+		w = suite.makeRequestWithHeader(t, http.MethodPut, path, override, http.Header{"If-Match": {etag}})
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NotEqual(t, etag, w.Header().Get("ETag"))
+
+		overrides, err := suite.db.GetRetentionOverrides(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, override, overrides[suite.initialOrg.ID])
+
+		negative := Duration(-time.Hour)
+		w = suite.makeRequestWithHeader(t, http.MethodPut, path, RetentionOverride{RefreshTokens: &negative},
+			http.Header{"If-Match": {w.Header().Get("ETag")}})
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("Suspend and reinstate organization", func(t *testing.T) {
+		suite.token = adminToken
+		w := suite.makeRequest(t, http.MethodPost,
+			fmt.Sprintf("/admin/organizations/%s/suspend", suite.initialOrg.ID), nil)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		// Members of the suspended organization are locked out
+		suite.token = originalToken
+		w = suite.makeRequest(t, http.MethodGet,
+			fmt.Sprintf("/organizations/%s", suite.initialOrg.ID), nil)
+		require.Equal(t, http.StatusForbidden, w.Code)
+
+		suite.token = adminToken
+		w = suite.makeRequest(t, http.MethodPost,
+			fmt.Sprintf("/admin/organizations/%s/unsuspend", suite.initialOrg.ID), nil)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		suite.token = originalToken
+		w = suite.makeRequest(t, http.MethodGet,
+			fmt.Sprintf("/organizations/%s", suite.initialOrg.ID), nil)
+		require.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("Suspend unknown organization", func(t *testing.T) {
+		suite.token = adminToken
+		defer func() { suite.token = originalToken }()
+
+		w := suite.makeRequest(t, http.MethodPost,
+			fmt.Sprintf("/admin/organizations/%s/suspend", uuid.New()), nil)
+		require.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("Delete organization", func(t *testing.T) {
+		suite.token = adminToken
+		defer func() { suite.token = originalToken }()
+
+		org, err := suite.db.CreateOrganization(context.Background(), "Doomed", "owner@doomed.test", "Doomed Owner")
+		require.NoError(t, err)
+
+		w := suite.makeRequest(t, http.MethodDelete, fmt.Sprintf("/admin/users/%s", org.OwnerID), nil)
+		require.Equal(t, http.StatusConflict, w.Code, "owners go with their organization")
+
+		w = suite.makeRequest(t, http.MethodDelete, fmt.Sprintf("/admin/organizations/%s", org.ID), nil)
+		require.Equal(t, http.StatusNoContent, w.Code)
+
+		w = suite.makeRequest(t, http.MethodDelete, fmt.Sprintf("/admin/organizations/%s", org.ID), nil)
+		require.Equal(t, http.StatusNotFound, w.Code)
+
+		w = suite.makeRequest(t, http.MethodGet, "/admin/users?q=doomed", nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		var users []User
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&users))
+		require.Empty(t, users)
+
+		w = suite.makeRequest(t, http.MethodGet, "/admin/users?q=doomed&include_deleted=true", nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&users))
+		require.Len(t, users, 1)
+		require.NotNil(t, users[0].DeletedAt)
+
+		w = suite.makeRequest(t, http.MethodGet, "/admin/organizations?include_deleted=maybe", nil)
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("Platform stats", func(t *testing.T) {
+		suite.token = adminToken
+		defer func() { suite.token = originalToken }()
+
+		w := suite.makeRequest(t, http.MethodGet, "/admin/stats", nil)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var stats PlatformStats
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&stats))
+		require.GreaterOrEqual(t, stats.Organizations, 2)
+		require.GreaterOrEqual(t, stats.Users, 2)
+		require.Equal(t, 0, stats.SuspendedOrganizations)
+		require.NotZero(t, stats.OrganizationsByTier["free"])
+	})
+
+}
@@ -0,0 +1,485 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	// auditGenesisHash is the previous hash of the first event in the chain
+	auditGenesisHash = "0000000000000000000000000000000000000000000000000000000000000000"
+
+	// auditChainLockKey serializes writers so every event links to its true predecessor
+	auditChainLockKey = 0x68756163 // "huac"
+
+	auditVerifyBatchSize = 1000
+)
+
+type AuditMetadata map[string]string
+
+// Value implements the driver.Valuer interface for AuditMetadata
+func (m AuditMetadata) Value() (driver.Value, error) {
+	if m == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(m)
+}
+
+// Scan implements the sql.Scanner interface for AuditMetadata
+func (m *AuditMetadata) Scan(value interface{}) error {
+	if value == nil {
+		*m = make(AuditMetadata)
+		return nil
+	}
+	return json.Unmarshal(value.([]byte), m)
+}
+
+// AuditEvent is a single entry in the hash-chained audit log
+type AuditEvent struct {
+	ID             int64         `db:"id" json:"id"`
+	OrganizationID *uuid.UUID    `db:"organization_id" json:"organization_id,omitempty"`
+	ActorID        *uuid.UUID    `db:"actor_id" json:"actor_id,omitempty"`
+	Action         string        `db:"action" json:"action"`
+	TargetID       string        `db:"target_id" json:"target_id,omitempty"`
+	Metadata       AuditMetadata `db:"metadata" json:"metadata,omitempty"`
+	CreatedAt      time.Time     `db:"created_at" json:"created_at"`
+	PrevHash       string        `db:"prev_hash" json:"prev_hash"`
+	Hash           string        `db:"hash" json:"hash"`
+}
+
+// computeHash returns the SHA-256 over the event's contents and its predecessor's hash
+func (e *AuditEvent) computeHash() string {
+	payload, _ := json.Marshal(struct {
+		PrevHash       string        `json:"prev_hash"`
+		OrganizationID *uuid.UUID    `json:"organization_id"`
+		ActorID        *uuid.UUID    `json:"actor_id"`
+		Action         string        `json:"action"`
+		TargetID       string        `json:"target_id"`
+		Metadata       AuditMetadata `json:"metadata"`
+		CreatedAt      string        `json:"created_at"`
+	}{
+		PrevHash:       e.PrevHash,
+		OrganizationID: e.OrganizationID,
+		ActorID:        e.ActorID,
+		Action:         e.Action,
+		TargetID:       e.TargetID,
+		Metadata:       e.Metadata,
+		CreatedAt:      e.CreatedAt.UTC().Format(time.RFC3339Nano),
+	})
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+// AuditAnchor is a signed record of the last event removed by retention.
+// Verification of the remaining chain starts from its hash.
+type AuditAnchor struct {
+	ID        int64     `db:"id" json:"id"`
+	EventID   int64     `db:"event_id" json:"event_id"`
+	Hash      string    `db:"hash" json:"hash"`
+	Signature string    `db:"signature" json:"signature"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+// AuditCheckpoint is a signed attestation of the chain head at a given event
+type AuditCheckpoint struct {
+	ID        int64     `db:"id" json:"id"`
+	EventID   int64     `db:"event_id" json:"event_id"`
+	Hash      string    `db:"hash" json:"hash"`
+	Signature string    `db:"signature" json:"signature"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+// AuditConfig holds configuration for the audit log
+type AuditConfig struct {
+	SigningKey         []byte
+	CheckpointInterval int64
+}
+
+// NewAuditConfig creates a new audit configuration from settings
+func NewAuditConfig(settings Settings) *AuditConfig {
+	key := []byte(settings("AUDIT_SIGNING_KEY"))
+	if len(key) == 0 {
+		// Generate a random key for development; checkpoints signed with it
+		// cannot be verified after a restart
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			panic("failed to generate audit signing key: " + err.Error())
+		}
+	}
+
+	interval, err := strconv.ParseInt(settings.get("AUDIT_CHECKPOINT_INTERVAL", "100"), 10, 64)
+	if err != nil || interval < 0 {
+		interval = 100
+	}
+
+	return &AuditConfig{
+		SigningKey:         key,
+		CheckpointInterval: interval,
+	}
+}
+
+type AuditLog struct {
+	db       *DB
+	config   *AuditConfig
+	exporter *AuditExporter // optional; streams committed events to external sinks
+}
+
+func NewAuditLog(db *DB, config *AuditConfig) *AuditLog {
+	return &AuditLog{db: db, config: config}
+}
+
+// signCheckpoint returns the HMAC-SHA256 signature for a checkpoint
+func (a *AuditLog) signCheckpoint(eventID int64, hash string) string {
+	mac := hmac.New(sha256.New, a.config.SigningKey)
+	fmt.Fprintf(mac, "%d:%s", eventID, hash)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signAnchor returns the HMAC-SHA256 signature for an anchor. It differs
+// from a checkpoint signature so that a copied checkpoint cannot pass as an
+// anchor and hide the events before it.
+func (a *AuditLog) signAnchor(eventID int64, hash string) string {
+	mac := hmac.New(sha256.New, a.config.SigningKey)
+	fmt.Fprintf(mac, "anchor:%d:%s", eventID, hash)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// enabled reports whether there is an audit table to write to
+func (a *AuditLog) enabled() bool {
+	return a != nil && a.db != nil
+}
+
+// Record appends an event to the chain, writing a signed checkpoint every
+// CheckpointInterval events
+func (a *AuditLog) Record(ctx context.Context, event *AuditEvent) error {
+	// Servers backed by an in-memory store have no audit table
+	if a.db == nil {
+		return nil
+	}
+
+	err := a.db.transact(ctx, func(tx *sqlx.Tx) error {
+		if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, auditChainLockKey); err != nil {
+			return err
+		}
+
+		// Retention never removes the newest event, but an anchor covers a
+		// chain whose events were all removed some other way
+		err := tx.GetContext(ctx, &event.PrevHash, `
+			SELECT hash FROM (
+				(SELECT id, hash FROM audit_events ORDER BY id DESC LIMIT 1)
+				UNION ALL
+				(SELECT event_id, hash FROM audit_anchors ORDER BY id DESC LIMIT 1)
+			) head
+			ORDER BY id DESC LIMIT 1
+		`)
+		if err == sql.ErrNoRows {
+			event.PrevHash = auditGenesisHash
+		} else if err != nil {
+			return err
+		}
+
+		if event.Metadata == nil {
+			event.Metadata = AuditMetadata{}
+		}
+		// Postgres stores microsecond precision; truncate so the hash survives a round trip
+		event.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+		event.Hash = event.computeHash()
+
+		err = tx.GetContext(ctx, &event.ID, `
+			INSERT INTO audit_events (organization_id, actor_id, action, target_id, metadata, created_at, prev_hash, hash)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			RETURNING id
+		`, event.OrganizationID, event.ActorID, event.Action, event.TargetID, event.Metadata,
+			event.CreatedAt, event.PrevHash, event.Hash)
+		if err != nil {
+			return err
+		}
+
+		if a.config.CheckpointInterval > 0 && event.ID%a.config.CheckpointInterval == 0 {
+			_, err = tx.ExecContext(ctx, `
+				INSERT INTO audit_checkpoints (event_id, hash, signature)
+				VALUES ($1, $2, $3)
+			`, event.ID, event.Hash, a.signCheckpoint(event.ID, event.Hash))
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if a.exporter != nil {
+		a.exporter.Enqueue(*event)
+	}
+	return nil
+}
+
+// Close flushes any events still buffered for export
+func (a *AuditLog) Close(ctx context.Context) error {
+	if a.exporter == nil {
+		return nil
+	}
+	return a.exporter.Close(ctx)
+}
+
+// AuditVerification reports the outcome of walking the audit chain
+type AuditVerification struct {
+	Valid               bool   `json:"valid"`
+	EventsChecked       int64  `json:"events_checked"`
+	CheckpointsChecked  int    `json:"checkpoints_checked"`
+	FirstInvalidEventID int64  `json:"first_invalid_event_id,omitempty"`
+	Error               string `json:"error,omitempty"`
+}
+
+// Verify walks the whole chain, recomputing every hash and checking each
+// checkpoint's signature against the event it attests to
+func (a *AuditLog) Verify(ctx context.Context) (*AuditVerification, error) {
+	if a.db == nil {
+		return nil, errors.New("audit log has no database")
+	}
+
+	var checkpoints []AuditCheckpoint
+	err := a.db.SelectContext(ctx, &checkpoints, `
+		SELECT id, event_id, hash, signature, created_at FROM audit_checkpoints
+	`)
+	if err != nil {
+		return nil, err
+	}
+	checkpointsByEvent := make(map[int64]AuditCheckpoint, len(checkpoints))
+	for _, cp := range checkpoints {
+		checkpointsByEvent[cp.EventID] = cp
+	}
+
+	result := &AuditVerification{Valid: true}
+	fail := func(eventID int64, format string, args ...interface{}) (*AuditVerification, error) {
+		result.Valid = false
+		result.FirstInvalidEventID = eventID
+		result.Error = fmt.Sprintf(format, args...)
+		return result, nil
+	}
+
+	prevHash := auditGenesisHash
+	var lastID int64
+
+	// Once retention has removed the start of the chain, it is verified
+	// from the last removed event
+	var anchor AuditAnchor
+	err = a.db.GetContext(ctx, &anchor, `
+		SELECT id, event_id, hash, signature, created_at FROM audit_anchors ORDER BY id DESC LIMIT 1
+	`)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return nil, err
+	case !hmac.Equal([]byte(anchor.Signature), []byte(a.signAnchor(anchor.EventID, strings.TrimSpace(anchor.Hash)))):
+		return fail(anchor.EventID, "anchor %d has an invalid signature", anchor.ID)
+	default:
+		prevHash = strings.TrimSpace(anchor.Hash)
+		lastID = anchor.EventID
+	}
+
+	for {
+		var events []AuditEvent
+		err := a.db.SelectContext(ctx, &events, `
+			SELECT id, organization_id, actor_id, action, target_id, metadata, created_at, prev_hash, hash
+			FROM audit_events
+			WHERE id > $1
+			ORDER BY id
+			LIMIT $2
+		`, lastID, auditVerifyBatchSize)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range events {
+			event := &events[i]
+			if strings.TrimSpace(event.PrevHash) != prevHash {
+				return fail(event.ID, "event %d does not link to its predecessor", event.ID)
+			}
+			if event.computeHash() != strings.TrimSpace(event.Hash) {
+				return fail(event.ID, "event %d hash mismatch", event.ID)
+			}
+
+			if cp, ok := checkpointsByEvent[event.ID]; ok {
+				if cp.Hash != event.Hash {
+					return fail(event.ID, "checkpoint %d does not match event %d", cp.ID, event.ID)
+				}
+				if !hmac.Equal([]byte(cp.Signature), []byte(a.signCheckpoint(cp.EventID, cp.Hash))) {
+					return fail(event.ID, "checkpoint %d has an invalid signature", cp.ID)
+				}
+				result.CheckpointsChecked++
+				delete(checkpointsByEvent, event.ID)
+			}
+
+			prevHash = event.Hash
+			lastID = event.ID
+			result.EventsChecked++
+		}
+
+		if len(events) < auditVerifyBatchSize {
+			break
+		}
+	}
+
+	// A checkpoint without its event means events were deleted
+	for _, cp := range checkpointsByEvent {
+		return fail(cp.EventID, "checkpoint %d references missing event %d", cp.ID, cp.EventID)
+	}
+
+	return result, nil
+}
+
+// firstRetainedEvent returns the ID of the oldest event in scope recorded at
+// or after cutoff, or of the oldest event in scope if cutoff is zero. It
+// returns 0 if scope has no events to keep. Platform events, which belong to
+// no organization, count as outside every organization.
+func (a *AuditLog) firstRetainedEvent(ctx context.Context, scope RetentionScope, cutoff time.Time) (int64, error) {
+	var id int64
+	err := a.db.GetContext(ctx, &id, `
+		SELECT COALESCE(MIN(id), 0) FROM audit_events
+		WHERE created_at >= $1
+		  AND (organization_id = $2
+		       OR ($2 = '00000000-0000-0000-0000-000000000000'
+		           AND (organization_id IS NULL OR organization_id <> ALL($3))))
+	`, cutoff, scope.OrgID, scopeExcept(scope))
+	return id, err
+}
+
+// Truncate removes the events before keepFrom, which must all be past
+// retention, and returns how many it removed. The chain is a single hash
+// chain across organizations, so it can only be shortened from the start:
+// an event stays until it and every event before it may go. The newest
+// event is always kept so that new events still link to the chain.
+func (a *AuditLog) Truncate(ctx context.Context, keepFrom int64) (int64, error) {
+	var removed int64
+	err := a.db.transact(ctx, func(tx *sqlx.Tx) error {
+		if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, auditChainLockKey); err != nil {
+			return err
+		}
+
+		var last struct {
+			ID   int64  `db:"id"`
+			Hash string `db:"hash"`
+		}
+		err := tx.GetContext(ctx, &last, `
+			SELECT id, hash FROM audit_events
+			WHERE id < $1 AND id < (SELECT MAX(id) FROM audit_events)
+			ORDER BY id DESC LIMIT 1
+		`, keepFrom)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO audit_anchors (event_id, hash, signature) VALUES ($1, $2, $3)
+		`, last.ID, last.Hash, a.signAnchor(last.ID, strings.TrimSpace(last.Hash)))
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM audit_checkpoints WHERE event_id <= $1`, last.ID); err != nil {
+			return err
+		}
+		result, err := tx.ExecContext(ctx, `DELETE FROM audit_events WHERE id <= $1`, last.ID)
+		if err != nil {
+			return err
+		}
+		removed, err = result.RowsAffected()
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
+
+// recordAudit appends an audit event for the current request, logging rather
+// than failing the request if the audit log cannot be written
+func (s *Server) recordAudit(r *http.Request, action string, orgID uuid.UUID, targetID string, metadata AuditMetadata) {
+	event := &AuditEvent{
+		Action:   action,
+		TargetID: targetID,
+		Metadata: metadata,
+	}
+	if orgID != uuid.Nil {
+		event.OrganizationID = &orgID
+	}
+	if user, err := GetUserFromContext(r.Context()); err == nil {
+		event.ActorID = &user.ID
+	}
+	if event.Metadata == nil {
+		event.Metadata = AuditMetadata{}
+	}
+	event.Metadata["remote_addr"] = r.RemoteAddr
+	if ip := GetClientIPFromContext(r.Context()); ip != "" {
+		event.Metadata["client_ip"] = ip
+	}
+	if id := GetRequestIDFromContext(r.Context()); id != "" {
+		event.Metadata["request_id"] = id
+	}
+	if grant := GetAccessGrantFromContext(r.Context()); grant != nil {
+		event.Metadata["access_grant_id"] = grant.ID.String()
+	}
+
+	s.recordEvent(r.Context(), event)
+}
+
+// recordEvent appends an audit event and passes it on to the event stream,
+// webhooks, the message bus and chat notifications. Background work records
+// its actions with it directly.
+func (s *Server) recordEvent(ctx context.Context, event *AuditEvent) {
+	if event.Metadata == nil {
+		event.Metadata = AuditMetadata{}
+	}
+	if err := s.audit.Record(ctx, event); err != nil {
+		s.logger.ErrorContext(ctx, "failed to record audit event", "action", event.Action, "error", err)
+	}
+	s.publishSecurityEvent(ctx, event)
+	s.dispatchWebhooks(ctx, event)
+	s.queueDomainEvent(ctx, event)
+	s.notifyChat(ctx, event)
+	s.quotas.Observe(event)
+}
+
+// OrganizationEvents returns orgID's most recent events, at most limit,
+// oldest first. Servers backed by an in-memory store have no audit table
+// and so no events.
+func (a *AuditLog) OrganizationEvents(ctx context.Context, orgID uuid.UUID, limit int) ([]AuditEvent, error) {
+	events := []AuditEvent{}
+	if !a.enabled() {
+		return events, nil
+	}
+
+	err := a.db.SelectContext(ctx, &events, `
+		SELECT id, organization_id, actor_id, action, target_id, metadata, created_at, prev_hash, hash
+		FROM (
+			SELECT * FROM audit_events
+			WHERE organization_id = $1
+			ORDER BY id DESC
+			LIMIT $2
+		) recent
+		ORDER BY id
+	`, orgID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
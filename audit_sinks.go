@@ -0,0 +1,400 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AuditSink delivers batches of audit events to an external system
+type AuditSink interface {
+	Name() string
+	Send(ctx context.Context, events []AuditEvent) error
+}
+
+// AuditExportConfig controls buffering and retries for audit export
+type AuditExportConfig struct {
+	BufferSize    int
+	BatchSize     int
+	FlushInterval time.Duration
+	MaxAttempts   int
+	RetryBackoff  time.Duration
+}
+
+func NewAuditExportConfig() *AuditExportConfig {
+	return &AuditExportConfig{
+		BufferSize:    1000,
+		BatchSize:     100,
+		FlushInterval: 5 * time.Second,
+		MaxAttempts:   5,
+		RetryBackoff:  500 * time.Millisecond,
+	}
+}
+
+// AuditExporter buffers recorded audit events and ships them to every
+// configured sink in batches, retrying failed deliveries with backoff
+type AuditExporter struct {
+	sinks   []AuditSink
+	config  *AuditExportConfig
+	logger  *slog.Logger
+	events  chan AuditEvent
+	done    chan struct{}
+	closeMu sync.Once
+	dropped atomic.Int64
+}
+
+func NewAuditExporter(sinks []AuditSink, config *AuditExportConfig, logger *slog.Logger) *AuditExporter {
+	e := &AuditExporter{
+		sinks:  sinks,
+		config: config,
+		logger: logger,
+		events: make(chan AuditEvent, config.BufferSize),
+		done:   make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+// Enqueue schedules an event for export without blocking. Events are
+// dropped, and counted, when the buffer is full.
+func (e *AuditExporter) Enqueue(event AuditEvent) {
+	select {
+	case e.events <- event:
+	default:
+		e.dropped.Add(1)
+		e.logger.Warn("audit export buffer full, dropping event", "event_id", event.ID)
+	}
+}
+
+// Dropped returns the number of events discarded because the buffer was full
+func (e *AuditExporter) Dropped() int64 {
+	return e.dropped.Load()
+}
+
+// Close stops accepting events and waits for buffered events to be delivered
+func (e *AuditExporter) Close(ctx context.Context) error {
+	e.closeMu.Do(func() { close(e.events) })
+	select {
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (e *AuditExporter) run() {
+	defer close(e.done)
+
+	ticker := time.NewTicker(e.config.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]AuditEvent, 0, e.config.BatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		e.deliver(batch)
+		batch = make([]AuditEvent, 0, e.config.BatchSize)
+	}
+
+	for {
+		select {
+		case event, ok := <-e.events:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, event)
+			if len(batch) >= e.config.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+func (e *AuditExporter) deliver(batch []AuditEvent) {
+	for _, sink := range e.sinks {
+		if err := e.sendWithRetry(sink, batch); err != nil {
+			e.logger.Error("failed to export audit events",
+				"sink", sink.Name(),
+				"events", len(batch),
+				"error", err,
+			)
+		}
+	}
+}
+
+func (e *AuditExporter) sendWithRetry(sink AuditSink, batch []AuditEvent) error {
+	var err error
+	for attempt := 0; attempt < e.config.MaxAttempts; attempt++ {
+		if attempt > 0 {
+			// Exponential backoff with full jitter
+			backoff := e.config.RetryBackoff << (attempt - 1)
+			time.Sleep(time.Duration(rand.Int64N(int64(backoff) + 1)))
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err = sink.Send(ctx, batch)
+		cancel()
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// NewAuditSinks builds a sink for every destination configured in settings
+func NewAuditSinks(settings Settings) ([]AuditSink, error) {
+	var sinks []AuditSink
+	httpClient := &http.Client{Timeout: 15 * time.Second}
+
+	if addr := settings("AUDIT_SYSLOG_ADDR"); addr != "" {
+		sink, err := NewSyslogAuditSink(addr)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+
+	if endpoint := settings("AUDIT_SPLUNK_URL"); endpoint != "" {
+		sinks = append(sinks, &SplunkAuditSink{
+			URL:    endpoint,
+			Token:  settings("AUDIT_SPLUNK_TOKEN"),
+			client: httpClient,
+		})
+	}
+
+	if apiKey := settings("AUDIT_DATADOG_API_KEY"); apiKey != "" {
+		sinks = append(sinks, &DatadogAuditSink{
+			URL:    settings.get("AUDIT_DATADOG_URL", "https://http-intake.logs.datadoghq.com/api/v2/logs"),
+			APIKey: apiKey,
+			client: httpClient,
+		})
+	}
+
+	if bucket := settings("AUDIT_S3_BUCKET"); bucket != "" {
+		sinks = append(sinks, &S3AuditSink{
+			Bucket:      bucket,
+			Region:      settings.get("AUDIT_S3_REGION", "us-east-1"),
+			Prefix:      settings.get("AUDIT_S3_PREFIX", "audit"),
+			Endpoint:    settings("AUDIT_S3_ENDPOINT"),
+			Credentials: awsCredentialsFromSettings(settings),
+			client:      httpClient,
+		})
+	}
+
+	return sinks, nil
+}
+
+// SyslogAuditSink writes RFC 5424 messages to a remote syslog collector
+type SyslogAuditSink struct {
+	network  string
+	addr     string
+	hostname string
+}
+
+// NewSyslogAuditSink parses addresses of the form udp://host:514 or tcp://host:601
+func NewSyslogAuditSink(rawAddr string) (*SyslogAuditSink, error) {
+	u, err := url.Parse(rawAddr)
+	if err != nil || (u.Scheme != "udp" && u.Scheme != "tcp") || u.Host == "" {
+		return nil, fmt.Errorf("invalid AUDIT_SYSLOG_ADDR %q: expected udp://host:port or tcp://host:port", rawAddr)
+	}
+	hostname, _ := os.Hostname()
+	return &SyslogAuditSink{network: u.Scheme, addr: u.Host, hostname: hostname}, nil
+}
+
+func (s *SyslogAuditSink) Name() string { return "syslog" }
+
+// formatMessage renders an event as an RFC 5424 message with facility
+// authpriv (10) and severity informational (6)
+func (s *SyslogAuditSink) formatMessage(event AuditEvent) ([]byte, error) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	const priority = 10*8 + 6
+	header := fmt.Sprintf("<%d>1 %s %s huachuca - %s - ",
+		priority, event.CreatedAt.UTC().Format(time.RFC3339Nano), s.hostname, event.Action)
+	return append([]byte(header), body...), nil
+}
+
+func (s *SyslogAuditSink) Send(ctx context.Context, events []AuditEvent) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, s.network, s.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	for _, event := range events {
+		msg, err := s.formatMessage(event)
+		if err != nil {
+			return err
+		}
+		if s.network == "tcp" {
+			// Octet-counting framing (RFC 6587)
+			msg = append([]byte(fmt.Sprintf("%d ", len(msg))), msg...)
+		}
+		if _, err := conn.Write(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// postJSON sends body and treats any non-2xx response as a retryable failure
+func postJSON(ctx context.Context, client *http.Client, endpoint string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s responded with status %d", endpoint, resp.StatusCode)
+	}
+	return nil
+}
+
+// SplunkAuditSink posts events to a Splunk HTTP Event Collector
+type SplunkAuditSink struct {
+	URL    string
+	Token  string
+	client *http.Client
+}
+
+func (s *SplunkAuditSink) Name() string { return "splunk" }
+
+func (s *SplunkAuditSink) Send(ctx context.Context, events []AuditEvent) error {
+	// HEC accepts concatenated event objects in a single request
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, event := range events {
+		err := enc.Encode(map[string]interface{}{
+			"time":       float64(event.CreatedAt.UnixMicro()) / 1e6,
+			"source":     "huachuca",
+			"sourcetype": "huachuca:audit",
+			"event":      event,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return postJSON(ctx, s.client, s.URL, body.Bytes(), map[string]string{
+		"Authorization": "Splunk " + s.Token,
+	})
+}
+
+// DatadogAuditSink posts events to the Datadog logs intake API
+type DatadogAuditSink struct {
+	URL    string
+	APIKey string
+	client *http.Client
+}
+
+func (s *DatadogAuditSink) Name() string { return "datadog" }
+
+func (s *DatadogAuditSink) Send(ctx context.Context, events []AuditEvent) error {
+	entries := make([]map[string]interface{}, 0, len(events))
+	for _, event := range events {
+		message, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, map[string]interface{}{
+			"ddsource": "huachuca",
+			"service":  "huachuca",
+			"ddtags":   "type:audit,action:" + event.Action,
+			"message":  string(message),
+		})
+	}
+	body, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, s.client, s.URL, body, map[string]string{
+		"DD-API-KEY": s.APIKey,
+	})
+}
+
+// S3AuditSink writes each batch as a JSON Lines object to an S3 bucket
+type S3AuditSink struct {
+	Bucket      string
+	Region      string
+	Prefix      string
+	Endpoint    string // optional, for S3-compatible stores; uses path-style addressing
+	Credentials awsCredentials
+	client      *http.Client
+}
+
+func (s *S3AuditSink) Name() string { return "s3" }
+
+// objectURL returns the URL for an object key
+func (s *S3AuditSink) objectURL(key string) string {
+	if s.Endpoint != "" {
+		return fmt.Sprintf("%s/%s/%s", strings.TrimSuffix(s.Endpoint, "/"), s.Bucket, key)
+	}
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", s.Bucket, s.Region, key)
+}
+
+func (s *S3AuditSink) Send(ctx context.Context, events []AuditEvent) error {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, event := range events {
+		if err := enc.Encode(event); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now().UTC()
+	key := fmt.Sprintf("%s/%s/%d-%s.jsonl",
+		strings.Trim(s.Prefix, "/"), now.Format("2006/01/02"), now.UnixNano(), uuid.NewString())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.objectURL(key), bytes.NewReader(body.Bytes()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if err := signAWSRequest(ctx, req, body.Bytes(), s.Credentials, s.Region, "s3", now); err != nil {
+		return err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("s3 put %s responded with status %d", key, resp.StatusCode)
+	}
+	return nil
+}
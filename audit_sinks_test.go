@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeAuditSink struct {
+	mu       sync.Mutex
+	failures int
+	batches  [][]AuditEvent
+}
+
+func (f *fakeAuditSink) Name() string { return "fake" }
+
+func (f *fakeAuditSink) Send(ctx context.Context, events []AuditEvent) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failures > 0 {
+		f.failures--
+		return errors.New("temporary failure")
+	}
+	f.batches = append(f.batches, append([]AuditEvent(nil), events...))
+	return nil
+}
+
+func (f *fakeAuditSink) delivered() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, b := range f.batches {
+		n += len(b)
+	}
+	return n
+}
+
+func TestAuditExporter(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	config := &AuditExportConfig{
+		BufferSize:    10,
+		BatchSize:     3,
+		FlushInterval: time.Hour,
+		MaxAttempts:   3,
+		RetryBackoff:  time.Millisecond,
+	}
+
+	t.Run("Batches and drains on close", func(t *testing.T) {
+		sink := &fakeAuditSink{}
+		exporter := NewAuditExporter([]AuditSink{sink}, config, logger)
+
+		for i := int64(1); i <= 7; i++ {
+			exporter.Enqueue(AuditEvent{ID: i, Action: "auth.login"})
+		}
+		require.NoError(t, exporter.Close(context.Background()))
+
+		require.Equal(t, 7, sink.delivered())
+		require.Len(t, sink.batches, 3)
+		require.Len(t, sink.batches[0], 3)
+	})
+
+	t.Run("Retries failed deliveries", func(t *testing.T) {
+		sink := &fakeAuditSink{failures: 2}
+		exporter := NewAuditExporter([]AuditSink{sink}, config, logger)
+
+		exporter.Enqueue(AuditEvent{ID: 1, Action: "auth.login"})
+		require.NoError(t, exporter.Close(context.Background()))
+		require.Equal(t, 1, sink.delivered())
+	})
+
+	t.Run("Drops events when the buffer is full", func(t *testing.T) {
+		blocked := make(chan struct{})
+		sink := &blockingAuditSink{release: blocked}
+		exporter := NewAuditExporter([]AuditSink{sink}, &AuditExportConfig{
+			BufferSize:    1,
+			BatchSize:     1,
+			FlushInterval: time.Hour,
+			MaxAttempts:   1,
+		}, logger)
+
+		for i := int64(1); i <= 10; i++ {
+			exporter.Enqueue(AuditEvent{ID: i})
+		}
+		require.Positive(t, exporter.Dropped())
+
+		close(blocked)
+		require.NoError(t, exporter.Close(context.Background()))
+	})
+}
+
+type blockingAuditSink struct {
+	release chan struct{}
+}
+
+func (b *blockingAuditSink) Name() string { return "blocking" }
+
+func (b *blockingAuditSink) Send(ctx context.Context, events []AuditEvent) error {
+	<-b.release
+	return nil
+}
+
+func TestAuditSinks(t *testing.T) {
+	events := []AuditEvent{
+		{ID: 1, Action: "organization.created", CreatedAt: time.Now()},
+		{ID: 2, Action: "user.added", CreatedAt: time.Now()},
+	}
+
+	t.Run("Splunk HEC", func(t *testing.T) {
+		var auth string
+		var lines int
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			auth = r.Header.Get("Authorization")
+			body, _ := io.ReadAll(r.Body)
+			lines = strings.Count(strings.TrimSpace(string(body)), "\n") + 1
+		}))
+		defer server.Close()
+
+		sink := &SplunkAuditSink{URL: server.URL, Token: "hec-token", client: server.Client()}
+		require.NoError(t, sink.Send(context.Background(), events))
+		require.Equal(t, "Splunk hec-token", auth)
+		require.Equal(t, 2, lines)
+	})
+
+	t.Run("Datadog", func(t *testing.T) {
+		var apiKey string
+		var entries []map[string]interface{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			apiKey = r.Header.Get("DD-API-KEY")
+			json.NewDecoder(r.Body).Decode(&entries)
+			w.WriteHeader(http.StatusAccepted)
+		}))
+		defer server.Close()
+
+		sink := &DatadogAuditSink{URL: server.URL, APIKey: "dd-key", client: server.Client()}
+		require.NoError(t, sink.Send(context.Background(), events))
+		require.Equal(t, "dd-key", apiKey)
+		require.Len(t, entries, 2)
+		require.Equal(t, "huachuca", entries[0]["ddsource"])
+	})
+
+	t.Run("HTTP errors are returned for retry", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		sink := &DatadogAuditSink{URL: server.URL, APIKey: "dd-key", client: server.Client()}
+		require.Error(t, sink.Send(context.Background(), events))
+	})
+
+	t.Run("S3", func(t *testing.T) {
+		var method, path, authHeader string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			method, path = r.Method, r.URL.Path
+			authHeader = r.Header.Get("Authorization")
+		}))
+		defer server.Close()
+
+		sink := &S3AuditSink{
+			Bucket:      "audit-bucket",
+			Region:      "us-west-2",
+			Prefix:      "huachuca",
+			Endpoint:    server.URL,
+			Credentials: awsCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret"},
+			client:      server.Client(),
+		}
+		require.NoError(t, sink.Send(context.Background(), events))
+		require.Equal(t, http.MethodPut, method)
+		require.True(t, strings.HasPrefix(path, "/audit-bucket/huachuca/"))
+		require.True(t, strings.HasSuffix(path, ".jsonl"))
+		require.True(t, strings.HasPrefix(authHeader, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/"))
+		require.Contains(t, authHeader, "/us-west-2/s3/aws4_request")
+	})
+
+	t.Run("Syslog over UDP", func(t *testing.T) {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer conn.Close()
+
+		sink, err := NewSyslogAuditSink("udp://" + conn.LocalAddr().String())
+		require.NoError(t, err)
+		require.NoError(t, sink.Send(context.Background(), events[:1]))
+
+		buf := make([]byte, 4096)
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(string(buf[:n]), "<86>1 "))
+		require.Contains(t, string(buf[:n]), "organization.created")
+	})
+
+	t.Run("Invalid syslog address", func(t *testing.T) {
+		_, err := NewSyslogAuditSink("localhost:514")
+		require.Error(t, err)
+	})
+}
+
+func TestSignAWSRequest(t *testing.T) {
+	creds := awsCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+	sign := func() *http.Request {
+		req := httptest.NewRequest(http.MethodPut, "https://bucket.s3.us-east-1.amazonaws.com/audit/key.jsonl", nil)
+		require.NoError(t, signAWSRequest(context.Background(), req, []byte("payload"), creds, "us-east-1", "s3", now))
+		return req
+	}
+
+	first, second := sign(), sign()
+	require.Equal(t, first.Header.Get("Authorization"), second.Header.Get("Authorization"))
+	require.Equal(t, "20150830T123600Z", first.Header.Get("X-Amz-Date"))
+	require.Equal(t, sha256Hex([]byte("payload")), first.Header.Get("X-Amz-Content-Sha256"))
+	require.Contains(t, first.Header.Get("Authorization"),
+		"Credential=AKIDEXAMPLE/20150830/us-east-1/s3/aws4_request, SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature=")
+}
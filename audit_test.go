@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditEventHash(t *testing.T) {
+	orgID := uuid.New()
+	event := &AuditEvent{
+		OrganizationID: &orgID,
+		Action:         "user.added",
+		TargetID:       uuid.New().String(),
+		Metadata:       AuditMetadata{"role": "sub_account", "remote_addr": "10.0.0.1:1234"},
+		CreatedAt:      time.Now(),
+		PrevHash:       auditGenesisHash,
+	}
+
+	hash := event.computeHash()
+	require.Len(t, hash, 64)
+	require.Equal(t, hash, event.computeHash(), "hash must be deterministic")
+
+	t.Run("Changing any field changes the hash", func(t *testing.T) {
+		tampered := *event
+		tampered.Action = "user.removed"
+		require.NotEqual(t, hash, tampered.computeHash())
+
+		tampered = *event
+		tampered.PrevHash = "f" + auditGenesisHash[1:]
+		require.NotEqual(t, hash, tampered.computeHash())
+
+		tampered = *event
+		tampered.Metadata = AuditMetadata{"role": "owner", "remote_addr": "10.0.0.1:1234"}
+		require.NotEqual(t, hash, tampered.computeHash())
+	})
+
+	t.Run("Checkpoint signatures depend on the key", func(t *testing.T) {
+		a := NewAuditLog(nil, &AuditConfig{SigningKey: []byte("key-one")})
+		b := NewAuditLog(nil, &AuditConfig{SigningKey: []byte("key-two")})
+
+		require.Equal(t, a.signCheckpoint(100, hash), a.signCheckpoint(100, hash))
+		require.NotEqual(t, a.signCheckpoint(100, hash), b.signCheckpoint(100, hash))
+		require.NotEqual(t, a.signCheckpoint(100, hash), a.signCheckpoint(200, hash))
+	})
+}
+
+func TestAuditLogChain(t *testing.T) {
+	testdb := setupTestDB(t)
+	defer testdb.teardown(t)
+
+	ctx := context.Background()
+	auditLog := NewAuditLog(testdb.DB, &AuditConfig{
+		SigningKey:         []byte("test-audit-signing-key"),
+		CheckpointInterval: 2,
+	})
+
+	orgID := uuid.New()
+	for _, action := range []string{"organization.created", "user.added", "user.added", "auth.login", "organization.tier_changed"} {
+		require.NoError(t, auditLog.Record(ctx, &AuditEvent{
+			OrganizationID: &orgID,
+			Action:         action,
+		}))
+	}
+
+	t.Run("Intact chain verifies", func(t *testing.T) {
+		result, err := auditLog.Verify(ctx)
+		require.NoError(t, err)
+		require.True(t, result.Valid, result.Error)
+		require.Equal(t, int64(5), result.EventsChecked)
+		require.Equal(t, 2, result.CheckpointsChecked)
+	})
+
+	t.Run("Wrong signing key fails checkpoints", func(t *testing.T) {
+		other := NewAuditLog(testdb.DB, &AuditConfig{SigningKey: []byte("another-key")})
+		result, err := other.Verify(ctx)
+		require.NoError(t, err)
+		require.False(t, result.Valid)
+	})
+
+	t.Run("Rewritten event is detected", func(t *testing.T) {
+		_, err := testdb.DB.ExecContext(ctx, `
+			UPDATE audit_events SET action = 'auth.logout' WHERE id = 4
+		`)
+		require.NoError(t, err)
+
+		result, err := auditLog.Verify(ctx)
+		require.NoError(t, err)
+		require.False(t, result.Valid)
+		require.Equal(t, int64(4), result.FirstInvalidEventID)
+	})
+
+	t.Run("Truncated chain verifies from its anchor", func(t *testing.T) {
+		// Truncating past the rewritten event leaves an intact chain
+		removed, err := auditLog.Truncate(ctx, 5)
+		require.NoError(t, err)
+		require.Equal(t, int64(4), removed)
+
+		result, err := auditLog.Verify(ctx)
+		require.NoError(t, err)
+		require.True(t, result.Valid, result.Error)
+		require.Equal(t, int64(1), result.EventsChecked)
+
+		// The newest event is always kept so the chain can continue
+		removed, err = auditLog.Truncate(ctx, 100)
+		require.NoError(t, err)
+		require.Zero(t, removed)
+
+		other := NewAuditLog(testdb.DB, &AuditConfig{SigningKey: []byte("another-key")})
+		result, err = other.Verify(ctx)
+		require.NoError(t, err)
+		require.False(t, result.Valid, "anchors are signed")
+
+		require.NoError(t, auditLog.Record(ctx, &AuditEvent{OrganizationID: &orgID, Action: "auth.logout"}))
+		result, err = auditLog.Verify(ctx)
+		require.NoError(t, err)
+		require.True(t, result.Valid, result.Error)
+		require.Equal(t, int64(2), result.EventsChecked)
+	})
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+)
+
+// Authentication event names. They are logged with log_type "auth", apart
+// from the request logs, and are stable so SIEM rules can match on them.
+const (
+	AuthEventLoginSucceeded     = "auth.login.succeeded"
+	AuthEventLoginFailed        = "auth.login.failed"
+	AuthEventTokenRefreshed     = "auth.token.refreshed"
+	AuthEventTokenRefreshFailed = "auth.token.refresh_failed"
+	AuthEventTokenRejected      = "auth.token.rejected"
+	AuthEventPermissionDenied   = "auth.permission.denied"
+)
+
+// Reasons an authentication event failed, logged as its reason
+const (
+	AuthReasonInvalidState       = "invalid_state"
+	AuthReasonExchangeFailed     = "exchange_failed"
+	AuthReasonInvalidLoginCode   = "invalid_login_code"
+	AuthReasonInvalidMFACode     = "invalid_mfa_code"
+	AuthReasonInvalidToken       = "invalid_token"
+	AuthReasonUserNotFound       = "user_not_found"
+	AuthReasonOrgSuspended       = "organization_suspended"
+	AuthReasonTokenNotFound      = "refresh_token_not_found"
+	AuthReasonTokenExpired       = "refresh_token_expired"
+	AuthReasonMissingPermission  = "missing_permission"
+	AuthReasonOtherOrganization  = "other_organization"
+	AuthReasonOutsideIPAllowlist = "outside_ip_allowlist"
+	AuthReasonGuestRestricted    = "guest_restricted"
+)
+
+// AuthLogger logs authentication outcomes as structured events. Every
+// event has the fields event, ip, and user_id and org_id when the user is
+// known; failures also have a reason. A nil *AuthLogger logs nothing.
+type AuthLogger struct {
+	logger *slog.Logger
+}
+
+func NewAuthLogger(logger *slog.Logger) *AuthLogger {
+	return &AuthLogger{logger: logger.With("log_type", "auth")}
+}
+
+// Log logs event for the request r made, as user if it is not nil. Events
+// with a reason are failures and are logged as warnings.
+func (l *AuthLogger) Log(r *http.Request, event string, user *User, reason string, attrs ...any) {
+	if l == nil {
+		return
+	}
+	level := slog.LevelInfo
+	fields := []any{"event", event, "ip", GetClientIPFromContext(r.Context())}
+	if user != nil {
+		fields = append(fields, "user_id", user.ID, "org_id", user.OrganizationID)
+	}
+	if reason != "" {
+		level = slog.LevelWarn
+		fields = append(fields, "reason", reason)
+	}
+	l.logger.Log(r.Context(), level, event, append(fields, attrs...)...)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthEvents(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	srv, err := NewServer(store, newTestConfig(t))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	srv.authLog = NewAuthLogger(slog.New(slog.NewJSONHandler(&buf, nil)))
+	srv.auth.events = srv.authLog
+
+	org, err := store.CreateOrganization(ctx, "Acme", "owner@acme.test", "Owner")
+	require.NoError(t, err)
+	owner, err := store.GetUser(ctx, org.OwnerID)
+	require.NoError(t, err)
+	token, err := srv.tokenManager.GenerateToken(owner)
+	require.NoError(t, err)
+	refreshToken, err := store.CreateRefreshToken(ctx, owner.ID, DefaultSessionTerms)
+	require.NoError(t, err)
+
+	// serve makes a request and returns the auth event it logged
+	serve := func(req *http.Request) map[string]any {
+		buf.Reset()
+		req.RemoteAddr = "203.0.113.7:1234"
+		srv.ServeHTTP(httptest.NewRecorder(), req)
+		var event map[string]any
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &event), buf.String())
+		require.Equal(t, "auth", event["log_type"])
+		require.Equal(t, "203.0.113.7", event["ip"])
+		return event
+	}
+	refresh := func(refreshToken string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/auth/refresh", strings.NewReader(`{"refresh_token":"`+refreshToken+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		addCSRFToken(t, srv, req)
+		return req
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/me", nil)
+	req.Header.Set("Authorization", "Bearer not-a-token")
+	event := serve(req)
+	require.Equal(t, AuthEventTokenRejected, event["event"])
+	require.Equal(t, AuthReasonInvalidToken, event["reason"])
+	require.Equal(t, "WARN", event["level"])
+
+	req = httptest.NewRequest(http.MethodGet, "/admin/log-level", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	event = serve(req)
+	require.Equal(t, AuthEventPermissionDenied, event["event"])
+	require.Equal(t, AuthReasonMissingPermission, event["reason"])
+	require.Equal(t, owner.ID.String(), event["user_id"])
+	require.Equal(t, org.ID.String(), event["org_id"])
+	require.Equal(t, "/admin/log-level", event["path"])
+
+	event = serve(refresh(refreshToken))
+	require.Equal(t, AuthEventTokenRefreshed, event["event"])
+	require.Equal(t, "INFO", event["level"])
+	require.Equal(t, owner.ID.String(), event["user_id"])
+	require.NotContains(t, event, "reason")
+
+	event = serve(refresh("unknown"))
+	require.Equal(t, AuthEventTokenRefreshFailed, event["event"])
+	require.Equal(t, AuthReasonTokenNotFound, event["reason"])
+	require.NotContains(t, event, "user_id")
+
+	var none *AuthLogger
+	none.Log(httptest.NewRequest(http.MethodGet, "/", nil), AuthEventLoginSucceeded, owner, "")
+}
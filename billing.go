@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrUnknownSeatOverage = errors.New("unknown seat overage policy")
+
+// Seat overage policies decide what happens when an organization adds more
+// sub-accounts than its paid seats
+const (
+	// SeatOverageBlock refuses the addition with ErrMaxSubAccounts
+	SeatOverageBlock = "block"
+	// SeatOverageAllow adds the member and bills the extra seats as overage
+	SeatOverageAllow = "allow"
+)
+
+// SeatOveragePolicies lists the valid seat overage policies
+var SeatOveragePolicies = []string{SeatOverageBlock, SeatOverageAllow}
+
+// SeatUsageRecord is emitted each time a billable seat is taken or freed.
+// Billing sums Change over a period, or reads Seats at its end.
+type SeatUsageRecord struct {
+	ID             uuid.UUID `db:"id" json:"id"`
+	OrganizationID uuid.UUID `db:"organization_id" json:"organization_id"`
+	UserID         uuid.UUID `db:"user_id" json:"user_id"`
+	Change         int       `db:"change" json:"change"`         // +1 or -1
+	Seats          int       `db:"seats" json:"seats"`           // seats in use after the change
+	PaidSeats      int       `db:"paid_seats" json:"paid_seats"` // the organization's paid seats at the time
+	RecordedAt     time.Time `db:"recorded_at" json:"recorded_at"`
+}
+
+// SeatUsage summarizes an organization's billable seats. Sub-accounts are
+// billable; owners are included in every tier.
+type SeatUsage struct {
+	OrganizationID   uuid.UUID         `json:"organization_id"`
+	SubscriptionTier string            `json:"subscription_tier"`
+	SeatOverage      string            `json:"seat_overage"`
+	PaidSeats        int               `json:"paid_seats"`
+	SeatsUsed        int               `json:"seats_used"`
+	OverageSeats     int               `json:"overage_seats"`
+	Records          []SeatUsageRecord `json:"records"` // newest first, paginated
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// recordSeatChange emits a seat usage record for a sub-account added to
+// (change 1) or removed from (change -1) an organization, counting the seats
+// as tx sees them
+func recordSeatChange(ctx context.Context, tx *sqlx.Tx, orgID, userID uuid.UUID, change int) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO seat_usage_records (id, organization_id, user_id, change, seats, paid_seats)
+		SELECT $1, o.id, $2, $3,
+			(SELECT COUNT(*) FROM users u
+			 WHERE u.organization_id = o.id AND u.role = 'sub_account' AND u.deleted_at IS NULL),
+			o.max_sub_accounts
+		FROM organizations o WHERE o.id = $4
+	`, uuid.New(), userID, change, orgID)
+	return err
+}
+
+// GetSeatUsage summarizes an organization's billable seats, leaving out the
+// usage records
+func (db *DB) GetSeatUsage(ctx context.Context, orgID uuid.UUID) (*SeatUsage, error) {
+	usage := &SeatUsage{OrganizationID: orgID, Records: []SeatUsageRecord{}}
+	err := db.tenantRead(ctx, orgID, func(q sqlx.QueryerContext) error {
+		return q.QueryRowxContext(ctx, `
+			SELECT
+				o.subscription_tier,
+				o.seat_overage,
+				o.max_sub_accounts,
+				(SELECT COUNT(*) FROM users u
+				 WHERE u.organization_id = o.id AND u.role = 'sub_account' AND u.deleted_at IS NULL)
+			FROM organizations o
+			WHERE o.id = $1 AND o.deleted_at IS NULL
+		`, orgID).Scan(&usage.SubscriptionTier, &usage.SeatOverage, &usage.PaidSeats, &usage.SeatsUsed)
+	})
+	if err == sql.ErrNoRows {
+		return nil, ErrOrganizationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	usage.OverageSeats = max(usage.SeatsUsed-usage.PaidSeats, 0)
+	return usage, nil
+}
+
+// ListSeatUsageRecords retrieves a page of an organization's seat usage
+// records, newest first
+func (db *DB) ListSeatUsageRecords(ctx context.Context, orgID uuid.UUID, limit, offset int) ([]SeatUsageRecord, int, error) {
+	var rows []struct {
+		SeatUsageRecord
+		Total int `db:"total"`
+	}
+	err := db.tenantRead(ctx, orgID, func(q sqlx.QueryerContext) error {
+		return sqlx.SelectContext(ctx, q, &rows, `
+			SELECT id, organization_id, user_id, change, seats, paid_seats, recorded_at,
+				COUNT(*) OVER () AS total
+			FROM seat_usage_records
+			WHERE organization_id = $1
+			ORDER BY recorded_at DESC, id
+			LIMIT $2 OFFSET $3
+		`, orgID, limit, offset)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	records := make([]SeatUsageRecord, len(rows))
+	total := 0
+	for i, row := range rows {
+		records[i], total = row.SeatUsageRecord, row.Total
+	}
+	return records, total, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeatBilling(t *testing.T) {
+	ctx := context.Background()
+
+	store := NewMemoryStore()
+	srv, err := NewServer(store, newTestConfig(t))
+	require.NoError(t, err)
+
+	org, err := store.CreateOrganization(ctx, "Acme", "owner@acme.test", "Owner")
+	require.NoError(t, err)
+	require.Equal(t, SeatOverageBlock, org.SeatOverage)
+	org, err = store.UpdateOrganizationTier(ctx, org.ID, org.Version, "free", 1, "")
+	require.NoError(t, err)
+	owner, err := store.GetUser(ctx, org.OwnerID)
+	require.NoError(t, err)
+	token, err := srv.tokenManager.GenerateToken(owner)
+	require.NoError(t, err)
+
+	addUser := func(email string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/organizations/"+org.ID.String()+"/users",
+			strings.NewReader(`{"email":"`+email+`","name":"Member"}`))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		addCSRFToken(t, srv, req)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return w
+	}
+	getUsage := func(query string) (*SeatUsage, *httptest.ResponseRecorder) {
+		req := httptest.NewRequest(http.MethodGet, "/organizations/"+org.ID.String()+"/billing/usage"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var usage SeatUsage
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&usage))
+		return &usage, w
+	}
+
+	require.Equal(t, http.StatusOK, addUser("one@acme.test").Code)
+
+	t.Run("Blocks additions past the paid seats", func(t *testing.T) {
+		require.Equal(t, http.StatusForbidden, addUser("two@acme.test").Code)
+
+		usage, _ := getUsage("")
+		require.Equal(t, 1, usage.PaidSeats)
+		require.Equal(t, 1, usage.SeatsUsed)
+		require.Equal(t, 0, usage.OverageSeats)
+		require.Len(t, usage.Records, 1)
+	})
+
+	t.Run("Allows overage when the policy does", func(t *testing.T) {
+		_, err := store.UpdateOrganizationTier(ctx, org.ID, org.Version, "free", 1, SeatOverageAllow)
+		require.NoError(t, err)
+
+		w := addUser("two@acme.test")
+		require.Equal(t, http.StatusOK, w.Code)
+		var member User
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&member))
+
+		usage, _ := getUsage("")
+		require.Equal(t, SeatOverageAllow, usage.SeatOverage)
+		require.Equal(t, 2, usage.SeatsUsed)
+		require.Equal(t, 1, usage.OverageSeats)
+		require.Equal(t, member.ID, usage.Records[0].UserID)
+		require.Equal(t, 1, usage.Records[0].Change)
+		require.Equal(t, 2, usage.Records[0].Seats)
+		require.Equal(t, 1, usage.Records[0].PaidSeats)
+
+		// Freeing a seat is recorded too
+		require.NoError(t, store.DeleteUser(ctx, member.ID))
+		usage, w = getUsage("?limit=1")
+		require.Equal(t, 1, usage.SeatsUsed)
+		require.Equal(t, "3", w.Header().Get(TotalCountHeader))
+		require.Len(t, usage.Records, 1)
+		require.Equal(t, -1, usage.Records[0].Change)
+		require.Equal(t, 1, usage.Records[0].Seats)
+	})
+
+	t.Run("Members cannot see billing", func(t *testing.T) {
+		member, err := store.GetUserByEmail(ctx, "one@acme.test")
+		require.NoError(t, err)
+		memberToken, err := srv.tokenManager.GenerateToken(member)
+		require.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodGet, "/organizations/"+org.ID.String()+"/billing/usage", nil)
+		req.Header.Set("Authorization", "Bearer "+memberToken)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		require.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	_, err = store.UpdateOrganizationTier(ctx, org.ID, org.Version, "free", 1, "unlimited")
+	require.ErrorIs(t, err, ErrUnknownSeatOverage)
+}
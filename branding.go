@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// MaxProductNameLength bounds a branded product name, in characters
+const MaxProductNameLength = 100
+
+// maxLogoURLLength bounds a branded logo's URL
+const maxLogoURLLength = 2048
+
+var primaryColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// Branding is how an organization's emails and hosted pages present it.
+// Every field is optional; the service's own look fills in for those left
+// out.
+type Branding struct {
+	// LogoURL is an https URL of the logo shown atop emails and pages
+	LogoURL string `json:"logo_url,omitempty"`
+	// PrimaryColor is the accent color, as #rrggbb
+	PrimaryColor string `json:"primary_color,omitempty"`
+	// ProductName replaces the service's name, including as the display
+	// name emails are sent from
+	ProductName string `json:"product_name,omitempty"`
+}
+
+func validateBranding(b *Branding) error {
+	if b == nil {
+		return nil
+	}
+	var errs []error
+	if b.LogoURL != "" {
+		u, err := url.Parse(b.LogoURL)
+		if err != nil || u.Scheme != "https" || u.Host == "" || u.User != nil || len(b.LogoURL) > maxLogoURLLength {
+			errs = append(errs, &ValidationError{Field: "branding.logo_url",
+				Message: fmt.Sprintf("invalid URL %q: expected an https URL of at most %d characters", b.LogoURL, maxLogoURLLength)})
+		}
+	}
+	if b.PrimaryColor != "" && !primaryColorPattern.MatchString(b.PrimaryColor) {
+		errs = append(errs, &ValidationError{Field: "branding.primary_color",
+			Message: fmt.Sprintf("invalid color %q: expected #rrggbb, such as #1a73e8", b.PrimaryColor)})
+	}
+	if n := utf8.RuneCountInString(b.ProductName); n > MaxProductNameLength || !printable(b.ProductName) {
+		errs = append(errs, &ValidationError{Field: "branding.product_name",
+			Message: fmt.Sprintf("must be at most %d printable characters", MaxProductNameLength)})
+	}
+	return joinValidationErrors(errs...)
+}
+
+// printable reports whether s is valid UTF-8 without control characters
+func printable(s string) bool {
+	if !utf8.ValidString(s) {
+		return false
+	}
+	for _, c := range s {
+		if unicode.IsControl(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// organizationBranding returns orgID's branding, or nil if it has none
+func organizationBranding(ctx context.Context, store Store, orgID uuid.UUID) (*Branding, error) {
+	settings, _, err := store.GetOrganizationSettings(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	return settings.Branding, nil
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CAPTCHA providers, selected with CAPTCHA_PROVIDER
+const (
+	CaptchaTurnstile = "turnstile"
+	CaptchaHCaptcha  = "hcaptcha"
+)
+
+// captchaVerifyURLs are where each provider checks a solved challenge
+var captchaVerifyURLs = map[string]string{
+	CaptchaTurnstile: "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+	CaptchaHCaptcha:  "https://api.hcaptcha.com/siteverify",
+}
+
+var ErrCaptchaFailed = errors.New("captcha verification failed")
+
+// CaptchaConfig sets when logins must solve a CAPTCHA. Without a provider
+// they never do.
+type CaptchaConfig struct {
+	Provider string
+	SiteKey  string
+	Secret   string
+	// AfterFailures is how many failed logins from an address within
+	// FailureWindow make its next logins solve a CAPTCHA; 0 asks on every
+	// login
+	AfterFailures int
+	FailureWindow time.Duration
+	// Timeout bounds each call to the provider
+	Timeout time.Duration
+}
+
+// NewCaptchaConfig creates a CAPTCHA configuration from settings
+func NewCaptchaConfig(settings Settings) (*CaptchaConfig, error) {
+	config := &CaptchaConfig{
+		Provider: settings("CAPTCHA_PROVIDER"),
+		SiteKey:  settings("CAPTCHA_SITE_KEY"),
+		Secret:   settings("CAPTCHA_SECRET"),
+	}
+
+	var errs []error
+	if config.Provider != "" {
+		if _, ok := captchaVerifyURLs[config.Provider]; !ok {
+			errs = append(errs, fmt.Errorf("invalid CAPTCHA_PROVIDER %q: expected turnstile or hcaptcha", config.Provider))
+		}
+		if config.SiteKey == "" || config.Secret == "" {
+			errs = append(errs, errors.New("CAPTCHA_SITE_KEY and CAPTCHA_SECRET are required with CAPTCHA_PROVIDER"))
+		}
+	}
+
+	n, err := strconv.Atoi(settings.get("CAPTCHA_AFTER_FAILURES", "0"))
+	if err != nil || n < 0 {
+		errs = append(errs, fmt.Errorf("invalid CAPTCHA_AFTER_FAILURES %q", settings("CAPTCHA_AFTER_FAILURES")))
+	}
+	config.AfterFailures = n
+
+	for _, v := range []struct {
+		key, fallback string
+		dest          *time.Duration
+	}{
+		{"CAPTCHA_FAILURE_WINDOW", "15m", &config.FailureWindow},
+		{"CAPTCHA_TIMEOUT", "5s", &config.Timeout},
+	} {
+		d, err := time.ParseDuration(settings.get(v.key, v.fallback))
+		if err != nil || d <= 0 {
+			errs = append(errs, fmt.Errorf("invalid %s %q", v.key, settings(v.key)))
+		}
+		*v.dest = d
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// CaptchaChallenge tells a client to solve a CAPTCHA before logging in,
+// and which widget to show
+type CaptchaChallenge struct {
+	Required bool   `json:"required"`
+	Provider string `json:"provider,omitempty"`
+	SiteKey  string `json:"site_key,omitempty"`
+}
+
+// Captcha guards logins with Cloudflare Turnstile or hCaptcha. Failed
+// logins are counted per client address on each instance, like the chat
+// notifier's spikes, so an address is challenged once one instance has
+// seen AfterFailures of its failures within FailureWindow.
+//
+// A nil *Captcha never asks for a CAPTCHA.
+type Captcha struct {
+	config    *CaptchaConfig
+	client    *http.Client
+	verifyURL string
+	now       func() time.Time
+
+	mu       sync.Mutex
+	failures map[string][]time.Time
+}
+
+// NewCaptcha returns nil unless config names a provider
+func NewCaptcha(config *CaptchaConfig) *Captcha {
+	if config.Provider == "" {
+		return nil
+	}
+	return &Captcha{
+		config:    config,
+		client:    &http.Client{Timeout: config.Timeout},
+		verifyURL: captchaVerifyURLs[config.Provider],
+		now:       time.Now,
+		failures:  make(map[string][]time.Time),
+	}
+}
+
+// Challenge reports whether a login from ip must solve a CAPTCHA
+func (c *Captcha) Challenge(ip string) CaptchaChallenge {
+	if c == nil {
+		return CaptchaChallenge{}
+	}
+	return CaptchaChallenge{
+		Required: c.config.AfterFailures == 0 || c.recentFailures(ip) >= c.config.AfterFailures,
+		Provider: c.config.Provider,
+		SiteKey:  c.config.SiteKey,
+	}
+}
+
+// recentFailures counts ip's failed logins within the window, forgetting
+// older ones
+func (c *Captcha) recentFailures(ip string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	since := c.now().Add(-c.config.FailureWindow)
+	recent := slices.DeleteFunc(c.failures[ip], func(t time.Time) bool { return t.Before(since) })
+	if len(recent) == 0 {
+		delete(c.failures, ip)
+	} else {
+		c.failures[ip] = recent
+	}
+	return len(recent)
+}
+
+// RecordFailure counts a failed login from ip
+func (c *Captcha) RecordFailure(ip string) {
+	if c == nil || c.config.AfterFailures == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	since := now.Add(-c.config.FailureWindow)
+	c.failures[ip] = append(c.failures[ip], now)
+
+	// Forget quiet addresses rather than keep map entries for each
+	for addr, times := range c.failures {
+		if times[len(times)-1].Before(since) {
+			delete(c.failures, addr)
+		}
+	}
+}
+
+// RecordSuccess forgets ip's failed logins
+func (c *Captcha) RecordSuccess(ip string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.failures, ip)
+}
+
+// captchaVerifyResponse is the provider's verdict; Turnstile and hCaptcha
+// answer alike
+type captchaVerifyResponse struct {
+	Success    bool     `json:"success"`
+	ErrorCodes []string `json:"error-codes"`
+}
+
+// Verify asks the provider whether token is a challenge solved from ip.
+// It returns ErrCaptchaFailed if not, and another error if the provider
+// could not be asked.
+func (c *Captcha) Verify(ctx context.Context, token, ip string) error {
+	if token == "" {
+		return ErrCaptchaFailed
+	}
+
+	form := url.Values{"secret": {c.config.Secret}, "response": {token}}
+	if ip != "" {
+		form.Set("remoteip", ip)
+	}
+	if c.config.Provider == CaptchaHCaptcha {
+		form.Set("sitekey", c.config.SiteKey)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.verifyURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("verify captcha: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("verify captcha: %s responded %s", c.config.Provider, resp.Status)
+	}
+
+	var verdict captchaVerifyResponse
+	if err := json.NewDecoder(resp.Body).Decode(&verdict); err != nil {
+		return fmt.Errorf("verify captcha: %w", err)
+	}
+	if !verdict.Success {
+		return fmt.Errorf("%w: %s", ErrCaptchaFailed, strings.Join(verdict.ErrorCodes, ", "))
+	}
+	return nil
+}
+
+// handleGetCaptcha tells a login page whether to show a CAPTCHA widget,
+// whose token it then passes to /auth/login/google as captcha_token
+func (s *Server) handleGetCaptcha(w http.ResponseWriter, r *http.Request) {
+	challenge := s.captcha.Challenge(GetClientIPFromContext(r.Context()))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(challenge)
+}
+
+// checkCaptcha verifies the CAPTCHA a login must solve, if any. It reports
+// whether the login may go ahead, having responded if not.
+func (s *Server) checkCaptcha(w http.ResponseWriter, r *http.Request) bool {
+	ip := GetClientIPFromContext(r.Context())
+	challenge := s.captcha.Challenge(ip)
+	if !challenge.Required {
+		return true
+	}
+
+	err := s.captcha.Verify(r.Context(), r.URL.Query().Get("captcha_token"), ip)
+	if errors.Is(err, ErrCaptchaFailed) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(challenge)
+		return false
+	}
+	if err != nil {
+		// Failing closed: while the provider is down, logins it guards wait
+		s.logger.ErrorContext(r.Context(), "failed to verify captcha", "error", err)
+		http.Error(w, "CAPTCHA verification unavailable", http.StatusServiceUnavailable)
+		return false
+	}
+	return true
+}
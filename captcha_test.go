@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewCaptchaConfig(t *testing.T) {
+	settings := func(values map[string]string) Settings {
+		return func(key string) string { return values[key] }
+	}
+
+	config, err := NewCaptchaConfig(settings(nil))
+	require.NoError(t, err)
+	require.Nil(t, NewCaptcha(config), "no provider, no CAPTCHA")
+
+	config, err = NewCaptchaConfig(settings(map[string]string{
+		"CAPTCHA_PROVIDER":       "hcaptcha",
+		"CAPTCHA_SITE_KEY":       "site",
+		"CAPTCHA_SECRET":         "secret",
+		"CAPTCHA_AFTER_FAILURES": "3",
+	}))
+	require.NoError(t, err)
+	require.Equal(t, 3, config.AfterFailures)
+	require.Equal(t, 15*time.Minute, config.FailureWindow)
+
+	for name, values := range map[string]map[string]string{
+		"Unknown provider": {"CAPTCHA_PROVIDER": "recaptcha", "CAPTCHA_SITE_KEY": "site", "CAPTCHA_SECRET": "secret"},
+		"Missing secret":   {"CAPTCHA_PROVIDER": "turnstile", "CAPTCHA_SITE_KEY": "site"},
+		"Negative count":   {"CAPTCHA_AFTER_FAILURES": "-1"},
+		"Invalid window":   {"CAPTCHA_FAILURE_WINDOW": "soon"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewCaptchaConfig(settings(values))
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestCaptchaChallenge(t *testing.T) {
+	captcha := NewCaptcha(&CaptchaConfig{
+		Provider:      CaptchaTurnstile,
+		SiteKey:       "site",
+		Secret:        "secret",
+		AfterFailures: 2,
+		FailureWindow: time.Minute,
+	})
+	now := time.Now()
+	captcha.now = func() time.Time { return now }
+
+	require.False(t, captcha.Challenge("203.0.113.1").Required)
+	captcha.RecordFailure("203.0.113.1")
+	captcha.RecordFailure("203.0.113.1")
+	challenge := captcha.Challenge("203.0.113.1")
+	require.Equal(t, CaptchaChallenge{Required: true, Provider: CaptchaTurnstile, SiteKey: "site"}, challenge)
+	require.False(t, captcha.Challenge("203.0.113.2").Required, "failures are counted per address")
+
+	now = now.Add(time.Minute + time.Second)
+	require.False(t, captcha.Challenge("203.0.113.1").Required, "failures expire")
+
+	captcha.RecordFailure("203.0.113.1")
+	captcha.RecordFailure("203.0.113.1")
+	captcha.RecordSuccess("203.0.113.1")
+	require.False(t, captcha.Challenge("203.0.113.1").Required, "a login clears failures")
+
+	var none *Captcha
+	require.False(t, none.Challenge("203.0.113.1").Required)
+	none.RecordFailure("203.0.113.1")
+}
+
+func TestCaptchaVerify(t *testing.T) {
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		require.Equal(t, "secret", r.PostForm.Get("secret"))
+		require.Equal(t, "203.0.113.1", r.PostForm.Get("remoteip"))
+		if r.PostForm.Get("response") == "solved" {
+			json.NewEncoder(w).Encode(captchaVerifyResponse{Success: true})
+			return
+		}
+		json.NewEncoder(w).Encode(captchaVerifyResponse{ErrorCodes: []string{"invalid-input-response"}})
+	}))
+	defer provider.Close()
+
+	captcha := NewCaptcha(&CaptchaConfig{Provider: CaptchaTurnstile, SiteKey: "site", Secret: "secret", Timeout: time.Second})
+	captcha.verifyURL = provider.URL
+	ctx := context.Background()
+
+	require.NoError(t, captcha.Verify(ctx, "solved", "203.0.113.1"))
+	require.ErrorIs(t, captcha.Verify(ctx, "guessed", "203.0.113.1"), ErrCaptchaFailed)
+	require.ErrorIs(t, captcha.Verify(ctx, "", "203.0.113.1"), ErrCaptchaFailed)
+
+	provider.Close()
+	err := captcha.Verify(ctx, "solved", "203.0.113.1")
+	require.Error(t, err)
+	require.NotErrorIs(t, err, ErrCaptchaFailed, "an unreachable provider is not a failed challenge")
+}
+
+func TestGoogleLoginCaptcha(t *testing.T) {
+	t.Setenv("CAPTCHA_PROVIDER", CaptchaTurnstile)
+	t.Setenv("CAPTCHA_SITE_KEY", "site")
+	t.Setenv("CAPTCHA_SECRET", "secret")
+	srv, err := NewServer(NewMemoryStore(), newTestConfig(t))
+	require.NoError(t, err)
+
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(captchaVerifyResponse{Success: r.FormValue("response") == "solved"})
+	}))
+	defer provider.Close()
+	srv.captcha.verifyURL = provider.URL
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	w := get("/auth/captcha")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"required":true,"provider":"turnstile","site_key":"site"}`, w.Body.String())
+
+	w = get("/auth/login/google")
+	require.Equal(t, http.StatusForbidden, w.Code)
+	require.JSONEq(t, `{"required":true,"provider":"turnstile","site_key":"site"}`, w.Body.String())
+
+	w = get("/auth/login/google?captcha_token=guessed")
+	require.Equal(t, http.StatusForbidden, w.Code)
+
+	w = get("/auth/login/google?captcha_token=solved")
+	require.Equal(t, http.StatusTemporaryRedirect, w.Code)
+}
@@ -0,0 +1,135 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// The platform operator API, for users with the platform:admin permission
+
+// OrganizationList is a page of organizations
+type OrganizationList struct {
+	Organizations []Organization
+	Total         int // organizations across all pages
+}
+
+// PlatformStats summarizes the whole platform
+type PlatformStats struct {
+	Organizations          int            `json:"organizations"`
+	SuspendedOrganizations int            `json:"suspended_organizations"`
+	Users                  int            `json:"users"`
+	ActiveSessions         int            `json:"active_sessions"`
+	OrganizationsByTier    map[string]int `json:"organizations_by_tier"`
+}
+
+// TierUpdate changes an organization's subscription
+type TierUpdate struct {
+	SubscriptionTier string `json:"subscription_tier"`
+	MaxSubAccounts   int    `json:"max_sub_accounts"`
+	SeatOverage      string `json:"seat_overage,omitempty"` // block or allow; unchanged if empty
+}
+
+func adminOrganizationPath(orgID string, elem ...string) string {
+	return "/admin" + organizationPath(orgID, elem...)
+}
+
+// ListOrganizations gets a page of all organizations, newest first
+func (c *Client) ListOrganizations(ctx context.Context, opts *ListOptions, includeDeleted bool) (*OrganizationList, error) {
+	q := opts.values()
+	if includeDeleted {
+		q.Set("include_deleted", "true")
+	}
+	var orgs []Organization
+	header, err := c.do(ctx, http.MethodGet, withQuery("/admin/organizations", q), nil, &orgs)
+	if err != nil {
+		return nil, err
+	}
+	return &OrganizationList{Organizations: orgs, Total: totalCount(header, len(orgs))}, nil
+}
+
+// SearchUsers gets a page of the users across organizations whose email or
+// name matches query
+func (c *Client) SearchUsers(ctx context.Context, query string, opts *ListOptions, includeDeleted bool) (*UserList, error) {
+	q := opts.values()
+	if query != "" {
+		q.Set("q", query)
+	}
+	if includeDeleted {
+		q.Set("include_deleted", "true")
+	}
+	var users []User
+	header, err := c.do(ctx, http.MethodGet, withQuery("/admin/users", q), nil, &users)
+	if err != nil {
+		return nil, err
+	}
+	return &UserList{Users: users, Total: totalCount(header, len(users))}, nil
+}
+
+// SearchResults are the users and organizations a platform search found
+type SearchResults struct {
+	Users         []User         `json:"users"`
+	Organizations []Organization `json:"organizations"`
+}
+
+// Search finds users and organizations across the platform by ID, email,
+// name or slug. The server audits every search.
+func (c *Client) Search(ctx context.Context, query string) (*SearchResults, error) {
+	var results SearchResults
+	if _, err := c.do(ctx, http.MethodGet, withQuery("/admin/search", url.Values{"q": {query}}), nil, &results); err != nil {
+		return nil, err
+	}
+	return &results, nil
+}
+
+// SuspendOrganization locks an organization's members out
+func (c *Client) SuspendOrganization(ctx context.Context, orgID string) (*Organization, error) {
+	var org Organization
+	if _, err := c.do(ctx, http.MethodPost, adminOrganizationPath(orgID, "suspend"), nil, &org); err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
+
+// UnsuspendOrganization reinstates a suspended organization
+func (c *Client) UnsuspendOrganization(ctx context.Context, orgID string) (*Organization, error) {
+	var org Organization
+	if _, err := c.do(ctx, http.MethodPost, adminOrganizationPath(orgID, "unsuspend"), nil, &org); err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
+
+// UpdateTier changes the subscription of an organization, which must still
+// be at version. It fails with ErrVersionConflict if the organization has
+// changed since.
+func (c *Client) UpdateTier(ctx context.Context, orgID string, version int, update TierUpdate) (*Organization, error) {
+	var org Organization
+	header := http.Header{"If-Match": {strconv.Quote(strconv.Itoa(version))}}
+	if _, err := c.doWithHeader(ctx, http.MethodPut, adminOrganizationPath(orgID, "tier"), header, update, &org); err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
+
+// DeleteOrganization deletes an organization and its members
+func (c *Client) DeleteOrganization(ctx context.Context, orgID string) error {
+	_, err := c.do(ctx, http.MethodDelete, adminOrganizationPath(orgID), nil, nil)
+	return err
+}
+
+// DeleteUser deletes a sub-account of any organization
+func (c *Client) DeleteUser(ctx context.Context, userID string) error {
+	_, err := c.do(ctx, http.MethodDelete, "/admin/users/"+url.PathEscape(userID), nil, nil)
+	return err
+}
+
+// PlatformStats gets statistics across all organizations
+func (c *Client) PlatformStats(ctx context.Context) (*PlatformStats, error) {
+	var stats PlatformStats
+	if _, err := c.do(ctx, http.MethodGet, "/admin/stats", nil, &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
@@ -1,32 +1,196 @@
+// Package client is a Go client for the Huachuca API
 package client
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/http/cookiejar"
+	"strings"
+	"sync"
 	"time"
 )
 
 type Client struct {
-	baseURL     string
-	httpClient  *http.Client
+	baseURL    string
+	httpClient *http.Client
+
+	mu          sync.RWMutex // guards the fields below
 	accessToken string
 	csrfToken   string
+	tokens      TokenSource
+	retry       RetryPolicy
+	rateLimit   *RateLimit // from the latest response carrying one
+	hooks       []Hooks
+
+	fetchedCSRF string
+
+	csrfMu sync.Mutex // serializes fetching CSRF tokens
 }
 
+// NewClient creates a client for the API at baseURL. It keeps cookies in
+// memory, so the CSRF tokens it fetches match the cookies they were issued
+// with.
 func NewClient(baseURL string) *Client {
+	jar, _ := cookiejar.New(nil) // never fails without options
 	return &Client{
-		baseURL: baseURL,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
 		httpClient: &http.Client{
 			Timeout: 10 * time.Second,
+			Jar:     jar,
 		},
+		retry: DefaultRetryPolicy,
+	}
+}
+
+// SetHTTPClient sends requests with hc. A copy of hc is kept, given the
+// client's cookie jar if it has none.
+func (c *Client) SetHTTPClient(hc *http.Client) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	copied := *hc
+	if copied.Jar == nil {
+		copied.Jar = c.httpClient.Jar
 	}
+	c.httpClient = &copied
+}
+
+// SetAccessToken authenticates requests with a fixed access token. A token
+// source set with SetTokenSource takes precedence.
+func (c *Client) SetAccessToken(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.accessToken = token
+}
+
+// SetCSRFToken sends token with requests that change state, instead of
+// one the client fetches itself
+func (c *Client) SetCSRFToken(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.csrfToken = token
 }
 
-// Set tokens
-func (c *Client) SetAccessToken(token string) { c.accessToken = token }
-func (c *Client) SetCSRFToken(token string)   { c.csrfToken = token }
+// SetTokenSource authenticates requests with tokens from ts. When ts is a
+// *RefreshingTokenSource, a request rejected with 401 is retried once with
+// a refreshed token.
+func (c *Client) SetTokenSource(ts TokenSource) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tokens = ts
+}
+
+// do sends an authenticated request, encoding body as JSON and decoding the
+// response into out when they are not nil. It returns the response headers.
+func (c *Client) do(ctx context.Context, method, path string, body, out interface{}) (http.Header, error) {
+	return c.doWithHeader(ctx, method, path, nil, body, out)
+}
+
+// doWithHeader is do, adding header to the request
+func (c *Client) doWithHeader(ctx context.Context, method, path string, header http.Header, body, out interface{}) (http.Header, error) {
+	c.mu.RLock()
+	ts, accessToken := c.tokens, c.accessToken
+	c.mu.RUnlock()
+	if ts == nil {
+		return c.send(ctx, method, path, header, body, out, accessToken)
+	}
+
+	token, err := ts.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+	respHeader, err := c.send(ctx, method, path, header, body, out, token.AccessToken)
+	var apiErr *APIError
+	refresher, ok := ts.(*RefreshingTokenSource)
+	if !ok || !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		return respHeader, err
+	}
+
+	// The token was revoked or expired early
+	token, err = refresher.Refresh(ctx, token.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+	return c.send(ctx, method, path, header, body, out, token.AccessToken)
+}
+
+// send sends a request with accessToken, if it is not empty, retrying it
+// as the client's RetryPolicy allows. Requests that change state carry the
+// client's CSRF token, and are sent once more with a fresh one if the
+// server rejects it.
+func (c *Client) send(ctx context.Context, method, path string, header http.Header, body, out interface{}, accessToken string) (http.Header, error) {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
+	}
+	if safeMethod(method) {
+		return c.sendOnce(ctx, method, path, header, data, out, accessToken, "")
+	}
+
+	csrfToken, manual := c.currentCSRF()
+	respHeader, err := c.sendOnce(ctx, method, path, header, data, out, accessToken, csrfToken)
+	if manual || !errors.Is(err, ErrCSRF) {
+		return respHeader, err
+	}
+	// There was no token yet, its cookie expired, or it was issued with
+	// another cookie. The server refused the request without acting on it,
+	// so it can be sent again.
+	csrfToken, fetchErr := c.refreshCSRF(ctx, csrfToken)
+	if fetchErr != nil {
+		return respHeader, err
+	}
+	return c.sendOnce(ctx, method, path, header, data, out, accessToken, csrfToken)
+}
+
+func (c *Client) sendOnce(ctx context.Context, method, path string, header http.Header, data []byte, out interface{}, accessToken, csrfToken string) (http.Header, error) {
+	newRequest := func() (*http.Request, error) {
+		var reader io.Reader
+		if data != nil {
+			reader = bytes.NewReader(data)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		req.Header.Set("Accept", "application/json")
+		if data != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if accessToken != "" {
+			req.Header.Set("Authorization", "Bearer "+accessToken)
+		}
+		if csrfToken != "" {
+			req.Header.Set("X-CSRF-Token", csrfToken)
+		}
+		return req, nil
+	}
+
+	resp, err := c.roundTrip(ctx, method, newRequest)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.Header, newAPIError(resp)
+	}
+	if out != nil && resp.StatusCode != http.StatusNoContent {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp.Header, fmt.Errorf("huachuca: decoding response: %w", err)
+		}
+	}
+	return resp.Header, nil
+}
 
 // TokenResponse represents the auth token response
 type TokenResponse struct {
@@ -43,6 +207,10 @@ type User struct {
 	OrganizationID string          `json:"organization_id"`
 	Role           string          `json:"role"`
 	Permissions    map[string]bool `json:"permissions"`
+	Metadata       map[string]any  `json:"metadata,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	Version        int             `json:"version"`
+	UpdatedAt      time.Time       `json:"updated_at"`
 }
 
 // GetGoogleAuthURL returns the Google OAuth URL
@@ -50,82 +218,48 @@ func (c *Client) GetGoogleAuthURL() string {
 	return fmt.Sprintf("%s/auth/login/google", c.baseURL)
 }
 
-// RefreshToken refreshes an access token
-func (c *Client) RefreshToken(refreshToken string) (*TokenResponse, error) {
-	reqBody, err := json.Marshal(map[string]string{
-		"refresh_token": refreshToken,
-	})
-	if err != nil {
-		return nil, err
-	}
-
-	resp, err := c.httpClient.Post(
-		fmt.Sprintf("%s/auth/refresh", c.baseURL),
-		"application/json",
-		bytes.NewBuffer(reqBody),
-	)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("refresh token request failed with status %d", resp.StatusCode)
-	}
-
-	var tokenResp TokenResponse
-	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+// RefreshToken exchanges a refresh token for a new token pair. The refresh
+// token given is revoked.
+func (c *Client) RefreshToken(ctx context.Context, refreshToken string) (*TokenResponse, error) {
+	// Token sources refresh through this, so it must not use them
+	var tokens TokenResponse
+	if _, err := c.send(ctx, http.MethodPost, "/auth/refresh", nil, map[string]string{"refresh_token": refreshToken}, &tokens, ""); err != nil {
 		return nil, err
 	}
-
-	return &tokenResp, nil
+	return &tokens, nil
 }
 
-// GetCSRFToken gets a new CSRF token
-func (c *Client) GetCSRFToken() (string, error) {
-	resp, err := c.httpClient.Get(fmt.Sprintf("%s/csrf/token", c.baseURL))
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("CSRF token request failed with status %d", resp.StatusCode)
-	}
+// Logout revokes a refresh token
+func (c *Client) Logout(ctx context.Context, refreshToken string) error {
+	_, err := c.send(ctx, http.MethodPost, "/auth/logout", nil, map[string]string{"refresh_token": refreshToken}, nil, "")
+	return err
+}
 
+// GetCSRFToken gets a new CSRF token, setting its cookie in the client's
+// cookie jar. The client fetches tokens as it needs them, so this is only
+// needed to hand tokens to other clients.
+func (c *Client) GetCSRFToken(ctx context.Context) (string, error) {
 	var result struct {
 		Token string `json:"csrf_token"`
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+	if _, err := c.send(ctx, http.MethodGet, "/csrf/token", nil, nil, &result, ""); err != nil {
 		return "", err
 	}
-
 	return result.Token, nil
 }
 
 // GetUser gets the current user's information
-func (c *Client) GetUser() (*User, error) {
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/user", c.baseURL), nil)
-	if err != nil {
-		return nil, err
-	}
-
-	req.Header.Set("Authorization", "Bearer "+c.accessToken)
-
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("get user failed with status %d", resp.StatusCode)
-	}
-
+func (c *Client) GetUser(ctx context.Context) (*User, error) {
 	var user User
-	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+	if _, err := c.do(ctx, http.MethodGet, "/me", nil, &user); err != nil {
 		return nil, err
 	}
-
 	return &user, nil
 }
+
+// RevokeSessions signs the current user out everywhere by revoking all of
+// their refresh tokens
+func (c *Client) RevokeSessions(ctx context.Context) error {
+	_, err := c.do(ctx, http.MethodDelete, "/me/sessions", nil, nil)
+	return err
+}
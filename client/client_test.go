@@ -0,0 +1,132 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+
+	var lastRequest *http.Request
+	var lastBody map[string]string
+	mux := http.NewServeMux()
+	record := func(h http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			lastRequest, lastBody = r, nil
+			json.NewDecoder(r.Body).Decode(&lastBody)
+			h(w, r)
+		}
+	}
+	mux.HandleFunc("GET /me", record(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(User{ID: "u1", Email: "owner@acme.test", Role: "owner"})
+	}))
+	mux.HandleFunc("POST /organizations", record(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(Organization{ID: "o1", Name: lastBody["name"]})
+	}))
+	mux.HandleFunc("GET /organizations/{orgID}/details", record(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "organization not found", http.StatusNotFound)
+	}))
+	mux.HandleFunc("GET /organizations/{orgID}/users", record(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Total-Count", "3")
+		json.NewEncoder(w).Encode([]User{{ID: "u2"}})
+	}))
+	mux.HandleFunc("PUT /organizations/{orgID}/users/{userID}/role", record(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(User{ID: r.PathValue("userID"), Role: lastBody["role"]})
+	}))
+	mux.HandleFunc("DELETE /organizations/{orgID}/users/{userID}", record(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	mux.HandleFunc("GET /health", record(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(HealthResponse{Status: "unhealthy"})
+	}))
+	mux.HandleFunc("GET /version", record(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(BuildInfo{Version: "1.2.3"})
+	}))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	c := NewClient(srv.URL + "/")
+	c.SetAccessToken("access")
+	c.SetCSRFToken("csrf")
+
+	t.Run("Authenticates requests", func(t *testing.T) {
+		user, err := c.GetUser(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "owner@acme.test", user.Email)
+
+		anonymous := NewClient(srv.URL)
+		_, err = anonymous.GetUser(ctx)
+		var apiErr *APIError
+		require.ErrorAs(t, err, &apiErr)
+		require.Equal(t, http.StatusUnauthorized, apiErr.StatusCode)
+		require.Equal(t, "Unauthorized", apiErr.Message)
+	})
+
+	t.Run("Sends the CSRF token with changes", func(t *testing.T) {
+		org, err := c.CreateOrganization(ctx, "Acme", "owner@acme.test", "Owner")
+		require.NoError(t, err)
+		require.Equal(t, "Acme", org.Name)
+		require.Equal(t, "csrf", lastRequest.Header.Get("X-CSRF-Token"))
+		require.Equal(t, "application/json", lastRequest.Header.Get("Content-Type"))
+		require.Equal(t, "owner@acme.test", lastBody["owner_email"])
+	})
+
+	t.Run("Lists a page of members with the total", func(t *testing.T) {
+		list, err := c.ListUsers(ctx, "o1", &ListOptions{Limit: 1, Offset: 2})
+		require.NoError(t, err)
+		require.Equal(t, 3, list.Total)
+		require.Len(t, list.Users, 1)
+		require.Equal(t, "limit=1&offset=2", lastRequest.URL.RawQuery)
+
+		_, err = c.ListUsers(ctx, "o1", nil)
+		require.NoError(t, err)
+		require.Empty(t, lastRequest.URL.RawQuery)
+	})
+
+	t.Run("Manages members", func(t *testing.T) {
+		user, err := c.UpdateUserRole(ctx, "o1", "u2", "admin")
+		require.NoError(t, err)
+		require.Equal(t, "admin", user.Role)
+		require.Equal(t, "u2", user.ID)
+
+		require.NoError(t, c.RemoveUser(ctx, "o1", "u2"))
+		require.Equal(t, "/organizations/o1/users/u2", lastRequest.URL.Path)
+	})
+
+	t.Run("Returns errors as APIError", func(t *testing.T) {
+		_, err := c.GetOrganization(ctx, "o1")
+		var apiErr *APIError
+		require.True(t, errors.As(err, &apiErr))
+		require.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+		require.EqualError(t, err, "huachuca: 404 organization not found")
+
+		_, err = c.Health(ctx)
+		require.ErrorAs(t, err, &apiErr)
+		require.Equal(t, http.StatusServiceUnavailable, apiErr.StatusCode)
+	})
+
+	t.Run("Reports the server version", func(t *testing.T) {
+		info, err := c.Version(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "1.2.3", info.Version)
+	})
+
+	t.Run("Honors the context", func(t *testing.T) {
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		_, err := c.Version(cancelled)
+		require.ErrorIs(t, err, context.Canceled)
+	})
+}
@@ -0,0 +1,47 @@
+package client
+
+import (
+	"context"
+	"net/http"
+)
+
+func safeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// currentCSRF returns the CSRF token to send with requests that change
+// state: the one set with SetCSRFToken, or the last one fetched. It is
+// empty until the server first asks for one, so deployments that exempt
+// bearer-authenticated requests never pay for a fetch.
+func (c *Client) currentCSRF() (token string, manual bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.csrfToken != "" {
+		return c.csrfToken, true
+	}
+	return c.fetchedCSRF, false
+}
+
+// refreshCSRF fetches a CSRF token to replace stale, which the server
+// rejected, unless another request has replaced it already. Requests
+// rejected together wait for a single fetch.
+func (c *Client) refreshCSRF(ctx context.Context, stale string) (string, error) {
+	c.csrfMu.Lock()
+	defer c.csrfMu.Unlock()
+
+	if token, _ := c.currentCSRF(); token != stale {
+		return token, nil
+	}
+	token, err := c.GetCSRFToken(ctx)
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	c.fetchedCSRF = token
+	c.mu.Unlock()
+	return token, nil
+}
@@ -0,0 +1,72 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gorilla/csrf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCSRF(t *testing.T) {
+	ctx := context.Background()
+
+	var fetches atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /csrf/token", func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		json.NewEncoder(w).Encode(map[string]string{"csrf_token": csrf.Token(r)})
+	})
+	mux.HandleFunc("POST /organizations", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(Organization{ID: "o1", Name: "Acme"})
+	})
+	// Configured as the server configures it
+	protect := csrf.Protect([]byte("0123456789abcdef0123456789abcdef"),
+		csrf.Secure(true),
+		csrf.Path("/"),
+		csrf.RequestHeader("X-CSRF-Token"),
+		csrf.CookieName("_gorilla.csrf"),
+		csrf.ErrorHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, csrf.FailureReason(r).Error(), http.StatusForbidden)
+		})),
+	)
+	srv := httptest.NewTLSServer(protect(mux))
+	defer srv.Close()
+
+	c := NewClient(srv.URL)
+	c.SetHTTPClient(srv.Client())
+	create := func() error {
+		_, err := c.CreateOrganization(ctx, "Acme", "owner@acme.test", "Owner")
+		return err
+	}
+
+	t.Run("Fetches a token when the server asks for one", func(t *testing.T) {
+		require.NoError(t, create())
+		require.Equal(t, int32(1), fetches.Load())
+
+		require.NoError(t, create())
+		require.Equal(t, int32(1), fetches.Load())
+	})
+
+	t.Run("Fetches a new token when the cookie is lost", func(t *testing.T) {
+		hc := srv.Client()
+		hc.Jar, _ = cookiejar.New(nil)
+		c.SetHTTPClient(hc)
+
+		require.NoError(t, create())
+		require.Equal(t, int32(2), fetches.Load())
+	})
+
+	t.Run("Leaves tokens set by the caller alone", func(t *testing.T) {
+		c.SetCSRFToken("forged")
+		err := create()
+		require.ErrorIs(t, err, ErrCSRF)
+		require.ErrorIs(t, err, ErrForbidden)
+		require.Equal(t, int32(2), fetches.Load())
+	})
+}
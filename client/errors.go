@@ -0,0 +1,156 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Errors an *APIError matches with errors.Is. Each error matches the
+// sentinel for its status, and the more specific ones the server's message
+// identifies: a member added past an organization's seats is both
+// ErrForbidden and ErrQuotaExceeded.
+var (
+	ErrBadRequest   = errors.New("huachuca: bad request")
+	ErrUnauthorized = errors.New("huachuca: unauthorized")
+	ErrForbidden    = errors.New("huachuca: forbidden")
+	ErrNotFound     = errors.New("huachuca: not found")
+	ErrConflict     = errors.New("huachuca: conflict")
+	ErrRateLimited  = errors.New("huachuca: rate limited")
+	ErrServer       = errors.New("huachuca: server error")
+
+	ErrEmailTaken            = errors.New("huachuca: email already taken")
+	ErrQuotaExceeded         = errors.New("huachuca: maximum sub-accounts reached")
+	ErrVersionConflict       = errors.New("huachuca: modified by another request")
+	ErrPreconditionRequired  = errors.New("huachuca: If-Match required")
+	ErrOrganizationSuspended = errors.New("huachuca: organization suspended")
+	ErrDeleteOwner           = errors.New("huachuca: organization owner cannot be deleted")
+	ErrCSRF                  = errors.New("huachuca: CSRF token missing or invalid")
+)
+
+// statusErrors maps statuses to their sentinel
+var statusErrors = map[int]error{
+	http.StatusBadRequest:           ErrBadRequest,
+	http.StatusUnauthorized:         ErrUnauthorized,
+	http.StatusForbidden:            ErrForbidden,
+	http.StatusNotFound:             ErrNotFound,
+	http.StatusConflict:             ErrConflict,
+	http.StatusPreconditionRequired: ErrPreconditionRequired,
+	http.StatusTooManyRequests:      ErrRateLimited,
+}
+
+// messageErrors maps the server's error messages, in lower case, to their
+// sentinel
+var messageErrors = map[string]error{
+	"email already taken":                  ErrEmailTaken,
+	"maximum sub-accounts reached":         ErrQuotaExceeded,
+	"modified by another request":          ErrVersionConflict,
+	"organization suspended":               ErrOrganizationSuspended,
+	"organization owner cannot be deleted": ErrDeleteOwner,
+	"if-match header required":             ErrPreconditionRequired,
+}
+
+// ValidationError is a request field the server rejected. errors.As finds
+// the first one an *APIError carries; Fields lists them all.
+type ValidationError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+// APIError is returned for responses with a non-2xx status
+type APIError struct {
+	StatusCode int
+	// Message is the plain text body, or the detail of an
+	// application/problem+json one
+	Message string
+	Fields  []*ValidationError // rejected fields, for 400 responses
+	// RetryAfter is how long the server asked clients to wait, if it did
+	RetryAfter time.Duration
+	RateLimit  *RateLimit // nil unless the response carried rate limit headers
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("huachuca: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("huachuca: %d %s", e.StatusCode, e.Message)
+}
+
+// Unwrap returns the sentinels and validation errors e matches
+func (e *APIError) Unwrap() []error {
+	var errs []error
+	if err, ok := statusErrors[e.StatusCode]; ok {
+		errs = append(errs, err)
+	} else if e.StatusCode >= 500 {
+		errs = append(errs, ErrServer)
+	}
+	if err, ok := messageErrors[strings.ToLower(e.Message)]; ok && !slices.Contains(errs, err) {
+		errs = append(errs, err)
+	}
+	if e.StatusCode == http.StatusForbidden && strings.Contains(e.Message, "CSRF") {
+		errs = append(errs, ErrCSRF)
+	}
+	for _, field := range e.Fields {
+		errs = append(errs, field)
+	}
+	return errs
+}
+
+// problem is an RFC 9457 problem details body. The server lists rejected
+// fields in errors; invalid-params is the RFC's own example of the same.
+type problem struct {
+	Title         string            `json:"title"`
+	Detail        string            `json:"detail"`
+	Errors        []ValidationError `json:"errors"`
+	InvalidParams []struct {
+		Name   string `json:"name"`
+		Reason string `json:"reason"`
+	} `json:"invalid-params"`
+}
+
+// validationMessage matches the server's "field: message" validation
+// errors, such as "email: invalid email format"
+var validationMessage = regexp.MustCompile(`^([a-z][a-z0-9_.]*): (.+)$`)
+
+// newAPIError reads an error response
+func newAPIError(resp *http.Response) *APIError {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	e := &APIError{StatusCode: resp.StatusCode}
+	e.RetryAfter, _ = parseRetryAfter(resp.Header, time.Now())
+	e.RateLimit, _ = parseRateLimit(resp.Header)
+
+	var p problem
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == "application/problem+json" && json.Unmarshal(body, &p) == nil {
+		e.Message = p.Detail
+		if e.Message == "" {
+			e.Message = p.Title
+		}
+		for _, field := range p.Errors {
+			e.Fields = append(e.Fields, &field)
+		}
+		for _, param := range p.InvalidParams {
+			e.Fields = append(e.Fields, &ValidationError{Field: param.Name, Message: param.Reason})
+		}
+		return e
+	}
+
+	e.Message = strings.TrimSpace(string(body))
+	if e.StatusCode == http.StatusBadRequest {
+		if m := validationMessage.FindStringSubmatch(e.Message); m != nil {
+			e.Fields = []*ValidationError{{Field: m[1], Message: m[2]}}
+		}
+	}
+	return e
+}
@@ -0,0 +1,100 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIErrors(t *testing.T) {
+	ctx := context.Background()
+
+	respond := func(status int, contentType, body string) error {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if contentType != "" {
+				w.Header().Set("Content-Type", contentType)
+			}
+			w.WriteHeader(status)
+			w.Write([]byte(body))
+		}))
+		defer srv.Close()
+		c := NewClient(srv.URL)
+		c.SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+		_, err := c.AddUser(ctx, "o1", "member@acme.test", "Member")
+		return err
+	}
+
+	t.Run("Matches the status and the server's message", func(t *testing.T) {
+		err := respond(http.StatusConflict, "", "email already taken\n")
+		require.ErrorIs(t, err, ErrConflict)
+		require.ErrorIs(t, err, ErrEmailTaken)
+		require.NotErrorIs(t, err, ErrVersionConflict)
+
+		err = respond(http.StatusForbidden, "", "maximum sub-accounts reached\n")
+		require.ErrorIs(t, err, ErrForbidden)
+		require.ErrorIs(t, err, ErrQuotaExceeded)
+
+		err = respond(http.StatusForbidden, "", "Organization suspended\n")
+		require.ErrorIs(t, err, ErrOrganizationSuspended)
+
+		err = respond(http.StatusForbidden, "", "forbidden - CSRF token invalid\n")
+		require.ErrorIs(t, err, ErrCSRF)
+
+		err = respond(http.StatusBadGateway, "", "")
+		require.ErrorIs(t, err, ErrServer)
+		require.EqualError(t, err, "huachuca: 502 Bad Gateway")
+	})
+
+	t.Run("Reads validation errors", func(t *testing.T) {
+		err := respond(http.StatusBadRequest, "", "email: invalid email format\n")
+		require.ErrorIs(t, err, ErrBadRequest)
+		var valErr *ValidationError
+		require.ErrorAs(t, err, &valErr)
+		require.Equal(t, "email", valErr.Field)
+		require.Equal(t, "invalid email format", valErr.Message)
+
+		err = respond(http.StatusBadRequest, "", "Invalid request body\n")
+		require.False(t, errors.As(err, &valErr))
+	})
+
+	t.Run("Reads problem details", func(t *testing.T) {
+		err := respond(http.StatusBadRequest, "application/problem+json", `{
+			"title": "Invalid request",
+			"detail": "2 fields are invalid",
+			"invalid-params": [
+				{"name": "email", "reason": "required field is empty"},
+				{"name": "name", "reason": "field exceeds maximum length"}
+			]
+		}`)
+		var apiErr *APIError
+		require.ErrorAs(t, err, &apiErr)
+		require.Equal(t, "2 fields are invalid", apiErr.Message)
+		require.Len(t, apiErr.Fields, 2)
+		require.Equal(t, "name", apiErr.Fields[1].Field)
+
+		var valErr *ValidationError
+		require.ErrorAs(t, err, &valErr)
+		require.Equal(t, "email", valErr.Field)
+
+		err = respond(http.StatusBadRequest, "application/problem+json", `{
+			"title": "Bad Request",
+			"detail": "name: required field is empty; owner_email: invalid email format",
+			"errors": [
+				{"field": "name", "message": "required field is empty"},
+				{"field": "owner_email", "message": "invalid email format"}
+			]
+		}`)
+		require.ErrorAs(t, err, &apiErr)
+		require.Equal(t, []*ValidationError{
+			{Field: "name", Message: "required field is empty"},
+			{Field: "owner_email", Message: "invalid email format"},
+		}, apiErr.Fields)
+
+		err = respond(http.StatusConflict, "application/problem+json; charset=utf-8", `{"title":"Email already taken"}`)
+		require.ErrorIs(t, err, ErrEmailTaken)
+	})
+}
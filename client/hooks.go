@@ -0,0 +1,48 @@
+package client
+
+import (
+	"net/http"
+	"time"
+)
+
+// Hooks observe each HTTP request the client sends, including retries and
+// token refreshes. Either may be nil.
+type Hooks struct {
+	// OnRequest is called before each attempt is sent. It returns the
+	// request to send: req itself, perhaps with headers added, or a copy
+	// made with req.WithContext.
+	OnRequest func(req *http.Request) *http.Request
+	// OnResponse is called after each attempt with the request OnRequest
+	// returned, and either the response or the error sending it. The body
+	// must be left unread.
+	OnResponse func(req *http.Request, resp *http.Response, err error, elapsed time.Duration)
+}
+
+// AddHooks adds hooks to the client. OnRequest hooks run in the order they
+// were added, OnResponse hooks in reverse, so hooks added later are nested
+// inside earlier ones.
+func (c *Client) AddHooks(h Hooks) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.hooks = append(c.hooks, h)
+}
+
+// sendAttempt sends one attempt of a request through the client's hooks
+func sendAttempt(httpClient *http.Client, hooks []Hooks, req *http.Request) (*http.Response, error) {
+	for _, h := range hooks {
+		if h.OnRequest != nil {
+			req = h.OnRequest(req)
+		}
+	}
+
+	start := time.Now()
+	resp, err := httpClient.Do(req)
+	elapsed := time.Since(start)
+
+	for i := len(hooks) - 1; i >= 0; i-- {
+		if hooks[i].OnResponse != nil {
+			hooks[i].OnResponse(req, resp, err, elapsed)
+		}
+	}
+	return resp, err
+}
@@ -0,0 +1,136 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const orgID = "0b0f9f6e-6c5d-4a57-9b4e-1f9c4f3d2a10"
+
+func TestHooks(t *testing.T) {
+	ctx := context.Background()
+	attempts := 0
+	var traceparent string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("Traceparent")
+		if r.URL.Path == "/version" {
+			w.Write([]byte(`{"version":"1.0.0"}`))
+			return
+		}
+		attempts++
+		if attempts == 1 {
+			http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		http.Error(w, "organization not found", http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL)
+	c.SetRetryPolicy(RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, MaxRetryAfter: time.Second})
+
+	t.Run("Hooks see every attempt, nested in the order added", func(t *testing.T) {
+		var calls []string
+		hook := func(name string) Hooks {
+			return Hooks{
+				OnRequest: func(req *http.Request) *http.Request {
+					calls = append(calls, name+" request "+req.URL.Path)
+					return req
+				},
+				OnResponse: func(req *http.Request, resp *http.Response, err error, elapsed time.Duration) {
+					calls = append(calls, name+" response "+resp.Status)
+				},
+			}
+		}
+		c.AddHooks(hook("outer"))
+		c.AddHooks(hook("inner"))
+		defer func() { c.hooks = nil }()
+
+		_, err := c.GetOrganization(ctx, orgID)
+		require.ErrorIs(t, err, ErrNotFound)
+		path := "/organizations/" + orgID + "/details"
+		require.Equal(t, []string{
+			"outer request " + path, "inner request " + path, "inner response 503 Service Unavailable", "outer response 503 Service Unavailable",
+			"outer request " + path, "inner request " + path, "inner response 404 Not Found", "outer response 404 Not Found",
+		}, calls)
+	})
+
+	t.Run("Tracing", func(t *testing.T) {
+		attempts = 0
+		recorder := tracetest.NewSpanRecorder()
+		c.AddHooks(TracingHooks(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))))
+		defer func() { c.hooks = nil }()
+
+		_, err := c.GetOrganization(ctx, orgID)
+		require.Error(t, err)
+		spans := recorder.Ended()
+		require.Len(t, spans, 2)
+		span := spans[1]
+		require.Equal(t, "GET /organizations/{id}/details", span.Name())
+		require.Equal(t, trace.SpanKindClient, span.SpanKind())
+		require.Equal(t, codes.Error, span.Status().Code)
+		require.Contains(t, span.Attributes(), attribute.Int("http.response.status_code", http.StatusNotFound))
+
+		_, err = c.Version(ctx)
+		require.NoError(t, err)
+		require.Equal(t, codes.Unset, recorder.Ended()[2].Status().Code)
+		require.Empty(t, traceparent, "without a propagator nothing is sent")
+
+		previous := otel.GetTextMapPropagator()
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+		defer otel.SetTextMapPropagator(previous)
+		_, err = c.Version(ctx)
+		require.NoError(t, err)
+		require.Contains(t, traceparent, recorder.Ended()[3].SpanContext().TraceID().String())
+	})
+
+	t.Run("Metrics", func(t *testing.T) {
+		attempts = 0
+		reg := prometheus.NewRegistry()
+		hooks, err := MetricsHooks(reg)
+		require.NoError(t, err)
+		c.AddHooks(hooks)
+		defer func() { c.hooks = nil }()
+
+		// A second client shares the histogram
+		other := NewClient("http://127.0.0.1:1")
+		other.SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+		hooks, err = MetricsHooks(reg)
+		require.NoError(t, err)
+		other.AddHooks(hooks)
+
+		c.GetOrganization(ctx, orgID)
+		_, err = other.Version(ctx)
+		require.Error(t, err)
+
+		families, err := reg.Gather()
+		require.NoError(t, err)
+		require.Len(t, families, 1)
+		series := map[string]uint64{}
+		for _, m := range families[0].GetMetric() {
+			labels := map[string]string{}
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			series[labels["method"]+" "+labels["route"]+" "+labels["code"]] = m.GetHistogram().GetSampleCount()
+		}
+		require.Equal(t, map[string]uint64{
+			"GET /organizations/{id}/details 503": 1,
+			"GET /organizations/{id}/details 404": 1,
+			"GET /version error":                  1,
+		}, series)
+	})
+}
@@ -0,0 +1,175 @@
+package huachucatest
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/mmichie/huachuca/client"
+)
+
+// Fixtures describe the organizations and users a server starts with. They
+// can be written in Go or kept as JSON files and read with LoadFixtures.
+type Fixtures struct {
+	Organizations []OrganizationFixture `json:"organizations"`
+}
+
+// OrganizationFixture is an organization with its owner and members.
+// Empty fields take the defaults the API gives new organizations.
+type OrganizationFixture struct {
+	ID               string        `json:"id,omitempty"`
+	Name             string        `json:"name"`
+	SubscriptionTier string        `json:"subscription_tier,omitempty"` // free unless set
+	MaxSubAccounts   int           `json:"max_sub_accounts,omitempty"`  // the tier's seats unless set
+	SeatOverage      string        `json:"seat_overage,omitempty"`      // block unless set
+	Suspended        bool          `json:"suspended,omitempty"`
+	Owner            UserFixture   `json:"owner"`
+	Members          []UserFixture `json:"members,omitempty"`
+}
+
+// UserFixture is a user. Members are sub-accounts unless Role makes them
+// admins; owners ignore Role. Platform admins may use the /admin endpoints.
+type UserFixture struct {
+	ID            string `json:"id,omitempty"`
+	Email         string `json:"email"`
+	Name          string `json:"name"`
+	Role          string `json:"role,omitempty"`
+	PlatformAdmin bool   `json:"platform_admin,omitempty"`
+}
+
+// tierSeats is each subscription tier's default sub-account limit
+var tierSeats = map[string]int{
+	"free":       5,
+	"pro":        25,
+	"enterprise": 250,
+}
+
+// LoadFixtures reads fixtures from a JSON file
+func LoadFixtures(path string) (*Fixtures, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f Fixtures
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("huachucatest: reading %s: %w", path, err)
+	}
+	return &f, nil
+}
+
+// Seed adds fixtures to the server. Nothing is added if any fixture is
+// invalid, such as a taken email address.
+func (s *Server) Seed(f *Fixtures) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	orgs := map[string]*client.Organization{}
+	users := map[string]*client.User{}
+	emails := map[string]bool{}
+	for _, u := range s.users {
+		emails[strings.ToLower(u.Email)] = true
+	}
+
+	addUser := func(orgID string, u UserFixture, role string) (*client.User, error) {
+		if u.Email == "" || u.Name == "" {
+			return nil, fmt.Errorf("huachucatest: user %q needs an email and a name", u.Email)
+		}
+		if emails[strings.ToLower(u.Email)] {
+			return nil, fmt.Errorf("huachucatest: email %s is taken", u.Email)
+		}
+		emails[strings.ToLower(u.Email)] = true
+		if _, ok := rolePermissions[role]; !ok {
+			return nil, fmt.Errorf("huachucatest: unknown role %q", role)
+		}
+		user := newUser(orgID, u.Email, u.Name, role)
+		if u.ID != "" {
+			user.ID = u.ID
+		}
+		if u.PlatformAdmin {
+			user.Permissions[permPlatformAdmin] = true
+		}
+		users[user.ID] = user
+		return user, nil
+	}
+
+	for _, o := range f.Organizations {
+		org := newOrganization(o.Name)
+		if o.ID != "" {
+			org.ID = o.ID
+		}
+		if o.SubscriptionTier != "" {
+			seats, ok := tierSeats[o.SubscriptionTier]
+			if !ok {
+				return fmt.Errorf("huachucatest: unknown subscription tier %q", o.SubscriptionTier)
+			}
+			org.SubscriptionTier, org.MaxSubAccounts = o.SubscriptionTier, seats
+		}
+		if o.MaxSubAccounts != 0 {
+			org.MaxSubAccounts = o.MaxSubAccounts
+		}
+		if o.SeatOverage != "" {
+			org.SeatOverage = o.SeatOverage
+		}
+		if o.Suspended {
+			org.SuspendedAt = &org.CreatedAt
+		}
+
+		owner, err := addUser(org.ID, o.Owner, "owner")
+		if err != nil {
+			return err
+		}
+		org.OwnerID = owner.ID
+		for _, m := range o.Members {
+			role := m.Role
+			if role == "" {
+				role = "sub_account"
+			}
+			if role == "owner" {
+				return fmt.Errorf("huachucatest: %s cannot be a second owner", m.Email)
+			}
+			if _, err := addUser(org.ID, m, role); err != nil {
+				return err
+			}
+		}
+		orgs[org.ID] = org
+	}
+
+	for id, org := range orgs {
+		s.orgs[id] = org
+	}
+	for id, user := range users {
+		s.users[id] = user
+	}
+	return nil
+}
+
+func newOrganization(name string) *client.Organization {
+	now := now()
+	return &client.Organization{
+		ID:               uuid.NewString(),
+		Name:             name,
+		SubscriptionTier: "free",
+		MaxSubAccounts:   tierSeats["free"],
+		SeatOverage:      "block",
+		CreatedAt:        now,
+		Version:          1,
+		UpdatedAt:        now,
+	}
+}
+
+func newUser(orgID, email, name, role string) *client.User {
+	now := now()
+	return &client.User{
+		ID:             uuid.NewString(),
+		Email:          email,
+		Name:           name,
+		OrganizationID: orgID,
+		Role:           role,
+		Permissions:    map[string]bool{},
+		CreatedAt:      now,
+		Version:        1,
+		UpdatedAt:      now,
+	}
+}
@@ -0,0 +1,700 @@
+package huachucatest
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/url"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mmichie/huachuca/client"
+)
+
+// handler serves a request authenticated as me, with s.mu held
+type handler func(w http.ResponseWriter, r *http.Request, me *client.User)
+
+func (s *Server) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	started := now()
+
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, client.HealthResponse{Status: "healthy", Checks: []client.HealthCheck{}, StartTime: started, CheckTime: now()})
+	})
+	mux.HandleFunc("GET /version", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, client.BuildInfo{Version: "huachucatest", GoVersion: runtime.Version()})
+	})
+	mux.HandleFunc("GET /csrf/token", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]string{"csrf_token": randomToken()})
+	})
+	mux.HandleFunc("GET /auth/login/google", s.handleLogin)
+	mux.HandleFunc("POST /auth/token", s.handleLoginCode)
+	mux.HandleFunc("POST /auth/refresh", s.handleRefresh)
+	mux.HandleFunc("POST /auth/logout", s.handleLogout)
+
+	mux.HandleFunc("GET /me", s.authed("", false, s.handleGetMe))
+	mux.HandleFunc("DELETE /me/sessions", s.authed("", false, s.handleRevokeMySessions))
+
+	mux.HandleFunc("POST /organizations", s.authed(permCreateOrg, false, s.handleCreateOrganization))
+	mux.HandleFunc("GET /organizations/{orgID}/details", s.authed(permReadOrg, true, s.handleGetOrganization))
+	mux.HandleFunc("PATCH /organizations/{orgID}/details", s.authed(permUpdateOrg, true, s.handleRenameOrganization))
+	mux.HandleFunc("GET /organizations/{orgID}/users", s.authed(permReadOrg, true, s.handleListUsers))
+	mux.HandleFunc("GET /organizations/{orgID}/users/search", s.authed(permReadOrg, true, s.handleSearchUsers))
+	mux.HandleFunc("POST /organizations/{orgID}/users", s.authed(permInviteUser, true, s.handleAddUser))
+	mux.HandleFunc("DELETE /organizations/{orgID}/users/{userID}", s.authed(permRemoveUser, true, s.handleRemoveUser))
+	mux.HandleFunc("PUT /organizations/{orgID}/users/{userID}/role", s.authed(permUpdateUser, true, s.handleUpdateUserRole))
+	mux.HandleFunc("PATCH /organizations/{orgID}/users/{userID}/metadata", s.authed(permUpdateUser, true, s.handleUpdateUserMetadata))
+	mux.HandleFunc("DELETE /organizations/{orgID}/users/{userID}/sessions", s.authed(permUpdateUser, true, s.handleRevokeUserSessions))
+
+	mux.HandleFunc("GET /admin/organizations", s.authed(permPlatformAdmin, false, s.handleAdminListOrganizations))
+	mux.HandleFunc("POST /admin/organizations/{orgID}/suspend", s.authed(permPlatformAdmin, false, s.handleAdminSuspend(true)))
+	mux.HandleFunc("POST /admin/organizations/{orgID}/unsuspend", s.authed(permPlatformAdmin, false, s.handleAdminSuspend(false)))
+	mux.HandleFunc("PUT /admin/organizations/{orgID}/tier", s.authed(permPlatformAdmin, false, s.handleAdminUpdateTier))
+	mux.HandleFunc("DELETE /admin/organizations/{orgID}", s.authed(permPlatformAdmin, false, s.handleAdminDeleteOrganization))
+	mux.HandleFunc("GET /admin/search", s.authed(permPlatformAdmin, false, s.handleAdminSearch))
+	mux.HandleFunc("GET /admin/users", s.authed(permPlatformAdmin, false, s.handleAdminSearchUsers))
+	mux.HandleFunc("DELETE /admin/users/{userID}", s.authed(permPlatformAdmin, false, s.handleAdminDeleteUser))
+	mux.HandleFunc("GET /admin/stats", s.authed(permPlatformAdmin, false, s.handleAdminStats))
+	return mux
+}
+
+// authed authenticates requests as the API does, then checks the user has
+// perm and, with sameOrg, belongs to the {orgID} organization
+func (s *Server) authed(perm string, sameOrg bool, h handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		auth := r.Header.Get("Authorization")
+		if auth == "" {
+			http.Error(w, "Authorization header required", http.StatusUnauthorized)
+			return
+		}
+		token, ok := strings.CutPrefix(auth, "Bearer ")
+		if !ok {
+			http.Error(w, "Invalid authorization header", http.StatusUnauthorized)
+			return
+		}
+		a, ok := s.access[token]
+		if !ok || !time.Now().Before(a.expiry) {
+			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			return
+		}
+		me, ok := s.users[a.userID]
+		if !ok {
+			http.Error(w, "User not found", http.StatusUnauthorized)
+			return
+		}
+		if org := s.orgs[me.OrganizationID]; org != nil && org.SuspendedAt != nil {
+			http.Error(w, "Organization suspended", http.StatusForbidden)
+			return
+		}
+
+		if perm != "" && !hasPermission(me, perm) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if sameOrg && r.PathValue("orgID") != me.OrganizationID {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		h(w, r, me)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// readJSON decodes the request body, answering 400 if it cannot
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// paginate answers with a page of items and their total count
+func paginate[T any](w http.ResponseWriter, r *http.Request, items []T) {
+	limit, offset := 50, 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "Invalid pagination parameters", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, 200)
+	}
+	if v := r.URL.Query().Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "Invalid pagination parameters", http.StatusBadRequest)
+			return
+		}
+		offset = n
+	}
+
+	page := []T{}
+	if offset < len(items) {
+		page = items[offset:min(offset+limit, len(items))]
+	}
+	w.Header().Set("X-Total-Count", strconv.Itoa(len(items)))
+	writeJSON(w, page)
+}
+
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || redirect.Scheme != "http" || redirect.Port() == "" ||
+		q.Get("code_challenge_method") != "S256" || len(q.Get("code_challenge")) != 43 {
+		http.Error(w, "Invalid redirect_uri or code_challenge", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user := s.userByEmail(s.loginAs)
+	if user == nil {
+		http.Error(w, "Authentication failed", http.StatusInternalServerError)
+		return
+	}
+
+	code := randomToken()
+	s.codes[code] = loginCode{userID: user.ID, challenge: q.Get("code_challenge")}
+	params := redirect.Query()
+	params.Set("code", code)
+	if state := q.Get("state"); state != "" {
+		params.Set("state", state)
+	}
+	redirect.RawQuery = params.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (s *Server) handleLoginCode(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Code         string `json:"code"`
+		CodeVerifier string `json:"code_verifier"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	code, ok := s.codes[req.Code]
+	sum := sha256.Sum256([]byte(req.CodeVerifier))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != code.challenge {
+		http.Error(w, "Invalid or expired login code", http.StatusBadRequest)
+		return
+	}
+	delete(s.codes, req.Code)
+	writeJSON(w, s.issue(code.userID))
+}
+
+func (s *Server) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	userID, ok := s.refresh[req.RefreshToken]
+	if !ok {
+		http.Error(w, "Invalid or expired refresh token", http.StatusUnauthorized)
+		return
+	}
+	delete(s.refresh, req.RefreshToken)
+	writeJSON(w, s.issue(userID))
+}
+
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.refresh, req.RefreshToken)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// revokeSessions revokes a user's refresh tokens. Access tokens last until
+// they expire, as they do in a deployment.
+func (s *Server) revokeSessions(userID string) {
+	for token, id := range s.refresh {
+		if id == userID {
+			delete(s.refresh, token)
+		}
+	}
+}
+
+func (s *Server) handleGetMe(w http.ResponseWriter, r *http.Request, me *client.User) {
+	writeJSON(w, me)
+}
+
+func (s *Server) handleRevokeMySessions(w http.ResponseWriter, r *http.Request, me *client.User) {
+	s.revokeSessions(me.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleCreateOrganization(w http.ResponseWriter, r *http.Request, me *client.User) {
+	var req struct {
+		Name       string `json:"name"`
+		OwnerEmail string `json:"owner_email"`
+		OwnerName  string `json:"owner_name"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	for field, value := range map[string]string{"name": req.Name, "owner_email": req.OwnerEmail, "owner_name": req.OwnerName} {
+		if strings.TrimSpace(value) == "" {
+			http.Error(w, field+": required field is empty", http.StatusBadRequest)
+			return
+		}
+	}
+	if !strings.Contains(req.OwnerEmail, "@") {
+		http.Error(w, "owner_email: invalid email format", http.StatusBadRequest)
+		return
+	}
+	if s.userByEmail(req.OwnerEmail) != nil {
+		http.Error(w, "email already taken", http.StatusConflict)
+		return
+	}
+
+	org := newOrganization(req.Name)
+	owner := newUser(org.ID, req.OwnerEmail, req.OwnerName, "owner")
+	org.OwnerID = owner.ID
+	s.orgs[org.ID] = org
+	s.users[owner.ID] = owner
+	writeJSON(w, org)
+}
+
+func (s *Server) handleGetOrganization(w http.ResponseWriter, r *http.Request, me *client.User) {
+	org, ok := s.orgs[r.PathValue("orgID")]
+	if !ok {
+		http.Error(w, "organization not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("ETag", strconv.Quote(strconv.Itoa(org.Version)))
+	w.Header().Set("Cache-Control", "private, max-age=30")
+	writeJSON(w, org)
+}
+
+func (s *Server) handleRenameOrganization(w http.ResponseWriter, r *http.Request, me *client.User) {
+	org, ok := s.orgs[r.PathValue("orgID")]
+	if !ok {
+		http.Error(w, "organization not found", http.StatusNotFound)
+		return
+	}
+	version, ok := ifMatchVersion(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Name string `json:"name"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	switch {
+	case req.Name == "":
+		http.Error(w, "name: field cannot be empty", http.StatusBadRequest)
+		return
+	case version != org.Version:
+		http.Error(w, "modified by another request", http.StatusConflict)
+		return
+	}
+
+	org.Name = req.Name
+	org.Version++
+	org.UpdatedAt = now()
+	w.Header().Set("ETag", strconv.Quote(strconv.Itoa(org.Version)))
+	writeJSON(w, org)
+}
+
+// ifMatchVersion reads the version an update expects from If-Match as the
+// API does, responding if it cannot
+func ifMatchVersion(w http.ResponseWriter, r *http.Request) (int, bool) {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		http.Error(w, "If-Match header required", http.StatusPreconditionRequired)
+		return 0, false
+	}
+	version, err := strconv.Atoi(strings.Trim(strings.TrimPrefix(ifMatch, "W/"), `"`))
+	if err != nil || version < 1 {
+		http.Error(w, "Invalid If-Match header", http.StatusBadRequest)
+		return 0, false
+	}
+	return version, true
+}
+
+// members lists an organization's users ordered by email
+func (s *Server) members(orgID string) []*client.User {
+	var users []*client.User
+	for _, u := range s.users {
+		if orgID == "" || u.OrganizationID == orgID {
+			users = append(users, u)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Email < users[j].Email })
+	return users
+}
+
+// subAccounts counts an organization's billable seats
+func (s *Server) subAccounts(orgID string) int {
+	n := 0
+	for _, u := range s.members(orgID) {
+		if u.Role == "sub_account" {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *Server) handleListUsers(w http.ResponseWriter, r *http.Request, me *client.User) {
+	paginate(w, r, s.members(r.PathValue("orgID")))
+}
+
+// handleSearchUsers ranks members as the server does: those whose email or
+// name is q first, then those starting with it, then those containing it
+func (s *Server) handleSearchUsers(w http.ResponseWriter, r *http.Request, me *client.User) {
+	q := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("q")))
+	if q == "" {
+		http.Error(w, "q: required field is empty", http.StatusBadRequest)
+		return
+	}
+	rank := func(u *client.User) int {
+		best := 0
+		for _, v := range []string{strings.ToLower(u.Email), strings.ToLower(u.Name)} {
+			switch {
+			case v == q:
+				best = max(best, 3)
+			case strings.HasPrefix(v, q):
+				best = max(best, 2)
+			case strings.Contains(v, q):
+				best = max(best, 1)
+			}
+		}
+		return best
+	}
+	var users []*client.User
+	for _, u := range s.members(r.PathValue("orgID")) {
+		if rank(u) > 0 {
+			users = append(users, u)
+		}
+	}
+	sort.SliceStable(users, func(i, j int) bool { return rank(users[i]) > rank(users[j]) })
+	paginate(w, r, users)
+}
+
+func (s *Server) handleAddUser(w http.ResponseWriter, r *http.Request, me *client.User) {
+	var req struct {
+		Email string `json:"email"`
+		Name  string `json:"name"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if !strings.Contains(req.Email, "@") {
+		http.Error(w, "email: invalid email format", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.Name) == "" {
+		http.Error(w, "name: required field is empty", http.StatusBadRequest)
+		return
+	}
+	if s.userByEmail(req.Email) != nil {
+		http.Error(w, "email already taken", http.StatusConflict)
+		return
+	}
+	org := s.orgs[me.OrganizationID]
+	if org.SeatOverage != "allow" && s.subAccounts(org.ID) >= org.MaxSubAccounts {
+		http.Error(w, "maximum sub-accounts reached", http.StatusForbidden)
+		return
+	}
+
+	user := newUser(org.ID, req.Email, req.Name, "sub_account")
+	s.users[user.ID] = user
+	writeJSON(w, user)
+}
+
+// member finds the {userID} member of the {orgID} organization, answering
+// 404 for users of other organizations
+func (s *Server) member(w http.ResponseWriter, r *http.Request) (*client.User, bool) {
+	user, ok := s.users[r.PathValue("userID")]
+	if !ok || user.OrganizationID != r.PathValue("orgID") {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return nil, false
+	}
+	return user, true
+}
+
+// deleteUser removes a user and their sessions, refusing owners
+func (s *Server) deleteUser(w http.ResponseWriter, user *client.User) {
+	if user.Role == "owner" {
+		http.Error(w, "organization owner cannot be deleted", http.StatusConflict)
+		return
+	}
+	delete(s.users, user.ID)
+	s.revokeSessions(user.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleRemoveUser(w http.ResponseWriter, r *http.Request, me *client.User) {
+	if user, ok := s.member(w, r); ok {
+		s.deleteUser(w, user)
+	}
+}
+
+func (s *Server) handleUpdateUserRole(w http.ResponseWriter, r *http.Request, me *client.User) {
+	user, ok := s.member(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Role string `json:"role"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	switch {
+	case req.Role != "admin" && req.Role != "sub_account":
+		http.Error(w, "unknown role", http.StatusBadRequest)
+		return
+	case user.Role == "owner":
+		http.Error(w, "the owner's role cannot be changed", http.StatusConflict)
+		return
+	}
+	org := s.orgs[user.OrganizationID]
+	if req.Role == "sub_account" && user.Role != "sub_account" &&
+		org.SeatOverage != "allow" && s.subAccounts(org.ID) >= org.MaxSubAccounts {
+		http.Error(w, "maximum sub-accounts reached", http.StatusForbidden)
+		return
+	}
+	if user.Role != req.Role {
+		user.Role = req.Role
+		user.Version++
+		user.UpdatedAt = now()
+	}
+	writeJSON(w, user)
+}
+
+// handleUpdateUserMetadata merges the patch into the member's metadata. It
+// does not apply organizations' metadata schemas.
+func (s *Server) handleUpdateUserMetadata(w http.ResponseWriter, r *http.Request, me *client.User) {
+	user, ok := s.member(w, r)
+	if !ok {
+		return
+	}
+	version, ok := ifMatchVersion(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Metadata map[string]any `json:"metadata"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if version != user.Version {
+		http.Error(w, "modified by another request", http.StatusConflict)
+		return
+	}
+
+	metadata := maps.Clone(user.Metadata)
+	if metadata == nil {
+		metadata = map[string]any{}
+	}
+	for key, value := range req.Metadata {
+		if value == nil {
+			delete(metadata, key)
+		} else {
+			metadata[key] = value
+		}
+	}
+	user.Metadata = metadata
+	user.Version++
+	user.UpdatedAt = now()
+	w.Header().Set("ETag", strconv.Quote(strconv.Itoa(user.Version)))
+	writeJSON(w, user)
+}
+
+func (s *Server) handleRevokeUserSessions(w http.ResponseWriter, r *http.Request, me *client.User) {
+	if user, ok := s.member(w, r); ok {
+		s.revokeSessions(user.ID)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *Server) handleAdminListOrganizations(w http.ResponseWriter, r *http.Request, me *client.User) {
+	orgs := make([]*client.Organization, 0, len(s.orgs))
+	for _, org := range s.orgs {
+		orgs = append(orgs, org)
+	}
+	sort.Slice(orgs, func(i, j int) bool {
+		if !orgs[i].CreatedAt.Equal(orgs[j].CreatedAt) {
+			return orgs[i].CreatedAt.After(orgs[j].CreatedAt)
+		}
+		return orgs[i].ID < orgs[j].ID
+	})
+	paginate(w, r, orgs)
+}
+
+// adminOrganization finds the {orgID} organization, answering 404 if there
+// is none
+func (s *Server) adminOrganization(w http.ResponseWriter, r *http.Request) (*client.Organization, bool) {
+	org, ok := s.orgs[r.PathValue("orgID")]
+	if !ok {
+		http.Error(w, "organization not found", http.StatusNotFound)
+	}
+	return org, ok
+}
+
+func (s *Server) handleAdminSuspend(suspend bool) handler {
+	return func(w http.ResponseWriter, r *http.Request, me *client.User) {
+		org, ok := s.adminOrganization(w, r)
+		if !ok {
+			return
+		}
+		switch {
+		case !suspend:
+			org.SuspendedAt = nil
+		case org.SuspendedAt == nil:
+			t := now()
+			org.SuspendedAt = &t
+		}
+		org.Version++
+		org.UpdatedAt = now()
+		writeJSON(w, org)
+	}
+}
+
+func (s *Server) handleAdminUpdateTier(w http.ResponseWriter, r *http.Request, me *client.User) {
+	org, ok := s.adminOrganization(w, r)
+	if !ok {
+		return
+	}
+	version, ok := ifMatchVersion(w, r)
+	if !ok {
+		return
+	}
+	var req client.TierUpdate
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	seats, ok := tierSeats[req.SubscriptionTier]
+	switch {
+	case req.MaxSubAccounts < 0:
+		http.Error(w, "max_sub_accounts: must not be negative", http.StatusBadRequest)
+		return
+	case !ok:
+		http.Error(w, "unknown subscription tier", http.StatusBadRequest)
+		return
+	case req.SeatOverage != "" && req.SeatOverage != "block" && req.SeatOverage != "allow":
+		http.Error(w, "unknown seat overage policy", http.StatusBadRequest)
+		return
+	case version != org.Version:
+		http.Error(w, "modified by another request", http.StatusConflict)
+		return
+	}
+
+	org.SubscriptionTier = req.SubscriptionTier
+	org.MaxSubAccounts = seats
+	if req.MaxSubAccounts > 0 {
+		org.MaxSubAccounts = req.MaxSubAccounts
+	}
+	if req.SeatOverage != "" {
+		org.SeatOverage = req.SeatOverage
+	}
+	org.Version++
+	org.UpdatedAt = now()
+	w.Header().Set("ETag", strconv.Quote(strconv.Itoa(org.Version)))
+	writeJSON(w, org)
+}
+
+func (s *Server) handleAdminDeleteOrganization(w http.ResponseWriter, r *http.Request, me *client.User) {
+	org, ok := s.adminOrganization(w, r)
+	if !ok {
+		return
+	}
+	for _, u := range s.members(org.ID) {
+		delete(s.users, u.ID)
+		s.revokeSessions(u.ID)
+	}
+	delete(s.orgs, org.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleAdminSearchUsers(w http.ResponseWriter, r *http.Request, me *client.User) {
+	q := strings.ToLower(r.URL.Query().Get("q"))
+	var users []*client.User
+	for _, u := range s.members("") {
+		if strings.Contains(strings.ToLower(u.Email), q) || strings.Contains(strings.ToLower(u.Name), q) {
+			users = append(users, u)
+		}
+	}
+	paginate(w, r, users)
+}
+
+// handleAdminSearch matches IDs, emails, names and slugs as the server
+// does, without its ranking
+func (s *Server) handleAdminSearch(w http.ResponseWriter, r *http.Request, me *client.User) {
+	q := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("q")))
+	if q == "" {
+		http.Error(w, "q: required field is empty", http.StatusBadRequest)
+		return
+	}
+	results := client.SearchResults{Users: []client.User{}, Organizations: []client.Organization{}}
+	for _, u := range s.members("") {
+		if u.ID == q || strings.Contains(strings.ToLower(u.Email), q) || strings.Contains(strings.ToLower(u.Name), q) {
+			results.Users = append(results.Users, *u)
+		}
+	}
+	for _, org := range s.orgs {
+		if org.ID == q || strings.Contains(org.Slug, q) || strings.Contains(strings.ToLower(org.Name), q) {
+			results.Organizations = append(results.Organizations, *org)
+		}
+	}
+	sort.Slice(results.Organizations, func(i, j int) bool { return results.Organizations[i].Name < results.Organizations[j].Name })
+	writeJSON(w, results)
+}
+
+func (s *Server) handleAdminDeleteUser(w http.ResponseWriter, r *http.Request, me *client.User) {
+	user, ok := s.users[r.PathValue("userID")]
+	if !ok {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	}
+	s.deleteUser(w, user)
+}
+
+func (s *Server) handleAdminStats(w http.ResponseWriter, r *http.Request, me *client.User) {
+	stats := client.PlatformStats{
+		Organizations:       len(s.orgs),
+		Users:               len(s.users),
+		ActiveSessions:      len(s.refresh),
+		OrganizationsByTier: map[string]int{},
+	}
+	for _, org := range s.orgs {
+		if org.SuspendedAt != nil {
+			stats.SuspendedOrganizations++
+		}
+		stats.OrganizationsByTier[org.SubscriptionTier]++
+	}
+	writeJSON(w, stats)
+}
@@ -0,0 +1,193 @@
+package huachucatest_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/mmichie/huachuca/client"
+	"github.com/mmichie/huachuca/client/huachucatest"
+	"github.com/stretchr/testify/require"
+)
+
+func newServer(t *testing.T) *huachucatest.Server {
+	srv := huachucatest.NewServer()
+	t.Cleanup(srv.Close)
+	fixtures, err := huachucatest.LoadFixtures("testdata/fixtures.json")
+	require.NoError(t, err)
+	require.NoError(t, srv.Seed(fixtures))
+	return srv
+}
+
+func TestSeed(t *testing.T) {
+	srv := newServer(t)
+
+	owner := srv.User("owner@acme.test")
+	require.Equal(t, "owner", owner.Role)
+	org := srv.Organization(owner.OrganizationID)
+	require.Equal(t, owner.ID, org.OwnerID)
+	require.Equal(t, 1, org.MaxSubAccounts)
+	require.Equal(t, "admin", srv.User("admin@acme.test").Role)
+	require.Equal(t, 250, srv.Organization(srv.User("ops@huachuca.test").OrganizationID).MaxSubAccounts)
+
+	// Fixtures are added together or not at all
+	err := srv.Seed(&huachucatest.Fixtures{Organizations: []huachucatest.OrganizationFixture{
+		{Name: "Initech", Owner: huachucatest.UserFixture{Email: "owner@initech.test", Name: "Owner"}},
+		{Name: "Acme again", Owner: huachucatest.UserFixture{Email: "OWNER@acme.test", Name: "Owner"}},
+	}})
+	require.ErrorContains(t, err, "taken")
+	require.Nil(t, srv.User("owner@initech.test"))
+}
+
+func TestMembers(t *testing.T) {
+	ctx := context.Background()
+	srv := newServer(t)
+	c, err := srv.Client("owner@acme.test")
+	require.NoError(t, err)
+
+	me, err := c.GetUser(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "owner@acme.test", me.Email)
+
+	users, err := c.ListUsers(ctx, me.OrganizationID, &client.ListOptions{Limit: 2})
+	require.NoError(t, err)
+	require.Equal(t, 3, users.Total)
+	require.Equal(t, "admin@acme.test", users.Users[0].Email)
+
+	found, err := c.SearchOrganizationUsers(ctx, me.OrganizationID, "ADMIN", nil)
+	require.NoError(t, err)
+	require.Equal(t, 1, found.Total)
+	require.Equal(t, "admin@acme.test", found.Users[0].Email)
+	_, err = c.SearchOrganizationUsers(ctx, me.OrganizationID, "", nil)
+	require.Error(t, err)
+
+	_, err = c.AddUser(ctx, me.OrganizationID, "new@acme.test", "New")
+	require.ErrorIs(t, err, client.ErrQuotaExceeded)
+	_, err = c.AddUser(ctx, me.OrganizationID, "member@acme.test", "Member")
+	require.ErrorIs(t, err, client.ErrEmailTaken)
+
+	require.ErrorIs(t, c.RemoveUser(ctx, me.OrganizationID, me.ID), client.ErrDeleteOwner)
+	member := srv.User("member@acme.test")
+	require.NoError(t, c.RemoveUser(ctx, me.OrganizationID, member.ID))
+	require.Nil(t, srv.User("member@acme.test"))
+
+	admin := srv.User("admin@acme.test")
+	updated, err := c.UpdateUserRole(ctx, me.OrganizationID, admin.ID, "sub_account")
+	require.NoError(t, err)
+	require.Equal(t, "sub_account", updated.Role)
+
+	tagged, err := c.UpdateUserMetadata(ctx, me.OrganizationID, admin.ID, updated.Version, map[string]any{"employee_id": "E-1042"})
+	require.NoError(t, err)
+	require.Equal(t, "E-1042", tagged.Metadata["employee_id"])
+	_, err = c.UpdateUserMetadata(ctx, me.OrganizationID, admin.ID, updated.Version, map[string]any{"employee_id": nil})
+	require.ErrorIs(t, err, client.ErrVersionConflict)
+	untagged, err := c.UpdateUserMetadata(ctx, me.OrganizationID, admin.ID, tagged.Version, map[string]any{"employee_id": nil})
+	require.NoError(t, err)
+	require.Empty(t, untagged.Metadata)
+
+	org, err := c.GetOrganization(ctx, me.OrganizationID)
+	require.NoError(t, err)
+	_, err = c.RenameOrganization(ctx, me.OrganizationID, org.Version+1, "Acme Corp")
+	require.ErrorIs(t, err, client.ErrVersionConflict)
+	renamed, err := c.RenameOrganization(ctx, me.OrganizationID, org.Version, "Acme Corp")
+	require.NoError(t, err)
+	require.Equal(t, "Acme Corp", renamed.Name)
+	require.Equal(t, org.Version+1, renamed.Version)
+
+	// Members cannot manage, or see, other organizations
+	other, err := srv.Client("ops@huachuca.test")
+	require.NoError(t, err)
+	_, err = other.ListUsers(ctx, me.OrganizationID, nil)
+	require.ErrorIs(t, err, client.ErrForbidden)
+}
+
+func TestTokens(t *testing.T) {
+	ctx := context.Background()
+	srv := newServer(t)
+	c, err := srv.Client("owner@acme.test")
+	require.NoError(t, err)
+
+	srv.ExpireAccessTokens()
+	_, err = c.GetUser(ctx)
+	require.NoError(t, err, "the expired token is refreshed")
+
+	require.NoError(t, c.RevokeSessions(ctx))
+	srv.ExpireAccessTokens()
+	_, err = c.GetUser(ctx)
+	require.ErrorIs(t, err, client.ErrUnauthorized)
+}
+
+func TestLoginWithBrowser(t *testing.T) {
+	ctx := context.Background()
+	srv := newServer(t)
+	srv.LoginAs("member@acme.test")
+
+	c := client.NewClient(srv.URL)
+	token, err := c.LoginWithBrowser(ctx, client.BrowserLogin{OpenURL: func(url string) error {
+		// Following the redirects is all the browser does
+		resp, err := http.Get(url)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}})
+	require.NoError(t, err)
+
+	c.SetAccessToken(token.AccessToken)
+	me, err := c.GetUser(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "member@acme.test", me.Email)
+}
+
+func TestAdmin(t *testing.T) {
+	ctx := context.Background()
+	srv := newServer(t)
+	c, err := srv.Client("ops@huachuca.test")
+	require.NoError(t, err)
+	orgID := srv.User("owner@acme.test").OrganizationID
+
+	// Platform operations need the platform admin permission
+	member, err := srv.Client("member@acme.test")
+	require.NoError(t, err)
+	_, err = member.PlatformStats(ctx)
+	require.ErrorIs(t, err, client.ErrForbidden)
+
+	orgs, err := c.ListOrganizations(ctx, nil, false)
+	require.NoError(t, err)
+	require.Equal(t, 2, orgs.Total)
+
+	org, err := c.SuspendOrganization(ctx, orgID)
+	require.NoError(t, err)
+	require.NotNil(t, org.SuspendedAt)
+	owner, err := srv.Client("owner@acme.test")
+	require.NoError(t, err)
+	_, err = owner.GetUser(ctx)
+	require.ErrorIs(t, err, client.ErrOrganizationSuspended)
+
+	_, err = c.UpdateTier(ctx, orgID, org.Version-1, client.TierUpdate{SubscriptionTier: "pro"})
+	require.ErrorIs(t, err, client.ErrVersionConflict)
+	org, err = c.UpdateTier(ctx, orgID, org.Version, client.TierUpdate{SubscriptionTier: "pro"})
+	require.NoError(t, err)
+	require.Equal(t, 25, org.MaxSubAccounts)
+
+	found, err := c.Search(ctx, "acme")
+	require.NoError(t, err)
+	require.Len(t, found.Users, 3)
+	require.Len(t, found.Organizations, 1)
+	found, err = c.Search(ctx, orgID)
+	require.NoError(t, err)
+	require.Empty(t, found.Users)
+	require.Equal(t, "Acme", found.Organizations[0].Name)
+
+	users, err := c.SearchUsers(ctx, "ACME", nil, false)
+	require.NoError(t, err)
+	require.Equal(t, 3, users.Total)
+
+	stats, err := c.PlatformStats(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, stats.SuspendedOrganizations)
+	require.Equal(t, 4, stats.Users)
+
+	require.NoError(t, c.DeleteOrganization(ctx, orgID))
+	require.Nil(t, srv.User("owner@acme.test"))
+}
@@ -0,0 +1,208 @@
+// Package huachucatest provides an in-memory Huachuca API for testing code
+// built on the client package, without a deployment, database or Google
+// account.
+//
+// The server answers the endpoints the client package uses, with the
+// statuses and error messages of a real deployment, so errors match the
+// client's sentinels:
+//
+//	srv := huachucatest.NewServer()
+//	defer srv.Close()
+//	srv.Seed(&huachucatest.Fixtures{Organizations: []huachucatest.OrganizationFixture{{
+//		Name:  "Acme",
+//		Owner: huachucatest.UserFixture{Email: "owner@acme.test", Name: "Owner"},
+//	}}})
+//	c, _ := srv.Client("owner@acme.test")
+//
+// It does not enforce CSRF tokens, rate limits or webhooks.
+package huachucatest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mmichie/huachuca/client"
+)
+
+// accessTokenTTL is how long issued access tokens last, as in a default
+// deployment
+const accessTokenTTL = 15 * time.Minute
+
+// Permissions, as the API names them
+const (
+	permCreateOrg     = "create:org"
+	permReadOrg       = "read:org"
+	permUpdateOrg     = "update:org"
+	permInviteUser    = "invite:user"
+	permRemoveUser    = "remove:user"
+	permUpdateUser    = "update:user"
+	permPlatformAdmin = "platform:admin"
+)
+
+// rolePermissions lists the permissions each role grants
+var rolePermissions = map[string][]string{
+	"owner":       {permCreateOrg, permReadOrg, permUpdateOrg, permInviteUser, permRemoveUser, permUpdateUser},
+	"admin":       {permReadOrg, permUpdateOrg, permInviteUser, permRemoveUser, permUpdateUser},
+	"sub_account": {permReadOrg},
+}
+
+// Server is a Huachuca API served by an httptest.Server
+type Server struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	orgs    map[string]*client.Organization
+	users   map[string]*client.User
+	access  map[string]accessToken // by token
+	refresh map[string]string      // user IDs by refresh token
+	codes   map[string]loginCode   // by code
+	loginAs string                 // the email browser logins sign in as
+}
+
+type accessToken struct {
+	userID string
+	expiry time.Time
+}
+
+type loginCode struct {
+	userID    string
+	challenge string
+}
+
+// NewServer starts a server with no organizations. Close it when done.
+func NewServer() *Server {
+	s := &Server{
+		orgs:    map[string]*client.Organization{},
+		users:   map[string]*client.User{},
+		access:  map[string]accessToken{},
+		refresh: map[string]string{},
+		codes:   map[string]loginCode{},
+	}
+	s.Server = httptest.NewServer(s.routes())
+	return s
+}
+
+// now is the time, as the API stores it
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+func randomToken() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// userByEmail finds a user; s.mu must be held
+func (s *Server) userByEmail(email string) *client.User {
+	for _, u := range s.users {
+		if strings.EqualFold(u.Email, email) {
+			return u
+		}
+	}
+	return nil
+}
+
+// issue creates a token pair for a user; s.mu must be held
+func (s *Server) issue(userID string) *client.TokenResponse {
+	tokens := &client.TokenResponse{
+		AccessToken:  randomToken(),
+		RefreshToken: randomToken(),
+		ExpiresIn:    int(accessTokenTTL.Seconds()),
+	}
+	s.access[tokens.AccessToken] = accessToken{userID: userID, expiry: time.Now().Add(accessTokenTTL)}
+	s.refresh[tokens.RefreshToken] = userID
+	return tokens
+}
+
+// Login issues tokens for the user with email, as logging in would
+func (s *Server) Login(email string) (*client.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user := s.userByEmail(email)
+	if user == nil {
+		return nil, errors.New("huachucatest: no user " + email)
+	}
+	return client.TokenFromResponse(s.issue(user.ID)), nil
+}
+
+// Client returns a client for the server signed in as the user with email.
+// Its tokens are refreshed as they expire.
+func (s *Server) Client(email string) (*client.Client, error) {
+	token, err := s.Login(email)
+	if err != nil {
+		return nil, err
+	}
+	c := client.NewClient(s.URL)
+	c.SetTokenSource(client.NewRefreshingTokenSource(c, token, nil))
+	return c, nil
+}
+
+// LoginAs makes browser logins, such as client.LoginWithBrowser, sign in
+// as the user with email instead of asking Google. Until it is called they
+// fail as if the user had no account.
+func (s *Server) LoginAs(email string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loginAs = email
+}
+
+// ExpireAccessTokens makes every access token issued so far expire, so
+// clients must refresh them
+func (s *Server) ExpireAccessTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for token, a := range s.access {
+		a.expiry = time.Now()
+		s.access[token] = a
+	}
+}
+
+// Organization returns a copy of an organization, or nil if there is none
+// with id
+func (s *Server) Organization(id string) *client.Organization {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	org, ok := s.orgs[id]
+	if !ok {
+		return nil
+	}
+	o := *org
+	return &o
+}
+
+// User returns a copy of the user with email, or nil if there is none
+func (s *Server) User(email string) *client.User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user := s.userByEmail(email)
+	if user == nil {
+		return nil
+	}
+	return copyUser(user)
+}
+
+func copyUser(user *client.User) *client.User {
+	u := *user
+	u.Permissions = make(map[string]bool, len(user.Permissions))
+	for p, ok := range user.Permissions {
+		u.Permissions[p] = ok
+	}
+	return &u
+}
+
+// hasPermission reports whether a user's role or own permissions grant perm
+func hasPermission(user *client.User, perm string) bool {
+	for _, p := range rolePermissions[user.Role] {
+		if p == perm {
+			return true
+		}
+	}
+	return user.Permissions[perm]
+}
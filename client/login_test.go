@@ -0,0 +1,120 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoginWithBrowser(t *testing.T) {
+	ctx := context.Background()
+
+	// The server sends the browser straight back to the app, as it does once
+	// Google has authenticated the user
+	var challenge string
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /auth/login/google", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		require.Equal(t, "S256", q.Get("code_challenge_method"))
+		challenge = q.Get("code_challenge")
+		redirect, _ := url.Parse(q.Get("redirect_uri"))
+		redirect.RawQuery = url.Values{"code": {"the-code"}, "state": {q.Get("state")}}.Encode()
+		http.Redirect(w, r, redirect.String(), http.StatusFound)
+	})
+	mux.HandleFunc("POST /auth/token", func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]string
+		json.NewDecoder(r.Body).Decode(&req)
+		sum := sha256.Sum256([]byte(req["code_verifier"]))
+		if req["code"] != "the-code" || base64.RawURLEncoding.EncodeToString(sum[:]) != challenge {
+			http.Error(w, "Invalid or expired login code", http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(TokenResponse{AccessToken: "access", RefreshToken: "refresh", ExpiresIn: 900})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	c := NewClient(srv.URL)
+
+	t.Run("Exchanges the code the browser brings back", func(t *testing.T) {
+		browse := func(loginURL string) error {
+			go func() {
+				resp, err := http.Get(loginURL)
+				if err == nil {
+					resp.Body.Close()
+				}
+			}()
+			return nil
+		}
+		token, err := c.LoginWithBrowser(ctx, BrowserLogin{OpenURL: browse})
+		require.NoError(t, err)
+		require.Equal(t, "access", token.AccessToken)
+		require.Equal(t, "refresh", token.RefreshToken)
+		require.WithinDuration(t, time.Now().Add(15*time.Minute), token.Expiry, time.Minute)
+	})
+
+	t.Run("Ignores redirects with another state", func(t *testing.T) {
+		browse := func(loginURL string) error {
+			u, _ := url.Parse(loginURL)
+			redirect := u.Query().Get("redirect_uri") + "?code=forged&state=wrong"
+			go func() {
+				resp, err := http.Get(redirect)
+				if err == nil {
+					resp.Body.Close()
+				}
+			}()
+			return nil
+		}
+		short, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+		defer cancel()
+		_, err := c.LoginWithBrowser(short, BrowserLogin{OpenURL: browse})
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("Reports a browser that cannot be opened", func(t *testing.T) {
+		_, err := c.LoginWithBrowser(ctx, BrowserLogin{OpenURL: func(string) error { return errors.New("no display") }})
+		require.ErrorContains(t, err, "no display")
+	})
+}
+
+func TestTokenFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "huachuca", "token.json")
+	file := &TokenFile{Path: path, Passphrase: []byte("correct horse")}
+
+	_, err := file.Load()
+	require.ErrorIs(t, err, fs.ErrNotExist)
+
+	token := &Token{AccessToken: "access", RefreshToken: "refresh", Expiry: time.Now().Add(time.Hour).Round(0)}
+	require.NoError(t, file.Save(token))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NotContains(t, string(data), "refresh")
+
+	loaded, err := file.Load()
+	require.NoError(t, err)
+	require.Equal(t, token.RefreshToken, loaded.RefreshToken)
+	require.True(t, token.Expiry.Equal(loaded.Expiry))
+
+	_, err = (&TokenFile{Path: path, Passphrase: []byte("wrong")}).Load()
+	require.ErrorIs(t, err, ErrTokenFileKey)
+
+	require.NoError(t, file.Delete())
+	require.NoError(t, file.Delete())
+	_, err = file.Load()
+	require.ErrorIs(t, err, fs.ErrNotExist)
+}
@@ -0,0 +1,179 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Organization represents a Huachuca organization
+type Organization struct {
+	ID               string     `json:"id"`
+	Name             string     `json:"name"`
+	Slug             string     `json:"slug,omitempty"`
+	OwnerID          string     `json:"owner_id"`
+	SubscriptionTier string     `json:"subscription_tier"`
+	MaxSubAccounts   int        `json:"max_sub_accounts"`
+	SeatOverage      string     `json:"seat_overage"`
+	SuspendedAt      *time.Time `json:"suspended_at,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+	Version          int        `json:"version"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+}
+
+// ListOptions selects a page of a list. Zero values use the server's
+// defaults.
+type ListOptions struct {
+	Limit  int
+	Offset int
+}
+
+// values returns the query parameters selecting the page
+func (o *ListOptions) values() url.Values {
+	q := url.Values{}
+	if o == nil {
+		return q
+	}
+	if o.Limit > 0 {
+		q.Set("limit", strconv.Itoa(o.Limit))
+	}
+	if o.Offset > 0 {
+		q.Set("offset", strconv.Itoa(o.Offset))
+	}
+	return q
+}
+
+// withQuery appends q to path, if it is not empty
+func withQuery(path string, q url.Values) string {
+	if len(q) == 0 {
+		return path
+	}
+	return path + "?" + q.Encode()
+}
+
+// totalCount reads the X-Total-Count header, falling back to n
+func totalCount(header http.Header, n int) int {
+	total, err := strconv.Atoi(header.Get("X-Total-Count"))
+	if err != nil {
+		return n
+	}
+	return total
+}
+
+// UserList is a page of an organization's members
+type UserList struct {
+	Users []User
+	Total int // members across all pages
+}
+
+func organizationPath(orgID string, elem ...string) string {
+	path := "/organizations/" + url.PathEscape(orgID)
+	for _, e := range elem {
+		path += "/" + url.PathEscape(e)
+	}
+	return path
+}
+
+// CreateOrganization creates an organization and its owner
+func (c *Client) CreateOrganization(ctx context.Context, name, ownerEmail, ownerName string) (*Organization, error) {
+	body := map[string]string{"name": name, "owner_email": ownerEmail, "owner_name": ownerName}
+	var org Organization
+	if _, err := c.do(ctx, http.MethodPost, "/organizations", body, &org); err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
+
+// GetOrganization gets an organization
+func (c *Client) GetOrganization(ctx context.Context, orgID string) (*Organization, error) {
+	var org Organization
+	if _, err := c.do(ctx, http.MethodGet, organizationPath(orgID, "details"), nil, &org); err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
+
+// RenameOrganization renames an organization, which must still be at
+// version. It fails with ErrVersionConflict if the organization has changed
+// since.
+func (c *Client) RenameOrganization(ctx context.Context, orgID string, version int, name string) (*Organization, error) {
+	var org Organization
+	header := http.Header{"If-Match": {strconv.Quote(strconv.Itoa(version))}}
+	body := map[string]string{"name": name}
+	if _, err := c.doWithHeader(ctx, http.MethodPatch, organizationPath(orgID, "details"), header, body, &org); err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
+
+// ListUsers gets a page of an organization's members, ordered by email
+func (c *Client) ListUsers(ctx context.Context, orgID string, opts *ListOptions) (*UserList, error) {
+	var users []User
+	header, err := c.do(ctx, http.MethodGet, withQuery(organizationPath(orgID, "users"), opts.values()), nil, &users)
+	if err != nil {
+		return nil, err
+	}
+	return &UserList{Users: users, Total: totalCount(header, len(users))}, nil
+}
+
+// SearchOrganizationUsers gets a page of an organization's members whose name or
+// email contains query, best matches first
+func (c *Client) SearchOrganizationUsers(ctx context.Context, orgID, query string, opts *ListOptions) (*UserList, error) {
+	q := opts.values()
+	q.Set("q", query)
+	var users []User
+	header, err := c.do(ctx, http.MethodGet, withQuery(organizationPath(orgID, "users", "search"), q), nil, &users)
+	if err != nil {
+		return nil, err
+	}
+	return &UserList{Users: users, Total: totalCount(header, len(users))}, nil
+}
+
+// AddUser adds a sub-account to an organization
+func (c *Client) AddUser(ctx context.Context, orgID, email, name string) (*User, error) {
+	var user User
+	body := map[string]string{"email": email, "name": name}
+	if _, err := c.do(ctx, http.MethodPost, organizationPath(orgID, "users"), body, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// RemoveUser removes a member from an organization. The owner cannot be
+// removed.
+func (c *Client) RemoveUser(ctx context.Context, orgID, userID string) error {
+	_, err := c.do(ctx, http.MethodDelete, organizationPath(orgID, "users", userID), nil, nil)
+	return err
+}
+
+// UpdateUserRole makes a member an "admin" or a "sub_account"
+func (c *Client) UpdateUserRole(ctx context.Context, orgID, userID, role string) (*User, error) {
+	var user User
+	body := map[string]string{"role": role}
+	if _, err := c.do(ctx, http.MethodPut, organizationPath(orgID, "users", userID, "role"), body, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// UpdateUserMetadata changes a member's metadata, provided the member is
+// still at version. Keys in metadata are set, keys set to nil are removed and
+// the rest are kept.
+func (c *Client) UpdateUserMetadata(ctx context.Context, orgID, userID string, version int, metadata map[string]any) (*User, error) {
+	var user User
+	header := http.Header{"If-Match": {strconv.Quote(strconv.Itoa(version))}}
+	body := map[string]any{"metadata": metadata}
+	if _, err := c.doWithHeader(ctx, http.MethodPatch, organizationPath(orgID, "users", userID, "metadata"), header, body, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// RevokeUserSessions signs a member out everywhere by revoking all of their
+// refresh tokens
+func (c *Client) RevokeUserSessions(ctx context.Context, orgID, userID string) error {
+	_, err := c.do(ctx, http.MethodDelete, organizationPath(orgID, "users", userID, "sessions"), nil, nil)
+	return err
+}
@@ -0,0 +1,128 @@
+package client
+
+import "context"
+
+// DefaultPageSize is how many entries iterators fetch at a time unless told
+// otherwise
+const DefaultPageSize = 100
+
+// Iterator walks a list a page at a time, so only one page is held in
+// memory:
+//
+//	it := c.IterateUsers(ctx, orgID, 0)
+//	for it.Next() {
+//		user := it.Value()
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+//
+// Pages are fetched by offset, so entries added or removed while iterating
+// can shift others between pages, making them appear twice or be missed.
+type Iterator[T any] struct {
+	ctx      context.Context
+	fetch    func(ctx context.Context, opts *ListOptions) ([]T, int, error)
+	pageSize int
+
+	page   []T
+	i      int // index of the current entry in page
+	offset int // of the next page
+	total  int
+	done   bool
+	err    error
+}
+
+func newIterator[T any](ctx context.Context, pageSize int, fetch func(context.Context, *ListOptions) ([]T, int, error)) *Iterator[T] {
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	return &Iterator[T]{ctx: ctx, fetch: fetch, pageSize: pageSize, i: -1, total: -1}
+}
+
+// Next advances to the next entry, fetching the next page when needed. It
+// returns false at the end of the list or on an error, which Err reports.
+func (it *Iterator[T]) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	if it.i+1 < len(it.page) {
+		it.i++
+		return true
+	}
+	if it.done {
+		return false
+	}
+
+	page, total, err := it.fetch(it.ctx, &ListOptions{Limit: it.pageSize, Offset: it.offset})
+	if err != nil {
+		it.err = err
+		return false
+	}
+	it.page, it.i, it.total = page, 0, total
+	it.offset += len(page)
+	// A short page is the last, whatever the total says, so shrinking lists
+	// cannot make iteration loop
+	it.done = len(page) < it.pageSize || it.offset >= total
+	return len(page) > 0
+}
+
+// Value returns the current entry
+func (it *Iterator[T]) Value() T {
+	return it.page[it.i]
+}
+
+// Err returns the error that stopped iteration, if any
+func (it *Iterator[T]) Err() error {
+	return it.err
+}
+
+// Total returns the size of the list as of the last page fetched, or -1
+// before the first
+func (it *Iterator[T]) Total() int {
+	return it.total
+}
+
+// ListAll collects the rest of an iterator's entries. It holds the whole
+// list in memory, so prefer iterating over large lists.
+func ListAll[T any](it *Iterator[T]) ([]T, error) {
+	var all []T
+	for it.Next() {
+		all = append(all, it.Value())
+	}
+	return all, it.Err()
+}
+
+// IterateUsers iterates over an organization's members, ordered by email,
+// fetching pageSize at a time, or DefaultPageSize if pageSize is zero
+func (c *Client) IterateUsers(ctx context.Context, orgID string, pageSize int) *Iterator[User] {
+	return newIterator(ctx, pageSize, func(ctx context.Context, opts *ListOptions) ([]User, int, error) {
+		list, err := c.ListUsers(ctx, orgID, opts)
+		if err != nil {
+			return nil, 0, err
+		}
+		return list.Users, list.Total, nil
+	})
+}
+
+// IterateOrganizations iterates over all organizations, newest first
+func (c *Client) IterateOrganizations(ctx context.Context, includeDeleted bool, pageSize int) *Iterator[Organization] {
+	return newIterator(ctx, pageSize, func(ctx context.Context, opts *ListOptions) ([]Organization, int, error) {
+		list, err := c.ListOrganizations(ctx, opts, includeDeleted)
+		if err != nil {
+			return nil, 0, err
+		}
+		return list.Organizations, list.Total, nil
+	})
+}
+
+// IterateSearchUsers iterates over the users across organizations whose
+// email or name matches query
+func (c *Client) IterateSearchUsers(ctx context.Context, query string, includeDeleted bool, pageSize int) *Iterator[User] {
+	return newIterator(ctx, pageSize, func(ctx context.Context, opts *ListOptions) ([]User, int, error) {
+		list, err := c.SearchUsers(ctx, query, opts, includeDeleted)
+		if err != nil {
+			return nil, 0, err
+		}
+		return list.Users, list.Total, nil
+	})
+}
@@ -0,0 +1,76 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIterator(t *testing.T) {
+	ctx := context.Background()
+
+	members := make([]User, 7)
+	for i := range members {
+		members[i] = User{ID: strconv.Itoa(i), Email: fmt.Sprintf("user%d@acme.test", i)}
+	}
+	var limits []string
+	failAt := -1
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		limits = append(limits, r.URL.Query().Get("limit"))
+		if offset == failAt {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("X-Total-Count", strconv.Itoa(len(members)))
+		json.NewEncoder(w).Encode(members[min(offset, len(members)):min(offset+limit, len(members))])
+	}))
+	defer srv.Close()
+	c := NewClient(srv.URL)
+
+	t.Run("Pages are fetched as they are reached", func(t *testing.T) {
+		limits = nil
+		it := c.IterateUsers(ctx, "o1", 3)
+		require.Equal(t, -1, it.Total())
+		var ids []string
+		for it.Next() {
+			ids = append(ids, it.Value().ID)
+			require.Equal(t, (len(ids)-1)/3+1, len(limits), "one page per three entries")
+		}
+		require.NoError(t, it.Err())
+		require.Equal(t, []string{"0", "1", "2", "3", "4", "5", "6"}, ids)
+		require.Equal(t, 7, it.Total())
+		require.Equal(t, []string{"3", "3", "3"}, limits)
+		require.False(t, it.Next())
+	})
+
+	t.Run("A full last page ends at the total", func(t *testing.T) {
+		limits = nil
+		users, err := ListAll(c.IterateUsers(ctx, "o1", 7))
+		require.NoError(t, err)
+		require.Len(t, users, 7)
+		require.Len(t, limits, 1)
+	})
+
+	t.Run("Errors stop iteration", func(t *testing.T) {
+		failAt = 4
+		defer func() { failAt = -1 }()
+		users, err := ListAll(c.IterateSearchUsers(ctx, "acme", false, 2))
+		require.ErrorIs(t, err, ErrForbidden)
+		require.Len(t, users, 4)
+	})
+
+	t.Run("Default page size", func(t *testing.T) {
+		limits = nil
+		orgs := c.IterateOrganizations(ctx, false, 0)
+		orgs.Next()
+		require.Equal(t, []string{strconv.Itoa(DefaultPageSize)}, limits)
+	})
+}
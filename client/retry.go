@@ -0,0 +1,180 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy decides which failed requests are sent again, and when.
+//
+// Requests are retried after a network error or a 429, 500, 502, 503 or
+// 504 response, but only if their method is idempotent (GET, HEAD, OPTIONS,
+// PUT and DELETE). A 429 is retried whatever the method, since the server
+// refused the request without acting on it.
+type RetryPolicy struct {
+	MaxAttempts int           // including the first; 1 disables retries
+	BaseDelay   time.Duration // the first backoff, doubling with each retry
+	MaxDelay    time.Duration // caps the backoff
+	// MaxRetryAfter is the longest Retry-After honored. Asked to wait
+	// longer, the client returns the error instead.
+	MaxRetryAfter time.Duration
+}
+
+// DefaultRetryPolicy is used by new clients
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:   3,
+	BaseDelay:     200 * time.Millisecond,
+	MaxDelay:      5 * time.Second,
+	MaxRetryAfter: 30 * time.Second,
+}
+
+// SetRetryPolicy replaces the client's retry policy
+func (c *Client) SetRetryPolicy(p RetryPolicy) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.retry = p
+}
+
+// RateLimit is the server's rate limit as reported by the RateLimit-* or
+// X-RateLimit-* response headers
+type RateLimit struct {
+	Limit     int
+	Remaining int
+	Reset     time.Duration // until the window resets
+}
+
+// RateLimit returns the rate limit reported by the latest response that
+// carried one
+func (c *Client) RateLimit() (RateLimit, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.rateLimit == nil {
+		return RateLimit{}, false
+	}
+	return *c.rateLimit, true
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+func retryableStatus(code int) bool {
+	switch code {
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// roundTrip sends the request newRequest builds, retrying it as the
+// client's policy allows. Responses other than the last are drained and
+// closed.
+func (c *Client) roundTrip(ctx context.Context, method string, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	c.mu.RLock()
+	policy, httpClient, hooks := c.retry, c.httpClient, c.hooks
+	c.mu.RUnlock()
+
+	delay := policy.BaseDelay
+	for attempt := 1; ; attempt++ {
+		req, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+		resp, err := sendAttempt(httpClient, hooks, req)
+		if resp != nil {
+			if limit, ok := parseRateLimit(resp.Header); ok {
+				c.mu.Lock()
+				c.rateLimit = limit
+				c.mu.Unlock()
+			}
+		}
+
+		retry := false
+		var wait time.Duration
+		switch {
+		case err != nil:
+			retry = idempotent(method) && ctx.Err() == nil
+		case resp.StatusCode == http.StatusTooManyRequests || (retryableStatus(resp.StatusCode) && idempotent(method)):
+			retry = true
+			if after, ok := parseRetryAfter(resp.Header, time.Now()); ok {
+				if after > policy.MaxRetryAfter {
+					retry = false
+				}
+				wait = after
+			}
+		}
+		if !retry || attempt >= policy.MaxAttempts {
+			return resp, err
+		}
+
+		if wait == 0 {
+			// Full jitter spreads out clients that failed together
+			wait = rand.N(delay) + 1
+		}
+		delay = min(2*delay, policy.MaxDelay)
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, errors.Join(ctx.Err(), err)
+		case <-timer.C:
+		}
+	}
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an
+// HTTP date
+func parseRetryAfter(h http.Header, now time.Time) (time.Duration, bool) {
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(v); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(t.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+// parseRateLimit reads the IETF RateLimit-Limit, -Remaining and -Reset
+// headers, or their common X-RateLimit- forms. Reset is taken as seconds
+// from now, unless it is large enough to be a Unix time.
+func parseRateLimit(h http.Header) (*RateLimit, bool) {
+	for _, prefix := range []string{"RateLimit-", "X-RateLimit-"} {
+		limit, err := strconv.Atoi(h.Get(prefix + "Limit"))
+		if err != nil {
+			continue
+		}
+		remaining, err := strconv.Atoi(h.Get(prefix + "Remaining"))
+		if err != nil {
+			continue
+		}
+		rl := &RateLimit{Limit: limit, Remaining: remaining}
+		if reset, err := strconv.ParseInt(h.Get(prefix+"Reset"), 10, 64); err == nil && reset >= 0 {
+			// A billion seconds is over 31 years, longer than any window
+			if reset > 1e9 {
+				rl.Reset = max(time.Until(time.Unix(reset, 0)), 0)
+			} else {
+				rl.Reset = time.Duration(reset) * time.Second
+			}
+		}
+		return rl, true
+	}
+	return nil, false
+}
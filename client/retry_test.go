@@ -0,0 +1,122 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetry(t *testing.T) {
+	ctx := context.Background()
+	fast := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond, MaxRetryAfter: 2 * time.Second}
+
+	// failing answers the first failures requests with status and headers
+	failing := func(failures int32, status int, header http.Header) (*Client, *atomic.Int32) {
+		var calls atomic.Int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if calls.Add(1) <= failures {
+				for k, v := range header {
+					w.Header()[k] = v
+				}
+				http.Error(w, http.StatusText(status), status)
+				return
+			}
+			w.Header().Set("RateLimit-Limit", "100")
+			w.Header().Set("RateLimit-Remaining", "42")
+			w.Header().Set("RateLimit-Reset", "30")
+			json.NewEncoder(w).Encode(BuildInfo{Version: "1.0.0"})
+		}))
+		t.Cleanup(srv.Close)
+		c := NewClient(srv.URL)
+		c.SetRetryPolicy(fast)
+		return c, &calls
+	}
+
+	t.Run("Retries idempotent requests on server errors", func(t *testing.T) {
+		c, calls := failing(2, http.StatusServiceUnavailable, nil)
+		info, err := c.Version(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "1.0.0", info.Version)
+		require.Equal(t, int32(3), calls.Load())
+	})
+
+	t.Run("Gives up after MaxAttempts", func(t *testing.T) {
+		c, calls := failing(5, http.StatusBadGateway, nil)
+		_, err := c.Version(ctx)
+		var apiErr *APIError
+		require.ErrorAs(t, err, &apiErr)
+		require.Equal(t, http.StatusBadGateway, apiErr.StatusCode)
+		require.Equal(t, int32(3), calls.Load())
+	})
+
+	t.Run("Does not retry non-idempotent requests on server errors", func(t *testing.T) {
+		c, calls := failing(1, http.StatusInternalServerError, nil)
+		_, err := c.CreateOrganization(ctx, "Acme", "owner@acme.test", "Owner")
+		require.Error(t, err)
+		require.Equal(t, int32(1), calls.Load())
+	})
+
+	t.Run("Retries any request refused with 429", func(t *testing.T) {
+		c, calls := failing(1, http.StatusTooManyRequests, nil)
+		require.NoError(t, c.Logout(ctx, "refresh"))
+		require.Equal(t, int32(2), calls.Load())
+	})
+
+	t.Run("Honors Retry-After", func(t *testing.T) {
+		c, calls := failing(1, http.StatusTooManyRequests, http.Header{"Retry-After": {"1"}})
+		start := time.Now()
+		_, err := c.Version(ctx)
+		require.NoError(t, err)
+		require.GreaterOrEqual(t, time.Since(start), time.Second)
+		require.Equal(t, int32(2), calls.Load())
+
+		// Waits longer than MaxRetryAfter are left to the caller
+		c, calls = failing(1, http.StatusTooManyRequests, http.Header{"Retry-After": {"60"}})
+		_, err = c.Version(ctx)
+		var apiErr *APIError
+		require.ErrorAs(t, err, &apiErr)
+		require.Equal(t, time.Minute, apiErr.RetryAfter)
+		require.Equal(t, int32(1), calls.Load())
+	})
+
+	t.Run("Stops waiting when the context ends", func(t *testing.T) {
+		c, _ := failing(1, http.StatusServiceUnavailable, http.Header{"Retry-After": {"2"}})
+		short, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		_, err := c.Version(short)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("Reports rate limits", func(t *testing.T) {
+		c, _ := failing(0, 0, nil)
+		_, ok := c.RateLimit()
+		require.False(t, ok)
+
+		_, err := c.Version(ctx)
+		require.NoError(t, err)
+		limit, ok := c.RateLimit()
+		require.True(t, ok)
+		require.Equal(t, RateLimit{Limit: 100, Remaining: 42, Reset: 30 * time.Second}, limit)
+	})
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	d, ok := parseRetryAfter(http.Header{"Retry-After": {"120"}}, now)
+	require.True(t, ok)
+	require.Equal(t, 2*time.Minute, d)
+
+	d, ok = parseRetryAfter(http.Header{"Retry-After": {now.Add(time.Minute).Format(http.TimeFormat)}}, now)
+	require.True(t, ok)
+	require.Equal(t, time.Minute, d)
+
+	_, ok = parseRetryAfter(http.Header{"Retry-After": {"soon"}}, now)
+	require.False(t, ok)
+}
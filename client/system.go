@@ -0,0 +1,55 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// BuildInfo describes the server's build
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+}
+
+// HealthCheck is the result of one of the server's health checks
+type HealthCheck struct {
+	Name     string            `json:"name"`
+	Status   string            `json:"status"`
+	Critical bool              `json:"critical"`
+	Error    string            `json:"error,omitempty"`
+	Details  map[string]string `json:"details,omitempty"`
+	Duration time.Duration     `json:"duration"`
+}
+
+// HealthResponse is the server's health
+type HealthResponse struct {
+	Status string `json:"status"`
+	BuildInfo
+	Checks    []HealthCheck `json:"checks"`
+	StartTime time.Time     `json:"start_time"`
+	CheckTime time.Time     `json:"check_time"`
+}
+
+// Health gets the server's health. An unhealthy server answers with an
+// *APIError whose StatusCode is 503. Only the status is filled in unless
+// the client is a platform admin or on one of the server's internal
+// networks.
+func (c *Client) Health(ctx context.Context) (*HealthResponse, error) {
+	var health HealthResponse
+	if _, err := c.do(ctx, http.MethodGet, "/health", nil, &health); err != nil {
+		return nil, err
+	}
+	return &health, nil
+}
+
+// Version gets the server's build details
+func (c *Client) Version(ctx context.Context) (*BuildInfo, error) {
+	var info BuildInfo
+	if _, err := c.do(ctx, http.MethodGet, "/version", nil, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
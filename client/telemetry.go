@@ -0,0 +1,103 @@
+package client
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName names the tracer and identifies the SDK in spans
+const instrumentationName = "github.com/mmichie/huachuca/client"
+
+// idSegment matches the path segments that are IDs: UUIDs and numbers
+var idSegment = regexp.MustCompile(`^([0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}|[0-9]+)$`)
+
+// route returns a request's path with its IDs replaced by {id}, keeping
+// span names and metric labels few
+func route(req *http.Request) string {
+	segments := strings.Split(req.URL.Path, "/")
+	for i, s := range segments {
+		if idSegment.MatchString(s) {
+			segments[i] = "{id}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// TracingHooks create an OpenTelemetry client span for each request, named
+// for its method and route, and send its context to the server with the
+// global propagator. A nil provider uses the global one.
+func TracingHooks(tp trace.TracerProvider) Hooks {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	tracer := tp.Tracer(instrumentationName)
+
+	return Hooks{
+		OnRequest: func(req *http.Request) *http.Request {
+			ctx, _ := tracer.Start(req.Context(), req.Method+" "+route(req),
+				trace.WithSpanKind(trace.SpanKindClient),
+				trace.WithAttributes(
+					attribute.String("http.request.method", req.Method),
+					attribute.String("http.route", route(req)),
+					attribute.String("server.address", req.URL.Hostname()),
+				),
+			)
+			req = req.WithContext(ctx)
+			otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+			return req
+		},
+		OnResponse: func(req *http.Request, resp *http.Response, err error, elapsed time.Duration) {
+			span := trace.SpanFromContext(req.Context())
+			defer span.End()
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+				return
+			}
+			span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+			if resp.StatusCode >= 400 {
+				span.SetStatus(codes.Error, http.StatusText(resp.StatusCode))
+			}
+		},
+	}
+}
+
+// MetricsHooks record each request's latency in the
+// huachuca_client_request_duration_seconds histogram, registered with reg,
+// labeled with its method, route and status code, or "error" if it was not
+// answered. Clients sharing a registry share the histogram.
+func MetricsHooks(reg prometheus.Registerer) (Hooks, error) {
+	duration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "huachuca_client_request_duration_seconds",
+		Help:    "Latency of requests to the Huachuca API by method, route and status code.",
+		Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"method", "route", "code"})
+	if err := reg.Register(duration); err != nil {
+		var registered prometheus.AlreadyRegisteredError
+		if !errors.As(err, &registered) {
+			return Hooks{}, err
+		}
+		duration = registered.ExistingCollector.(*prometheus.HistogramVec)
+	}
+
+	return Hooks{
+		OnResponse: func(req *http.Request, resp *http.Response, err error, elapsed time.Duration) {
+			code := "error"
+			if err == nil {
+				code = strconv.Itoa(resp.StatusCode)
+			}
+			duration.WithLabelValues(req.Method, route(req), code).Observe(elapsed.Seconds())
+		},
+	}, nil
+}
@@ -0,0 +1,110 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrNoRefreshToken is returned when an access token has expired and there
+// is no refresh token to renew it with
+var ErrNoRefreshToken = errors.New("huachuca: access token expired and there is no refresh token")
+
+// expiryLeeway renews tokens this long before they expire, so they do not
+// expire in flight
+const expiryLeeway = 30 * time.Second
+
+// Token is an access token with the refresh token that renews it
+type Token struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token"`
+	Expiry       time.Time `json:"expiry"` // zero if unknown
+}
+
+// TokenFromResponse makes a Token of a login or refresh response
+func TokenFromResponse(resp *TokenResponse) *Token {
+	return &Token{
+		AccessToken:  resp.AccessToken,
+		RefreshToken: resp.RefreshToken,
+		Expiry:       time.Now().Add(time.Duration(resp.ExpiresIn) * time.Second),
+	}
+}
+
+func (t *Token) expired(now time.Time) bool {
+	return !t.Expiry.IsZero() && now.Add(expiryLeeway).After(t.Expiry)
+}
+
+// TokenSource supplies the access token for each request
+type TokenSource interface {
+	Token(ctx context.Context) (*Token, error)
+}
+
+// RefreshingTokenSource renews its token through the client when it is
+// about to expire, or when the server rejects it. It is safe for
+// concurrent use: requests that find the token expired wait for a single
+// refresh and share its result.
+type RefreshingTokenSource struct {
+	client *Client
+	// onRefresh is called with each renewed token, while no other refresh
+	// can run. The server revokes a refresh token once used, so callers
+	// persisting tokens must save each new one.
+	onRefresh func(*Token)
+	now       func() time.Time
+
+	mu    sync.Mutex
+	token *Token
+}
+
+// NewRefreshingTokenSource creates a token source starting from token,
+// which may have been loaded from storage. onRefresh may be nil.
+func NewRefreshingTokenSource(c *Client, token *Token, onRefresh func(*Token)) *RefreshingTokenSource {
+	return &RefreshingTokenSource{client: c, onRefresh: onRefresh, now: time.Now, token: token}
+}
+
+// Token returns the current token, refreshing it first if it is about to
+// expire
+func (s *RefreshingTokenSource) Token(ctx context.Context) (*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.token.expired(s.now()) {
+		return s.token, nil
+	}
+	return s.refresh(ctx)
+}
+
+// Refresh renews the token after the server rejected the access token
+// rejected. If another request has renewed it since, the new token is
+// returned without refreshing again.
+func (s *RefreshingTokenSource) Refresh(ctx context.Context, rejected string) (*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token.AccessToken != rejected {
+		return s.token, nil
+	}
+	return s.refresh(ctx)
+}
+
+// refresh renews the token; s.mu must be held
+func (s *RefreshingTokenSource) refresh(ctx context.Context) (*Token, error) {
+	if s.token.RefreshToken == "" {
+		return nil, ErrNoRefreshToken
+	}
+	resp, err := s.client.RefreshToken(ctx, s.token.RefreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	token := &Token{
+		AccessToken:  resp.AccessToken,
+		RefreshToken: resp.RefreshToken,
+		Expiry:       s.now().Add(time.Duration(resp.ExpiresIn) * time.Second),
+	}
+	s.token = token
+	if s.onRefresh != nil {
+		s.onRefresh(token)
+	}
+	return token, nil
+}
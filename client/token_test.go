@@ -0,0 +1,136 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// tokenServer issues access-N/refresh-N pairs and accepts only the latest
+// access token
+type tokenServer struct {
+	mu        sync.Mutex
+	issued    int
+	refreshes atomic.Int32
+}
+
+func (ts *tokenServer) current() string {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return fmt.Sprintf("access-%d", ts.issued)
+}
+
+func (ts *tokenServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /auth/refresh", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			RefreshToken string `json:"refresh_token"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+
+		ts.mu.Lock()
+		defer ts.mu.Unlock()
+		if req.RefreshToken != fmt.Sprintf("refresh-%d", ts.issued) {
+			http.Error(w, "Invalid or expired refresh token", http.StatusUnauthorized)
+			return
+		}
+		ts.refreshes.Add(1)
+		ts.issued++
+		time.Sleep(10 * time.Millisecond) // widen the window for concurrent refreshes
+		json.NewEncoder(w).Encode(TokenResponse{
+			AccessToken:  fmt.Sprintf("access-%d", ts.issued),
+			RefreshToken: fmt.Sprintf("refresh-%d", ts.issued),
+			ExpiresIn:    900,
+		})
+	})
+	mux.HandleFunc("GET /me", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+ts.current() {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(User{ID: "u1"})
+	})
+	return mux
+}
+
+func TestRefreshingTokenSource(t *testing.T) {
+	ctx := context.Background()
+
+	setup := func(token *Token) (*Client, *tokenServer, *[]*Token) {
+		ts := &tokenServer{}
+		srv := httptest.NewServer(ts.handler())
+		t.Cleanup(srv.Close)
+
+		var saved []*Token
+		c := NewClient(srv.URL)
+		c.SetTokenSource(NewRefreshingTokenSource(c, token, func(t *Token) { saved = append(saved, t) }))
+		return c, ts, &saved
+	}
+
+	t.Run("Refreshes an expired token once for concurrent requests", func(t *testing.T) {
+		c, ts, saved := setup(&Token{AccessToken: "access-0", RefreshToken: "refresh-0", Expiry: time.Now().Add(-time.Minute)})
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 10)
+		for range 10 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := c.GetUser(ctx)
+				errs <- err
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			require.NoError(t, err)
+		}
+
+		require.Equal(t, int32(1), ts.refreshes.Load())
+		require.Len(t, *saved, 1)
+		require.Equal(t, "refresh-1", (*saved)[0].RefreshToken)
+		require.WithinDuration(t, time.Now().Add(15*time.Minute), (*saved)[0].Expiry, time.Minute)
+	})
+
+	t.Run("Refreshes a token close to expiry", func(t *testing.T) {
+		c, ts, _ := setup(&Token{AccessToken: "access-0", RefreshToken: "refresh-0", Expiry: time.Now().Add(10 * time.Second)})
+		_, err := c.GetUser(ctx)
+		require.NoError(t, err)
+		require.Equal(t, int32(1), ts.refreshes.Load())
+	})
+
+	t.Run("Refreshes and retries a rejected token", func(t *testing.T) {
+		// The token looks valid but the server has moved on
+		c, ts, _ := setup(&Token{AccessToken: "stale", RefreshToken: "refresh-0"})
+		_, err := c.GetUser(ctx)
+		require.NoError(t, err)
+		require.Equal(t, int32(1), ts.refreshes.Load())
+
+		_, err = c.GetUser(ctx)
+		require.NoError(t, err)
+		require.Equal(t, int32(1), ts.refreshes.Load())
+	})
+
+	t.Run("Fails when the refresh token is rejected", func(t *testing.T) {
+		c, _, saved := setup(&Token{AccessToken: "stale", RefreshToken: "revoked"})
+		_, err := c.GetUser(ctx)
+		var apiErr *APIError
+		require.ErrorAs(t, err, &apiErr)
+		require.Equal(t, http.StatusUnauthorized, apiErr.StatusCode)
+		require.Empty(t, *saved)
+	})
+
+	t.Run("Cannot renew without a refresh token", func(t *testing.T) {
+		c, _, _ := setup(&Token{AccessToken: "access-0", Expiry: time.Now().Add(-time.Minute)})
+		_, err := c.GetUser(ctx)
+		require.ErrorIs(t, err, ErrNoRefreshToken)
+	})
+}
@@ -0,0 +1,147 @@
+package client
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// ErrTokenFileKey is returned when a token file cannot be decrypted with
+// the passphrase given
+var ErrTokenFileKey = errors.New("huachuca: wrong passphrase or corrupt token file")
+
+// scrypt parameters recommended for interactive logins
+const (
+	scryptN   = 1 << 15
+	scryptR   = 8
+	scryptP   = 1
+	saltBytes = 16
+)
+
+// TokenFile keeps a Token on disk, encrypted with AES-256-GCM under a key
+// derived from Passphrase with scrypt. The file is only readable by its
+// owner.
+//
+// To keep the token current, save it as a RefreshingTokenSource renews it:
+//
+//	source := client.NewRefreshingTokenSource(c, token, func(t *client.Token) {
+//		if err := file.Save(t); err != nil {
+//			log.Printf("saving token: %v", err)
+//		}
+//	})
+type TokenFile struct {
+	Path       string
+	Passphrase []byte
+}
+
+type tokenFileContents struct {
+	Version    int    `json:"version"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+func (f *TokenFile) aead(salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(f.Passphrase, salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Save encrypts token and writes it, replacing the file atomically
+func (f *TokenFile) Save(token *Token) error {
+	plaintext, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	contents := tokenFileContents{Version: 1, Salt: make([]byte, saltBytes)}
+	if _, err := rand.Read(contents.Salt); err != nil {
+		return err
+	}
+	aead, err := f.aead(contents.Salt)
+	if err != nil {
+		return err
+	}
+	contents.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(contents.Nonce); err != nil {
+		return err
+	}
+	contents.Ciphertext = aead.Seal(nil, contents.Nonce, plaintext, nil)
+	data, err := json.Marshal(contents)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(f.Path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".token-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	// CreateTemp makes the file readable by its owner only
+	return os.Rename(tmp.Name(), f.Path)
+}
+
+// Load reads and decrypts the token. It returns an error satisfying
+// errors.Is(err, fs.ErrNotExist) if no token has been saved.
+func (f *TokenFile) Load() (*Token, error) {
+	data, err := os.ReadFile(f.Path)
+	if err != nil {
+		return nil, err
+	}
+	var contents tokenFileContents
+	if err := json.Unmarshal(data, &contents); err != nil {
+		return nil, ErrTokenFileKey
+	}
+	if contents.Version != 1 {
+		return nil, fmt.Errorf("huachuca: unsupported token file version %d", contents.Version)
+	}
+
+	aead, err := f.aead(contents.Salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(contents.Nonce) != aead.NonceSize() {
+		return nil, ErrTokenFileKey
+	}
+	plaintext, err := aead.Open(nil, contents.Nonce, contents.Ciphertext, nil)
+	if err != nil {
+		return nil, ErrTokenFileKey
+	}
+	var token Token
+	if err := json.Unmarshal(plaintext, &token); err != nil {
+		return nil, ErrTokenFileKey
+	}
+	return &token, nil
+}
+
+// Delete removes the file, as when logging out
+func (f *TokenFile) Delete() error {
+	err := os.Remove(f.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,184 @@
+// Package webhooks verifies and decodes the webhook deliveries Huachuca
+// sends organizations.
+//
+// A receiver reads the body, checks its signature and decodes it:
+//
+//	body, err := io.ReadAll(r.Body)
+//	if err != nil {
+//		return err
+//	}
+//	if err := webhooks.VerifySignature(body, r.Header, secret); err != nil {
+//		http.Error(w, err.Error(), http.StatusUnauthorized)
+//		return
+//	}
+//	event, err := webhooks.Parse(body)
+//	switch e := event.(type) {
+//	case *webhooks.UserCreated:
+//		// ...
+//	}
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMissingSignature = errors.New("webhooks: missing signature or timestamp")
+	ErrInvalidSignature = errors.New("webhooks: signature does not match")
+	// ErrStaleTimestamp is returned for deliveries signed too long ago, which
+	// may be replays
+	ErrStaleTimestamp = errors.New("webhooks: timestamp outside the tolerance")
+)
+
+// Headers sent with every delivery
+const (
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery" // the same on every attempt, for deduplicating retries
+	TimestampHeader = "X-Webhook-Timestamp"
+	SignatureHeader = "X-Webhook-Signature"
+)
+
+// Tolerance is how far a delivery's timestamp may be from the receiver's
+// clock
+const Tolerance = 5 * time.Minute
+
+// VerifySignature checks that payload was signed with the webhook's secret
+// no more than Tolerance ago. The signature is the hex HMAC-SHA256 of the
+// timestamp, a dot and the body.
+func VerifySignature(payload []byte, header http.Header, secret string) error {
+	return verifySignature(payload, header, secret, time.Now())
+}
+
+func verifySignature(payload []byte, header http.Header, secret string, now time.Time) error {
+	signature, ok := strings.CutPrefix(header.Get(SignatureHeader), "sha256=")
+	timestamp := header.Get(TimestampHeader)
+	if !ok || signature == "" || timestamp == "" {
+		return ErrMissingSignature
+	}
+	got, err := hex.DecodeString(signature)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return ErrInvalidSignature
+	}
+
+	// Checked after the signature, so only a genuine timestamp is judged
+	if age := now.Sub(time.Unix(unix, 0)); age > Tolerance || age < -Tolerance {
+		return ErrStaleTimestamp
+	}
+	return nil
+}
+
+// Events webhooks can subscribe to
+const (
+	EventUserCreated = "user.created"
+	EventUserRemoved = "user.removed"
+	EventOrgUpdated  = "org.updated"
+	EventLoginFailed = "login.failed"
+	EventQuota       = "quota.threshold"
+)
+
+// Event is the body every delivery shares
+type Event struct {
+	ID             string            `json:"id"` // the delivery's ID, the same on every attempt
+	Type           string            `json:"event"`
+	OrganizationID string            `json:"organization_id"`
+	ActorID        string            `json:"actor_id,omitempty"` // empty for events without a signed-in actor
+	TargetID       string            `json:"target_id,omitempty"`
+	Metadata       map[string]string `json:"metadata,omitempty"`
+	CreatedAt      time.Time         `json:"created_at"`
+}
+
+// UserCreated is delivered when a member is added to the organization
+type UserCreated struct {
+	Event
+	UserID string
+	Role   string
+}
+
+// UserRemoved is delivered when a member is removed from the organization
+type UserRemoved struct {
+	Event
+	UserID string
+}
+
+// OrgUpdated is delivered when the organization's settings, subscription,
+// retention or suspension change. Fields that did not change are empty;
+// Metadata holds everything the change recorded.
+type OrgUpdated struct {
+	Event
+	SubscriptionTier string
+	MaxSubAccounts   string
+	Retention        string
+}
+
+// LoginFailed is delivered when a member is refused a login
+type LoginFailed struct {
+	Event
+	UserID   string
+	Provider string
+	Reason   string
+}
+
+// QuotaThreshold is delivered when the organization reaches 80% or 100% of
+// its seats or of its monthly API calls
+type QuotaThreshold struct {
+	Event
+	Quota     string // seats or api_calls
+	Threshold int    // the percentage reached
+	Used      int64
+	Limit     int64
+}
+
+// Parse decodes a delivery into *UserCreated, *UserRemoved, *OrgUpdated,
+// *LoginFailed or *QuotaThreshold, or into *Event for events this package
+// does not know.
+// Verify the signature first.
+func Parse(payload []byte) (interface{}, error) {
+	var e Event
+	if err := json.Unmarshal(payload, &e); err != nil {
+		return nil, err
+	}
+
+	switch e.Type {
+	case EventUserCreated:
+		return &UserCreated{Event: e, UserID: e.TargetID, Role: e.Metadata["role"]}, nil
+	case EventUserRemoved:
+		return &UserRemoved{Event: e, UserID: e.TargetID}, nil
+	case EventOrgUpdated:
+		return &OrgUpdated{
+			Event:            e,
+			SubscriptionTier: e.Metadata["subscription_tier"],
+			MaxSubAccounts:   e.Metadata["max_sub_accounts"],
+			Retention:        e.Metadata["retention"],
+		}, nil
+	case EventLoginFailed:
+		return &LoginFailed{Event: e, UserID: e.TargetID, Provider: e.Metadata["provider"], Reason: e.Metadata["reason"]}, nil
+	case EventQuota:
+		q := &QuotaThreshold{Event: e, Quota: e.Metadata["quota"]}
+		q.Threshold, _ = strconv.Atoi(e.Metadata["threshold"])
+		q.Used, _ = strconv.ParseInt(e.Metadata["used"], 10, 64)
+		q.Limit, _ = strconv.ParseInt(e.Metadata["limit"], 10, 64)
+		return q, nil
+	default:
+		return &e, nil
+	}
+}
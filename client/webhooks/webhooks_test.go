@@ -0,0 +1,75 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// sign signs body as the server does
+func sign(secret string, timestamp int64, body []byte) http.Header {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return http.Header{
+		TimestampHeader: {strconv.FormatInt(timestamp, 10)},
+		SignatureHeader: {"sha256=" + hex.EncodeToString(mac.Sum(nil))},
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	body := []byte(`{"event":"user.created"}`)
+	now := time.Now()
+
+	require.NoError(t, VerifySignature(body, sign("whsec_1", now.Unix(), body), "whsec_1"))
+
+	require.ErrorIs(t, verifySignature(body, http.Header{}, "whsec_1", now), ErrMissingSignature)
+	require.ErrorIs(t, verifySignature(body, sign("whsec_2", now.Unix(), body), "whsec_1", now), ErrInvalidSignature)
+	require.ErrorIs(t, verifySignature([]byte(`{"event":"user.removed"}`), sign("whsec_1", now.Unix(), body), "whsec_1", now), ErrInvalidSignature)
+
+	// A replayed delivery keeps its original timestamp, and a changed
+	// timestamp breaks the signature
+	old := sign("whsec_1", now.Add(-10*time.Minute).Unix(), body)
+	require.ErrorIs(t, verifySignature(body, old, "whsec_1", now), ErrStaleTimestamp)
+	old.Set(TimestampHeader, strconv.FormatInt(now.Unix(), 10))
+	require.ErrorIs(t, verifySignature(body, old, "whsec_1", now), ErrInvalidSignature)
+}
+
+func TestParse(t *testing.T) {
+	event, err := Parse([]byte(`{
+		"id": "d1", "event": "user.created", "organization_id": "o1", "actor_id": "u1",
+		"target_id": "u2", "metadata": {"role": "sub_account"}, "created_at": "2026-01-02T03:04:05Z"
+	}`))
+	require.NoError(t, err)
+	created, ok := event.(*UserCreated)
+	require.True(t, ok)
+	require.Equal(t, "u2", created.UserID)
+	require.Equal(t, "sub_account", created.Role)
+	require.Equal(t, "o1", created.OrganizationID)
+	require.Equal(t, time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC), created.CreatedAt)
+
+	event, err = Parse([]byte(`{"event": "org.updated", "metadata": {"subscription_tier": "pro", "max_sub_accounts": "50"}}`))
+	require.NoError(t, err)
+	require.Equal(t, "pro", event.(*OrgUpdated).SubscriptionTier)
+
+	event, err = Parse([]byte(`{"event": "login.failed", "target_id": "u3", "metadata": {"reason": "organization_suspended"}}`))
+	require.NoError(t, err)
+	require.Equal(t, "organization_suspended", event.(*LoginFailed).Reason)
+
+	event, err = Parse([]byte(`{"event": "quota.threshold", "metadata": {"quota": "seats", "threshold": "80", "used": "4", "limit": "5"}}`))
+	require.NoError(t, err)
+	require.Equal(t, &QuotaThreshold{Event: event.(*QuotaThreshold).Event, Quota: "seats", Threshold: 80, Used: 4, Limit: 5}, event)
+
+	event, err = Parse([]byte(`{"event": "org.renamed"}`))
+	require.NoError(t, err)
+	require.Equal(t, "org.renamed", event.(*Event).Type)
+
+	_, err = Parse([]byte(`not json`))
+	require.Error(t, err)
+}
@@ -0,0 +1,798 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/mmichie/huachuca/client"
+)
+
+// cli carries the global flags and environment to commands
+type cli struct {
+	stdout, stderr io.Writer
+	getenv         func(string) string
+
+	profileName string
+	url         string
+	output      string
+	configDir   string
+	config      *Config
+}
+
+type command struct {
+	name    string // one or two words, as typed
+	args    string
+	summary string
+	run     func(ctx context.Context, c *cli, args []string) error
+}
+
+var commands = []*command{
+	{"config set", "<profile> [--url URL] [--output table|json]", "Create or change a profile", runConfigSet},
+	{"config use", "<profile>", "Make a profile the current one", runConfigUse},
+	{"config list", "", "List profiles", runConfigList},
+	{"login", "[--no-browser]", "Log in with Google through the browser", runLogin},
+	{"logout", "", "Revoke and forget the profile's tokens", runLogout},
+	{"whoami", "", "Show the logged in user", runWhoami},
+	{"token print", "", "Print a current access token", runTokenPrint},
+	{"org show", "[orgID]", "Show an organization, by default your own", runOrgShow},
+	{"org create", "--name NAME --owner-email EMAIL --owner-name NAME", "Create an organization and its owner", runOrgCreate},
+	{"org list", "[--limit N] [--offset N] [--include-deleted]", "List all organizations (platform admins)", runOrgList},
+	{"user list", "[--org ID] [--limit N] [--offset N]", "List an organization's members", runUserList},
+	{"user add", "--email EMAIL --name NAME [--org ID]", "Add a sub-account", runUserAdd},
+	{"user remove", "<userID> [--org ID]", "Remove a member", runUserRemove},
+	{"user role", "<userID> <admin|sub_account> [--org ID]", "Change a member's role", runUserRole},
+	{"sessions revoke", "[--user ID] [--org ID]", "Sign yourself, or a member, out everywhere", runSessionsRevoke},
+	{"admin users", "[query] [--limit N] [--offset N] [--include-deleted]", "Search users across organizations", runAdminUsers},
+	{"admin suspend", "<orgID>", "Suspend an organization", runAdminSuspend},
+	{"admin unsuspend", "<orgID>", "Reinstate a suspended organization", runAdminUnsuspend},
+	{"admin tier", "<orgID> --version N --tier TIER --max-sub-accounts N [--seat-overage block|allow]", "Change an organization's subscription", runAdminTier},
+	{"admin delete-org", "<orgID>", "Delete an organization and its members", runAdminDeleteOrg},
+	{"admin delete-user", "<userID>", "Delete a sub-account", runAdminDeleteUser},
+	{"admin stats", "", "Show platform statistics", runAdminStats},
+}
+
+// flags returns a flag set for a command, reporting errors on stderr
+func (c *cli) flags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	return fs
+}
+
+// parse parses flags wherever they appear among args, returning the
+// positional arguments, of which there must be between min and max
+func parse(fs *flag.FlagSet, args []string, min, max int) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+	if len(positional) < min || len(positional) > max {
+		return nil, errUsage
+	}
+	return positional, nil
+}
+
+func (c *cli) loadConfig() (*Config, error) {
+	if c.config == nil {
+		config, err := loadConfig(c.configDir)
+		if err != nil {
+			return nil, err
+		}
+		c.config = config
+	}
+	return c.config, nil
+}
+
+// profile returns the profile in use and its name. Profiles that were never
+// configured are empty.
+func (c *cli) profile() (string, *Profile, error) {
+	config, err := c.loadConfig()
+	if err != nil {
+		return "", nil, err
+	}
+	name := c.profileName
+	if name == "" {
+		name = config.CurrentProfile
+	}
+	if p, ok := config.Profiles[name]; ok {
+		return name, p, nil
+	}
+	return name, &Profile{}, nil
+}
+
+func (c *cli) printer() (*printer, error) {
+	_, p, err := c.profile()
+	if err != nil {
+		return nil, err
+	}
+	format := c.output
+	if format == "" {
+		format = p.Output
+	}
+	if format == "" {
+		format = outputTable
+	}
+	return &printer{w: c.stdout, format: format}, nil
+}
+
+// anonymous returns a client for the profile's deployment without tokens
+func (c *cli) anonymous() (*client.Client, error) {
+	name, p, err := c.profile()
+	if err != nil {
+		return nil, err
+	}
+	url := c.url
+	if url == "" {
+		url = p.URL
+	}
+	if url == "" {
+		return nil, fmt.Errorf("profile %q has no URL: run huachuca config set %s --url URL", name, name)
+	}
+	return client.NewClient(url), nil
+}
+
+// tokenFile is where the profile's tokens are kept
+func (c *cli) tokenFile() (*client.TokenFile, error) {
+	name, _, err := c.profile()
+	if err != nil {
+		return nil, err
+	}
+	passphrase := c.getenv("HUACHUCA_PASSPHRASE")
+	if passphrase == "" {
+		return nil, errors.New("set HUACHUCA_PASSPHRASE to encrypt and decrypt stored tokens")
+	}
+	return &client.TokenFile{Path: tokenPath(c.configDir, name), Passphrase: []byte(passphrase)}, nil
+}
+
+// client returns a client authenticated with HUACHUCA_TOKEN, or with the
+// profile's stored tokens, saving them again as they are refreshed
+func (c *cli) client() (*client.Client, client.TokenSource, error) {
+	cl, err := c.anonymous()
+	if err != nil {
+		return nil, nil, err
+	}
+	if token := c.getenv("HUACHUCA_TOKEN"); token != "" {
+		cl.SetAccessToken(token)
+		return cl, nil, nil
+	}
+
+	file, err := c.tokenFile()
+	if err != nil {
+		return nil, nil, err
+	}
+	token, err := file.Load()
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, errors.New("not logged in: run huachuca login")
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	source := client.NewRefreshingTokenSource(cl, token, func(t *client.Token) {
+		if err := file.Save(t); err != nil {
+			fmt.Fprintln(c.stderr, "huachuca: saving refreshed tokens:", err)
+		}
+	})
+	cl.SetTokenSource(source)
+	return cl, source, nil
+}
+
+// orgID returns the organization flag's value, or the user's own
+// organization
+func orgID(ctx context.Context, cl *client.Client, flagValue string) (string, error) {
+	if flagValue != "" {
+		return flagValue, nil
+	}
+	me, err := cl.GetUser(ctx)
+	if err != nil {
+		return "", err
+	}
+	return me.OrganizationID, nil
+}
+
+func userRows(users []client.User) [][]string {
+	rows := make([][]string, len(users))
+	for i, u := range users {
+		rows[i] = []string{u.ID, u.Email, u.Name, u.Role}
+	}
+	return rows
+}
+
+var userHeaders = []string{"ID", "EMAIL", "NAME", "ROLE"}
+
+func orgRows(orgs ...client.Organization) [][]string {
+	rows := make([][]string, len(orgs))
+	for i, o := range orgs {
+		suspended := ""
+		if o.SuspendedAt != nil {
+			suspended = o.SuspendedAt.Format(time.DateOnly)
+		}
+		rows[i] = []string{o.ID, o.Name, o.SubscriptionTier, strconv.Itoa(o.MaxSubAccounts), o.SeatOverage, strconv.Itoa(o.Version), suspended}
+	}
+	return rows
+}
+
+var orgHeaders = []string{"ID", "NAME", "TIER", "SEATS", "OVERAGE", "VERSION", "SUSPENDED"}
+
+func runConfigSet(ctx context.Context, c *cli, args []string) error {
+	fs := c.flags("config set")
+	url := fs.String("url", "", "API URL, e.g. https://auth.example.com")
+	output := fs.String("output", "", "default output format: table or json")
+	positional, err := parse(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	if *output != "" && *output != outputTable && *output != outputJSON {
+		return fmt.Errorf("unknown output format %q", *output)
+	}
+
+	config, err := c.loadConfig()
+	if err != nil {
+		return err
+	}
+	name := positional[0]
+	p, ok := config.Profiles[name]
+	if !ok {
+		p = &Profile{}
+		config.Profiles[name] = p
+	}
+	if *url != "" {
+		p.URL = *url
+	}
+	if *output != "" {
+		p.Output = *output
+	}
+	return config.save(c.configDir)
+}
+
+func runConfigUse(ctx context.Context, c *cli, args []string) error {
+	positional, err := parse(c.flags("config use"), args, 1, 1)
+	if err != nil {
+		return err
+	}
+	config, err := c.loadConfig()
+	if err != nil {
+		return err
+	}
+	if _, ok := config.Profiles[positional[0]]; !ok {
+		return fmt.Errorf("no profile %q", positional[0])
+	}
+	config.CurrentProfile = positional[0]
+	return config.save(c.configDir)
+}
+
+func runConfigList(ctx context.Context, c *cli, args []string) error {
+	if _, err := parse(c.flags("config list"), args, 0, 0); err != nil {
+		return err
+	}
+	config, err := c.loadConfig()
+	if err != nil {
+		return err
+	}
+	p, err := c.printer()
+	if err != nil {
+		return err
+	}
+	var rows [][]string
+	for _, name := range config.profileNames() {
+		current := ""
+		if name == config.CurrentProfile {
+			current = "*"
+		}
+		rows = append(rows, []string{current, name, config.Profiles[name].URL, config.Profiles[name].Output})
+	}
+	return p.print(config, []string{"CURRENT", "NAME", "URL", "OUTPUT"}, rows)
+}
+
+func runLogin(ctx context.Context, c *cli, args []string) error {
+	fs := c.flags("login")
+	noBrowser := fs.Bool("no-browser", false, "print the login URL instead of opening a browser")
+	if _, err := parse(fs, args, 0, 0); err != nil {
+		return err
+	}
+	cl, err := c.anonymous()
+	if err != nil {
+		return err
+	}
+	file, err := c.tokenFile()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+	token, err := cl.LoginWithBrowser(ctx, client.BrowserLogin{OpenURL: func(url string) error {
+		if !*noBrowser && client.OpenBrowser(url) == nil {
+			fmt.Fprintf(c.stderr, "Opened the login page. If no browser appeared, visit:\n\n  %s\n\n", url)
+			return nil
+		}
+		fmt.Fprintf(c.stderr, "Visit this URL to log in:\n\n  %s\n\n", url)
+		return nil
+	}})
+	if err != nil {
+		return err
+	}
+	if err := file.Save(token); err != nil {
+		return err
+	}
+
+	cl.SetAccessToken(token.AccessToken)
+	me, err := cl.GetUser(ctx)
+	if err != nil {
+		return err
+	}
+	p, err := c.printer()
+	if err != nil {
+		return err
+	}
+	p.message("Logged in as %s", me.Email)
+	return nil
+}
+
+func runLogout(ctx context.Context, c *cli, args []string) error {
+	if _, err := parse(c.flags("logout"), args, 0, 0); err != nil {
+		return err
+	}
+	cl, err := c.anonymous()
+	if err != nil {
+		return err
+	}
+	file, err := c.tokenFile()
+	if err != nil {
+		return err
+	}
+	token, err := file.Load()
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err == nil {
+		if err := cl.Logout(ctx, token.RefreshToken); err != nil {
+			return err
+		}
+	}
+	// Tokens that cannot be decrypted are forgotten all the same
+	return file.Delete()
+}
+
+func runWhoami(ctx context.Context, c *cli, args []string) error {
+	if _, err := parse(c.flags("whoami"), args, 0, 0); err != nil {
+		return err
+	}
+	cl, _, err := c.client()
+	if err != nil {
+		return err
+	}
+	me, err := cl.GetUser(ctx)
+	if err != nil {
+		return err
+	}
+	p, err := c.printer()
+	if err != nil {
+		return err
+	}
+	return p.print(me, []string{"ID", "EMAIL", "NAME", "ROLE", "ORGANIZATION"},
+		[][]string{{me.ID, me.Email, me.Name, me.Role, me.OrganizationID}})
+}
+
+func runTokenPrint(ctx context.Context, c *cli, args []string) error {
+	if _, err := parse(c.flags("token print"), args, 0, 0); err != nil {
+		return err
+	}
+	if token := c.getenv("HUACHUCA_TOKEN"); token != "" {
+		fmt.Fprintln(c.stdout, token)
+		return nil
+	}
+	_, source, err := c.client()
+	if err != nil {
+		return err
+	}
+	token, err := source.Token(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(c.stdout, token.AccessToken)
+	return nil
+}
+
+func runOrgShow(ctx context.Context, c *cli, args []string) error {
+	positional, err := parse(c.flags("org show"), args, 0, 1)
+	if err != nil {
+		return err
+	}
+	cl, _, err := c.client()
+	if err != nil {
+		return err
+	}
+	flagValue := ""
+	if len(positional) == 1 {
+		flagValue = positional[0]
+	}
+	id, err := orgID(ctx, cl, flagValue)
+	if err != nil {
+		return err
+	}
+	org, err := cl.GetOrganization(ctx, id)
+	if err != nil {
+		return err
+	}
+	p, err := c.printer()
+	if err != nil {
+		return err
+	}
+	return p.print(org, orgHeaders, orgRows(*org))
+}
+
+func runOrgCreate(ctx context.Context, c *cli, args []string) error {
+	fs := c.flags("org create")
+	name := fs.String("name", "", "organization name")
+	ownerEmail := fs.String("owner-email", "", "owner's email address")
+	ownerName := fs.String("owner-name", "", "owner's name")
+	if _, err := parse(fs, args, 0, 0); err != nil {
+		return err
+	}
+	if *name == "" || *ownerEmail == "" || *ownerName == "" {
+		return errUsage
+	}
+	cl, _, err := c.client()
+	if err != nil {
+		return err
+	}
+	org, err := cl.CreateOrganization(ctx, *name, *ownerEmail, *ownerName)
+	if err != nil {
+		return err
+	}
+	p, err := c.printer()
+	if err != nil {
+		return err
+	}
+	return p.print(org, orgHeaders, orgRows(*org))
+}
+
+// pageFlags adds --limit and --offset to fs
+func pageFlags(fs *flag.FlagSet) *client.ListOptions {
+	opts := &client.ListOptions{}
+	fs.IntVar(&opts.Limit, "limit", 0, "page size")
+	fs.IntVar(&opts.Offset, "offset", 0, "entries to skip")
+	return opts
+}
+
+func runOrgList(ctx context.Context, c *cli, args []string) error {
+	fs := c.flags("org list")
+	opts := pageFlags(fs)
+	includeDeleted := fs.Bool("include-deleted", false, "include deleted organizations")
+	if _, err := parse(fs, args, 0, 0); err != nil {
+		return err
+	}
+	cl, _, err := c.client()
+	if err != nil {
+		return err
+	}
+	list, err := cl.ListOrganizations(ctx, opts, *includeDeleted)
+	if err != nil {
+		return err
+	}
+	p, err := c.printer()
+	if err != nil {
+		return err
+	}
+	if err := p.print(list.Organizations, orgHeaders, orgRows(list.Organizations...)); err != nil {
+		return err
+	}
+	p.message("%d of %d organizations", len(list.Organizations), list.Total)
+	return nil
+}
+
+func runUserList(ctx context.Context, c *cli, args []string) error {
+	fs := c.flags("user list")
+	org := fs.String("org", "", "organization ID; defaults to your own")
+	opts := pageFlags(fs)
+	if _, err := parse(fs, args, 0, 0); err != nil {
+		return err
+	}
+	cl, _, err := c.client()
+	if err != nil {
+		return err
+	}
+	id, err := orgID(ctx, cl, *org)
+	if err != nil {
+		return err
+	}
+	list, err := cl.ListUsers(ctx, id, opts)
+	if err != nil {
+		return err
+	}
+	p, err := c.printer()
+	if err != nil {
+		return err
+	}
+	if err := p.print(list.Users, userHeaders, userRows(list.Users)); err != nil {
+		return err
+	}
+	p.message("%d of %d members", len(list.Users), list.Total)
+	return nil
+}
+
+func runUserAdd(ctx context.Context, c *cli, args []string) error {
+	fs := c.flags("user add")
+	org := fs.String("org", "", "organization ID; defaults to your own")
+	email := fs.String("email", "", "email address")
+	name := fs.String("name", "", "name")
+	if _, err := parse(fs, args, 0, 0); err != nil {
+		return err
+	}
+	if *email == "" || *name == "" {
+		return errUsage
+	}
+	cl, _, err := c.client()
+	if err != nil {
+		return err
+	}
+	id, err := orgID(ctx, cl, *org)
+	if err != nil {
+		return err
+	}
+	user, err := cl.AddUser(ctx, id, *email, *name)
+	if err != nil {
+		return err
+	}
+	p, err := c.printer()
+	if err != nil {
+		return err
+	}
+	return p.print(user, userHeaders, userRows([]client.User{*user}))
+}
+
+func runUserRemove(ctx context.Context, c *cli, args []string) error {
+	fs := c.flags("user remove")
+	org := fs.String("org", "", "organization ID; defaults to your own")
+	positional, err := parse(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	cl, _, err := c.client()
+	if err != nil {
+		return err
+	}
+	id, err := orgID(ctx, cl, *org)
+	if err != nil {
+		return err
+	}
+	if err := cl.RemoveUser(ctx, id, positional[0]); err != nil {
+		return err
+	}
+	p, err := c.printer()
+	if err != nil {
+		return err
+	}
+	p.message("Removed %s", positional[0])
+	return nil
+}
+
+func runUserRole(ctx context.Context, c *cli, args []string) error {
+	fs := c.flags("user role")
+	org := fs.String("org", "", "organization ID; defaults to your own")
+	positional, err := parse(fs, args, 2, 2)
+	if err != nil {
+		return err
+	}
+	cl, _, err := c.client()
+	if err != nil {
+		return err
+	}
+	id, err := orgID(ctx, cl, *org)
+	if err != nil {
+		return err
+	}
+	user, err := cl.UpdateUserRole(ctx, id, positional[0], positional[1])
+	if err != nil {
+		return err
+	}
+	p, err := c.printer()
+	if err != nil {
+		return err
+	}
+	return p.print(user, userHeaders, userRows([]client.User{*user}))
+}
+
+func runSessionsRevoke(ctx context.Context, c *cli, args []string) error {
+	fs := c.flags("sessions revoke")
+	user := fs.String("user", "", "member to sign out; defaults to yourself")
+	org := fs.String("org", "", "the member's organization ID; defaults to your own")
+	if _, err := parse(fs, args, 0, 0); err != nil {
+		return err
+	}
+	cl, _, err := c.client()
+	if err != nil {
+		return err
+	}
+	p, err := c.printer()
+	if err != nil {
+		return err
+	}
+
+	if *user == "" {
+		if err := cl.RevokeSessions(ctx); err != nil {
+			return err
+		}
+		p.message("Signed out everywhere; run huachuca login to log in again")
+		return nil
+	}
+	id, err := orgID(ctx, cl, *org)
+	if err != nil {
+		return err
+	}
+	if err := cl.RevokeUserSessions(ctx, id, *user); err != nil {
+		return err
+	}
+	p.message("Signed %s out everywhere", *user)
+	return nil
+}
+
+func runAdminUsers(ctx context.Context, c *cli, args []string) error {
+	fs := c.flags("admin users")
+	opts := pageFlags(fs)
+	includeDeleted := fs.Bool("include-deleted", false, "include deleted users")
+	positional, err := parse(fs, args, 0, 1)
+	if err != nil {
+		return err
+	}
+	query := ""
+	if len(positional) == 1 {
+		query = positional[0]
+	}
+	cl, _, err := c.client()
+	if err != nil {
+		return err
+	}
+	list, err := cl.SearchUsers(ctx, query, opts, *includeDeleted)
+	if err != nil {
+		return err
+	}
+	p, err := c.printer()
+	if err != nil {
+		return err
+	}
+	rows := userRows(list.Users)
+	for i, u := range list.Users {
+		rows[i] = append(rows[i], u.OrganizationID)
+	}
+	if err := p.print(list.Users, append(userHeaders, "ORGANIZATION"), rows); err != nil {
+		return err
+	}
+	p.message("%d of %d users", len(list.Users), list.Total)
+	return nil
+}
+
+// runOrgAction runs an admin action taking an organization ID
+func runOrgAction(ctx context.Context, c *cli, name string, args []string, action func(*client.Client, context.Context, string) (*client.Organization, error)) error {
+	positional, err := parse(c.flags(name), args, 1, 1)
+	if err != nil {
+		return err
+	}
+	cl, _, err := c.client()
+	if err != nil {
+		return err
+	}
+	org, err := action(cl, ctx, positional[0])
+	if err != nil {
+		return err
+	}
+	p, err := c.printer()
+	if err != nil {
+		return err
+	}
+	return p.print(org, orgHeaders, orgRows(*org))
+}
+
+func runAdminSuspend(ctx context.Context, c *cli, args []string) error {
+	return runOrgAction(ctx, c, "admin suspend", args, (*client.Client).SuspendOrganization)
+}
+
+func runAdminUnsuspend(ctx context.Context, c *cli, args []string) error {
+	return runOrgAction(ctx, c, "admin unsuspend", args, (*client.Client).UnsuspendOrganization)
+}
+
+func runAdminTier(ctx context.Context, c *cli, args []string) error {
+	fs := c.flags("admin tier")
+	version := fs.Int("version", 0, "the organization's version, as org list shows it")
+	var update client.TierUpdate
+	fs.StringVar(&update.SubscriptionTier, "tier", "", "subscription tier")
+	fs.IntVar(&update.MaxSubAccounts, "max-sub-accounts", -1, "paid seats")
+	fs.StringVar(&update.SeatOverage, "seat-overage", "", "block or allow additions past the paid seats")
+	positional, err := parse(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	if *version == 0 || update.SubscriptionTier == "" || update.MaxSubAccounts < 0 {
+		return errUsage
+	}
+	cl, _, err := c.client()
+	if err != nil {
+		return err
+	}
+	org, err := cl.UpdateTier(ctx, positional[0], *version, update)
+	if err != nil {
+		return err
+	}
+	p, err := c.printer()
+	if err != nil {
+		return err
+	}
+	return p.print(org, orgHeaders, orgRows(*org))
+}
+
+func runAdminDeleteOrg(ctx context.Context, c *cli, args []string) error {
+	positional, err := parse(c.flags("admin delete-org"), args, 1, 1)
+	if err != nil {
+		return err
+	}
+	cl, _, err := c.client()
+	if err != nil {
+		return err
+	}
+	if err := cl.DeleteOrganization(ctx, positional[0]); err != nil {
+		return err
+	}
+	p, err := c.printer()
+	if err != nil {
+		return err
+	}
+	p.message("Deleted organization %s", positional[0])
+	return nil
+}
+
+func runAdminDeleteUser(ctx context.Context, c *cli, args []string) error {
+	positional, err := parse(c.flags("admin delete-user"), args, 1, 1)
+	if err != nil {
+		return err
+	}
+	cl, _, err := c.client()
+	if err != nil {
+		return err
+	}
+	if err := cl.DeleteUser(ctx, positional[0]); err != nil {
+		return err
+	}
+	p, err := c.printer()
+	if err != nil {
+		return err
+	}
+	p.message("Deleted user %s", positional[0])
+	return nil
+}
+
+func runAdminStats(ctx context.Context, c *cli, args []string) error {
+	if _, err := parse(c.flags("admin stats"), args, 0, 0); err != nil {
+		return err
+	}
+	cl, _, err := c.client()
+	if err != nil {
+		return err
+	}
+	stats, err := cl.PlatformStats(ctx)
+	if err != nil {
+		return err
+	}
+	p, err := c.printer()
+	if err != nil {
+		return err
+	}
+	return p.print(stats, []string{"ORGANIZATIONS", "SUSPENDED", "USERS", "ACTIVE SESSIONS"}, [][]string{{
+		strconv.Itoa(stats.Organizations),
+		strconv.Itoa(stats.SuspendedOrganizations),
+		strconv.Itoa(stats.Users),
+		strconv.Itoa(stats.ActiveSessions),
+	}})
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// defaultProfile is used until another is chosen
+const defaultProfile = "default"
+
+// Config holds the CLI's profiles, each naming a deployment to talk to
+type Config struct {
+	CurrentProfile string              `json:"current_profile"`
+	Profiles       map[string]*Profile `json:"profiles"`
+}
+
+// Profile is one deployment and how to show its results
+type Profile struct {
+	URL    string `json:"url"`
+	Output string `json:"output,omitempty"` // table or json
+}
+
+// configPath is the configuration file in dir
+func configPath(dir string) string {
+	return filepath.Join(dir, "config.json")
+}
+
+// tokenPath is where a profile's encrypted tokens are kept
+func tokenPath(dir, profile string) string {
+	return filepath.Join(dir, "tokens", profile+".json")
+}
+
+// loadConfig reads the configuration, which is empty if none was saved
+func loadConfig(dir string) (*Config, error) {
+	config := &Config{CurrentProfile: defaultProfile, Profiles: map[string]*Profile{}}
+	data, err := os.ReadFile(configPath(dir))
+	if errors.Is(err, os.ErrNotExist) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", configPath(dir), err)
+	}
+	if config.Profiles == nil {
+		config.Profiles = map[string]*Profile{}
+	}
+	return config, nil
+}
+
+func (c *Config) save(dir string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(configPath(dir), append(data, '\n'), 0o600)
+}
+
+// profileNames lists the profiles in order
+func (c *Config) profileNames() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,95 @@
+// Command huachuca manages a Huachuca deployment from the command line.
+//
+// Each profile names a deployment. Logging in stores the profile's tokens
+// encrypted with HUACHUCA_PASSPHRASE, and they are refreshed as they
+// expire. HUACHUCA_TOKEN supplies an access token directly instead, as in
+// CI.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := run(ctx, os.Args[1:], os.Stdout, os.Stderr, os.Getenv); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "huachuca:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+// errUsage reports a command given the wrong arguments
+var errUsage = errors.New("invalid arguments")
+
+// run parses the global flags and runs the command they are followed by
+func run(ctx context.Context, args []string, stdout, stderr io.Writer, getenv func(string) string) error {
+	c := &cli{stdout: stdout, stderr: stderr, getenv: getenv}
+
+	fs := flag.NewFlagSet("huachuca", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&c.profileName, "profile", getenv("HUACHUCA_PROFILE"), "profile to use instead of the current one")
+	fs.StringVar(&c.url, "url", getenv("HUACHUCA_URL"), "API URL, overriding the profile's")
+	fs.StringVar(&c.output, "o", "", "output format: table or json")
+	fs.Usage = func() { c.usage(fs) }
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if c.output != "" && c.output != outputTable && c.output != outputJSON {
+		return fmt.Errorf("unknown output format %q", c.output)
+	}
+
+	dir := getenv("HUACHUCA_CONFIG_DIR")
+	if dir == "" {
+		base, err := os.UserConfigDir()
+		if err != nil {
+			return err
+		}
+		dir = filepath.Join(base, "huachuca")
+	}
+	c.configDir = dir
+
+	cmd, rest := findCommand(fs.Args())
+	if cmd == nil {
+		c.usage(fs)
+		return errUsage
+	}
+	err := cmd.run(ctx, c, rest)
+	if errors.Is(err, errUsage) {
+		fmt.Fprintf(stderr, "usage: huachuca %s %s\n", cmd.name, cmd.args)
+	}
+	return err
+}
+
+// findCommand finds the command args begin with, returning the arguments
+// after its name
+func findCommand(args []string) (*command, []string) {
+	for _, cmd := range commands {
+		words := strings.Fields(cmd.name)
+		if len(args) >= len(words) && strings.Join(args[:len(words)], " ") == cmd.name {
+			return cmd, args[len(words):]
+		}
+	}
+	return nil, nil
+}
+
+func (c *cli) usage(fs *flag.FlagSet) {
+	fmt.Fprintln(c.stderr, "usage: huachuca [flags] <command> [arguments]")
+	fmt.Fprintln(c.stderr, "\nCommands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(c.stderr, "  %-22s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(c.stderr, "\nFlags:")
+	fs.PrintDefaults()
+}
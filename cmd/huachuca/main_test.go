@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mmichie/huachuca/client"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCLI(t *testing.T) {
+	var gotAuth []string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = append(gotAuth, r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method + " " + r.URL.Path {
+		case "GET /me":
+			json.NewEncoder(w).Encode(client.User{ID: "u1", Email: "owner@acme.test", Name: "Owner", Role: "owner", OrganizationID: "o1"})
+		case "GET /organizations/o1/users":
+			require.Equal(t, "1", r.URL.Query().Get("limit"))
+			w.Header().Set("X-Total-Count", "2")
+			json.NewEncoder(w).Encode([]client.User{{ID: "u1", Email: "owner@acme.test", Name: "Owner", Role: "owner"}})
+		case "DELETE /organizations/o1/users/u2":
+			w.WriteHeader(http.StatusNoContent)
+		case "POST /auth/refresh":
+			json.NewEncoder(w).Encode(client.TokenResponse{AccessToken: "fresh", RefreshToken: "refresh-2", ExpiresIn: 900})
+		default:
+			http.Error(w, "Organization not found", http.StatusNotFound)
+		}
+	}))
+	defer api.Close()
+
+	dir := t.TempDir()
+	env := map[string]string{"HUACHUCA_CONFIG_DIR": dir}
+	huachuca := func(args ...string) (string, string, error) {
+		var stdout, stderr bytes.Buffer
+		err := run(context.Background(), args, &stdout, &stderr, func(k string) string { return env[k] })
+		return stdout.String(), stderr.String(), err
+	}
+
+	t.Run("Profiles", func(t *testing.T) {
+		_, _, err := huachuca("whoami")
+		require.ErrorContains(t, err, `profile "default" has no URL`)
+
+		_, _, err = huachuca("config", "set", "default", "--url", api.URL)
+		require.NoError(t, err)
+		_, _, err = huachuca("config", "set", "staging", "--url", "https://staging.example.com", "--output", "json")
+		require.NoError(t, err)
+		_, _, err = huachuca("config", "use", "prod")
+		require.ErrorContains(t, err, `no profile "prod"`)
+
+		out, _, err := huachuca("config", "list")
+		require.NoError(t, err)
+		require.Regexp(t, `\*\s+default\s+`+api.URL, out)
+		require.Regexp(t, `\n\s+staging\s+https://staging.example.com\s+json`, out)
+	})
+
+	t.Run("An access token from the environment", func(t *testing.T) {
+		env["HUACHUCA_TOKEN"] = "ci-token"
+		defer delete(env, "HUACHUCA_TOKEN")
+
+		out, _, err := huachuca("whoami")
+		require.NoError(t, err)
+		require.Regexp(t, `u1\s+owner@acme.test\s+Owner\s+owner\s+o1`, out)
+		require.Equal(t, "Bearer ci-token", gotAuth[len(gotAuth)-1])
+
+		out, _, err = huachuca("-o", "json", "user", "list", "--limit", "1")
+		require.NoError(t, err)
+		var users []client.User
+		require.NoError(t, json.Unmarshal([]byte(out), &users))
+		require.Len(t, users, 1)
+
+		out, _, err = huachuca("user", "remove", "u2", "--org", "o1")
+		require.NoError(t, err)
+		require.Equal(t, "Removed u2\n", out)
+
+		_, _, err = huachuca("org", "show", "o9")
+		require.ErrorIs(t, err, client.ErrNotFound)
+	})
+
+	t.Run("Stored tokens are refreshed and saved", func(t *testing.T) {
+		_, _, err := huachuca("whoami")
+		require.ErrorContains(t, err, "HUACHUCA_PASSPHRASE")
+
+		env["HUACHUCA_PASSPHRASE"] = "correct horse"
+		_, _, err = huachuca("whoami")
+		require.ErrorContains(t, err, "not logged in")
+
+		file := &client.TokenFile{Path: filepath.Join(dir, "tokens", "default.json"), Passphrase: []byte("correct horse")}
+		require.NoError(t, file.Save(&client.Token{AccessToken: "stale", RefreshToken: "refresh-1", Expiry: time.Now().Add(-time.Minute)}))
+
+		out, _, err := huachuca("token", "print")
+		require.NoError(t, err)
+		require.Equal(t, "fresh\n", out)
+
+		saved, err := file.Load()
+		require.NoError(t, err)
+		require.Equal(t, "refresh-2", saved.RefreshToken)
+	})
+
+	t.Run("Usage", func(t *testing.T) {
+		_, stderr, err := huachuca("user", "role", "u2")
+		require.ErrorIs(t, err, errUsage)
+		require.Contains(t, stderr, "usage: huachuca user role <userID> <admin|sub_account> [--org ID]")
+
+		_, stderr, err = huachuca("frobnicate")
+		require.ErrorIs(t, err, errUsage)
+		require.Contains(t, stderr, "sessions revoke")
+	})
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// Output formats
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+// printer writes results as a table or as JSON
+type printer struct {
+	w      io.Writer
+	format string
+}
+
+// print writes v as indented JSON, or as a table with headers and one row
+// per element of rows
+func (p *printer) print(v interface{}, headers []string, rows [][]string) error {
+	if p.format == outputJSON {
+		enc := json.NewEncoder(p.w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+
+	tw := tabwriter.NewWriter(p.w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(headers, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+// message writes a confirmation, which JSON output leaves out so it stays
+// machine-readable
+func (p *printer) message(format string, args ...interface{}) {
+	if p.format != outputJSON {
+		fmt.Fprintf(p.w, format+"\n", args...)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+const (
+	encodingGzip   = "gzip"
+	encodingBrotli = "br"
+
+	// brotliLevel trades some ratio for speed, which suits dynamic responses
+	brotliLevel = 5
+)
+
+var (
+	gzipWriters   = sync.Pool{New: func() interface{} { return gzip.NewWriter(io.Discard) }}
+	brotliWriters = sync.Pool{New: func() interface{} { return brotli.NewWriterLevel(io.Discard, brotliLevel) }}
+)
+
+// encoder is the common interface of the gzip and brotli writers
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// acceptedEncoding picks the preferred encoding the client accepts, favouring
+// brotli over gzip, or returns "" if it accepts neither
+func acceptedEncoding(header string) string {
+	var gzipOK, brotliOK bool
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err != nil || v == 0 {
+				continue
+			}
+		}
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case encodingBrotli:
+			brotliOK = true
+		case encodingGzip:
+			gzipOK = true
+		}
+	}
+
+	switch {
+	case brotliOK:
+		return encodingBrotli
+	case gzipOK:
+		return encodingGzip
+	default:
+		return ""
+	}
+}
+
+// compressible reports whether responses of contentType should be encoded
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// CompressionMiddleware encodes JSON responses of at least
+// config.CompressionMinSize bytes with brotli or gzip, depending on the
+// request's Accept-Encoding
+func CompressionMiddleware(config *ServerConfig) Middleware {
+	return func(next http.Handler) http.Handler {
+		if !config.Compression {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+
+			encoding := acceptedEncoding(r.Header.Get("Accept-Encoding"))
+			if encoding == "" || r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &compressWriter{ResponseWriter: w, encoding: encoding, minSize: config.CompressionMinSize}
+			defer cw.Close()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// compressWriter buffers the start of a response until it knows whether the
+// body is large enough to be worth encoding
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int
+	status   int
+	buf      []byte
+	started  bool
+	encoder  encoder // nil unless the response is being encoded
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.started || cw.status != 0 {
+		return
+	}
+	// Informational responses go straight through
+	if status >= 100 && status < 200 {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	cw.status = status
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.started {
+		cw.buf = append(cw.buf, p...)
+		if len(cw.buf) < cw.minSize {
+			return len(p), nil
+		}
+		if err := cw.start(); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	if cw.encoder != nil {
+		return cw.encoder.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// start writes the header, encoding the body if it is large enough and of a
+// compressible type, then writes out anything buffered so far
+func (cw *compressWriter) start() error {
+	cw.started = true
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+
+	h := cw.Header()
+	if len(cw.buf) >= cw.minSize && h.Get("Content-Encoding") == "" &&
+		cw.status != http.StatusNoContent && cw.status != http.StatusNotModified &&
+		compressible(h.Get("Content-Type")) {
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+		switch cw.encoding {
+		case encodingBrotli:
+			cw.encoder = brotliWriters.Get().(*brotli.Writer)
+		default:
+			cw.encoder = gzipWriters.Get().(*gzip.Writer)
+		}
+		cw.encoder.Reset(cw.ResponseWriter)
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	if cw.encoder != nil {
+		_, err := cw.encoder.Write(buf)
+		return err
+	}
+	_, err := cw.ResponseWriter.Write(buf)
+	return err
+}
+
+// Flush sends what has been written so far, deciding on the encoding early
+func (cw *compressWriter) Flush() {
+	if !cw.started {
+		cw.start()
+	}
+	if cw.encoder != nil {
+		cw.encoder.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close finishes the response once the handler has returned
+func (cw *compressWriter) Close() error {
+	if !cw.started {
+		if err := cw.start(); err != nil {
+			return err
+		}
+	}
+	if cw.encoder == nil {
+		return nil
+	}
+
+	err := cw.encoder.Close()
+	cw.encoder.Reset(io.Discard)
+	switch e := cw.encoder.(type) {
+	case *brotli.Writer:
+		brotliWriters.Put(e)
+	case *gzip.Writer:
+		gzipWriters.Put(e)
+	}
+	cw.encoder = nil
+	return err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
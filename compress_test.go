@@ -0,0 +1,84 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompression(t *testing.T) {
+	config := &ServerConfig{Compression: true, CompressionMinSize: 64}
+	large := `{"data":"` + strings.Repeat("a", 256) + `"}`
+
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		contentType    string
+		body           string
+		status         int
+		expected       string
+	}{
+		{"Large JSON with gzip", "gzip", "application/json", large, http.StatusOK, "gzip"},
+		{"Brotli preferred", "gzip, deflate, br", "application/json", large, http.StatusOK, "br"},
+		{"Rejected encoding", "br;q=0, gzip", "application/json", large, http.StatusOK, "gzip"},
+		{"Error responses are compressed", "gzip", "application/problem+json", large, http.StatusBadRequest, "gzip"},
+		{"Small JSON", "gzip", "application/json", `{"ok":true}`, http.StatusOK, ""},
+		{"Non-JSON", "gzip", "text/html", large, http.StatusOK, ""},
+		{"No Accept-Encoding", "", "application/json", large, http.StatusOK, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := CompressionMiddleware(config)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.WriteHeader(tt.status)
+				// Write in pieces to exercise buffering up to the threshold
+				io.WriteString(w, tt.body[:len(tt.body)/2])
+				io.WriteString(w, tt.body[len(tt.body)/2:])
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			require.Equal(t, tt.status, w.Code)
+			require.Equal(t, tt.expected, w.Header().Get("Content-Encoding"))
+			require.Contains(t, w.Header().Values("Vary"), "Accept-Encoding")
+
+			var body io.Reader = w.Body
+			switch tt.expected {
+			case "gzip":
+				zr, err := gzip.NewReader(w.Body)
+				require.NoError(t, err)
+				body = zr
+			case "br":
+				body = brotli.NewReader(w.Body)
+			}
+			decoded, err := io.ReadAll(body)
+			require.NoError(t, err)
+			require.Equal(t, tt.body, string(decoded))
+		})
+	}
+
+	t.Run("Disabled", func(t *testing.T) {
+		handler := CompressionMiddleware(&ServerConfig{CompressionMinSize: 64})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, large)
+		}))
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		require.Empty(t, w.Header().Get("Content-Encoding"))
+		require.Equal(t, large, w.Body.String())
+	})
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "huachuca.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := writeConfigFile(t, `
+environment: development
+database_url: memory://
+request_timeout: 5s
+csrf:
+  mode: double-submit
+  trusted_origins:
+    - https://app.example.com
+    - https://admin.example.com
+db:
+  max_open_conns: 10
+`)
+
+	config, err := LoadConfig(path, []string{"DB_MAX_OPEN_CONNS=40", "DB_MAX_IDLE_CONNS="})
+	require.NoError(t, err)
+	require.Equal(t, EnvironmentDevelopment, config.Environment)
+	require.Equal(t, "memory://", config.DatabaseURL)
+	require.Equal(t, 5*time.Second, config.Server.RequestTimeout)
+	require.Equal(t, CSRFModeDoubleSubmit, config.CSRF.Mode)
+	require.Equal(t, []string{"https://app.example.com", "https://admin.example.com"}, config.CSRF.TrustedOrigins)
+	require.Equal(t, 40, config.DB.MaxOpenConns, "the environment overrides the file")
+	require.Equal(t, 25, config.DB.MaxIdleConns, "unset and empty values keep their defaults")
+
+	t.Run("Production requires its secrets", func(t *testing.T) {
+		_, err := LoadConfig("", []string{"GOOGLE_CLIENT_ID=client"})
+		require.Error(t, err)
+		for _, key := range []string{"DATABASE_URL", "GOOGLE_CLIENT_SECRET", "GOOGLE_REDIRECT_URL", "CSRF_AUTH_KEY", "AUDIT_SIGNING_KEY"} {
+			require.Contains(t, err.Error(), key+" is required in production")
+		}
+		require.NotContains(t, err.Error(), "GOOGLE_CLIENT_ID")
+	})
+
+	t.Run("Every invalid value is reported", func(t *testing.T) {
+		_, err := LoadConfig("", []string{"ENVIRONMENT=qa", "REQUEST_TIMEOUT=soon", "DB_MAX_OPEN_CONNS=lots",
+			"LOG_LEVEL=loud", "USER_CACHE_TTL=0", "IP_ALLOWLIST_CACHE_TTL=-1s"})
+		require.ErrorContains(t, err, `invalid ENVIRONMENT "qa"`)
+		require.ErrorContains(t, err, `invalid REQUEST_TIMEOUT "soon"`)
+		require.ErrorContains(t, err, `invalid DB_MAX_OPEN_CONNS "lots"`)
+		require.ErrorContains(t, err, `invalid LOG_LEVEL "loud"`)
+		require.ErrorContains(t, err, `invalid USER_CACHE_TTL "0"`)
+		require.ErrorContains(t, err, `invalid IP_ALLOWLIST_CACHE_TTL "-1s"`)
+	})
+
+	t.Run("Invalid files", func(t *testing.T) {
+		development := []string{"ENVIRONMENT=" + EnvironmentDevelopment}
+		for name, content := range map[string]string{
+			"syntax":      "csrf: [",
+			"set twice":   "csrf_mode: gorilla\ncsrf:\n  mode: gorilla\n",
+			"key":         "csrf:\n  auth key: x\n",
+			"nested list": "trusted_proxies:\n  - {cidr: 10.0.0.0/8}\n",
+		} {
+			_, err := LoadConfig(writeConfigFile(t, content), development)
+			require.ErrorContains(t, err, "parsing", name)
+		}
+
+		_, err := LoadConfig(filepath.Join(t.TempDir(), "missing.yaml"), development)
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+}
@@ -1,87 +1,256 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"log/slog"
 	"net/http"
-	"os"
+	"net/url"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 type CORSConfig struct {
-	AllowedOrigins []string
+	AllowedOrigins []string // exact origins, https://*.example.com wildcards or regex:<expr>
 	AllowedMethods []string
 	AllowedHeaders []string
-	MaxAge         int // in seconds
+	MaxAge         int           // in seconds
+	OrgOriginsTTL  time.Duration // how long origins registered by organizations are cached
 }
 
-func NewCORSConfig() *CORSConfig {
+func NewCORSConfig(settings Settings) *CORSConfig {
 	var allowedOrigins []string
-	originsEnv := os.Getenv("ALLOWED_ORIGINS")
-
-	if originsEnv == "" {
-		// Development defaults - strictly limit to localhost
-		allowedOrigins = []string{"http://localhost:3000", "http://127.0.0.1:3000"}
-	} else {
-		// Production - split and validate origins
-		origins := strings.Split(originsEnv, ",")
-		for _, origin := range origins {
-			origin = strings.TrimSpace(origin)
-			if origin != "" && origin != "*" { // Explicitly prevent wildcard
-				allowedOrigins = append(allowedOrigins, origin)
-			}
+	for _, origin := range splitList(settings("ALLOWED_ORIGINS")) {
+		// Invalid patterns, including a bare "*", are dropped
+		if _, err := parseOriginPattern(origin); err == nil {
+			allowedOrigins = append(allowedOrigins, origin)
 		}
+	}
 
-		// If no valid origins were provided, use a safe default
-		if len(allowedOrigins) == 0 {
-			allowedOrigins = []string{"http://localhost:3000"}
-		}
+	// Fall back to the profile's defaults if nothing valid was configured
+	if len(allowedOrigins) == 0 {
+		allowedOrigins = profileFor(settings).AllowedOrigins
+	}
+
+	orgOriginsTTL, err := time.ParseDuration(settings.get("CORS_ORG_ORIGINS_TTL", "1m"))
+	if err != nil || orgOriginsTTL < 0 {
+		orgOriginsTTL = time.Minute
 	}
 
 	return &CORSConfig{
 		AllowedOrigins: allowedOrigins,
-		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowedHeaders: []string{
 			"Authorization",
 			"Content-Type",
 			"X-Requested-With",
 			"Accept",
 			"Origin",
+			"If-Match",
+			"If-None-Match",
+			RequestIDHeader,
+			BreakGlassHeader,
 		},
-		MaxAge: 86400, // 24 hours
+		MaxAge:        86400, // 24 hours
+		OrgOriginsTTL: orgOriginsTTL,
+	}
+}
+
+// originPattern matches request Origin values against one allowed origin
+type originPattern struct {
+	exact  string
+	regexp *regexp.Regexp
+}
+
+func (p *originPattern) match(origin string) bool {
+	if p.regexp != nil {
+		return p.regexp.MatchString(origin)
+	}
+	return p.exact == origin
+}
+
+// parseOriginPattern parses an allowed origin. It accepts exact origins
+// (https://app.example.com), a wildcard for the leftmost subdomain label
+// (https://*.example.com), a wildcard port (http://localhost:*) and regular
+// expressions prefixed with "regex:", which are anchored to the whole origin.
+// A bare "*" is rejected because credentials are always allowed.
+func parseOriginPattern(pattern string) (*originPattern, error) {
+	if expr, ok := strings.CutPrefix(pattern, "regex:"); ok {
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid origin regex %q: %w", expr, err)
+		}
+		return &originPattern{regexp: re}, nil
+	}
+
+	if pattern == "*" {
+		return nil, fmt.Errorf("wildcard origin %q is not allowed with credentials", pattern)
+	}
+
+	scheme, rest, ok := strings.Cut(pattern, "://")
+	if !ok || (scheme != "http" && scheme != "https") || rest == "" || strings.ContainsAny(rest, "/?#@") {
+		return nil, fmt.Errorf("invalid origin %q: expected scheme://host[:port]", pattern)
+	}
+
+	host, port, hasPort := strings.Cut(rest, ":")
+	wildcardHost := strings.HasPrefix(host, "*.")
+	wildcardPort := hasPort && port == "*"
+	if hasPort && !wildcardPort {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return nil, fmt.Errorf("invalid origin %q: bad port", pattern)
+		}
+	}
+
+	suffix := strings.TrimPrefix(host, "*.")
+	if suffix == "" || strings.Contains(suffix, "*") {
+		return nil, fmt.Errorf("invalid origin %q: wildcard is only allowed as the leftmost label", pattern)
+	}
+	// *.com would match every site under a public suffix
+	if wildcardHost && !strings.Contains(suffix, ".") {
+		return nil, fmt.Errorf("invalid origin %q: wildcard domain is too broad", pattern)
+	}
+	if _, err := url.Parse(scheme + "://" + suffix); err != nil {
+		return nil, fmt.Errorf("invalid origin %q: %w", pattern, err)
+	}
+
+	if !wildcardHost && !wildcardPort {
+		return &originPattern{exact: pattern}, nil
+	}
+
+	expr := "^" + regexp.QuoteMeta(scheme+"://")
+	if wildcardHost {
+		// A single DNS label, so *.example.com matches neither example.com
+		// nor a.b.example.com
+		expr += `[a-z0-9]([a-z0-9-]*[a-z0-9])?\.`
+	}
+	expr += regexp.QuoteMeta(suffix)
+	if wildcardPort {
+		expr += `(:[0-9]{1,5})?`
+	} else if hasPort {
+		expr += regexp.QuoteMeta(":" + port)
 	}
+	return &originPattern{regexp: regexp.MustCompile(expr + "$")}, nil
+}
+
+// OrgOriginCache holds the origins organizations have registered in their
+// settings, reloading them from the database once they are older than ttl
+type OrgOriginCache struct {
+	mu       sync.Mutex
+	origins  map[string]bool
+	loadedAt time.Time
+	ttl      time.Duration
+	load     func(ctx context.Context) ([]string, error)
+	logger   *slog.Logger
+	now      func() time.Time
+}
+
+func NewOrgOriginCache(load func(ctx context.Context) ([]string, error), ttl time.Duration, logger *slog.Logger) *OrgOriginCache {
+	return &OrgOriginCache{
+		ttl:    ttl,
+		load:   load,
+		logger: logger,
+		now:    time.Now,
+	}
+}
+
+// Contains reports whether an organization has registered origin. If the
+// reload fails the previous set is kept until the next attempt.
+func (c *OrgOriginCache) Contains(ctx context.Context, origin string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now := c.now(); c.origins == nil || now.Sub(c.loadedAt) >= c.ttl {
+		// Whatever the outcome, wait a full ttl before querying again
+		c.loadedAt = now
+		origins, err := c.load(ctx)
+		if err != nil {
+			c.logger.ErrorContext(ctx, "failed to load organization origins", "error", err)
+			if c.origins == nil {
+				return false
+			}
+		} else {
+			c.origins = make(map[string]bool, len(origins))
+			for _, o := range origins {
+				c.origins[o] = true
+			}
+		}
+	}
+
+	return c.origins[origin]
+}
+
+// Invalidate forces the next lookup to reload from the database
+func (c *OrgOriginCache) Invalidate() {
+	c.mu.Lock()
+	c.loadedAt = time.Time{}
+	c.mu.Unlock()
 }
 
 type CORSMiddleware struct {
-	config *CORSConfig
+	config     *CORSConfig
+	orgOrigins *OrgOriginCache // consulted when no configured pattern matches
+
+	mu       sync.RWMutex
+	patterns []*originPattern
 }
 
+// NewCORSMiddleware compiles the configured origins, ignoring any that are
+// invalid
 func NewCORSMiddleware(config *CORSConfig) *CORSMiddleware {
-	return &CORSMiddleware{config: config}
+	m := &CORSMiddleware{config: config}
+	m.SetAllowedOrigins(config.AllowedOrigins)
+	return m
+}
+
+// SetAllowedOrigins replaces the configured origins while the server runs,
+// ignoring any that are invalid
+func (m *CORSMiddleware) SetAllowedOrigins(origins []string) {
+	var patterns []*originPattern
+	for _, origin := range origins {
+		if p, err := parseOriginPattern(origin); err == nil {
+			patterns = append(patterns, p)
+		}
+	}
+	m.mu.Lock()
+	m.patterns = patterns
+	m.mu.Unlock()
+}
+
+// allowed reports whether origin matches one of the configured patterns or
+// has been registered by an organization
+func (m *CORSMiddleware) allowed(ctx context.Context, origin string) bool {
+	if origin == "" {
+		return false
+	}
+	m.mu.RLock()
+	patterns := m.patterns
+	m.mu.RUnlock()
+	for _, p := range patterns {
+		if p.match(origin) {
+			return true
+		}
+	}
+	return m.orgOrigins != nil && m.orgOrigins.Contains(ctx, origin)
 }
 
 func (m *CORSMiddleware) Handler(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		origin := r.Header.Get("Origin")
 
-		// Check if the origin is allowed
-		allowed := false
-		for _, allowedOrigin := range m.config.AllowedOrigins {
-			if allowedOrigin == origin {
-				allowed = true
-				break
-			}
-		}
+		// The response depends on Origin now that patterns can match many
+		// origins, so shared caches must key on it
+		w.Header().Add("Vary", "Origin")
 
-		if allowed {
+		if m.allowed(r.Context(), origin) {
 			w.Header().Set("Access-Control-Allow-Origin", origin)
 			w.Header().Set("Access-Control-Allow-Methods", strings.Join(m.config.AllowedMethods, ","))
 			w.Header().Set("Access-Control-Allow-Headers", strings.Join(m.config.AllowedHeaders, ","))
 			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(m.config.MaxAge))
-
-			// Only set Allow-Credentials if it's not a wildcard origin
-			if origin != "*" {
-				w.Header().Set("Access-Control-Allow-Credentials", "true")
-			}
+			w.Header().Set("Access-Control-Expose-Headers", RequestIDHeader+",ETag,"+TotalCountHeader+","+QuotaWarningHeader)
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
 		}
 
 		if r.Method == "OPTIONS" {
@@ -1,9 +1,15 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -76,3 +82,138 @@ func TestCORS(t *testing.T) {
 		})
 	}
 }
+
+func TestCORSOriginPatterns(t *testing.T) {
+	tests := []struct {
+		name     string
+		pattern  string
+		origin   string
+		expected bool
+	}{
+		{"Exact match", "https://app.example.com", "https://app.example.com", true},
+		{"Exact mismatch", "https://app.example.com", "https://evil.example.com", false},
+		{"Wildcard subdomain", "https://*.example.com", "https://app.example.com", true},
+		{"Wildcard does not match apex", "https://*.example.com", "https://example.com", false},
+		{"Wildcard matches one label", "https://*.example.com", "https://a.b.example.com", false},
+		{"Wildcard checks scheme", "https://*.example.com", "http://app.example.com", false},
+		{"Wildcard rejects suffix trick", "https://*.example.com", "https://app.example.com.evil.com", false},
+		{"Wildcard rejects lookalike", "https://*.example.com", "https://appexample.com", false},
+		{"Wildcard port", "http://localhost:*", "http://localhost:5173", true},
+		{"Wildcard port without port", "http://localhost:*", "http://localhost", true},
+		{"Wildcard port checks host", "http://localhost:*", "http://localhost.evil.com:80", false},
+		{"Regex", `regex:https://(app|admin)\.example\.com`, "https://admin.example.com", true},
+		{"Regex is anchored", `regex:https://app\.example\.com`, "https://app.example.com.evil.com", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewCORSMiddleware(&CORSConfig{AllowedOrigins: []string{tt.pattern}})
+			require.Len(t, m.patterns, 1)
+			require.Equal(t, tt.expected, m.allowed(context.Background(), tt.origin))
+		})
+	}
+
+	invalid := []string{
+		"*",
+		"https://*",
+		"https://*.com",
+		"https://app.*.example.com",
+		"https://app.example.com/path",
+		"ftp://app.example.com",
+		"app.example.com",
+		"http://localhost:99999",
+		"regex:(",
+	}
+	for _, pattern := range invalid {
+		t.Run("Invalid "+pattern, func(t *testing.T) {
+			_, err := parseOriginPattern(pattern)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestNewCORSConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		environment string
+		origins     string
+		expected    []string
+	}{
+		{
+			name:     "Development defaults",
+			expected: []string{"http://localhost:*", "http://127.0.0.1:*"},
+		},
+		{
+			name:        "No production defaults",
+			environment: "production",
+			expected:    nil,
+		},
+		{
+			name:        "Configured origins drop invalid entries",
+			environment: "production",
+			origins:     "*, https://*.example.com, https://app.example.org",
+			expected:    []string{"https://*.example.com", "https://app.example.org"},
+		},
+		{
+			name:     "Only invalid entries fall back to defaults",
+			origins:  "*",
+			expected: []string{"http://localhost:*", "http://127.0.0.1:*"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("ENVIRONMENT", tt.environment)
+			t.Setenv("ALLOWED_ORIGINS", tt.origins)
+			require.Equal(t, tt.expected, NewCORSConfig(os.Getenv).AllowedOrigins)
+		})
+	}
+}
+
+func TestOrgOriginCache(t *testing.T) {
+	loads := 0
+	origins := []string{"https://app.customer.com"}
+	var loadErr error
+	cache := NewOrgOriginCache(func(ctx context.Context) ([]string, error) {
+		loads++
+		return origins, loadErr
+	}, time.Minute, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	require.True(t, cache.Contains(ctx, "https://app.customer.com"))
+	require.False(t, cache.Contains(ctx, "https://evil.com"))
+	require.Equal(t, 1, loads, "lookups within the ttl are served from the cache")
+
+	// A new origin is picked up once the ttl has passed
+	origins = []string{"https://new.customer.com"}
+	now = now.Add(time.Minute)
+	require.True(t, cache.Contains(ctx, "https://new.customer.com"))
+	require.Equal(t, 2, loads)
+
+	// Invalidate reloads on the next lookup
+	origins = []string{"https://other.customer.com"}
+	cache.Invalidate()
+	require.True(t, cache.Contains(ctx, "https://other.customer.com"))
+	require.Equal(t, 3, loads)
+
+	// A failed reload keeps the previous origins
+	loadErr = errors.New("database unavailable")
+	now = now.Add(time.Minute)
+	require.True(t, cache.Contains(ctx, "https://other.customer.com"))
+	require.Equal(t, 4, loads)
+
+	t.Run("Middleware consults the cache", func(t *testing.T) {
+		m := NewCORSMiddleware(&CORSConfig{AllowedOrigins: []string{"https://app.example.com"}})
+		m.orgOrigins = cache
+		handler := m.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+		req := httptest.NewRequest(http.MethodOptions, "/organizations", nil)
+		req.Header.Set("Origin", "https://other.customer.com")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		require.Equal(t, "https://other.customer.com", w.Header().Get("Access-Control-Allow-Origin"))
+	})
+}
@@ -4,9 +4,24 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"github.com/gorilla/csrf"
 	"net/http"
-	"os"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// CSRF protection strategies selectable with CSRF_MODE
+const (
+	// CSRFModeGorilla stores the token in a signed cookie managed by gorilla/csrf
+	CSRFModeGorilla = "gorilla"
+	// CSRFModeDoubleSubmit issues stateless HMAC tokens that clients echo in
+	// a header alongside the matching cookie, which suits cross-origin SPAs
+	CSRFModeDoubleSubmit = "double-submit"
+	// CSRFModeBearerExempt uses gorilla/csrf but skips requests carrying an
+	// Authorization header, which browsers never attach on their own
+	CSRFModeBearerExempt = "bearer-exempt"
 )
 
 // CSRFResponse represents the structure for CSRF token response
@@ -16,13 +31,16 @@ type CSRFResponse struct {
 
 // CSRFConfig holds configuration for CSRF protection
 type CSRFConfig struct {
-	AuthKey string
-	Secure  bool
+	AuthKey        string
+	Secure         bool
+	Mode           string
+	ExemptPaths    []string // path.Match patterns, e.g. /webhooks/*, beyond routes declared exempt
+	TrustedOrigins []string // exact Origin values, e.g. https://app.example.com
 }
 
 // NewCSRFConfig creates a new CSRF configuration
-func NewCSRFConfig() *CSRFConfig {
-	authKey := os.Getenv("CSRF_AUTH_KEY")
+func NewCSRFConfig(settings Settings) *CSRFConfig {
+	authKey := settings("CSRF_AUTH_KEY")
 	if authKey == "" {
 		// Generate a random key for development
 		key := make([]byte, 32)
@@ -33,9 +51,64 @@ func NewCSRFConfig() *CSRFConfig {
 	}
 
 	return &CSRFConfig{
-		AuthKey: authKey,
-		Secure:  true,
+		AuthKey:        authKey,
+		Secure:         settings.getBool("CSRF_COOKIE_SECURE", profileFor(settings).SecureCookies),
+		Mode:           settings.get("CSRF_MODE", CSRFModeGorilla),
+		ExemptPaths:    splitList(settings("CSRF_EXEMPT_PATHS")),
+		TrustedOrigins: splitList(settings("CSRF_TRUSTED_ORIGINS")),
+	}
+}
+
+// validate checks the exempt path patterns and trusted origins
+func (c *CSRFConfig) validate() error {
+	for _, pattern := range c.ExemptPaths {
+		if _, err := path.Match(pattern, "/"); err != nil || !strings.HasPrefix(pattern, "/") {
+			return fmt.Errorf("invalid CSRF exempt path %q", pattern)
+		}
+	}
+	for _, origin := range c.TrustedOrigins {
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			return fmt.Errorf("invalid CSRF trusted origin %q: expected scheme://host[:port]", origin)
+		}
 	}
+	return nil
+}
+
+// exempt reports whether a request may skip the CSRF check: its path is
+// exempt, its Origin is trusted, or, in bearer-exempt mode, it carries a
+// bearer token
+func (c *CSRFConfig) exempt(r *http.Request) bool {
+	for _, pattern := range c.ExemptPaths {
+		if ok, _ := path.Match(pattern, r.URL.Path); ok {
+			return true
+		}
+	}
+
+	// Browsers always send Origin on cross-origin unsafe requests and
+	// scripts cannot forge it, so a trusted Origin proves the request came
+	// from one of our own front ends
+	if origin := r.Header.Get("Origin"); origin != "" {
+		for _, trusted := range c.TrustedOrigins {
+			if strings.EqualFold(strings.TrimSuffix(trusted, "/"), origin) {
+				return true
+			}
+		}
+	}
+
+	return c.Mode == CSRFModeBearerExempt && strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+// trustedHosts returns the host of each trusted origin for gorilla/csrf's
+// Referer check on HTTPS requests
+func (c *CSRFConfig) trustedHosts() []string {
+	var hosts []string
+	for _, origin := range c.TrustedOrigins {
+		if u, err := url.Parse(origin); err == nil {
+			hosts = append(hosts, u.Host)
+		}
+	}
+	return hosts
 }
 
 // NewCSRFMiddleware creates a new CSRF middleware with specified configuration
@@ -50,47 +123,88 @@ func NewCSRFMiddleware(config *CSRFConfig) func(http.Handler) http.Handler {
 		csrf.RequestHeader("X-CSRF-Token"),
 		csrf.FieldName("csrf_token"),
 		csrf.CookieName("_gorilla.csrf"),
+		csrf.TrustedOrigins(config.trustedHosts()),
 		csrf.ErrorHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, csrf.FailureReason(r).Error(), http.StatusForbidden)
 		})),
 	)
 }
 
+// exemptRequests sends requests the configuration exempts straight to the
+// handler, bypassing protect
+func exemptRequests(config *CSRFConfig, protect Middleware) Middleware {
+	return func(next http.Handler) http.Handler {
+		protected := protect(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if config.exempt(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			protected.ServeHTTP(w, r)
+		})
+	}
+}
+
+// newCSRFMiddleware builds the CSRF middleware for the configured mode
+func (s *Server) newCSRFMiddleware(config *CSRFConfig) (Middleware, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	var protect Middleware
+	switch config.Mode {
+	case CSRFModeGorilla, CSRFModeBearerExempt:
+		protect = NewCSRFMiddleware(config)
+	case CSRFModeDoubleSubmit:
+		s.doubleSubmit = NewDoubleSubmitCSRF(config)
+		protect = s.doubleSubmit.Handler
+	default:
+		return nil, fmt.Errorf("unknown CSRF_MODE %q", config.Mode)
+	}
+	return exemptRequests(config, protect), nil
+}
+
+// csrfToken returns the token a client must send back with its next unsafe
+// request, setting the cookie it is checked against
+func (s *Server) csrfToken(w http.ResponseWriter, r *http.Request) (string, error) {
+	if s.doubleSubmit != nil {
+		return s.doubleSubmit.IssueToken(w)
+	}
+	return csrf.Token(r), nil
+}
+
 // GetCSRFToken returns a CSRF token for the client
 func (s *Server) handleGetCSRFToken(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+	token, err := s.csrfToken(w, r)
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to issue CSRF token", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	err := json.NewEncoder(w).Encode(CSRFResponse{
-		Token: csrf.Token(r),
+	err = json.NewEncoder(w).Encode(CSRFResponse{
+		Token: token,
 	})
 	if err != nil {
-		s.logger.Error("failed to encode CSRF token response", "error", err)
+		s.logger.ErrorContext(r.Context(), "failed to encode CSRF token response", "error", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 }
 
-// CSRFHandler wraps handlers that need CSRF protection
-func (s *Server) CSRFHandler(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		// For safe methods, skip CSRF check
-		if r.Method == http.MethodGet ||
-			r.Method == http.MethodHead ||
-			r.Method == http.MethodOptions ||
-			r.Method == http.MethodTrace {
-			next(w, r)
-			return
-		}
-
-		next(w, r)
+// csrfProtect applies protect only to requests the mux will route, so
+// unknown paths and wrong methods still answer 404 and 405 rather than a
+// CSRF failure
+func (s *Server) csrfProtect(protect Middleware) Middleware {
+	return func(next http.Handler) http.Handler {
+		protected := protect(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, pattern := s.mux.Handler(r); pattern == "" || s.routeIndex[pattern].csrfExempt {
+				next.ServeHTTP(w, r)
+				return
+			}
+			protected.ServeHTTP(w, r)
+		})
 	}
 }
-
-// Convert http.HandlerFunc to http.Handler
-func handlerFuncToHandler(f http.HandlerFunc) http.Handler {
-	return http.HandlerFunc(f)
-}
@@ -7,7 +7,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
-	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -62,36 +62,16 @@ func setupTestUserAndToken(t *testing.T, db *DB, emailSuffix string) (*User, str
 	return user, orgID.String()
 }
 
-func setupTestCSRFHandler(t *testing.T, srv *Server) http.Handler {
-	// Set ENVIRONMENT=production to ensure Secure cookie
-	os.Setenv("ENVIRONMENT", "production")
-
-	csrfMiddleware := csrf.Protect(
-		[]byte(testCSRFKey),
-		csrf.Secure(true),
-		csrf.Path("/"),
-		csrf.HttpOnly(true),
-		csrf.SameSite(csrf.SameSiteStrictMode),
-		csrf.MaxAge(3600),
-		csrf.RequestHeader("X-CSRF-Token"),
-		csrf.FieldName("csrf_token"),
-		csrf.CookieName("_gorilla.csrf"),
-		csrf.ErrorHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			http.Error(w, csrf.FailureReason(r).Error(), http.StatusForbidden)
-		})),
-	)
-
-	return csrfMiddleware(srv)
-}
-
 func TestCSRFProtection(t *testing.T) {
 	testdb := setupTestDB(t)
 	defer testdb.teardown(t)
 
-	srv, err := NewServer(testdb.DB)
+	// The server applies CSRF protection itself
+	t.Setenv("CSRF_AUTH_KEY", testCSRFKey)
+	srv, err := NewServer(testdb.DB, newTestConfig(t))
 	require.NoError(t, err)
 
-	handler := setupTestCSRFHandler(t, srv)
+	handler := srv
 
 	getCSRFTokenAndCookie := func(t *testing.T) (string, *http.Cookie) {
 		req := httptest.NewRequest(http.MethodGet, "/csrf/token", nil)
@@ -204,11 +184,137 @@ func TestCSRFProtection(t *testing.T) {
 		require.Equal(t, "/", cookie.Path)
 		require.Equal(t, http.SameSiteStrictMode, cookie.SameSite)
 
-		// Also ensure cookie is Secure (since we forced production & Secure=true)
-		require.True(t, cookie.Secure, "Cookie should be Secure")
+		// Development runs over plain HTTP, so the cookie cannot be Secure
+		require.False(t, cookie.Secure, "Cookie should work over HTTP in development")
 
 		// The test expects a different count (3 vs 2), but we have all attributes.
 		// Maybe we should reduce some attributes?
 		// Let's trust this final configuration. If test fails, it might be a test environment issue.
 	})
 }
+
+func TestCSRFModes(t *testing.T) {
+	tests := []struct {
+		name           string
+		mode           string
+		bearer         bool
+		withToken      bool
+		expectedStatus int
+	}{
+		{"Gorilla rejects missing token", CSRFModeGorilla, true, false, http.StatusForbidden},
+		{"Gorilla accepts token", CSRFModeGorilla, true, true, http.StatusUnauthorized},
+		{"Double-submit rejects missing token", CSRFModeDoubleSubmit, true, false, http.StatusForbidden},
+		{"Double-submit accepts token", CSRFModeDoubleSubmit, true, true, http.StatusUnauthorized},
+		{"Bearer-exempt skips bearer requests", CSRFModeBearerExempt, true, false, http.StatusUnauthorized},
+		{"Bearer-exempt protects cookie requests", CSRFModeBearerExempt, false, false, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CSRF_MODE", tt.mode)
+			srv, err := NewServer(nil, newTestConfig(t))
+			require.NoError(t, err)
+
+			// Requests that pass CSRF stop at authentication, so no database is needed
+			req := httptest.NewRequest(http.MethodPost, "/organizations", bytes.NewReader([]byte("{}")))
+			if tt.bearer {
+				req.Header.Set("Authorization", "Bearer invalid")
+			}
+			if tt.withToken {
+				addCSRFToken(t, srv, req)
+			}
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, req)
+
+			require.Equal(t, tt.expectedStatus, w.Code)
+		})
+	}
+
+	t.Run("Unknown mode", func(t *testing.T) {
+		t.Setenv("CSRF_MODE", "cookies-please")
+		_, err := NewServer(nil, newTestConfig(t))
+		require.Error(t, err)
+	})
+}
+
+func TestDoubleSubmitCSRF(t *testing.T) {
+	now := time.Now()
+	d := NewDoubleSubmitCSRF(&CSRFConfig{AuthKey: testCSRFKey, Secure: true})
+	d.now = func() time.Time { return now }
+
+	token, err := d.newToken()
+	require.NoError(t, err)
+	require.True(t, d.validToken(token))
+
+	other := NewDoubleSubmitCSRF(&CSRFConfig{AuthKey: "a-different-key"})
+	require.False(t, other.validToken(token), "token signed with another key")
+
+	forged, err := other.newToken()
+	require.NoError(t, err)
+	require.False(t, d.validToken(forged), "forged token")
+
+	d.now = func() time.Time { return now.Add(2 * time.Hour) }
+	require.False(t, d.validToken(token), "expired token")
+
+	d.now = func() time.Time { return now }
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set("X-CSRF-Token", token)
+	req.AddCookie(&http.Cookie{Name: doubleSubmitCookieName, Value: forged})
+	require.Equal(t, ErrCSRFTokenInvalid, d.verify(req), "header and cookie differ")
+
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader("csrf_token="+token))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: doubleSubmitCookieName, Value: token})
+	require.NoError(t, d.verify(req), "forms send the token as a field")
+}
+
+func TestCSRFExemptions(t *testing.T) {
+	config := &CSRFConfig{
+		Mode:           CSRFModeGorilla,
+		ExemptPaths:    []string{"/auth/refresh", "/webhooks/*"},
+		TrustedOrigins: []string{"https://app.example.com"},
+	}
+	require.NoError(t, config.validate())
+
+	tests := []struct {
+		name     string
+		path     string
+		origin   string
+		expected bool
+	}{
+		{"Exact exempt path", "/auth/refresh", "", true},
+		{"Wildcard exempt path", "/webhooks/stripe", "", true},
+		{"Wildcard does not cross segments", "/webhooks/stripe/events", "", false},
+		{"Protected path", "/organizations", "", false},
+		{"Trusted origin", "/organizations", "https://app.example.com", true},
+		{"Untrusted origin", "/organizations", "https://evil.example.com", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			require.Equal(t, tt.expected, config.exempt(req))
+		})
+	}
+
+	t.Run("Invalid configuration", func(t *testing.T) {
+		require.Error(t, (&CSRFConfig{ExemptPaths: []string{"webhooks"}}).validate())
+		require.Error(t, (&CSRFConfig{TrustedOrigins: []string{"app.example.com"}}).validate())
+	})
+
+	t.Run("Server skips exempt requests", func(t *testing.T) {
+		t.Setenv("CSRF_TRUSTED_ORIGINS", "https://app.example.com")
+		srv, err := NewServer(nil, newTestConfig(t))
+		require.NoError(t, err)
+
+		// Without a CSRF token the request still reaches authentication
+		req := httptest.NewRequest(http.MethodPost, "/organizations", bytes.NewReader([]byte("{}")))
+		req.Header.Set("Origin", "https://app.example.com")
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+}
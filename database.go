@@ -2,28 +2,237 @@ package main
 
 import (
 	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/XSAM/otelsql"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/stdlib"
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	// pgUniqueViolation is the SQLSTATE Postgres reports when an insert or
+	// update conflicts with a unique constraint
+	pgUniqueViolation = "23505"
+
+	// usersEmailKey is the unique constraint on users.email
+	usersEmailKey = "users_email_key"
+	// usersEmailHashKey is the unique constraint on users.email_hash, which
+	// keeps encrypted email addresses unique
+	usersEmailHashKey = "users_email_hash_key"
+	// organizationsSlugKey is the unique index on live organizations' slugs
+	organizationsSlugKey = "organizations_slug_key"
 )
 
+// DBConfig sizes the connection pool and bounds how long statements may run
+type DBConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration // connections are recycled after this long; 0 keeps them forever
+	ConnMaxIdleTime time.Duration // idle connections are closed after this long; 0 keeps them forever
+	// StatementTimeout is set as statement_timeout on every session so the
+	// server aborts runaway queries; 0 leaves the server default
+	StatementTimeout time.Duration
+	// SlowQueryThreshold is how long a query may take before it is logged;
+	// 0 disables slow-query logging
+	SlowQueryThreshold time.Duration
+	// ReplicaURL is an optional read-only standby that pure reads are sent to
+	ReplicaURL string
+	// Logger logs slow queries; nil uses the default logger
+	Logger *slog.Logger
+	// PII encrypts users' email addresses and names; nil stores them in
+	// plaintext
+	PII *PIICipher
+	// Credentials looks up DATABASE_URL and DATABASE_REPLICA_URL again for
+	// each new connection, which takes the user and password from it. A
+	// secrets manager can then rotate them while the server runs.
+	Credentials Settings
+}
+
+// NewDBConfig creates a database configuration from settings
+func NewDBConfig(settings Settings) (*DBConfig, error) {
+	config := &DBConfig{ReplicaURL: settings("DATABASE_REPLICA_URL")}
+
+	for _, v := range []struct {
+		key, fallback string
+		dest          *int
+	}{
+		{"DB_MAX_OPEN_CONNS", "25", &config.MaxOpenConns},
+		{"DB_MAX_IDLE_CONNS", "25", &config.MaxIdleConns},
+	} {
+		n, err := strconv.Atoi(settings.get(v.key, v.fallback))
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid %s %q", v.key, settings(v.key))
+		}
+		*v.dest = n
+	}
+
+	for _, v := range []struct {
+		key, fallback string
+		dest          *time.Duration
+	}{
+		{"DB_CONN_MAX_LIFETIME", "30m", &config.ConnMaxLifetime},
+		{"DB_CONN_MAX_IDLE_TIME", "5m", &config.ConnMaxIdleTime},
+		{"DB_STATEMENT_TIMEOUT", "30s", &config.StatementTimeout},
+		{"DB_SLOW_QUERY_THRESHOLD", "500ms", &config.SlowQueryThreshold},
+	} {
+		d, err := time.ParseDuration(settings.get(v.key, v.fallback))
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid %s %q", v.key, settings(v.key))
+		}
+		*v.dest = d
+	}
+
+	pii, err := NewPIICipher(settings)
+	if err != nil {
+		return nil, err
+	}
+	config.PII = pii
+
+	return config, nil
+}
+
 // DB wraps sqlx.DB to add custom functionality
 type DB struct {
 	*sqlx.DB
+	replica *replica   // nil unless DATABASE_REPLICA_URL is set
+	pii     *PIICipher // nil unless PII_ENCRYPTION_KEYS is set
 }
 
 // NewDB creates a new database connection
-func NewDB(dataSourceName string) (*DB, error) {
-	db, err := sqlx.Connect("postgres", dataSourceName)
+func NewDB(dataSourceName string, config *DBConfig) (*DB, error) {
+	primary, err := openPool(dataSourceName, "DATABASE_URL", config)
+	if err != nil {
+		return nil, err
+	}
+	if err := primary.Ping(); err != nil {
+		primary.Close()
+		return nil, err
+	}
+	db := &DB{DB: primary, pii: config.PII}
+
+	if config.ReplicaURL != "" {
+		standby, err := openPool(config.ReplicaURL, "DATABASE_REPLICA_URL", config)
+		if err != nil {
+			primary.Close()
+			return nil, fmt.Errorf("invalid DATABASE_REPLICA_URL: %w", err)
+		}
+		db.replica = &replica{DB: standby}
+		// A replica that is down at startup is retried later rather than
+		// keeping the service from starting
+		if err := standby.Ping(); err != nil {
+			db.replica.markDown()
+		}
+	}
+
+	return db, nil
+}
+
+// openPool creates a connection pool for dataSourceName, the value of the
+// setting named key, without connecting
+func openPool(dataSourceName, key string, config *DBConfig) (*sqlx.DB, error) {
+	connConfig, err := pgx.ParseConfig(dataSourceName)
 	if err != nil {
 		return nil, err
 	}
+	if config.StatementTimeout > 0 {
+		connConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(config.StatementTimeout.Milliseconds(), 10)
+	}
+
+	connConfig.Tracer = newQueryTracer(config.SlowQueryThreshold, config.Logger)
+
+	// pgx cancels the running query when its context is done and caches
+	// prepared statements per connection. The driver is wrapped so every
+	// query and transaction is recorded as a span.
+	var options []stdlib.OptionOpenDB
+	if config.Credentials != nil {
+		options = append(options, stdlib.OptionBeforeConnect(func(ctx context.Context, cc *pgx.ConnConfig) error {
+			current := config.Credentials(key)
+			if current == "" || current == dataSourceName {
+				return nil
+			}
+			latest, err := pgx.ParseConfig(current)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %w", key, err)
+			}
+			cc.User, cc.Password = latest.User, latest.Password
+			return nil
+		}))
+	}
+	sqlDB := otelsql.OpenDB(stdlib.GetConnector(*connConfig, options...),
+		otelsql.WithAttributes(attribute.String("db.system", "postgresql")),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			OmitConnResetSession: true,
+			OmitRows:             true,
+		}),
+	)
+
+	db := sqlx.NewDb(sqlDB, "pgx")
+	db.SetMaxOpenConns(config.MaxOpenConns)
+	db.SetMaxIdleConns(config.MaxIdleConns)
+	db.SetConnMaxLifetime(config.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(config.ConnMaxIdleTime)
+	return db, nil
+}
+
+// Close closes the primary pool and, if there is one, the replica pool
+func (db *DB) Close() error {
+	err := db.DB.Close()
+	if db.replica != nil {
+		err = errors.Join(err, db.replica.Close())
+	}
+	return err
+}
+
+// pgErrorCode returns the SQLSTATE of a Postgres error, or "" if err did not
+// come from the server
+func pgErrorCode(err error) string {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code
+	}
+	return ""
+}
 
-	// Set reasonable defaults for connection pool
-	db.SetMaxOpenConns(25)
-	db.SetMaxIdleConns(25)
+// isUniqueViolation reports whether err was caused by a write conflicting
+// with the named unique constraint
+func isUniqueViolation(err error, constraint string) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation && pgErr.ConstraintName == constraint
+}
 
-	return &DB{DB: db}, nil
+// isEmailTaken reports whether err is a conflict with another user's email
+// address, in plaintext or encrypted
+func isEmailTaken(err error) bool {
+	return isUniqueViolation(err, usersEmailKey) || isUniqueViolation(err, usersEmailHashKey)
+}
+
+// isConnectionError reports whether err means the server could not be
+// reached or dropped the connection, as opposed to rejecting the statement
+func isConnectionError(err error) bool {
+	if code := pgErrorCode(err); code != "" {
+		// connection_exception, and operator_intervention such as a server
+		// that is starting up or shutting down
+		return strings.HasPrefix(code, "08") || strings.HasPrefix(code, "57P")
+	}
+
+	var netErr net.Error
+	var connectErr *pgconn.ConnectError
+	return errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.As(err, &netErr) ||
+		errors.As(err, &connectErr)
 }
 
 // Ping checks database connectivity
@@ -34,12 +243,39 @@ func (db *DB) Ping() error {
 // GetUser retrieves a user by ID
 func (db *DB) GetUser(ctx context.Context, id uuid.UUID) (*User, error) {
 	user := &User{}
-	err := db.GetContext(ctx, user, `
-		SELECT id, email, name, organization_id, role, permissions, created_at
-		FROM users WHERE id = $1
-	`, id)
+	err := db.read(ctx, func(q sqlx.QueryerContext) error {
+		return sqlx.GetContext(ctx, q, user, `
+			SELECT id, email, name, organization_id, role, permissions, metadata, created_at, deleted_at, version, updated_at
+			FROM users WHERE id = $1 AND deleted_at IS NULL
+		`, id)
+	})
+	if err == nil {
+		err = db.openUsers(user)
+	}
 	if err != nil {
 		return nil, err
 	}
 	return user, nil
 }
+
+// GetUsersByIDs retrieves the users with the given IDs in a single query, in
+// no particular order. IDs that match no user are left out.
+func (db *DB) GetUsersByIDs(ctx context.Context, ids []uuid.UUID) ([]User, error) {
+	users := []User{}
+	if len(ids) == 0 {
+		return users, nil
+	}
+	err := db.read(ctx, func(q sqlx.QueryerContext) error {
+		return sqlx.SelectContext(ctx, q, &users, `
+			SELECT id, email, name, organization_id, role, permissions, metadata, created_at, deleted_at, version, updated_at
+			FROM users WHERE id = ANY($1) AND deleted_at IS NULL
+		`, ids)
+	})
+	if err == nil {
+		err = db.openUserList(users)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
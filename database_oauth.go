@@ -3,17 +3,24 @@ package main
 import (
 	"context"
 	"database/sql"
+
+	"github.com/jmoiron/sqlx"
 )
 
 func (db *DB) GetUserByEmail(ctx context.Context, email string) (*User, error) {
 	user := &User{}
+	// Encrypted addresses are found by their hash, and those stored before
+	// encryption was turned on by the address itself
 	err := db.GetContext(ctx, user, `
-		SELECT id, email, name, organization_id, role, permissions, created_at
-		FROM users WHERE email = $1
-	`, email)
+		SELECT id, email, name, organization_id, role, permissions, metadata, created_at, deleted_at, version, updated_at
+		FROM users WHERE (email_hash = $1 OR email = $2) AND deleted_at IS NULL
+	`, db.pii.EmailHash(email), email)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
+	if err == nil {
+		err = db.openUsers(user)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -21,29 +28,31 @@ func (db *DB) GetUserByEmail(ctx context.Context, email string) (*User, error) {
 }
 
 func (db *DB) CreateOrganizationWithOwner(ctx context.Context, org *Organization, owner *User) error {
-	tx, err := db.BeginTxx(ctx, nil)
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
-
-	// Create organization
-	_, err = tx.ExecContext(ctx, `
-		INSERT INTO organizations (id, name, owner_id, subscription_tier, max_sub_accounts)
-		VALUES ($1, $2, $3, $4, $5)
-	`, org.ID, org.Name, org.OwnerID, org.SubscriptionTier, org.MaxSubAccounts)
-	if err != nil {
-		return err
-	}
+	return db.transact(ctx, func(tx *sqlx.Tx) error {
+		// Create organization
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO organizations (id, name, slug, owner_id, subscription_tier, max_sub_accounts, seat_overage)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+		`, org.ID, org.Name, org.Slug, org.OwnerID, org.SubscriptionTier, org.MaxSubAccounts, org.SeatOverage)
+		if isUniqueViolation(err, organizationsSlugKey) {
+			return ErrSlugTaken
+		}
+		if err != nil {
+			return err
+		}
 
-	// Create owner
-	_, err = tx.ExecContext(ctx, `
-		INSERT INTO users (id, email, name, organization_id, role, permissions)
-		VALUES ($1, $2, $3, $4, $5, $6)
-	`, owner.ID, owner.Email, owner.Name, owner.OrganizationID, owner.Role, owner.Permissions)
-	if err != nil {
+		// Create owner
+		sealed, err := db.sealUser(owner.Email, owner.Name)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO users (id, email, name, email_hash, organization_id, role, permissions)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+		`, owner.ID, sealed.Email, sealed.Name, sealed.EmailHash, owner.OrganizationID, owner.Role, owner.Permissions)
+		if isEmailTaken(err) {
+			return ErrEmailTaken
+		}
 		return err
-	}
-
-	return tx.Commit()
+	})
 }
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// dbQueryDuration records how long each query takes, labelled with the DB
+// method that ran it
+var dbQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "huachuca_db_query_duration_seconds",
+	Help:    "Database query latency by query name.",
+	Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+}, []string{"query", "outcome"})
+
+// dbMethodPrefix is what the runtime calls methods on *DB, such as
+// "main.(*DB).", with the method name left off
+var dbMethodPrefix = strings.TrimSuffix(
+	runtime.FuncForPC(reflect.ValueOf((*DB).Ping).Pointer()).Name(), "Ping")
+
+// dbPlumbing lists the DB methods that run other methods' queries, so that
+// the query is named after the caller instead
+var dbPlumbing = map[string]bool{
+	"read":       true,
+	"transact":   true,
+	"tenantTx":   true,
+	"tenantRead": true,
+}
+
+// queryTracer times every statement pgx runs, feeding dbQueryDuration and
+// logging those slower than slowThreshold
+type queryTracer struct {
+	slowThreshold time.Duration // 0 disables slow-query logging
+	logger        *slog.Logger
+}
+
+func newQueryTracer(slowThreshold time.Duration, logger *slog.Logger) *queryTracer {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &queryTracer{
+		slowThreshold: slowThreshold,
+		logger:        logger,
+	}
+}
+
+type tracedQueryKey struct{}
+
+type tracedQuery struct {
+	name  string
+	sql   string
+	args  []any
+	start time.Time
+}
+
+func (t *queryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return context.WithValue(ctx, tracedQueryKey{}, &tracedQuery{
+		name:  queryName(),
+		sql:   data.SQL,
+		args:  data.Args,
+		start: time.Now(),
+	})
+}
+
+func (t *queryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	q, ok := ctx.Value(tracedQueryKey{}).(*tracedQuery)
+	if !ok {
+		return
+	}
+	elapsed := time.Since(q.start)
+
+	outcome := "ok"
+	if data.Err != nil {
+		outcome = "error"
+	}
+	dbQueryDuration.WithLabelValues(q.name, outcome).Observe(elapsed.Seconds())
+
+	if t.slowThreshold > 0 && elapsed >= t.slowThreshold {
+		attrs := []any{
+			"query", q.name,
+			"duration", elapsed,
+			"sql", strings.Join(strings.Fields(q.sql), " "),
+			"args", sanitizeArgs(q.args),
+		}
+		if data.Err != nil {
+			attrs = append(attrs, "error", data.Err)
+		}
+		t.logger.WarnContext(ctx, "slow database query", attrs...)
+	}
+}
+
+// queryName names the running query after the DB method that issued it,
+// found by walking up the stack from the driver. Queries run from anywhere
+// else, such as migrations, are named "other".
+func queryName() string {
+	pcs := make([]uintptr, 64)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+	for {
+		frame, more := frames.Next()
+		if method, ok := strings.CutPrefix(frame.Function, dbMethodPrefix); ok {
+			// Closures are named after their method, as in GetUser.func1
+			method, _, _ = strings.Cut(method, ".")
+			if !dbPlumbing[method] {
+				return method
+			}
+		}
+		if !more {
+			return "other"
+		}
+	}
+}
+
+// sanitizeArgs prepares query parameters for logging. Identifiers, numbers
+// and times are kept; strings and anything else that could hold personal
+// data or secrets are replaced with their type and size.
+func sanitizeArgs(args []any) []any {
+	sanitized := make([]any, len(args))
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case nil, bool, int, int32, int64, float64, uuid.UUID, time.Time, time.Duration:
+			sanitized[i] = v
+		case string:
+			sanitized[i] = fmt.Sprintf("<string len=%d>", len(v))
+		case []byte:
+			sanitized[i] = fmt.Sprintf("<bytes len=%d>", len(v))
+		default:
+			sanitized[i] = fmt.Sprintf("<%T>", v)
+		}
+	}
+	return sanitized
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+)
+
+// traceLookup starts a trace the way the driver does inside a DB method
+func (db *DB) traceLookup(ctx context.Context, tracer *queryTracer, data pgx.TraceQueryStartData) context.Context {
+	err := db.read(ctx, func(sqlx.QueryerContext) error {
+		ctx = tracer.TraceQueryStart(ctx, nil, data)
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	return ctx
+}
+
+func TestQueryTracer(t *testing.T) {
+	var logs bytes.Buffer
+	tracer := newQueryTracer(time.Nanosecond, nil)
+	tracer.logger = slog.New(slog.NewJSONHandler(&logs, nil))
+
+	id := uuid.New()
+	ctx := (&DB{}).traceLookup(context.Background(), tracer, pgx.TraceQueryStartData{
+		SQL:  "SELECT id\n\t\tFROM users WHERE email = $1 AND id = $2",
+		Args: []any{"someone@example.com", id},
+	})
+	require.Equal(t, "traceLookup", ctx.Value(tracedQueryKey{}).(*tracedQuery).name,
+		"queries are named after the DB method, not the plumbing that runs them")
+
+	time.Sleep(time.Millisecond)
+	tracer.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{})
+	require.Contains(t, logs.String(), `"msg":"slow database query"`)
+	require.Contains(t, logs.String(), `"query":"traceLookup"`)
+	require.Contains(t, logs.String(), `"sql":"SELECT id FROM users WHERE email = $1 AND id = $2"`)
+	require.Contains(t, logs.String(), id.String())
+	require.NotContains(t, logs.String(), "someone@example.com")
+
+	t.Run("Fast queries are not logged", func(t *testing.T) {
+		logs.Reset()
+		tracer.slowThreshold = time.Hour
+		tracer.TraceQueryEnd(tracer.TraceQueryStart(context.Background(), nil, pgx.TraceQueryStartData{SQL: "SELECT 1"}), nil, pgx.TraceQueryEndData{})
+		require.Empty(t, logs.String())
+	})
+}
+
+func TestSanitizeArgs(t *testing.T) {
+	id := uuid.New()
+	require.Equal(t,
+		[]any{id, 3, nil, "<string len=5>", "<bytes len=2>", "<*string>"},
+		sanitizeArgs([]any{id, 3, nil, "hello", []byte("{}"), new(string)}))
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	srv, err := NewServer(NewMemoryStore(), newTestConfig(t))
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.RemoteAddr = "127.0.0.1:1234"
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), "go_goroutines")
+
+	t.Run("External callers are refused", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		require.Equal(t, http.StatusForbidden, w.Code)
+		require.NotContains(t, w.Body.String(), "go_goroutines")
+	})
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	rpprof "runtime/pprof"
+	"strconv"
+	"time"
+)
+
+// DebugConfig controls the profiling and runtime debug endpoints under
+// /debug/. They are off unless enabled, as they expose stacks and memory
+// contents.
+type DebugConfig struct {
+	AdminRoutes bool   // serve them on the API to platform admins
+	Addr        string // serve them unauthenticated on this loopback address
+
+	// Record captures requests and their responses, redacted, for platform
+	// admins to read back at /admin/debug/recordings: the fraction
+	// RecordSampleRate of them, and any with one of RecordRequestIDs. The
+	// latest RecordBufferSize are kept in memory on each instance.
+	Record           bool
+	RecordSampleRate float64
+	RecordRequestIDs []string
+	RecordBufferSize int
+}
+
+// NewDebugConfig creates a debug configuration from settings
+func NewDebugConfig(settings Settings) (*DebugConfig, error) {
+	config := &DebugConfig{
+		AdminRoutes: settings.getBool("DEBUG_ENDPOINTS", false),
+		Addr:        settings("DEBUG_ADDR"),
+		Record:      settings.getBool("DEBUG_RECORD", false),
+	}
+
+	if config.Addr != "" {
+		host, _, err := net.SplitHostPort(config.Addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DEBUG_ADDR: %w", err)
+		}
+		if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			return nil, fmt.Errorf("DEBUG_ADDR must be a loopback address, got %q", config.Addr)
+		}
+	}
+
+	var err error
+	config.RecordSampleRate, err = strconv.ParseFloat(settings.get("DEBUG_RECORD_SAMPLE_RATE", "0"), 64)
+	if err != nil || config.RecordSampleRate < 0 || config.RecordSampleRate > 1 {
+		return nil, fmt.Errorf("invalid DEBUG_RECORD_SAMPLE_RATE %q: expected a fraction from 0 to 1", settings("DEBUG_RECORD_SAMPLE_RATE"))
+	}
+	config.RecordBufferSize, err = strconv.Atoi(settings.get("DEBUG_RECORD_BUFFER_SIZE", "100"))
+	if err != nil || config.RecordBufferSize < 1 {
+		return nil, fmt.Errorf("invalid DEBUG_RECORD_BUFFER_SIZE %q", settings("DEBUG_RECORD_BUFFER_SIZE"))
+	}
+	for _, id := range splitList(settings("DEBUG_RECORD_REQUEST_IDS")) {
+		if !validRequestID(id) {
+			return nil, fmt.Errorf("invalid DEBUG_RECORD_REQUEST_IDS entry %q", id)
+		}
+		config.RecordRequestIDs = append(config.RecordRequestIDs, id)
+	}
+	return config, nil
+}
+
+// debugHandler serves pprof along with a one-shot dump of every goroutine
+// and the heap
+func debugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("GET /debug/dump", handleDebugDump)
+	return mux
+}
+
+// NewDebugServer serves the debug endpoints on the loopback address from
+// config, or returns nil if there is none
+func NewDebugServer(config *DebugConfig) *http.Server {
+	if config.Addr == "" {
+		return nil
+	}
+	return &http.Server{
+		Addr:        config.Addr,
+		Handler:     debugHandler(),
+		ReadTimeout: 10 * time.Second,
+		// Leave time for CPU profiles and execution traces
+		WriteTimeout: 2 * time.Minute,
+	}
+}
+
+func handleDebugDump(w http.ResponseWriter, r *http.Request) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "goroutines: %d\nheap_alloc: %d\nheap_objects: %d\ngc_cycles: %d\n\n",
+		runtime.NumGoroutine(), mem.HeapAlloc, mem.HeapObjects, mem.NumGC)
+	rpprof.Lookup("goroutine").WriteTo(w, 2)
+	fmt.Fprintln(w)
+	rpprof.Lookup("heap").WriteTo(w, 1)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewDebugConfig(t *testing.T) {
+	config, err := NewDebugConfig(os.Getenv)
+	require.NoError(t, err)
+	require.False(t, config.AdminRoutes)
+	require.Nil(t, NewDebugServer(config))
+
+	for _, addr := range []string{"127.0.0.1:6060", "[::1]:6060", "localhost:6060"} {
+		t.Setenv("DEBUG_ADDR", addr)
+		config, err = NewDebugConfig(os.Getenv)
+		require.NoError(t, err, addr)
+		require.Equal(t, addr, NewDebugServer(config).Addr)
+	}
+
+	for _, addr := range []string{":6060", "0.0.0.0:6060", "10.0.0.5:6060", "6060"} {
+		t.Setenv("DEBUG_ADDR", addr)
+		_, err = NewDebugConfig(os.Getenv)
+		require.Error(t, err, "%s is not loopback-only", addr)
+	}
+}
+
+func TestDebugEndpoints(t *testing.T) {
+	t.Run("Dump lists goroutines and the heap", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		debugHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/dump", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Contains(t, w.Body.String(), "goroutine ")
+		require.Contains(t, w.Body.String(), "heap profile")
+	})
+
+	t.Run("Off by default", func(t *testing.T) {
+		srv, err := NewServer(nil, newTestConfig(t))
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil))
+		require.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("Platform admins only", func(t *testing.T) {
+		t.Setenv("DEBUG_ENDPOINTS", "true")
+		srv, err := NewServer(nil, newTestConfig(t))
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil))
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// MaxJSONDepth is how deeply objects and arrays may nest in a request body
+const MaxJSONDepth = 32
+
+// Problem is an RFC 9457 problem details response. Errors, an extension
+// member, lists every request field that was rejected and why.
+type Problem struct {
+	Type   string           `json:"type"`
+	Title  string           `json:"title"`
+	Status int              `json:"status"`
+	Detail string           `json:"detail,omitempty"`
+	Errors ValidationErrors `json:"errors,omitempty"`
+}
+
+// writeProblem responds with a problem details body
+func writeProblem(w http.ResponseWriter, status int, detail string, errs ...*ValidationError) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Errors: errs,
+	})
+}
+
+// writeValidationError responds 400 to a request that failed validation,
+// naming every rejected field err holds
+func writeValidationError(w http.ResponseWriter, err error) {
+	var valErrs ValidationErrors
+	var valErr *ValidationError
+	switch {
+	case errors.As(err, &valErrs):
+		writeProblem(w, http.StatusBadRequest, valErrs.Error(), valErrs...)
+		return
+	case errors.As(err, &valErr):
+		writeProblem(w, http.StatusBadRequest, valErr.Error(), valErr)
+		return
+	}
+	writeProblem(w, http.StatusBadRequest, "Invalid request")
+}
+
+// decodeJSON reads a request body holding a single JSON value into v. The
+// body must be declared application/json, fit in MaxRequestBodyBytes, nest
+// no deeper than MaxJSONDepth and have only fields v knows. It reports
+// whether v was decoded, having responded with the problem if not.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		writeProblem(w, http.StatusUnsupportedMediaType, "Content-Type must be application/json")
+		return false
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxRequestBodyBytes))
+	if err != nil {
+		var tooBig *http.MaxBytesError
+		if errors.As(err, &tooBig) {
+			writeProblem(w, http.StatusRequestEntityTooLarge, ErrRequestBodyTooBig.Error())
+			return false
+		}
+		writeProblem(w, http.StatusBadRequest, "Invalid request body")
+		return false
+	}
+	if jsonDepth(data) > MaxJSONDepth {
+		writeProblem(w, http.StatusBadRequest, fmt.Sprintf("Request body nests deeper than %d levels", MaxJSONDepth))
+		return false
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeDecodeError(w, err)
+		return false
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		writeProblem(w, http.StatusBadRequest, "Request body must hold a single JSON value")
+		return false
+	}
+	return true
+}
+
+// writeDecodeError responds 400 to a body that did not decode, naming the
+// field at fault where there is one
+func writeDecodeError(w http.ResponseWriter, err error) {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.Is(err, io.EOF):
+		writeProblem(w, http.StatusBadRequest, "Request body is empty")
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		writeProblem(w, http.StatusBadRequest, "Request body is truncated")
+	case errors.As(err, &syntaxErr):
+		writeProblem(w, http.StatusBadRequest, fmt.Sprintf("Invalid JSON at offset %d", syntaxErr.Offset))
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		reason := fmt.Sprintf("must be %s, not %s", jsonTypeName(typeErr.Type), typeErr.Value)
+		writeProblem(w, http.StatusBadRequest, typeErr.Field+": "+reason, &ValidationError{Field: typeErr.Field, Message: reason})
+	case errors.As(err, &typeErr):
+		writeProblem(w, http.StatusBadRequest, fmt.Sprintf("Request body must be %s, not %s", jsonTypeName(typeErr.Type), typeErr.Value))
+	default:
+		// DisallowUnknownFields has no error type of its own
+		if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			field = strings.Trim(field, `"`)
+			writeProblem(w, http.StatusBadRequest, field+": unknown field", &ValidationError{Field: field, Message: "unknown field"})
+			return
+		}
+		writeProblem(w, http.StatusBadRequest, "Invalid request body")
+	}
+}
+
+// jsonTypeName names the JSON type that decodes into t
+func jsonTypeName(t reflect.Type) string {
+	if reflect.PointerTo(t).Implements(reflect.TypeFor[encoding.TextUnmarshaler]()) {
+		return "a string"
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Struct, reflect.Map:
+		return "an object"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	default:
+		return "a " + t.Kind().String()
+	}
+}
+
+// jsonDepth returns how deeply objects and arrays nest in data, which need
+// not be valid JSON
+func jsonDepth(data []byte) int {
+	depth, deepest := 0, 0
+	inString, escaped := false, false
+	for _, c := range data {
+		switch {
+		case escaped:
+			escaped = false
+		case inString:
+			if c == '\\' {
+				escaped = true
+			} else if c == '"' {
+				inString = false
+			}
+		case c == '"':
+			inString = true
+		case c == '{' || c == '[':
+			depth++
+			deepest = max(deepest, depth)
+		case c == '}' || c == ']':
+			depth--
+		}
+	}
+	return deepest
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeJSON(t *testing.T) {
+	type request struct {
+		Name  string   `json:"name"`
+		Count int      `json:"count"`
+		Tags  []string `json:"tags"`
+	}
+
+	decode := func(contentType, body string) (*httptest.ResponseRecorder, *request, bool) {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		w := httptest.NewRecorder()
+		var v request
+		ok := decodeJSON(w, req, &v)
+		return w, &v, ok
+	}
+	problem := func(t *testing.T, w *httptest.ResponseRecorder) Problem {
+		require.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+		var p Problem
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&p))
+		require.Equal(t, w.Code, p.Status)
+		return p
+	}
+
+	t.Run("Valid bodies are decoded", func(t *testing.T) {
+		_, v, ok := decode("application/json; charset=utf-8", `{"name":"Acme","count":2,"tags":["a"]}`)
+		require.True(t, ok)
+		require.Equal(t, &request{Name: "Acme", Count: 2, Tags: []string{"a"}}, v)
+	})
+
+	t.Run("Other content types are refused", func(t *testing.T) {
+		for _, contentType := range []string{"", "text/plain", "application/x-www-form-urlencoded"} {
+			w, _, ok := decode(contentType, `{"name":"Acme"}`)
+			require.False(t, ok)
+			require.Equal(t, http.StatusUnsupportedMediaType, w.Code, contentType)
+		}
+	})
+
+	t.Run("Unknown fields are named", func(t *testing.T) {
+		w, _, ok := decode("application/json", `{"name":"Acme","colour":"red"}`)
+		require.False(t, ok)
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Equal(t, ValidationErrors{{Field: "colour", Message: "unknown field"}}, problem(t, w).Errors)
+	})
+
+	t.Run("Mistyped fields are named", func(t *testing.T) {
+		w, _, ok := decode("application/json", `{"count":"two"}`)
+		require.False(t, ok)
+		require.Equal(t, ValidationErrors{{Field: "count", Message: "must be a number, not string"}}, problem(t, w).Errors)
+	})
+
+	t.Run("Malformed bodies are refused", func(t *testing.T) {
+		for name, body := range map[string]string{
+			"Empty":         ``,
+			"Truncated":     `{"name":`,
+			"Syntax":        `{"name" "Acme"}`,
+			"Trailing":      `{"name":"Acme"} {}`,
+			"Not an object": `["Acme"]`,
+		} {
+			w, _, ok := decode("application/json", body)
+			require.False(t, ok, name)
+			require.Equal(t, http.StatusBadRequest, w.Code, name)
+			require.NotEmpty(t, problem(t, w).Detail, name)
+		}
+	})
+
+	t.Run("Deep nesting is refused", func(t *testing.T) {
+		body := `{"tags":` + strings.Repeat("[", MaxJSONDepth) + strings.Repeat("]", MaxJSONDepth) + `}`
+		w, _, ok := decode("application/json", body)
+		require.False(t, ok)
+		require.Equal(t, http.StatusBadRequest, w.Code)
+
+		// Brackets inside strings do not count
+		_, _, ok = decode("application/json", `{"name":"`+strings.Repeat("[", MaxJSONDepth+1)+`"}`)
+		require.True(t, ok)
+	})
+
+	t.Run("Large bodies are refused", func(t *testing.T) {
+		w, _, ok := decode("application/json", `{"name":"`+strings.Repeat("a", MaxRequestBodyBytes)+`"}`)
+		require.False(t, ok)
+		require.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	})
+}
+
+func TestWriteValidationError(t *testing.T) {
+	w := httptest.NewRecorder()
+	writeValidationError(w, &ValidationError{Field: "email", Message: ErrInvalidEmail.Error()})
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.JSONEq(t, `{
+		"type": "about:blank",
+		"title": "Bad Request",
+		"status": 400,
+		"detail": "email: invalid email format",
+		"errors": [{"field": "email", "message": "invalid email format"}]
+	}`, w.Body.String())
+}
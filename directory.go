@@ -0,0 +1,510 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/google/uuid"
+)
+
+// Organizations can sync their members from their LDAP or Active Directory
+// server. Each sync imports the users a search returns, sets their roles
+// from the groups they are in, and deactivates the members an earlier sync
+// imported who are no longer returned. Members invited by hand, and the
+// owner, are left alone unless the directory lists them.
+
+var ErrDirectorySyncNotFound = errors.New("directory sync not configured")
+
+// DirectorySync is an organization's directory connection and the outcome
+// of its last sync
+type DirectorySync struct {
+	OrganizationID uuid.UUID `db:"organization_id" json:"organization_id"`
+	URL            string    `db:"url" json:"url"` // ldaps://host[:port]
+	BindDN         string    `db:"bind_dn" json:"bind_dn"`
+	// BindPassword is never returned; updates that leave it out keep the
+	// current one
+	BindPassword   string               `db:"bind_password" json:"bind_password,omitempty"`
+	BaseDN         string               `db:"base_dn" json:"base_dn"`
+	UserFilter     string               `db:"user_filter" json:"user_filter"`
+	EmailAttribute string               `db:"email_attribute" json:"email_attribute"`
+	NameAttribute  string               `db:"name_attribute" json:"name_attribute"`
+	GroupRoles     DirectoryGroupRoles  `db:"group_roles" json:"group_roles"`
+	Enabled        bool                 `db:"enabled" json:"enabled"` // synced on the schedule
+	LastResult     *DirectorySyncResult `db:"last_result" json:"last_result,omitempty"`
+	CreatedAt      time.Time            `db:"created_at" json:"created_at"`
+	UpdatedAt      time.Time            `db:"updated_at" json:"updated_at"`
+}
+
+// DirectoryGroupRoles maps group DNs to the role their members get. A user
+// in several mapped groups gets the one with the most permissions; users
+// in none are sub-accounts.
+type DirectoryGroupRoles map[string]string
+
+// Value implements the driver.Valuer interface for DirectoryGroupRoles
+func (g DirectoryGroupRoles) Value() (driver.Value, error) {
+	if g == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(g)
+}
+
+// Scan implements the sql.Scanner interface for DirectoryGroupRoles
+func (g *DirectoryGroupRoles) Scan(value interface{}) error {
+	if value == nil {
+		*g = DirectoryGroupRoles{}
+		return nil
+	}
+	return json.Unmarshal(value.([]byte), g)
+}
+
+// roleFor returns the role for a user in groups
+func (g DirectoryGroupRoles) roleFor(groups []string) string {
+	role := "sub_account"
+	for dn, mapped := range g {
+		if mapped == "admin" && slices.ContainsFunc(groups, func(group string) bool { return sameDN(group, dn) }) {
+			role = "admin"
+		}
+	}
+	return role
+}
+
+// sameDN compares DNs the way directories usually do: without case, and
+// ignoring spaces around the separators
+func sameDN(a, b string) bool {
+	normalize := func(dn string) string {
+		parts := strings.Split(dn, ",")
+		for i, p := range parts {
+			parts[i] = strings.TrimSpace(p)
+		}
+		return strings.ToLower(strings.Join(parts, ","))
+	}
+	return normalize(a) == normalize(b)
+}
+
+// DirectorySyncResult reports what a sync changed
+type DirectorySyncResult struct {
+	Status      string    `json:"status"` // succeeded or failed
+	Error       string    `json:"error,omitempty"`
+	Added       int       `json:"added"`
+	Updated     int       `json:"updated"` // role changes
+	Deactivated int       `json:"deactivated"`
+	Skipped     int       `json:"skipped"` // entries that could not be imported or updated
+	SyncedAt    time.Time `json:"synced_at"`
+}
+
+// Value implements the driver.Valuer interface for DirectorySyncResult
+func (r DirectorySyncResult) Value() (driver.Value, error) {
+	return json.Marshal(r)
+}
+
+// Scan implements the sql.Scanner interface for DirectorySyncResult
+func (r *DirectorySyncResult) Scan(value interface{}) error {
+	if value == nil {
+		return nil
+	}
+	return json.Unmarshal(value.([]byte), r)
+}
+
+const (
+	DirectorySyncSucceeded = "succeeded"
+	DirectorySyncFailed    = "failed"
+)
+
+type DirectorySyncRequest struct {
+	URL            string            `json:"url"`
+	BindDN         string            `json:"bind_dn"`
+	BindPassword   string            `json:"bind_password"`
+	BaseDN         string            `json:"base_dn"`
+	UserFilter     string            `json:"user_filter"`     // defaults to people with an email address
+	EmailAttribute string            `json:"email_attribute"` // defaults to mail
+	NameAttribute  string            `json:"name_attribute"`  // defaults to displayName
+	GroupRoles     map[string]string `json:"group_roles"`
+	Enabled        *bool             `json:"enabled,omitempty"` // defaults to true
+}
+
+const (
+	defaultDirectoryUserFilter     = "(&(objectClass=person)(mail=*))"
+	defaultDirectoryEmailAttribute = "mail"
+	defaultDirectoryNameAttribute  = "displayName"
+)
+
+// ValidateDirectorySyncRequest checks a directory configuration, filling
+// in defaults. The server must be reached over TLS on a public address,
+// unless config allows private networks for local development.
+func ValidateDirectorySyncRequest(req *DirectorySyncRequest, config *DirectoryConfig) error {
+	u, err := url.Parse(req.URL)
+	if err != nil || u.Host == "" || (u.Scheme != "ldaps" && u.Scheme != "ldap") || u.Path != "" && u.Path != "/" {
+		return &ValidationError{Field: "url", Message: "expected an ldaps://host[:port] URL"}
+	}
+	if u.Scheme != "ldaps" && !config.AllowPrivateNetworks {
+		return &ValidationError{Field: "url", Message: "directories must be reached with ldaps"}
+	}
+	if len(req.URL) > 2048 {
+		return &ValidationError{Field: "url", Message: ErrFieldTooLong.Error()}
+	}
+	if u.User != nil || u.RawQuery != "" {
+		return &ValidationError{Field: "url", Message: "credentials and options are not allowed in the URL"}
+	}
+	if ip := net.ParseIP(u.Hostname()); ip != nil && !config.AllowPrivateNetworks && !isPublicIP(ip) {
+		return &ValidationError{Field: "url", Message: "directories cannot be on private addresses"}
+	}
+
+	if strings.TrimSpace(req.BindDN) == "" {
+		return &ValidationError{Field: "bind_dn", Message: ErrEmptyField.Error()}
+	}
+	if strings.TrimSpace(req.BaseDN) == "" {
+		return &ValidationError{Field: "base_dn", Message: ErrEmptyField.Error()}
+	}
+
+	if req.UserFilter == "" {
+		req.UserFilter = defaultDirectoryUserFilter
+	}
+	if _, err := ldap.CompileFilter(req.UserFilter); err != nil {
+		return &ValidationError{Field: "user_filter", Message: err.Error()}
+	}
+	if req.EmailAttribute == "" {
+		req.EmailAttribute = defaultDirectoryEmailAttribute
+	}
+	if req.NameAttribute == "" {
+		req.NameAttribute = defaultDirectoryNameAttribute
+	}
+	for field, attr := range map[string]string{"email_attribute": req.EmailAttribute, "name_attribute": req.NameAttribute} {
+		if len(attr) > 64 || strings.ContainsAny(attr, "()=*, ") {
+			return &ValidationError{Field: field, Message: fmt.Sprintf("%q is not an attribute name", attr)}
+		}
+	}
+
+	for dn, role := range req.GroupRoles {
+		if strings.TrimSpace(dn) == "" {
+			return &ValidationError{Field: "group_roles", Message: "group DNs cannot be empty"}
+		}
+		if !slices.Contains(AssignableRoles, role) {
+			return &ValidationError{Field: "group_roles", Message: fmt.Sprintf("unknown role %q", role)}
+		}
+	}
+	return nil
+}
+
+// DirectoryConfig controls directory syncs
+type DirectoryConfig struct {
+	Interval time.Duration // between scheduled syncs of an organization
+	Timeout  time.Duration // for a whole sync
+	// AllowPrivateNetworks lets directories use plain ldap and be reached
+	// on loopback and private addresses, for local development. Otherwise
+	// tenants could use syncs to probe the service's own network.
+	AllowPrivateNetworks bool
+}
+
+// NewDirectoryConfig creates a directory sync configuration from settings
+func NewDirectoryConfig(settings Settings) *DirectoryConfig {
+	interval, err := time.ParseDuration(settings.get("DIRECTORY_SYNC_INTERVAL", "1h"))
+	if err != nil || interval <= 0 {
+		interval = time.Hour
+	}
+	timeout, err := time.ParseDuration(settings.get("DIRECTORY_SYNC_TIMEOUT", "2m"))
+	if err != nil || timeout <= 0 {
+		timeout = 2 * time.Minute
+	}
+	return &DirectoryConfig{
+		Interval:             interval,
+		Timeout:              timeout,
+		AllowPrivateNetworks: settings.getBool("DIRECTORY_ALLOW_PRIVATE_NETWORKS", false),
+	}
+}
+
+// directorySyncJob is the kind of job that syncs an organization
+const directorySyncJob = "directory.sync"
+
+type directorySyncPayload struct {
+	OrganizationID uuid.UUID `json:"organization_id"`
+}
+
+// directorySchedulePoll is how often instances look for syncs that are due
+const directorySchedulePoll = time.Minute
+
+// DirectorySyncer runs directory syncs as background jobs. Every instance
+// looks for due syncs, and claiming one schedules the next, so each
+// organization is synced once per interval however many instances run.
+type DirectorySyncer struct {
+	store  Store
+	jobs   *JobRunner
+	config *DirectoryConfig
+	dialer *net.Dialer
+	record func(ctx context.Context, event *AuditEvent)
+	logger *slog.Logger
+	now    func() time.Time
+}
+
+// NewDirectorySyncer starts scheduling syncs. record is given the audit
+// events for the changes syncs make.
+func NewDirectorySyncer(store Store, jobs *JobRunner, config *DirectoryConfig, record func(context.Context, *AuditEvent), logger *slog.Logger) *DirectorySyncer {
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	if !config.AllowPrivateNetworks {
+		// Checked on the resolved address, so DNS cannot point a directory
+		// inside the network after its URL was validated
+		dialer.Control = func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+				return fmt.Errorf("directory address %s is not public", host)
+			}
+			return nil
+		}
+	}
+
+	d := &DirectorySyncer{
+		store:  store,
+		jobs:   jobs,
+		config: config,
+		dialer: dialer,
+		record: record,
+		logger: logger,
+		now:    time.Now,
+	}
+	jobs.Register(directorySyncJob, d.run)
+	go d.schedule()
+	return d
+}
+
+func (d *DirectorySyncer) schedule() {
+	ticker := time.NewTicker(directorySchedulePoll)
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		if err := d.enqueueDue(ctx); err != nil {
+			d.logger.Error("failed to schedule directory syncs", "error", err)
+		}
+		cancel()
+	}
+}
+
+// enqueueDue queues a sync of every organization whose sync is due
+func (d *DirectorySyncer) enqueueDue(ctx context.Context) error {
+	due, err := d.store.ClaimDueDirectorySyncs(ctx, d.now(), d.config.Interval)
+	if err != nil {
+		return err
+	}
+	for _, orgID := range due {
+		if err := d.Enqueue(ctx, orgID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Enqueue queues a sync of an organization
+func (d *DirectorySyncer) Enqueue(ctx context.Context, orgID uuid.UUID) error {
+	_, err := d.jobs.Enqueue(ctx, directorySyncJob, directorySyncPayload{OrganizationID: orgID}, time.Time{})
+	return err
+}
+
+// run is the job handler. A failed sync is reported rather than retried;
+// the next scheduled one tries again.
+func (d *DirectorySyncer) run(ctx context.Context, raw json.RawMessage) error {
+	var payload directorySyncPayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return err
+	}
+	_, err := d.Sync(ctx, payload.OrganizationID)
+	if err == ErrDirectorySyncNotFound {
+		// Removed since the job was queued
+		return nil
+	}
+	return err
+}
+
+// Sync syncs an organization's members with its directory, recording the
+// result and reporting it in the audit log. The error is only set if the
+// result could not be recorded; a failed sync is a result.
+func (d *DirectorySyncer) Sync(ctx context.Context, orgID uuid.UUID) (*DirectorySyncResult, error) {
+	sync, err := d.store.GetDirectorySync(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	syncCtx, cancel := context.WithTimeout(ctx, d.config.Timeout)
+	defer cancel()
+	result := &DirectorySyncResult{Status: DirectorySyncSucceeded}
+	if err := d.sync(syncCtx, sync, result); err != nil {
+		result.Status, result.Error = DirectorySyncFailed, err.Error()
+		d.logger.WarnContext(ctx, "directory sync failed", "organization_id", orgID, "error", err)
+	}
+	result.SyncedAt = d.now().UTC()
+
+	if err := d.store.RecordDirectorySyncResult(ctx, orgID, result); err != nil {
+		return nil, err
+	}
+
+	action, metadata := "directory.synced", AuditMetadata{
+		"added":       fmt.Sprint(result.Added),
+		"updated":     fmt.Sprint(result.Updated),
+		"deactivated": fmt.Sprint(result.Deactivated),
+		"skipped":     fmt.Sprint(result.Skipped),
+	}
+	if result.Status == DirectorySyncFailed {
+		action, metadata["error"] = "directory.sync_failed", result.Error
+	}
+	d.audit(ctx, action, orgID, orgID.String(), metadata)
+	return result, nil
+}
+
+// directoryUser is a user as the directory lists them
+type directoryUser struct {
+	email, name, role string
+}
+
+// sync applies the directory's users to the organization, counting the
+// changes in result. Changes made before an error stay made.
+func (d *DirectorySyncer) sync(ctx context.Context, sync *DirectorySync, result *DirectorySyncResult) error {
+	conn, err := dialLDAP(ctx, sync.URL, d.dialer)
+	if err != nil {
+		return fmt.Errorf("connecting to the directory: %w", err)
+	}
+	defer conn.Close()
+	if err := conn.Bind(sync.BindDN, sync.BindPassword); err != nil {
+		return fmt.Errorf("binding to the directory: %w", err)
+	}
+	entries, err := searchLDAP(conn, sync.BaseDN, sync.UserFilter, []string{sync.EmailAttribute, sync.NameAttribute, "cn", "memberOf"})
+	if err != nil {
+		return fmt.Errorf("searching the directory: %w", err)
+	}
+
+	listed := make(map[string]directoryUser, len(entries))
+	for _, entry := range entries {
+		email := strings.ToLower(strings.TrimSpace(entry.GetEqualFoldAttributeValue(sync.EmailAttribute)))
+		if ValidateEmail(email) != nil {
+			result.Skipped++
+			continue
+		}
+		name := entry.GetEqualFoldAttributeValue(sync.NameAttribute)
+		if name == "" {
+			name = entry.GetEqualFoldAttributeValue("cn")
+		}
+		if ValidateName(name) != nil {
+			name = email
+		}
+		if _, ok := listed[email]; !ok {
+			listed[email] = directoryUser{email: email, name: name, role: sync.GroupRoles.roleFor(entry.GetEqualFoldAttributeValues("memberOf"))}
+		}
+	}
+
+	members, err := d.store.GetOrganizationUsers(ctx, sync.OrganizationID)
+	if err != nil {
+		return err
+	}
+	managed, err := d.store.ListDirectoryUsers(ctx, sync.OrganizationID)
+	if err != nil {
+		return err
+	}
+	// A search that comes back empty is far more likely a broken filter or
+	// base DN than a directory with nobody in it
+	if len(listed) == 0 && len(managed) > 0 {
+		return errors.New("the directory search returned no users; not deactivating every synced member")
+	}
+
+	byEmail := make(map[string]*User, len(members))
+	for i := range members {
+		byEmail[strings.ToLower(members[i].Email)] = &members[i]
+	}
+	var matched []uuid.UUID
+
+	// Deactivate first, so leavers free their seats for joiners
+	for _, id := range managed {
+		member := memberByID(members, id)
+		if member == nil {
+			continue
+		}
+		if _, ok := listed[strings.ToLower(member.Email)]; ok {
+			continue
+		}
+		switch err := d.store.DeleteUser(ctx, id); err {
+		case nil:
+			result.Deactivated++
+			d.audit(ctx, "user.deleted", sync.OrganizationID, id.String(), AuditMetadata{"source": "directory"})
+		case ErrUserNotFound, ErrDeleteOwner:
+		default:
+			return err
+		}
+	}
+
+	emails := make([]string, 0, len(listed))
+	for email := range listed {
+		emails = append(emails, email)
+	}
+	sort.Strings(emails)
+	for _, email := range emails {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		want := listed[email]
+
+		member, ok := byEmail[email]
+		if !ok {
+			user, err := d.store.AddUserToOrganization(ctx, sync.OrganizationID, email, want.name)
+			switch err {
+			case nil:
+			case ErrEmailTaken, ErrMaxSubAccounts:
+				// In another organization, or out of seats
+				result.Skipped++
+				continue
+			default:
+				return err
+			}
+			result.Added++
+			d.audit(ctx, "user.added", sync.OrganizationID, user.ID.String(), AuditMetadata{"role": user.Role, "source": "directory"})
+			member = user
+		}
+		if member.Role == "owner" {
+			continue
+		}
+		matched = append(matched, member.ID)
+
+		if member.Role != want.role {
+			user, err := d.store.UpdateUserRole(ctx, sync.OrganizationID, member.ID, want.role)
+			switch err {
+			case nil:
+			case ErrMaxSubAccounts, ErrUserNotFound:
+				result.Skipped++
+				continue
+			default:
+				return err
+			}
+			if ok {
+				// Joiners' promotions are part of being added
+				result.Updated++
+			}
+			d.audit(ctx, "user.role_changed", sync.OrganizationID, user.ID.String(), AuditMetadata{"from": member.Role, "to": user.Role, "source": "directory"})
+		}
+	}
+
+	return d.store.SetDirectoryUsers(ctx, sync.OrganizationID, matched)
+}
+
+func memberByID(members []User, id uuid.UUID) *User {
+	for i := range members {
+		if members[i].ID == id {
+			return &members[i]
+		}
+	}
+	return nil
+}
+
+// audit records an action a sync took, which has no actor
+func (d *DirectorySyncer) audit(ctx context.Context, action string, orgID uuid.UUID, targetID string, metadata AuditMetadata) {
+	if d.record != nil {
+		d.record(ctx, &AuditEvent{Action: action, OrganizationID: &orgID, TargetID: targetID, Metadata: metadata})
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+const directorySyncColumns = `organization_id, url, bind_dn, bind_password, base_dn, user_filter,
+	email_attribute, name_attribute, group_roles, enabled, last_result, created_at, updated_at`
+
+func (db *DB) GetDirectorySync(ctx context.Context, orgID uuid.UUID) (*DirectorySync, error) {
+	sync := &DirectorySync{}
+	err := db.tenantRead(ctx, orgID, func(q sqlx.QueryerContext) error {
+		return sqlx.GetContext(ctx, q, sync, `
+			SELECT `+directorySyncColumns+` FROM directory_syncs WHERE organization_id = $1
+		`, orgID)
+	})
+	if err == sql.ErrNoRows {
+		return nil, ErrDirectorySyncNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return sync, nil
+}
+
+func (db *DB) PutDirectorySync(ctx context.Context, sync *DirectorySync) error {
+	return db.tenantTx(ctx, sync.OrganizationID, func(tx *sqlx.Tx) error {
+		return tx.GetContext(ctx, sync, `
+			INSERT INTO directory_syncs (organization_id, url, bind_dn, bind_password, base_dn, user_filter,
+				email_attribute, name_attribute, group_roles, enabled)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+			ON CONFLICT (organization_id) DO UPDATE SET
+				url = EXCLUDED.url, bind_dn = EXCLUDED.bind_dn, bind_password = EXCLUDED.bind_password,
+				base_dn = EXCLUDED.base_dn, user_filter = EXCLUDED.user_filter,
+				email_attribute = EXCLUDED.email_attribute, name_attribute = EXCLUDED.name_attribute,
+				group_roles = EXCLUDED.group_roles, enabled = EXCLUDED.enabled,
+				next_sync_at = NOW(), updated_at = NOW()
+			RETURNING `+directorySyncColumns,
+			sync.OrganizationID, sync.URL, sync.BindDN, sync.BindPassword, sync.BaseDN, sync.UserFilter,
+			sync.EmailAttribute, sync.NameAttribute, sync.GroupRoles, sync.Enabled)
+	})
+}
+
+func (db *DB) DeleteDirectorySync(ctx context.Context, orgID uuid.UUID) error {
+	return db.tenantTx(ctx, orgID, func(tx *sqlx.Tx) error {
+		result, err := tx.ExecContext(ctx, `DELETE FROM directory_syncs WHERE organization_id = $1`, orgID)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrDirectorySyncNotFound
+		}
+		_, err = tx.ExecContext(ctx, `DELETE FROM directory_users WHERE organization_id = $1`, orgID)
+		return err
+	})
+}
+
+func (db *DB) ClaimDueDirectorySyncs(ctx context.Context, now time.Time, interval time.Duration) ([]uuid.UUID, error) {
+	due := []uuid.UUID{}
+	err := db.transact(ctx, func(tx *sqlx.Tx) error {
+		// SKIP LOCKED lets instances polling at once claim different syncs
+		return tx.SelectContext(ctx, &due, `
+			UPDATE directory_syncs SET next_sync_at = $2
+			WHERE organization_id IN (
+				SELECT organization_id FROM directory_syncs
+				WHERE enabled AND next_sync_at <= $1
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING organization_id
+		`, now.UTC(), now.Add(interval).UTC())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return due, nil
+}
+
+func (db *DB) RecordDirectorySyncResult(ctx context.Context, orgID uuid.UUID, result *DirectorySyncResult) error {
+	return db.tenantTx(ctx, orgID, func(tx *sqlx.Tx) error {
+		res, err := tx.ExecContext(ctx, `
+			UPDATE directory_syncs SET last_result = $2 WHERE organization_id = $1
+		`, orgID, result)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrDirectorySyncNotFound
+		}
+		return nil
+	})
+}
+
+func (db *DB) ListDirectoryUsers(ctx context.Context, orgID uuid.UUID) ([]uuid.UUID, error) {
+	ids := []uuid.UUID{}
+	err := db.tenantRead(ctx, orgID, func(q sqlx.QueryerContext) error {
+		return sqlx.SelectContext(ctx, q, &ids, `
+			SELECT user_id FROM directory_users WHERE organization_id = $1 ORDER BY user_id
+		`, orgID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (db *DB) SetDirectoryUsers(ctx context.Context, orgID uuid.UUID, userIDs []uuid.UUID) error {
+	return db.tenantTx(ctx, orgID, func(tx *sqlx.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM directory_users WHERE organization_id = $1`, orgID); err != nil {
+			return err
+		}
+		if len(userIDs) == 0 {
+			return nil
+		}
+		// Only the organization's live members; a user may have been
+		// removed, or the sync deleted, while it ran
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO directory_users (user_id, organization_id)
+			SELECT id, organization_id FROM users
+			WHERE id = ANY($2) AND organization_id = $1 AND deleted_at IS NULL
+				AND EXISTS (SELECT 1 FROM directory_syncs WHERE organization_id = $1)
+		`, orgID, userIDs)
+		return err
+	})
+}
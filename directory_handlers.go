@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+func (s *Server) handleGetDirectorySync(w http.ResponseWriter, r *http.Request) {
+	sync, err := s.store.GetDirectorySync(r.Context(), pathOrgID(r))
+	if err != nil {
+		switch err {
+		case ErrDirectorySyncNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			s.logger.ErrorContext(r.Context(), "failed to get directory sync", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+	sync.BindPassword = ""
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sync)
+}
+
+// handlePutDirectorySync configures the organization's directory sync. An
+// enabled sync runs within a minute and then on the schedule.
+func (s *Server) handlePutDirectorySync(w http.ResponseWriter, r *http.Request) {
+	orgID := pathOrgID(r)
+
+	var req DirectorySyncRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	err := ValidateDirectorySyncRequest(&req, s.directory.config)
+	if err == nil && req.BindPassword == "" {
+		// Keep the password of the current configuration, if there is one
+		current, getErr := s.store.GetDirectorySync(r.Context(), orgID)
+		switch getErr {
+		case nil:
+			req.BindPassword = current.BindPassword
+		case ErrDirectorySyncNotFound:
+			err = &ValidationError{Field: "bind_password", Message: ErrEmptyField.Error()}
+		default:
+			s.logger.ErrorContext(r.Context(), "failed to get directory sync", "error", getErr)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err != nil {
+		writeValidationError(w, err)
+		return
+	}
+
+	sync := &DirectorySync{
+		OrganizationID: orgID,
+		URL:            req.URL,
+		BindDN:         req.BindDN,
+		BindPassword:   req.BindPassword,
+		BaseDN:         req.BaseDN,
+		UserFilter:     req.UserFilter,
+		EmailAttribute: req.EmailAttribute,
+		NameAttribute:  req.NameAttribute,
+		GroupRoles:     DirectoryGroupRoles(req.GroupRoles),
+		Enabled:        req.Enabled == nil || *req.Enabled,
+	}
+	if sync.GroupRoles == nil {
+		sync.GroupRoles = DirectoryGroupRoles{}
+	}
+	if err := s.store.PutDirectorySync(r.Context(), sync); err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to save directory sync", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	s.recordAudit(r, "directory.updated", orgID, orgID.String(), AuditMetadata{
+		"url":         sync.URL,
+		"base_dn":     sync.BaseDN,
+		"user_filter": sync.UserFilter,
+		"enabled":     strconv.FormatBool(sync.Enabled),
+	})
+
+	sync.BindPassword = ""
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sync)
+}
+
+// handleDeleteDirectorySync stops syncing. Synced members stay, as members
+// the organization manages by hand.
+func (s *Server) handleDeleteDirectorySync(w http.ResponseWriter, r *http.Request) {
+	orgID := pathOrgID(r)
+
+	if err := s.store.DeleteDirectorySync(r.Context(), orgID); err != nil {
+		switch err {
+		case ErrDirectorySyncNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			s.logger.ErrorContext(r.Context(), "failed to delete directory sync", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	s.recordAudit(r, "directory.deleted", orgID, orgID.String(), nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleRunDirectorySync queues a sync now, outside the schedule. Its
+// result shows up in the configuration and the audit log.
+func (s *Server) handleRunDirectorySync(w http.ResponseWriter, r *http.Request) {
+	orgID := pathOrgID(r)
+
+	if _, err := s.store.GetDirectorySync(r.Context(), orgID); err != nil {
+		switch err {
+		case ErrDirectorySyncNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			s.logger.ErrorContext(r.Context(), "failed to get directory sync", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+	if err := s.directory.Enqueue(r.Context(), orgID); err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to queue directory sync", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
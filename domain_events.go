@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+)
+
+// DomainEventVersion is the version of the DomainEvent schema. It changes
+// only when a field is removed or changes meaning.
+const DomainEventVersion = 1
+
+// domainEventTypes lists the audited actions published as domain events:
+// the organization and user lifecycle that billing and analytics follow
+var domainEventTypes = []string{
+	"organization.created",
+	"organization.tier_changed",
+	"organization.suspended",
+	"organization.reinstated",
+	"organization.deleted",
+	"user.added",
+	"user.deleted",
+}
+
+// DomainEvent is a lifecycle event as published to the message bus. See
+// docs/events.md for the schema consumers rely on.
+type DomainEvent struct {
+	ID             uuid.UUID         `json:"id"` // consumers use it to drop redeliveries
+	Type           string            `json:"type"`
+	Version        int               `json:"version"`
+	OccurredAt     time.Time         `json:"occurred_at"`
+	OrganizationID uuid.UUID         `json:"organization_id"`
+	ActorID        *uuid.UUID        `json:"actor_id,omitempty"`
+	SubjectID      string            `json:"subject_id"`
+	Data           map[string]string `json:"data"`
+}
+
+// domainEventPrivateKeys are audit metadata left out of domain events
+var domainEventPrivateKeys = []string{"remote_addr", "client_ip"}
+
+// NewDomainEvent returns the domain event for an audited action, or nil if
+// the action is not published
+func NewDomainEvent(event *AuditEvent) *DomainEvent {
+	if event.OrganizationID == nil || !slices.Contains(domainEventTypes, event.Action) {
+		return nil
+	}
+
+	occurredAt := event.CreatedAt
+	if occurredAt.IsZero() {
+		occurredAt = time.Now().UTC()
+	}
+	data := make(map[string]string, len(event.Metadata))
+	for k, v := range event.Metadata {
+		if !slices.Contains(domainEventPrivateKeys, k) {
+			data[k] = v
+		}
+	}
+	return &DomainEvent{
+		ID:             uuid.New(),
+		Type:           event.Action,
+		Version:        DomainEventVersion,
+		OccurredAt:     occurredAt,
+		OrganizationID: *event.OrganizationID,
+		ActorID:        event.ActorID,
+		SubjectID:      event.TargetID,
+		Data:           data,
+	}
+}
+
+// DomainEventPublisher sends domain events to a message bus
+type DomainEventPublisher interface {
+	Name() string
+	Publish(ctx context.Context, event *DomainEvent) error
+	Close() error
+}
+
+// NewDomainEventPublisher returns the publisher selected by
+// EVENT_BUS, or nil if it is unset
+func NewDomainEventPublisher(settings Settings) (DomainEventPublisher, error) {
+	bus := settings("EVENT_BUS")
+	if bus == "" {
+		return nil, nil
+	}
+	rawURL := settings("EVENT_BUS_URL")
+	topic := settings.get("EVENT_BUS_TOPIC", "huachuca.events")
+
+	switch bus {
+	case "nats":
+		return NewNATSPublisher(rawURL, topic)
+	case "kafka":
+		return NewKafkaRESTPublisher(rawURL, topic)
+	default:
+		return nil, fmt.Errorf("unknown EVENT_BUS %q: expected nats or kafka", bus)
+	}
+}
+
+// publishDomainEventJob is the kind of job that publishes a domain event.
+// Queued in the same store as other jobs, they form an outbox: events are
+// published at least once, surviving restarts and bus outages.
+const publishDomainEventJob = "events.publish"
+
+// queueDomainEvent queues an audited action for publishing, if it is a
+// lifecycle event and a message bus is configured
+func (s *Server) queueDomainEvent(ctx context.Context, event *AuditEvent) {
+	if s.bus == nil || s.jobs == nil {
+		return
+	}
+	domainEvent := NewDomainEvent(event)
+	if domainEvent == nil {
+		return
+	}
+	if _, err := s.jobs.Enqueue(ctx, publishDomainEventJob, domainEvent, time.Time{}); err != nil {
+		s.logger.ErrorContext(ctx, "failed to queue domain event", "type", domainEvent.Type, "error", err)
+	}
+}
+
+// publishDomainEvent is the job handler publishing a queued domain event
+func publishDomainEvent(bus DomainEventPublisher) JobHandler {
+	return func(ctx context.Context, payload json.RawMessage) error {
+		var event DomainEvent
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return err
+		}
+		return bus.Publish(ctx, &event)
+	}
+}
+
+// NATSPublisher publishes each event to the subject <prefix>.<type> with
+// the NATS client, which reconnects on its own after errors. Each publish
+// waits for the server to acknowledge it.
+type NATSPublisher struct {
+	url     string
+	prefix  string
+	timeout time.Duration
+
+	mu   sync.Mutex
+	conn *nats.Conn // nil until the first publish
+}
+
+// NewNATSPublisher creates a publisher for a URL of the form
+// nats://[user:pass@]host:4222, or tls:// for TLS. It connects on the first
+// publish, so the server starts while the bus is down.
+func NewNATSPublisher(rawURL, prefix string) (*NATSPublisher, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "nats" && u.Scheme != "tls") || u.Host == "" {
+		return nil, fmt.Errorf("invalid EVENT_BUS_URL %q: expected nats://host:port or tls://host:port", rawURL)
+	}
+	return &NATSPublisher{
+		url:     rawURL,
+		prefix:  prefix,
+		timeout: 10 * time.Second,
+	}, nil
+}
+
+func (p *NATSPublisher) Name() string { return "nats" }
+
+func (p *NATSPublisher) Publish(ctx context.Context, event *DomainEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	conn, err := p.connection()
+	if err != nil {
+		return fmt.Errorf("connecting to nats: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	if err := conn.Publish(p.prefix+"."+event.Type, payload); err != nil {
+		return fmt.Errorf("publishing to nats: %w", err)
+	}
+	// The flush's PONG confirms the server processed the message
+	if err := conn.FlushWithContext(ctx); err != nil {
+		return fmt.Errorf("publishing to nats: %w", err)
+	}
+	return nil
+}
+
+// connection returns the connection to the server, connecting if there is
+// none or it has been closed
+func (p *NATSPublisher) connection() (*nats.Conn, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.conn != nil && !p.conn.IsClosed() {
+		return p.conn, nil
+	}
+	conn, err := nats.Connect(p.url,
+		nats.Name("huachuca"),
+		nats.Timeout(p.timeout),
+		nats.MaxReconnects(-1),
+	)
+	if err != nil {
+		return nil, err
+	}
+	p.conn = conn
+	return conn, nil
+}
+
+func (p *NATSPublisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+	return nil
+}
+
+// KafkaRESTPublisher produces each event to a Kafka topic through a
+// Confluent-compatible REST Proxy, keyed by organization so each
+// organization's events stay in order within a partition
+type KafkaRESTPublisher struct {
+	endpoint string
+	client   *http.Client
+}
+
+// NewKafkaRESTPublisher creates a publisher for the REST Proxy at rawURL
+func NewKafkaRESTPublisher(rawURL, topic string) (*KafkaRESTPublisher, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid EVENT_BUS_URL %q: expected the http(s) URL of a Kafka REST Proxy", rawURL)
+	}
+	return &KafkaRESTPublisher{
+		endpoint: strings.TrimSuffix(u.String(), "/") + "/topics/" + url.PathEscape(topic),
+		client:   &http.Client{Timeout: 15 * time.Second},
+	}, nil
+}
+
+func (p *KafkaRESTPublisher) Name() string { return "kafka" }
+
+func (p *KafkaRESTPublisher) Publish(ctx context.Context, event *DomainEvent) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"records": []map[string]interface{}{
+			{"key": event.OrganizationID.String(), "value": event},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s responded with status %d", p.endpoint, resp.StatusCode)
+	}
+
+	// The proxy answers 200 even when a record failed
+	var result struct {
+		Offsets []struct {
+			ErrorCode *int   `json:"error_code"`
+			Error     string `json:"error"`
+		} `json:"offsets"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("decoding kafka rest proxy response: %w", err)
+	}
+	for _, offset := range result.Offsets {
+		if offset.ErrorCode != nil {
+			return fmt.Errorf("kafka rejected the event: %s (code %d)", offset.Error, *offset.ErrorCode)
+		}
+	}
+	return nil
+}
+
+func (p *KafkaRESTPublisher) Close() error { return nil }
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewDomainEvent(t *testing.T) {
+	orgID, actorID := uuid.New(), uuid.New()
+
+	event := NewDomainEvent(&AuditEvent{
+		Action:         "user.added",
+		OrganizationID: &orgID,
+		ActorID:        &actorID,
+		TargetID:       "user-1",
+		Metadata:       AuditMetadata{"role": "member", "client_ip": "10.0.0.1", "remote_addr": "10.0.0.1:1234"},
+	})
+	require.NotNil(t, event)
+	require.Equal(t, "user.added", event.Type)
+	require.Equal(t, DomainEventVersion, event.Version)
+	require.Equal(t, orgID, event.OrganizationID)
+	require.Equal(t, &actorID, event.ActorID)
+	require.Equal(t, "user-1", event.SubjectID)
+	require.Equal(t, map[string]string{"role": "member"}, event.Data)
+	require.False(t, event.OccurredAt.IsZero())
+
+	require.Nil(t, NewDomainEvent(&AuditEvent{Action: "auth.login", OrganizationID: &orgID}))
+	require.Nil(t, NewDomainEvent(&AuditEvent{Action: "organization.created"}))
+}
+
+func TestNewDomainEventPublisherFromEnv(t *testing.T) {
+	publisher, err := NewDomainEventPublisher(os.Getenv)
+	require.NoError(t, err)
+	require.Nil(t, publisher)
+
+	t.Setenv("EVENT_BUS", "nats")
+	t.Setenv("EVENT_BUS_URL", "nats://localhost")
+	publisher, err = NewDomainEventPublisher(os.Getenv)
+	require.NoError(t, err)
+	require.Equal(t, "nats", publisher.Name())
+	require.Equal(t, "nats://localhost", publisher.(*NATSPublisher).url)
+
+	t.Setenv("EVENT_BUS_URL", "http://localhost:8082")
+	_, err = NewDomainEventPublisher(os.Getenv)
+	require.Error(t, err)
+
+	t.Setenv("EVENT_BUS", "kafka")
+	t.Setenv("EVENT_BUS_TOPIC", "billing")
+	publisher, err = NewDomainEventPublisher(os.Getenv)
+	require.NoError(t, err)
+	require.Equal(t, "http://localhost:8082/topics/billing", publisher.(*KafkaRESTPublisher).endpoint)
+
+	t.Setenv("EVENT_BUS", "rabbitmq")
+	_, err = NewDomainEventPublisher(os.Getenv)
+	require.Error(t, err)
+}
+
+// fakeNATS is a NATS server that acknowledges pings and records what is
+// published to it
+type fakeNATS struct {
+	listener net.Listener
+
+	mu       sync.Mutex
+	conns    []net.Conn
+	connects []string
+	subjects []string
+	payloads [][]byte
+}
+
+func newFakeNATS(t *testing.T) *fakeNATS {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &fakeNATS{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			server.mu.Lock()
+			server.conns = append(server.conns, conn)
+			server.mu.Unlock()
+			go server.serve(conn)
+		}
+	}()
+	t.Cleanup(func() {
+		listener.Close()
+		server.dropConnections()
+	})
+	return server
+}
+
+func (s *fakeNATS) serve(conn net.Conn) {
+	defer conn.Close()
+	fmt.Fprint(conn, "INFO {\"server_id\":\"fake\",\"max_payload\":1048576}\r\n")
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "CONNECT "):
+			s.mu.Lock()
+			s.connects = append(s.connects, strings.TrimPrefix(line, "CONNECT "))
+			s.mu.Unlock()
+		case line == "PING":
+			fmt.Fprint(conn, "PONG\r\n")
+		case strings.HasPrefix(line, "PUB "):
+			fields := strings.Fields(line)
+			size, _ := strconv.Atoi(fields[len(fields)-1])
+			payload := make([]byte, size+2) // with the trailing CRLF
+			if _, err := io.ReadFull(reader, payload); err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.subjects = append(s.subjects, fields[1])
+			s.payloads = append(s.payloads, payload[:size])
+			s.mu.Unlock()
+		}
+	}
+}
+
+func (s *fakeNATS) dropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+}
+
+func TestNATSPublisher(t *testing.T) {
+	ctx := context.Background()
+	server := newFakeNATS(t)
+
+	publisher, err := NewNATSPublisher("nats://svc:secret@"+server.listener.Addr().String(), "huachuca.events")
+	require.NoError(t, err)
+	defer publisher.Close()
+
+	event := &DomainEvent{
+		ID:             uuid.New(),
+		Type:           "organization.created",
+		Version:        DomainEventVersion,
+		OccurredAt:     time.Now().UTC(),
+		OrganizationID: uuid.New(),
+		Data:           map[string]string{"name": "Acme"},
+	}
+	require.NoError(t, publisher.Publish(ctx, event))
+
+	server.mu.Lock()
+	require.Len(t, server.connects, 1)
+	var options map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(server.connects[0]), &options))
+	require.Equal(t, "svc", options["user"])
+	require.Equal(t, "secret", options["pass"])
+	require.Equal(t, []string{"huachuca.events.organization.created"}, server.subjects)
+	var published DomainEvent
+	require.NoError(t, json.Unmarshal(server.payloads[0], &published))
+	server.mu.Unlock()
+	require.Equal(t, event.ID, published.ID)
+	require.Equal(t, "Acme", published.Data["name"])
+
+	t.Run("Reconnects after the connection drops", func(t *testing.T) {
+		server.dropConnections()
+
+		// Publishes fail until the client has reconnected
+		event.Type = "user.added"
+		require.Eventually(t, func() bool {
+			return publisher.Publish(ctx, event) == nil
+		}, 10*time.Second, 50*time.Millisecond)
+
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		require.Len(t, server.connects, 2)
+		require.Equal(t, "huachuca.events.user.added", server.subjects[len(server.subjects)-1])
+	})
+}
+
+func TestKafkaRESTPublisher(t *testing.T) {
+	ctx := context.Background()
+
+	var errorCode *int
+	var records []json.RawMessage
+	var keys []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/topics/huachuca.events", r.URL.Path)
+		require.Equal(t, "application/vnd.kafka.json.v2+json", r.Header.Get("Content-Type"))
+		var body struct {
+			Records []struct {
+				Key   string          `json:"key"`
+				Value json.RawMessage `json:"value"`
+			} `json:"records"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		for _, record := range body.Records {
+			keys = append(keys, record.Key)
+			records = append(records, record.Value)
+		}
+		offset := map[string]interface{}{"partition": 0, "offset": len(records)}
+		if errorCode != nil {
+			offset = map[string]interface{}{"error_code": *errorCode, "error": "topic authorization failed"}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"offsets": []interface{}{offset}})
+	}))
+	defer proxy.Close()
+
+	publisher, err := NewKafkaRESTPublisher(proxy.URL+"/", "huachuca.events")
+	require.NoError(t, err)
+
+	event := &DomainEvent{
+		ID:             uuid.New(),
+		Type:           "organization.tier_changed",
+		Version:        DomainEventVersion,
+		OccurredAt:     time.Now().UTC(),
+		OrganizationID: uuid.New(),
+		SubjectID:      "org",
+		Data:           map[string]string{"subscription_tier": "enterprise"},
+	}
+	require.NoError(t, publisher.Publish(ctx, event))
+	require.Equal(t, []string{event.OrganizationID.String()}, keys)
+	var published DomainEvent
+	require.NoError(t, json.Unmarshal(records[0], &published))
+	require.Equal(t, event.ID, published.ID)
+	require.Equal(t, "organization.tier_changed", published.Type)
+
+	code := 29
+	errorCode = &code
+	require.ErrorContains(t, publisher.Publish(ctx, event), "topic authorization failed")
+}
+
+func TestDomainEventsArePublished(t *testing.T) {
+	ctx := context.Background()
+
+	var mu sync.Mutex
+	var published []DomainEvent
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Records []struct {
+				Value DomainEvent `json:"value"`
+			} `json:"records"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		for _, record := range body.Records {
+			published = append(published, record.Value)
+		}
+		mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]interface{}{"offsets": []interface{}{map[string]int{"offset": 1}}})
+	}))
+	defer proxy.Close()
+
+	t.Setenv("EVENT_BUS", "kafka")
+	t.Setenv("EVENT_BUS_URL", proxy.URL)
+	t.Setenv("JOB_POLL_INTERVAL", "5ms")
+
+	store := NewMemoryStore()
+	srv, err := NewServer(store, newTestConfig(t))
+	require.NoError(t, err)
+	defer srv.jobs.Close(ctx)
+
+	org, err := store.CreateOrganization(ctx, "Acme", "owner@acme.test", "Owner")
+	require.NoError(t, err)
+	owner, err := store.GetUser(ctx, org.OwnerID)
+	require.NoError(t, err)
+	token, err := srv.tokenManager.GenerateToken(owner)
+	require.NoError(t, err)
+
+	body := strings.NewReader(`{"email":"member@acme.test","name":"Member"}`)
+	req := httptest.NewRequest(http.MethodPost, "/organizations/"+org.ID.String()+"/users", body)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	addCSRFToken(t, srv, req)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var member User
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&member))
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(published) == 1
+	}, 2*time.Second, 10*time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	event := published[0]
+	require.Equal(t, "user.added", event.Type)
+	require.Equal(t, org.ID, event.OrganizationID)
+	require.Equal(t, owner.ID, *event.ActorID)
+	require.Equal(t, member.ID.String(), event.SubjectID)
+	require.Equal(t, member.Role, event.Data["role"])
+	require.NotContains(t, event.Data, "client_ip")
+}
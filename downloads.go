@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Exports are downloaded from signed URLs rather than with an access
+// token, so a browser can fetch one straight from a link. An organization
+// member with manage:settings asks for a URL; it names the export, the
+// organization, when it expires and a random nonce, and carries their
+// HMAC-SHA256 keyed with DOWNLOAD_SIGNING_KEY. The nonce is kept in the
+// state store until the URL expires and taken on download, so each URL
+// works once.
+
+// Kinds of export
+const (
+	ExportOrganization = "organization" // the organization, its settings and members
+	ExportAudit        = "audit"        // the organization's audit events
+)
+
+// ExportKinds lists the kinds of export
+var ExportKinds = []string{ExportOrganization, ExportAudit}
+
+// MaxAuditExportEvents bounds the events an audit export holds, newest kept
+const MaxAuditExportEvents = 10000
+
+var (
+	ErrInvalidDownload = errors.New("invalid download link")
+	ErrDownloadExpired = errors.New("download link expired or already used")
+)
+
+// DownloadConfig signs download URLs
+type DownloadConfig struct {
+	// Key signs the URLs. Without DOWNLOAD_SIGNING_KEY one is generated at
+	// startup, so URLs only work on the instance that made them.
+	Key []byte
+	// TTL is how long a URL can be used, DOWNLOAD_URL_TTL
+	TTL time.Duration
+}
+
+// NewDownloadConfig creates a download configuration from settings
+func NewDownloadConfig(settings Settings) (*DownloadConfig, error) {
+	config := &DownloadConfig{Key: []byte(settings("DOWNLOAD_SIGNING_KEY"))}
+	var errs []error
+	if len(config.Key) == 0 {
+		config.Key = make([]byte, 32)
+		if _, err := rand.Read(config.Key); err != nil {
+			panic("failed to generate download signing key: " + err.Error())
+		}
+	} else if len(config.Key) < 32 {
+		errs = append(errs, errors.New("DOWNLOAD_SIGNING_KEY must be at least 32 bytes"))
+	}
+
+	ttl, err := time.ParseDuration(settings.get("DOWNLOAD_URL_TTL", "5m"))
+	if err != nil || ttl <= 0 || ttl > time.Hour {
+		errs = append(errs, fmt.Errorf("invalid DOWNLOAD_URL_TTL %q: expected a duration up to 1h", settings("DOWNLOAD_URL_TTL")))
+	}
+	config.TTL = ttl
+	return config, errors.Join(errs...)
+}
+
+// DownloadURL is a signed URL for one download of an export. URL is
+// relative to the API's base.
+type DownloadURL struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// download is what a download URL names
+type download struct {
+	kind      string
+	orgID     uuid.UUID
+	expiresAt time.Time
+	nonce     string
+}
+
+func (d *download) signature(key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s\n%s\n%d\n%s", d.kind, d.orgID, d.expiresAt.Unix(), d.nonce)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// downloadKey is the state store key of a download URL's nonce
+func downloadKey(nonce string) string {
+	sum := sha256.Sum256([]byte(nonce))
+	return "download:" + hex.EncodeToString(sum[:])
+}
+
+// signDownload issues a URL for one download of orgID's kind export
+func (s *Server) signDownload(ctx context.Context, kind string, orgID uuid.UUID) (*DownloadURL, error) {
+	nonce, err := generateState()
+	if err != nil {
+		return nil, err
+	}
+	d := download{
+		kind:      kind,
+		orgID:     orgID,
+		expiresAt: time.Now().Add(s.downloads.TTL).UTC().Truncate(time.Second),
+		nonce:     nonce,
+	}
+	if err := s.stateStore.StoreState(ctx, downloadKey(d.nonce), s.downloads.TTL); err != nil {
+		return nil, err
+	}
+
+	q := url.Values{
+		"org":     {d.orgID.String()},
+		"expires": {strconv.FormatInt(d.expiresAt.Unix(), 10)},
+		"nonce":   {d.nonce},
+		"sig":     {d.signature(s.downloads.Key)},
+	}
+	return &DownloadURL{URL: "/downloads/" + kind + "?" + q.Encode(), ExpiresAt: d.expiresAt}, nil
+}
+
+// verifyDownload checks a download URL's signature and expiry, then uses
+// it up
+func (s *Server) verifyDownload(r *http.Request) (*download, error) {
+	q := r.URL.Query()
+	orgID, err := uuid.Parse(q.Get("org"))
+	if err != nil {
+		return nil, ErrInvalidDownload
+	}
+	expires, err := strconv.ParseInt(q.Get("expires"), 10, 64)
+	if err != nil {
+		return nil, ErrInvalidDownload
+	}
+	d := &download{kind: r.PathValue("kind"), orgID: orgID, expiresAt: time.Unix(expires, 0), nonce: q.Get("nonce")}
+	if d.nonce == "" || !hmac.Equal([]byte(q.Get("sig")), []byte(d.signature(s.downloads.Key))) {
+		return nil, ErrInvalidDownload
+	}
+	if time.Now().After(d.expiresAt) {
+		return nil, ErrDownloadExpired
+	}
+
+	valid, err := s.stateStore.ValidateAndDeleteState(r.Context(), downloadKey(d.nonce))
+	if err != nil {
+		return nil, err
+	}
+	if !valid {
+		return nil, ErrDownloadExpired
+	}
+	return d, nil
+}
+
+// handleCreateDownload issues a signed URL for one download of an export
+func (s *Server) handleCreateDownload(w http.ResponseWriter, r *http.Request) {
+	orgID := pathOrgID(r)
+	kind := r.PathValue("kind")
+	if !slices.Contains(ExportKinds, kind) {
+		http.Error(w, "Unknown export", http.StatusNotFound)
+		return
+	}
+
+	link, err := s.signDownload(r.Context(), kind, orgID)
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to sign download", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	s.recordAudit(r, "export.requested", orgID, orgID.String(), AuditMetadata{
+		"export":     kind,
+		"expires_at": link.ExpiresAt.Format(time.RFC3339),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(link)
+}
+
+// OrganizationExport is an organization's export
+type OrganizationExport struct {
+	Organization *Organization        `json:"organization"`
+	Settings     OrganizationSettings `json:"settings"`
+	Members      []User               `json:"members"`
+	ExportedAt   time.Time            `json:"exported_at"`
+}
+
+// AuditExport is an organization's audit export, oldest event first
+type AuditExport struct {
+	OrganizationID uuid.UUID    `json:"organization_id"`
+	Events         []AuditEvent `json:"events"`
+	ExportedAt     time.Time    `json:"exported_at"`
+}
+
+// handleDownload serves an export to whoever holds a valid download URL.
+// It takes no access token: the URL's signature is the authorization.
+func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
+	d, err := s.verifyDownload(r)
+	switch err {
+	case nil:
+	case ErrInvalidDownload:
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case ErrDownloadExpired:
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	default:
+		s.logger.ErrorContext(r.Context(), "failed to verify download", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Only the organization the URL names is reachable
+	ctx := WithOrg(r.Context(), d.orgID)
+	var export any
+	switch d.kind {
+	case ExportOrganization:
+		export, err = s.exportOrganization(ctx, d.orgID)
+	case ExportAudit:
+		export, err = s.exportAudit(ctx, d.orgID)
+	}
+	if err == sql.ErrNoRows || err == ErrOrganizationNotFound {
+		http.Error(w, ErrOrganizationNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to export", "export", d.kind, "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s.json"`, d.kind, d.orgID))
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(export)
+}
+
+func (s *Server) exportOrganization(ctx context.Context, orgID uuid.UUID) (*OrganizationExport, error) {
+	org, err := s.store.GetOrganization(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	settings, _, err := s.store.GetOrganizationSettings(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	members, err := s.store.GetOrganizationUsers(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	return &OrganizationExport{Organization: org, Settings: *settings, Members: members, ExportedAt: time.Now().UTC()}, nil
+}
+
+func (s *Server) exportAudit(ctx context.Context, orgID uuid.UUID) (*AuditExport, error) {
+	events, err := s.audit.OrganizationEvents(ctx, orgID, MaxAuditExportEvents)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditExport{OrganizationID: orgID, Events: events, ExportedAt: time.Now().UTC()}, nil
+}
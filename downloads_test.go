@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloads(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	srv, err := NewServer(store, newTestConfig(t))
+	require.NoError(t, err)
+
+	acme, err := store.CreateOrganization(ctx, "Acme", "owner@acme.test", "Owner")
+	require.NoError(t, err)
+	owner, err := store.GetUser(ctx, acme.OwnerID)
+	require.NoError(t, err)
+	token, err := srv.tokenManager.GenerateToken(owner)
+	require.NoError(t, err)
+
+	issue := func(kind string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/organizations/"+acme.ID.String()+"/exports/"+kind, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		addCSRFToken(t, srv, req)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return w
+	}
+	link := func(kind string) DownloadURL {
+		w := issue(kind)
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		var link DownloadURL
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&link))
+		return link
+	}
+	download := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+		return w
+	}
+
+	t.Run("URLs download their export once", func(t *testing.T) {
+		url := link(ExportOrganization).URL
+		w := download(url)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Contains(t, w.Header().Get("Content-Disposition"), "attachment")
+		var export OrganizationExport
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&export))
+		require.Equal(t, acme.ID, export.Organization.ID)
+		require.Len(t, export.Members, 1)
+
+		w = download(url)
+		require.Equal(t, http.StatusGone, w.Code)
+
+		w = download(link(ExportAudit).URL)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	})
+
+	t.Run("Tampered URLs are refused", func(t *testing.T) {
+		url := link(ExportOrganization).URL
+		for _, tampered := range []string{
+			strings.Replace(url, "/downloads/organization", "/downloads/audit", 1),
+			strings.Replace(url, "expires=", "expires=9", 1),
+			strings.Replace(url, "sig=", "sig=x", 1),
+		} {
+			require.Equal(t, http.StatusForbidden, download(tampered).Code, tampered)
+		}
+		require.Equal(t, http.StatusOK, download(url).Code, "refused attempts do not use a URL up")
+	})
+
+	t.Run("Unknown exports are not issued", func(t *testing.T) {
+		require.Equal(t, http.StatusNotFound, issue("billing").Code)
+	})
+}
+
+func TestDownloadConfig(t *testing.T) {
+	config, err := NewDownloadConfig(func(string) string { return "" })
+	require.NoError(t, err)
+	require.Len(t, config.Key, 32)
+	require.Equal(t, 5*time.Minute, config.TTL)
+
+	_, err = NewDownloadConfig(func(key string) string {
+		return map[string]string{"DOWNLOAD_SIGNING_KEY": "short", "DOWNLOAD_URL_TTL": "2h"}[key]
+	})
+	require.ErrorContains(t, err, "DOWNLOAD_SIGNING_KEY")
+	require.ErrorContains(t, err, "DOWNLOAD_URL_TTL")
+}
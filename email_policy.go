@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+)
+
+// MaxEmailDomains is how many email domains an organization may list
+const MaxEmailDomains = 20
+
+// disposableDomains are well-known disposable-email services, blocked
+// with EMAIL_BLOCK_DISPOSABLE along with their subdomains.
+// EMAIL_DISPOSABLE_DOMAINS adds to them.
+var disposableDomains = []string{
+	"10minutemail.com",
+	"burnermail.io",
+	"discard.email",
+	"dispostable.com",
+	"emailondeck.com",
+	"fakeinbox.com",
+	"getnada.com",
+	"guerrillamail.com",
+	"guerrillamail.net",
+	"guerrillamail.org",
+	"inboxkitten.com",
+	"mailcatch.com",
+	"maildrop.cc",
+	"mailinator.com",
+	"mailnesia.com",
+	"mintemail.com",
+	"moakt.com",
+	"mohmal.com",
+	"mytemp.email",
+	"sharklasers.com",
+	"spamgourmet.com",
+	"temp-mail.io",
+	"temp-mail.org",
+	"tempail.com",
+	"tempmail.com",
+	"tempr.email",
+	"throwawaymail.com",
+	"trash-mail.com",
+	"trashmail.com",
+	"yopmail.com",
+}
+
+// gmailDomains deliver to the same mailbox whatever dots or +tag the local
+// part has
+var gmailDomains = map[string]bool{"gmail.com": true, "googlemail.com": true}
+
+// EmailPolicyConfig sets which addresses users can be created with
+type EmailPolicyConfig struct {
+	// BlockDisposable refuses addresses at disposable-email domains
+	BlockDisposable   bool
+	DisposableDomains []string // blocked besides the built-in list
+	// Normalize lowercases addresses, and drops the dots and +tags Gmail
+	// ignores, before they are stored or looked up, so one mailbox cannot
+	// be signed up twice. Addresses stored before it was set are not
+	// rewritten.
+	Normalize bool
+}
+
+// NewEmailPolicyConfig creates an email policy configuration from settings
+func NewEmailPolicyConfig(settings Settings) (*EmailPolicyConfig, error) {
+	config := &EmailPolicyConfig{
+		BlockDisposable: settings.getBool("EMAIL_BLOCK_DISPOSABLE", false),
+		Normalize:       settings.getBool("EMAIL_NORMALIZE", false),
+	}
+	for _, domain := range splitList(settings("EMAIL_DISPOSABLE_DOMAINS")) {
+		if !isDomainName(domain) {
+			return nil, fmt.Errorf("invalid EMAIL_DISPOSABLE_DOMAINS entry %q", domain)
+		}
+		config.DisposableDomains = append(config.DisposableDomains, strings.ToLower(domain))
+	}
+	return config, nil
+}
+
+// EmailPolicy applies an EmailPolicyConfig to the addresses users are
+// created with. A nil *EmailPolicy allows any valid address and leaves it
+// as it is.
+type EmailPolicy struct {
+	config     *EmailPolicyConfig
+	disposable map[string]bool
+}
+
+func NewEmailPolicy(config *EmailPolicyConfig) *EmailPolicy {
+	p := &EmailPolicy{config: config, disposable: make(map[string]bool)}
+	if config.BlockDisposable {
+		for _, domain := range disposableDomains {
+			p.disposable[domain] = true
+		}
+		for _, domain := range config.DisposableDomains {
+			p.disposable[domain] = true
+		}
+	}
+	return p
+}
+
+// Normalize returns the form of email that is stored and looked up
+func (p *EmailPolicy) Normalize(email string) string {
+	if p == nil || !p.config.Normalize {
+		return email
+	}
+	return NormalizeEmail(email)
+}
+
+// NormalizeEmail lowercases an address. At Gmail it also drops the dots
+// and +tag from the local part and uses gmail.com for googlemail.com.
+func NormalizeEmail(email string) string {
+	email = strings.ToLower(strings.TrimSpace(email))
+	local, domain, ok := cutEmail(email)
+	if !ok || !gmailDomains[domain] {
+		return email
+	}
+	local, _, _ = strings.Cut(local, "+")
+	return strings.ReplaceAll(local, ".", "") + "@gmail.com"
+}
+
+// Check applies the policy to email, reporting problems against field.
+// domains, if any, are the only ones the address may be at, subdomains
+// included. Addresses ValidateEmail refuses pass, as that reports them.
+func (p *EmailPolicy) Check(field, email string, domains []string) error {
+	if _, err := mail.ParseAddress(email); err != nil {
+		return nil
+	}
+	_, domain, ok := cutEmail(strings.ToLower(email))
+	if !ok {
+		return nil
+	}
+
+	if p != nil && len(p.disposable) > 0 {
+		for parent := domain; parent != ""; parent = parentDomain(parent) {
+			if p.disposable[parent] {
+				return &ValidationError{Field: field, Message: "disposable email addresses are not allowed"}
+			}
+		}
+	}
+
+	if len(domains) == 0 {
+		return nil
+	}
+	for _, allowed := range domains {
+		allowed = strings.ToLower(allowed)
+		if domain == allowed || strings.HasSuffix(domain, "."+allowed) {
+			return nil
+		}
+	}
+	return &ValidationError{Field: field, Message: fmt.Sprintf("must be at %s", strings.Join(domains, ", "))}
+}
+
+// cutEmail splits an address at its last @
+func cutEmail(email string) (local, domain string, ok bool) {
+	i := strings.LastIndexByte(email, '@')
+	if i < 0 {
+		return "", "", false
+	}
+	return email[:i], email[i+1:], true
+}
+
+// parentDomain drops the leftmost label of domain, returning "" for a
+// top-level domain
+func parentDomain(domain string) string {
+	_, parent, _ := strings.Cut(domain, ".")
+	return parent
+}
+
+// isDomainName reports whether s is a DNS name of two or more labels
+func isDomainName(s string) bool {
+	if len(s) > 253 || !strings.Contains(s, ".") {
+		return false
+	}
+	for _, label := range strings.Split(s, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeEmail(t *testing.T) {
+	for email, want := range map[string]string{
+		"Jane.Doe@Acme.com":             "jane.doe@acme.com",
+		"jane.doe+news@acme.com":        "jane.doe+news@acme.com",
+		"Jane.Doe+news@Gmail.com":       "janedoe@gmail.com",
+		"j.a.n.e@googlemail.com":        "jane@gmail.com",
+		" jane@example.com ":            "jane@example.com",
+		"not-an-email":                  "not-an-email",
+		"jane@mail.gmail.com.evil.test": "jane@mail.gmail.com.evil.test",
+	} {
+		require.Equal(t, want, NormalizeEmail(email), email)
+	}
+
+	policy := NewEmailPolicy(&EmailPolicyConfig{})
+	require.Equal(t, "Jane.Doe@Gmail.com", policy.Normalize("Jane.Doe@Gmail.com"), "normalizing is opt-in")
+	var none *EmailPolicy
+	require.Equal(t, "Jane@Acme.com", none.Normalize("Jane@Acme.com"))
+}
+
+func TestEmailPolicyCheck(t *testing.T) {
+	config, err := NewEmailPolicyConfig(func(key string) string {
+		return map[string]string{
+			"EMAIL_BLOCK_DISPOSABLE":   "true",
+			"EMAIL_DISPOSABLE_DOMAINS": "Burner.example, throwaway.test",
+		}[key]
+	})
+	require.NoError(t, err)
+	policy := NewEmailPolicy(config)
+
+	require.NoError(t, policy.Check("email", "jane@acme.com", nil))
+	for _, email := range []string{"jane@mailinator.com", "jane@eu.Mailinator.com", "jane@burner.example"} {
+		var valErr *ValidationError
+		require.ErrorAs(t, policy.Check("email", email, nil), &valErr, email)
+		require.Equal(t, "email", valErr.Field)
+	}
+	require.NoError(t, policy.Check("email", "not-an-email", nil), "left to ValidateEmail")
+
+	domains := []string{"acme.com", "acme.io"}
+	require.NoError(t, policy.Check("email", "jane@acme.io", domains))
+	require.NoError(t, policy.Check("email", "jane@eng.ACME.com", domains))
+	require.Error(t, policy.Check("email", "jane@notacme.com", domains))
+	require.Error(t, policy.Check("email", "jane@acme.com.evil.test", domains))
+
+	var none *EmailPolicy
+	require.NoError(t, none.Check("email", "jane@mailinator.com", nil))
+	require.Error(t, none.Check("email", "jane@mailinator.com", domains))
+
+	_, err = NewEmailPolicyConfig(func(key string) string {
+		return map[string]string{"EMAIL_DISPOSABLE_DOMAINS": "https://burner.example"}[key]
+	})
+	require.Error(t, err)
+}
+
+func TestInvitationEmailPolicy(t *testing.T) {
+	t.Setenv("EMAIL_BLOCK_DISPOSABLE", "true")
+	t.Setenv("EMAIL_NORMALIZE", "true")
+	ctx := context.Background()
+	store := NewMemoryStore()
+	srv, err := NewServer(store, newTestConfig(t))
+	require.NoError(t, err)
+
+	org, err := store.CreateOrganization(ctx, "Acme", "owner@acme.test", "Owner")
+	require.NoError(t, err)
+	owner, err := store.GetUser(ctx, org.OwnerID)
+	require.NoError(t, err)
+	token, err := srv.tokenManager.GenerateToken(owner)
+	require.NoError(t, err)
+	_, version, err := store.GetOrganizationSettings(ctx, org.ID)
+	require.NoError(t, err)
+	_, err = store.UpdateOrganizationSettings(ctx, org.ID, version, &OrganizationSettings{
+		AllowedOrigins: []string{},
+		EmailDomains:   []string{"acme.test", "gmail.com"},
+	})
+	require.NoError(t, err)
+
+	addUser := func(email string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/organizations/"+org.ID.String()+"/users",
+			strings.NewReader(`{"email":"`+email+`","name":"Member"}`))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		addCSRFToken(t, srv, req)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return w
+	}
+
+	w := addUser("member@elsewhere.test")
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Contains(t, w.Body.String(), `"field":"email"`)
+
+	w = addUser("Jane.Doe+work@Gmail.com")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var user User
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&user))
+	require.Equal(t, "janedoe@gmail.com", user.Email)
+
+	require.Equal(t, http.StatusConflict, addUser("janedoe@gmail.com").Code, "one mailbox is one user")
+}
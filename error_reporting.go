@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+)
+
+// ErrorReport is an unexpected error, as sent to an error tracker. It holds
+// identifiers only: no email addresses, names or IP addresses.
+type ErrorReport struct {
+	Message   string
+	Error     string
+	Stack     string // set for panics
+	RequestID string
+	Method    string
+	Route     string // the matched route pattern, such as "/organizations/{orgID}"
+	UserID    string
+	OrgID     string
+	Extra     map[string]string // the log record's other attributes, scrubbed
+	Time      time.Time
+}
+
+// ErrorReporter sends unexpected errors to an error tracker
+type ErrorReporter interface {
+	Report(report *ErrorReport)
+	Close(ctx context.Context) error
+}
+
+// NewErrorReporter returns a Sentry reporter when SENTRY_DSN is
+// set, and nil otherwise
+func NewErrorReporter(settings Settings) (ErrorReporter, error) {
+	dsn := settings("SENTRY_DSN")
+	if dsn == "" {
+		return nil, nil
+	}
+	return NewSentryReporter(dsn, settings.get("ENVIRONMENT", "development"))
+}
+
+// routedRequestKey holds the request the mux routes, recorded by traceRoute,
+// so that the route pattern it matched can be found from the context
+type routedRequestKey struct{}
+
+type routedRequest struct {
+	request *http.Request
+}
+
+// withRoutedRequest makes room in the context for traceRoute to record the
+// routed request
+func withRoutedRequest(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), routedRequestKey{}, &routedRequest{}))
+}
+
+// requestRoute returns the route pattern matched for the request in ctx,
+// once the mux has routed it
+func requestRoute(ctx context.Context) string {
+	routed, ok := ctx.Value(routedRequestKey{}).(*routedRequest)
+	if !ok || routed.request == nil {
+		return ""
+	}
+	return routed.request.Pattern
+}
+
+// recoverPanics turns a panicking handler into a 500 response, logging the
+// panic with its stack so that it is reported like any other error
+func (s *Server) recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = withRoutedRequest(r)
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			// net/http uses this panic to abort a response on purpose
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+			s.logger.ErrorContext(r.Context(), "panic serving request",
+				"error", fmt.Sprint(p),
+				"stack", string(debug.Stack()),
+			)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// piiKeys matches attribute keys that could hold personal data and are left
+// out of error reports
+var piiKeys = regexp.MustCompile(`(?i)(^|_)(email|name|addr|ip|user_agent)$`)
+
+// errorReportingHandler reports every record at error level or above, along
+// with the request it was logged for
+type errorReportingHandler struct {
+	slog.Handler
+	reporter ErrorReporter
+	redact   *regexp.Regexp
+	attrs    []slog.Attr
+}
+
+// NewErrorReportingHandler wraps h to send errors to reporter. Attributes
+// whose keys match redact are left out of reports, as are likely PII.
+func NewErrorReportingHandler(h slog.Handler, reporter ErrorReporter, redact *regexp.Regexp) slog.Handler {
+	return &errorReportingHandler{Handler: h, reporter: reporter, redact: redact}
+}
+
+func (h *errorReportingHandler) Handle(ctx context.Context, record slog.Record) error {
+	if record.Level >= slog.LevelError {
+		h.reporter.Report(h.report(ctx, record))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h *errorReportingHandler) report(ctx context.Context, record slog.Record) *ErrorReport {
+	report := &ErrorReport{
+		Message:   record.Message,
+		RequestID: GetRequestIDFromContext(ctx),
+		Extra:     make(map[string]string),
+		Time:      record.Time,
+	}
+	if route := requestRoute(ctx); route != "" {
+		report.Method, report.Route, _ = strings.Cut(route, " ")
+	}
+	if user, err := GetUserFromContext(ctx); err == nil {
+		report.UserID = user.ID.String()
+		report.OrgID = user.OrganizationID.String()
+	}
+
+	add := func(a slog.Attr) bool {
+		switch {
+		case a.Key == "error":
+			report.Error = a.Value.String()
+		case a.Key == "stack":
+			report.Stack = a.Value.String()
+		case piiKeys.MatchString(a.Key):
+		case h.redact != nil && h.redact.MatchString(a.Key):
+			report.Extra[a.Key] = "[REDACTED]"
+		default:
+			report.Extra[a.Key] = a.Value.String()
+		}
+		return true
+	}
+	for _, a := range h.attrs {
+		add(a)
+	}
+	record.Attrs(add)
+	return report
+}
+
+func (h *errorReportingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &errorReportingHandler{
+		Handler:  h.Handler.WithAttrs(attrs),
+		reporter: h.reporter,
+		redact:   h.redact,
+		attrs:    append(h.attrs[:len(h.attrs):len(h.attrs)], attrs...),
+	}
+}
+
+func (h *errorReportingHandler) WithGroup(name string) slog.Handler {
+	return &errorReportingHandler{
+		Handler:  h.Handler.WithGroup(name),
+		reporter: h.reporter,
+		redact:   h.redact,
+		attrs:    h.attrs,
+	}
+}
+
+// SentryReporter sends error reports to Sentry in the background. The
+// SDK's transport drops reports when Sentry falls behind and backs off while
+// it is rate limited.
+type SentryReporter struct {
+	client *sentry.Client
+}
+
+// NewSentryReporter creates a reporter for a DSN of the form
+// https://<key>@<host>/<project>
+func NewSentryReporter(dsn, environment string) (*SentryReporter, error) {
+	client, err := sentry.NewClient(sentry.ClientOptions{
+		Dsn:         dsn,
+		Environment: environment,
+		Release:     currentBuild.Version,
+		// Reports carry identifiers only; see ErrorReport
+		SendDefaultPII: false,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid SENTRY_DSN: %w", err)
+	}
+	return &SentryReporter{client: client}, nil
+}
+
+func (r *SentryReporter) Report(report *ErrorReport) {
+	event := sentry.NewEvent()
+	event.Level = sentry.LevelError
+	event.Logger = "huachuca"
+	event.Message = report.Message
+	event.Timestamp = report.Time
+	event.Tags = map[string]string{"commit": currentBuild.Commit}
+	for key, value := range report.Extra {
+		event.Extra[key] = value
+	}
+	if report.Error != "" {
+		event.Exception = []sentry.Exception{{Type: report.Message, Value: report.Error}}
+	}
+	if report.Stack != "" {
+		event.Extra["stack"] = report.Stack
+		event.Tags["panic"] = "true"
+	}
+	if report.Route != "" {
+		event.Transaction = report.Method + " " + report.Route
+		event.Tags["route"] = report.Route
+		event.Tags["method"] = report.Method
+	}
+	if report.RequestID != "" {
+		event.Tags["request_id"] = report.RequestID
+	}
+	if report.UserID != "" {
+		event.User = sentry.User{ID: report.UserID}
+		event.Tags["organization_id"] = report.OrgID
+	}
+	r.client.CaptureEvent(event, nil, nil)
+}
+
+// Close sends the reports still queued, waiting until ctx is done
+func (r *SentryReporter) Close(ctx context.Context) error {
+	timeout := 10 * time.Second
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+	if !r.client.Flush(timeout) {
+		return fmt.Errorf("failed to send error reports: %w", context.DeadlineExceeded)
+	}
+	return nil
+}
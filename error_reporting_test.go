@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSentryReporter(t *testing.T) {
+	for _, dsn := range []string{"https://abc123@o1.ingest.sentry.io/42", "https://abc123@sentry.example.com/errors/7"} {
+		_, err := NewSentryReporter(dsn, "production")
+		require.NoError(t, err, dsn)
+	}
+	for _, dsn := range []string{"https://sentry.example.com/7", "https://abc123@sentry.example.com/", "::"} {
+		_, err := NewSentryReporter(dsn, "production")
+		require.Error(t, err, dsn)
+	}
+}
+
+func TestErrorReporting(t *testing.T) {
+	var mu sync.Mutex
+	var events []sentry.Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/42/envelope/", r.URL.Path)
+		require.Contains(t, r.Header.Get("X-Sentry-Auth"), "sentry_key=key")
+
+		// An envelope is a header, then an item header and the event
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		lines := strings.Split(string(body), "\n")
+		require.GreaterOrEqual(t, len(lines), 3)
+		var event sentry.Event
+		require.NoError(t, json.Unmarshal([]byte(lines[2]), &event))
+		mu.Lock()
+		events = append(events, event)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	t.Setenv("SENTRY_DSN", strings.Replace(server.URL, "http://", "http://key@", 1)+"/42")
+	srv, err := NewServer(nil, newTestConfig(t))
+	require.NoError(t, err)
+	srv.mux.HandleFunc("GET /panics/{id}", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+
+	// A panicking handler is answered with a 500 and reported
+	req := httptest.NewRequest(http.MethodGet, "/panics/1", nil)
+	req.Header.Set(RequestIDHeader, "req-123")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+
+	// Errors logged while serving a request carry its user, without PII
+	user := &User{ID: uuid.New(), OrganizationID: uuid.New(), Email: "someone@example.com"}
+	ctx := context.WithValue(context.Background(), userContextKey, user)
+	srv.logger.ErrorContext(ctx, "failed to create organization",
+		"error", errors.New("connection refused"),
+		"owner_email", user.Email,
+		"access_token", "secret-token",
+		"attempt", 2,
+	)
+	srv.logger.WarnContext(ctx, "not an error")
+
+	require.NoError(t, srv.errors.Close(context.Background()))
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, events, 2)
+
+	panicked := events[0]
+	require.Equal(t, "panic serving request", panicked.Message)
+	require.Equal(t, "boom", panicked.Exception[0].Value)
+	require.Equal(t, "/panics/{id}", panicked.Tags["route"])
+	require.Equal(t, "GET /panics/{id}", panicked.Transaction)
+	require.Equal(t, "req-123", panicked.Tags["request_id"])
+	require.Contains(t, panicked.Extra["stack"], "error_reporting_test.go")
+
+	logged := events[1]
+	require.Equal(t, "connection refused", logged.Exception[0].Value)
+	require.Equal(t, user.ID.String(), logged.User.ID)
+	require.Equal(t, user.OrganizationID.String(), logged.Tags["organization_id"])
+	require.Equal(t, "2", logged.Extra["attempt"])
+	require.Equal(t, "[REDACTED]", logged.Extra["access_token"])
+	require.NotContains(t, logged.Extra, "owner_email")
+}
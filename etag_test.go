@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestETag(t *testing.T) {
+	body := `[{"id":"1"}]`
+	handler := ETag(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/organizations", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, body, w.Body.String())
+	etag := w.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+	require.Equal(t, "private, no-cache", w.Header().Get("Cache-Control"))
+
+	tests := []struct {
+		name           string
+		ifNoneMatch    string
+		expectedStatus int
+	}{
+		{"Matching ETag", etag, http.StatusNotModified},
+		{"Matching ETag in a list", `"other", ` + etag, http.StatusNotModified},
+		{"Strong form of the weak ETag", etag[2:], http.StatusNotModified},
+		{"Wildcard", "*", http.StatusNotModified},
+		{"Stale ETag", `W/"stale"`, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/organizations", nil)
+			req.Header.Set("If-None-Match", tt.ifNoneMatch)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			require.Equal(t, tt.expectedStatus, w.Code)
+			require.Equal(t, etag, w.Header().Get("ETag"))
+			if tt.expectedStatus == http.StatusNotModified {
+				require.Empty(t, w.Body.String())
+			} else {
+				require.Equal(t, body, w.Body.String())
+			}
+		})
+	}
+
+	t.Run("Errors are not tagged", func(t *testing.T) {
+		handler := ETag(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}))
+		req := httptest.NewRequest(http.MethodGet, "/organizations", nil)
+		req.Header.Set("If-None-Match", "*")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Empty(t, w.Header().Get("ETag"))
+	})
+
+	t.Run("Handler-set tags are kept", func(t *testing.T) {
+		handler := ETag(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("ETag", versionETag(3))
+			w.Write([]byte(body))
+		}))
+		req := httptest.NewRequest(http.MethodGet, "/organizations", nil)
+		req.Header.Set("If-None-Match", `"3"`)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		require.Equal(t, http.StatusNotModified, w.Code)
+		require.Equal(t, `"3"`, w.Header().Get("ETag"))
+	})
+}
+
+func TestIfMatchVersion(t *testing.T) {
+	tests := []struct {
+		name            string
+		ifMatch         string
+		expectedVersion int
+		expectedStatus  int
+	}{
+		{name: "Version tag", ifMatch: `"4"`, expectedVersion: 4},
+		{name: "Weak version tag", ifMatch: `W/"4"`, expectedVersion: 4},
+		{name: "Missing", ifMatch: "", expectedStatus: http.StatusPreconditionRequired},
+		{name: "Not a version", ifMatch: `"abc"`, expectedStatus: http.StatusBadRequest},
+		{name: "Wildcard", ifMatch: "*", expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPut, "/organizations", nil)
+			req.Header.Set("If-Match", tc.ifMatch)
+			w := httptest.NewRecorder()
+
+			version, ok := ifMatchVersion(w, req)
+			require.Equal(t, tc.expectedStatus == 0, ok)
+			if ok {
+				require.Equal(t, tc.expectedVersion, version)
+			} else {
+				require.Equal(t, tc.expectedStatus, w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// SecurityEvent is an audited action in an organization, as streamed live to
+// its dashboards
+type SecurityEvent struct {
+	OrganizationID uuid.UUID     `json:"organization_id"`
+	ActorID        *uuid.UUID    `json:"actor_id,omitempty"`
+	Action         string        `json:"action"`
+	TargetID       string        `json:"target_id,omitempty"`
+	Metadata       AuditMetadata `json:"metadata,omitempty"`
+	CreatedAt      time.Time     `json:"created_at"`
+}
+
+// EventBroker fans security events out to the organization's subscribers
+type EventBroker interface {
+	Publish(ctx context.Context, event *SecurityEvent) error
+	// Subscribe returns the organization's events until unsubscribe is
+	// called. Events are dropped for subscribers that fall behind.
+	Subscribe(orgID uuid.UUID) (events <-chan SecurityEvent, unsubscribe func())
+}
+
+// subscriberBuffer is how many events a subscriber can fall behind by
+// before it misses some
+const subscriberBuffer = 32
+
+// LocalEventBroker delivers events to subscribers on this instance only
+type LocalEventBroker struct {
+	mu          sync.Mutex
+	subscribers map[uuid.UUID]map[chan SecurityEvent]struct{}
+}
+
+func NewLocalEventBroker() *LocalEventBroker {
+	return &LocalEventBroker{subscribers: make(map[uuid.UUID]map[chan SecurityEvent]struct{})}
+}
+
+func (b *LocalEventBroker) Publish(ctx context.Context, event *SecurityEvent) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers[event.OrganizationID] {
+		select {
+		case ch <- *event:
+		default:
+		}
+	}
+	return nil
+}
+
+func (b *LocalEventBroker) Subscribe(orgID uuid.UUID) (<-chan SecurityEvent, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan SecurityEvent, subscriberBuffer)
+	if b.subscribers[orgID] == nil {
+		b.subscribers[orgID] = make(map[chan SecurityEvent]struct{})
+	}
+	b.subscribers[orgID][ch] = struct{}{}
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			delete(b.subscribers[orgID], ch)
+			if len(b.subscribers[orgID]) == 0 {
+				delete(b.subscribers, orgID)
+			}
+		})
+	}
+}
+
+// redisEventChannelPrefix namespaces the Redis pub/sub channel of each
+// organization's events
+const redisEventChannelPrefix = "huachuca:events:"
+
+// RedisEventBroker shares events between instances through Redis pub/sub,
+// so a dashboard sees events whichever instance handled the request
+type RedisEventBroker struct {
+	client *redis.Client
+	local  *LocalEventBroker
+	pubsub *redis.PubSub
+}
+
+// NewRedisEventBroker relays every organization's events from Redis to the
+// subscribers on this instance until ctx is done
+func NewRedisEventBroker(ctx context.Context, client *redis.Client, logger *slog.Logger) *RedisEventBroker {
+	b := &RedisEventBroker{
+		client: client,
+		local:  NewLocalEventBroker(),
+		pubsub: client.PSubscribe(ctx, redisEventChannelPrefix+"*"),
+	}
+	go b.relay(logger)
+	return b
+}
+
+func (b *RedisEventBroker) relay(logger *slog.Logger) {
+	for msg := range b.pubsub.Channel() {
+		var event SecurityEvent
+		if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+			logger.Warn("dropping malformed security event", "channel", msg.Channel, "error", err)
+			continue
+		}
+		b.local.Publish(context.Background(), &event)
+	}
+}
+
+func (b *RedisEventBroker) Publish(ctx context.Context, event *SecurityEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return b.client.Publish(ctx, redisEventChannelPrefix+event.OrganizationID.String(), payload).Err()
+}
+
+func (b *RedisEventBroker) Subscribe(orgID uuid.UUID) (<-chan SecurityEvent, func()) {
+	return b.local.Subscribe(orgID)
+}
+
+// Close stops relaying events from Redis
+func (b *RedisEventBroker) Close() error {
+	return b.pubsub.Close()
+}
+
+// publishSecurityEvent streams an audited action to the dashboards of its
+// organization
+func (s *Server) publishSecurityEvent(ctx context.Context, event *AuditEvent) {
+	if event.OrganizationID == nil {
+		return
+	}
+	createdAt := event.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now().UTC()
+	}
+	err := s.events.Publish(ctx, &SecurityEvent{
+		OrganizationID: *event.OrganizationID,
+		ActorID:        event.ActorID,
+		Action:         event.Action,
+		TargetID:       event.TargetID,
+		Metadata:       event.Metadata,
+		CreatedAt:      createdAt,
+	})
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to publish security event", "action", event.Action, "error", err)
+	}
+}
+
+// eventStreamHeartbeat is how often an idle event stream sends a comment,
+// keeping proxies from closing the connection
+const eventStreamHeartbeat = 25 * time.Second
+
+// handleEventStream streams the organization's security events as
+// Server-Sent Events for as long as the client stays connected
+func (s *Server) handleEventStream(w http.ResponseWriter, r *http.Request) {
+	orgID := pathOrgID(r)
+
+	// The stream outlives the server's write timeout
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && err != http.ErrNotSupported {
+		s.logger.ErrorContext(r.Context(), "failed to clear write deadline", "error", err)
+	}
+
+	events, unsubscribe := s.events.Subscribe(orgID)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // stop nginx buffering the stream
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(eventStreamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		case event := <-events:
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Action, data)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// isEventStream reports whether path is an event stream, which has no
+// request time budget
+func isEventStream(path string) bool {
+	return strings.HasSuffix(path, "/events/stream")
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalEventBroker(t *testing.T) {
+	ctx := context.Background()
+	broker := NewLocalEventBroker()
+	orgID := uuid.New()
+
+	events, unsubscribe := broker.Subscribe(orgID)
+	other, unsubscribeOther := broker.Subscribe(uuid.New())
+	defer unsubscribeOther()
+
+	require.NoError(t, broker.Publish(ctx, &SecurityEvent{OrganizationID: orgID, Action: "user.login"}))
+	require.Equal(t, "user.login", (<-events).Action)
+	require.Empty(t, other, "events stay within their organization")
+
+	// A subscriber that falls behind misses events rather than blocking
+	for range subscriberBuffer + 1 {
+		require.NoError(t, broker.Publish(ctx, &SecurityEvent{OrganizationID: orgID, Action: "user.login"}))
+	}
+	require.Len(t, events, subscriberBuffer)
+
+	unsubscribe()
+	unsubscribe()
+	require.Empty(t, broker.subscribers[orgID])
+}
+
+func TestRedisEventBroker(t *testing.T) {
+	ctx := context.Background()
+	_, client := setupRedis(t)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	// Events published on one instance reach subscribers on another
+	publisher := NewRedisEventBroker(ctx, client, logger)
+	defer publisher.Close()
+	subscriber := NewRedisEventBroker(ctx, client, logger)
+	defer subscriber.Close()
+
+	orgID := uuid.New()
+	events, unsubscribe := subscriber.Subscribe(orgID)
+	defer unsubscribe()
+
+	// The pattern subscription is established asynchronously
+	require.Eventually(t, func() bool {
+		require.NoError(t, publisher.Publish(ctx, &SecurityEvent{OrganizationID: orgID, Action: "invitation.created"}))
+		select {
+		case event := <-events:
+			require.Equal(t, "invitation.created", event.Action)
+			require.Equal(t, orgID, event.OrganizationID)
+			return true
+		case <-time.After(50 * time.Millisecond):
+			return false
+		}
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestEventStream(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	srv, err := NewServer(store, newTestConfig(t))
+	require.NoError(t, err)
+
+	org, err := store.CreateOrganization(ctx, "Acme", "owner@acme.test", "Owner")
+	require.NoError(t, err)
+	owner, err := store.GetUser(ctx, org.OwnerID)
+	require.NoError(t, err)
+	token, err := srv.tokenManager.GenerateToken(owner)
+	require.NoError(t, err)
+
+	server := httptest.NewServer(srv)
+	defer server.Close()
+
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	req, err := http.NewRequestWithContext(streamCtx, http.MethodGet,
+		server.URL+"/organizations/"+org.ID.String()+"/events/stream", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	lines := bufio.NewScanner(resp.Body)
+	require.True(t, lines.Scan())
+	require.Equal(t, ": connected", lines.Text())
+	require.True(t, lines.Scan())
+
+	// Audited actions are streamed as they are recorded
+	audited := httptest.NewRequest(http.MethodPost, "/", nil)
+	audited = audited.WithContext(context.WithValue(audited.Context(), userContextKey, owner))
+	srv.recordAudit(audited, "member.role_changed", org.ID, "user-1", AuditMetadata{"role": "admin"})
+	srv.recordAudit(audited, "platform.log_level_changed", uuid.Nil, "", nil)
+
+	var event []string
+	for lines.Scan() && lines.Text() != "" {
+		event = append(event, lines.Text())
+	}
+	require.Len(t, event, 2)
+	require.Equal(t, "event: member.role_changed", event[0])
+
+	var streamed SecurityEvent
+	require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(event[1], "data: ")), &streamed))
+	require.Equal(t, org.ID, streamed.OrganizationID)
+	require.Equal(t, owner.ID, *streamed.ActorID)
+	require.Equal(t, "user-1", streamed.TargetID)
+	require.Equal(t, "admin", streamed.Metadata["role"])
+	require.False(t, streamed.CreatedAt.IsZero())
+
+	// Another organization's stream is forbidden
+	req, err = http.NewRequest(http.MethodGet, server.URL+"/organizations/"+uuid.NewString()+"/events/stream", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+token)
+	forbidden, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	forbidden.Body.Close()
+	require.Equal(t, http.StatusForbidden, forbidden.StatusCode)
+}
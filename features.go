@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Feature flags let a capability ship dark and be turned on for chosen
+// organizations, or a growing share of them, without a deploy. Platform
+// admins manage the flags; code asks whether one is on for the request's
+// organization with FeatureEnabled, or gates a route with RequireFeature.
+
+var ErrFeatureFlagNotFound = errors.New("feature flag not found")
+
+// FeatureFlag is a capability and who has it
+type FeatureFlag struct {
+	Key         string `db:"key" json:"key"`
+	Description string `db:"description" json:"description"`
+	// Enabled is the flag's kill switch: a disabled flag is off for every
+	// organization, whatever its rollout or overrides
+	Enabled bool `db:"enabled" json:"enabled"`
+	// RolloutPercent of organizations have the flag. Each organization
+	// falls in a fixed bucket per flag, so raising the percentage only adds
+	// organizations.
+	RolloutPercent int `db:"rollout_percent" json:"rollout_percent"`
+	// Organizations turns the flag on or off for particular organizations,
+	// whatever the rollout
+	Organizations map[uuid.UUID]bool `db:"-" json:"organizations"`
+	CreatedAt     time.Time          `db:"created_at" json:"created_at"`
+	UpdatedAt     time.Time          `db:"updated_at" json:"updated_at"`
+}
+
+// EnabledFor reports whether the flag is on for an organization
+func (f *FeatureFlag) EnabledFor(orgID uuid.UUID) bool {
+	if !f.Enabled {
+		return false
+	}
+	if enabled, ok := f.Organizations[orgID]; ok {
+		return enabled
+	}
+	return rolloutBucket(f.Key, orgID) < f.RolloutPercent
+}
+
+// rolloutBucket places an organization in one of 100 buckets for a flag.
+// Hashing the key too means the same organizations are not always first.
+func rolloutBucket(key string, orgID uuid.UUID) int {
+	sum := sha256.Sum256(append([]byte(key+":"), orgID[:]...))
+	return int(binary.BigEndian.Uint32(sum[:4]) % 100)
+}
+
+// FeatureFlagRequest creates or replaces a flag, keeping its organizations
+type FeatureFlagRequest struct {
+	Description    string `json:"description"`
+	Enabled        bool   `json:"enabled"`
+	RolloutPercent int    `json:"rollout_percent"`
+}
+
+// FeatureFlagOrganizationRequest turns a flag on or off for an organization
+type FeatureFlagOrganizationRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+// OrganizationFeatures are the flags and whether each is on for an
+// organization
+type OrganizationFeatures struct {
+	Features map[string]bool `json:"features"`
+}
+
+var featureFlagKey = regexp.MustCompile(`^[a-z][a-z0-9_.-]{0,63}$`)
+
+func ValidateFeatureFlagKey(key string) error {
+	if !featureFlagKey.MatchString(key) {
+		return &ValidationError{Field: "key", Message: "must be lowercase letters, digits, '_', '.' or '-', starting with a letter, at most 64 characters"}
+	}
+	return nil
+}
+
+func ValidateFeatureFlagRequest(req *FeatureFlagRequest) error {
+	var errs ValidationErrors
+	if len(req.Description) > 1024 {
+		errs = append(errs, &ValidationError{Field: "description", Message: ErrFieldTooLong.Error()})
+	}
+	if req.RolloutPercent < 0 || req.RolloutPercent > 100 {
+		errs = append(errs, &ValidationError{Field: "rollout_percent", Message: "must be between 0 and 100"})
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// FeatureFlags evaluates flags from a copy loaded from the store, which is
+// reloaded once older than ttl. Changes made through this instance apply at
+// once; other instances pick them up within ttl.
+type FeatureFlags struct {
+	mu       sync.Mutex
+	flags    map[string]*FeatureFlag
+	loadedAt time.Time
+	ttl      time.Duration
+	load     func(ctx context.Context) ([]FeatureFlag, error)
+	logger   *slog.Logger
+	now      func() time.Time
+}
+
+func NewFeatureFlags(load func(ctx context.Context) ([]FeatureFlag, error), ttl time.Duration, logger *slog.Logger) *FeatureFlags {
+	return &FeatureFlags{
+		ttl:    ttl,
+		load:   load,
+		logger: logger,
+		now:    time.Now,
+	}
+}
+
+// current returns the flags, reloading them if they are stale. If the
+// reload fails the previous flags are kept until the next attempt, and
+// with none loaded every flag is off.
+func (f *FeatureFlags) current(ctx context.Context) map[string]*FeatureFlag {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if now := f.now(); f.flags == nil || now.Sub(f.loadedAt) >= f.ttl {
+		// Whatever the outcome, wait a full ttl before querying again
+		f.loadedAt = now
+		flags, err := f.load(ctx)
+		if err != nil {
+			f.logger.ErrorContext(ctx, "failed to load feature flags", "error", err)
+		} else {
+			f.flags = make(map[string]*FeatureFlag, len(flags))
+			for i := range flags {
+				f.flags[flags[i].Key] = &flags[i]
+			}
+		}
+	}
+	return f.flags
+}
+
+// Enabled reports whether the flag named key is on for an organization.
+// Unknown flags are off.
+func (f *FeatureFlags) Enabled(ctx context.Context, key string, orgID uuid.UUID) bool {
+	if f == nil {
+		return false
+	}
+	flag, ok := f.current(ctx)[key]
+	return ok && flag.EnabledFor(orgID)
+}
+
+// Evaluate reports whether each flag is on for an organization
+func (f *FeatureFlags) Evaluate(ctx context.Context, orgID uuid.UUID) map[string]bool {
+	features := map[string]bool{}
+	if f == nil {
+		return features
+	}
+	for key, flag := range f.current(ctx) {
+		features[key] = flag.EnabledFor(orgID)
+	}
+	return features
+}
+
+// Invalidate forces the next evaluation to reload from the store
+func (f *FeatureFlags) Invalidate() {
+	f.mu.Lock()
+	f.loadedAt = time.Time{}
+	f.mu.Unlock()
+}
+
+const featureFlagsContextKey contextKey = "feature_flags"
+
+// Handler makes the flags available to FeatureEnabled for the rest of the
+// request
+func (f *FeatureFlags) Handler(next http.Handler) http.Handler {
+	if f == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), featureFlagsContextKey, f)))
+	})
+}
+
+// FeatureEnabled reports whether the flag named key is on for the
+// authenticated user's organization. It is off for unauthenticated
+// requests.
+func FeatureEnabled(ctx context.Context, key string) bool {
+	flags, _ := ctx.Value(featureFlagsContextKey).(*FeatureFlags)
+	user, err := GetUserFromContext(ctx)
+	if flags == nil || err != nil {
+		return false
+	}
+	return flags.Enabled(ctx, key, user.OrganizationID)
+}
+
+// RequireFeature hides a route from organizations without the flag named
+// key, as if it did not exist. It must follow authentication.
+func RequireFeature(key string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !FeatureEnabled(r.Context(), key) {
+				http.NotFound(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
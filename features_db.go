@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+const featureFlagColumns = `key, description, enabled, rollout_percent, created_at, updated_at`
+
+// featureFlagOrganization is a row of feature_flag_organizations
+type featureFlagOrganization struct {
+	FlagKey        string    `db:"flag_key"`
+	OrganizationID uuid.UUID `db:"organization_id"`
+	Enabled        bool      `db:"enabled"`
+}
+
+// ListFeatureFlags returns every flag with the organizations it is set for.
+// The flags serve every organization, so it reads outside any tenant.
+func (db *DB) ListFeatureFlags(ctx context.Context) ([]FeatureFlag, error) {
+	ctx = unscoped(ctx)
+	flags := []FeatureFlag{}
+	var orgs []featureFlagOrganization
+	err := db.read(ctx, func(q sqlx.QueryerContext) error {
+		if err := sqlx.SelectContext(ctx, q, &flags, `
+			SELECT `+featureFlagColumns+` FROM feature_flags ORDER BY key
+		`); err != nil {
+			return err
+		}
+		return sqlx.SelectContext(ctx, q, &orgs, `
+			SELECT flag_key, organization_id, enabled FROM feature_flag_organizations
+		`)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	byKey := make(map[string]*FeatureFlag, len(flags))
+	for i := range flags {
+		flags[i].Organizations = map[uuid.UUID]bool{}
+		byKey[flags[i].Key] = &flags[i]
+	}
+	for _, o := range orgs {
+		if flag, ok := byKey[o.FlagKey]; ok {
+			flag.Organizations[o.OrganizationID] = o.Enabled
+		}
+	}
+	return flags, nil
+}
+
+func (db *DB) GetFeatureFlag(ctx context.Context, key string) (*FeatureFlag, error) {
+	flag := &FeatureFlag{Organizations: map[uuid.UUID]bool{}}
+	var orgs []featureFlagOrganization
+	err := db.read(ctx, func(q sqlx.QueryerContext) error {
+		if err := sqlx.GetContext(ctx, q, flag, `
+			SELECT `+featureFlagColumns+` FROM feature_flags WHERE key = $1
+		`, key); err != nil {
+			return err
+		}
+		return sqlx.SelectContext(ctx, q, &orgs, `
+			SELECT flag_key, organization_id, enabled FROM feature_flag_organizations WHERE flag_key = $1
+		`, key)
+	})
+	if err == sql.ErrNoRows {
+		return nil, ErrFeatureFlagNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, o := range orgs {
+		flag.Organizations[o.OrganizationID] = o.Enabled
+	}
+	return flag, nil
+}
+
+func (db *DB) PutFeatureFlag(ctx context.Context, flag *FeatureFlag) error {
+	return db.transact(ctx, func(tx *sqlx.Tx) error {
+		if err := tx.GetContext(ctx, flag, `
+			INSERT INTO feature_flags (key, description, enabled, rollout_percent)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (key) DO UPDATE SET
+				description = EXCLUDED.description, enabled = EXCLUDED.enabled,
+				rollout_percent = EXCLUDED.rollout_percent, updated_at = NOW()
+			RETURNING `+featureFlagColumns,
+			flag.Key, flag.Description, flag.Enabled, flag.RolloutPercent); err != nil {
+			return err
+		}
+
+		var orgs []featureFlagOrganization
+		if err := tx.SelectContext(ctx, &orgs, `
+			SELECT flag_key, organization_id, enabled FROM feature_flag_organizations WHERE flag_key = $1
+		`, flag.Key); err != nil {
+			return err
+		}
+		flag.Organizations = make(map[uuid.UUID]bool, len(orgs))
+		for _, o := range orgs {
+			flag.Organizations[o.OrganizationID] = o.Enabled
+		}
+		return nil
+	})
+}
+
+func (db *DB) DeleteFeatureFlag(ctx context.Context, key string) error {
+	return db.transact(ctx, func(tx *sqlx.Tx) error {
+		result, err := tx.ExecContext(ctx, `DELETE FROM feature_flags WHERE key = $1`, key)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrFeatureFlagNotFound
+		}
+		return nil
+	})
+}
+
+func (db *DB) SetFeatureFlagOrganization(ctx context.Context, key string, orgID uuid.UUID, enabled bool) error {
+	return db.transact(ctx, func(tx *sqlx.Tx) error {
+		if err := touchFeatureFlag(ctx, tx, key); err != nil {
+			return err
+		}
+		var exists bool
+		if err := tx.GetContext(ctx, &exists, `
+			SELECT EXISTS (SELECT 1 FROM organizations WHERE id = $1 AND deleted_at IS NULL)
+		`, orgID); err != nil {
+			return err
+		}
+		if !exists {
+			return ErrOrganizationNotFound
+		}
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO feature_flag_organizations (flag_key, organization_id, enabled)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (flag_key, organization_id) DO UPDATE SET enabled = EXCLUDED.enabled
+		`, key, orgID, enabled)
+		return err
+	})
+}
+
+func (db *DB) RemoveFeatureFlagOrganization(ctx context.Context, key string, orgID uuid.UUID) error {
+	return db.transact(ctx, func(tx *sqlx.Tx) error {
+		if err := touchFeatureFlag(ctx, tx, key); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `
+			DELETE FROM feature_flag_organizations WHERE flag_key = $1 AND organization_id = $2
+		`, key, orgID)
+		return err
+	})
+}
+
+// touchFeatureFlag marks a flag updated, locking it until tx ends
+func touchFeatureFlag(ctx context.Context, tx *sqlx.Tx, key string) error {
+	result, err := tx.ExecContext(ctx, `UPDATE feature_flags SET updated_at = NOW() WHERE key = $1`, key)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrFeatureFlagNotFound
+	}
+	return nil
+}
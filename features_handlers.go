@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+// handleGetOrganizationFeatures tells clients which flags are on for the
+// organization, so they can show or hide what the flags gate
+func (s *Server) handleGetOrganizationFeatures(w http.ResponseWriter, r *http.Request) {
+	features := s.features.Evaluate(r.Context(), pathOrgID(r))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(OrganizationFeatures{Features: features})
+}
+
+func (s *Server) handleAdminListFeatureFlags(w http.ResponseWriter, r *http.Request) {
+	flags, err := s.store.ListFeatureFlags(r.Context())
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to list feature flags", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(flags)
+}
+
+func (s *Server) handleAdminGetFeatureFlag(w http.ResponseWriter, r *http.Request) {
+	flag, err := s.store.GetFeatureFlag(r.Context(), r.PathValue("key"))
+	if err != nil {
+		switch err {
+		case ErrFeatureFlagNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			s.logger.ErrorContext(r.Context(), "failed to get feature flag", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(flag)
+}
+
+// handleAdminPutFeatureFlag creates or replaces a flag. The organizations
+// it is turned on or off for are kept.
+func (s *Server) handleAdminPutFeatureFlag(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+
+	var req FeatureFlagRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if err := joinValidationErrors(ValidateFeatureFlagKey(key), ValidateFeatureFlagRequest(&req)); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+
+	flag := &FeatureFlag{
+		Key:            key,
+		Description:    req.Description,
+		Enabled:        req.Enabled,
+		RolloutPercent: req.RolloutPercent,
+	}
+	if err := s.store.PutFeatureFlag(r.Context(), flag); err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to save feature flag", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	s.features.Invalidate()
+
+	s.recordAudit(r, "feature_flag.updated", uuid.Nil, key, AuditMetadata{
+		"enabled":         strconv.FormatBool(flag.Enabled),
+		"rollout_percent": strconv.Itoa(flag.RolloutPercent),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(flag)
+}
+
+func (s *Server) handleAdminDeleteFeatureFlag(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+
+	if err := s.store.DeleteFeatureFlag(r.Context(), key); err != nil {
+		switch err {
+		case ErrFeatureFlagNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			s.logger.ErrorContext(r.Context(), "failed to delete feature flag", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+	s.features.Invalidate()
+
+	s.recordAudit(r, "feature_flag.deleted", uuid.Nil, key, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAdminSetFeatureFlagOrganization turns a flag on or off for one
+// organization, whatever the flag's rollout
+func (s *Server) handleAdminSetFeatureFlagOrganization(w http.ResponseWriter, r *http.Request) {
+	key, orgID := r.PathValue("key"), pathOrgID(r)
+
+	var req FeatureFlagOrganizationRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	if err := s.store.SetFeatureFlagOrganization(r.Context(), key, orgID, req.Enabled); err != nil {
+		switch err {
+		case ErrFeatureFlagNotFound, ErrOrganizationNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			s.logger.ErrorContext(r.Context(), "failed to set feature flag organization", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+	s.features.Invalidate()
+
+	s.recordAudit(r, "feature_flag.organization_set", orgID, key, AuditMetadata{
+		"enabled": strconv.FormatBool(req.Enabled),
+	})
+	s.writeFeatureFlag(w, r, key)
+}
+
+// handleAdminRemoveFeatureFlagOrganization returns an organization to the
+// flag's rollout
+func (s *Server) handleAdminRemoveFeatureFlagOrganization(w http.ResponseWriter, r *http.Request) {
+	key, orgID := r.PathValue("key"), pathOrgID(r)
+
+	if err := s.store.RemoveFeatureFlagOrganization(r.Context(), key, orgID); err != nil {
+		switch err {
+		case ErrFeatureFlagNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			s.logger.ErrorContext(r.Context(), "failed to remove feature flag organization", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+	s.features.Invalidate()
+
+	s.recordAudit(r, "feature_flag.organization_removed", orgID, key, nil)
+	s.writeFeatureFlag(w, r, key)
+}
+
+// writeFeatureFlag responds with the flag as it is now
+func (s *Server) writeFeatureFlag(w http.ResponseWriter, r *http.Request, key string) {
+	flag, err := s.store.GetFeatureFlag(r.Context(), key)
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to get feature flag", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(flag)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFeatureFlagEnabledFor(t *testing.T) {
+	orgID := uuid.New()
+	flag := &FeatureFlag{Key: "teams", Enabled: true, RolloutPercent: 100}
+	require.True(t, flag.EnabledFor(orgID))
+
+	flag.Organizations = map[uuid.UUID]bool{orgID: false}
+	require.False(t, flag.EnabledFor(orgID), "organizations override the rollout")
+
+	flag.RolloutPercent, flag.Organizations[orgID] = 0, true
+	require.True(t, flag.EnabledFor(orgID))
+
+	flag.Enabled = false
+	require.False(t, flag.EnabledFor(orgID), "disabled flags are off for everyone")
+
+	t.Run("Rollouts only grow", func(t *testing.T) {
+		flag := &FeatureFlag{Key: "scim", Enabled: true, RolloutPercent: 20}
+		var orgs []uuid.UUID
+		for range 1000 {
+			orgs = append(orgs, uuid.New())
+		}
+		var enabled []uuid.UUID
+		for _, id := range orgs {
+			if flag.EnabledFor(id) {
+				enabled = append(enabled, id)
+			}
+		}
+		require.InDelta(t, 200, len(enabled), 60)
+
+		flag.RolloutPercent = 50
+		for _, id := range enabled {
+			require.True(t, flag.EnabledFor(id))
+		}
+	})
+}
+
+func TestValidateFeatureFlagRequest(t *testing.T) {
+	require.NoError(t, ValidateFeatureFlagKey("teams.scim-v2"))
+	for _, key := range []string{"", "Teams", "2fa", "a b"} {
+		require.Error(t, ValidateFeatureFlagKey(key), key)
+	}
+
+	require.NoError(t, ValidateFeatureFlagRequest(&FeatureFlagRequest{RolloutPercent: 100}))
+	require.Error(t, ValidateFeatureFlagRequest(&FeatureFlagRequest{RolloutPercent: 101}))
+	require.Error(t, ValidateFeatureFlagRequest(&FeatureFlagRequest{RolloutPercent: -1}))
+}
+
+func TestFeatureFlags(t *testing.T) {
+	orgID := uuid.New()
+	loads := 0
+	flags := []FeatureFlag{{Key: "teams", Enabled: true, RolloutPercent: 100}}
+	var loadErr error
+	features := NewFeatureFlags(func(ctx context.Context) ([]FeatureFlag, error) {
+		loads++
+		return flags, loadErr
+	}, time.Minute, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	now := time.Now()
+	features.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	require.True(t, features.Enabled(ctx, "teams", orgID))
+	require.False(t, features.Enabled(ctx, "unknown", orgID))
+	require.Equal(t, 1, loads, "evaluations within the ttl use the loaded flags")
+
+	// Invalidate reloads on the next evaluation
+	flags = []FeatureFlag{{Key: "teams"}, {Key: "scim", Enabled: true, RolloutPercent: 100}}
+	features.Invalidate()
+	require.Equal(t, map[string]bool{"teams": false, "scim": true}, features.Evaluate(ctx, orgID))
+	require.Equal(t, 2, loads)
+
+	// A failed reload keeps the previous flags
+	loadErr = errors.New("database unavailable")
+	now = now.Add(time.Minute)
+	require.True(t, features.Enabled(ctx, "scim", orgID))
+	require.Equal(t, 3, loads)
+
+	var none *FeatureFlags
+	require.False(t, none.Enabled(ctx, "scim", orgID))
+	require.Empty(t, none.Evaluate(ctx, orgID))
+}
+
+func TestFeatureFlagAPI(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	srv, err := NewServer(store, newTestConfig(t))
+	require.NoError(t, err)
+
+	org, err := store.CreateOrganization(ctx, "Acme", "owner@acme.test", "Owner")
+	require.NoError(t, err)
+	owner, err := store.GetUser(ctx, org.OwnerID)
+	require.NoError(t, err)
+	ownerToken, err := srv.tokenManager.GenerateToken(owner)
+	require.NoError(t, err)
+
+	operators, err := store.CreateOrganization(ctx, "Operators", "ops@example.com", "Ops")
+	require.NoError(t, err)
+	store.users[operators.OwnerID].Permissions[string(PermPlatformAdmin)] = true
+	admin, err := store.GetUser(ctx, operators.OwnerID)
+	require.NoError(t, err)
+	adminToken, err := srv.tokenManager.GenerateToken(admin)
+	require.NoError(t, err)
+
+	do := func(method, path, token string, body any) *httptest.ResponseRecorder {
+		var data []byte
+		if body != nil {
+			data, err = json.Marshal(body)
+			require.NoError(t, err)
+		}
+		req := httptest.NewRequest(method, path, bytes.NewReader(data))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		if method != http.MethodGet {
+			addCSRFToken(t, srv, req)
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return w
+	}
+	features := func() map[string]bool {
+		w := do(http.MethodGet, "/organizations/"+org.ID.String()+"/features", ownerToken, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var resp OrganizationFeatures
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+		return resp.Features
+	}
+
+	// Routes can be hidden behind a flag
+	gated := chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		srv.features.Handler, srv.auth.RequireAuth, RequireFeature("teams"))
+	gatedStatus := func() int {
+		req := httptest.NewRequest(http.MethodGet, "/teams", nil)
+		req.Header.Set("Authorization", "Bearer "+ownerToken)
+		w := httptest.NewRecorder()
+		gated.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	t.Run("Only platform admins manage flags", func(t *testing.T) {
+		w := do(http.MethodPut, "/admin/feature-flags/teams", ownerToken, FeatureFlagRequest{Enabled: true})
+		require.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("A flag ships dark", func(t *testing.T) {
+		w := do(http.MethodPut, "/admin/feature-flags/teams", adminToken, FeatureFlagRequest{
+			Description: "Teams within organizations",
+			Enabled:     true,
+		})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Equal(t, map[string]bool{"teams": false}, features())
+		require.Equal(t, http.StatusNotFound, gatedStatus())
+
+		w = do(http.MethodPut, "/admin/feature-flags/Teams", adminToken, FeatureFlagRequest{})
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("Organizations are enabled one at a time", func(t *testing.T) {
+		w := do(http.MethodPut, "/admin/feature-flags/teams/organizations/"+org.ID.String(), adminToken,
+			FeatureFlagOrganizationRequest{Enabled: true})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var flag FeatureFlag
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&flag))
+		require.Equal(t, map[uuid.UUID]bool{org.ID: true}, flag.Organizations)
+
+		require.Equal(t, map[string]bool{"teams": true}, features())
+		require.Equal(t, http.StatusOK, gatedStatus())
+
+		// Replacing the flag keeps its organizations
+		w = do(http.MethodPut, "/admin/feature-flags/teams", adminToken, FeatureFlagRequest{Enabled: true, RolloutPercent: 0})
+		require.Equal(t, http.StatusOK, w.Code)
+		require.True(t, features()["teams"])
+
+		w = do(http.MethodPut, "/admin/feature-flags/teams/organizations/"+uuid.NewString(), adminToken,
+			FeatureFlagOrganizationRequest{Enabled: true})
+		require.Equal(t, http.StatusNotFound, w.Code)
+
+		w = do(http.MethodDelete, "/admin/feature-flags/teams/organizations/"+org.ID.String(), adminToken, nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.False(t, features()["teams"])
+	})
+
+	t.Run("Deleted flags are off", func(t *testing.T) {
+		w := do(http.MethodGet, "/admin/feature-flags", adminToken, nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Contains(t, w.Body.String(), `"key":"teams"`)
+
+		w = do(http.MethodDelete, "/admin/feature-flags/teams", adminToken, nil)
+		require.Equal(t, http.StatusNoContent, w.Code)
+		require.Empty(t, features())
+		require.Equal(t, http.StatusNotFound, do(http.MethodGet, "/admin/feature-flags/teams", adminToken, nil).Code)
+	})
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/graph-gophers/dataloader/v7"
+	"github.com/graph-gophers/graphql-go"
+	gqlerrors "github.com/graph-gophers/graphql-go/errors"
+)
+
+// gqlMaxDepth bounds how deeply a request may nest selections, so a query
+// cannot walk user.organization.users... to an arbitrary depth
+const gqlMaxDepth = 10
+
+// gqlLoaderWait is how long a loader collects the loads of concurrently
+// resolving fields before fetching them in one batch
+const gqlLoaderWait = 2 * time.Millisecond
+
+// gqlSchema is the parsed schema /graphql serves. Introspection is off,
+// as the dashboard is the only client.
+var gqlSchema = graphql.MustParseSchema(gqlSchemaSDL, &gqlRoot{},
+	graphql.MaxDepth(gqlMaxDepth),
+	graphql.DisableIntrospection(),
+)
+
+// GraphQLRequest is the body of POST /graphql. GET /graphql takes the same
+// fields as query parameters, with variables JSON encoded, and runs queries
+// only.
+type GraphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+	// Extensions is accepted, and ignored, for clients that send
+	// persisted query hashes and the like
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+// GraphQLResponse carries a result's data, absent when the request could
+// not run, and its errors
+type GraphQLResponse struct {
+	Data   json.RawMessage         `json:"data,omitempty"`
+	Errors []*gqlerrors.QueryError `json:"errors,omitempty"`
+}
+
+// GraphQLError is an error a resolver shows the client, with its code in
+// the error's extensions. Any other error a resolver returns is logged and
+// reported as an internal error.
+type GraphQLError struct {
+	Message string
+	Code    string
+	// Errors lists the invalid fields of a BAD_USER_INPUT error
+	Errors ValidationErrors
+}
+
+func (e *GraphQLError) Error() string {
+	return e.Message
+}
+
+// Extensions is added to the error's entry in the response
+func (e *GraphQLError) Extensions() map[string]interface{} {
+	ext := map[string]interface{}{"code": e.Code}
+	if len(e.Errors) > 0 {
+		ext["errors"] = e.Errors
+	}
+	return ext
+}
+
+// Codes set in a GraphQLError's extensions
+const (
+	gqlBadRequest   = "BAD_REQUEST"
+	gqlBadUserInput = "BAD_USER_INPUT"
+	gqlForbidden    = "FORBIDDEN"
+	gqlNotFound     = "NOT_FOUND"
+	gqlConflict     = "CONFLICT"
+	gqlInternal     = "INTERNAL_SERVER_ERROR"
+)
+
+func gqlErrorf(code, format string, args ...interface{}) *GraphQLError {
+	return &GraphQLError{Message: fmt.Sprintf(format, args...), Code: code}
+}
+
+type gqlContextKey struct{}
+
+// gqlContext is the state of one /graphql request. Its loaders batch the
+// users, organizations, members and sessions the request reads.
+type gqlContext struct {
+	s    *Server
+	r    *http.Request
+	user *User
+
+	// getMutation is set when a GET request tried to run a mutation
+	getMutation bool
+
+	users    *dataloader.Loader[uuid.UUID, *User]
+	orgs     *dataloader.Loader[uuid.UUID, *Organization]
+	members  *dataloader.Loader[uuid.UUID, []User]
+	sessions *dataloader.Loader[uuid.UUID, []RefreshToken]
+}
+
+func newGQLContext(s *Server, r *http.Request, user *User) *gqlContext {
+	q := &gqlContext{s: s, r: r, user: user}
+	q.users = newGQLLoader(func(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]*User, error) {
+		users, err := q.s.store.GetUsersByIDs(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+		byID := make(map[uuid.UUID]*User, len(users))
+		for i := range users {
+			byID[users[i].ID] = &users[i]
+		}
+		return byID, nil
+	})
+	q.orgs = newGQLLoader(func(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]*Organization, error) {
+		orgs, err := q.s.store.GetOrganizationsByIDs(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+		byID := make(map[uuid.UUID]*Organization, len(orgs))
+		for i := range orgs {
+			byID[orgs[i].ID] = &orgs[i]
+		}
+		return byID, nil
+	})
+	// The store has no batch reads for members and sessions; the loaders
+	// still read each organization or user once per request
+	q.members = newGQLLoader(func(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID][]User, error) {
+		members := make(map[uuid.UUID][]User, len(ids))
+		for _, id := range ids {
+			users, err := q.s.store.GetOrganizationUsers(ctx, id)
+			if err != nil {
+				return nil, err
+			}
+			members[id] = users
+		}
+		return members, nil
+	})
+	q.sessions = newGQLLoader(func(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID][]RefreshToken, error) {
+		sessions := make(map[uuid.UUID][]RefreshToken, len(ids))
+		for _, id := range ids {
+			tokens, err := q.s.store.ListUserRefreshTokens(ctx, id)
+			if err != nil {
+				return nil, err
+			}
+			sessions[id] = tokens
+		}
+		return sessions, nil
+	})
+	return q
+}
+
+// newGQLLoader batches loads by ID with fetch, which leaves IDs that were
+// not found out of its result. Results are cached for the request.
+func newGQLLoader[V any](fetch func(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]V, error)) *dataloader.Loader[uuid.UUID, V] {
+	return dataloader.NewBatchedLoader(func(ctx context.Context, ids []uuid.UUID) []*dataloader.Result[V] {
+		byID, err := fetch(ctx, ids)
+		results := make([]*dataloader.Result[V], len(ids))
+		for i, id := range ids {
+			results[i] = &dataloader.Result[V]{Data: byID[id], Error: err}
+		}
+		return results
+	}, dataloader.WithWait[uuid.UUID, V](gqlLoaderWait))
+}
+
+func gqlContextFrom(ctx context.Context) *gqlContext {
+	return ctx.Value(gqlContextKey{}).(*gqlContext)
+}
+
+// resetLoaders drops loaded records, so fields read after a mutation see
+// its changes
+func (q *gqlContext) resetLoaders() {
+	q.users.ClearAll()
+	q.orgs.ClearAll()
+	q.members.ClearAll()
+	q.sessions.ClearAll()
+}
+
+// mutation refuses mutations sent with GET, which handleGraphQL answers
+// with 405. Mutations run one at a time, so the first refuses the request
+// before any has made a change.
+func (q *gqlContext) mutation() error {
+	if q.r.Method == http.MethodGet {
+		q.getMutation = true
+		return gqlErrorf(gqlBadRequest, "Mutations must be sent with POST")
+	}
+	return nil
+}
+
+// authorize applies the checks REST routes make with RequirePermissions
+// and RequireSameOrg
+func (q *gqlContext) authorize(orgID uuid.UUID, perm Permission) error {
+	user := q.user
+	member, err := q.s.auth.hierarchy.Contains(q.r.Context(), user.OrganizationID, orgID)
+	if err != nil {
+		return err
+	}
+	if !member {
+		delegate, _, err := q.s.auth.delegate(q.r, user, orgID)
+		if err != nil && err != ErrOutsideIPAllowlist {
+			return err
+		}
+		user, member = delegate, delegate != nil
+	}
+	if !member || !user.HasPermission(perm) {
+		return gqlErrorf(gqlForbidden, "Forbidden")
+	}
+	return nil
+}
+
+// orgArg parses an organization ID argument and authorizes perm on it
+func (q *gqlContext) orgArg(id graphql.ID, perm Permission) (uuid.UUID, error) {
+	orgID, err := uuid.Parse(string(id))
+	if err != nil {
+		return uuid.Nil, gqlErrorf(gqlBadUserInput, "Invalid organization ID format")
+	}
+	return orgID, q.authorize(orgID, perm)
+}
+
+// member loads a userId argument's user, reporting users of other
+// organizations as not found like RequireOwnership
+func (q *gqlContext) member(ctx context.Context, orgID uuid.UUID, id graphql.ID) (*User, error) {
+	userID, err := uuid.Parse(string(id))
+	if err != nil {
+		return nil, gqlErrorf(gqlBadUserInput, "Invalid user ID format")
+	}
+	user, err := q.s.store.GetUser(ctx, userID)
+	if err == sql.ErrNoRows || (err == nil && user.OrganizationID != orgID) {
+		return nil, gqlErrorf(gqlNotFound, "%s", ErrUserNotFound)
+	}
+	return user, err
+}
+
+// handleGraphQL runs a GraphQL query or mutation for the dashboard.
+// Requests that cannot be parsed or validated are answered 400 with errors
+// and no data; once a request runs the answer is 200, with an error and a
+// null value for each field that failed.
+func (s *Server) handleGraphQL(w http.ResponseWriter, r *http.Request) {
+	user, err := GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req GraphQLRequest
+	if r.Method == http.MethodGet {
+		query := r.URL.Query()
+		req.Query, req.OperationName = query.Get("query"), query.Get("operationName")
+		if v := query.Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				http.Error(w, "Invalid variables", http.StatusBadRequest)
+				return
+			}
+		}
+	} else if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	q := newGQLContext(s, r, user)
+	ctx := context.WithValue(r.Context(), gqlContextKey{}, q)
+	resp := gqlSchema.Exec(ctx, req.Query, req.OperationName, req.Variables)
+	if q.getMutation {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Mutations must be sent with POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if resp.Data == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(GraphQLResponse{Errors: resp.Errors})
+		return
+	}
+	for _, gqlErr := range resp.Errors {
+		var resolverErr *GraphQLError
+		if errors.As(gqlErr.ResolverError, &resolverErr) {
+			continue
+		}
+		s.logger.ErrorContext(r.Context(), "failed to resolve GraphQL field", "path", gqlErr.Path, "error", gqlErr)
+		*gqlErr = gqlerrors.QueryError{
+			Message:    "Internal server error",
+			Path:       gqlErr.Path,
+			Extensions: map[string]interface{}{"code": gqlInternal},
+		}
+	}
+	json.NewEncoder(w).Encode(GraphQLResponse{Data: resp.Data, Errors: resp.Errors})
+}
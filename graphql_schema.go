@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+
+	"github.com/google/uuid"
+	"github.com/graph-gophers/graphql-go"
+)
+
+// gqlSchemaSDL describes the API /graphql serves. Each field is resolved
+// by the method of the same name on gqlRoot or the type's resolver below.
+const gqlSchemaSDL = `
+scalar Time
+
+schema {
+  query: Query
+  mutation: Mutation
+}
+
+type Query {
+  me: User!
+  # Defaults to the caller's organization
+  organization(id: ID): Organization
+}
+
+type Mutation {
+  inviteUser(organizationId: ID!, email: String!, name: String!): User
+  updateUserRole(organizationId: ID!, userId: ID!, role: String!): User
+  removeUser(organizationId: ID!, userId: ID!): Boolean
+  revokeUserSessions(organizationId: ID!, userId: ID!): Boolean
+}
+
+type User {
+  id: ID!
+  email: String!
+  name: String!
+  role: String!
+  permissions: [String!]!
+  organizationId: ID!
+  version: Int!
+  createdAt: Time!
+  updatedAt: Time!
+  organization: Organization
+  # Your own, or with update:user
+  sessions: [Session!]
+}
+
+type Organization {
+  id: ID!
+  name: String!
+  slug: String
+  subscriptionTier: String!
+  maxSubAccounts: Int!
+  seatOverage: String!
+  suspendedAt: Time
+  version: Int!
+  createdAt: Time!
+  updatedAt: Time!
+  owner: User
+  # Ordered like GET /organizations/{orgID}/users
+  users(limit: Int, offset: Int): [User!]!
+  userCount: Int!
+}
+
+type Session {
+  id: ID!
+  createdAt: Time!
+  expiresAt: Time!
+}
+`
+
+// gqlRoot resolves the fields of Query and Mutation
+type gqlRoot struct{}
+
+func (*gqlRoot) Me(ctx context.Context) *gqlUser {
+	q := gqlContextFrom(ctx)
+	return &gqlUser{q: q, u: q.user}
+}
+
+func (*gqlRoot) Organization(ctx context.Context, args struct{ ID *graphql.ID }) (*gqlOrganization, error) {
+	q := gqlContextFrom(ctx)
+	orgID := q.user.OrganizationID
+	if args.ID != nil {
+		var err error
+		if orgID, err = uuid.Parse(string(*args.ID)); err != nil {
+			return nil, gqlErrorf(gqlBadUserInput, "Invalid organization ID format")
+		}
+	}
+	if err := q.authorize(orgID, PermReadOrg); err != nil {
+		return nil, err
+	}
+	return q.loadOrganization(ctx, orgID)
+}
+
+func (*gqlRoot) InviteUser(ctx context.Context, args struct {
+	OrganizationID graphql.ID
+	Email, Name    string
+}) (*gqlUser, error) {
+	q := gqlContextFrom(ctx)
+	if err := q.mutation(); err != nil {
+		return nil, err
+	}
+	orgID, err := q.orgArg(args.OrganizationID, PermInviteUser)
+	if err != nil {
+		return nil, err
+	}
+	req := &AddUserRequest{Email: args.Email, Name: args.Name}
+	err = joinValidationErrors(ValidateAddUserRequest(req), q.s.checkInvitation(ctx, orgID, req.Email))
+	if err != nil {
+		var valErrs ValidationErrors
+		if errors.As(err, &valErrs) {
+			gqlErr := gqlErrorf(gqlBadUserInput, "%s", valErrs)
+			gqlErr.Errors = valErrs
+			return nil, gqlErr
+		}
+		return nil, err
+	}
+	req.Email = q.s.emailPolicy.Normalize(req.Email)
+
+	user, err := q.s.store.AddUserToOrganization(ctx, orgID, req.Email, req.Name)
+	switch err {
+	case nil:
+	case ErrEmailTaken:
+		return nil, gqlErrorf(gqlConflict, "%s", err)
+	case ErrMaxSubAccounts:
+		return nil, gqlErrorf(gqlForbidden, "%s", err)
+	default:
+		return nil, err
+	}
+
+	q.s.recordAudit(q.r, "user.added", orgID, user.ID.String(), AuditMetadata{"role": user.Role})
+	q.resetLoaders()
+	return &gqlUser{q: q, u: user}, nil
+}
+
+func (*gqlRoot) UpdateUserRole(ctx context.Context, args struct {
+	OrganizationID, UserID graphql.ID
+	Role                   string
+}) (*gqlUser, error) {
+	q := gqlContextFrom(ctx)
+	if err := q.mutation(); err != nil {
+		return nil, err
+	}
+	orgID, err := q.orgArg(args.OrganizationID, PermUpdateUser)
+	if err != nil {
+		return nil, err
+	}
+	member, err := q.member(ctx, orgID, args.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := q.s.store.UpdateUserRole(ctx, orgID, member.ID, args.Role)
+	switch err {
+	case nil:
+	case ErrUnknownRole:
+		return nil, gqlErrorf(gqlBadUserInput, "%s", err)
+	case ErrUserNotFound:
+		return nil, gqlErrorf(gqlNotFound, "%s", err)
+	case ErrOwnerRole:
+		return nil, gqlErrorf(gqlConflict, "%s", err)
+	case ErrMaxSubAccounts:
+		return nil, gqlErrorf(gqlForbidden, "%s", err)
+	default:
+		return nil, err
+	}
+
+	if user.Role != member.Role {
+		q.s.recordAudit(q.r, "user.role_changed", orgID, user.ID.String(), AuditMetadata{"from": member.Role, "to": user.Role})
+	}
+	q.resetLoaders()
+	return &gqlUser{q: q, u: user}, nil
+}
+
+func (*gqlRoot) RemoveUser(ctx context.Context, args struct{ OrganizationID, UserID graphql.ID }) (*bool, error) {
+	q := gqlContextFrom(ctx)
+	if err := q.mutation(); err != nil {
+		return nil, err
+	}
+	orgID, err := q.orgArg(args.OrganizationID, PermRemoveUser)
+	if err != nil {
+		return nil, err
+	}
+	user, err := q.member(ctx, orgID, args.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	switch err := q.s.store.DeleteUser(ctx, user.ID); err {
+	case nil:
+	case ErrUserNotFound:
+		return nil, gqlErrorf(gqlNotFound, "%s", err)
+	case ErrDeleteOwner:
+		return nil, gqlErrorf(gqlConflict, "%s", err)
+	default:
+		return nil, err
+	}
+
+	q.s.recordAudit(q.r, "user.deleted", orgID, user.ID.String(), nil)
+	q.resetLoaders()
+	removed := true
+	return &removed, nil
+}
+
+func (*gqlRoot) RevokeUserSessions(ctx context.Context, args struct{ OrganizationID, UserID graphql.ID }) (*bool, error) {
+	q := gqlContextFrom(ctx)
+	if err := q.mutation(); err != nil {
+		return nil, err
+	}
+	orgID, err := q.orgArg(args.OrganizationID, PermUpdateUser)
+	if err != nil {
+		return nil, err
+	}
+	user, err := q.member(ctx, orgID, args.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := q.s.store.InvalidateUserRefreshTokens(ctx, user.ID); err != nil {
+		return nil, err
+	}
+
+	q.s.recordAudit(q.r, "user.sessions_revoked", orgID, user.ID.String(), nil)
+	q.resetLoaders()
+	revoked := true
+	return &revoked, nil
+}
+
+// loadOrganization resolves an organization through the request's loader,
+// or nil if it does not exist
+func (q *gqlContext) loadOrganization(ctx context.Context, id uuid.UUID) (*gqlOrganization, error) {
+	org, err := q.orgs.Load(ctx, id)()
+	if err != nil || org == nil {
+		return nil, err
+	}
+	return &gqlOrganization{q: q, o: org}, nil
+}
+
+type gqlUser struct {
+	q *gqlContext
+	u *User
+}
+
+func (r *gqlUser) ID() graphql.ID             { return graphql.ID(r.u.ID.String()) }
+func (r *gqlUser) Email() string              { return r.u.Email }
+func (r *gqlUser) Name() string               { return r.u.Name }
+func (r *gqlUser) Role() string               { return r.u.Role }
+func (r *gqlUser) Permissions() []string      { return effectivePermissions(r.u) }
+func (r *gqlUser) OrganizationID() graphql.ID { return graphql.ID(r.u.OrganizationID.String()) }
+func (r *gqlUser) Version() int32             { return int32(r.u.Version) }
+func (r *gqlUser) CreatedAt() graphql.Time    { return graphql.Time{Time: r.u.CreatedAt} }
+func (r *gqlUser) UpdatedAt() graphql.Time    { return graphql.Time{Time: r.u.UpdatedAt} }
+
+func (r *gqlUser) Organization(ctx context.Context) (*gqlOrganization, error) {
+	if err := r.q.authorize(r.u.OrganizationID, PermReadOrg); err != nil {
+		return nil, err
+	}
+	return r.q.loadOrganization(ctx, r.u.OrganizationID)
+}
+
+func (r *gqlUser) Sessions(ctx context.Context) (*[]*gqlSession, error) {
+	if r.u.ID != r.q.user.ID {
+		if err := r.q.authorize(r.u.OrganizationID, PermUpdateUser); err != nil {
+			return nil, err
+		}
+	}
+	tokens, err := r.q.sessions.Load(ctx, r.u.ID)()
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]*gqlSession, len(tokens))
+	for i := range tokens {
+		sessions[i] = &gqlSession{&tokens[i]}
+	}
+	return &sessions, nil
+}
+
+type gqlOrganization struct {
+	q *gqlContext
+	o *Organization
+}
+
+func (r *gqlOrganization) ID() graphql.ID           { return graphql.ID(r.o.ID.String()) }
+func (r *gqlOrganization) Name() string             { return r.o.Name }
+func (r *gqlOrganization) Slug() *string            { return r.o.Slug }
+func (r *gqlOrganization) SubscriptionTier() string { return r.o.SubscriptionTier }
+func (r *gqlOrganization) MaxSubAccounts() int32    { return int32(r.o.MaxSubAccounts) }
+func (r *gqlOrganization) SeatOverage() string      { return r.o.SeatOverage }
+func (r *gqlOrganization) Version() int32           { return int32(r.o.Version) }
+func (r *gqlOrganization) CreatedAt() graphql.Time  { return graphql.Time{Time: r.o.CreatedAt} }
+func (r *gqlOrganization) UpdatedAt() graphql.Time  { return graphql.Time{Time: r.o.UpdatedAt} }
+
+func (r *gqlOrganization) SuspendedAt() *graphql.Time {
+	if r.o.SuspendedAt == nil {
+		return nil
+	}
+	return &graphql.Time{Time: *r.o.SuspendedAt}
+}
+
+func (r *gqlOrganization) Owner(ctx context.Context) (*gqlUser, error) {
+	owner, err := r.q.users.Load(ctx, r.o.OwnerID)()
+	if err != nil || owner == nil {
+		return nil, err
+	}
+	return &gqlUser{q: r.q, u: owner}, nil
+}
+
+func (r *gqlOrganization) Users(ctx context.Context, args struct{ Limit, Offset *int32 }) ([]*gqlUser, error) {
+	limit, offset := defaultPageSize, 0
+	if args.Limit != nil {
+		limit = min(int(*args.Limit), maxPageSize)
+	}
+	if args.Offset != nil {
+		offset = int(*args.Offset)
+	}
+	if limit < 1 || offset < 0 {
+		return nil, gqlErrorf(gqlBadUserInput, "Invalid pagination parameters")
+	}
+
+	members, err := r.q.members.Load(ctx, r.o.ID)()
+	if err != nil {
+		return nil, err
+	}
+	page, _ := paginate(members, limit, offset)
+	users := make([]*gqlUser, len(page))
+	for i := range page {
+		users[i] = &gqlUser{q: r.q, u: &page[i]}
+	}
+	return users, nil
+}
+
+func (r *gqlOrganization) UserCount(ctx context.Context) (int32, error) {
+	members, err := r.q.members.Load(ctx, r.o.ID)()
+	return int32(len(members)), err
+}
+
+type gqlSession struct {
+	rt *RefreshToken
+}
+
+func (r *gqlSession) ID() graphql.ID          { return graphql.ID(r.rt.ID.String()) }
+func (r *gqlSession) CreatedAt() graphql.Time { return graphql.Time{Time: r.rt.CreatedAt} }
+func (r *gqlSession) ExpiresAt() graphql.Time { return graphql.Time{Time: r.rt.ExpiresAt} }
+
+// effectivePermissions lists the permissions a user holds through their
+// role or directly
+func effectivePermissions(u *User) []string {
+	perms := []string{}
+	for _, p := range RolePermissions[u.Role] {
+		perms = append(perms, string(p))
+	}
+	for p, granted := range u.Permissions {
+		if granted {
+			perms = append(perms, p)
+		}
+	}
+	slices.Sort(perms)
+	return slices.Compact(perms)
+}
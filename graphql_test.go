@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// batchCountingStore counts the batch reads GraphQL loaders make
+type batchCountingStore struct {
+	Store
+	userBatches, orgBatches int
+}
+
+func (c *batchCountingStore) GetUsersByIDs(ctx context.Context, ids []uuid.UUID) ([]User, error) {
+	c.userBatches++
+	return c.Store.GetUsersByIDs(ctx, ids)
+}
+
+func (c *batchCountingStore) GetOrganizationsByIDs(ctx context.Context, ids []uuid.UUID) ([]Organization, error) {
+	c.orgBatches++
+	return c.Store.GetOrganizationsByIDs(ctx, ids)
+}
+
+func TestGraphQL(t *testing.T) {
+	ctx := context.Background()
+	store := &batchCountingStore{Store: NewMemoryStore()}
+	srv, err := NewServer(store, newTestConfig(t))
+	require.NoError(t, err)
+
+	org, err := store.CreateOrganization(ctx, "Acme", "owner@acme.test", "Owner")
+	require.NoError(t, err)
+	owner, err := store.GetUser(ctx, org.OwnerID)
+	require.NoError(t, err)
+	ownerToken, err := srv.tokenManager.GenerateToken(owner)
+	require.NoError(t, err)
+	_, err = store.CreateRefreshToken(ctx, owner.ID, DefaultSessionTerms)
+	require.NoError(t, err)
+
+	member, err := store.AddUserToOrganization(ctx, org.ID, "member@acme.test", "Member")
+	require.NoError(t, err)
+	memberToken, err := srv.tokenManager.GenerateToken(member)
+	require.NoError(t, err)
+
+	other, err := store.CreateOrganization(ctx, "Other", "owner@other.test", "Other Owner")
+	require.NoError(t, err)
+
+	graphql := func(token, query string, variables map[string]interface{}) (int, string) {
+		body, err := json.Marshal(GraphQLRequest{Query: query, Variables: variables})
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, "/graphql", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		addCSRFToken(t, srv, req)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return w.Code, w.Body.String()
+	}
+
+	t.Run("Nested data is fetched in one request with batched loads", func(t *testing.T) {
+		store.userBatches, store.orgBatches = 0, 0
+
+		code, body := graphql(ownerToken, `{
+			me {
+				email
+				sessions { expiresAt }
+				organization {
+					name
+					owner { name }
+					userCount
+					users { email role organization { name } }
+				}
+			}
+		}`, nil)
+		require.Equal(t, http.StatusOK, code, body)
+
+		var resp struct {
+			Data struct {
+				Me struct {
+					Email        string
+					Sessions     []map[string]string
+					Organization struct {
+						Name      string
+						Owner     struct{ Name string }
+						UserCount int
+						Users     []struct {
+							Email, Role  string
+							Organization struct{ Name string }
+						}
+					}
+				}
+			}
+			Errors []GraphQLError
+		}
+		require.NoError(t, json.Unmarshal([]byte(body), &resp))
+		require.Empty(t, resp.Errors)
+
+		me := resp.Data.Me
+		require.Equal(t, "owner@acme.test", me.Email)
+		require.Len(t, me.Sessions, 1)
+		require.Equal(t, "Acme", me.Organization.Name)
+		require.Equal(t, "Owner", me.Organization.Owner.Name)
+		require.Equal(t, 2, me.Organization.UserCount)
+		require.Len(t, me.Organization.Users, 2)
+		for _, u := range me.Organization.Users {
+			require.Equal(t, "Acme", u.Organization.Name)
+		}
+
+		// The members' organization was loaded for me.organization
+		require.Equal(t, 1, store.userBatches)
+		require.Equal(t, 1, store.orgBatches)
+	})
+
+	t.Run("Fields are returned in the order selected", func(t *testing.T) {
+		code, body := graphql(ownerToken, `query ($skip: Boolean!) {
+			me { ...Names role @skip(if: $skip) __typename }
+		}
+		fragment Names on User { whoami: email name }`, map[string]interface{}{"skip": true})
+		require.Equal(t, http.StatusOK, code, body)
+		require.Equal(t, `{"data":{"me":{"whoami":"owner@acme.test","name":"Owner","__typename":"User"}}}`+"\n", body)
+	})
+
+	t.Run("Permissions match the REST routes", func(t *testing.T) {
+		_, body := graphql(ownerToken, `query ($id: ID) { organization(id: $id) { name } }`,
+			map[string]interface{}{"id": other.ID.String()})
+		require.JSONEq(t, `{
+			"data": {"organization": null},
+			"errors": [{"message": "Forbidden", "path": ["organization"], "extensions": {"code": "FORBIDDEN"}}]
+		}`, body)
+
+		// Members may read their organization but not others' sessions
+		_, body = graphql(memberToken, `{ organization { owner { email sessions { id } } } }`, nil)
+		require.JSONEq(t, `{
+			"data": {"organization": {"owner": {"email": "owner@acme.test", "sessions": null}}},
+			"errors": [{"message": "Forbidden", "path": ["organization", "owner", "sessions"], "extensions": {"code": "FORBIDDEN"}}]
+		}`, body)
+
+		_, body = graphql(memberToken, `mutation ($org: ID!) { inviteUser(organizationId: $org, email: "x@acme.test", name: "X") { id } }`,
+			map[string]interface{}{"org": org.ID.String()})
+		require.Contains(t, body, `"inviteUser":null`)
+		require.Contains(t, body, `"code":"FORBIDDEN"`)
+	})
+
+	t.Run("Mutations manage members", func(t *testing.T) {
+		vars := map[string]interface{}{"org": org.ID.String()}
+		code, body := graphql(ownerToken, `mutation ($org: ID!) {
+			inviteUser(organizationId: $org, email: "new@acme.test", name: "New") { role organization { userCount } }
+		}`, vars)
+		require.Equal(t, http.StatusOK, code, body)
+		require.JSONEq(t, `{"data": {"inviteUser": {"role": "sub_account", "organization": {"userCount": 3}}}}`, body)
+
+		invited, err := store.GetUserByEmail(ctx, "new@acme.test")
+		require.NoError(t, err)
+		vars["user"] = invited.ID.String()
+
+		_, body = graphql(ownerToken, `mutation ($org: ID!, $user: ID!) {
+			promoted: updateUserRole(organizationId: $org, userId: $user, role: "admin") { role }
+			revoked: revokeUserSessions(organizationId: $org, userId: $user)
+		}`, vars)
+		require.JSONEq(t, `{"data": {"promoted": {"role": "admin"}, "revoked": true}}`, body)
+
+		_, body = graphql(ownerToken, `mutation ($org: ID!) { removeUser(organizationId: $org, userId: "`+owner.ID.String()+`") }`, vars)
+		require.Contains(t, body, `"code":"CONFLICT"`)
+
+		_, body = graphql(ownerToken, `mutation ($org: ID!, $user: ID!) { removeUser(organizationId: $org, userId: $user) }`, vars)
+		require.JSONEq(t, `{"data": {"removeUser": true}}`, body)
+
+		_, body = graphql(ownerToken, `mutation ($org: ID!) { removeUser(organizationId: $org, userId: "`+other.OwnerID.String()+`") }`, vars)
+		require.Contains(t, body, `"code":"NOT_FOUND"`)
+	})
+
+	t.Run("Invalid requests are rejected before running", func(t *testing.T) {
+		for query, message := range map[string]string{
+			`{ me { password } }`:                                  `Cannot query field \"password\" on type \"User\".`,
+			`{ me }`:                                               `Field \"me\" of type \"User!\" must have a selection of subfields.`,
+			`{ me { email { x } } }`:                               `Field \"email\" must not have a selection since type \"String!\" has no subfields.`,
+			`{ organization(id: true) { name } }`:                  `Argument \"id\" has invalid value true.`,
+			`{ organization(id: $id) { name } }`:                   `Variable \"$id\" is not defined.`,
+			`mutation { removeUser(organizationId: "x") }`:         `Field \"removeUser\" argument \"userId\" of type \"ID!\" is required but not provided.`,
+			`query ($id: ID!) { organization(id: $id) { name } }`:  `Variable \"id\" has invalid value null.\nExpected type \"ID!\", found null.`,
+			`{ me { ...Missing } }`:                                `Unknown fragment \"Missing\".`,
+			`{ me { ...Loop } } fragment Loop on User { ...Loop }`: `Cannot spread fragment \"Loop\" within itself.`,
+		} {
+			code, body := graphql(ownerToken, query, nil)
+			require.Equal(t, http.StatusBadRequest, code, query)
+			require.Contains(t, body, message, query)
+			require.NotContains(t, body, `"data"`, query)
+		}
+
+		deep := "name"
+		for range 6 {
+			deep = "organization { owner { " + deep + " } }"
+		}
+		code, body := graphql(ownerToken, "{ me { "+deep+" } }", nil)
+		require.Equal(t, http.StatusBadRequest, code)
+		require.Contains(t, body, "exceeds max depth")
+	})
+
+	t.Run("GET runs queries only", func(t *testing.T) {
+		get := func(query string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodGet, "/graphql?"+url.Values{"query": {query}}.Encode(), nil)
+			req.Header.Set("Authorization", "Bearer "+ownerToken)
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, req)
+			return w
+		}
+
+		w := get(`{ me { name } }`)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.JSONEq(t, `{"data": {"me": {"name": "Owner"}}}`, w.Body.String())
+
+		w = get(`mutation { removeUser(organizationId: "x", userId: "y") }`)
+		require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	})
+
+	t.Run("Requires authentication", func(t *testing.T) {
+		code, _ := graphql("", `{ me { name } }`, nil)
+		require.Equal(t, http.StatusUnauthorized, code)
+	})
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Guest tokens give share-link style access to an organization without an
+// account: an access token with no user behind it, holding only the
+// permissions it was minted with and, when restricted, reaching only some
+// of the organization's paths. They are short-lived, and revoking one adds
+// its ID to the token denylist until it would have expired.
+
+// RoleGuest is the role of the stand-in user a guest token authenticates
+// as. It confers no permissions of its own.
+const RoleGuest = "guest"
+
+const (
+	// DefaultGuestTokenTTL is how long a guest token lasts unless asked
+	DefaultGuestTokenTTL = time.Hour
+	// MaxGuestTokenTTL is the longest a guest token can last, and so how
+	// long a revoked one is kept on the denylist
+	MaxGuestTokenTTL = 24 * time.Hour
+	// MaxGuestTokenResources is how many paths a guest token can be
+	// restricted to
+	MaxGuestTokenResources = 20
+)
+
+// GuestTokenRequest mints a token holding Permissions for TTL. Resources
+// are paths under the organization, such as "webhooks/<id>", each reaching
+// the path and those beneath it; without any the token reaches all of them.
+type GuestTokenRequest struct {
+	Permissions []Permission `json:"permissions"`
+	Resources   []string     `json:"resources,omitempty"`
+	TTL         Duration     `json:"ttl,omitempty"`
+}
+
+// GuestToken is a minted guest token. The token itself is only ever shown
+// here; ID is what revokes it.
+type GuestToken struct {
+	ID          uuid.UUID    `json:"id"`
+	AccessToken string       `json:"access_token"`
+	Permissions []Permission `json:"permissions"`
+	Resources   []string     `json:"resources,omitempty"`
+	ExpiresAt   time.Time    `json:"expires_at"`
+}
+
+// ValidateGuestTokenRequest checks a guest token request, defaulting its
+// TTL. Like an access grant, a guest token can hold any permission an
+// admin holds.
+func ValidateGuestTokenRequest(req *GuestTokenRequest) error {
+	var errs []error
+
+	grantable := RolePermissions["admin"]
+	if len(req.Permissions) == 0 {
+		errs = append(errs, &ValidationError{Field: "permissions", Message: ErrEmptyField.Error()})
+	}
+	for _, perm := range req.Permissions {
+		if !slices.Contains(grantable, perm) {
+			errs = append(errs, &ValidationError{Field: "permissions",
+				Message: fmt.Sprintf("%q cannot be granted; grantable permissions are %s", perm, joinPermissions(grantable))})
+		}
+	}
+	req.Permissions = slices.Compact(slices.Sorted(slices.Values(req.Permissions)))
+
+	if len(req.Resources) > MaxGuestTokenResources {
+		errs = append(errs, &ValidationError{Field: "resources", Message: fmt.Sprintf("at most %d resources are allowed", MaxGuestTokenResources)})
+	}
+	for _, resource := range req.Resources {
+		if !validGuestResource(resource) {
+			errs = append(errs, &ValidationError{Field: "resources",
+				Message: fmt.Sprintf("%q is not a path under the organization, such as \"webhooks/<id>\"", resource)})
+		}
+	}
+
+	if req.TTL == 0 {
+		req.TTL = Duration(DefaultGuestTokenTTL)
+	}
+	if d := time.Duration(req.TTL); d <= 0 || d > MaxGuestTokenTTL {
+		errs = append(errs, &ValidationError{Field: "ttl",
+			Message: fmt.Sprintf("must be positive and at most %s", MaxGuestTokenTTL)})
+	}
+	return joinValidationErrors(errs...)
+}
+
+// validGuestResource reports whether resource is a relative path of plain
+// segments
+func validGuestResource(resource string) bool {
+	if resource == "" || len(resource) > 255 {
+		return false
+	}
+	for _, segment := range strings.Split(resource, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return false
+		}
+		for _, c := range segment {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// guest returns the stand-in user of a guest token, holding only the
+// token's permissions, or ErrInvalidToken once the token is revoked
+func (am *AuthMiddleware) guest(ctx context.Context, claims *Claims) (*User, error) {
+	id, err := uuid.Parse(claims.ID)
+	if err != nil || claims.OrganizationID == uuid.Nil {
+		return nil, ErrInvalidToken
+	}
+	denied, err := am.store.IsTokenDenied(ctx, claims.OrganizationID, id)
+	if err != nil {
+		return nil, err
+	}
+	if denied {
+		return nil, ErrInvalidToken
+	}
+
+	perms := make(Permissions, len(claims.Permissions))
+	for _, perm := range claims.Permissions {
+		perms[string(perm)] = true
+	}
+	return &User{
+		ID:             id,
+		Name:           "Guest",
+		OrganizationID: claims.OrganizationID,
+		Role:           RoleGuest,
+		Permissions:    perms,
+	}, nil
+}
+
+// guestMayReach reports whether a guest token may be used for r: only on
+// its own organization's routes, and only on its resources when it is
+// restricted to some
+func guestMayReach(claims *Claims, r *http.Request) bool {
+	if pathOrgID(r) != claims.OrganizationID {
+		return false
+	}
+	if len(claims.Resources) == 0 {
+		return true
+	}
+	rest := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/organizations/"+r.PathValue("orgID")), "/")
+	for _, resource := range claims.Resources {
+		if rest == resource || strings.HasPrefix(rest, resource+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// handleCreateGuestToken mints a guest token for the organization. Callers
+// can only pass on permissions they hold, and guests cannot mint tokens.
+func (s *Server) handleCreateGuestToken(w http.ResponseWriter, r *http.Request) {
+	orgID := pathOrgID(r)
+	caller, _ := GetUserFromContext(r.Context())
+	if caller.Role == RoleGuest {
+		http.Error(w, "Guests cannot mint guest tokens", http.StatusForbidden)
+		return
+	}
+
+	var req GuestTokenRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if err := ValidateGuestTokenRequest(&req); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	for _, perm := range req.Permissions {
+		if !caller.HasPermission(perm) {
+			writeValidationError(w, &ValidationError{Field: "permissions", Message: fmt.Sprintf("%q is not yours to grant", perm)})
+			return
+		}
+	}
+
+	ttl := time.Duration(req.TTL)
+	guest := GuestToken{
+		ID:          uuid.New(),
+		Permissions: req.Permissions,
+		Resources:   req.Resources,
+		ExpiresAt:   time.Now().UTC().Add(ttl).Truncate(time.Second),
+	}
+	token, err := s.tokenManager.GenerateGuestToken(orgID, guest.ID, guest.Permissions, guest.Resources, ttl)
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to mint guest token", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	guest.AccessToken = token
+
+	s.recordAudit(r, "guest_token.created", orgID, guest.ID.String(), AuditMetadata{
+		"permissions": joinPermissions(guest.Permissions),
+		"resources":   strings.Join(guest.Resources, ", "),
+		"expires_at":  guest.ExpiresAt.Format(time.RFC3339),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(guest)
+}
+
+// handleRevokeGuestToken denies a guest token from now on. Tokens are not
+// stored, so any ID is accepted; it is kept on the denylist as long as a
+// token minted now would last.
+func (s *Server) handleRevokeGuestToken(w http.ResponseWriter, r *http.Request) {
+	orgID := pathOrgID(r)
+	caller, _ := GetUserFromContext(r.Context())
+	if caller.Role == RoleGuest {
+		http.Error(w, "Guests cannot revoke guest tokens", http.StatusForbidden)
+		return
+	}
+	tokenID, err := uuid.Parse(r.PathValue("tokenID"))
+	if err != nil {
+		http.Error(w, "Invalid guest token ID format", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.store.DenyToken(r.Context(), orgID, tokenID, time.Now().Add(MaxGuestTokenTTL)); err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to revoke guest token", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	s.recordAudit(r, "guest_token.revoked", orgID, tokenID.String(), nil)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGuestTokens(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	srv, err := NewServer(store, newTestConfig(t))
+	require.NoError(t, err)
+
+	acme, err := store.CreateOrganization(ctx, "Acme", "owner@acme.test", "Owner")
+	require.NoError(t, err)
+	initech, err := store.CreateOrganization(ctx, "Initech", "owner@initech.test", "Owner")
+	require.NoError(t, err)
+	hook := &Webhook{ID: uuid.New(), OrganizationID: acme.ID, URL: "https://hooks.acme.test", Events: WebhookEventSet{WebhookUserCreated}, Secret: "whsec_1", Enabled: true}
+	require.NoError(t, store.CreateWebhook(ctx, hook))
+
+	owner, err := store.GetUser(ctx, acme.OwnerID)
+	require.NoError(t, err)
+	ownerToken, err := srv.tokenManager.GenerateToken(owner)
+	require.NoError(t, err)
+
+	acmePath := "/organizations/" + acme.ID.String()
+	call := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		if method != http.MethodGet {
+			addCSRFToken(t, srv, req)
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return w
+	}
+	mint := func(body string) GuestToken {
+		w := call(http.MethodPost, acmePath+"/guest-tokens", ownerToken, body)
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		var guest GuestToken
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&guest))
+		return guest
+	}
+
+	guest := mint(`{"permissions":["manage:settings"],"resources":["webhooks/` + hook.ID.String() + `"],"ttl":"30m"}`)
+	require.Equal(t, []Permission{PermManageSettings}, guest.Permissions)
+
+	t.Run("Guests reach only their resources", func(t *testing.T) {
+		w := call(http.MethodGet, acmePath+"/webhooks/"+hook.ID.String(), guest.AccessToken, "")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		w = call(http.MethodGet, acmePath+"/webhooks/"+hook.ID.String()+"/deliveries", guest.AccessToken, "")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		for _, path := range []string{acmePath + "/webhooks", acmePath, "/organizations/" + initech.ID.String() + "/webhooks/" + hook.ID.String(), "/me"} {
+			w := call(http.MethodGet, path, guest.AccessToken, "")
+			require.Equal(t, http.StatusForbidden, w.Code, path)
+		}
+	})
+
+	t.Run("Guests hold only their permissions", func(t *testing.T) {
+		reader := mint(`{"permissions":["read:org"]}`)
+		w := call(http.MethodGet, acmePath+"/users", reader.AccessToken, "")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		w = call(http.MethodGet, acmePath+"/webhooks", reader.AccessToken, "")
+		require.Equal(t, http.StatusForbidden, w.Code)
+
+		unrestricted := mint(`{"permissions":["manage:settings"]}`)
+		w = call(http.MethodPost, acmePath+"/guest-tokens", unrestricted.AccessToken, `{"permissions":["manage:settings"]}`)
+		require.Equal(t, http.StatusForbidden, w.Code, "guests cannot mint tokens")
+	})
+
+	t.Run("Requests are validated", func(t *testing.T) {
+		for _, body := range []string{
+			`{"permissions":[]}`,
+			`{"permissions":["delete:org"]}`,
+			`{"permissions":["read:org"],"resources":["../users"]}`,
+			`{"permissions":["read:org"],"ttl":"48h"}`,
+		} {
+			w := call(http.MethodPost, acmePath+"/guest-tokens", ownerToken, body)
+			require.Equal(t, http.StatusBadRequest, w.Code, body)
+		}
+	})
+
+	t.Run("Revoked tokens are refused", func(t *testing.T) {
+		w := call(http.MethodDelete, acmePath+"/guest-tokens/"+guest.ID.String(), ownerToken, "")
+		require.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+
+		w = call(http.MethodGet, acmePath+"/webhooks/"+hook.ID.String(), guest.AccessToken, "")
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+}
+
+func TestTokenDenylist(t *testing.T) {
+	testdb := setupTestDB(t)
+	defer testdb.teardown(t)
+
+	ctx := context.Background()
+	acme, err := testdb.DB.CreateOrganization(ctx, "Denylist Org", "owner@denylist.test", "Owner")
+	require.NoError(t, err)
+	initech, err := testdb.DB.CreateOrganization(ctx, "Other Org", "owner@other.test", "Owner")
+	require.NoError(t, err)
+
+	tokenID := uuid.New()
+	denied, err := testdb.DB.IsTokenDenied(ctx, acme.ID, tokenID)
+	require.NoError(t, err)
+	require.False(t, denied)
+
+	require.NoError(t, testdb.DB.DenyToken(ctx, acme.ID, tokenID, time.Now().Add(time.Hour)))
+	require.NoError(t, testdb.DB.DenyToken(ctx, acme.ID, tokenID, time.Now().Add(time.Minute)), "denying again is harmless")
+	denied, err = testdb.DB.IsTokenDenied(ctx, acme.ID, tokenID)
+	require.NoError(t, err)
+	require.True(t, denied)
+
+	denied, err = testdb.DB.IsTokenDenied(ctx, initech.ID, tokenID)
+	require.NoError(t, err)
+	require.False(t, denied, "entries are per organization")
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/google/uuid"
@@ -14,10 +15,8 @@ import (
 )
 
 func TestOrganizationHandlers(t *testing.T) {
-	testdb := setupTestDB(t)
-	defer testdb.teardown(t)
-
-	srv, err := NewServer(testdb.DB)
+	store := NewMemoryStore()
+	srv, err := NewServer(store, newTestConfig(t))
 	require.NoError(t, err)
 
 	// Create initial test user and organization
@@ -40,18 +39,7 @@ func TestOrganizationHandlers(t *testing.T) {
 	// Set the organization ID for the user
 	testUser.OrganizationID = testOrg.ID
 
-	// Insert organization first
-	_, err = testdb.DB.ExecContext(context.Background(), `
-		INSERT INTO organizations (id, name, owner_id, subscription_tier, max_sub_accounts)
-		VALUES ($1, $2, $3, $4, $5)
-	`, testOrg.ID, testOrg.Name, testOrg.OwnerID, testOrg.SubscriptionTier, testOrg.MaxSubAccounts)
-	require.NoError(t, err)
-
-	// Then insert the user
-	_, err = testdb.DB.ExecContext(context.Background(), `
-		INSERT INTO users (id, email, name, organization_id, role, permissions)
-		VALUES ($1, $2, $3, $4, $5, $6)
-	`, testUser.ID, testUser.Email, testUser.Name, testUser.OrganizationID, testUser.Role, testUser.Permissions)
+	err = store.CreateOrganizationWithOwner(context.Background(), testOrg, testUser)
 	require.NoError(t, err)
 
 	// Generate token for the test user
@@ -71,6 +59,7 @@ func TestOrganizationHandlers(t *testing.T) {
 		req := httptest.NewRequest(http.MethodPost, "/organizations", bytes.NewReader(body))
 		req.Header.Set("Content-Type", "application/json")
 		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+		addCSRFToken(t, srv, req)
 		w := httptest.NewRecorder()
 
 		srv.ServeHTTP(w, req)
@@ -96,6 +85,7 @@ func TestOrganizationHandlers(t *testing.T) {
 		req := httptest.NewRequest(http.MethodPost, "/organizations", bytes.NewReader(body))
 		req.Header.Set("Content-Type", "application/json")
 		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+		addCSRFToken(t, srv, req)
 		w := httptest.NewRecorder()
 
 		srv.ServeHTTP(w, req)
@@ -119,6 +109,7 @@ func TestOrganizationHandlers(t *testing.T) {
 		)
 		req.Header.Set("Content-Type", "application/json")
 		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+		addCSRFToken(t, srv, req)
 		w := httptest.NewRecorder()
 
 		srv.ServeHTTP(w, req)
@@ -135,7 +126,7 @@ func TestOrganizationHandlers(t *testing.T) {
 
 	t.Run("Get Organization Users", func(t *testing.T) {
 		// Add a sub-account to the test organization
-		_, err = testdb.DB.AddUserToOrganization(
+		_, err = store.AddUserToOrganization(
 			context.Background(),
 			testOrg.ID,
 			"sub@example.com",
@@ -174,4 +165,218 @@ func TestOrganizationHandlers(t *testing.T) {
 		require.True(t, hasOwner, "Organization should have an owner")
 		require.True(t, hasSubAccount, "Organization should have a sub-account")
 	})
+
+	t.Run("Update Organization Settings", func(t *testing.T) {
+		settingsPath := fmt.Sprintf("/organizations/%s/settings", testOrg.ID)
+		put := func(ifMatch string) *httptest.ResponseRecorder {
+			body, err := json.Marshal(OrganizationSettings{AllowedOrigins: []string{"https://app.example.com"}})
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodPut, settingsPath, bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+			if ifMatch != "" {
+				req.Header.Set("If-Match", ifMatch)
+			}
+			addCSRFToken(t, srv, req)
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, req)
+			return w
+		}
+
+		req := httptest.NewRequest(http.MethodGet, settingsPath, nil)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		etag := w.Header().Get("ETag")
+		require.Equal(t, versionETag(1), etag)
+
+		require.Equal(t, http.StatusPreconditionRequired, put("").Code)
+
+		w = put(etag)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, versionETag(2), w.Header().Get("ETag"))
+
+		// The same stale tag cannot overwrite the change it missed
+		require.Equal(t, http.StatusConflict, put(etag).Code)
+	})
+
+	t.Run("Organization Details", func(t *testing.T) {
+		detailsPath := fmt.Sprintf("/organizations/%s/details", testOrg.ID)
+		get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodGet, detailsPath, nil)
+			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+			if ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", ifNoneMatch)
+			}
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, req)
+			return w
+		}
+		patch := func(ifMatch, name string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodPatch, detailsPath, strings.NewReader(`{"name":"`+name+`"}`))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+			req.Header.Set("If-Match", ifMatch)
+			addCSRFToken(t, srv, req)
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, req)
+			return w
+		}
+
+		w := get("")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "private, max-age=30", w.Header().Get("Cache-Control"))
+		etag := w.Header().Get("ETag")
+		var org Organization
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&org))
+		require.Equal(t, versionETag(org.Version), etag)
+
+		w = get(etag)
+		require.Equal(t, http.StatusNotModified, w.Code)
+		require.Empty(t, w.Body.String())
+
+		require.Equal(t, http.StatusBadRequest, patch(etag, "").Code)
+		w = patch(etag, "Renamed Org")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, versionETag(org.Version+1), w.Header().Get("ETag"))
+		require.Equal(t, http.StatusConflict, patch(etag, "Stale Name").Code)
+
+		// The rename bumped the version, so the cached copy is stale
+		w = get(etag)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&org))
+		require.Equal(t, "Renamed Org", org.Name)
+	})
+}
+
+func TestMemberHandlers(t *testing.T) {
+	ctx := context.Background()
+
+	store := NewMemoryStore()
+	srv, err := NewServer(store, newTestConfig(t))
+	require.NoError(t, err)
+
+	org, err := store.CreateOrganization(ctx, "Acme", "owner@acme.test", "Owner")
+	require.NoError(t, err)
+	org, err = store.UpdateOrganizationTier(ctx, org.ID, org.Version, "free", 1, "")
+	require.NoError(t, err)
+	owner, err := store.GetUser(ctx, org.OwnerID)
+	require.NoError(t, err)
+	token, err := srv.tokenManager.GenerateToken(owner)
+	require.NoError(t, err)
+	member, err := store.AddUserToOrganization(ctx, org.ID, "member@acme.test", "Member")
+	require.NoError(t, err)
+
+	do := func(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+		var data []byte
+		if body != nil {
+			data, err = json.Marshal(body)
+			require.NoError(t, err)
+		}
+		req := httptest.NewRequest(method, path, bytes.NewReader(data))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		if method != http.MethodGet {
+			addCSRFToken(t, srv, req)
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return w
+	}
+	orgPath := "/organizations/" + org.ID.String()
+	memberPath := orgPath + "/users/" + member.ID.String()
+
+	t.Run("Get the current user", func(t *testing.T) {
+		w := do(http.MethodGet, "/me", token, nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		var me User
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&me))
+		require.Equal(t, owner.ID, me.ID)
+
+		require.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/me", "", nil).Code)
+	})
+
+	t.Run("Get the organization", func(t *testing.T) {
+		w := do(http.MethodGet, orgPath+"/details", token, nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		var got Organization
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&got))
+		require.Equal(t, org.ID, got.ID)
+		require.Equal(t, "Acme", got.Name)
+	})
+
+	t.Run("List members a page at a time", func(t *testing.T) {
+		w := do(http.MethodGet, orgPath+"/users?limit=1&offset=1", token, nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "2", w.Header().Get(TotalCountHeader))
+		var users []User
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&users))
+		require.Len(t, users, 1)
+		require.Equal(t, "owner@acme.test", users[0].Email)
+
+		w = do(http.MethodGet, orgPath+"/users?offset=5", token, nil)
+		require.JSONEq(t, `[]`, w.Body.String())
+	})
+
+	t.Run("Change a member's role", func(t *testing.T) {
+		w := do(http.MethodPut, memberPath+"/role", token, UpdateUserRoleRequest{Role: "admin"})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var updated User
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&updated))
+		require.Equal(t, "admin", updated.Role)
+
+		// Promoting the member freed their seat
+		usage, err := store.GetSeatUsage(ctx, org.ID)
+		require.NoError(t, err)
+		require.Equal(t, 0, usage.SeatsUsed)
+
+		_, err = store.AddUserToOrganization(ctx, org.ID, "second@acme.test", "Second")
+		require.NoError(t, err)
+		w = do(http.MethodPut, memberPath+"/role", token, UpdateUserRoleRequest{Role: "sub_account"})
+		require.Equal(t, http.StatusForbidden, w.Code)
+
+		w = do(http.MethodPut, memberPath+"/role", token, UpdateUserRoleRequest{Role: "owner"})
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		w = do(http.MethodPut, orgPath+"/users/"+owner.ID.String()+"/role", token, UpdateUserRoleRequest{Role: "admin"})
+		require.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	t.Run("Revoke a member's sessions", func(t *testing.T) {
+		refresh, err := store.CreateRefreshToken(ctx, member.ID, DefaultSessionTerms)
+		require.NoError(t, err)
+
+		require.Equal(t, http.StatusNoContent, do(http.MethodDelete, memberPath+"/sessions", token, nil).Code)
+		_, err = store.ValidateRefreshToken(ctx, refresh)
+		require.Error(t, err)
+	})
+
+	t.Run("Sign out", func(t *testing.T) {
+		first, err := store.CreateRefreshToken(ctx, owner.ID, DefaultSessionTerms)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusNoContent, do(http.MethodPost, "/auth/logout", "", RefreshTokenRequest{RefreshToken: first}).Code)
+		_, err = store.ValidateRefreshToken(ctx, first)
+		require.Error(t, err)
+
+		second, err := store.CreateRefreshToken(ctx, owner.ID, DefaultSessionTerms)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/me/sessions", token, nil).Code)
+		_, err = store.ValidateRefreshToken(ctx, second)
+		require.Error(t, err)
+	})
+
+	t.Run("Remove a member", func(t *testing.T) {
+		other, err := store.CreateOrganization(ctx, "Other", "owner@other.test", "Other")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusNotFound,
+			do(http.MethodDelete, orgPath+"/users/"+other.OwnerID.String(), token, nil).Code)
+		require.Equal(t, http.StatusConflict,
+			do(http.MethodDelete, orgPath+"/users/"+owner.ID.String(), token, nil).Code)
+
+		require.Equal(t, http.StatusNoContent, do(http.MethodDelete, memberPath, token, nil).Code)
+		require.Equal(t, http.StatusNotFound, do(http.MethodDelete, memberPath, token, nil).Code)
+	})
 }
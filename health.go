@@ -4,9 +4,13 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"net/netip"
 	"runtime"
 	"sync"
 	"time"
+
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/sync/singleflight"
 )
 
 type HealthStatus string
@@ -20,63 +24,198 @@ const (
 type HealthCheck struct {
 	Name     string            `json:"name"`
 	Status   HealthStatus      `json:"status"`
+	Critical bool              `json:"critical"`
 	Error    string            `json:"error,omitempty"`
 	Details  map[string]string `json:"details,omitempty"`
 	Duration time.Duration     `json:"duration"`
 }
 
 type HealthResponse struct {
-	Status    HealthStatus  `json:"status"`
-	Version   string        `json:"version"`
+	Status HealthStatus `json:"status"`
+	BuildInfo
 	Checks    []HealthCheck `json:"checks"`
 	StartTime time.Time     `json:"start_time"`
 	CheckTime time.Time     `json:"check_time"`
+	CacheAge  time.Duration `json:"cache_age"` // how long ago the checks ran, if the result was cached
+}
+
+// HealthSummary is all of a health response that callers outside the
+// internal networks see unless they are platform admins. The checks behind
+// it name pool sizes, memory use and schema versions.
+type HealthSummary struct {
+	Status HealthStatus `json:"status"`
+}
+
+// CheckLevel says how much a failing check matters to the service as a whole
+type CheckLevel int
+
+const (
+	// Critical checks make the service unhealthy, and not ready, when they fail
+	Critical CheckLevel = iota
+	// NonCritical checks only degrade the service when they fail, for
+	// dependencies the service can limp along without
+	NonCritical
+)
+
+// registeredCheck is a check along with how it is run
+type registeredCheck struct {
+	name    string
+	check   func(ctx context.Context) HealthCheck
+	level   CheckLevel
+	timeout time.Duration // 0 leaves only the request's deadline
 }
 
 type HealthChecker struct {
-	version   string
+	build     BuildInfo
 	startTime time.Time
 	db        *DB
 	logger    *slog.Logger
+
+	mu     sync.RWMutex
+	checks []registeredCheck // contributed by other subsystems
+
+	// internal are the networks shown the checks; see Server.internalCaller
+	internal []netip.Prefix
+
+	// Probe results are reused for cacheTTL, and probes arriving while the
+	// checks run wait for that run rather than starting their own
+	cacheTTL time.Duration
+	inflight singleflight.Group
+	cacheMu  sync.Mutex
+	cache    map[string]*HealthResponse
 }
 
-func NewHealthChecker(version string, db *DB, logger *slog.Logger) *HealthChecker {
+func NewHealthChecker(build BuildInfo, db *DB, logger *slog.Logger) *HealthChecker {
 	return &HealthChecker{
-		version:   version,
+		build:     build,
 		startTime: time.Now(),
 		db:        db,
 		logger:    logger,
+		cache:     make(map[string]*HealthResponse),
 	}
 }
 
+// Cached returns the result of check cached under key, running it only if
+// the cached result is older than the cache TTL. Concurrent callers share a
+// single run, which is given timeout whether or not the caller that started
+// it gives up first.
+func (h *HealthChecker) Cached(ctx context.Context, key string, timeout time.Duration, check func(context.Context) *HealthResponse) *HealthResponse {
+	if response := h.cached(key); response != nil {
+		return response
+	}
+
+	result, _, _ := h.inflight.Do(key, func() (interface{}, error) {
+		if response := h.cached(key); response != nil {
+			return response, nil
+		}
+
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+		defer cancel()
+		response := check(ctx)
+
+		if h.cacheTTL > 0 {
+			h.cacheMu.Lock()
+			h.cache[key] = response
+			h.cacheMu.Unlock()
+		}
+		return response, nil
+	})
+
+	response := *result.(*HealthResponse)
+	return &response
+}
+
+// cached returns a copy of the fresh result cached under key, if there is one
+func (h *HealthChecker) cached(key string) *HealthResponse {
+	h.cacheMu.Lock()
+	defer h.cacheMu.Unlock()
+
+	cached, ok := h.cache[key]
+	if !ok {
+		return nil
+	}
+	age := time.Since(cached.CheckTime)
+	if age >= h.cacheTTL {
+		return nil
+	}
+	response := *cached
+	response.CacheAge = age
+	return &response
+}
+
+// RegisterCheck adds a check of a dependency to /health and /readyz. A check
+// that has not finished within timeout fails; a zero timeout leaves it the
+// whole request. Whatever name check reports, its result is listed under name.
+func (h *HealthChecker) RegisterCheck(name string, check func(ctx context.Context) HealthCheck, level CheckLevel, timeout time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks = append(h.checks, registeredCheck{name: name, check: check, level: level, timeout: timeout})
+}
+
+// registered returns the checks other subsystems registered after builtin
+func (h *HealthChecker) registered(builtin ...registeredCheck) []registeredCheck {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return append(builtin, h.checks...)
+}
+
+// CheckHealth runs every check, for operators and load balancers that want
+// the full picture
 func (h *HealthChecker) CheckHealth(ctx context.Context) *HealthResponse {
+	builtin := []registeredCheck{
+		{name: "memory", check: func(context.Context) HealthCheck { return h.checkMemory() }, level: NonCritical},
+	}
+	// A server on the in-memory store has no database to check
+	if h.db != nil {
+		builtin = append(builtin,
+			registeredCheck{name: "database", check: h.checkDatabase},
+			registeredCheck{name: "migrations", check: h.checkMigrations},
+		)
+	}
+	return h.run(ctx, h.registered(builtin...))
+}
+
+// CheckLiveness reports whether the process is running and able to serve
+// requests. It checks no dependencies, so an outage elsewhere does not get
+// the process restarted.
+func (h *HealthChecker) CheckLiveness(ctx context.Context) *HealthResponse {
+	return h.run(ctx, nil)
+}
+
+// CheckReadiness reports whether the server can take traffic: its
+// dependencies are reachable and the schema is at the version the binary
+// expects, so instances are not sent requests while migrations are pending
+func (h *HealthChecker) CheckReadiness(ctx context.Context) *HealthResponse {
+	var builtin []registeredCheck
+	if h.db != nil {
+		builtin = append(builtin,
+			registeredCheck{name: "database", check: h.checkDatabase},
+			registeredCheck{name: "migrations", check: h.checkMigrationsApplied},
+		)
+	}
+	return h.run(ctx, h.registered(builtin...))
+}
+
+// run runs checks in parallel and combines their results, the worst status
+// winning. A failing non-critical check only degrades the service.
+func (h *HealthChecker) run(ctx context.Context, checks []registeredCheck) *HealthResponse {
 	response := &HealthResponse{
 		Status:    StatusHealthy,
-		Version:   h.version,
+		BuildInfo: h.build,
+		Checks:    make([]HealthCheck, 0, len(checks)),
 		StartTime: h.startTime,
 		CheckTime: time.Now(),
 	}
 
 	var wg sync.WaitGroup
-	checks := make([]HealthCheck, 0)
-	checksChan := make(chan HealthCheck, 3) // Buffer for all checks
-
-	// Run all checks in parallel
-	wg.Add(3)
-	go func() {
-		defer wg.Done()
-		checksChan <- h.checkDatabase(ctx)
-	}()
-
-	go func() {
-		defer wg.Done()
-		checksChan <- h.checkMigrations(ctx)
-	}()
-
-	go func() {
-		defer wg.Done()
-		checksChan <- h.checkMemory()
-	}()
+	checksChan := make(chan HealthCheck, len(checks)) // Buffer for all checks
+	for _, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			checksChan <- check.run(ctx)
+		}()
+	}
 
 	// Wait for all checks in a separate goroutine
 	done := make(chan struct{})
@@ -90,29 +229,62 @@ func (h *HealthChecker) CheckHealth(ctx context.Context) *HealthResponse {
 	select {
 	case <-ctx.Done():
 		check := HealthCheck{
-			Name:    "system",
-			Status:  StatusUnhealthy,
-			Error:   "health check timeout",
-			Details: map[string]string{"error": ctx.Err().Error()},
+			Name:     "system",
+			Status:   StatusUnhealthy,
+			Critical: true,
+			Error:    "health check timeout",
+			Details:  map[string]string{"error": ctx.Err().Error()},
 		}
-		checks = append(checks, check)
+		response.Checks = append(response.Checks, check)
 		response.Status = StatusUnhealthy
 	case <-done:
 		// Collect all results
 		for check := range checksChan {
-			checks = append(checks, check)
-			if check.Status == StatusUnhealthy {
+			response.Checks = append(response.Checks, check)
+			status := check.Status
+			if status == StatusUnhealthy && !check.Critical {
+				status = StatusDegraded
+			}
+			if status == StatusUnhealthy {
 				response.Status = StatusUnhealthy
-			} else if check.Status == StatusDegraded && response.Status != StatusUnhealthy {
+			} else if status == StatusDegraded && response.Status != StatusUnhealthy {
 				response.Status = StatusDegraded
 			}
 		}
 	}
 
-	response.Checks = checks
 	return response
 }
 
+// run runs the check within its timeout
+func (c registeredCheck) run(ctx context.Context) HealthCheck {
+	start := time.Now()
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	result := make(chan HealthCheck, 1)
+	go func() {
+		result <- c.check(ctx)
+	}()
+
+	var check HealthCheck
+	select {
+	case check = <-result:
+	case <-ctx.Done():
+		check = HealthCheck{
+			Status:   StatusUnhealthy,
+			Error:    "check timed out",
+			Duration: time.Since(start),
+		}
+	}
+	check.Name = c.name
+	check.Critical = c.level == Critical
+	return check
+}
+
 func (h *HealthChecker) checkDatabase(ctx context.Context) HealthCheck {
 	start := time.Now()
 	check := HealthCheck{
@@ -153,6 +325,31 @@ func (h *HealthChecker) checkDatabase(ctx context.Context) HealthCheck {
 	return check
 }
 
+// RedisHealthCheck checks that client can reach Redis
+func RedisHealthCheck(client *redis.Client) func(ctx context.Context) HealthCheck {
+	return func(ctx context.Context) HealthCheck {
+		start := time.Now()
+		check := HealthCheck{
+			Name:    "redis",
+			Status:  StatusHealthy,
+			Details: make(map[string]string),
+		}
+
+		if err := client.Ping(ctx).Err(); err != nil {
+			check.Status = StatusUnhealthy
+			check.Error = fmt.Sprintf("redis ping failed: %v", err)
+			check.Duration = time.Since(start)
+			return check
+		}
+
+		stats := client.PoolStats()
+		check.Details["total_conns"] = fmt.Sprintf("%d", stats.TotalConns)
+		check.Details["idle_conns"] = fmt.Sprintf("%d", stats.IdleConns)
+		check.Duration = time.Since(start)
+		return check
+	}
+}
+
 func (h *HealthChecker) checkMigrations(ctx context.Context) HealthCheck {
 	start := time.Now()
 	check := HealthCheck{
@@ -176,10 +373,22 @@ func (h *HealthChecker) checkMigrations(ctx context.Context) HealthCheck {
 
 	check.Details["current_version"] = fmt.Sprintf("%d", version)
 	check.Details["is_applied"] = "true"
+	check.Details["expected_version"] = fmt.Sprintf("%d", latestMigrationVersion)
 	check.Duration = time.Since(start)
 	return check
 }
 
+// checkMigrationsApplied fails the migration check until the database has
+// every migration embedded in the binary
+func (h *HealthChecker) checkMigrationsApplied(ctx context.Context) HealthCheck {
+	check := h.checkMigrations(ctx)
+	if check.Status == StatusHealthy && check.Details["current_version"] != check.Details["expected_version"] {
+		check.Status = StatusUnhealthy
+		check.Error = "database migrations are pending"
+	}
+	return check
+}
+
 func (h *HealthChecker) checkMemory() HealthCheck {
 	start := time.Now()
 	check := HealthCheck{
@@ -5,6 +5,9 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -27,11 +30,14 @@ func TestHealthCheck(t *testing.T) {
 	`)
 	require.NoError(t, err)
 
-	srv, err := NewServer(testdb.DB)
+	// Every probe below should see the database as it is now
+	t.Setenv("HEALTH_CACHE_TTL", "0")
+	srv, err := NewServer(testdb.DB, newTestConfig(t))
 	require.NoError(t, err)
 
 	t.Run("Healthy System", func(t *testing.T) {
 		req := httptest.NewRequest(http.MethodGet, "/health", nil)
+		req.RemoteAddr = "127.0.0.1:1234"
 		w := httptest.NewRecorder()
 
 		srv.ServeHTTP(w, req)
@@ -77,6 +83,52 @@ func TestHealthCheck(t *testing.T) {
 		require.True(t, hasUnhealthyCheck)
 	})
 
+	t.Run("Readiness waits for migrations", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		// Roll the recorded schema back as if this binary had just been deployed
+		_, err := testdb.DB.ExecContext(context.Background(), `
+			DELETE FROM goose_db_version WHERE version_id = $1
+		`, latestMigrationVersion)
+		require.NoError(t, err)
+
+		w = httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+		req.RemoteAddr = "127.0.0.1:1234"
+		srv.ServeHTTP(w, req)
+		require.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+		var resp HealthResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+		for _, check := range resp.Checks {
+			if check.Name == "migrations" {
+				require.Equal(t, "database migrations are pending", check.Error)
+			}
+		}
+
+		_, err = testdb.DB.ExecContext(context.Background(), `
+			INSERT INTO goose_db_version (version_id, is_applied) VALUES ($1, true)
+		`, latestMigrationVersion)
+		require.NoError(t, err)
+
+		w = httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("Liveness ignores dependencies", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/livez", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var resp HealthResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+		require.Equal(t, StatusHealthy, resp.Status)
+		require.Empty(t, resp.Checks)
+	})
+
 	t.Run("Method Not Allowed", func(t *testing.T) {
 		req := httptest.NewRequest(http.MethodPost, "/health", nil)
 		w := httptest.NewRecorder()
@@ -86,3 +138,179 @@ func TestHealthCheck(t *testing.T) {
 		require.Equal(t, http.StatusMethodNotAllowed, w.Code)
 	})
 }
+
+func TestHealthCheckInMemory(t *testing.T) {
+	srv, err := NewServer(NewMemoryStore(), newTestConfig(t))
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/health", nil)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+
+	var response HealthResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	for _, check := range response.Checks {
+		require.NotEqual(t, "database", check.Name, "there is no database to check")
+		require.NotEqual(t, "migrations", check.Name)
+	}
+
+	for _, path := range []string{"/livez", "/readyz"} {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		require.Equal(t, http.StatusOK, w.Code, path)
+	}
+}
+
+func TestHealthDetails(t *testing.T) {
+	t.Setenv("HEALTH_INTERNAL_NETWORKS", "10.0.0.0/8")
+	ctx := context.Background()
+	store := NewMemoryStore()
+	srv, err := NewServer(store, newTestConfig(t))
+	require.NoError(t, err)
+
+	org, err := store.CreateOrganization(ctx, "Acme", "owner@acme.test", "Owner")
+	require.NoError(t, err)
+	owner, err := store.GetUser(ctx, org.OwnerID)
+	require.NoError(t, err)
+	ownerToken, err := srv.tokenManager.GenerateToken(owner)
+	require.NoError(t, err)
+	operators, err := store.CreateOrganization(ctx, "Operators", "admin@operators.test", "Admin")
+	require.NoError(t, err)
+	store.users[operators.OwnerID].Permissions[string(PermPlatformAdmin)] = true
+	admin, err := store.GetUser(ctx, operators.OwnerID)
+	require.NoError(t, err)
+	adminToken, err := srv.tokenManager.GenerateToken(admin)
+	require.NoError(t, err)
+
+	health := func(remoteAddr, token string) map[string]any {
+		req := httptest.NewRequest(http.MethodGet, "/health", nil)
+		req.RemoteAddr = remoteAddr
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		var body map[string]any
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+		return body
+	}
+
+	require.Equal(t, map[string]any{"status": "healthy"}, health("203.0.113.7:1234", ""), "anonymous callers see only the status")
+	require.Equal(t, map[string]any{"status": "healthy"}, health("203.0.113.7:1234", "not-a-token"))
+	require.Equal(t, map[string]any{"status": "healthy"}, health("203.0.113.7:1234", ownerToken), "members are not admins")
+	require.Contains(t, health("203.0.113.7:1234", adminToken), "checks")
+	require.Contains(t, health("10.1.2.3:1234", ""), "checks")
+	require.Equal(t, map[string]any{"status": "healthy"}, health("127.0.0.1:1234", ""), "the networks replace the loopback default")
+}
+
+func TestLatestMigrationVersion(t *testing.T) {
+	entries, err := embedMigrations.ReadDir("migrations")
+	require.NoError(t, err)
+	require.Equal(t, int64(len(entries)), latestMigrationVersion, "migrations are numbered consecutively")
+}
+
+func TestRegisterCheck(t *testing.T) {
+	ctx := context.Background()
+	failing := func(ctx context.Context) HealthCheck {
+		return HealthCheck{Status: StatusUnhealthy, Error: "unreachable"}
+	}
+	hanging := func(ctx context.Context) HealthCheck {
+		<-ctx.Done()
+		time.Sleep(time.Second) // ignores cancellation for a while
+		return HealthCheck{Status: StatusHealthy}
+	}
+
+	t.Run("Failing non-critical check degrades", func(t *testing.T) {
+		health := NewHealthChecker(currentBuild, nil, nil)
+		health.RegisterCheck("email", failing, NonCritical, 0)
+
+		response := health.CheckReadiness(ctx)
+		require.Equal(t, StatusDegraded, response.Status)
+		require.Equal(t, "email", response.Checks[0].Name)
+		require.False(t, response.Checks[0].Critical)
+		require.Equal(t, StatusHealthy, health.CheckLiveness(ctx).Status, "liveness checks no dependencies")
+	})
+
+	t.Run("Failing critical check is unhealthy", func(t *testing.T) {
+		health := NewHealthChecker(currentBuild, nil, nil)
+		health.RegisterCheck("email", failing, NonCritical, 0)
+		health.RegisterCheck("queue", failing, Critical, 0)
+
+		response := health.CheckHealth(ctx)
+		require.Equal(t, StatusUnhealthy, response.Status)
+		require.Len(t, response.Checks, 3, "registered checks run alongside the built-in ones")
+	})
+
+	t.Run("Slow check times out", func(t *testing.T) {
+		health := NewHealthChecker(currentBuild, nil, nil)
+		health.RegisterCheck("oauth", hanging, Critical, 10*time.Millisecond)
+
+		start := time.Now()
+		response := health.CheckReadiness(ctx)
+		require.Less(t, time.Since(start), 500*time.Millisecond)
+		require.Equal(t, StatusUnhealthy, response.Status)
+		require.Equal(t, "check timed out", response.Checks[0].Error)
+	})
+}
+
+func TestHealthCache(t *testing.T) {
+	ctx := context.Background()
+	health := NewHealthChecker(currentBuild, nil, nil)
+	health.cacheTTL = 100 * time.Millisecond
+
+	var runs atomic.Int32
+	health.RegisterCheck("slow", func(ctx context.Context) HealthCheck {
+		runs.Add(1)
+		time.Sleep(20 * time.Millisecond)
+		return HealthCheck{Status: StatusHealthy}
+	}, Critical, 0)
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			health.Cached(ctx, "/readyz", time.Second, health.CheckReadiness)
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, int32(1), runs.Load(), "concurrent probes share one run")
+
+	response := health.Cached(ctx, "/readyz", time.Second, health.CheckReadiness)
+	require.Equal(t, int32(1), runs.Load())
+	require.Positive(t, response.CacheAge)
+
+	// A caller giving up does not cut short the run others are waiting on
+	time.Sleep(100 * time.Millisecond)
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	response = health.Cached(cancelled, "/readyz", time.Second, health.CheckReadiness)
+	require.Equal(t, int32(2), runs.Load())
+	require.Equal(t, StatusHealthy, response.Status)
+	require.Zero(t, response.CacheAge)
+}
+
+func TestVersionEndpoint(t *testing.T) {
+	srv, err := NewServer(nil, newTestConfig(t))
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/version", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var build BuildInfo
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&build))
+	require.Equal(t, currentBuild, build)
+	require.Equal(t, runtime.Version(), build.GoVersion)
+	require.NotEmpty(t, build.Commit)
+
+	// The detailed health response carries the same details
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/livez", nil)
+	req.RemoteAddr = "127.0.0.1:1234"
+	srv.ServeHTTP(w, req)
+	var health HealthResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&health))
+	require.Equal(t, currentBuild, health.BuildInfo)
+}
@@ -2,21 +2,20 @@ package main
 
 import (
 	"context"
-	"embed"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"testing"
 	"time"
 
-	_ "github.com/lib/pq"
-	"github.com/pressly/goose/v3"
+	"github.com/stretchr/testify/require"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/modules/postgres"
 	"github.com/testcontainers/testcontainers-go/wait"
 )
 
-//go:embed migrations/*.sql
-var embedMigrations embed.FS
-
 // testDB represents a test database instance
 type testDB struct {
 	Container *postgres.PostgresContainer
@@ -57,18 +56,17 @@ func setupTestDB(t *testing.T) *testDB {
 	connStr := fmt.Sprintf("postgres://test:test@%s:%d/test?sslmode=disable", host, port.Int())
 
 	// Connect to the database
-	db, err := NewDB(connStr)
+	config, err := NewDBConfig(os.Getenv)
+	if err != nil {
+		t.Fatalf("invalid database configuration: %s", err)
+	}
+	db, err := NewDB(connStr, config)
 	if err != nil {
 		t.Fatalf("failed to connect to test database: %s", err)
 	}
 
 	// Run migrations
-	goose.SetBaseFS(embedMigrations)
-	if err := goose.SetDialect("postgres"); err != nil {
-		t.Fatalf("failed to set dialect: %s", err)
-	}
-
-	if err := goose.Up(db.DB.DB, "migrations"); err != nil {
+	if _, err := Migrate(ctx, db); err != nil {
 		t.Fatalf("failed to run migrations: %s", err)
 	}
 
@@ -78,6 +76,16 @@ func setupTestDB(t *testing.T) *testDB {
 	}
 }
 
+// newTestConfig loads a development configuration from the environment, so
+// tests can t.Setenv the settings they exercise first
+func newTestConfig(t *testing.T) *Config {
+	t.Helper()
+
+	config, err := NewConfig(os.Getenv)
+	require.NoError(t, err)
+	return config
+}
+
 // teardown closes the database connection and stops the container
 func (tdb *testDB) teardown(t *testing.T) {
 	t.Helper()
@@ -90,3 +98,21 @@ func (tdb *testDB) teardown(t *testing.T) {
 		t.Errorf("failed to terminate container: %s", err)
 	}
 }
+
+// addCSRFToken fetches a CSRF token from handler and attaches it, with its
+// cookie, to a state-changing request
+func addCSRFToken(t *testing.T, handler http.Handler, req *http.Request) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/csrf/token", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var response CSRFResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+
+	req.Header.Set("X-CSRF-Token", response.Token)
+	for _, cookie := range w.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+}
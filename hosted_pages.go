@@ -0,0 +1,468 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"html/template"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Hosted pages give deployments without a frontend of their own a working
+// sign-in: a login page choosing an identity provider, an invitation page
+// INVITATION_ACCEPT_URL can point at, the MFA page asking members with
+// two-step verification for a code, and the consent page members see
+// before signing in to an OIDC client when OIDC_REQUIRE_CONSENT is set.
+// They are rendered on the server in the organization's branding, and post
+// their forms with a CSRF token like any other client.
+
+// defaultProductName names the service on pages of organizations that
+// brand none
+const defaultProductName = "Huachuca"
+
+// oidcConsentTTL bounds how long a member can take to answer the consent page
+const oidcConsentTTL = 10 * time.Minute
+
+// pageContentSecurityPolicy lets hosted pages use their inline styles and
+// show logos from anywhere over HTTPS, and nothing else
+const pageContentSecurityPolicy = "default-src 'none'; " +
+	"style-src 'unsafe-inline'; " +
+	"img-src https:; " +
+	"frame-ancestors 'none'"
+
+// hostedPagePaths are the paths that serve hosted pages. The MFA and
+// consent pages are served from the Google callback that leads to them.
+var hostedPagePaths = []string{"/pages/login", "/pages/invitation", "/pages/mfa", "/pages/oidc/consent", "/auth/callback/google"}
+
+// page is what every hosted page is rendered with
+type page struct {
+	Title     string
+	Product   string
+	Color     string
+	LogoURL   string
+	CSRFToken string
+	Data      any
+}
+
+const pageLayout = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}} · {{.Product}}</title>
+<style>
+body { margin: 0; font-family: Helvetica, Arial, sans-serif; background: #f5f5f5; color: #202124; }
+main { max-width: 400px; margin: 64px auto; padding: 32px; background: #fff; border-top: 4px solid {{.Color}}; border-radius: 4px; }
+h1 { font-size: 22px; font-weight: normal; }
+img { max-height: 48px; }
+form { margin: 0; }
+.button { display: block; width: 100%; box-sizing: border-box; margin-top: 12px; padding: 12px; border: 1px solid {{.Color}}; border-radius: 4px; background: {{.Color}}; color: #fff; font-size: 16px; text-align: center; text-decoration: none; cursor: pointer; }
+.secondary { background: #fff; color: {{.Color}}; }
+.error { color: #d93025; }
+input.code { display: block; width: 100%; box-sizing: border-box; padding: 12px; border: 1px solid #dadce0; border-radius: 4px; font-size: 24px; letter-spacing: 8px; text-align: center; }
+</style>
+</head>
+<body>
+<main>
+{{- if .LogoURL}}
+<img src="{{.LogoURL}}" alt="{{.Product}}">
+{{- end}}
+<h1>{{.Title}}</h1>
+{{template "content" .}}
+</main>
+</body>
+</html>
+`
+
+func newPage(content string) *template.Template {
+	return template.Must(template.Must(template.New("page").Parse(pageLayout)).Parse(content))
+}
+
+var (
+	loginPage = newPage(`{{define "content"}}
+{{- range .Data}}
+<a class="button" href="{{.URL}}">Continue with {{.Name}}</a>
+{{- end}}
+{{end}}`)
+
+	invitationPage = newPage(`{{define "content"}}
+<p>{{.Data.Name}}, you have been invited to join {{.Data.Organization}} as {{.Data.Email}}.</p>
+<form method="post" action="/pages/invitation">
+<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+<input type="hidden" name="token" value="{{.Data.Token}}">
+<button class="button" type="submit">Accept invitation</button>
+</form>
+{{end}}`)
+
+	invitationAcceptedPage = newPage(`{{define "content"}}
+<p>You are now a member of {{.Data.Organization}}.</p>
+<a class="button" href="{{.Data.LoginURL}}">Sign in</a>
+{{end}}`)
+
+	mfaPage = newPage(`{{define "content"}}
+<p>Enter the code from your authenticator app to sign in as {{.Data.Email}}.</p>
+{{- if .Data.Error}}
+<p class="error">{{.Data.Error}}</p>
+{{- end}}
+<form method="post" action="/pages/mfa">
+<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+<input type="hidden" name="ticket" value="{{.Data.Ticket}}">
+<input class="code" type="text" name="code" inputmode="numeric" autocomplete="one-time-code" pattern="[0-9]{6}" maxlength="6" required autofocus>
+<button class="button" type="submit">Verify</button>
+</form>
+{{end}}`)
+
+	consentPage = newPage(`{{define "content"}}
+<p>{{.Data.Client}} would like to sign you in as {{.Data.Email}} and see:</p>
+<ul>
+{{- range .Data.Scopes}}
+<li>{{.}}</li>
+{{- end}}
+</ul>
+<form method="post" action="/pages/oidc/consent">
+<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+<input type="hidden" name="ticket" value="{{.Data.Ticket}}">
+<button class="button" type="submit" name="decision" value="allow">Allow</button>
+<button class="button secondary" type="submit" name="decision" value="deny">Deny</button>
+</form>
+{{end}}`)
+
+	messagePage = newPage(`{{define "content"}}
+<p>{{.Data}}</p>
+{{end}}`)
+)
+
+// oidcScopeDescriptions say what each scope shows a client, for the
+// consent page
+var oidcScopeDescriptions = map[string]string{
+	"openid":       "Your name and account ID",
+	"email":        "Your email address",
+	"organization": "Your organization and role",
+	"metadata":     "Details your organization keeps about you",
+}
+
+// renderPage responds with tmpl in orgID's branding, or the service's own
+// look when orgID is uuid.Nil
+func (s *Server) renderPage(w http.ResponseWriter, r *http.Request, status int, tmpl *template.Template, orgID uuid.UUID, title string, data any) {
+	p := page{Title: title, Product: defaultProductName, Color: defaultPrimaryColor, Data: data}
+	if branding := s.pageBranding(r.Context(), orgID); branding != nil {
+		if branding.ProductName != "" {
+			p.Product = branding.ProductName
+		}
+		if branding.PrimaryColor != "" {
+			p.Color = branding.PrimaryColor
+		}
+		p.LogoURL = branding.LogoURL
+	}
+
+	var err error
+	if p.CSRFToken, err = s.csrfToken(w, r); err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to issue CSRF token", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := tmpl.Execute(w, p); err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to render page", "title", title, "error", err)
+	}
+}
+
+// pageBranding returns orgID's branding, or nil when it has none or cannot
+// be read; pages are still served, unbranded
+func (s *Server) pageBranding(ctx context.Context, orgID uuid.UUID) *Branding {
+	if orgID == uuid.Nil || s.store == nil {
+		return nil
+	}
+	branding, err := organizationBranding(unscoped(ctx), s.store, orgID)
+	if err != nil && err != ErrOrganizationNotFound {
+		s.logger.WarnContext(ctx, "failed to get organization branding", "organization_id", orgID, "error", err)
+	}
+	return branding
+}
+
+// renderMessage responds with a page saying message
+func (s *Server) renderMessage(w http.ResponseWriter, r *http.Request, status int, orgID uuid.UUID, title, message string) {
+	s.renderPage(w, r, status, messagePage, orgID, title, message)
+}
+
+// loginProvider is an identity provider offered on the login page
+type loginProvider struct {
+	Name string
+	URL  string
+}
+
+// loginParams are the login page's parameters passed on to the provider's
+// login, such as a native app's loopback redirect
+var loginParams = []string{"redirect_uri", "code_challenge", "code_challenge_method", "state"}
+
+// handleLoginPage offers the identity providers members can sign in with,
+// in the branding of the organization named by org, if any
+func (s *Server) handleLoginPage(w http.ResponseWriter, r *http.Request) {
+	orgID, _ := uuid.Parse(r.URL.Query().Get("org"))
+
+	forwarded := url.Values{}
+	for _, key := range loginParams {
+		if value := r.URL.Query().Get(key); value != "" {
+			forwarded.Set(key, value)
+		}
+	}
+	google := "/auth/login/google"
+	if len(forwarded) > 0 {
+		google += "?" + forwarded.Encode()
+	}
+
+	providers := []loginProvider{{Name: "Google", URL: google}}
+	s.renderPage(w, r, http.StatusOK, loginPage, orgID, "Sign in", providers)
+}
+
+// handleInvitationPage shows an invitation and a form to accept it
+func (s *Server) handleInvitationPage(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if s.invitations == nil || token == "" {
+		s.renderMessage(w, r, http.StatusNotFound, uuid.Nil, "Invitation not found", ErrInvitationNotFound.Error())
+		return
+	}
+	inv, err := s.invitations.Find(r.Context(), token)
+	if err != nil {
+		s.renderInvitationError(w, r, err)
+		return
+	}
+	org, err := s.store.GetOrganization(unscoped(r.Context()), inv.OrganizationID)
+	if err != nil {
+		s.renderInvitationError(w, r, err)
+		return
+	}
+
+	s.renderPage(w, r, http.StatusOK, invitationPage, org.ID, "Join "+org.Name, map[string]string{
+		"Name":         inv.Name,
+		"Email":        inv.Email,
+		"Organization": org.Name,
+		"Token":        token,
+	})
+}
+
+// handleAcceptInvitationPage accepts the invitation the page's form names
+func (s *Server) handleAcceptInvitationPage(w http.ResponseWriter, r *http.Request) {
+	token := r.PostFormValue("token")
+	if s.invitations == nil || token == "" {
+		s.renderMessage(w, r, http.StatusNotFound, uuid.Nil, "Invitation not found", ErrInvitationNotFound.Error())
+		return
+	}
+	user, err := s.acceptInvitation(r, token)
+	if err != nil {
+		s.renderInvitationError(w, r, err)
+		return
+	}
+	org, err := s.store.GetOrganization(unscoped(r.Context()), user.OrganizationID)
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to get organization", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	s.renderPage(w, r, http.StatusOK, invitationAcceptedPage, org.ID, "Welcome to "+org.Name, map[string]string{
+		"Organization": org.Name,
+		"LoginURL":     "/pages/login?org=" + org.ID.String(),
+	})
+}
+
+// renderInvitationError responds with a page explaining why an invitation
+// cannot be accepted
+func (s *Server) renderInvitationError(w http.ResponseWriter, r *http.Request, err error) {
+	if err == sql.ErrNoRows || err == ErrOrganizationNotFound {
+		err = ErrInvitationNotFound
+	}
+	status := invitationErrorStatus(err)
+	if status == http.StatusInternalServerError {
+		s.logger.ErrorContext(r.Context(), "failed to accept invitation", "error", err)
+		http.Error(w, "Internal server error", status)
+		return
+	}
+	s.renderMessage(w, r, status, uuid.Nil, "Invitation unavailable", strings.ToUpper(err.Error()[:1])+err.Error()[1:]+".")
+}
+
+// renderMFAPage asks user for a code to finish the sign-in c describes,
+// with a ticket redeemed once for an answer, along with why the last code
+// was refused, if one was
+func (s *Server) renderMFAPage(w http.ResponseWriter, r *http.Request, status int, c *mfaChallenge, user *User, problem string) {
+	ticket, err := newMFATicket(c)
+	if err == nil {
+		err = s.stateStore.StoreState(r.Context(), mfaTicketKey(ticket), mfaChallengeTTL)
+	}
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to issue MFA ticket", "error", err)
+		http.Error(w, "Authentication failed", http.StatusInternalServerError)
+		return
+	}
+
+	s.renderPage(w, r, status, mfaPage, user.OrganizationID, "Two-step verification", map[string]string{
+		"Email":  user.Email,
+		"Error":  problem,
+		"Ticket": ticket,
+	})
+}
+
+// handleMFAChallenge checks the code given on the MFA page, finishing the
+// sign-in if it is right and asking again if not, until mfaMaxAttempts
+// codes have been wrong
+func (s *Server) handleMFAChallenge(w http.ResponseWriter, r *http.Request) {
+	expired := func() {
+		s.renderMessage(w, r, http.StatusBadRequest, uuid.Nil, "Sign-in expired",
+			"This sign-in has expired or was already finished. Sign in again.")
+	}
+
+	ticket := r.PostFormValue("ticket")
+	challenge := parseMFATicket(ticket)
+	if challenge == nil {
+		expired()
+		return
+	}
+	valid, err := s.stateStore.ValidateAndDeleteState(r.Context(), mfaTicketKey(ticket))
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to validate MFA ticket", "error", err)
+		http.Error(w, "Authentication failed", http.StatusInternalServerError)
+		return
+	}
+	if !valid {
+		expired()
+		return
+	}
+
+	user, err := s.store.GetUser(r.Context(), challenge.UserID)
+	if err == sql.ErrNoRows {
+		// Deleted while signing in
+		expired()
+		return
+	}
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to get user", "error", err)
+		http.Error(w, "Authentication failed", http.StatusInternalServerError)
+		return
+	}
+	factor, err := s.store.GetMFAFactor(r.Context(), user.ID)
+	if err == ErrMFAFactorNotFound || (err == nil && !factor.Enabled()) {
+		// Turned off while signing in
+		expired()
+		return
+	}
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to get MFA factor", "error", err)
+		http.Error(w, "Authentication failed", http.StatusInternalServerError)
+		return
+	}
+
+	step, ok := verifyTOTP(factor.Secret, r.PostFormValue("code"), time.Now())
+	if ok {
+		// A code is accepted once, so one seen over a shoulder is no use
+		if ok, err = s.store.UseMFAFactor(r.Context(), user.ID, step); err != nil {
+			s.logger.ErrorContext(r.Context(), "failed to record MFA code", "error", err)
+			http.Error(w, "Authentication failed", http.StatusInternalServerError)
+			return
+		}
+	}
+	if !ok {
+		s.captcha.RecordFailure(GetClientIPFromContext(r.Context()))
+		s.authLog.Log(r, AuthEventLoginFailed, user, AuthReasonInvalidMFACode, "provider", "google")
+		challenge.Attempts++
+		if challenge.Attempts >= mfaMaxAttempts {
+			s.renderMessage(w, r, http.StatusUnauthorized, user.OrganizationID, "Too many attempts",
+				"Too many wrong codes were entered. Sign in again.")
+			return
+		}
+		s.renderMFAPage(w, r, http.StatusUnauthorized, challenge, user, "That code is wrong or has expired. Try again.")
+		return
+	}
+	s.completeLogin(w, r, challenge.State, user)
+}
+
+// renderConsentPage asks user whether to sign in to client. Their answer
+// comes back with a ticket carrying authz, which is redeemed once.
+func (s *Server) renderConsentPage(w http.ResponseWriter, r *http.Request, authz *oidcAuthorization, client *OIDCClient, user *User) {
+	ticket, err := newOIDCCode(authz)
+	if err == nil {
+		err = s.stateStore.StoreState(r.Context(), oidcConsentKey(ticket), oidcConsentTTL)
+	}
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to issue consent ticket", "error", err)
+		http.Error(w, "Authentication failed", http.StatusInternalServerError)
+		return
+	}
+
+	var scopes []string
+	for _, scope := range strings.Fields(authz.Scope) {
+		scopes = append(scopes, oidcScopeDescriptions[scope])
+	}
+	s.renderPage(w, r, http.StatusOK, consentPage, client.OrganizationID, "Sign in to "+client.Name, map[string]any{
+		"Client": client.Name,
+		"Email":  user.Email,
+		"Scopes": scopes,
+		"Ticket": ticket,
+	})
+}
+
+// handleConsent takes a member's answer on the consent page, sending the
+// browser back to the client with a code if they allowed it or an
+// access_denied error if not
+func (s *Server) handleConsent(w http.ResponseWriter, r *http.Request) {
+	if !s.oidc.Enabled() {
+		http.NotFound(w, r)
+		return
+	}
+	expired := func() {
+		s.renderMessage(w, r, http.StatusBadRequest, uuid.Nil, "Request expired",
+			"This sign-in request has expired or was already answered. Return to the application and sign in again.")
+	}
+
+	ticket := r.PostFormValue("ticket")
+	authz := parseOIDCCode(ticket)
+	if authz == nil {
+		expired()
+		return
+	}
+	valid, err := s.stateStore.ValidateAndDeleteState(r.Context(), oidcConsentKey(ticket))
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to validate consent ticket", "error", err)
+		http.Error(w, "Authentication failed", http.StatusInternalServerError)
+		return
+	}
+	if !valid {
+		expired()
+		return
+	}
+
+	client, err := s.store.GetOIDCClient(r.Context(), authz.ClientID)
+	if err != nil {
+		switch err {
+		case ErrOIDCClientNotFound:
+			// Deleted while the member was deciding
+			http.Error(w, "Invalid client_id", http.StatusBadRequest)
+		default:
+			s.logger.ErrorContext(r.Context(), "failed to get OIDC client", "error", err)
+			http.Error(w, "Authentication failed", http.StatusInternalServerError)
+		}
+		return
+	}
+	if !slices.Contains(client.RedirectURIs, authz.RedirectURI) {
+		http.Error(w, "Invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	if r.PostFormValue("decision") != "allow" {
+		s.recordAudit(r, "oidc.consent_denied", client.OrganizationID, authz.UserID.String(), AuditMetadata{"client_id": client.ID.String()})
+		redirectWithParams(w, r, authz.RedirectURI, url.Values{
+			"error":             {"access_denied"},
+			"error_description": {"the user denied the request"},
+			"state":             {authz.State},
+		})
+		return
+	}
+	s.issueOIDCCode(w, r, authz, client)
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+var hiddenFieldPattern = regexp.MustCompile(`name="(\w+)" value="([^"]*)"`)
+
+// submitForm posts the hidden fields of the form page w rendered, with the
+// cookies it set, plus extra
+func submitForm(t *testing.T, srv *Server, page *httptest.ResponseRecorder, path string, extra url.Values) *httptest.ResponseRecorder {
+	t.Helper()
+	return resubmitForm(t, srv, page, page.Result().Cookies(), path, extra)
+}
+
+// resubmitForm is submitForm for pages that set no cookies, answering an
+// earlier page that set them
+func resubmitForm(t *testing.T, srv *Server, page *httptest.ResponseRecorder, cookies []*http.Cookie, path string, extra url.Values) *httptest.ResponseRecorder {
+	t.Helper()
+
+	form := url.Values{}
+	for _, field := range hiddenFieldPattern.FindAllStringSubmatch(page.Body.String(), -1) {
+		form.Set(field[1], html.UnescapeString(field[2]))
+	}
+	require.NotEmpty(t, form.Get("csrf_token"), "the form carries a CSRF token")
+	for key, values := range extra {
+		form[key] = values
+	}
+
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	return w
+}
+
+func TestHostedPages(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	srv, err := NewServer(store, newTestConfig(t))
+	require.NoError(t, err)
+
+	acme, err := store.CreateOrganization(ctx, "Acme", "owner@acme.test", "Owner")
+	require.NoError(t, err)
+	branding := &Branding{LogoURL: "https://cdn.acme.test/logo.png", PrimaryColor: "#ff6600", ProductName: "Acme Portal"}
+	_, err = store.UpdateOrganizationSettings(ctx, acme.ID, acme.Version, &OrganizationSettings{Branding: branding})
+	require.NoError(t, err)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	t.Run("The login page offers the providers in the organization's branding", func(t *testing.T) {
+		w := get("/pages/login?org=" + acme.ID.String() + "&redirect_uri=http://127.0.0.1:5000/cb&unknown=x")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+		require.Equal(t, pageContentSecurityPolicy, w.Header().Get("Content-Security-Policy"))
+		require.Contains(t, w.Body.String(), `<title>Sign in · Acme Portal</title>`)
+		require.Contains(t, w.Body.String(), `<img src="https://cdn.acme.test/logo.png"`)
+		require.Contains(t, w.Body.String(), "#ff6600")
+		require.Contains(t, w.Body.String(), `href="/auth/login/google?redirect_uri=http%3A%2F%2F127.0.0.1%3A5000%2Fcb"`)
+
+		w = get("/pages/login?org=" + uuid.NewString())
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Contains(t, w.Body.String(), defaultProductName, "unknown organizations get the service's look")
+	})
+
+	t.Run("Invitations are accepted from their page", func(t *testing.T) {
+		inv, err := srv.invitations.Create(ctx, acme.ID, acme.OwnerID, "new@acme.test", "New")
+		require.NoError(t, err)
+
+		page := get("/pages/invitation?token=" + url.QueryEscape(inv.Token))
+		require.Equal(t, http.StatusOK, page.Code, page.Body.String())
+		require.Contains(t, page.Body.String(), "join Acme as new@acme.test")
+
+		forged := httptest.NewRequest(http.MethodPost, "/pages/invitation", strings.NewReader(url.Values{"token": {inv.Token}}.Encode()))
+		forged.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, forged)
+		require.Equal(t, http.StatusForbidden, w.Code, "forms need their CSRF token")
+
+		w = submitForm(t, srv, page, "/pages/invitation", nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Contains(t, w.Body.String(), "You are now a member of Acme")
+		user, err := store.GetUserByEmail(ctx, "new@acme.test")
+		require.NoError(t, err)
+		require.Equal(t, acme.ID, user.OrganizationID)
+
+		w = submitForm(t, srv, page, "/pages/invitation", nil)
+		require.Equal(t, http.StatusNotFound, w.Code)
+		require.Contains(t, w.Body.String(), "Invitation not found.")
+	})
+
+	t.Run("Unknown invitations are not found", func(t *testing.T) {
+		require.Equal(t, http.StatusNotFound, get("/pages/invitation?token=nope").Code)
+		require.Equal(t, http.StatusNotFound, get("/pages/invitation").Code)
+
+		// The organization was deleted after inviting them
+		inv, err := srv.invitations.Create(ctx, acme.ID, acme.OwnerID, "late@acme.test", "Late")
+		require.NoError(t, err)
+		orphaned, err := NewServer(orphanedStore{store}, newTestConfig(t))
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		orphaned.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/pages/invitation?token="+url.QueryEscape(inv.Token), nil))
+		require.Equal(t, http.StatusNotFound, w.Code, w.Body.String())
+		require.Contains(t, w.Body.String(), "Invitation not found.")
+	})
+}
+
+func TestOIDCConsentPage(t *testing.T) {
+	t.Setenv("OIDC_ISSUER", "https://auth.acme.test")
+	t.Setenv("OIDC_REQUIRE_CONSENT", "true")
+	ctx := context.Background()
+
+	store := NewMemoryStore()
+	config := newTestConfig(t)
+	srv, err := NewServer(store, config)
+	require.NoError(t, err)
+	acme, err := store.CreateOrganization(ctx, "Acme", "owner@acme.test", "Owner")
+	require.NoError(t, err)
+	owner, err := store.GetUser(ctx, acme.OwnerID)
+	require.NoError(t, err)
+
+	const redirectURI = "https://wiki.acme.test/callback"
+	client := &OIDCClient{ID: uuid.New(), OrganizationID: acme.ID, Name: "Wiki", RedirectURIs: OIDCRedirectURIs{redirectURI}}
+	require.NoError(t, store.CreateOIDCClient(ctx, client))
+
+	// The Google callback renders the page, behind the CSRF middleware
+	callback := NewCSRFMiddleware(config.CSRF)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authz := &oidcAuthorization{ClientID: client.ID, RedirectURI: redirectURI, Scope: "openid email", State: "app-state"}
+		srv.redirectToOIDCClient(w, r, authz, owner)
+	}))
+	// consent signs owner in and answers the page
+	consent := func(t *testing.T, decision string) (*httptest.ResponseRecorder, *httptest.ResponseRecorder) {
+		page := httptest.NewRecorder()
+		callback.ServeHTTP(page, httptest.NewRequest(http.MethodGet, "/auth/callback/google", nil))
+		require.Equal(t, http.StatusOK, page.Code, page.Body.String())
+		require.Contains(t, page.Body.String(), "Wiki would like to sign you in as owner@acme.test")
+		require.Contains(t, page.Body.String(), oidcScopeDescriptions["email"])
+
+		return page, submitForm(t, srv, page, "/pages/oidc/consent", url.Values{"decision": {decision}})
+	}
+
+	t.Run("Allowing sends the browser back with a code", func(t *testing.T) {
+		page, w := consent(t, "allow")
+		require.Equal(t, http.StatusFound, w.Code, w.Body.String())
+		location, err := url.Parse(w.Header().Get("Location"))
+		require.NoError(t, err)
+		require.Equal(t, "wiki.acme.test", location.Host)
+		require.Equal(t, "app-state", location.Query().Get("state"))
+		authz := parseOIDCCode(location.Query().Get("code"))
+		require.NotNil(t, authz)
+		require.Equal(t, owner.ID, authz.UserID)
+
+		w = submitForm(t, srv, page, "/pages/oidc/consent", url.Values{"decision": {"allow"}})
+		require.Equal(t, http.StatusBadRequest, w.Code, "each page is answered once")
+	})
+
+	t.Run("Denying sends the browser back with an error", func(t *testing.T) {
+		_, w := consent(t, "deny")
+		require.Equal(t, http.StatusFound, w.Code, w.Body.String())
+		location, err := url.Parse(w.Header().Get("Location"))
+		require.NoError(t, err)
+		require.Equal(t, "access_denied", location.Query().Get("error"))
+		require.Empty(t, location.Query().Get("code"))
+	})
+}
+
+func TestMFAPage(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	config := newTestConfig(t)
+	srv, err := NewServer(store, config)
+	require.NoError(t, err)
+
+	acme, err := store.CreateOrganization(ctx, "Acme", "owner@acme.test", "Owner")
+	require.NoError(t, err)
+	branding := &Branding{PrimaryColor: "#ff6600", ProductName: "Acme Portal"}
+	_, err = store.UpdateOrganizationSettings(ctx, acme.ID, acme.Version, &OrganizationSettings{Branding: branding})
+	require.NoError(t, err)
+	owner, err := store.GetUser(ctx, acme.OwnerID)
+	require.NoError(t, err)
+
+	// The Google callback renders the page, behind the CSRF middleware
+	callback := NewCSRFMiddleware(config.CSRF)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		srv.continueLogin(w, r, "", owner)
+	}))
+	login := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		callback.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/auth/callback/google", nil))
+		return w
+	}
+
+	w := login()
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), "access_token", "members without a factor are signed in at once")
+
+	secret, err := newTOTPSecret()
+	require.NoError(t, err)
+	require.NoError(t, store.PutMFAFactor(ctx, &MFAFactor{UserID: owner.ID, Secret: secret}))
+	require.Contains(t, login().Body.String(), "access_token", "pending factors are not asked for")
+	// Enable it with a code from the last step, leaving the current one
+	// for the sign-in
+	ok, err := store.UseMFAFactor(ctx, owner.ID, time.Now().Unix()/int64(totpPeriod/time.Second)-1)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	t.Run("A code finishes the sign-in", func(t *testing.T) {
+		page := login()
+		require.Equal(t, http.StatusOK, page.Code, page.Body.String())
+		require.Contains(t, page.Body.String(), `<title>Two-step verification · Acme Portal</title>`)
+		require.Contains(t, page.Body.String(), "sign in as owner@acme.test")
+		require.NotContains(t, page.Body.String(), "access_token")
+
+		code := currentTOTP(t, secret)
+		w := submitForm(t, srv, page, "/pages/mfa", url.Values{"code": {code}})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var tokens TokenResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&tokens))
+		require.NotEmpty(t, tokens.AccessToken)
+
+		w = submitForm(t, srv, page, "/pages/mfa", url.Values{"code": {code}})
+		require.Equal(t, http.StatusBadRequest, w.Code, "each page is answered once")
+
+		w = submitForm(t, srv, login(), "/pages/mfa", url.Values{"code": {code}})
+		require.Equal(t, http.StatusUnauthorized, w.Code, "each code is accepted once")
+	})
+
+	t.Run("Wrong codes are asked again, a few times", func(t *testing.T) {
+		forged := httptest.NewRequest(http.MethodPost, "/pages/mfa", strings.NewReader(url.Values{"code": {"000000"}}.Encode()))
+		forged.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, forged)
+		require.Equal(t, http.StatusForbidden, w.Code, "forms need their CSRF token")
+
+		page := login()
+		cookies := page.Result().Cookies()
+		for i := 1; i < mfaMaxAttempts; i++ {
+			page = resubmitForm(t, srv, page, cookies, "/pages/mfa", url.Values{"code": {"abcdef"}})
+			require.Equal(t, http.StatusUnauthorized, page.Code)
+			require.Contains(t, page.Body.String(), "That code is wrong or has expired.")
+		}
+		w = resubmitForm(t, srv, page, cookies, "/pages/mfa", url.Values{"code": {"abcdef"}})
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+		require.Contains(t, w.Body.String(), "Too many wrong codes were entered.")
+		require.NotContains(t, w.Body.String(), `name="ticket"`)
+	})
+}
@@ -27,7 +27,7 @@ type IntegrationTestSuite struct {
 func setupIntegrationTest(t *testing.T) *IntegrationTestSuite {
 	testdb := setupTestDB(t)
 
-	srv, err := NewServer(testdb.DB)
+	srv, err := NewServer(testdb.DB, newTestConfig(t))
 	require.NoError(t, err)
 
 	// Create initial test user and organization
@@ -89,6 +89,11 @@ func setupIntegrationTest(t *testing.T) *IntegrationTestSuite {
 }
 
 func (s *IntegrationTestSuite) makeRequest(t *testing.T, method, path string, body interface{}) *httptest.ResponseRecorder {
+	return s.makeRequestWithHeader(t, method, path, body, nil)
+}
+
+// makeRequestWithHeader is makeRequest with extra request headers
+func (s *IntegrationTestSuite) makeRequestWithHeader(t *testing.T, method, path string, body interface{}, header http.Header) *httptest.ResponseRecorder {
 	var bodyReader bytes.Buffer
 	if body != nil {
 		err := json.NewEncoder(&bodyReader).Encode(body)
@@ -96,12 +101,18 @@ func (s *IntegrationTestSuite) makeRequest(t *testing.T, method, path string, bo
 	}
 
 	req := httptest.NewRequest(method, path, &bodyReader)
+	for name, values := range header {
+		req.Header[name] = values
+	}
 	if body != nil {
 		req.Header.Set("Content-Type", "application/json")
 	}
 	if s.token != "" {
 		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.token))
 	}
+	if method != http.MethodGet {
+		addCSRFToken(t, s.server, req)
+	}
 
 	w := httptest.NewRecorder()
 	s.server.ServeHTTP(w, req)
@@ -405,7 +416,7 @@ func TestAuthFlow(t *testing.T) {
 		require.NotEmpty(t, user.ID)
 
 		// Generate refresh token
-		refreshToken, err := suite.db.CreateRefreshToken(context.Background(), user.ID)
+		refreshToken, err := suite.db.CreateRefreshToken(context.Background(), user.ID, DefaultSessionTerms)
 		require.NoError(t, err)
 
 		// Verify refresh token was stored
@@ -430,11 +441,11 @@ func TestAuthFlow(t *testing.T) {
 		require.NotEmpty(t, tokenResp.RefreshToken)
 		require.Equal(t, 900, tokenResp.ExpiresIn)
 
-		// Verify old refresh token was replaced
+		// Verify old refresh token was revoked
 		err = suite.db.GetContext(context.Background(), &count,
-			`SELECT COUNT(*) FROM refresh_tokens WHERE user_id = $1`, user.ID)
+			`SELECT COUNT(*) FROM refresh_tokens WHERE user_id = $1 AND revoked_at IS NULL`, user.ID)
 		require.NoError(t, err)
-		require.Equal(t, 1, count, "Should still have exactly one refresh token")
+		require.Equal(t, 1, count, "Should still have exactly one live refresh token")
 
 		// Try to use the old refresh token (should fail)
 		refreshReq.RefreshToken = refreshToken
@@ -479,14 +490,22 @@ func TestAuthFlow(t *testing.T) {
 		w = suite.makeRequest(t, http.MethodPost, "/auth/refresh", refreshReq)
 		require.Equal(t, http.StatusUnauthorized, w.Code)
 
-		// Verify expired token was cleaned up
-		time.Sleep(100 * time.Millisecond) // Small delay to ensure cleanup is complete
-
+		// Verify expired token is kept until retention purges it
 		var count int
 		err = suite.db.GetContext(context.Background(), &count,
 			`SELECT COUNT(*) FROM refresh_tokens WHERE user_id = $1`, user.ID)
 		require.NoError(t, err)
-		require.Equal(t, 0, count, "Expired token should be deleted")
+		require.Equal(t, 1, count, "Expired token should be kept within the retention window")
+
+		purged, err := suite.db.PurgeRetained(context.Background(), RetentionScope{OrgID: user.OrganizationID},
+			RetentionCutoffs{RefreshTokens: time.Now().Add(-time.Hour)})
+		require.NoError(t, err)
+		require.Equal(t, int64(1), purged.RefreshTokens)
+
+		err = suite.db.GetContext(context.Background(), &count,
+			`SELECT COUNT(*) FROM refresh_tokens WHERE user_id = $1`, user.ID)
+		require.NoError(t, err)
+		require.Equal(t, 0, count, "Expired token should be purged")
 	})
 
 	t.Run("Invalid Refresh Token", func(t *testing.T) {
@@ -517,7 +536,7 @@ func TestAuthFlow(t *testing.T) {
 		require.NoError(t, err)
 
 		// Create first refresh token
-		token1, err := suite.db.CreateRefreshToken(context.Background(), user.ID)
+		token1, err := suite.db.CreateRefreshToken(context.Background(), user.ID, DefaultSessionTerms)
 		require.NoError(t, err)
 
 		// Verify first token works
@@ -529,7 +548,7 @@ func TestAuthFlow(t *testing.T) {
 		require.Equal(t, http.StatusOK, w.Code)
 
 		// Create second refresh token (simulating login from another device)
-		token2, err := suite.db.CreateRefreshToken(context.Background(), user.ID)
+		token2, err := suite.db.CreateRefreshToken(context.Background(), user.ID, DefaultSessionTerms)
 		require.NoError(t, err)
 
 		// Try to use the first token (should fail as it was invalidated)
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Members can be invited rather than added outright. An invitation waits
+// for the invitee to accept it with the token they were sent, and expires
+// unaccepted after INVITATION_TTL. While it is pending the invitee is
+// reminded every INVITATION_REMINDER_INTERVAL, at most
+// INVITATION_MAX_REMINDERS times, and once expired it is kept for
+// INVITATION_RETENTION so it still counts in the organization's stats.
+
+var (
+	ErrInvitationNotFound = errors.New("invitation not found")
+	ErrInvitationExpired  = errors.New("invitation has expired")
+)
+
+// Invitation is a pending invitation to join an organization as a
+// sub-account. Only the hash of its token is stored.
+type Invitation struct {
+	ID             uuid.UUID  `db:"id" json:"id"`
+	OrganizationID uuid.UUID  `db:"organization_id" json:"organization_id"`
+	Email          string     `db:"email" json:"email"`
+	Name           string     `db:"name" json:"name"`
+	InvitedBy      uuid.UUID  `db:"invited_by" json:"invited_by"`
+	TokenHash      string     `db:"token_hash" json:"-"`
+	RemindersSent  int        `db:"reminders_sent" json:"reminders_sent"`
+	NextReminderAt *time.Time `db:"next_reminder_at" json:"next_reminder_at,omitempty"`
+	ExpiresAt      time.Time  `db:"expires_at" json:"expires_at"`
+	CreatedAt      time.Time  `db:"created_at" json:"created_at"`
+
+	// Token is returned once, when the invitation is created by a server
+	// that sends no email, for the caller to deliver
+	Token string `db:"-" json:"token,omitempty"`
+}
+
+// Expired reports whether the invitation can no longer be accepted
+func (i *Invitation) Expired(now time.Time) bool {
+	return !now.Before(i.ExpiresAt)
+}
+
+// AcceptInvitationRequest accepts the invitation a token was sent for
+type AcceptInvitationRequest struct {
+	Token string `json:"token"`
+}
+
+// InvitationConfig sets how long invitations last and how their invitees
+// are reminded
+type InvitationConfig struct {
+	TTL              time.Duration
+	ReminderInterval time.Duration // 0 sends no reminders
+	MaxReminders     int
+	Retention        time.Duration // how long expired invitations are kept
+	// AcceptURL is the page invitees accept on; the token is added to it
+	// as the token query parameter. Needed to email invitations.
+	AcceptURL string
+}
+
+// NewInvitationConfig creates an invitation configuration from settings
+func NewInvitationConfig(settings Settings) (*InvitationConfig, error) {
+	config := &InvitationConfig{AcceptURL: settings("INVITATION_ACCEPT_URL")}
+	var errs []error
+	for _, d := range []struct {
+		key, fallback string
+		value         *time.Duration
+	}{
+		{"INVITATION_TTL", "168h", &config.TTL},
+		{"INVITATION_REMINDER_INTERVAL", "48h", &config.ReminderInterval},
+		{"INVITATION_RETENTION", "720h", &config.Retention},
+	} {
+		value, err := time.ParseDuration(settings.get(d.key, d.fallback))
+		if err != nil || value < 0 {
+			errs = append(errs, fmt.Errorf("invalid %s %q", d.key, settings(d.key)))
+		}
+		*d.value = value
+	}
+	if config.TTL == 0 {
+		errs = append(errs, fmt.Errorf("invalid INVITATION_TTL %q: must be positive", settings("INVITATION_TTL")))
+	}
+
+	maxReminders, err := strconv.Atoi(settings.get("INVITATION_MAX_REMINDERS", "2"))
+	if err != nil || maxReminders < 0 {
+		errs = append(errs, fmt.Errorf("invalid INVITATION_MAX_REMINDERS %q", settings("INVITATION_MAX_REMINDERS")))
+	}
+	config.MaxReminders = maxReminders
+
+	if config.AcceptURL != "" {
+		u, err := url.Parse(config.AcceptURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("invalid INVITATION_ACCEPT_URL %q: expected an absolute URL", config.AcceptURL))
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// invitationSchedulePoll is how often reminders and expired invitations
+// are looked for
+const invitationSchedulePoll = time.Minute
+
+// invitationEmailJob is the kind of job that emails an invitation or a
+// reminder of it
+const invitationEmailJob = "invitation.email"
+
+type invitationEmailPayload struct {
+	InvitationID uuid.UUID `json:"invitation_id"`
+	Reminder     bool      `json:"reminder,omitempty"`
+}
+
+// Invitations creates invitations, emails them when the server has a
+// mailer, and reminds and cleans up after them in the background. Tokens
+// are never queued: each email is sent with a fresh token, which replaces
+// the one sent before, so only the latest email's link works.
+type Invitations struct {
+	store  Store
+	jobs   *JobRunner
+	mailer Mailer // nil sends nothing, and no reminders are due
+	config *InvitationConfig
+	logger *slog.Logger
+	now    func() time.Time
+}
+
+func NewInvitations(store Store, jobs *JobRunner, mailer Mailer, config *InvitationConfig, logger *slog.Logger) *Invitations {
+	inv := &Invitations{
+		store:  store,
+		jobs:   jobs,
+		mailer: mailer,
+		config: config,
+		logger: logger,
+		now:    time.Now,
+	}
+	jobs.Register(invitationEmailJob, inv.email)
+	go inv.schedule()
+	return inv
+}
+
+// Create invites email to orgID on behalf of invitedBy, replacing any
+// invitation already pending for the address. Without a mailer the
+// invitation is returned with its token.
+func (i *Invitations) Create(ctx context.Context, orgID, invitedBy uuid.UUID, email, name string) (*Invitation, error) {
+	now := i.now().UTC().Truncate(time.Microsecond)
+	inv := &Invitation{
+		ID:             uuid.New(),
+		OrganizationID: orgID,
+		Email:          email,
+		Name:           name,
+		InvitedBy:      invitedBy,
+		ExpiresAt:      now.Add(i.config.TTL),
+	}
+
+	token, err := GenerateRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+	inv.TokenHash = HashToken(token)
+	if i.mailer == nil {
+		inv.Token = token
+	} else if i.config.ReminderInterval > 0 && i.config.MaxReminders > 0 {
+		next := now.Add(i.config.ReminderInterval)
+		inv.NextReminderAt = &next
+	}
+
+	if err := i.store.CreateInvitation(ctx, inv); err != nil {
+		return nil, err
+	}
+	if i.mailer != nil {
+		if _, err := i.jobs.Enqueue(ctx, invitationEmailJob, invitationEmailPayload{InvitationID: inv.ID}, time.Time{}); err != nil {
+			return nil, err
+		}
+	}
+	return inv, nil
+}
+
+// Find returns the live invitation token was issued for
+func (i *Invitations) Find(ctx context.Context, token string) (*Invitation, error) {
+	inv, err := i.store.GetInvitationByToken(ctx, HashToken(token))
+	if err != nil {
+		return nil, err
+	}
+	if inv.Expired(i.now()) {
+		return nil, ErrInvitationExpired
+	}
+	return inv, nil
+}
+
+func (i *Invitations) schedule() {
+	ticker := time.NewTicker(invitationSchedulePoll)
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		if err := i.sweep(ctx); err != nil {
+			i.logger.Error("failed to process invitations", "error", err)
+		}
+		cancel()
+	}
+}
+
+// sweep queues the reminders that are due and deletes invitations that
+// expired longer than the retention window ago
+func (i *Invitations) sweep(ctx context.Context) error {
+	now := i.now()
+	if i.mailer != nil {
+		due, err := i.store.ClaimDueInvitationReminders(ctx, now, i.config.ReminderInterval, i.config.MaxReminders)
+		if err != nil {
+			return err
+		}
+		for _, id := range due {
+			payload := invitationEmailPayload{InvitationID: id, Reminder: true}
+			if _, err := i.jobs.Enqueue(ctx, invitationEmailJob, payload, time.Time{}); err != nil {
+				return err
+			}
+		}
+	}
+
+	deleted, err := i.store.DeleteExpiredInvitations(ctx, now.Add(-i.config.Retention))
+	if err != nil {
+		return err
+	}
+	if deleted > 0 {
+		i.logger.Info("deleted expired invitations", "count", deleted)
+	}
+	return nil
+}
+
+// email is the job handler sending an invitation, or a reminder of it,
+// with a fresh token. Invitations accepted, revoked or expired since the
+// job was queued are not sent.
+func (i *Invitations) email(ctx context.Context, raw json.RawMessage) error {
+	var payload invitationEmailPayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return err
+	}
+
+	token, err := GenerateRefreshToken()
+	if err != nil {
+		return err
+	}
+	inv, err := i.store.SetInvitationToken(ctx, payload.InvitationID, HashToken(token))
+	if err == ErrInvitationNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if inv.Expired(i.now()) {
+		return nil
+	}
+	org, err := i.store.GetOrganization(ctx, inv.OrganizationID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	branding, err := organizationBranding(ctx, i.store, org.ID)
+	if err != nil {
+		return err
+	}
+
+	subject := fmt.Sprintf("You're invited to join %s", org.Name)
+	if payload.Reminder {
+		subject = fmt.Sprintf("Reminder: you're invited to join %s", org.Name)
+	}
+	body := fmt.Sprintf("Hi %s,\n\nYou have been invited to join %s. Accept the invitation here:\n\n%s\n\nThe invitation expires on %s.\n",
+		inv.Name, org.Name, i.acceptLink(token), inv.ExpiresAt.Format("January 2, 2006 at 15:04 MST"))
+	return i.mailer.Send(ctx, &Mail{To: inv.Email, Subject: subject, Body: body, Branding: branding})
+}
+
+// acceptLink returns the accept page's URL carrying token
+func (i *Invitations) acceptLink(token string) string {
+	u, _ := url.Parse(i.config.AcceptURL)
+	q := u.Query()
+	q.Set("token", token)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
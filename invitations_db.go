@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+const invitationColumns = `id, organization_id, email, name, invited_by, token_hash, reminders_sent, next_reminder_at, expires_at, created_at`
+
+// openInvitations decrypts the email addresses and names of invitations
+// read from the database
+func (db *DB) openInvitations(invitations ...*Invitation) error {
+	for _, inv := range invitations {
+		email, err := db.pii.Open(inv.Email)
+		if err != nil {
+			return err
+		}
+		name, err := db.pii.Open(inv.Name)
+		if err != nil {
+			return err
+		}
+		inv.Email, inv.Name = email, name
+	}
+	return nil
+}
+
+// CreateInvitation stores an invitation, replacing the one pending for the
+// same address, and fills in its creation time
+func (db *DB) CreateInvitation(ctx context.Context, inv *Invitation) error {
+	sealed, err := db.sealUser(inv.Email, inv.Name)
+	if err != nil {
+		return err
+	}
+	return db.tenantTx(ctx, inv.OrganizationID, func(tx *sqlx.Tx) error {
+		_, err := tx.ExecContext(ctx, `
+			DELETE FROM invitations WHERE organization_id = $1 AND (email_hash = $2 OR email = $3)
+		`, inv.OrganizationID, sealed.EmailHash, inv.Email)
+		if err != nil {
+			return err
+		}
+		return tx.QueryRowxContext(ctx, `
+			INSERT INTO invitations (id, organization_id, email, email_hash, name, invited_by, token_hash, next_reminder_at, expires_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			RETURNING created_at
+		`, inv.ID, inv.OrganizationID, sealed.Email, sealed.EmailHash, sealed.Name, inv.InvitedBy, inv.TokenHash,
+			inv.NextReminderAt, inv.ExpiresAt).Scan(&inv.CreatedAt)
+	})
+}
+
+// GetInvitationByToken finds an invitation by the hash of its token, in
+// whichever organization it is, expired or not
+func (db *DB) GetInvitationByToken(ctx context.Context, tokenHash string) (*Invitation, error) {
+	inv := &Invitation{}
+	err := db.read(ctx, func(q sqlx.QueryerContext) error {
+		return sqlx.GetContext(ctx, q, inv, `
+			SELECT `+invitationColumns+` FROM invitations WHERE token_hash = $1
+		`, tokenHash)
+	})
+	if err == sql.ErrNoRows {
+		return nil, ErrInvitationNotFound
+	}
+	if err == nil {
+		err = db.openInvitations(inv)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+// ListInvitations returns an organization's invitations, pending and
+// expired, newest first
+func (db *DB) ListInvitations(ctx context.Context, orgID uuid.UUID) ([]Invitation, error) {
+	invitations := []Invitation{}
+	err := db.tenantRead(ctx, orgID, func(q sqlx.QueryerContext) error {
+		return sqlx.SelectContext(ctx, q, &invitations, `
+			SELECT `+invitationColumns+` FROM invitations
+			WHERE organization_id = $1
+			ORDER BY created_at DESC, id
+		`, orgID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range invitations {
+		if err := db.openInvitations(&invitations[i]); err != nil {
+			return nil, err
+		}
+	}
+	return invitations, nil
+}
+
+func (db *DB) DeleteInvitation(ctx context.Context, orgID, id uuid.UUID) error {
+	return db.tenantTx(ctx, orgID, func(tx *sqlx.Tx) error {
+		result, err := tx.ExecContext(ctx, `
+			DELETE FROM invitations WHERE id = $1 AND organization_id = $2
+		`, id, orgID)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrInvitationNotFound
+		}
+		return nil
+	})
+}
+
+// SetInvitationToken replaces an invitation's token, returning the
+// invitation
+func (db *DB) SetInvitationToken(ctx context.Context, id uuid.UUID, tokenHash string) (*Invitation, error) {
+	inv := &Invitation{}
+	err := db.transact(ctx, func(tx *sqlx.Tx) error {
+		return tx.GetContext(ctx, inv, `
+			UPDATE invitations SET token_hash = $2 WHERE id = $1
+			RETURNING `+invitationColumns,
+			id, tokenHash)
+	})
+	if err == sql.ErrNoRows {
+		return nil, ErrInvitationNotFound
+	}
+	if err == nil {
+		err = db.openInvitations(inv)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+// ClaimDueInvitationReminders returns the live invitations whose reminder
+// is due at now, counting the reminder and scheduling the next one after
+// interval unless maxReminders have then been sent
+func (db *DB) ClaimDueInvitationReminders(ctx context.Context, now time.Time, interval time.Duration, maxReminders int) ([]uuid.UUID, error) {
+	due := []uuid.UUID{}
+	err := db.transact(ctx, func(tx *sqlx.Tx) error {
+		// SKIP LOCKED lets instances polling at once claim different invitations
+		return tx.SelectContext(ctx, &due, `
+			UPDATE invitations
+			SET reminders_sent = reminders_sent + 1,
+			    next_reminder_at = CASE WHEN reminders_sent + 1 < $3 THEN $2::timestamp END
+			WHERE id IN (
+				SELECT id FROM invitations
+				WHERE next_reminder_at <= $1 AND expires_at > $1
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING id
+		`, now.UTC(), now.Add(interval).UTC(), maxReminders)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return due, nil
+}
+
+// DeleteExpiredInvitations deletes the invitations that expired before
+// cutoff, returning how many there were
+func (db *DB) DeleteExpiredInvitations(ctx context.Context, cutoff time.Time) (int, error) {
+	var deleted int64
+	err := db.transact(ctx, func(tx *sqlx.Tx) error {
+		result, err := tx.ExecContext(ctx, `DELETE FROM invitations WHERE expires_at < $1`, cutoff.UTC())
+		if err != nil {
+			return err
+		}
+		deleted, err = result.RowsAffected()
+		return err
+	})
+	return int(deleted), err
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// handleCreateInvitation invites someone to join as a sub-account. The
+// invitation is emailed when the server has a mailer; otherwise its token
+// is in the response, for the caller to deliver.
+func (s *Server) handleCreateInvitation(w http.ResponseWriter, r *http.Request) {
+	orgID := pathOrgID(r)
+	inviter, _ := GetUserFromContext(r.Context())
+
+	var req AddUserRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	err := joinValidationErrors(ValidateAddUserRequest(&req), s.checkInvitation(r.Context(), orgID, req.Email))
+	var valErr *ValidationError
+	switch {
+	case err == nil:
+	case errors.As(err, &valErr):
+		writeValidationError(w, err)
+		return
+	default:
+		s.logger.ErrorContext(r.Context(), "failed to get organization settings", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	req.Email = s.emailPolicy.Normalize(req.Email)
+
+	existing, err := s.store.GetUserByEmail(r.Context(), req.Email)
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to look up invitee", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if existing != nil {
+		http.Error(w, ErrEmailTaken.Error(), http.StatusConflict)
+		return
+	}
+
+	inv, err := s.invitations.Create(r.Context(), orgID, inviter.ID, req.Email, req.Name)
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to create invitation", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	s.recordAudit(r, "invitation.created", orgID, inv.ID.String(), AuditMetadata{
+		"expires_at": inv.ExpiresAt.Format(time.RFC3339),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(inv)
+}
+
+func (s *Server) handleListInvitations(w http.ResponseWriter, r *http.Request) {
+	invitations, err := s.store.ListInvitations(r.Context(), pathOrgID(r))
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to list invitations", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invitations)
+}
+
+// handleDeleteInvitation revokes an invitation before it is accepted
+func (s *Server) handleDeleteInvitation(w http.ResponseWriter, r *http.Request) {
+	orgID := pathOrgID(r)
+	invitationID, err := uuid.Parse(r.PathValue("invitationID"))
+	if err != nil {
+		http.Error(w, "Invalid invitation ID format", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.store.DeleteInvitation(r.Context(), orgID, invitationID); err != nil {
+		switch err {
+		case ErrInvitationNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			s.logger.ErrorContext(r.Context(), "failed to delete invitation", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	s.recordAudit(r, "invitation.revoked", orgID, invitationID.String(), nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAcceptInvitation adds the invitee to the organization that invited
+// them. They then sign in like any other member.
+func (s *Server) handleAcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	var req AcceptInvitationRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Token == "" {
+		writeValidationError(w, &ValidationError{Field: "token", Message: ErrEmptyField.Error()})
+		return
+	}
+
+	user, err := s.acceptInvitation(r, req.Token)
+	if err != nil {
+		status := invitationErrorStatus(err)
+		if status == http.StatusInternalServerError {
+			s.logger.ErrorContext(r.Context(), "failed to accept invitation", "error", err)
+			http.Error(w, "Internal server error", status)
+			return
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// acceptInvitation adds the invitee token was sent to to their
+// organization and uses the invitation up
+func (s *Server) acceptInvitation(r *http.Request, token string) (*User, error) {
+	inv, err := s.invitations.Find(r.Context(), token)
+	if err != nil {
+		return nil, err
+	}
+
+	suspended, err := s.store.IsOrganizationSuspended(r.Context(), inv.OrganizationID)
+	if err != nil && err != ErrOrganizationNotFound {
+		return nil, err
+	}
+	if suspended {
+		return nil, ErrOrganizationSuspended
+	}
+
+	user, err := s.store.AddUserToOrganization(r.Context(), inv.OrganizationID, inv.Email, inv.Name)
+	if err == ErrOrganizationNotFound {
+		return nil, ErrInvitationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.store.DeleteInvitation(r.Context(), inv.OrganizationID, inv.ID); err != nil && err != ErrInvitationNotFound {
+		s.logger.ErrorContext(r.Context(), "failed to delete accepted invitation", "invitation_id", inv.ID, "error", err)
+	}
+
+	s.recordAudit(r, "invitation.accepted", inv.OrganizationID, inv.ID.String(), AuditMetadata{"user_id": user.ID.String()})
+	s.recordAudit(r, "user.added", inv.OrganizationID, user.ID.String(), AuditMetadata{"role": user.Role, "invitation_id": inv.ID.String()})
+	return user, nil
+}
+
+// invitationErrorStatus is the status answering an invitation that could
+// not be accepted for err
+func invitationErrorStatus(err error) int {
+	switch err {
+	case ErrInvitationNotFound:
+		return http.StatusNotFound
+	case ErrInvitationExpired:
+		return http.StatusGone
+	case ErrOrganizationSuspended, ErrMaxSubAccounts:
+		return http.StatusForbidden
+	case ErrEmailTaken:
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingMailer keeps the mail it is asked to send
+type recordingMailer struct {
+	mu   sync.Mutex
+	sent []Mail
+}
+
+func (m *recordingMailer) Send(ctx context.Context, msg *Mail) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, *msg)
+	return nil
+}
+
+func TestNewInvitationConfig(t *testing.T) {
+	config, err := NewInvitationConfig(func(string) string { return "" })
+	require.NoError(t, err)
+	require.Equal(t, 7*24*time.Hour, config.TTL)
+	require.Equal(t, 2, config.MaxReminders)
+
+	_, err = NewInvitationConfig(func(key string) string {
+		return map[string]string{
+			"INVITATION_TTL":           "0",
+			"INVITATION_MAX_REMINDERS": "-1",
+			"INVITATION_ACCEPT_URL":    "/accept",
+		}[key]
+	})
+	require.ErrorContains(t, err, "INVITATION_TTL")
+	require.ErrorContains(t, err, "INVITATION_MAX_REMINDERS")
+	require.ErrorContains(t, err, "INVITATION_ACCEPT_URL")
+
+	_, err = NewConfig(func(key string) string {
+		return map[string]string{"MAIL_SMTP_URL": "smtp://relay.example.com", "MAIL_FROM": "noreply@example.com"}[key]
+	})
+	require.ErrorContains(t, err, "INVITATION_ACCEPT_URL is required")
+}
+
+func TestInvitations(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	srv, err := NewServer(store, newTestConfig(t))
+	require.NoError(t, err)
+
+	org, err := store.CreateOrganization(ctx, "Acme", "owner@acme.test", "Owner")
+	require.NoError(t, err)
+	owner, err := store.GetUser(ctx, org.OwnerID)
+	require.NoError(t, err)
+	ownerToken, err := srv.tokenManager.GenerateToken(owner)
+	require.NoError(t, err)
+
+	do := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		if method != http.MethodGet {
+			req.Header.Set("Content-Type", "application/json")
+			addCSRFToken(t, srv, req)
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return w
+	}
+	invitations := "/organizations/" + org.ID.String() + "/invitations"
+	stats := func() *OrganizationStats {
+		stats, err := store.GetOrganizationStats(ctx, org.ID)
+		require.NoError(t, err)
+		return stats
+	}
+
+	require.Equal(t, http.StatusConflict, do(http.MethodPost, invitations, ownerToken, `{"email":"owner@acme.test","name":"Owner"}`).Code,
+		"existing users cannot be invited")
+
+	w := do(http.MethodPost, invitations, ownerToken, `{"email":"new@acme.test","name":"New"}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var created Invitation
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&created))
+	require.NotEmpty(t, created.Token, "without a mailer the token is returned")
+	require.WithinDuration(t, time.Now().Add(7*24*time.Hour), created.ExpiresAt, time.Minute)
+	require.Equal(t, 1, stats().PendingInvitations)
+
+	w = do(http.MethodGet, invitations, ownerToken, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NotContains(t, w.Body.String(), created.Token, "tokens are not listed")
+
+	accept := func(token string) *httptest.ResponseRecorder {
+		return do(http.MethodPost, "/invitations/accept", "", `{"token":"`+token+`"}`)
+	}
+	require.Equal(t, http.StatusNotFound, accept("wrong").Code)
+
+	t.Run("Expired invitations cannot be accepted, and are cleaned up", func(t *testing.T) {
+		store.invitations[created.ID].ExpiresAt = time.Now().Add(-time.Hour)
+		require.Equal(t, http.StatusGone, accept(created.Token).Code)
+		require.Equal(t, 1, stats().ExpiredInvitations)
+
+		require.NoError(t, srv.invitations.sweep(ctx))
+		require.Equal(t, 1, stats().ExpiredInvitations, "kept for the retention window")
+		store.invitations[created.ID].ExpiresAt = time.Now().Add(-31 * 24 * time.Hour)
+		require.NoError(t, srv.invitations.sweep(ctx))
+		require.Equal(t, 0, stats().ExpiredInvitations)
+	})
+
+	t.Run("Accepting adds the member", func(t *testing.T) {
+		w := do(http.MethodPost, invitations, ownerToken, `{"email":"new@acme.test","name":"New"}`)
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		var inv Invitation
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&inv))
+
+		w = accept(inv.Token)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var user User
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&user))
+		require.Equal(t, org.ID, user.OrganizationID)
+		require.Equal(t, "sub_account", user.Role)
+		require.Equal(t, 0, stats().PendingInvitations)
+		require.Equal(t, http.StatusNotFound, accept(inv.Token).Code, "invitations are accepted once")
+	})
+
+	t.Run("Revoked invitations cannot be accepted", func(t *testing.T) {
+		w := do(http.MethodPost, invitations, ownerToken, `{"email":"other@acme.test","name":"Other"}`)
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		var inv Invitation
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&inv))
+
+		require.Equal(t, http.StatusNoContent, do(http.MethodDelete, invitations+"/"+inv.ID.String(), ownerToken, "").Code)
+		require.Equal(t, http.StatusNotFound, accept(inv.Token).Code)
+	})
+}
+
+// orphanedStore is a store whose organizations have all been deleted,
+// for jobs queued before the deletion
+type orphanedStore struct {
+	Store
+}
+
+func (orphanedStore) GetOrganization(ctx context.Context, id uuid.UUID) (*Organization, error) {
+	return nil, sql.ErrNoRows
+}
+
+func TestInvitationEmails(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	org, err := store.CreateOrganization(ctx, "Acme", "owner@acme.test", "Owner")
+	require.NoError(t, err)
+	branding := &Branding{ProductName: "Acme Portal", PrimaryColor: "#ff6600"}
+	_, err = store.UpdateOrganizationSettings(ctx, org.ID, org.Version, &OrganizationSettings{Branding: branding})
+	require.NoError(t, err)
+
+	mailer := &recordingMailer{}
+	config := &InvitationConfig{TTL: 7 * 24 * time.Hour, ReminderInterval: 48 * time.Hour, MaxReminders: 2,
+		Retention: 24 * time.Hour, AcceptURL: "https://app.example.com/accept?lang=en"}
+	jobs := &JobRunner{handlers: map[string]JobHandler{}, store: store, config: &JobConfig{MaxAttempts: 1}, now: time.Now}
+	now := time.Now()
+	invitations := &Invitations{store: store, jobs: jobs, mailer: mailer, config: config, now: func() time.Time { return now }}
+
+	inv, err := invitations.Create(ctx, org.ID, org.OwnerID, "new@acme.test", "New")
+	require.NoError(t, err)
+	require.Empty(t, inv.Token, "emailed tokens are not returned")
+
+	// Sends what was queued, as the job runner would
+	sendQueued := func() {
+		for _, job := range store.jobs {
+			if job.Kind == invitationEmailJob && job.Status == JobPending {
+				require.NoError(t, invitations.email(ctx, job.Payload))
+				job.Status = JobSucceeded
+			}
+		}
+	}
+	sendQueued()
+	require.Len(t, mailer.sent, 1)
+	require.Equal(t, "new@acme.test", mailer.sent[0].To)
+	require.Contains(t, mailer.sent[0].Subject, "Acme")
+	require.Equal(t, branding, mailer.sent[0].Branding)
+
+	link := mailer.sent[0].Body[strings.Index(mailer.sent[0].Body, "https://"):]
+	link = link[:strings.Index(link, "\n")]
+	u, err := url.Parse(link)
+	require.NoError(t, err)
+	require.Equal(t, "en", u.Query().Get("lang"))
+	first := u.Query().Get("token")
+	found, err := invitations.Find(ctx, first)
+	require.NoError(t, err)
+	require.Equal(t, inv.ID, found.ID)
+
+	// Reminders come every interval until the most have been sent
+	require.NoError(t, invitations.sweep(ctx))
+	sendQueued()
+	require.Len(t, mailer.sent, 1, "no reminder is due yet")
+	for range 3 {
+		now = now.Add(config.ReminderInterval)
+		require.NoError(t, invitations.sweep(ctx))
+		sendQueued()
+	}
+	require.Len(t, mailer.sent, 3)
+	require.Contains(t, mailer.sent[2].Subject, "Reminder")
+	_, err = invitations.Find(ctx, first)
+	require.ErrorIs(t, err, ErrInvitationNotFound, "each email replaces the token")
+
+	// Nothing is sent once the organization is gone
+	invitations.store = orphanedStore{store}
+	for _, job := range store.jobs {
+		if job.Kind == invitationEmailJob {
+			require.NoError(t, invitations.email(ctx, job.Payload))
+		}
+	}
+	require.Len(t, mailer.sent, 3)
+}
+
+func TestInvitationStore(t *testing.T) {
+	testdb := setupTestDB(t)
+	defer testdb.teardown(t)
+
+	ctx := context.Background()
+	org, err := testdb.DB.CreateOrganization(ctx, "Invitations Org", "owner@invitations.test", "Owner")
+	require.NoError(t, err)
+
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	next := now.Add(-time.Minute)
+	inv := &Invitation{
+		ID:             uuid.New(),
+		OrganizationID: org.ID,
+		Email:          "new@invitations.test",
+		Name:           "New",
+		InvitedBy:      org.OwnerID,
+		TokenHash:      HashToken("first"),
+		NextReminderAt: &next,
+		ExpiresAt:      now.Add(time.Hour),
+	}
+	require.NoError(t, testdb.DB.CreateInvitation(ctx, inv))
+
+	replacement := *inv
+	replacement.ID = uuid.New()
+	replacement.TokenHash = HashToken("second")
+	require.NoError(t, testdb.DB.CreateInvitation(ctx, &replacement))
+	listed, err := testdb.DB.ListInvitations(ctx, org.ID)
+	require.NoError(t, err)
+	require.Len(t, listed, 1, "inviting an address again replaces its invitation")
+	require.Equal(t, "new@invitations.test", listed[0].Email)
+
+	got, err := testdb.DB.GetInvitationByToken(ctx, HashToken("second"))
+	require.NoError(t, err)
+	require.Equal(t, replacement.ID, got.ID)
+	_, err = testdb.DB.GetInvitationByToken(ctx, HashToken("first"))
+	require.ErrorIs(t, err, ErrInvitationNotFound)
+
+	due, err := testdb.DB.ClaimDueInvitationReminders(ctx, now, time.Hour, 1)
+	require.NoError(t, err)
+	require.Equal(t, []uuid.UUID{replacement.ID}, due)
+	due, err = testdb.DB.ClaimDueInvitationReminders(ctx, now.Add(2*time.Hour), time.Hour, 1)
+	require.NoError(t, err)
+	require.Empty(t, due, "the last reminder schedules no other")
+
+	got, err = testdb.DB.SetInvitationToken(ctx, replacement.ID, HashToken("third"))
+	require.NoError(t, err)
+	require.Equal(t, 1, got.RemindersSent)
+
+	stats, err := testdb.DB.GetOrganizationStats(ctx, org.ID)
+	require.NoError(t, err)
+	require.Equal(t, 1, stats.PendingInvitations)
+
+	deleted, err := testdb.DB.DeleteExpiredInvitations(ctx, now.Add(2*time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 1, deleted)
+	require.ErrorIs(t, testdb.DB.DeleteInvitation(ctx, org.ID, replacement.ID), ErrInvitationNotFound)
+}
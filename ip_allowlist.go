@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// BreakGlassHeader lets an organization's owner through its IP allowlist
+// from anywhere, such as to fix a list that locks everyone out. It carries
+// the reason, which is audited with every request it is used on.
+const BreakGlassHeader = "X-Break-Glass"
+
+var ErrOutsideIPAllowlist = errors.New("request from outside the organization's IP allowlist")
+
+// IPAllowlists holds the IP allowlists organizations have set, reloading
+// them from the database once they are older than ttl
+type IPAllowlists struct {
+	mu       sync.Mutex
+	lists    map[uuid.UUID][]netip.Prefix
+	loadedAt time.Time
+	ttl      time.Duration
+	load     func(ctx context.Context) (map[uuid.UUID][]string, error)
+	logger   *slog.Logger
+	now      func() time.Time
+}
+
+func NewIPAllowlists(load func(ctx context.Context) (map[uuid.UUID][]string, error), ttl time.Duration, logger *slog.Logger) *IPAllowlists {
+	return &IPAllowlists{
+		ttl:    ttl,
+		load:   load,
+		logger: logger,
+		now:    time.Now,
+	}
+}
+
+// Allows reports whether members of an organization may call from ip. If
+// a reload fails the previous lists are kept until the next attempt; it
+// only returns an error when there are none to keep.
+func (a *IPAllowlists) Allows(ctx context.Context, orgID uuid.UUID, ip string) (bool, error) {
+	if a == nil {
+		return true, nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if now := a.now(); a.lists == nil || now.Sub(a.loadedAt) >= a.ttl {
+		// Whatever the outcome, wait a full ttl before querying again
+		a.loadedAt = now
+		lists, err := a.load(ctx)
+		if err != nil {
+			a.logger.ErrorContext(ctx, "failed to load organization IP allowlists", "error", err)
+			if a.lists == nil {
+				return false, err
+			}
+		} else {
+			a.lists = make(map[uuid.UUID][]netip.Prefix, len(lists))
+			for id, ranges := range lists {
+				for _, r := range ranges {
+					// Ranges are validated when saved
+					if prefix, err := netip.ParsePrefix(r); err == nil {
+						a.lists[id] = append(a.lists[id], prefix)
+					}
+				}
+			}
+		}
+	}
+
+	ranges, ok := a.lists[orgID]
+	if !ok {
+		return true, nil
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false, nil
+	}
+	addr = addr.Unmap()
+	for _, prefix := range ranges {
+		if prefix.Contains(addr) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Invalidate forces the next lookup to reload from the database
+func (a *IPAllowlists) Invalidate() {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	a.loadedAt = time.Time{}
+	a.mu.Unlock()
+}
+
+// checkIPAllowlist holds an authenticated request to the IP allowlist of
+// the user's organization, which r's context carries. It reports whether
+// the request may go ahead, having responded if not. Refused requests and
+// the owner's break-glass requests are audited.
+func (am *AuthMiddleware) checkIPAllowlist(w http.ResponseWriter, r *http.Request, user *User) bool {
+	ip := GetClientIPFromContext(r.Context())
+	allowed, err := am.allowlists.Allows(r.Context(), user.OrganizationID, ip)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return false
+	}
+	if allowed {
+		return true
+	}
+
+	if reason := r.Header.Get(BreakGlassHeader); reason != "" && user.Role == "owner" {
+		am.audit(r, "auth.ip_allowlist_break_glass", user.OrganizationID, user.ID.String(), AuditMetadata{
+			"reason": reason,
+			"method": r.Method,
+			"path":   r.URL.Path,
+		})
+		return true
+	}
+
+	am.audit(r, "auth.ip_allowlist_denied", user.OrganizationID, user.ID.String(), AuditMetadata{
+		"method": r.Method,
+		"path":   r.URL.Path,
+	})
+	am.events.Log(r, AuthEventPermissionDenied, user, AuthReasonOutsideIPAllowlist, "path", r.URL.Path)
+	http.Error(w, ErrOutsideIPAllowlist.Error(), http.StatusForbidden)
+	return false
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIPAllowlists(t *testing.T) {
+	orgID, other := uuid.New(), uuid.New()
+	loads := 0
+	lists := map[uuid.UUID][]string{orgID: {"203.0.113.0/24", "2001:db8::/32"}}
+	var loadErr error
+	allowlists := NewIPAllowlists(func(ctx context.Context) (map[uuid.UUID][]string, error) {
+		loads++
+		return lists, loadErr
+	}, time.Minute, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	now := time.Now()
+	allowlists.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	allows := func(orgID uuid.UUID, ip string) bool {
+		allowed, err := allowlists.Allows(ctx, orgID, ip)
+		require.NoError(t, err)
+		return allowed
+	}
+	require.True(t, allows(orgID, "203.0.113.7"))
+	require.True(t, allows(orgID, "::ffff:203.0.113.7"), "IPv4-mapped addresses match IPv4 ranges")
+	require.True(t, allows(orgID, "2001:db8::1"))
+	require.False(t, allows(orgID, "198.51.100.1"))
+	require.False(t, allows(orgID, ""), "requests without an address are refused")
+	require.True(t, allows(other, "198.51.100.1"), "organizations without a list allow any address")
+	require.Equal(t, 1, loads)
+
+	// Invalidate reloads on the next check
+	lists = map[uuid.UUID][]string{}
+	allowlists.Invalidate()
+	require.True(t, allows(orgID, "198.51.100.1"))
+	require.Equal(t, 2, loads)
+
+	// A failed reload keeps the previous lists
+	loadErr = errors.New("database unavailable")
+	now = now.Add(time.Minute)
+	require.True(t, allows(orgID, "198.51.100.1"))
+
+	var none *IPAllowlists
+	require.True(t, func() bool { ok, _ := none.Allows(ctx, orgID, ""); return ok }())
+}
+
+func TestIPAllowlistPolicy(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	srv, err := NewServer(store, newTestConfig(t))
+	require.NoError(t, err)
+
+	var audited []string
+	srv.auth.audit = func(r *http.Request, action string, orgID uuid.UUID, targetID string, metadata AuditMetadata) {
+		audited = append(audited, action)
+	}
+
+	org, err := store.CreateOrganization(ctx, "Acme", "owner@acme.test", "Owner")
+	require.NoError(t, err)
+	owner, err := store.GetUser(ctx, org.OwnerID)
+	require.NoError(t, err)
+	ownerToken, err := srv.tokenManager.GenerateToken(owner)
+	require.NoError(t, err)
+	member, err := store.AddUserToOrganization(ctx, org.ID, "admin@acme.test", "Admin")
+	require.NoError(t, err)
+	member, err = store.UpdateUserRole(ctx, org.ID, member.ID, "admin")
+	require.NoError(t, err)
+	store.users[member.ID].Permissions[string(PermManageSettings)] = true
+	memberToken, err := srv.tokenManager.GenerateToken(member)
+	require.NoError(t, err)
+
+	do := func(method, token, remoteAddr string, body any, header http.Header) *httptest.ResponseRecorder {
+		var data []byte
+		if body != nil {
+			data, err = json.Marshal(body)
+			require.NoError(t, err)
+		}
+		req := httptest.NewRequest(method, "/organizations/"+org.ID.String()+"/settings", bytes.NewReader(data))
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		if method != http.MethodGet {
+			_, version, err := store.GetOrganizationSettings(ctx, org.ID)
+			require.NoError(t, err)
+			req.Header.Set("If-Match", versionETag(version))
+			addCSRFToken(t, srv, req)
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return w
+	}
+	const office, elsewhere = "203.0.113.7:4000", "198.51.100.1:4000"
+
+	t.Run("Invalid ranges are rejected", func(t *testing.T) {
+		w := do(http.MethodPut, ownerToken, office, OrganizationSettings{IPAllowlist: []string{"203.0.113.7"}}, nil)
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("Only the owner sets the allowlist", func(t *testing.T) {
+		settings := OrganizationSettings{IPAllowlist: []string{"203.0.113.0/24"}}
+		w := do(http.MethodPut, memberToken, office, settings, nil)
+		require.Equal(t, http.StatusForbidden, w.Code)
+
+		w = do(http.MethodPut, ownerToken, office, settings, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		// Other settings can still be changed by admins
+		settings.AllowedOrigins = []string{"https://app.acme.test"}
+		w = do(http.MethodPut, memberToken, office, settings, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	})
+
+	t.Run("Members are refused outside the ranges", func(t *testing.T) {
+		audited = nil
+		require.Equal(t, http.StatusOK, do(http.MethodGet, memberToken, office, nil, nil).Code)
+		require.Equal(t, http.StatusForbidden, do(http.MethodGet, memberToken, elsewhere, nil, nil).Code)
+		require.Equal(t, http.StatusForbidden, do(http.MethodGet, ownerToken, elsewhere, nil, nil).Code)
+		require.Equal(t, []string{"auth.ip_allowlist_denied", "auth.ip_allowlist_denied"}, audited)
+	})
+
+	t.Run("The owner can break the glass", func(t *testing.T) {
+		audited = nil
+		breakGlass := http.Header{BreakGlassHeader: {"office network down"}}
+		require.Equal(t, http.StatusForbidden, do(http.MethodGet, memberToken, elsewhere, nil, breakGlass).Code)
+
+		w := do(http.MethodPut, ownerToken, elsewhere, OrganizationSettings{}, breakGlass)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Equal(t, []string{"auth.ip_allowlist_denied", "auth.ip_allowlist_break_glass"}, audited)
+
+		require.Equal(t, http.StatusOK, do(http.MethodGet, memberToken, elsewhere, nil, nil).Code)
+	})
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrJobNotFound = errors.New("job not found")
+	ErrJobNotDead  = errors.New("only dead jobs can be retried")
+)
+
+// Job states. A job that fails max_attempts times is dead: it stays in the
+// table for inspection and is only run again if an operator retries it.
+const (
+	JobPending   = "pending"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobDead      = "dead"
+)
+
+// JobStatuses lists every job state
+var JobStatuses = []string{JobPending, JobRunning, JobSucceeded, JobDead}
+
+// Job is a unit of background work
+type Job struct {
+	ID          uuid.UUID       `db:"id" json:"id"`
+	Kind        string          `db:"kind" json:"kind"`
+	Payload     json.RawMessage `db:"payload" json:"payload"`
+	Status      string          `db:"status" json:"status"`
+	Attempts    int             `db:"attempts" json:"attempts"`
+	MaxAttempts int             `db:"max_attempts" json:"max_attempts"`
+	RunAt       time.Time       `db:"run_at" json:"run_at"`
+	LockedUntil *time.Time      `db:"locked_until" json:"locked_until,omitempty"`
+	LastError   *string         `db:"last_error" json:"last_error,omitempty"`
+	CreatedAt   time.Time       `db:"created_at" json:"created_at"`
+	UpdatedAt   time.Time       `db:"updated_at" json:"updated_at"`
+}
+
+// JobHandler performs one kind of job. Returning an error schedules a retry.
+type JobHandler func(ctx context.Context, payload json.RawMessage) error
+
+// JobConfig sizes the worker pool and its retry policy
+type JobConfig struct {
+	Workers      int           // jobs run at the same time by this instance
+	PollInterval time.Duration // how often idle workers look for due jobs
+	Lease        time.Duration // how long a job may run before another worker takes it over
+	MaxAttempts  int           // runs before a failing job is dead
+	RetryBackoff time.Duration // delay before the first retry, doubled for each later one
+}
+
+// NewJobConfig creates a job configuration from settings
+func NewJobConfig(settings Settings) *JobConfig {
+	workers, err := strconv.Atoi(settings.get("JOB_WORKERS", "4"))
+	if err != nil || workers < 1 {
+		workers = 4
+	}
+
+	pollInterval, err := time.ParseDuration(settings.get("JOB_POLL_INTERVAL", "1s"))
+	if err != nil || pollInterval <= 0 {
+		pollInterval = time.Second
+	}
+
+	lease, err := time.ParseDuration(settings.get("JOB_LEASE", "5m"))
+	if err != nil || lease <= 0 {
+		lease = 5 * time.Minute
+	}
+
+	maxAttempts, err := strconv.Atoi(settings.get("JOB_MAX_ATTEMPTS", "5"))
+	if err != nil || maxAttempts < 1 {
+		maxAttempts = 5
+	}
+
+	return &JobConfig{
+		Workers:      workers,
+		PollInterval: pollInterval,
+		Lease:        lease,
+		MaxAttempts:  maxAttempts,
+		RetryBackoff: 10 * time.Second,
+	}
+}
+
+// maxJobBackoff caps the delay between retries of a failing job
+const maxJobBackoff = time.Hour
+
+// JobRunner runs queued jobs on a pool of workers. Jobs live in the store,
+// so any instance can pick up work enqueued by another, and a job whose
+// worker dies is taken over once its lease runs out.
+type JobRunner struct {
+	store  JobStore
+	config *JobConfig
+	logger *slog.Logger
+
+	mu       sync.RWMutex
+	handlers map[string]JobHandler
+
+	slots     chan struct{} // one per busy worker
+	inFlight  sync.WaitGroup
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	now       func() time.Time
+}
+
+func NewJobRunner(store JobStore, config *JobConfig, logger *slog.Logger) *JobRunner {
+	r := &JobRunner{
+		store:    store,
+		config:   config,
+		logger:   logger,
+		handlers: make(map[string]JobHandler),
+		slots:    make(chan struct{}, config.Workers),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		now:      time.Now,
+	}
+	go r.run()
+	return r
+}
+
+// Register sets the handler for jobs of kind
+func (r *JobRunner) Register(kind string, handler JobHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[kind] = handler
+}
+
+// Enqueue stores a job of kind with payload encoded as JSON. It runs as soon
+// as a worker is free, or not before runAt if that is set.
+func (r *JobRunner) Enqueue(ctx context.Context, kind string, payload interface{}, runAt time.Time) (*Job, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("encoding %s job payload: %w", kind, err)
+	}
+	if runAt.IsZero() {
+		runAt = r.now()
+	}
+
+	job := &Job{
+		ID:          uuid.New(),
+		Kind:        kind,
+		Payload:     data,
+		Status:      JobPending,
+		MaxAttempts: r.config.MaxAttempts,
+		RunAt:       runAt.UTC(),
+	}
+	if err := r.store.EnqueueJob(ctx, job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// Close stops taking new jobs and waits for running ones to finish. Jobs
+// still running when ctx expires are picked up again after their lease.
+func (r *JobRunner) Close(ctx context.Context) error {
+	r.closeOnce.Do(func() { close(r.stop) })
+	select {
+	case <-r.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *JobRunner) run() {
+	defer close(r.done)
+	defer r.inFlight.Wait()
+
+	ticker := time.NewTicker(r.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			r.poll()
+		}
+	}
+}
+
+// poll claims as many due jobs as there are idle workers and starts them
+func (r *JobRunner) poll() {
+	idle := cap(r.slots) - len(r.slots)
+	if idle == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	jobs, err := r.store.ClaimJobs(ctx, idle, r.config.Lease)
+	cancel()
+	if err != nil {
+		r.logger.Error("failed to claim jobs", "error", err)
+		return
+	}
+
+	for _, job := range jobs {
+		r.slots <- struct{}{}
+		r.inFlight.Add(1)
+		go func(job Job) {
+			defer func() {
+				<-r.slots
+				r.inFlight.Done()
+			}()
+			r.execute(job)
+		}(job)
+	}
+}
+
+// execute runs a claimed job and records the outcome
+func (r *JobRunner) execute(job Job) {
+	// Finish before the lease runs out so no other worker starts the job too
+	ctx, cancel := context.WithTimeout(context.Background(), r.config.Lease)
+	defer cancel()
+
+	err := r.handle(ctx, job)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err == nil {
+		if err := r.store.CompleteJob(ctx, job.ID); err != nil {
+			r.logger.Error("failed to complete job", "job_id", job.ID, "kind", job.Kind, "error", err)
+		}
+		return
+	}
+
+	logger := r.logger.With("job_id", job.ID, "kind", job.Kind, "attempt", job.Attempts, "error", err)
+	if job.Attempts >= job.MaxAttempts {
+		logger.Error("job failed for the last time")
+	} else {
+		logger.Warn("job failed, will retry")
+	}
+	if err := r.store.FailJob(ctx, job.ID, err.Error(), r.now().Add(r.backoff(job.Attempts)).UTC()); err != nil {
+		r.logger.Error("failed to record job failure", "job_id", job.ID, "kind", job.Kind, "error", err)
+	}
+}
+
+// handle calls the job's handler, turning a panic into an error
+func (r *JobRunner) handle(ctx context.Context, job Job) (err error) {
+	r.mu.RLock()
+	handler, ok := r.handlers[job.Kind]
+	r.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("no handler registered for %q jobs", job.Kind)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("job panicked: %v", p)
+		}
+	}()
+	return handler(ctx, job.Payload)
+}
+
+// backoff returns how long to wait before running a job again after its
+// nth attempt failed, using exponential backoff with full jitter
+func (r *JobRunner) backoff(attempt int) time.Duration {
+	delay := r.config.RetryBackoff
+	for i := 1; i < attempt && delay < maxJobBackoff; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxJobBackoff)
+	return rand.N(delay) + 1
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+const jobColumns = `id, kind, payload, status, attempts, max_attempts, run_at, locked_until, last_error, created_at, updated_at`
+
+// EnqueueJob stores a new pending job
+func (db *DB) EnqueueJob(ctx context.Context, job *Job) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO jobs (id, kind, payload, status, max_attempts, run_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, job.ID, job.Kind, job.Payload, job.Status, job.MaxAttempts, job.RunAt)
+	return err
+}
+
+// ClaimJobs marks up to limit due jobs as running for lease and returns
+// them. Running jobs whose lease has expired are due again. SKIP LOCKED
+// lets workers on every instance claim at the same time without sharing a job.
+func (db *DB) ClaimJobs(ctx context.Context, limit int, lease time.Duration) ([]Job, error) {
+	jobs := []Job{}
+	err := db.SelectContext(ctx, &jobs, `
+		UPDATE jobs
+		SET status = 'running', attempts = attempts + 1,
+			locked_until = NOW() + make_interval(secs => $2), updated_at = NOW()
+		WHERE id IN (
+			SELECT id FROM jobs
+			WHERE (status = 'pending' AND run_at <= NOW())
+			   OR (status = 'running' AND locked_until < NOW())
+			ORDER BY run_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+jobColumns, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// CompleteJob records that a job succeeded
+func (db *DB) CompleteJob(ctx context.Context, id uuid.UUID) error {
+	_, err := db.ExecContext(ctx, `
+		UPDATE jobs
+		SET status = 'succeeded', locked_until = NULL, last_error = NULL, updated_at = NOW()
+		WHERE id = $1
+	`, id)
+	return err
+}
+
+// FailJob records a failed attempt. The job runs again at retryAt unless it
+// has used up its attempts, in which case it is dead.
+func (db *DB) FailJob(ctx context.Context, id uuid.UUID, lastError string, retryAt time.Time) error {
+	_, err := db.ExecContext(ctx, `
+		UPDATE jobs
+		SET status = CASE WHEN attempts >= max_attempts THEN 'dead' ELSE 'pending' END,
+			run_at = $3, locked_until = NULL, last_error = $2, updated_at = NOW()
+		WHERE id = $1
+	`, id, lastError, retryAt)
+	return err
+}
+
+// GetJob retrieves a job by ID
+func (db *DB) GetJob(ctx context.Context, id uuid.UUID) (*Job, error) {
+	job := &Job{}
+	err := db.GetContext(ctx, job, `SELECT `+jobColumns+` FROM jobs WHERE id = $1`, id)
+	if err == sql.ErrNoRows {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// ListJobs retrieves a page of jobs, newest first, optionally only those in
+// status, and how many there are in total
+func (db *DB) ListJobs(ctx context.Context, status string, limit, offset int) ([]Job, int, error) {
+	var rows []struct {
+		Job
+		Total int `db:"total"`
+	}
+	err := db.read(ctx, func(q sqlx.QueryerContext) error {
+		return sqlx.SelectContext(ctx, q, &rows, `
+			SELECT `+jobColumns+`, COUNT(*) OVER () AS total
+			FROM jobs
+			WHERE $1 = '' OR status = $1
+			ORDER BY created_at DESC, id
+			LIMIT $2 OFFSET $3
+		`, status, limit, offset)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	jobs := make([]Job, len(rows))
+	total := 0
+	for i, row := range rows {
+		jobs[i], total = row.Job, row.Total
+	}
+	return jobs, total, nil
+}
+
+// RetryJob gives a dead job a fresh set of attempts, starting now
+func (db *DB) RetryJob(ctx context.Context, id uuid.UUID) (*Job, error) {
+	job := &Job{}
+	err := db.transact(ctx, func(tx *sqlx.Tx) error {
+		var status string
+		err := tx.GetContext(ctx, &status, `SELECT status FROM jobs WHERE id = $1 FOR UPDATE`, id)
+		if err == sql.ErrNoRows {
+			return ErrJobNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if status != JobDead {
+			return ErrJobNotDead
+		}
+
+		return tx.GetContext(ctx, job, `
+			UPDATE jobs
+			SET status = 'pending', attempts = 0, run_at = NOW(), updated_at = NOW()
+			WHERE id = $1
+			RETURNING `+jobColumns, id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
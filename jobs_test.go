@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewJobConfig(t *testing.T) {
+	config := NewJobConfig(os.Getenv)
+	require.Equal(t, 4, config.Workers)
+	require.Equal(t, time.Second, config.PollInterval)
+	require.Equal(t, 5*time.Minute, config.Lease)
+	require.Equal(t, 5, config.MaxAttempts)
+
+	t.Setenv("JOB_WORKERS", "16")
+	t.Setenv("JOB_MAX_ATTEMPTS", "0")
+	config = NewJobConfig(os.Getenv)
+	require.Equal(t, 16, config.Workers)
+	require.Equal(t, 5, config.MaxAttempts, "invalid values fall back to the default")
+}
+
+func TestJobRunner(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	runner := NewJobRunner(store, &JobConfig{
+		Workers:      2,
+		PollInterval: 5 * time.Millisecond,
+		Lease:        time.Minute,
+		MaxAttempts:  3,
+		RetryBackoff: time.Millisecond,
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	defer runner.Close(ctx)
+
+	waitForStatus := func(t *testing.T, id uuid.UUID, status string) *Job {
+		t.Helper()
+		var job *Job
+		require.Eventually(t, func() bool {
+			var err error
+			job, err = store.GetJob(ctx, id)
+			require.NoError(t, err)
+			return job.Status == status
+		}, 5*time.Second, 5*time.Millisecond)
+		return job
+	}
+
+	t.Run("Runs jobs with their payload", func(t *testing.T) {
+		received := make(chan string, 1)
+		runner.Register("greet", func(ctx context.Context, payload json.RawMessage) error {
+			var name string
+			if err := json.Unmarshal(payload, &name); err != nil {
+				return err
+			}
+			received <- name
+			return nil
+		})
+
+		job, err := runner.Enqueue(ctx, "greet", "world", time.Time{})
+		require.NoError(t, err)
+		require.Equal(t, "world", <-received)
+
+		job = waitForStatus(t, job.ID, JobSucceeded)
+		require.Equal(t, 1, job.Attempts)
+	})
+
+	t.Run("Retries failures until the job succeeds", func(t *testing.T) {
+		var calls atomic.Int32
+		runner.Register("flaky", func(ctx context.Context, payload json.RawMessage) error {
+			if calls.Add(1) < 2 {
+				return errors.New("temporarily unavailable")
+			}
+			return nil
+		})
+
+		job, err := runner.Enqueue(ctx, "flaky", nil, time.Time{})
+		require.NoError(t, err)
+
+		job = waitForStatus(t, job.ID, JobSucceeded)
+		require.Equal(t, 2, job.Attempts)
+	})
+
+	t.Run("Dead-letters jobs that keep failing", func(t *testing.T) {
+		runner.Register("broken", func(ctx context.Context, payload json.RawMessage) error {
+			panic("boom")
+		})
+
+		job, err := runner.Enqueue(ctx, "broken", nil, time.Time{})
+		require.NoError(t, err)
+
+		job = waitForStatus(t, job.ID, JobDead)
+		require.Equal(t, 3, job.Attempts)
+		require.Contains(t, *job.LastError, "boom")
+
+		dead, _, err := store.ListJobs(ctx, JobDead, 10, 0)
+		require.NoError(t, err)
+		require.Len(t, dead, 1)
+
+		// Retrying gives the job a fresh set of attempts
+		runner.Register("broken", func(ctx context.Context, payload json.RawMessage) error { return nil })
+		_, err = store.RetryJob(ctx, job.ID)
+		require.NoError(t, err)
+		waitForStatus(t, job.ID, JobSucceeded)
+
+		_, err = store.RetryJob(ctx, job.ID)
+		require.ErrorIs(t, err, ErrJobNotDead)
+	})
+
+	t.Run("Unknown kinds fail", func(t *testing.T) {
+		job, err := runner.Enqueue(ctx, "unknown", nil, time.Time{})
+		require.NoError(t, err)
+
+		job = waitForStatus(t, job.ID, JobDead)
+		require.Contains(t, *job.LastError, "no handler registered")
+	})
+
+	t.Run("Scheduled jobs wait for their time", func(t *testing.T) {
+		runner.Register("later", func(ctx context.Context, payload json.RawMessage) error { return nil })
+
+		job, err := runner.Enqueue(ctx, "later", nil, time.Now().Add(time.Hour))
+		require.NoError(t, err)
+
+		time.Sleep(50 * time.Millisecond)
+		job, err = store.GetJob(ctx, job.ID)
+		require.NoError(t, err)
+		require.Equal(t, JobPending, job.Status)
+		require.Zero(t, job.Attempts)
+	})
+}
+
+func TestJobRunnerBackoff(t *testing.T) {
+	runner := &JobRunner{config: &JobConfig{RetryBackoff: 10 * time.Second}}
+	for attempt := 1; attempt <= 20; attempt++ {
+		delay := runner.backoff(attempt)
+		require.Positive(t, delay)
+		require.LessOrEqual(t, delay, min(10*time.Second<<(attempt-1), maxJobBackoff))
+	}
+}
+
+func TestJobStore(t *testing.T) {
+	testdb := setupTestDB(t)
+	defer testdb.teardown(t)
+
+	ctx := context.Background()
+
+	job := &Job{
+		ID:          uuid.New(),
+		Kind:        "export",
+		Payload:     json.RawMessage(`{"organization_id":"acme"}`),
+		Status:      JobPending,
+		MaxAttempts: 2,
+		RunAt:       time.Now().UTC().Add(-time.Second),
+	}
+	require.NoError(t, testdb.DB.EnqueueJob(ctx, job))
+
+	claimed, err := testdb.DB.ClaimJobs(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+	require.Equal(t, JobRunning, claimed[0].Status)
+	require.Equal(t, 1, claimed[0].Attempts)
+	require.JSONEq(t, `{"organization_id":"acme"}`, string(claimed[0].Payload))
+
+	claimed, err = testdb.DB.ClaimJobs(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Empty(t, claimed, "a leased job is not handed out twice")
+
+	require.NoError(t, testdb.DB.FailJob(ctx, job.ID, "timeout", time.Now().UTC().Add(-time.Second)))
+	claimed, err = testdb.DB.ClaimJobs(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+
+	require.NoError(t, testdb.DB.FailJob(ctx, job.ID, "timeout", time.Now().UTC()))
+	stored, err := testdb.DB.GetJob(ctx, job.ID)
+	require.NoError(t, err)
+	require.Equal(t, JobDead, stored.Status)
+	require.Equal(t, "timeout", *stored.LastError)
+
+	retried, err := testdb.DB.RetryJob(ctx, job.ID)
+	require.NoError(t, err)
+	require.Equal(t, JobPending, retried.Status)
+	require.Zero(t, retried.Attempts)
+
+	jobs, _, err := testdb.DB.ListJobs(ctx, JobPending, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+
+	_, err = testdb.DB.GetJob(ctx, uuid.New())
+	require.ErrorIs(t, err, ErrJobNotFound)
+}
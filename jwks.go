@@ -9,6 +9,9 @@ import (
 	"net/http"
 )
 
+// jwksKeyID names the signing key in the JWKS
+const jwksKeyID = "default-key"
+
 // JWKS represents a JSON Web Key Set
 type JWKS struct {
 	Keys []JWK `json:"keys"`
@@ -58,18 +61,13 @@ func rsaPublicKeyToJWK(publicKey *rsa.PublicKey, kid string) (*JWK, error) {
 
 // Add JWKSHandler to Server struct
 func (s *Server) handleJWKS(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return
-	}
-
 	// Get public key from token manager
 	publicKey := s.tokenManager.GetPublicKey()
 
 	// Convert to JWK
-	jwk, err := rsaPublicKeyToJWK(publicKey, "default-key")
+	jwk, err := rsaPublicKeyToJWK(publicKey, jwksKeyID)
 	if err != nil {
-		s.logger.Error("failed to convert public key to JWK", "error", err)
+		s.logger.ErrorContext(r.Context(), "failed to convert public key to JWK", "error", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
@@ -85,7 +83,7 @@ func (s *Server) handleJWKS(w http.ResponseWriter, r *http.Request) {
 
 	// Write response
 	if err := json.NewEncoder(w).Encode(jwks); err != nil {
-		s.logger.Error("failed to encode JWKS response", "error", err)
+		s.logger.ErrorContext(r.Context(), "failed to encode JWKS response", "error", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
@@ -13,7 +13,7 @@ func TestJWKS(t *testing.T) {
 	testdb := setupTestDB(t)
 	defer testdb.teardown(t)
 
-	srv, err := NewServer(testdb.DB)
+	srv, err := NewServer(testdb.DB, newTestConfig(t))
 	require.NoError(t, err)
 
 	t.Run("JWKS Endpoint", func(t *testing.T) {
@@ -3,6 +3,9 @@ package main
 import (
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"time"
 
@@ -15,6 +18,15 @@ type Claims struct {
 	UserID         uuid.UUID `json:"user_id"`
 	OrganizationID uuid.UUID `json:"organization_id"`
 	Role           string    `json:"role"`
+	// Scope is set on tokens issued to OIDC clients, which carry the
+	// client as their audience and are only accepted by /oidc/userinfo
+	Scope string `json:"scope,omitempty"`
+	// Guest tokens name no user: they carry the permissions they were
+	// granted, and the paths under their organization they may reach when
+	// restricted to some. Their jti is what revokes them.
+	Guest       bool         `json:"guest,omitempty"`
+	Permissions []Permission `json:"permissions,omitempty"`
+	Resources   []string     `json:"resources,omitempty"`
 }
 
 // Make sure Claims implements jwt.Claims interface
@@ -45,6 +57,7 @@ func (c Claims) GetAudience() (jwt.ClaimStrings, error) {
 type TokenManager struct {
 	privateKey *rsa.PrivateKey
 	publicKey  *rsa.PublicKey
+	accessTTL  time.Duration // how long GenerateToken's tokens last
 }
 
 func NewTokenManager() (*TokenManager, error) {
@@ -54,16 +67,54 @@ func NewTokenManager() (*TokenManager, error) {
 		return nil, fmt.Errorf("failed to generate RSA key: %w", err)
 	}
 
+	return NewTokenManagerWithKey(privateKey), nil
+}
+
+// NewTokenManagerWithKey signs with a key that outlives the process, so
+// tokens stay valid across restarts and between instances
+func NewTokenManagerWithKey(privateKey *rsa.PrivateKey) *TokenManager {
 	return &TokenManager{
 		privateKey: privateKey,
 		publicKey:  &privateKey.PublicKey,
-	}, nil
+		accessTTL:  DefaultAccessTokenTTL,
+	}
+}
+
+// parseRSAPrivateKey parses a PEM-encoded RSA key in PKCS #1 or PKCS #8 form
+func parseRSAPrivateKey(data string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	rsaKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		var ok bool
+		if rsaKey, ok = key.(*rsa.PrivateKey); !ok {
+			return nil, errors.New("not an RSA key")
+		}
+	}
+	if rsaKey.N.BitLen() < 2048 {
+		return nil, errors.New("RSA keys must be at least 2048 bits")
+	}
+	return rsaKey, nil
 }
 
+// GenerateToken issues an access token for user with the platform's
+// lifetime
 func (tm *TokenManager) GenerateToken(user *User) (string, error) {
+	return tm.GenerateTokenWithTTL(user, tm.accessTTL)
+}
+
+// GenerateTokenWithTTL issues an access token for user that lasts ttl, as
+// set by their organization's session policy
+func (tm *TokenManager) GenerateTokenWithTTL(user *User, ttl time.Duration) (string, error) {
 	claims := Claims{
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(15 * time.Minute)),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			NotBefore: jwt.NewNumericDate(time.Now()),
 		},
@@ -76,6 +127,35 @@ func (tm *TokenManager) GenerateToken(user *User) (string, error) {
 	return token.SignedString(tm.privateKey)
 }
 
+// GenerateGuestToken issues a guest token for orgID that lasts ttl, with
+// only perms, reaching only resources when any are given
+func (tm *TokenManager) GenerateGuestToken(orgID, id uuid.UUID, perms []Permission, resources []string, ttl time.Duration) (string, error) {
+	claims := Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        id.String(),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			NotBefore: jwt.NewNumericDate(time.Now()),
+		},
+		OrganizationID: orgID,
+		Role:           RoleGuest,
+		Guest:          true,
+		Permissions:    perms,
+		Resources:      resources,
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	return token.SignedString(tm.privateKey)
+}
+
+// Sign signs claims with the key published in the JWKS, naming it in the
+// kid header so relying parties can select it
+func (tm *TokenManager) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = jwksKeyID
+	return token.SignedString(tm.privateKey)
+}
+
 func (tm *TokenManager) ValidateToken(tokenString string) (*Claims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Directory sync speaks LDAP with go-ldap: a simple bind and a paged
+// subtree search.
+
+// ldapPageSize is the page size of searches. Active Directory returns at
+// most 1000 entries per search without paging.
+const ldapPageSize = 500
+
+// dialLDAP connects to an ldap:// or ldaps:// URL with dialer. The
+// connection gives up when ctx is done.
+func dialLDAP(ctx context.Context, rawURL string, dialer *net.Dialer) (*ldap.Conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	host, port := u.Hostname(), u.Port()
+	switch u.Scheme {
+	case "ldaps":
+		if port == "" {
+			port = ldap.DefaultLdapsPort
+		}
+	case "ldap":
+		if port == "" {
+			port = ldap.DefaultLdapPort
+		}
+	default:
+		return nil, fmt.Errorf("ldap: unsupported scheme %q", u.Scheme)
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "ldaps" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	l := ldap.NewConn(conn, u.Scheme == "ldaps")
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		l.SetTimeout(time.Until(deadline))
+	}
+	l.Start()
+	return l, nil
+}
+
+// searchLDAP returns the entries below baseDN matching filter, with the
+// given attributes, following the pages the server hands out. Referrals
+// to other servers are not followed.
+func searchLDAP(conn *ldap.Conn, baseDN, filter string, attributes []string) ([]*ldap.Entry, error) {
+	req := ldap.NewSearchRequest(baseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false, filter, attributes, nil)
+	result, err := conn.SearchWithPaging(req, ldapPageSize)
+	if err != nil {
+		return nil, err
+	}
+	return result.Entries, nil
+}
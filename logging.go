@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// logLevel is the minimum level of every logger in the process. Platform
+// admins can change it at runtime, on one instance at a time.
+var logLevel = new(slog.LevelVar)
+
+// Log formats, selected with LOG_FORMAT
+const (
+	LogFormatJSON = "json"
+	LogFormatText = "text" // easier to read in a terminal
+)
+
+// LogConfig holds the logging configuration
+type LogConfig struct {
+	Level      slog.Level
+	Format     string
+	RedactKeys []string // attribute keys whose values are never logged
+}
+
+// NewLogConfig creates a logging configuration from settings
+func NewLogConfig(settings Settings) (*LogConfig, error) {
+	config := &LogConfig{
+		Format: settings.get("LOG_FORMAT", profileFor(settings).LogFormat),
+		RedactKeys: splitList(settings.get("LOG_REDACT_KEYS",
+			"password,secret,token,authorization,cookie,api_key,client_secret")),
+	}
+	if err := config.Level.UnmarshalText([]byte(settings.get("LOG_LEVEL", "info"))); err != nil {
+		return nil, fmt.Errorf("invalid LOG_LEVEL %q: expected debug, info, warn or error", settings("LOG_LEVEL"))
+	}
+	if config.Format != LogFormatJSON && config.Format != LogFormatText {
+		return nil, fmt.Errorf("invalid LOG_FORMAT %q: expected json or text", config.Format)
+	}
+	return config, nil
+}
+
+// redactPattern matches attribute keys that are one of keys, or end in one
+// after a separator, as "access_token" does "token"
+func redactPattern(keys []string) *regexp.Regexp {
+	if len(keys) == 0 {
+		return nil
+	}
+	quoted := make([]string, len(keys))
+	for i, key := range keys {
+		quoted[i] = regexp.QuoteMeta(key)
+	}
+	return regexp.MustCompile(`(?i)(^|[_.-])(` + strings.Join(quoted, "|") + `)$`)
+}
+
+// NewLogger creates a logger to stdout at logLevel that adds request context
+// and redacts the values of the configured keys
+func NewLogger(config *LogConfig) *slog.Logger {
+	options := &slog.HandlerOptions{Level: logLevel}
+	if redact := redactPattern(config.RedactKeys); redact != nil {
+		options.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+			if redact.MatchString(a.Key) {
+				return slog.String(a.Key, "[REDACTED]")
+			}
+			return a
+		}
+	}
+	var handler slog.Handler = slog.NewJSONHandler(os.Stdout, options)
+	if config.Format == LogFormatText {
+		handler = slog.NewTextHandler(os.Stdout, options)
+	}
+	return slog.New(NewContextLogHandler(handler))
+}
+
+// LogLevelRequest changes the log level
+type LogLevelRequest struct {
+	Level string `json:"level"`
+}
+
+func (s *Server) handleAdminGetLogLevel(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(LogLevelRequest{Level: logLevel.Level().String()})
+}
+
+// handleAdminSetLogLevel changes the log level of the instance serving the
+// request until it restarts
+func (s *Server) handleAdminSetLogLevel(w http.ResponseWriter, r *http.Request) {
+	var req LogLevelRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(req.Level)); err != nil {
+		http.Error(w, "level: must be debug, info, warn or error", http.StatusBadRequest)
+		return
+	}
+
+	previous := logLevel.Level()
+	logLevel.Set(level)
+	s.logger.WarnContext(r.Context(), "log level changed", "from", previous, "to", level)
+	s.recordAudit(r, "platform.log_level_changed", uuid.Nil, "", AuditMetadata{
+		"from": previous.String(),
+		"to":   level.String(),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(LogLevelRequest{Level: level.String()})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewLogConfig(t *testing.T) {
+	config, err := NewLogConfig(os.Getenv)
+	require.NoError(t, err)
+	require.Equal(t, slog.LevelInfo, config.Level)
+	require.Contains(t, config.RedactKeys, "password")
+
+	t.Setenv("LOG_LEVEL", "debug")
+	t.Setenv("LOG_REDACT_KEYS", "ssn, dob")
+	config, err = NewLogConfig(os.Getenv)
+	require.NoError(t, err)
+	require.Equal(t, slog.LevelDebug, config.Level)
+	require.Equal(t, []string{"ssn", "dob"}, config.RedactKeys)
+
+	t.Setenv("LOG_LEVEL", "loud")
+	_, err = NewLogConfig(os.Getenv)
+	require.ErrorContains(t, err, "LOG_LEVEL")
+
+	t.Setenv("LOG_LEVEL", "")
+	t.Setenv("LOG_FORMAT", "xml")
+	_, err = NewLogConfig(os.Getenv)
+	require.ErrorContains(t, err, "LOG_FORMAT")
+}
+
+func TestRedactPattern(t *testing.T) {
+	redact := redactPattern([]string{"token", "password"})
+	for key, redacted := range map[string]bool{
+		"token":          true,
+		"access_token":   true,
+		"Password":       true,
+		"user.password":  true,
+		"refresh_tokens": false,
+		"token_count":    false,
+		"email":          false,
+	} {
+		require.Equal(t, redacted, redact.MatchString(key), key)
+	}
+	require.Nil(t, redactPattern(nil))
+}
+
+func TestLogLevelEndpoint(t *testing.T) {
+	srv, err := NewServer(nil, newTestConfig(t))
+	require.NoError(t, err)
+	defer logLevel.Set(logLevel.Level())
+
+	setLevel := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/admin/log-level", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		srv.handleAdminSetLogLevel(w, req)
+		return w
+	}
+
+	w := setLevel(`{"level":"debug"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, slog.LevelDebug, logLevel.Level())
+	require.True(t, srv.logger.Enabled(context.Background(), slog.LevelDebug), "the change applies to existing loggers")
+
+	w = httptest.NewRecorder()
+	srv.handleAdminGetLogLevel(w, httptest.NewRequest(http.MethodGet, "/admin/log-level", nil))
+	var resp LogLevelRequest
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	require.Equal(t, "DEBUG", resp.Level)
+
+	w = setLevel(`{"level":"loud"}`)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Equal(t, slog.LevelDebug, logLevel.Level())
+}
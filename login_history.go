@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// maxUserAgentLength bounds the user agent kept with each login
+const maxUserAgentLength = 256
+
+// LoginRecord is one of a user's sign-ins, as recorded in the audit log
+type LoginRecord struct {
+	Time     time.Time `json:"time"`
+	IP       string    `json:"ip,omitempty"`
+	Location string    `json:"location,omitempty"` // as reported by the edge proxy; see CLIENT_LOCATION_HEADER
+	Device   string    `json:"device,omitempty"`   // browser and operating system, from the user agent
+	Provider string    `json:"provider"`
+}
+
+// loginMetadata returns the audit metadata describing a sign-in through
+// provider, for the user's login history
+func loginMetadata(r *http.Request, provider string) AuditMetadata {
+	metadata := AuditMetadata{"provider": provider}
+	if ua := r.UserAgent(); ua != "" {
+		metadata["user_agent"] = truncate(ua, maxUserAgentLength)
+	}
+	if location := GetClientLocationFromContext(r.Context()); location != "" {
+		metadata["location"] = location
+	}
+	return metadata
+}
+
+// truncate cuts s to at most n bytes without splitting a character
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return strings.ToValidUTF8(s[:n], "")
+}
+
+// browserPatterns and osPatterns are checked in order, so browsers built on
+// another come before it: Edge and Opera before Chrome, Chrome before Safari
+var (
+	browserPatterns = []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"FxiOS/", "Firefox"},
+		{"CriOS/", "Chrome"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+	}
+	osPatterns = []struct{ token, name string }{
+		{"iPhone", "iOS"},
+		{"iPad", "iPadOS"},
+		{"Android", "Android"},
+		{"CrOS", "ChromeOS"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"Macintosh", "macOS"},
+		{"Linux", "Linux"},
+	}
+)
+
+// describeDevice summarises a user agent as "Browser on OS", leaving out
+// whichever part it does not recognise
+func describeDevice(userAgent string) string {
+	var browser, system string
+	for _, p := range browserPatterns {
+		if strings.Contains(userAgent, p.token) {
+			browser = p.name
+			break
+		}
+	}
+	for _, p := range osPatterns {
+		if strings.Contains(userAgent, p.token) {
+			system = p.name
+			break
+		}
+	}
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "":
+		return browser
+	default:
+		return system
+	}
+}
+
+// LoginHistory returns userID's sign-ins, newest first. Servers backed by an
+// in-memory store have no audit table and so no history.
+func (a *AuditLog) LoginHistory(ctx context.Context, userID uuid.UUID, limit, offset int) ([]LoginRecord, error) {
+	history := []LoginRecord{}
+	if !a.enabled() {
+		return history, nil
+	}
+
+	var events []AuditEvent
+	err := a.db.SelectContext(ctx, &events, `
+		SELECT id, organization_id, actor_id, action, target_id, metadata, created_at, prev_hash, hash
+		FROM audit_events
+		WHERE action = 'auth.login' AND target_id = $1
+		ORDER BY id DESC
+		LIMIT $2 OFFSET $3
+	`, userID.String(), limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, event := range events {
+		ip := event.Metadata["client_ip"]
+		if ip == "" {
+			ip = event.Metadata["remote_addr"]
+		}
+		history = append(history, LoginRecord{
+			Time:     event.CreatedAt,
+			IP:       ip,
+			Location: event.Metadata["location"],
+			Device:   describeDevice(event.Metadata["user_agent"]),
+			Provider: event.Metadata["provider"],
+		})
+	}
+	return history, nil
+}
+
+// handleGetLoginHistory lists the authenticated user's recent sign-ins, so
+// they can spot ones they do not recognise
+func (s *Server) handleGetLoginHistory(w http.ResponseWriter, r *http.Request) {
+	user, err := GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	limit, offset, ok := parsePagination(r)
+	if !ok {
+		http.Error(w, "Invalid pagination parameters", http.StatusBadRequest)
+		return
+	}
+
+	history, err := s.audit.LoginHistory(r.Context(), user.ID, limit, offset)
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to load login history", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(history)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDescribeDevice(t *testing.T) {
+	for userAgent, device := range map[string]string{
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.0.0":   "Edge on Windows",
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Safari/605.1.15":           "Safari on macOS",
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/120.0 Mobile Safari/604.1": "Chrome on iOS",
+		"Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0":                                                          "Firefox on Linux",
+		"Mozilla/5.0 (Linux; Android 14) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36":                    "Chrome on Android",
+		"Go-http-client/1.1": "",
+		"":                   "",
+	} {
+		require.Equal(t, device, describeDevice(userAgent), userAgent)
+	}
+}
+
+func TestLoginMetadata(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/auth/callback/google", nil)
+	req.Header.Set("User-Agent", "a"+strings.Repeat("é", maxUserAgentLength))
+	metadata := loginMetadata(req, "google")
+	require.Equal(t, "google", metadata["provider"])
+	require.Len(t, metadata["user_agent"], maxUserAgentLength-1, "long user agents are cut on a character boundary")
+	require.NotContains(t, metadata, "location")
+}
+
+func TestLoginHistory(t *testing.T) {
+	testdb := setupTestDB(t)
+	defer testdb.teardown(t)
+
+	ctx := context.Background()
+	org, err := testdb.DB.CreateOrganization(ctx, "Logins", "logins@example.com", "Logins")
+	require.NoError(t, err)
+	auditLog := NewAuditLog(testdb.DB, &AuditConfig{SigningKey: []byte("test-audit-signing-key")})
+
+	for _, event := range []*AuditEvent{
+		{Action: "auth.login", TargetID: org.OwnerID.String(), Metadata: AuditMetadata{"provider": "google", "client_ip": "198.51.100.1"}},
+		{Action: "auth.login_failed", TargetID: org.OwnerID.String(), Metadata: AuditMetadata{"provider": "google"}},
+		{Action: "auth.login", TargetID: org.OwnerID.String(), Metadata: AuditMetadata{
+			"provider":   "google",
+			"client_ip":  "203.0.113.7",
+			"location":   "NZ",
+			"user_agent": "Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0",
+		}},
+		{Action: "auth.login", TargetID: "someone-else", Metadata: AuditMetadata{"provider": "google"}},
+	} {
+		require.NoError(t, auditLog.Record(ctx, event))
+	}
+
+	history, err := auditLog.LoginHistory(ctx, org.OwnerID, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, history, 2, "only the user's successful logins")
+	require.Equal(t, LoginRecord{
+		Time:     history[0].Time,
+		IP:       "203.0.113.7",
+		Location: "NZ",
+		Device:   "Firefox on Linux",
+		Provider: "google",
+	}, history[0], "newest first")
+	require.Equal(t, "198.51.100.1", history[1].IP)
+
+	history, err = auditLog.LoginHistory(ctx, org.OwnerID, 10, 1)
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+}
+
+func TestGetLoginHistoryHandler(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	srv, err := NewServer(store, newTestConfig(t))
+	require.NoError(t, err)
+
+	org, err := store.CreateOrganization(ctx, "Acme", "owner@acme.test", "Owner")
+	require.NoError(t, err)
+	owner, err := store.GetUser(ctx, org.OwnerID)
+	require.NoError(t, err)
+	token, err := srv.tokenManager.GenerateToken(owner)
+	require.NoError(t, err)
+
+	get := func(path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return w
+	}
+
+	require.Equal(t, http.StatusUnauthorized, get("/me/login-history", "").Code)
+	require.Equal(t, http.StatusBadRequest, get("/me/login-history?limit=0", token).Code)
+
+	// A memory-backed server keeps no audit log, so there is no history
+	w := get("/me/login-history", token)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var history []LoginRecord
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&history))
+	require.NotNil(t, history)
+	require.Empty(t, history)
+}
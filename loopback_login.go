@@ -0,0 +1,179 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Native apps such as the CLI log in through the browser and receive the
+// result on a loopback redirect (RFC 8252). The login URL names the
+// redirect and a PKCE code challenge; after the Google callback the browser
+// is sent to the redirect with a one-time login code, which the app
+// exchanges for tokens at /auth/token with the challenge's verifier. Tokens
+// never pass through the browser, and an app that intercepts the redirect
+// cannot redeem the code without the verifier.
+
+var ErrInvalidLoopbackLogin = errors.New("invalid loopback login")
+
+// loginCodeTTL bounds how long a login code can be redeemed
+const loginCodeTTL = time.Minute
+
+// loopbackLogin is the part of a login request naming the native app's
+// loopback redirect
+type loopbackLogin struct {
+	RedirectURI   string `json:"r"`
+	CodeChallenge string `json:"c"`
+	State         string `json:"s,omitempty"` // the app's own state, returned on the redirect
+}
+
+// parseLoopbackLogin reads a loopback login from the login request's query,
+// returning nil if it has no redirect_uri
+func parseLoopbackLogin(q url.Values) (*loopbackLogin, error) {
+	redirect := q.Get("redirect_uri")
+	if redirect == "" {
+		return nil, nil
+	}
+
+	u, err := url.Parse(redirect)
+	if err != nil || u.Scheme != "http" || u.Port() == "" || u.User != nil || u.Fragment != "" {
+		return nil, ErrInvalidLoopbackLogin
+	}
+	// Only loopback addresses, so codes can only be sent to this machine
+	if !isLoopbackHost(u.Hostname()) {
+		return nil, ErrInvalidLoopbackLogin
+	}
+
+	challenge := q.Get("code_challenge")
+	if q.Get("code_challenge_method") != "S256" || len(challenge) != 43 {
+		return nil, ErrInvalidLoopbackLogin
+	}
+	if _, err := base64.RawURLEncoding.DecodeString(challenge); err != nil {
+		return nil, ErrInvalidLoopbackLogin
+	}
+	return &loopbackLogin{RedirectURI: redirect, CodeChallenge: challenge, State: q.Get("state")}, nil
+}
+
+// The loopback login travels in the OAuth state, after a separator that
+// base64 state never contains. The whole state is stored when the login
+// starts and must match on the callback, so it cannot be altered.
+const loopbackStateSeparator = "~"
+
+func (l *loopbackLogin) appendTo(state string) string {
+	data, _ := json.Marshal(l)
+	return state + loopbackStateSeparator + base64.RawURLEncoding.EncodeToString(data)
+}
+
+// loopbackFromState returns the loopback login carried in a validated
+// state, if any
+func loopbackFromState(state string) *loopbackLogin {
+	_, encoded, ok := strings.Cut(state, loopbackStateSeparator)
+	if !ok {
+		return nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil
+	}
+	var l loopbackLogin
+	if json.Unmarshal(data, &l) != nil {
+		return nil
+	}
+	return &l
+}
+
+// loginCodeKey is the state store key of a login code. The key covers the
+// code challenge, so only the holder of its verifier can find it.
+func loginCodeKey(code, challenge string) string {
+	sum := sha256.Sum256([]byte(code + "." + challenge))
+	return "login-code:" + hex.EncodeToString(sum[:])
+}
+
+// redirectToLoopback issues a login code for user and sends the browser to
+// the app's redirect with it
+func (s *Server) redirectToLoopback(w http.ResponseWriter, r *http.Request, l *loopbackLogin, user *User) {
+	secret, err := generateState()
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to generate login code", "error", err)
+		http.Error(w, "Authentication failed", http.StatusInternalServerError)
+		return
+	}
+	// The code names the user; the secret part makes it unguessable
+	code := user.ID.String() + "." + strings.TrimRight(secret, "=")
+	if err := s.stateStore.StoreState(r.Context(), loginCodeKey(code, l.CodeChallenge), loginCodeTTL); err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to store login code", "error", err)
+		http.Error(w, "Authentication failed", http.StatusInternalServerError)
+		return
+	}
+
+	redirect, _ := url.Parse(l.RedirectURI)
+	q := redirect.Query()
+	q.Set("code", code)
+	if l.State != "" {
+		q.Set("state", l.State)
+	}
+	redirect.RawQuery = q.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// LoginCodeRequest redeems a loopback login code
+type LoginCodeRequest struct {
+	Code         string `json:"code"`
+	CodeVerifier string `json:"code_verifier"`
+}
+
+// handleLoginCode exchanges a loopback login code and its PKCE verifier for
+// tokens
+func (s *Server) handleLoginCode(w http.ResponseWriter, r *http.Request) {
+	var req LoginCodeRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Code == "" {
+		writeValidationError(w, &ValidationError{Field: "code", Message: ErrEmptyField.Error()})
+		return
+	}
+	if req.CodeVerifier == "" {
+		writeValidationError(w, &ValidationError{Field: "code_verifier", Message: ErrEmptyField.Error()})
+		return
+	}
+
+	sum := sha256.Sum256([]byte(req.CodeVerifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+	valid, err := s.stateStore.ValidateAndDeleteState(r.Context(), loginCodeKey(req.Code, challenge))
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to validate login code", "error", err)
+		http.Error(w, "Authentication failed", http.StatusInternalServerError)
+		return
+	}
+	userPart, _, _ := strings.Cut(req.Code, ".")
+	userID, err := uuid.Parse(userPart)
+	if !valid || err != nil {
+		s.authLog.Log(r, AuthEventLoginFailed, nil, AuthReasonInvalidLoginCode, "provider", "loopback")
+		http.Error(w, "Invalid or expired login code", http.StatusBadRequest)
+		return
+	}
+
+	user, err := s.store.GetUser(r.Context(), userID)
+	if err == sql.ErrNoRows {
+		// Deleted since the callback
+		http.Error(w, "Invalid or expired login code", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to get user", "error", err)
+		http.Error(w, "Authentication failed", http.StatusInternalServerError)
+		return
+	}
+
+	s.writeTokens(w, r, user)
+}
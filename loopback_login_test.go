@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLoopbackLogin(t *testing.T) {
+	challenge := strings.Repeat("a", 43)
+	query := func(redirect, challenge string) url.Values {
+		return url.Values{"redirect_uri": {redirect}, "code_challenge": {challenge}, "code_challenge_method": {"S256"}, "state": {"xyz"}}
+	}
+
+	l, err := parseLoopbackLogin(url.Values{})
+	require.NoError(t, err)
+	require.Nil(t, l)
+
+	for _, redirect := range []string{"http://127.0.0.1:8400/callback", "http://localhost:8400/", "http://[::1]:8400/callback"} {
+		l, err := parseLoopbackLogin(query(redirect, challenge))
+		require.NoError(t, err, redirect)
+		require.Equal(t, redirect, l.RedirectURI)
+		require.Equal(t, "xyz", l.State)
+	}
+
+	for _, redirect := range []string{"https://127.0.0.1:8400/", "http://127.0.0.1/", "http://evil.test:8400/", "http://10.0.0.1:8400/", "http://user@127.0.0.1:8400/"} {
+		_, err := parseLoopbackLogin(query(redirect, challenge))
+		require.ErrorIs(t, err, ErrInvalidLoopbackLogin, redirect)
+	}
+	_, err = parseLoopbackLogin(query("http://127.0.0.1:8400/", "short"))
+	require.ErrorIs(t, err, ErrInvalidLoopbackLogin)
+	q := query("http://127.0.0.1:8400/", challenge)
+	q.Set("code_challenge_method", "plain")
+	_, err = parseLoopbackLogin(q)
+	require.ErrorIs(t, err, ErrInvalidLoopbackLogin)
+}
+
+func TestLoopbackLogin(t *testing.T) {
+	ctx := context.Background()
+
+	store := NewMemoryStore()
+	srv, err := NewServer(store, newTestConfig(t))
+	require.NoError(t, err)
+	org, err := store.CreateOrganization(ctx, "Acme", "owner@acme.test", "Owner")
+	require.NoError(t, err)
+	owner, err := store.GetUser(ctx, org.OwnerID)
+	require.NoError(t, err)
+
+	verifier := "a-verifier-of-at-least-forty-three-characters-long"
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	t.Run("The login carries the loopback redirect in its state", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/auth/login/google?"+url.Values{
+			"redirect_uri":          {"http://127.0.0.1:8400/callback"},
+			"code_challenge":        {challenge},
+			"code_challenge_method": {"S256"},
+			"state":                 {"app-state"},
+		}.Encode(), nil)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		require.Equal(t, http.StatusTemporaryRedirect, w.Code)
+
+		location, err := url.Parse(w.Header().Get("Location"))
+		require.NoError(t, err)
+		state := location.Query().Get("state")
+		l := loopbackFromState(state)
+		require.NotNil(t, l)
+		require.Equal(t, "http://127.0.0.1:8400/callback", l.RedirectURI)
+		require.Equal(t, "app-state", l.State)
+
+		req = httptest.NewRequest(http.MethodGet, "/auth/login/google?redirect_uri=http://evil.test:8400/", nil)
+		w = httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	redeem := func(code, verifier string) *httptest.ResponseRecorder {
+		body, err := json.Marshal(LoginCodeRequest{Code: code, CodeVerifier: verifier})
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, "/auth/token", strings.NewReader(string(body)))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return w
+	}
+	issueCode := func() string {
+		w := httptest.NewRecorder()
+		l := &loopbackLogin{RedirectURI: "http://127.0.0.1:8400/callback", CodeChallenge: challenge, State: "app-state"}
+		srv.redirectToLoopback(w, httptest.NewRequest(http.MethodGet, "/auth/callback/google", nil), l, owner)
+		require.Equal(t, http.StatusFound, w.Code)
+
+		location, err := url.Parse(w.Header().Get("Location"))
+		require.NoError(t, err)
+		require.Equal(t, "127.0.0.1:8400", location.Host)
+		require.Equal(t, "app-state", location.Query().Get("state"))
+		return location.Query().Get("code")
+	}
+
+	t.Run("The code is exchanged for tokens once", func(t *testing.T) {
+		code := issueCode()
+
+		w := redeem(code, verifier)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var tokens TokenResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&tokens))
+		require.NotEmpty(t, tokens.AccessToken)
+		user, err := store.ValidateRefreshToken(ctx, tokens.RefreshToken)
+		require.NoError(t, err)
+		require.Equal(t, owner.ID, user.ID)
+
+		require.Equal(t, http.StatusBadRequest, redeem(code, verifier).Code)
+	})
+
+	t.Run("The code needs the verifier", func(t *testing.T) {
+		code := issueCode()
+		require.Equal(t, http.StatusBadRequest, redeem(code, "another-verifier").Code)
+		require.Equal(t, http.StatusOK, redeem(code, verifier).Code)
+	})
+}
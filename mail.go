@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Mail is a plain-text email to one recipient
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+	// Branding, if set, names the sender after the product and adds an
+	// HTML version of Body showing the logo and color
+	Branding *Branding
+}
+
+// Mailer sends email. Servers without MAIL_SMTP_URL have none, and leave
+// out what they would have sent.
+type Mailer interface {
+	Send(ctx context.Context, m *Mail) error
+}
+
+// MailConfig names the SMTP relay email is sent through
+type MailConfig struct {
+	// SMTPURL is smtp://[user:password@]host[:port]; the relay must offer
+	// STARTTLS before credentials are sent. Empty sends no email.
+	SMTPURL string
+	From    string // the sender address
+}
+
+// NewMailConfig creates a mail configuration from settings
+func NewMailConfig(settings Settings) (*MailConfig, error) {
+	config := &MailConfig{SMTPURL: settings("MAIL_SMTP_URL"), From: settings("MAIL_FROM")}
+	if config.SMTPURL == "" {
+		return config, nil
+	}
+
+	u, err := url.Parse(config.SMTPURL)
+	if err != nil || u.Scheme != "smtp" || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid MAIL_SMTP_URL: expected smtp://[user:password@]host[:port]")
+	}
+	if _, err := mail.ParseAddress(config.From); err != nil {
+		return nil, fmt.Errorf("invalid MAIL_FROM %q: MAIL_SMTP_URL needs a sender address", config.From)
+	}
+	return config, nil
+}
+
+// SMTPMailer sends email through an SMTP relay
+type SMTPMailer struct {
+	addr string
+	host string
+	auth smtp.Auth // nil unless the URL carries credentials
+	from string
+}
+
+// NewMailer creates the mailer config names, or returns nil if it names none
+func NewMailer(config *MailConfig) Mailer {
+	if config.SMTPURL == "" {
+		return nil
+	}
+	u, _ := url.Parse(config.SMTPURL)
+	m := &SMTPMailer{addr: u.Host, host: u.Hostname(), from: config.From}
+	if u.Port() == "" {
+		m.addr = net.JoinHostPort(u.Hostname(), "587")
+	}
+	if u.User != nil {
+		password, _ := u.User.Password()
+		m.auth = smtp.PlainAuth("", u.User.Username(), password, m.host)
+	}
+	return m
+}
+
+// Send delivers m to the relay. smtp.SendMail cannot be cancelled, so ctx
+// only stops a send that has not started.
+func (m *SMTPMailer) Send(ctx context.Context, msg *Mail) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return fmt.Errorf("invalid recipient: %w", err)
+	}
+	return smtp.SendMail(m.addr, m.auth, m.from, []string{to.Address}, m.render(to, msg))
+}
+
+// render formats msg as a MIME message. The subject and sender name are
+// encoded, so neither they nor the recipient can add headers.
+func (m *SMTPMailer) render(to *mail.Address, msg *Mail) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", m.sender(msg.Branding))
+	fmt.Fprintf(&b, "To: %s\r\n", to.String())
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	body := strings.ReplaceAll(strings.ReplaceAll(msg.Body, "\r\n", "\n"), "\n", "\r\n")
+	if msg.Branding == nil {
+		b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+		b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+		b.WriteString(body)
+		return []byte(b.String())
+	}
+
+	parts := multipart.NewWriter(&b)
+	fmt.Fprintf(&b, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", parts.Boundary())
+	text, _ := parts.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"8bit"},
+	})
+	io.WriteString(text, body)
+	html, _ := parts.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/html; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	qp := quotedprintable.NewWriter(html)
+	renderBrandedMail(qp, msg.Body, msg.Branding)
+	qp.Close()
+	parts.Close()
+	return []byte(b.String())
+}
+
+// sender is the From address, named after branding's product if it has one
+func (m *SMTPMailer) sender(branding *Branding) string {
+	if branding == nil || branding.ProductName == "" {
+		return m.from
+	}
+	from, err := mail.ParseAddress(m.from)
+	if err != nil {
+		return m.from
+	}
+	from.Name = branding.ProductName
+	return from.String()
+}
+
+// defaultPrimaryColor is the accent of branded mail and pages that set none
+const defaultPrimaryColor = "#1a73e8"
+
+var brandedMailTemplate = template.Must(template.New("mail").Parse(`<!DOCTYPE html>
+<html>
+<body style="margin:0;padding:24px;font-family:Helvetica,Arial,sans-serif;color:#202124">
+{{- if .LogoURL}}
+<img src="{{.LogoURL}}" alt="{{.ProductName}}" style="max-height:48px;margin-bottom:16px">
+{{- end}}
+<div style="border-top:4px solid {{.Color}};padding-top:16px">
+{{- range .Paragraphs}}
+<p>{{if .Link}}<a href="{{.Text}}" style="color:{{$.Color}}">{{.Text}}</a>{{else}}{{.Text}}{{end}}</p>
+{{- end}}
+</div>
+</body>
+</html>
+`))
+
+// renderBrandedMail writes body as HTML with branding's logo and color.
+// Each paragraph that is only a URL becomes a link.
+func renderBrandedMail(w io.Writer, body string, branding *Branding) error {
+	type paragraph struct {
+		Text string
+		Link bool
+	}
+	data := struct {
+		*Branding
+		Color      string
+		Paragraphs []paragraph
+	}{Branding: branding, Color: branding.PrimaryColor}
+	if data.Color == "" {
+		data.Color = defaultPrimaryColor
+	}
+	for _, text := range strings.Split(strings.TrimSpace(body), "\n\n") {
+		text = strings.TrimSpace(text)
+		link := (strings.HasPrefix(text, "https://") || strings.HasPrefix(text, "http://")) && !strings.ContainsAny(text, " \n")
+		data.Paragraphs = append(data.Paragraphs, paragraph{Text: text, Link: link})
+	}
+	return brandedMailTemplate.Execute(w, data)
+}
@@ -0,0 +1,2062 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type usageKey struct {
+	orgID uuid.UUID
+	day   string
+}
+
+type quotaAlertKey struct {
+	orgID uuid.UUID
+	quota string
+}
+
+type quotaAlert struct {
+	period string
+	level  int
+}
+
+type deniedTokenKey struct {
+	orgID   uuid.UUID
+	tokenID uuid.UUID
+}
+
+type memoryOrganization struct {
+	Organization
+	settings  OrganizationSettings
+	retention RetentionOverride
+}
+
+// touch records a change to the organization
+func (o *memoryOrganization) touch() {
+	o.Version++
+	o.UpdatedAt = time.Now().UTC()
+}
+
+// MemoryStore is an in-process Store with the same semantics as the
+// Postgres implementation, for tests that should not need a database
+type MemoryStore struct {
+	mu            sync.Mutex
+	organizations map[uuid.UUID]*memoryOrganization
+	users         map[uuid.UUID]*User
+	refreshTokens map[string]RefreshToken // keyed by token hash
+	usage         map[usageKey]int64
+	jobs          map[uuid.UUID]*Job
+	webhooks      map[uuid.UUID]*Webhook
+	deliveries    map[uuid.UUID]*WebhookDelivery
+	oidcClients   map[uuid.UUID]*OIDCClient
+	accessGrants  map[uuid.UUID]*AccessGrant
+	directory     map[uuid.UUID]*memoryDirectorySync
+	featureFlags  map[string]*FeatureFlag
+	orgFields     map[string]OrganizationField
+	invitations   map[uuid.UUID]*Invitation
+	quotaAlerts   map[quotaAlertKey]quotaAlert
+	deniedTokens  map[deniedTokenKey]time.Time // until when
+	mfaFactors    map[uuid.UUID]*MFAFactor     // by user
+	seatRecords   []SeatUsageRecord            // in the order recorded
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		organizations: make(map[uuid.UUID]*memoryOrganization),
+		users:         make(map[uuid.UUID]*User),
+		refreshTokens: make(map[string]RefreshToken),
+		usage:         make(map[usageKey]int64),
+		jobs:          make(map[uuid.UUID]*Job),
+		webhooks:      make(map[uuid.UUID]*Webhook),
+		deliveries:    make(map[uuid.UUID]*WebhookDelivery),
+		oidcClients:   make(map[uuid.UUID]*OIDCClient),
+		accessGrants:  make(map[uuid.UUID]*AccessGrant),
+		directory:     make(map[uuid.UUID]*memoryDirectorySync),
+		featureFlags:  make(map[string]*FeatureFlag),
+		orgFields:     make(map[string]OrganizationField),
+		invitations:   make(map[uuid.UUID]*Invitation),
+		quotaAlerts:   make(map[quotaAlertKey]quotaAlert),
+		deniedTokens:  make(map[deniedTokenKey]time.Time),
+		mfaFactors:    make(map[uuid.UUID]*MFAFactor),
+	}
+}
+
+// copyUser returns a copy of u that shares no state with the store
+func copyUser(u *User) *User {
+	c := *u
+	c.Permissions = make(Permissions, len(u.Permissions))
+	for k, v := range u.Permissions {
+		c.Permissions[k] = v
+	}
+	c.Metadata = copyMetadata(u.Metadata)
+	return &c
+}
+
+// copySettings returns a copy of s that shares no state with the store
+func copySettings(s *OrganizationSettings) *OrganizationSettings {
+	c := OrganizationSettings{
+		AllowedOrigins: append([]string(nil), s.AllowedOrigins...),
+		IPAllowlist:    append([]string(nil), s.IPAllowlist...),
+		EmailDomains:   append([]string(nil), s.EmailDomains...),
+	}
+	if s.Notifications != nil {
+		n := *s.Notifications
+		n.Events = append([]string(nil), n.Events...)
+		c.Notifications = &n
+	}
+	if s.Sessions != nil {
+		p := *s.Sessions
+		c.Sessions = &p
+	}
+	c.UserMetadataSchema = copyMetadataSchema(s.UserMetadataSchema)
+	if s.Branding != nil {
+		b := *s.Branding
+		c.Branding = &b
+	}
+	return &c
+}
+
+// liveUser returns the user with id unless it is missing or deleted;
+// callers hold m.mu
+func (m *MemoryStore) liveUser(id uuid.UUID) (*User, bool) {
+	u, ok := m.users[id]
+	return u, ok && u.DeletedAt == nil
+}
+
+// liveOrganization returns the organization with id unless it is missing or
+// deleted; callers hold m.mu
+func (m *MemoryStore) liveOrganization(id uuid.UUID) (*memoryOrganization, bool) {
+	org, ok := m.organizations[id]
+	return org, ok && org.DeletedAt == nil
+}
+
+// touchUser records a change to u
+func touchUser(u *User) {
+	u.Version++
+	u.UpdatedAt = time.Now().UTC()
+}
+
+// emailTaken reports whether a user that is not deleted already has email;
+// callers hold m.mu
+func (m *MemoryStore) emailTaken(email string) bool {
+	for _, u := range m.users {
+		if u.Email == email && u.DeletedAt == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedUsers returns copies of the users matching keep, ordered by email
+func (m *MemoryStore) sortedUsers(keep func(*User) bool) []User {
+	users := []User{}
+	for _, u := range m.users {
+		if keep(u) {
+			users = append(users, *copyUser(u))
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Email < users[j].Email })
+	return users
+}
+
+func (m *MemoryStore) GetUser(ctx context.Context, id uuid.UUID) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.liveUser(id)
+	if !ok || !inScope(ctx, u.OrganizationID) {
+		return nil, sql.ErrNoRows
+	}
+	return copyUser(u), nil
+}
+
+func (m *MemoryStore) GetUsersByIDs(ctx context.Context, ids []uuid.UUID) ([]User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	users := []User{}
+	for _, id := range ids {
+		if u, ok := m.liveUser(id); ok && inScope(ctx, u.OrganizationID) {
+			users = append(users, *copyUser(u))
+		}
+	}
+	return users, nil
+}
+
+func (m *MemoryStore) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, u := range m.users {
+		if u.Email == email && u.DeletedAt == nil {
+			return copyUser(u), nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *MemoryStore) SearchUsers(ctx context.Context, query string, includeDeleted bool, limit, offset int) ([]User, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	query = strings.ToLower(query)
+	users := m.sortedUsers(func(u *User) bool {
+		if u.DeletedAt != nil && !includeDeleted {
+			return false
+		}
+		return strings.Contains(strings.ToLower(u.Email), query) || strings.Contains(strings.ToLower(u.Name), query)
+	})
+	page, total := paginate(users, limit, offset)
+	return page, total, nil
+}
+
+func (m *MemoryStore) SearchOrganizationUsers(ctx context.Context, orgID uuid.UUID, query string, limit, offset int) ([]User, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	members := m.sortedUsers(func(u *User) bool { return u.OrganizationID == orgID && u.DeletedAt == nil })
+	page, total := paginate(rankUsers(members, query), limit, offset)
+	return page, total, nil
+}
+
+func (m *MemoryStore) CreateOrganization(ctx context.Context, name, ownerEmail, ownerName string) (*Organization, error) {
+	org := &Organization{
+		ID:               uuid.New(),
+		Name:             name,
+		SubscriptionTier: DefaultSubscriptionTier,
+		MaxSubAccounts:   SubscriptionTiers.DefaultLimit(),
+		SeatOverage:      SeatOverageBlock,
+		Version:          1,
+	}
+	owner := &User{
+		ID:             uuid.New(),
+		Email:          ownerEmail,
+		Name:           ownerName,
+		OrganizationID: org.ID,
+		Role:           "owner",
+		Permissions:    Permissions{"admin": true},
+	}
+	org.OwnerID = owner.ID
+
+	if err := m.CreateOrganizationWithOwner(ctx, org, owner); err != nil {
+		return nil, err
+	}
+	return org, nil
+}
+
+func (m *MemoryStore) CreateOrganizationWithOwner(ctx context.Context, org *Organization, owner *User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.emailTaken(owner.Email) {
+		return ErrEmailTaken
+	}
+	if org.Slug != nil {
+		for _, other := range m.organizations {
+			if other.DeletedAt == nil && other.Slug != nil && *other.Slug == *org.Slug {
+				return ErrSlugTaken
+			}
+		}
+	}
+
+	now := time.Now().UTC()
+	stored := &memoryOrganization{Organization: *org}
+	stored.CreatedAt = now
+	stored.UpdatedAt = now
+	stored.SuspendedAt = nil
+	stored.DeletedAt = nil
+	stored.Version = 1
+	if stored.SeatOverage == "" {
+		stored.SeatOverage = SeatOverageBlock
+	}
+	m.organizations[org.ID] = stored
+
+	user := copyUser(owner)
+	user.CreatedAt = now
+	user.UpdatedAt = now
+	user.DeletedAt = nil
+	user.Version = 1
+	m.users[owner.ID] = user
+	return nil
+}
+
+func (m *MemoryStore) GetOrganization(ctx context.Context, id uuid.UUID) (*Organization, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	org, ok := m.liveOrganization(id)
+	if !ok || !inScope(ctx, id) {
+		return nil, sql.ErrNoRows
+	}
+	o := org.Organization
+	return &o, nil
+}
+
+func (m *MemoryStore) GetOrganizationsByIDs(ctx context.Context, ids []uuid.UUID) ([]Organization, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	orgs := []Organization{}
+	for _, id := range ids {
+		if org, ok := m.liveOrganization(id); ok && inScope(ctx, id) {
+			orgs = append(orgs, org.Organization)
+		}
+	}
+	return orgs, nil
+}
+
+func (m *MemoryStore) GetOrganizationUsers(ctx context.Context, orgID uuid.UUID) ([]User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.sortedUsers(func(u *User) bool { return u.OrganizationID == orgID && u.DeletedAt == nil }), nil
+}
+
+func (m *MemoryStore) AddUserToOrganization(ctx context.Context, orgID uuid.UUID, email, name string) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	org, ok := m.liveOrganization(orgID)
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	if org.SeatOverage != SeatOverageAllow && m.seatsUsed(orgID) >= org.MaxSubAccounts {
+		return nil, ErrMaxSubAccounts
+	}
+	if m.emailTaken(email) {
+		return nil, ErrEmailTaken
+	}
+
+	user := &User{
+		ID:             uuid.New(),
+		Email:          email,
+		Name:           name,
+		OrganizationID: orgID,
+		Role:           "sub_account",
+		Permissions:    Permissions{},
+		Version:        1,
+	}
+	user.CreatedAt = time.Now().UTC()
+	user.UpdatedAt = user.CreatedAt
+	m.users[user.ID] = copyUser(user)
+	m.recordSeatChange(orgID, user.ID, 1)
+	return user, nil
+}
+
+func (m *MemoryStore) UpdateUserMetadata(ctx context.Context, orgID, userID uuid.UUID, expectedVersion int, metadata UserMetadata) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.liveUser(userID)
+	if !ok || u.OrganizationID != orgID {
+		return nil, ErrUserNotFound
+	}
+	if u.Version != expectedVersion {
+		return nil, ErrVersionConflict
+	}
+	u.Metadata = copyMetadata(metadata)
+	touchUser(u)
+	return copyUser(u), nil
+}
+
+func (m *MemoryStore) UpdateUserRole(ctx context.Context, orgID, userID uuid.UUID, role string) (*User, error) {
+	if !slices.Contains(AssignableRoles, role) {
+		return nil, ErrUnknownRole
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	org, ok := m.liveOrganization(orgID)
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	u, ok := m.liveUser(userID)
+	if !ok || u.OrganizationID != orgID {
+		return nil, ErrUserNotFound
+	}
+	if u.Role == "owner" {
+		return nil, ErrOwnerRole
+	}
+	if u.Role == role {
+		return copyUser(u), nil
+	}
+	if role == "sub_account" && org.SeatOverage != SeatOverageAllow && m.seatsUsed(orgID) >= org.MaxSubAccounts {
+		return nil, ErrMaxSubAccounts
+	}
+
+	previous := u.Role
+	u.Role = role
+	touchUser(u)
+	if previous == "sub_account" {
+		m.recordSeatChange(orgID, userID, -1)
+	}
+	if role == "sub_account" {
+		m.recordSeatChange(orgID, userID, 1)
+	}
+	return copyUser(u), nil
+}
+
+func (m *MemoryStore) GetOrganizationStats(ctx context.Context, orgID uuid.UUID) (*OrganizationStats, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	org, ok := m.liveOrganization(orgID)
+	if !ok {
+		return nil, ErrOrganizationNotFound
+	}
+
+	stats := &OrganizationStats{OrganizationID: orgID, SeatLimit: org.MaxSubAccounts}
+	for _, u := range m.users {
+		if u.OrganizationID != orgID || u.DeletedAt != nil {
+			continue
+		}
+		stats.Members++
+		if u.Role == "sub_account" {
+			stats.SeatsUsed++
+		}
+	}
+
+	now := time.Now()
+	for _, rt := range m.refreshTokens {
+		if u, ok := m.users[rt.UserID]; ok && u.OrganizationID == orgID && rt.live(now) {
+			stats.ActiveSessions++
+		}
+	}
+
+	since := now.UTC().AddDate(0, 0, -30).Format("2006-01-02")
+	for key, calls := range m.usage {
+		if key.orgID == orgID && key.day > since {
+			stats.APICalls30d += calls
+		}
+	}
+
+	for _, inv := range m.invitations {
+		if inv.OrganizationID != orgID {
+			continue
+		}
+		if inv.Expired(now) {
+			stats.ExpiredInvitations++
+		} else {
+			stats.PendingInvitations++
+		}
+	}
+	return stats, nil
+}
+
+func (m *MemoryStore) IncrementAPIUsage(ctx context.Context, orgID uuid.UUID, day time.Time, calls int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.usage[usageKey{orgID: orgID, day: day.Format("2006-01-02")}] += calls
+	return nil
+}
+
+func (m *MemoryStore) GetOrganizationSettings(ctx context.Context, orgID uuid.UUID) (*OrganizationSettings, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	org, ok := m.liveOrganization(orgID)
+	if !ok {
+		return nil, 0, ErrOrganizationNotFound
+	}
+	return copySettings(&org.settings), org.Version, nil
+}
+
+func (m *MemoryStore) UpdateOrganizationSettings(ctx context.Context, orgID uuid.UUID, expectedVersion int, settings *OrganizationSettings) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	org, err := m.organizationAtVersion(orgID, expectedVersion)
+	if err != nil {
+		return 0, err
+	}
+	org.settings = *copySettings(settings)
+	org.touch()
+	return org.Version, nil
+}
+
+func (m *MemoryStore) RenameOrganization(ctx context.Context, id uuid.UUID, expectedVersion int, name string) (*Organization, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	org, err := m.organizationAtVersion(id, expectedVersion)
+	if err != nil {
+		return nil, err
+	}
+	org.Name = name
+	org.touch()
+	o := org.Organization
+	return &o, nil
+}
+
+// organizationAtVersion returns the organization with id if nobody has
+// changed it since the caller read expectedVersion; callers hold m.mu
+func (m *MemoryStore) organizationAtVersion(id uuid.UUID, expectedVersion int) (*memoryOrganization, error) {
+	org, ok := m.liveOrganization(id)
+	if !ok {
+		return nil, ErrOrganizationNotFound
+	}
+	if org.Version != expectedVersion {
+		return nil, ErrVersionConflict
+	}
+	return org, nil
+}
+
+func (m *MemoryStore) ListOrganizationOrigins(ctx context.Context) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	seen := make(map[string]bool)
+	origins := []string{}
+	for _, org := range m.organizations {
+		if org.SuspendedAt != nil || org.DeletedAt != nil {
+			continue
+		}
+		for _, origin := range org.settings.AllowedOrigins {
+			if !seen[origin] {
+				seen[origin] = true
+				origins = append(origins, origin)
+			}
+		}
+	}
+	return origins, nil
+}
+
+func (m *MemoryStore) ListOrganizationIPAllowlists(ctx context.Context) (map[uuid.UUID][]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	allowlists := make(map[uuid.UUID][]string)
+	for id, org := range m.organizations {
+		if org.DeletedAt == nil && len(org.settings.IPAllowlist) > 0 {
+			allowlists[id] = append([]string(nil), org.settings.IPAllowlist...)
+		}
+	}
+	return allowlists, nil
+}
+
+func (m *MemoryStore) ListOrganizationParents(ctx context.Context) (map[uuid.UUID]uuid.UUID, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	parents := make(map[uuid.UUID]uuid.UUID)
+	for id, org := range m.organizations {
+		if org.DeletedAt != nil || org.ParentID == nil {
+			continue
+		}
+		if _, ok := m.liveOrganization(*org.ParentID); ok {
+			parents[id] = *org.ParentID
+		}
+	}
+	return parents, nil
+}
+
+func (m *MemoryStore) ListOrganizations(ctx context.Context, filter OrganizationFilter, limit, offset int) ([]Organization, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	orgs := []Organization{}
+	for _, org := range m.organizations {
+		if org.DeletedAt != nil && !filter.IncludeDeleted {
+			continue
+		}
+		if hasFields(org.CustomFields, filter.CustomFields) {
+			orgs = append(orgs, org.Organization)
+		}
+	}
+	sort.Slice(orgs, func(i, j int) bool {
+		if !orgs[i].CreatedAt.Equal(orgs[j].CreatedAt) {
+			return orgs[i].CreatedAt.After(orgs[j].CreatedAt)
+		}
+		return orgs[i].ID.String() < orgs[j].ID.String()
+	})
+	page, total := paginate(orgs, limit, offset)
+	return page, total, nil
+}
+
+func (m *MemoryStore) SearchOrganizations(ctx context.Context, query string, limit int) ([]Organization, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	query = strings.ToLower(query)
+	rank := func(org *Organization) int {
+		switch {
+		case org.Slug != nil && *org.Slug == query:
+			return 3
+		case strings.ToLower(org.Name) == query:
+			return 2
+		case org.Slug != nil && strings.Contains(*org.Slug, query), strings.Contains(strings.ToLower(org.Name), query):
+			return 1
+		}
+		return 0
+	}
+
+	orgs := []Organization{}
+	for _, org := range m.organizations {
+		if org.DeletedAt == nil && rank(&org.Organization) > 0 {
+			orgs = append(orgs, org.Organization)
+		}
+	}
+	sort.Slice(orgs, func(i, j int) bool {
+		if ri, rj := rank(&orgs[i]), rank(&orgs[j]); ri != rj {
+			return ri > rj
+		}
+		if orgs[i].Name != orgs[j].Name {
+			return orgs[i].Name < orgs[j].Name
+		}
+		return orgs[i].ID.String() < orgs[j].ID.String()
+	})
+	page, _ := paginate(orgs, limit, 0)
+	return page, nil
+}
+
+func (m *MemoryStore) SetOrganizationSuspended(ctx context.Context, id uuid.UUID, suspended bool) (*Organization, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	org, ok := m.liveOrganization(id)
+	if !ok {
+		return nil, ErrOrganizationNotFound
+	}
+	switch {
+	case !suspended:
+		org.SuspendedAt = nil
+	case org.SuspendedAt == nil:
+		now := time.Now().UTC()
+		org.SuspendedAt = &now
+	}
+	org.touch()
+	o := org.Organization
+	return &o, nil
+}
+
+func (m *MemoryStore) IsOrganizationSuspended(ctx context.Context, id uuid.UUID) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	org, ok := m.liveOrganization(id)
+	return ok && org.SuspendedAt != nil, nil
+}
+
+func (m *MemoryStore) UpdateOrganizationTier(ctx context.Context, id uuid.UUID, expectedVersion int, tier string, maxSubAccounts int, seatOverage string) (*Organization, error) {
+	defaultLimit, ok := SubscriptionTiers.Limit(tier)
+	if !ok {
+		return nil, ErrUnknownTier
+	}
+	if maxSubAccounts == 0 {
+		maxSubAccounts = defaultLimit
+	}
+	if seatOverage != "" && !slices.Contains(SeatOveragePolicies, seatOverage) {
+		return nil, ErrUnknownSeatOverage
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	org, err := m.organizationAtVersion(id, expectedVersion)
+	if err != nil {
+		return nil, err
+	}
+	org.SubscriptionTier = tier
+	org.MaxSubAccounts = maxSubAccounts
+	if seatOverage != "" {
+		org.SeatOverage = seatOverage
+	}
+	org.touch()
+	o := org.Organization
+	return &o, nil
+}
+
+func (m *MemoryStore) SetOrganizationParent(ctx context.Context, id uuid.UUID, expectedVersion int, parentID *uuid.UUID) (*Organization, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	org, err := m.organizationAtVersion(id, expectedVersion)
+	if err != nil {
+		return nil, err
+	}
+	if parentID != nil {
+		if _, ok := m.liveOrganization(*parentID); !ok {
+			return nil, ErrParentNotFound
+		}
+		for ancestor, ok := m.organizations[*parentID]; ok; ancestor, ok = m.organizations[*ancestor.ParentID] {
+			if ancestor.ID == id {
+				return nil, ErrOrganizationCycle
+			}
+			if ancestor.ParentID == nil {
+				break
+			}
+		}
+		parent := *parentID
+		parentID = &parent
+	}
+	org.ParentID = parentID
+	org.touch()
+	o := org.Organization
+	return &o, nil
+}
+
+func (m *MemoryStore) GetPlatformStats(ctx context.Context) (*PlatformStats, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := &PlatformStats{OrganizationsByTier: make(map[string]int)}
+	for _, u := range m.users {
+		if u.DeletedAt == nil {
+			stats.Users++
+		}
+	}
+	for _, org := range m.organizations {
+		if org.DeletedAt != nil {
+			continue
+		}
+		stats.Organizations++
+		if org.SuspendedAt != nil {
+			stats.SuspendedOrganizations++
+		}
+		stats.OrganizationsByTier[org.SubscriptionTier]++
+	}
+	now := time.Now()
+	for _, rt := range m.refreshTokens {
+		if rt.live(now) {
+			stats.ActiveSessions++
+		}
+	}
+	return stats, nil
+}
+
+func (m *MemoryStore) DeleteOrganization(ctx context.Context, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	org, ok := m.liveOrganization(id)
+	if !ok {
+		return ErrOrganizationNotFound
+	}
+	now := time.Now().UTC()
+	org.touch()
+	org.DeletedAt = &now
+	for _, u := range m.users {
+		if u.OrganizationID == id && u.DeletedAt == nil {
+			touchUser(u)
+			u.DeletedAt = &now
+		}
+	}
+	for hash, rt := range m.refreshTokens {
+		if u, ok := m.users[rt.UserID]; ok && u.OrganizationID == id {
+			delete(m.refreshTokens, hash)
+		}
+	}
+	for invID, inv := range m.invitations {
+		if inv.OrganizationID == id {
+			delete(m.invitations, invID)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) MoveUser(ctx context.Context, userID, fromOrgID, toOrgID uuid.UUID, role string) (*User, error) {
+	if !slices.Contains(AssignableRoles, role) {
+		return nil, ErrUnknownRole
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.liveOrganization(toOrgID); !ok {
+		return nil, ErrOrganizationNotFound
+	}
+	u, ok := m.liveUser(userID)
+	if !ok || u.OrganizationID != fromOrgID {
+		return nil, ErrUserNotFound
+	}
+
+	previous := u.Role
+	u.OrganizationID = toOrgID
+	u.Role = role
+	touchUser(u)
+	if previous == "sub_account" {
+		m.recordSeatChange(fromOrgID, userID, -1)
+	}
+	if role == "sub_account" {
+		m.recordSeatChange(toOrgID, userID, 1)
+	}
+	return copyUser(u), nil
+}
+
+func (m *MemoryStore) DeleteUser(ctx context.Context, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.liveUser(id)
+	if !ok {
+		return ErrUserNotFound
+	}
+	if u.Role == "owner" {
+		return ErrDeleteOwner
+	}
+	now := time.Now().UTC()
+	touchUser(u)
+	u.DeletedAt = &now
+	for hash, rt := range m.refreshTokens {
+		if rt.UserID == id {
+			delete(m.refreshTokens, hash)
+		}
+	}
+	if u.Role == "sub_account" {
+		m.recordSeatChange(u.OrganizationID, id, -1)
+	}
+	return nil
+}
+
+func (m *MemoryStore) CreateRefreshToken(ctx context.Context, userID uuid.UUID, terms SessionTerms) (string, error) {
+	token, err := GenerateRefreshToken()
+	if err != nil {
+		return "", err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.insertRefreshToken(userID, token, terms, terms.sessionEnd(time.Now()))
+	return token, nil
+}
+
+func (m *MemoryStore) RotateRefreshToken(ctx context.Context, token string, terms SessionTerms) (string, error) {
+	next, err := GenerateRefreshToken()
+	if err != nil {
+		return "", err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	hash := HashToken(token)
+	rt, ok := m.refreshTokens[hash]
+	if !ok || !rt.live(time.Now()) {
+		return "", ErrRefreshTokenNotFound
+	}
+	m.revokeTokens(func(rt RefreshToken) bool { return rt.TokenHash == hash })
+	m.insertRefreshToken(rt.UserID, next, terms, rt.SessionExpiresAt)
+	return next, nil
+}
+
+// insertRefreshToken stores token for a session of userID's ending at end;
+// callers hold m.mu
+func (m *MemoryStore) insertRefreshToken(userID uuid.UUID, token string, terms SessionTerms, end *time.Time) {
+	if terms.SingleSession {
+		m.revokeTokens(func(rt RefreshToken) bool { return rt.UserID == userID })
+	}
+
+	now := time.Now()
+	hash := HashToken(token)
+	m.refreshTokens[hash] = RefreshToken{
+		ID:               uuid.New(),
+		UserID:           userID,
+		TokenHash:        hash,
+		ExpiresAt:        terms.refreshExpiry(now, end),
+		SessionExpiresAt: end,
+		CreatedAt:        now,
+	}
+}
+
+func (m *MemoryStore) ValidateRefreshToken(ctx context.Context, token string) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rt, ok := m.refreshTokens[HashToken(token)]
+	if !ok || !rt.live(time.Now()) {
+		return nil, ErrRefreshTokenNotFound
+	}
+	u, ok := m.liveUser(rt.UserID)
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return copyUser(u), nil
+}
+
+func (m *MemoryStore) InvalidateRefreshToken(ctx context.Context, token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	hash := HashToken(token)
+	m.revokeTokens(func(rt RefreshToken) bool { return rt.TokenHash == hash })
+	return nil
+}
+
+func (m *MemoryStore) InvalidateUserRefreshTokens(ctx context.Context, userID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.revokeTokens(func(rt RefreshToken) bool { return rt.UserID == userID })
+	return nil
+}
+
+func (m *MemoryStore) ListUserRefreshTokens(ctx context.Context, userID uuid.UUID) ([]RefreshToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	tokens := []RefreshToken{}
+	for _, rt := range m.refreshTokens {
+		if rt.UserID == userID && rt.live(now) {
+			tokens = append(tokens, rt)
+		}
+	}
+	slices.SortFunc(tokens, func(a, b RefreshToken) int { return b.CreatedAt.Compare(a.CreatedAt) })
+	return tokens, nil
+}
+
+// revokeTokens revokes the live refresh tokens matching match; callers hold m.mu
+func (m *MemoryStore) revokeTokens(match func(rt RefreshToken) bool) {
+	now := time.Now().UTC()
+	for hash, rt := range m.refreshTokens {
+		if rt.RevokedAt == nil && match(rt) {
+			rt.RevokedAt = &now
+			m.refreshTokens[hash] = rt
+		}
+	}
+}
+
+func (m *MemoryStore) GetRetentionOverrides(ctx context.Context) (map[uuid.UUID]RetentionOverride, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	overrides := make(map[uuid.UUID]RetentionOverride)
+	for id, org := range m.organizations {
+		if !org.retention.empty() {
+			overrides[id] = org.retention
+		}
+	}
+	return overrides, nil
+}
+
+func (m *MemoryStore) GetRetentionOverride(ctx context.Context, orgID uuid.UUID) (*RetentionOverride, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	org, ok := m.liveOrganization(orgID)
+	if !ok {
+		return nil, 0, ErrOrganizationNotFound
+	}
+	override := org.retention
+	return &override, org.Version, nil
+}
+
+func (m *MemoryStore) UpdateRetentionOverride(ctx context.Context, orgID uuid.UUID, expectedVersion int, override *RetentionOverride) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	org, err := m.organizationAtVersion(orgID, expectedVersion)
+	if err != nil {
+		return 0, err
+	}
+	org.retention = *override
+	org.touch()
+	return org.Version, nil
+}
+
+func (m *MemoryStore) PurgeRetained(ctx context.Context, scope RetentionScope, cutoffs RetentionCutoffs) (*PurgeCounts, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	inScope := func(orgID uuid.UUID) bool {
+		if scope.OrgID != uuid.Nil {
+			return orgID == scope.OrgID
+		}
+		return !slices.Contains(scope.Except, orgID)
+	}
+	before := func(t *time.Time, cutoff time.Time) bool {
+		return t != nil && !cutoff.IsZero() && t.Before(cutoff)
+	}
+
+	counts := &PurgeCounts{}
+	for hash, rt := range m.refreshTokens {
+		u, ok := m.users[rt.UserID]
+		if !ok || !inScope(u.OrganizationID) {
+			continue
+		}
+		if rt.RevokedAt == nil && before(&rt.ExpiresAt, cutoffs.RefreshTokens) {
+			delete(m.refreshTokens, hash)
+			counts.RefreshTokens++
+		} else if before(rt.RevokedAt, cutoffs.RevokedSessions) {
+			delete(m.refreshTokens, hash)
+			counts.RevokedSessions++
+		}
+	}
+
+	purgedOrgs := make(map[uuid.UUID]bool)
+	for id, org := range m.organizations {
+		if inScope(id) && before(org.DeletedAt, cutoffs.DeletedRecords) {
+			purgedOrgs[id] = true
+		}
+	}
+
+	// Members of a purged organization go with it whenever they were deleted
+	for id, u := range m.users {
+		if inScope(u.OrganizationID) && (before(u.DeletedAt, cutoffs.DeletedRecords) || purgedOrgs[u.OrganizationID]) {
+			delete(m.users, id)
+			counts.Users++
+		}
+	}
+	for hash, rt := range m.refreshTokens {
+		if _, ok := m.users[rt.UserID]; !ok {
+			delete(m.refreshTokens, hash)
+		}
+	}
+	for userID := range m.mfaFactors {
+		if _, ok := m.users[userID]; !ok {
+			delete(m.mfaFactors, userID)
+		}
+	}
+	for key := range m.usage {
+		if purgedOrgs[key.orgID] {
+			delete(m.usage, key)
+		}
+	}
+	for key := range m.quotaAlerts {
+		if purgedOrgs[key.orgID] {
+			delete(m.quotaAlerts, key)
+		}
+	}
+	for key := range m.deniedTokens {
+		if purgedOrgs[key.orgID] {
+			delete(m.deniedTokens, key)
+		}
+	}
+	for id, webhook := range m.webhooks {
+		if purgedOrgs[webhook.OrganizationID] {
+			m.deleteWebhook(id)
+		}
+	}
+	for id, client := range m.oidcClients {
+		if purgedOrgs[client.OrganizationID] {
+			delete(m.oidcClients, id)
+		}
+	}
+	for id, grant := range m.accessGrants {
+		if _, ok := m.users[grant.UserID]; !ok || purgedOrgs[grant.OrganizationID] {
+			delete(m.accessGrants, id)
+		}
+	}
+	for orgID, sync := range m.directory {
+		if purgedOrgs[orgID] {
+			delete(m.directory, orgID)
+			continue
+		}
+		sync.users = slices.DeleteFunc(sync.users, func(id uuid.UUID) bool {
+			_, ok := m.users[id]
+			return !ok
+		})
+	}
+	m.seatRecords = slices.DeleteFunc(m.seatRecords, func(r SeatUsageRecord) bool {
+		return purgedOrgs[r.OrganizationID]
+	})
+	for _, flag := range m.featureFlags {
+		maps.DeleteFunc(flag.Organizations, func(id uuid.UUID, _ bool) bool { return purgedOrgs[id] })
+	}
+	for id := range purgedOrgs {
+		delete(m.organizations, id)
+		counts.Organizations++
+	}
+	return counts, nil
+}
+
+// copyJob returns a copy of j that shares no state with the store
+func copyJob(j *Job) Job {
+	c := *j
+	c.Payload = append([]byte(nil), j.Payload...)
+	return c
+}
+
+func (m *MemoryStore) EnqueueJob(ctx context.Context, job *Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored := copyJob(job)
+	stored.Attempts = 0
+	stored.CreatedAt = time.Now().UTC()
+	stored.UpdatedAt = stored.CreatedAt
+	m.jobs[job.ID] = &stored
+	return nil
+}
+
+func (m *MemoryStore) ClaimJobs(ctx context.Context, limit int, lease time.Duration) ([]Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC()
+	due := []*Job{}
+	for _, j := range m.jobs {
+		switch {
+		case j.Status == JobPending && !j.RunAt.After(now):
+			due = append(due, j)
+		case j.Status == JobRunning && j.LockedUntil != nil && j.LockedUntil.Before(now):
+			due = append(due, j)
+		}
+	}
+	sort.Slice(due, func(i, k int) bool { return due[i].RunAt.Before(due[k].RunAt) })
+
+	claimed := []Job{}
+	due, _ = paginate(due, limit, 0)
+	for _, j := range due {
+		lockedUntil := now.Add(lease)
+		j.Status = JobRunning
+		j.Attempts++
+		j.LockedUntil = &lockedUntil
+		j.UpdatedAt = now
+		claimed = append(claimed, copyJob(j))
+	}
+	return claimed, nil
+}
+
+func (m *MemoryStore) CompleteJob(ctx context.Context, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if j, ok := m.jobs[id]; ok {
+		j.Status = JobSucceeded
+		j.LockedUntil = nil
+		j.LastError = nil
+		j.UpdatedAt = time.Now().UTC()
+	}
+	return nil
+}
+
+func (m *MemoryStore) FailJob(ctx context.Context, id uuid.UUID, lastError string, retryAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if j, ok := m.jobs[id]; ok {
+		j.Status = JobPending
+		if j.Attempts >= j.MaxAttempts {
+			j.Status = JobDead
+		}
+		j.RunAt = retryAt
+		j.LockedUntil = nil
+		j.LastError = &lastError
+		j.UpdatedAt = time.Now().UTC()
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetJob(ctx context.Context, id uuid.UUID) (*Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	j, ok := m.jobs[id]
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	job := copyJob(j)
+	return &job, nil
+}
+
+func (m *MemoryStore) ListJobs(ctx context.Context, status string, limit, offset int) ([]Job, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	jobs := []Job{}
+	for _, j := range m.jobs {
+		if status == "" || j.Status == status {
+			jobs = append(jobs, copyJob(j))
+		}
+	}
+	sort.Slice(jobs, func(i, k int) bool {
+		if !jobs[i].CreatedAt.Equal(jobs[k].CreatedAt) {
+			return jobs[i].CreatedAt.After(jobs[k].CreatedAt)
+		}
+		return jobs[i].ID.String() < jobs[k].ID.String()
+	})
+	page, total := paginate(jobs, limit, offset)
+	return page, total, nil
+}
+
+func (m *MemoryStore) RetryJob(ctx context.Context, id uuid.UUID) (*Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	j, ok := m.jobs[id]
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	if j.Status != JobDead {
+		return nil, ErrJobNotDead
+	}
+	j.Status = JobPending
+	j.Attempts = 0
+	j.RunAt = time.Now().UTC()
+	j.UpdatedAt = j.RunAt
+	job := copyJob(j)
+	return &job, nil
+}
+
+// copyWebhook returns a copy of w that shares no state with the store
+func copyWebhook(w *Webhook) Webhook {
+	c := *w
+	c.Events = slices.Clone(w.Events)
+	return c
+}
+
+func (m *MemoryStore) CreateWebhook(ctx context.Context, webhook *Webhook) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	webhook.CreatedAt = time.Now().UTC()
+	webhook.UpdatedAt = webhook.CreatedAt
+	stored := copyWebhook(webhook)
+	m.webhooks[webhook.ID] = &stored
+	return nil
+}
+
+func (m *MemoryStore) GetWebhook(ctx context.Context, orgID, id uuid.UUID) (*Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w, ok := m.webhooks[id]
+	if !ok || w.OrganizationID != orgID {
+		return nil, ErrWebhookNotFound
+	}
+	webhook := copyWebhook(w)
+	return &webhook, nil
+}
+
+func (m *MemoryStore) ListWebhooks(ctx context.Context, orgID uuid.UUID) ([]Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	webhooks := []Webhook{}
+	for _, w := range m.webhooks {
+		if w.OrganizationID == orgID {
+			webhooks = append(webhooks, copyWebhook(w))
+		}
+	}
+	sort.Slice(webhooks, func(i, k int) bool {
+		if !webhooks[i].CreatedAt.Equal(webhooks[k].CreatedAt) {
+			return webhooks[i].CreatedAt.Before(webhooks[k].CreatedAt)
+		}
+		return webhooks[i].ID.String() < webhooks[k].ID.String()
+	})
+	return webhooks, nil
+}
+
+func (m *MemoryStore) UpdateWebhook(ctx context.Context, webhook *Webhook) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w, ok := m.webhooks[webhook.ID]
+	if !ok || w.OrganizationID != webhook.OrganizationID {
+		return ErrWebhookNotFound
+	}
+	w.URL = webhook.URL
+	w.Events = slices.Clone(webhook.Events)
+	w.Enabled = webhook.Enabled
+	w.UpdatedAt = time.Now().UTC()
+	*webhook = copyWebhook(w)
+	return nil
+}
+
+func (m *MemoryStore) DeleteWebhook(ctx context.Context, orgID, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w, ok := m.webhooks[id]
+	if !ok || w.OrganizationID != orgID {
+		return ErrWebhookNotFound
+	}
+	m.deleteWebhook(id)
+	return nil
+}
+
+// deleteWebhook removes a webhook and its deliveries; callers hold m.mu
+func (m *MemoryStore) deleteWebhook(id uuid.UUID) {
+	delete(m.webhooks, id)
+	for deliveryID, d := range m.deliveries {
+		if d.WebhookID == id {
+			delete(m.deliveries, deliveryID)
+		}
+	}
+}
+
+// copyOIDCClient returns a copy of c that shares no state with the store
+func copyOIDCClient(c *OIDCClient) OIDCClient {
+	client := *c
+	client.RedirectURIs = slices.Clone(c.RedirectURIs)
+	client.Secret = ""
+	return client
+}
+
+func (m *MemoryStore) CreateOIDCClient(ctx context.Context, client *OIDCClient) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	client.CreatedAt = time.Now().UTC()
+	stored := copyOIDCClient(client)
+	m.oidcClients[client.ID] = &stored
+	return nil
+}
+
+func (m *MemoryStore) GetOIDCClient(ctx context.Context, id uuid.UUID) (*OIDCClient, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.oidcClients[id]
+	if !ok {
+		return nil, ErrOIDCClientNotFound
+	}
+	client := copyOIDCClient(c)
+	return &client, nil
+}
+
+func (m *MemoryStore) ListOIDCClients(ctx context.Context, orgID uuid.UUID) ([]OIDCClient, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	clients := []OIDCClient{}
+	for _, c := range m.oidcClients {
+		if c.OrganizationID == orgID {
+			clients = append(clients, copyOIDCClient(c))
+		}
+	}
+	sort.Slice(clients, func(i, k int) bool {
+		if !clients[i].CreatedAt.Equal(clients[k].CreatedAt) {
+			return clients[i].CreatedAt.Before(clients[k].CreatedAt)
+		}
+		return clients[i].ID.String() < clients[k].ID.String()
+	})
+	return clients, nil
+}
+
+func (m *MemoryStore) DeleteOIDCClient(ctx context.Context, orgID, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.oidcClients[id]
+	if !ok || c.OrganizationID != orgID {
+		return ErrOIDCClientNotFound
+	}
+	delete(m.oidcClients, id)
+	return nil
+}
+
+// copyAccessGrant returns a copy of g that shares no state with the store
+func copyAccessGrant(g *AccessGrant) AccessGrant {
+	grant := *g
+	grant.Permissions = slices.Clone(g.Permissions)
+	return grant
+}
+
+func (m *MemoryStore) PutAccessGrant(ctx context.Context, grant *AccessGrant) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, g := range m.accessGrants {
+		if g.OrganizationID == grant.OrganizationID && g.UserID == grant.UserID {
+			delete(m.accessGrants, id)
+			grant.ID = id
+		}
+	}
+	grant.CreatedAt = time.Now().UTC()
+	stored := copyAccessGrant(grant)
+	m.accessGrants[grant.ID] = &stored
+	return nil
+}
+
+func (m *MemoryStore) GetAccessGrant(ctx context.Context, orgID, userID uuid.UUID) (*AccessGrant, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for _, g := range m.accessGrants {
+		if g.OrganizationID == orgID && g.UserID == userID && g.ExpiresAt.After(now) {
+			grant := copyAccessGrant(g)
+			return &grant, nil
+		}
+	}
+	return nil, ErrAccessGrantNotFound
+}
+
+func (m *MemoryStore) ListAccessGrants(ctx context.Context, orgID uuid.UUID) ([]AccessGrant, error) {
+	return m.listAccessGrants(func(g *AccessGrant) bool { return g.OrganizationID == orgID }), nil
+}
+
+func (m *MemoryStore) ListUserAccessGrants(ctx context.Context, userID uuid.UUID) ([]AccessGrant, error) {
+	return m.listAccessGrants(func(g *AccessGrant) bool { return g.UserID == userID }), nil
+}
+
+// listAccessGrants returns the live grants matching keep, soonest to
+// expire first
+func (m *MemoryStore) listAccessGrants(keep func(g *AccessGrant) bool) []AccessGrant {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	grants := []AccessGrant{}
+	for _, g := range m.accessGrants {
+		if keep(g) && g.ExpiresAt.After(now) {
+			grants = append(grants, copyAccessGrant(g))
+		}
+	}
+	sort.Slice(grants, func(i, k int) bool {
+		if !grants[i].ExpiresAt.Equal(grants[k].ExpiresAt) {
+			return grants[i].ExpiresAt.Before(grants[k].ExpiresAt)
+		}
+		return grants[i].ID.String() < grants[k].ID.String()
+	})
+	return grants
+}
+
+func (m *MemoryStore) DeleteAccessGrant(ctx context.Context, orgID, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	g, ok := m.accessGrants[id]
+	if !ok || g.OrganizationID != orgID {
+		return ErrAccessGrantNotFound
+	}
+	delete(m.accessGrants, id)
+	return nil
+}
+
+// memoryDirectorySync is a directory sync with its schedule and the
+// members it manages
+type memoryDirectorySync struct {
+	DirectorySync
+	nextSyncAt time.Time
+	users      []uuid.UUID
+}
+
+// copyDirectorySync returns a copy of s that shares no state with the store
+func copyDirectorySync(s *DirectorySync) *DirectorySync {
+	c := *s
+	c.GroupRoles = maps.Clone(s.GroupRoles)
+	if s.LastResult != nil {
+		r := *s.LastResult
+		c.LastResult = &r
+	}
+	return &c
+}
+
+func (m *MemoryStore) GetDirectorySync(ctx context.Context, orgID uuid.UUID) (*DirectorySync, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.directory[orgID]
+	if !ok {
+		return nil, ErrDirectorySyncNotFound
+	}
+	return copyDirectorySync(&s.DirectorySync), nil
+}
+
+func (m *MemoryStore) PutDirectorySync(ctx context.Context, sync *DirectorySync) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC()
+	stored, ok := m.directory[sync.OrganizationID]
+	if !ok {
+		stored = &memoryDirectorySync{}
+		sync.CreatedAt = now
+		m.directory[sync.OrganizationID] = stored
+	} else {
+		sync.CreatedAt, sync.LastResult = stored.CreatedAt, stored.LastResult
+	}
+	sync.UpdatedAt = now
+	stored.DirectorySync = *copyDirectorySync(sync)
+	stored.nextSyncAt = now
+	return nil
+}
+
+func (m *MemoryStore) DeleteDirectorySync(ctx context.Context, orgID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.directory[orgID]; !ok {
+		return ErrDirectorySyncNotFound
+	}
+	delete(m.directory, orgID)
+	return nil
+}
+
+func (m *MemoryStore) ClaimDueDirectorySyncs(ctx context.Context, now time.Time, interval time.Duration) ([]uuid.UUID, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	due := []uuid.UUID{}
+	for orgID, s := range m.directory {
+		if s.Enabled && !s.nextSyncAt.After(now) {
+			s.nextSyncAt = now.Add(interval)
+			due = append(due, orgID)
+		}
+	}
+	return due, nil
+}
+
+func (m *MemoryStore) RecordDirectorySyncResult(ctx context.Context, orgID uuid.UUID, result *DirectorySyncResult) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.directory[orgID]
+	if !ok {
+		return ErrDirectorySyncNotFound
+	}
+	r := *result
+	s.LastResult = &r
+	return nil
+}
+
+func (m *MemoryStore) ListDirectoryUsers(ctx context.Context, orgID uuid.UUID) ([]uuid.UUID, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ids := []uuid.UUID{}
+	if s, ok := m.directory[orgID]; ok {
+		ids = append(ids, s.users...)
+	}
+	slices.SortFunc(ids, func(a, b uuid.UUID) int { return strings.Compare(a.String(), b.String()) })
+	return ids, nil
+}
+
+func (m *MemoryStore) SetDirectoryUsers(ctx context.Context, orgID uuid.UUID, userIDs []uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.directory[orgID]
+	if !ok {
+		return ErrDirectorySyncNotFound
+	}
+	s.users = nil
+	for _, id := range userIDs {
+		if u, ok := m.liveUser(id); ok && u.OrganizationID == orgID {
+			s.users = append(s.users, id)
+		}
+	}
+	return nil
+}
+
+// copyDelivery returns a copy of d that shares no state with the store
+func copyDelivery(d *WebhookDelivery) WebhookDelivery {
+	c := *d
+	c.Payload = append([]byte(nil), d.Payload...)
+	return c
+}
+
+func (m *MemoryStore) CreateWebhookDelivery(ctx context.Context, delivery *WebhookDelivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delivery.Attempts = 0
+	delivery.CreatedAt = time.Now().UTC()
+	delivery.UpdatedAt = delivery.CreatedAt
+	stored := copyDelivery(delivery)
+	m.deliveries[delivery.ID] = &stored
+	return nil
+}
+
+func (m *MemoryStore) GetWebhookDelivery(ctx context.Context, id uuid.UUID) (*WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	d, ok := m.deliveries[id]
+	if !ok {
+		return nil, ErrWebhookDeliveryNotFound
+	}
+	delivery := copyDelivery(d)
+	return &delivery, nil
+}
+
+func (m *MemoryStore) RecordWebhookAttempt(ctx context.Context, id uuid.UUID, status string, responseStatus *int, lastError *string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if d, ok := m.deliveries[id]; ok {
+		d.Status = status
+		d.Attempts++
+		d.ResponseStatus = responseStatus
+		d.LastError = lastError
+		d.UpdatedAt = time.Now().UTC()
+	}
+	return nil
+}
+
+func (m *MemoryStore) ListWebhookDeliveries(ctx context.Context, orgID, webhookID uuid.UUID, limit, offset int) ([]WebhookDelivery, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	deliveries := []WebhookDelivery{}
+	for _, d := range m.deliveries {
+		if d.WebhookID == webhookID && d.OrganizationID == orgID {
+			deliveries = append(deliveries, copyDelivery(d))
+		}
+	}
+	sort.Slice(deliveries, func(i, k int) bool {
+		if !deliveries[i].CreatedAt.Equal(deliveries[k].CreatedAt) {
+			return deliveries[i].CreatedAt.After(deliveries[k].CreatedAt)
+		}
+		return deliveries[i].ID.String() < deliveries[k].ID.String()
+	})
+	page, total := paginate(deliveries, limit, offset)
+	return page, total, nil
+}
+
+// seatsUsed counts an organization's billable seats; callers hold m.mu
+func (m *MemoryStore) seatsUsed(orgID uuid.UUID) int {
+	count := 0
+	for _, u := range m.users {
+		if u.OrganizationID == orgID && u.Role == "sub_account" && u.DeletedAt == nil {
+			count++
+		}
+	}
+	return count
+}
+
+// recordSeatChange emits a seat usage record; callers hold m.mu
+func (m *MemoryStore) recordSeatChange(orgID, userID uuid.UUID, change int) {
+	m.seatRecords = append(m.seatRecords, SeatUsageRecord{
+		ID:             uuid.New(),
+		OrganizationID: orgID,
+		UserID:         userID,
+		Change:         change,
+		Seats:          m.seatsUsed(orgID),
+		PaidSeats:      m.organizations[orgID].MaxSubAccounts,
+		RecordedAt:     time.Now().UTC(),
+	})
+}
+
+func (m *MemoryStore) GetSeatUsage(ctx context.Context, orgID uuid.UUID) (*SeatUsage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	org, ok := m.liveOrganization(orgID)
+	if !ok {
+		return nil, ErrOrganizationNotFound
+	}
+	usage := &SeatUsage{
+		OrganizationID:   orgID,
+		SubscriptionTier: org.SubscriptionTier,
+		SeatOverage:      org.SeatOverage,
+		PaidSeats:        org.MaxSubAccounts,
+		SeatsUsed:        m.seatsUsed(orgID),
+		Records:          []SeatUsageRecord{},
+	}
+	usage.OverageSeats = max(usage.SeatsUsed-usage.PaidSeats, 0)
+	return usage, nil
+}
+
+func (m *MemoryStore) ListSeatUsageRecords(ctx context.Context, orgID uuid.UUID, limit, offset int) ([]SeatUsageRecord, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	records := []SeatUsageRecord{}
+	for i := len(m.seatRecords) - 1; i >= 0; i-- {
+		if m.seatRecords[i].OrganizationID == orgID {
+			records = append(records, m.seatRecords[i])
+		}
+	}
+	page, total := paginate(records, limit, offset)
+	return page, total, nil
+}
+
+// copyFeatureFlag returns a copy of f that shares no state with the store
+func copyFeatureFlag(f *FeatureFlag) FeatureFlag {
+	c := *f
+	c.Organizations = maps.Clone(f.Organizations)
+	return c
+}
+
+func (m *MemoryStore) ListFeatureFlags(ctx context.Context) ([]FeatureFlag, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	flags := make([]FeatureFlag, 0, len(m.featureFlags))
+	for _, f := range m.featureFlags {
+		flags = append(flags, copyFeatureFlag(f))
+	}
+	sort.Slice(flags, func(i, k int) bool { return flags[i].Key < flags[k].Key })
+	return flags, nil
+}
+
+func (m *MemoryStore) GetFeatureFlag(ctx context.Context, key string) (*FeatureFlag, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, ok := m.featureFlags[key]
+	if !ok {
+		return nil, ErrFeatureFlagNotFound
+	}
+	c := copyFeatureFlag(f)
+	return &c, nil
+}
+
+func (m *MemoryStore) PutFeatureFlag(ctx context.Context, flag *FeatureFlag) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC()
+	if stored, ok := m.featureFlags[flag.Key]; ok {
+		flag.CreatedAt, flag.Organizations = stored.CreatedAt, maps.Clone(stored.Organizations)
+	} else {
+		flag.CreatedAt, flag.Organizations = now, map[uuid.UUID]bool{}
+	}
+	flag.UpdatedAt = now
+	c := copyFeatureFlag(flag)
+	m.featureFlags[flag.Key] = &c
+	return nil
+}
+
+func (m *MemoryStore) DeleteFeatureFlag(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.featureFlags[key]; !ok {
+		return ErrFeatureFlagNotFound
+	}
+	delete(m.featureFlags, key)
+	return nil
+}
+
+func (m *MemoryStore) SetFeatureFlagOrganization(ctx context.Context, key string, orgID uuid.UUID, enabled bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, ok := m.featureFlags[key]
+	if !ok {
+		return ErrFeatureFlagNotFound
+	}
+	if _, ok := m.liveOrganization(orgID); !ok {
+		return ErrOrganizationNotFound
+	}
+	f.Organizations[orgID] = enabled
+	f.UpdatedAt = time.Now().UTC()
+	return nil
+}
+
+func (m *MemoryStore) RemoveFeatureFlagOrganization(ctx context.Context, key string, orgID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, ok := m.featureFlags[key]
+	if !ok {
+		return ErrFeatureFlagNotFound
+	}
+	delete(f.Organizations, orgID)
+	f.UpdatedAt = time.Now().UTC()
+	return nil
+}
+
+// hasFields reports whether fields hold every value in want, as
+// custom_fields @> want does in Postgres
+func hasFields(fields, want OrganizationFields) bool {
+	for key, value := range want {
+		if v, ok := fields[key]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *MemoryStore) ListOrganizationFields(ctx context.Context) ([]OrganizationField, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fields := make([]OrganizationField, 0, len(m.orgFields))
+	for _, f := range m.orgFields {
+		fields = append(fields, f)
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Key < fields[j].Key })
+	return fields, nil
+}
+
+func (m *MemoryStore) PutOrganizationField(ctx context.Context, field *OrganizationField) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC()
+	if stored, ok := m.orgFields[field.Key]; ok {
+		if stored.Type != field.Type {
+			return ErrOrganizationFieldType
+		}
+		field.CreatedAt = stored.CreatedAt
+	} else {
+		field.CreatedAt = now
+	}
+	field.UpdatedAt = now
+	m.orgFields[field.Key] = *field
+	return nil
+}
+
+func (m *MemoryStore) DeleteOrganizationField(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.orgFields[key]; !ok {
+		return ErrOrganizationFieldNotFound
+	}
+	delete(m.orgFields, key)
+	for _, org := range m.organizations {
+		if _, ok := org.CustomFields[key]; ok {
+			fields := maps.Clone(org.CustomFields)
+			delete(fields, key)
+			org.CustomFields = fields
+			org.Version++
+			org.UpdatedAt = time.Now().UTC()
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) UpdateOrganizationFields(ctx context.Context, orgID uuid.UUID, expectedVersion int, fields OrganizationFields) (*Organization, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	org, ok := m.liveOrganization(orgID)
+	if !ok {
+		return nil, ErrOrganizationNotFound
+	}
+	if org.Version != expectedVersion {
+		return nil, ErrVersionConflict
+	}
+	org.CustomFields = maps.Clone(fields)
+	org.Version++
+	org.UpdatedAt = time.Now().UTC()
+	o := org.Organization
+	return &o, nil
+}
+
+// paginate returns the page of items selected by limit and offset, and how
+// many items there are. Like COUNT(*) OVER () in Postgres, the total comes
+// with the rows, so it is 0 past the last page.
+func paginate[T any](items []T, limit, offset int) (page []T, total int) {
+	if offset >= len(items) {
+		return items[:0], 0
+	}
+	page = items[offset:]
+	if limit < len(page) {
+		page = page[:limit]
+	}
+	return page, len(items)
+}
+
+// copyInvitation returns a copy of inv that shares no state with the store
+func copyInvitation(inv *Invitation) *Invitation {
+	c := *inv
+	c.Token = ""
+	if inv.NextReminderAt != nil {
+		next := *inv.NextReminderAt
+		c.NextReminderAt = &next
+	}
+	return &c
+}
+
+func (m *MemoryStore) CreateInvitation(ctx context.Context, inv *Invitation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, existing := range m.invitations {
+		if existing.OrganizationID == inv.OrganizationID && existing.Email == inv.Email {
+			delete(m.invitations, id)
+		}
+	}
+	inv.CreatedAt = time.Now().UTC()
+	m.invitations[inv.ID] = copyInvitation(inv)
+	return nil
+}
+
+func (m *MemoryStore) GetInvitationByToken(ctx context.Context, tokenHash string) (*Invitation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, inv := range m.invitations {
+		if inv.TokenHash == tokenHash {
+			return copyInvitation(inv), nil
+		}
+	}
+	return nil, ErrInvitationNotFound
+}
+
+func (m *MemoryStore) ListInvitations(ctx context.Context, orgID uuid.UUID) ([]Invitation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	invitations := []Invitation{}
+	for _, inv := range m.invitations {
+		if inv.OrganizationID == orgID {
+			invitations = append(invitations, *copyInvitation(inv))
+		}
+	}
+	sort.Slice(invitations, func(i, k int) bool {
+		if !invitations[i].CreatedAt.Equal(invitations[k].CreatedAt) {
+			return invitations[i].CreatedAt.After(invitations[k].CreatedAt)
+		}
+		return invitations[i].ID.String() < invitations[k].ID.String()
+	})
+	return invitations, nil
+}
+
+func (m *MemoryStore) DeleteInvitation(ctx context.Context, orgID, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	inv, ok := m.invitations[id]
+	if !ok || inv.OrganizationID != orgID {
+		return ErrInvitationNotFound
+	}
+	delete(m.invitations, id)
+	return nil
+}
+
+func (m *MemoryStore) SetInvitationToken(ctx context.Context, id uuid.UUID, tokenHash string) (*Invitation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	inv, ok := m.invitations[id]
+	if !ok {
+		return nil, ErrInvitationNotFound
+	}
+	inv.TokenHash = tokenHash
+	return copyInvitation(inv), nil
+}
+
+func (m *MemoryStore) ClaimDueInvitationReminders(ctx context.Context, now time.Time, interval time.Duration, maxReminders int) ([]uuid.UUID, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	due := []uuid.UUID{}
+	for id, inv := range m.invitations {
+		if inv.NextReminderAt == nil || inv.NextReminderAt.After(now) || inv.Expired(now) {
+			continue
+		}
+		inv.RemindersSent++
+		inv.NextReminderAt = nil
+		if inv.RemindersSent < maxReminders {
+			next := now.Add(interval).UTC()
+			inv.NextReminderAt = &next
+		}
+		due = append(due, id)
+	}
+	return due, nil
+}
+
+func (m *MemoryStore) DeleteExpiredInvitations(ctx context.Context, cutoff time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	deleted := 0
+	for id, inv := range m.invitations {
+		if inv.ExpiresAt.Before(cutoff) {
+			delete(m.invitations, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (m *MemoryStore) GetAPIUsage(ctx context.Context, orgID uuid.UUID, since time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	day := since.UTC().Format("2006-01-02")
+	var calls int64
+	for key, n := range m.usage {
+		if key.orgID == orgID && key.day >= day {
+			calls += n
+		}
+	}
+	return calls, nil
+}
+
+func (m *MemoryStore) SetQuotaAlertLevel(ctx context.Context, orgID uuid.UUID, quota, period string, level int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := quotaAlertKey{orgID: orgID, quota: quota}
+	previous := m.quotaAlerts[key]
+	m.quotaAlerts[key] = quotaAlert{period: period, level: level}
+	if previous.period != period {
+		return 0, nil
+	}
+	return previous.level, nil
+}
+
+func (m *MemoryStore) DenyToken(ctx context.Context, orgID, tokenID uuid.UUID, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for key, until := range m.deniedTokens {
+		if key.orgID == orgID && until.Before(now) {
+			delete(m.deniedTokens, key)
+		}
+	}
+	key := deniedTokenKey{orgID: orgID, tokenID: tokenID}
+	if until, ok := m.deniedTokens[key]; !ok || expiresAt.After(until) {
+		m.deniedTokens[key] = expiresAt
+	}
+	return nil
+}
+
+func (m *MemoryStore) IsTokenDenied(ctx context.Context, orgID, tokenID uuid.UUID) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, denied := m.deniedTokens[deniedTokenKey{orgID: orgID, tokenID: tokenID}]
+	return denied, nil
+}
+
+func (m *MemoryStore) GetMFAFactor(ctx context.Context, userID uuid.UUID) (*MFAFactor, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	factor, ok := m.mfaFactors[userID]
+	if !ok {
+		return nil, ErrMFAFactorNotFound
+	}
+	copied := *factor
+	return &copied, nil
+}
+
+func (m *MemoryStore) PutMFAFactor(ctx context.Context, factor *MFAFactor) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	factor.CreatedAt = time.Now().UTC()
+	stored := *factor
+	m.mfaFactors[factor.UserID] = &stored
+	return nil
+}
+
+func (m *MemoryStore) UseMFAFactor(ctx context.Context, userID uuid.UUID, step int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	factor, ok := m.mfaFactors[userID]
+	if !ok || factor.LastUsedStep >= step {
+		return false, nil
+	}
+	factor.LastUsedStep = step
+	if factor.EnabledAt == nil {
+		now := time.Now().UTC()
+		factor.EnabledAt = &now
+	}
+	return true, nil
+}
+
+func (m *MemoryStore) DeleteMFAFactor(ctx context.Context, userID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.mfaFactors[userID]; !ok {
+		return ErrMFAFactorNotFound
+	}
+	delete(m.mfaFactors, userID)
+	return nil
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("organizations and members", func(t *testing.T) {
+		store := NewMemoryStore()
+
+		org, err := store.CreateOrganization(ctx, "Acme", "owner@acme.test", "Owner")
+		require.NoError(t, err)
+		require.Equal(t, "free", org.SubscriptionTier)
+
+		_, err = store.CreateOrganization(ctx, "Acme Again", "owner@acme.test", "Owner")
+		require.ErrorIs(t, err, ErrEmailTaken)
+
+		_, err = store.UpdateOrganizationTier(ctx, org.ID, org.Version, "free", 1, "")
+		require.NoError(t, err)
+		_, err = store.UpdateOrganizationTier(ctx, org.ID, org.Version, "pro", 0, "")
+		require.ErrorIs(t, err, ErrVersionConflict, "stale version")
+
+		_, err = store.AddUserToOrganization(ctx, org.ID, "one@acme.test", "One")
+		require.NoError(t, err)
+		_, err = store.AddUserToOrganization(ctx, org.ID, "two@acme.test", "Two")
+		require.ErrorIs(t, err, ErrMaxSubAccounts)
+
+		users, err := store.GetOrganizationUsers(ctx, org.ID)
+		require.NoError(t, err)
+		require.Len(t, users, 2)
+
+		owner, err := store.GetUserByEmail(ctx, "owner@acme.test")
+		require.NoError(t, err)
+		require.Equal(t, org.OwnerID, owner.ID)
+
+		missing, err := store.GetUserByEmail(ctx, "nobody@acme.test")
+		require.NoError(t, err)
+		require.Nil(t, missing)
+
+		_, err = store.GetOrganization(ctx, uuid.New())
+		require.ErrorIs(t, err, sql.ErrNoRows)
+	})
+
+	t.Run("batch lookups and listing totals", func(t *testing.T) {
+		store := NewMemoryStore()
+		org, err := store.CreateOrganization(ctx, "Acme", "owner@acme.test", "Owner")
+		require.NoError(t, err)
+		member, err := store.AddUserToOrganization(ctx, org.ID, "one@acme.test", "One")
+		require.NoError(t, err)
+		require.False(t, member.CreatedAt.IsZero())
+
+		users, err := store.GetUsersByIDs(ctx, []uuid.UUID{org.OwnerID, member.ID, uuid.New()})
+		require.NoError(t, err)
+		require.Len(t, users, 2)
+
+		orgs, err := store.GetOrganizationsByIDs(ctx, []uuid.UUID{org.ID, uuid.New()})
+		require.NoError(t, err)
+		require.Len(t, orgs, 1)
+
+		page, total, err := store.SearchUsers(ctx, "acme.test", false, 1, 1)
+		require.NoError(t, err)
+		require.Len(t, page, 1)
+		require.Equal(t, 2, total)
+
+		page, total, err = store.SearchUsers(ctx, "acme.test", false, 1, 2)
+		require.NoError(t, err)
+		require.Empty(t, page)
+		require.Zero(t, total, "no total past the last page")
+	})
+
+	t.Run("scoped contexts do not reach other organizations", func(t *testing.T) {
+		store := NewMemoryStore()
+		acme, err := store.CreateOrganization(ctx, "Acme", "owner@acme.test", "Owner")
+		require.NoError(t, err)
+		initech, err := store.CreateOrganization(ctx, "Initech", "owner@initech.test", "Owner")
+		require.NoError(t, err)
+
+		scoped := WithOrg(ctx, acme.ID)
+		_, err = store.GetUser(scoped, initech.OwnerID)
+		require.ErrorIs(t, err, sql.ErrNoRows)
+		_, err = store.GetOrganization(scoped, initech.ID)
+		require.ErrorIs(t, err, sql.ErrNoRows)
+		users, err := store.GetUsersByIDs(scoped, []uuid.UUID{acme.OwnerID, initech.OwnerID})
+		require.NoError(t, err)
+		require.Len(t, users, 1)
+		require.Equal(t, acme.OwnerID, users[0].ID)
+
+		_, err = store.GetUser(unscoped(scoped), initech.OwnerID)
+		require.NoError(t, err)
+	})
+
+	t.Run("suspended organizations do not contribute origins", func(t *testing.T) {
+		store := NewMemoryStore()
+		org, err := store.CreateOrganization(ctx, "Acme", "owner@acme.test", "Owner")
+		require.NoError(t, err)
+
+		version, err := store.UpdateOrganizationSettings(ctx, org.ID, org.Version, &OrganizationSettings{AllowedOrigins: []string{"https://app.acme.test"}})
+		require.NoError(t, err)
+		require.Equal(t, org.Version+1, version)
+
+		origins, err := store.ListOrganizationOrigins(ctx)
+		require.NoError(t, err)
+		require.Equal(t, []string{"https://app.acme.test"}, origins)
+
+		_, err = store.SetOrganizationSuspended(ctx, org.ID, true)
+		require.NoError(t, err)
+
+		origins, err = store.ListOrganizationOrigins(ctx)
+		require.NoError(t, err)
+		require.Empty(t, origins)
+	})
+
+	t.Run("refresh tokens rotate per user", func(t *testing.T) {
+		store := NewMemoryStore()
+		org, err := store.CreateOrganization(ctx, "Acme", "owner@acme.test", "Owner")
+		require.NoError(t, err)
+
+		first, err := store.CreateRefreshToken(ctx, org.OwnerID, DefaultSessionTerms)
+		require.NoError(t, err)
+		second, err := store.CreateRefreshToken(ctx, org.OwnerID, DefaultSessionTerms)
+		require.NoError(t, err)
+
+		_, err = store.ValidateRefreshToken(ctx, first)
+		require.ErrorIs(t, err, ErrRefreshTokenNotFound)
+
+		user, err := store.ValidateRefreshToken(ctx, second)
+		require.NoError(t, err)
+		require.Equal(t, org.OwnerID, user.ID)
+
+		require.NoError(t, store.InvalidateUserRefreshTokens(ctx, org.OwnerID))
+		_, err = store.ValidateRefreshToken(ctx, second)
+		require.ErrorIs(t, err, ErrRefreshTokenNotFound)
+	})
+
+	t.Run("soft deletes hide records until they are purged", func(t *testing.T) {
+		store := NewMemoryStore()
+		org, err := store.CreateOrganization(ctx, "Acme", "owner@acme.test", "Owner")
+		require.NoError(t, err)
+		member, err := store.AddUserToOrganization(ctx, org.ID, "one@acme.test", "One")
+		require.NoError(t, err)
+
+		require.ErrorIs(t, store.DeleteUser(ctx, org.OwnerID), ErrDeleteOwner)
+
+		token, err := store.CreateRefreshToken(ctx, member.ID, DefaultSessionTerms)
+		require.NoError(t, err)
+		require.NoError(t, store.DeleteUser(ctx, member.ID))
+		require.ErrorIs(t, store.DeleteUser(ctx, member.ID), ErrUserNotFound)
+
+		_, err = store.GetUser(ctx, member.ID)
+		require.ErrorIs(t, err, sql.ErrNoRows)
+		_, err = store.ValidateRefreshToken(ctx, token)
+		require.ErrorIs(t, err, ErrRefreshTokenNotFound)
+
+		users, _, err := store.SearchUsers(ctx, "one@", false, 10, 0)
+		require.NoError(t, err)
+		require.Empty(t, users)
+		users, _, err = store.SearchUsers(ctx, "one@", true, 10, 0)
+		require.NoError(t, err)
+		require.Len(t, users, 1)
+		require.NotNil(t, users[0].DeletedAt)
+
+		// The address is free again straight away
+		_, err = store.AddUserToOrganization(ctx, org.ID, "one@acme.test", "One Again")
+		require.NoError(t, err)
+
+		require.NoError(t, store.DeleteOrganization(ctx, org.ID))
+		_, err = store.GetOrganization(ctx, org.ID)
+		require.ErrorIs(t, err, sql.ErrNoRows)
+		_, err = store.GetUser(ctx, org.OwnerID)
+		require.ErrorIs(t, err, sql.ErrNoRows)
+
+		orgs, _, err := store.ListOrganizations(ctx, OrganizationFilter{}, 10, 0)
+		require.NoError(t, err)
+		require.Empty(t, orgs)
+		orgs, _, err = store.ListOrganizations(ctx, OrganizationFilter{IncludeDeleted: true}, 10, 0)
+		require.NoError(t, err)
+		require.Len(t, orgs, 1)
+
+		stats, err := store.GetPlatformStats(ctx)
+		require.NoError(t, err)
+		require.Zero(t, stats.Organizations)
+		require.Zero(t, stats.Users)
+
+		purged, err := store.PurgeRetained(ctx, RetentionScope{}, RetentionCutoffs{DeletedRecords: time.Now().Add(-time.Hour)})
+		require.NoError(t, err)
+		require.Zero(t, purged.Organizations, "inside the retention window")
+		require.Zero(t, purged.Users)
+
+		purged, err = store.PurgeRetained(ctx, RetentionScope{Except: []uuid.UUID{org.ID}}, RetentionCutoffs{DeletedRecords: time.Now().Add(time.Second)})
+		require.NoError(t, err)
+		require.Zero(t, purged.Organizations, "outside the scope")
+
+		purged, err = store.PurgeRetained(ctx, RetentionScope{OrgID: org.ID}, RetentionCutoffs{DeletedRecords: time.Now().Add(time.Second)})
+		require.NoError(t, err)
+		require.Equal(t, int64(1), purged.Organizations)
+		require.Equal(t, int64(3), purged.Users)
+
+		orgs, _, err = store.ListOrganizations(ctx, OrganizationFilter{IncludeDeleted: true}, 10, 0)
+		require.NoError(t, err)
+		require.Empty(t, orgs)
+	})
+}
+
+func TestOpenStore(t *testing.T) {
+	store, err := OpenStore("memory://", nil)
+	require.NoError(t, err)
+	require.IsType(t, &MemoryStore{}, store)
+}
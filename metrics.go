@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// newMetricsRegistry gathers the metrics served on /metrics: the Go runtime
+// and process, query latency, and the connection pools when db is set
+func newMetricsRegistry(db *DB) *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		dbQueryDuration,
+	)
+	if db != nil {
+		registry.MustRegister(collectors.NewDBStatsCollector(db.DB.DB, "primary"))
+		if db.replica != nil {
+			registry.MustRegister(collectors.NewDBStatsCollector(db.replica.DB.DB, "replica"))
+		}
+	}
+	return registry
+}
+
+// metricsHandler serves the registry to internal networks and platform
+// admins, like the health checks. Organizations' series are business data,
+// and collecting them reads the database.
+func (s *Server) metricsHandler() http.HandlerFunc {
+	metrics := promhttp.HandlerFor(s.metrics, promhttp.HandlerOpts{})
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.internalCaller(r) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		metrics.ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Members can add a second factor to their Google sign-in: a TOTP
+// authenticator app (RFC 6238, with the defaults every app supports:
+// HMAC-SHA1, six digits, 30 second steps). Once a code from it has been
+// confirmed, the Google callback asks for one on the hosted MFA page before
+// it signs them in.
+
+const (
+	totpDigits = 6
+	totpPeriod = 30 * time.Second
+	// totpSkew is how many steps either side of now a code is accepted
+	// from, for clocks that have drifted
+	totpSkew = 1
+	// totpSecretSize is the size of secrets in bytes, the 160 bits RFC
+	// 4226 recommends
+	totpSecretSize = 20
+)
+
+// mfaChallengeTTL bounds how long a member can take to answer the MFA page
+const mfaChallengeTTL = 5 * time.Minute
+
+// mfaMaxAttempts is how many wrong codes a sign-in survives
+const mfaMaxAttempts = 5
+
+var (
+	ErrMFAFactorNotFound = errors.New("two-step verification is not set up")
+	ErrMFAEnabled        = errors.New("two-step verification is already enabled")
+)
+
+// MFAFactor is a member's TOTP authenticator. It is pending until a code
+// from it is confirmed, and only enabled factors are asked for.
+type MFAFactor struct {
+	UserID uuid.UUID `db:"user_id"`
+	Secret string    `db:"secret"` // base32, as authenticator apps take it
+	// LastUsedStep is the time step of the last code accepted, so no code
+	// is accepted twice
+	LastUsedStep int64      `db:"last_used_step"`
+	EnabledAt    *time.Time `db:"enabled_at"`
+	CreatedAt    time.Time  `db:"created_at"`
+}
+
+// Enabled reports whether the factor is asked for at sign-in
+func (f *MFAFactor) Enabled() bool {
+	return f.EnabledAt != nil
+}
+
+// MFAEnrollment is a new factor's secret, for the member to add to their
+// authenticator app by hand or from a QR code of URI
+type MFAEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// MFACodeRequest carries a code from the member's authenticator app
+type MFACodeRequest struct {
+	Code string `json:"code"`
+}
+
+// newTOTPSecret returns a random base32 secret
+func newTOTPSecret() (string, error) {
+	b := make([]byte, totpSecretSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b), nil
+}
+
+// totpURI is the otpauth URI authenticator apps scan from a QR code
+func totpURI(issuer, account, secret string) string {
+	u := url.URL{
+		Scheme: "otpauth",
+		Host:   "totp",
+		Path:   "/" + issuer + ":" + account,
+		RawQuery: url.Values{
+			"secret":    {secret},
+			"issuer":    {issuer},
+			"algorithm": {"SHA1"},
+			"digits":    {fmt.Sprint(totpDigits)},
+			"period":    {fmt.Sprint(int(totpPeriod / time.Second))},
+		}.Encode(),
+	}
+	return u.String()
+}
+
+// totpCode is the code of key for a time step (RFC 4226 section 5.3)
+func totpCode(key []byte, step int64) string {
+	mac := hmac.New(sha1.New, key)
+	binary.Write(mac, binary.BigEndian, step)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	code := fmt.Sprintf("%010d", value)
+	return code[len(code)-totpDigits:]
+}
+
+// verifyTOTP checks a code against secret at now, returning the time step
+// it is for
+func verifyTOTP(secret, code string, now time.Time) (int64, bool) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return 0, false
+	}
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	current := now.Unix() / int64(totpPeriod/time.Second)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if hmac.Equal([]byte(totpCode(key, step)), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// mfaChallenge is a sign-in waiting for a code, carried by the MFA page's
+// ticket
+type mfaChallenge struct {
+	UserID uuid.UUID `json:"u"`
+	// State is the sign-in's OAuth state, with the loopback login or OIDC
+	// request it finishes, if any
+	State    string `json:"s"`
+	Attempts int    `json:"a,omitempty"`
+}
+
+// newMFATicket issues a ticket for c, made like an OIDC code: c followed by
+// a secret that makes it unguessable. Its key in the state store covers the
+// whole ticket, so it cannot be altered either.
+func newMFATicket(c *mfaChallenge) (string, error) {
+	secret, err := generateState()
+	if err != nil {
+		return "", err
+	}
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data) + "." + strings.TrimRight(secret, "="), nil
+}
+
+// parseMFATicket returns the challenge a ticket carries, without checking
+// it was issued
+func parseMFATicket(ticket string) *mfaChallenge {
+	encoded, _, ok := strings.Cut(ticket, ".")
+	if !ok {
+		return nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil
+	}
+	var c mfaChallenge
+	if json.Unmarshal(data, &c) != nil {
+		return nil
+	}
+	return &c
+}
+
+func mfaTicketKey(ticket string) string {
+	sum := sha256.Sum256([]byte(ticket))
+	return "mfa-challenge:" + hex.EncodeToString(sum[:])
+}
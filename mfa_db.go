@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/google/uuid"
+)
+
+const mfaFactorColumns = `user_id, secret, last_used_step, enabled_at, created_at`
+
+// GetMFAFactor reads the primary, so a factor is asked for as soon as it is
+// enabled
+func (db *DB) GetMFAFactor(ctx context.Context, userID uuid.UUID) (*MFAFactor, error) {
+	factor := &MFAFactor{}
+	err := db.GetContext(ctx, factor, `
+		SELECT `+mfaFactorColumns+` FROM mfa_factors WHERE user_id = $1
+	`, userID)
+	if err == sql.ErrNoRows {
+		return nil, ErrMFAFactorNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return factor, nil
+}
+
+// PutMFAFactor creates or replaces a user's factor, filling in its creation
+// time from the stored factor
+func (db *DB) PutMFAFactor(ctx context.Context, factor *MFAFactor) error {
+	return db.GetContext(ctx, factor, `
+		INSERT INTO mfa_factors (user_id, secret, last_used_step, enabled_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, last_used_step = EXCLUDED.last_used_step,
+		    enabled_at = EXCLUDED.enabled_at, created_at = CURRENT_TIMESTAMP
+		RETURNING `+mfaFactorColumns,
+		factor.UserID, factor.Secret, factor.LastUsedStep, factor.EnabledAt)
+}
+
+// UseMFAFactor moves the factor's last used step forward in one statement,
+// so of two sign-ins racing with the same code only one succeeds
+func (db *DB) UseMFAFactor(ctx context.Context, userID uuid.UUID, step int64) (bool, error) {
+	result, err := db.ExecContext(ctx, `
+		UPDATE mfa_factors
+		SET last_used_step = $2, enabled_at = COALESCE(enabled_at, NOW())
+		WHERE user_id = $1 AND last_used_step < $2
+	`, userID, step)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+func (db *DB) DeleteMFAFactor(ctx context.Context, userID uuid.UUID) error {
+	result, err := db.ExecContext(ctx, `DELETE FROM mfa_factors WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrMFAFactorNotFound
+	}
+	return nil
+}
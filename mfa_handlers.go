@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// handleEnrollMFA starts setting up two-step verification with a new
+// secret, replacing any pending one. Sign-ins ask for codes only once one
+// has been confirmed.
+func (s *Server) handleEnrollMFA(w http.ResponseWriter, r *http.Request) {
+	user, err := GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	current, err := s.store.GetMFAFactor(r.Context(), user.ID)
+	if err != nil && err != ErrMFAFactorNotFound {
+		s.logger.ErrorContext(r.Context(), "failed to get MFA factor", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if current != nil && current.Enabled() {
+		http.Error(w, ErrMFAEnabled.Error(), http.StatusConflict)
+		return
+	}
+
+	secret, err := newTOTPSecret()
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to generate TOTP secret", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err := s.store.PutMFAFactor(r.Context(), &MFAFactor{UserID: user.ID, Secret: secret}); err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to store MFA factor", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	issuer := defaultProductName
+	if branding := s.pageBranding(r.Context(), user.OrganizationID); branding != nil && branding.ProductName != "" {
+		issuer = branding.ProductName
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(MFAEnrollment{Secret: secret, URI: totpURI(issuer, user.Email, secret)})
+}
+
+// handleConfirmMFA enables a pending factor with a code from it, showing
+// the member's app is set up
+func (s *Server) handleConfirmMFA(w http.ResponseWriter, r *http.Request) {
+	user, err := GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req MFACodeRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	factor, err := s.store.GetMFAFactor(r.Context(), user.ID)
+	if err == ErrMFAFactorNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to get MFA factor", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if factor.Enabled() {
+		http.Error(w, ErrMFAEnabled.Error(), http.StatusConflict)
+		return
+	}
+
+	step, ok := verifyTOTP(factor.Secret, req.Code, time.Now())
+	if ok {
+		ok, err = s.store.UseMFAFactor(r.Context(), user.ID, step)
+		if err != nil {
+			s.logger.ErrorContext(r.Context(), "failed to enable MFA factor", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+	if !ok {
+		writeValidationError(w, &ValidationError{Field: "code", Message: "invalid or expired code"})
+		return
+	}
+
+	s.recordAudit(r, "user.mfa_enabled", user.OrganizationID, user.ID.String(), nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleDeleteMFA turns two-step verification off, or abandons setting it
+// up
+func (s *Server) handleDeleteMFA(w http.ResponseWriter, r *http.Request) {
+	user, err := GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := s.store.DeleteMFAFactor(r.Context(), user.ID); err != nil {
+		if err == ErrMFAFactorNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		s.logger.ErrorContext(r.Context(), "failed to delete MFA factor", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	s.recordAudit(r, "user.mfa_disabled", user.OrganizationID, user.ID.String(), nil)
+	w.WriteHeader(http.StatusNoContent)
+}
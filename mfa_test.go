@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/base32"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// currentTOTP is the code an authenticator app shows for secret now
+func currentTOTP(t *testing.T, secret string) string {
+	t.Helper()
+
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	require.NoError(t, err)
+	return totpCode(key, time.Now().Unix()/int64(totpPeriod/time.Second))
+}
+
+func TestTOTP(t *testing.T) {
+	// The SHA1 test vectors of RFC 6238 appendix B, cut to six digits
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1111111111: "050471",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, code := range vectors {
+		step, ok := verifyTOTP(secret, code, time.Unix(unix, 0))
+		require.True(t, ok, "code at %d", unix)
+		require.Equal(t, unix/30, step)
+	}
+
+	now := time.Unix(1111111109, 0)
+	_, ok := verifyTOTP(secret, "081804", now.Add(totpPeriod))
+	require.True(t, ok, "codes are accepted a step late")
+	_, ok = verifyTOTP(secret, "081804", now.Add(3*totpPeriod))
+	require.False(t, ok)
+	_, ok = verifyTOTP(secret, "081 804", now)
+	require.True(t, ok, "spaces are ignored")
+	for _, code := range []string{"", "81804", "0818040", "abcdef"} {
+		_, ok = verifyTOTP(secret, code, now)
+		require.False(t, ok, code)
+	}
+
+	generated, err := newTOTPSecret()
+	require.NoError(t, err)
+	require.Len(t, generated, 32)
+	uri, err := url.Parse(totpURI("Acme Portal", "owner@acme.test", generated))
+	require.NoError(t, err)
+	require.Equal(t, "otpauth", uri.Scheme)
+	require.Equal(t, "/Acme Portal:owner@acme.test", uri.Path)
+	require.Equal(t, generated, uri.Query().Get("secret"))
+}
+
+func TestMFATicket(t *testing.T) {
+	challenge := &mfaChallenge{UserID: uuid.New(), State: "state.loopback", Attempts: 2}
+	ticket, err := newMFATicket(challenge)
+	require.NoError(t, err)
+	require.Equal(t, challenge, parseMFATicket(ticket))
+
+	other, err := newMFATicket(challenge)
+	require.NoError(t, err)
+	require.NotEqual(t, mfaTicketKey(ticket), mfaTicketKey(other), "each ticket is redeemed on its own")
+
+	require.Nil(t, parseMFATicket("no-separator"))
+	require.Nil(t, parseMFATicket("!!!.secret"))
+}
+
+func TestMFAEnrollment(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	srv, err := NewServer(store, newTestConfig(t))
+	require.NoError(t, err)
+
+	acme, err := store.CreateOrganization(ctx, "Acme", "owner@acme.test", "Owner")
+	require.NoError(t, err)
+	owner, err := store.GetUser(ctx, acme.OwnerID)
+	require.NoError(t, err)
+	token, err := srv.tokenManager.GenerateToken(owner)
+	require.NoError(t, err)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		addCSRFToken(t, srv, req)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return w
+	}
+	enroll := func() MFAEnrollment {
+		w := do(http.MethodPost, "/me/mfa", "")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var enrollment MFAEnrollment
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&enrollment))
+		return enrollment
+	}
+	confirm := func(code string) *httptest.ResponseRecorder {
+		return do(http.MethodPost, "/me/mfa/confirm", `{"code":"`+code+`"}`)
+	}
+
+	require.Equal(t, http.StatusNotFound, confirm("123456").Code, "nothing to confirm yet")
+
+	first := enroll()
+	require.Contains(t, first.URI, "otpauth://totp/")
+	second := enroll()
+	require.NotEqual(t, first.Secret, second.Secret, "enrolling again replaces the pending secret")
+
+	require.Equal(t, http.StatusBadRequest, confirm(currentTOTP(t, first.Secret)).Code)
+	factor, err := store.GetMFAFactor(ctx, owner.ID)
+	require.NoError(t, err)
+	require.False(t, factor.Enabled(), "pending until a code is confirmed")
+
+	w := confirm(currentTOTP(t, second.Secret))
+	require.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+	factor, err = store.GetMFAFactor(ctx, owner.ID)
+	require.NoError(t, err)
+	require.True(t, factor.Enabled())
+
+	require.Equal(t, http.StatusConflict, confirm(currentTOTP(t, second.Secret)).Code)
+	require.Equal(t, http.StatusConflict, do(http.MethodPost, "/me/mfa", "").Code, "an enabled factor is turned off first")
+
+	require.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/me/mfa", "").Code)
+	require.Equal(t, http.StatusNotFound, do(http.MethodDelete, "/me/mfa", "").Code)
+	_, err = store.GetMFAFactor(ctx, owner.ID)
+	require.ErrorIs(t, err, ErrMFAFactorNotFound)
+}
+
+func TestMFAStore(t *testing.T) {
+	testdb := setupTestDB(t)
+	defer testdb.teardown(t)
+
+	ctx := context.Background()
+	org, err := testdb.DB.CreateOrganization(ctx, "MFA Org", "owner@mfa.test", "Owner")
+	require.NoError(t, err)
+
+	_, err = testdb.DB.GetMFAFactor(ctx, org.OwnerID)
+	require.ErrorIs(t, err, ErrMFAFactorNotFound)
+	ok, err := testdb.DB.UseMFAFactor(ctx, org.OwnerID, 100)
+	require.NoError(t, err)
+	require.False(t, ok, "there is no factor to use")
+
+	factor := &MFAFactor{UserID: org.OwnerID, Secret: "JBSWY3DPEHPK3PXP"}
+	require.NoError(t, testdb.DB.PutMFAFactor(ctx, factor))
+	require.False(t, factor.CreatedAt.IsZero())
+	require.False(t, factor.Enabled())
+
+	ok, err = testdb.DB.UseMFAFactor(ctx, org.OwnerID, 100)
+	require.NoError(t, err)
+	require.True(t, ok)
+	ok, err = testdb.DB.UseMFAFactor(ctx, org.OwnerID, 100)
+	require.NoError(t, err)
+	require.False(t, ok, "each step is used once")
+	ok, err = testdb.DB.UseMFAFactor(ctx, org.OwnerID, 99)
+	require.NoError(t, err)
+	require.False(t, ok, "earlier steps are not used either")
+
+	stored, err := testdb.DB.GetMFAFactor(ctx, org.OwnerID)
+	require.NoError(t, err)
+	require.True(t, stored.Enabled())
+	require.Equal(t, int64(100), stored.LastUsedStep)
+
+	require.NoError(t, testdb.DB.DeleteMFAFactor(ctx, org.OwnerID))
+	require.ErrorIs(t, testdb.DB.DeleteMFAFactor(ctx, org.OwnerID), ErrMFAFactorNotFound)
+}
@@ -2,9 +2,13 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type contextKey string
@@ -13,15 +17,26 @@ const (
 	userContextKey contextKey = "user"
 )
 
+var (
+	ErrInvalidToken          = errors.New("invalid token")
+	ErrOrganizationSuspended = errors.New("organization suspended")
+)
+
 type AuthMiddleware struct {
 	tokenManager *TokenManager
-	db           *DB
+	store        Store
+	allowlists   *IPAllowlists // nil lets members in from any address
+	hierarchy    *OrgHierarchy // nil confines members to their own organization
+	// audit records refusals and break-glass access by the IP allowlist
+	audit  func(r *http.Request, action string, orgID uuid.UUID, targetID string, metadata AuditMetadata)
+	events *AuthLogger // nil logs no auth events
 }
 
-func NewAuthMiddleware(tokenManager *TokenManager, db *DB) *AuthMiddleware {
+func NewAuthMiddleware(tokenManager *TokenManager, store Store) *AuthMiddleware {
 	return &AuthMiddleware{
 		tokenManager: tokenManager,
-		db:           db,
+		store:        store,
+		audit:        func(*http.Request, string, uuid.UUID, string, AuditMetadata) {},
 	}
 }
 
@@ -49,25 +64,93 @@ func (am *AuthMiddleware) RequireAuth(next http.Handler) http.Handler {
 			return
 		}
 
-		claims, err := am.tokenManager.ValidateToken(parts[1])
+		claims, user, err := am.validate(r.Context(), parts[1])
 		if err != nil {
-			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			switch err {
+			case ErrInvalidToken:
+				am.events.Log(r, AuthEventTokenRejected, nil, AuthReasonInvalidToken)
+				http.Error(w, "Invalid token", http.StatusUnauthorized)
+			case ErrUserNotFound:
+				am.events.Log(r, AuthEventTokenRejected, nil, AuthReasonUserNotFound)
+				http.Error(w, "User not found", http.StatusUnauthorized)
+			case ErrOrganizationSuspended:
+				am.events.Log(r, AuthEventTokenRejected, nil, AuthReasonOrgSuspended)
+				http.Error(w, "Organization suspended", http.StatusForbidden)
+			default:
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+			}
 			return
 		}
 
-		// Get user from database to ensure they still exist and have proper permissions
-		user, err := am.db.GetUser(r.Context(), claims.UserID)
-		if err != nil {
-			http.Error(w, "User not found", http.StatusUnauthorized)
+		// Add user to request context
+		r = r.WithContext(context.WithValue(r.Context(), userContextKey, user))
+		if claims.Guest && !guestMayReach(claims, r) {
+			am.events.Log(r, AuthEventPermissionDenied, user, AuthReasonGuestRestricted, "path", r.URL.Path)
+			http.Error(w, "Forbidden", http.StatusForbidden)
 			return
 		}
-
-		// Add user to request context
-		ctx := context.WithValue(r.Context(), userContextKey, user)
-		next.ServeHTTP(w, r.WithContext(ctx))
+		if !am.checkIPAllowlist(w, r, user) {
+			return
+		}
+		next.ServeHTTP(w, r)
 	})
 }
 
+// authenticate validates an access token and loads its user
+func (am *AuthMiddleware) authenticate(ctx context.Context, token string) (*User, error) {
+	_, user, err := am.validate(ctx, token)
+	return user, err
+}
+
+// validate checks an access token as authenticate does, returning its
+// claims too, and traces the lookups so slow logins can be attributed
+func (am *AuthMiddleware) validate(ctx context.Context, token string) (*Claims, *User, error) {
+	ctx, span := tracer.Start(ctx, "auth.authenticate")
+	defer span.End()
+
+	claims, err := am.tokenManager.ValidateToken(token)
+	if err != nil {
+		recordSpanError(span, err)
+		return nil, nil, ErrInvalidToken
+	}
+	// Tokens issued to OIDC clients do not grant API access
+	if len(claims.Audience) > 0 {
+		return nil, nil, ErrInvalidToken
+	}
+
+	// Guests stand in for their token. Users are loaded from the database
+	// to ensure they still exist and have proper permissions.
+	var user *User
+	if claims.Guest {
+		if user, err = am.guest(ctx, claims); err != nil {
+			recordSpanError(span, err)
+			return nil, nil, err
+		}
+	} else if user, err = am.store.GetUser(ctx, claims.UserID); err != nil {
+		recordSpanError(span, err)
+		return nil, nil, ErrUserNotFound
+	}
+	span.SetAttributes(
+		attribute.String("enduser.id", user.ID.String()),
+		attribute.String("organization.id", user.OrganizationID.String()),
+	)
+
+	// Members of suspended organizations are locked out; platform admins
+	// keep access so they can reinstate them
+	if !user.HasPermission(PermPlatformAdmin) {
+		suspended, err := am.store.IsOrganizationSuspended(ctx, user.OrganizationID)
+		if err != nil {
+			recordSpanError(span, err)
+			return nil, nil, err
+		}
+		if suspended {
+			return nil, nil, ErrOrganizationSuspended
+		}
+	}
+
+	return claims, user, nil
+}
+
 // RequirePermissions middleware ensures the user has all required permissions
 func (am *AuthMiddleware) RequirePermissions(perms ...Permission) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
@@ -79,6 +162,7 @@ func (am *AuthMiddleware) RequirePermissions(perms ...Permission) func(http.Hand
 			}
 
 			if !user.HasAllPermissions(perms...) {
+				am.events.Log(r, AuthEventPermissionDenied, user, AuthReasonMissingPermission, "permissions", perms, "path", r.URL.Path)
 				http.Error(w, "Forbidden", http.StatusForbidden)
 				return
 			}
@@ -99,6 +183,7 @@ func (am *AuthMiddleware) RequireAnyPermission(perms ...Permission) func(http.Ha
 			}
 
 			if !user.HasAnyPermission(perms...) {
+				am.events.Log(r, AuthEventPermissionDenied, user, AuthReasonMissingPermission, "permissions", perms, "path", r.URL.Path)
 				http.Error(w, "Forbidden", http.StatusForbidden)
 				return
 			}
@@ -108,7 +193,11 @@ func (am *AuthMiddleware) RequireAnyPermission(perms ...Permission) func(http.Ha
 	}
 }
 
-// RequireSameOrg middleware ensures the user belongs to the organization they're trying to access
+// RequireSameOrg middleware ensures the user belongs to the organization
+// they're trying to access, or to one it sits under, or holds an access
+// grant to it. Under a grant the request goes on with the user's
+// permissions narrowed to the grant's, so permission checks must follow.
+// Either way the request's store work is then confined to the organization.
 func (am *AuthMiddleware) RequireSameOrg(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user, err := GetUserFromContext(r.Context())
@@ -117,21 +206,39 @@ func (am *AuthMiddleware) RequireSameOrg(next http.Handler) http.Handler {
 			return
 		}
 
-		// Extract org ID from URL or request body
-		var targetOrgID string
-		if strings.Contains(r.URL.Path, "/organizations/") {
-			parts := strings.Split(r.URL.Path, "/")
-			for i, part := range parts {
-				if part == "organizations" && i+1 < len(parts) {
-					targetOrgID = parts[i+1]
-					break
+		// Extract org ID from the route's {orgID} parameter
+		if targetOrgID := r.PathValue("orgID"); targetOrgID != "" {
+			orgID, err := uuid.Parse(targetOrgID)
+			member := err == nil
+			if member {
+				member, err = am.hierarchy.Contains(r.Context(), user.OrganizationID, orgID)
+				if err != nil {
+					http.Error(w, "Internal server error", http.StatusInternalServerError)
+					return
 				}
 			}
-		}
-
-		if targetOrgID != "" && targetOrgID != user.OrganizationID.String() {
-			http.Error(w, "Forbidden", http.StatusForbidden)
-			return
+			if !member && err == nil {
+				delegate, grant, err := am.delegate(r, user, orgID)
+				switch {
+				case err == ErrOutsideIPAllowlist:
+					am.events.Log(r, AuthEventPermissionDenied, user, AuthReasonOutsideIPAllowlist, "path", r.URL.Path)
+					http.Error(w, err.Error(), http.StatusForbidden)
+					return
+				case err != nil:
+					http.Error(w, "Internal server error", http.StatusInternalServerError)
+					return
+				case delegate != nil:
+					ctx := context.WithValue(r.Context(), userContextKey, delegate)
+					r = r.WithContext(context.WithValue(ctx, accessGrantContextKey, grant))
+					member = true
+				}
+			}
+			if !member {
+				am.events.Log(r, AuthEventPermissionDenied, user, AuthReasonOtherOrganization, "path", r.URL.Path)
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			r = r.WithContext(WithOrg(r.Context(), orgID))
 		}
 
 		next.ServeHTTP(w, r)
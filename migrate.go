@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"io/fs"
+	"strconv"
+	"strings"
+
+	"github.com/pressly/goose/v3"
+	"github.com/pressly/goose/v3/lock"
+)
+
+//go:embed migrations/*.sql
+var embedMigrations embed.FS
+
+// latestMigrationVersion is the version of the newest migration embedded in
+// the binary, which a database must reach before the server is ready
+var latestMigrationVersion = func() int64 {
+	entries, err := embedMigrations.ReadDir("migrations")
+	if err != nil {
+		panic(err)
+	}
+	var latest int64
+	for _, entry := range entries {
+		prefix, _, _ := strings.Cut(entry.Name(), "_")
+		version, err := strconv.ParseInt(prefix, 10, 64)
+		if err != nil {
+			panic("migration " + entry.Name() + " has no version prefix")
+		}
+		latest = max(latest, version)
+	}
+	return latest
+}()
+
+// Migrate applies any pending migrations embedded in the binary. A Postgres
+// advisory lock is held while they run, so instances starting together
+// apply each migration once and the others wait for it to finish.
+func Migrate(ctx context.Context, db *DB) ([]*goose.MigrationResult, error) {
+	migrations, err := fs.Sub(embedMigrations, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	locker, err := lock.NewPostgresSessionLocker()
+	if err != nil {
+		return nil, err
+	}
+
+	provider, err := goose.NewProvider(goose.DialectPostgres, db.DB.DB, migrations,
+		goose.WithSessionLocker(locker),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return provider.Up(ctx)
+}
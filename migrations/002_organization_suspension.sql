@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE organizations ADD COLUMN suspended_at TIMESTAMP;
+
+-- +goose Down
+ALTER TABLE organizations DROP COLUMN suspended_at;
@@ -0,0 +1,10 @@
+-- +goose Up
+CREATE TABLE organization_api_usage (
+    organization_id UUID NOT NULL REFERENCES organizations(id),
+    day DATE NOT NULL,
+    calls BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (organization_id, day)
+);
+
+-- +goose Down
+DROP TABLE organization_api_usage;
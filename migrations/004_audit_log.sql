@@ -0,0 +1,26 @@
+-- +goose Up
+CREATE TABLE audit_events (
+    id BIGSERIAL PRIMARY KEY,
+    organization_id UUID,
+    actor_id UUID,
+    action VARCHAR(100) NOT NULL,
+    target_id VARCHAR(255) NOT NULL DEFAULT '',
+    metadata JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP NOT NULL,
+    prev_hash CHAR(64) NOT NULL,
+    hash CHAR(64) NOT NULL
+);
+
+CREATE INDEX audit_events_organization_id_idx ON audit_events (organization_id, id);
+
+CREATE TABLE audit_checkpoints (
+    id BIGSERIAL PRIMARY KEY,
+    event_id BIGINT NOT NULL REFERENCES audit_events(id),
+    hash CHAR(64) NOT NULL,
+    signature CHAR(64) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- +goose Down
+DROP TABLE audit_checkpoints;
+DROP TABLE audit_events;
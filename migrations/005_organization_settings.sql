@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE organizations ADD COLUMN settings JSONB NOT NULL DEFAULT '{}';
+
+-- +goose Down
+ALTER TABLE organizations DROP COLUMN settings;
@@ -0,0 +1,35 @@
+-- +goose Up
+-- Transactions that set app.organization_id only see and write that
+-- organization's rows. Without it, as for platform admin and background
+-- work, every row stays visible. FORCE applies the policies to the table
+-- owner, which the server connects as.
+ALTER TABLE organizations ENABLE ROW LEVEL SECURITY;
+ALTER TABLE organizations FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON organizations
+    USING (NULLIF(current_setting('app.organization_id', true), '') IS NULL
+           OR id = current_setting('app.organization_id', true)::uuid);
+
+ALTER TABLE users ENABLE ROW LEVEL SECURITY;
+ALTER TABLE users FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON users
+    USING (NULLIF(current_setting('app.organization_id', true), '') IS NULL
+           OR organization_id = current_setting('app.organization_id', true)::uuid);
+
+ALTER TABLE organization_api_usage ENABLE ROW LEVEL SECURITY;
+ALTER TABLE organization_api_usage FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON organization_api_usage
+    USING (NULLIF(current_setting('app.organization_id', true), '') IS NULL
+           OR organization_id = current_setting('app.organization_id', true)::uuid);
+
+-- +goose Down
+DROP POLICY tenant_isolation ON organization_api_usage;
+ALTER TABLE organization_api_usage NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE organization_api_usage DISABLE ROW LEVEL SECURITY;
+
+DROP POLICY tenant_isolation ON users;
+ALTER TABLE users NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE users DISABLE ROW LEVEL SECURITY;
+
+DROP POLICY tenant_isolation ON organizations;
+ALTER TABLE organizations NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE organizations DISABLE ROW LEVEL SECURITY;
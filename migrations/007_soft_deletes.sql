@@ -0,0 +1,23 @@
+-- +goose Up
+ALTER TABLE organizations ADD COLUMN deleted_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN deleted_at TIMESTAMP;
+
+-- A deleted user keeps its row until it is purged, but not its address,
+-- which can sign up again straight away
+ALTER TABLE users DROP CONSTRAINT users_email_key;
+CREATE UNIQUE INDEX users_email_key ON users (email) WHERE deleted_at IS NULL;
+
+CREATE INDEX organizations_deleted_at_idx ON organizations (deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX users_deleted_at_idx ON users (deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- +goose Down
+DROP INDEX users_deleted_at_idx;
+DROP INDEX organizations_deleted_at_idx;
+
+DELETE FROM refresh_tokens WHERE user_id IN (SELECT id FROM users WHERE deleted_at IS NOT NULL);
+DELETE FROM users WHERE deleted_at IS NOT NULL;
+DROP INDEX users_email_key;
+ALTER TABLE users ADD CONSTRAINT users_email_key UNIQUE (email);
+
+ALTER TABLE users DROP COLUMN deleted_at;
+ALTER TABLE organizations DROP COLUMN deleted_at;
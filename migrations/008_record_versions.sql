@@ -0,0 +1,13 @@
+-- +goose Up
+-- version counts the changes made to a row; writers that send the version
+-- they last read are refused if someone else changed it in the meantime
+ALTER TABLE organizations
+    ADD COLUMN version INT NOT NULL DEFAULT 1,
+    ADD COLUMN updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE users
+    ADD COLUMN version INT NOT NULL DEFAULT 1,
+    ADD COLUMN updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP;
+
+-- +goose Down
+ALTER TABLE users DROP COLUMN updated_at, DROP COLUMN version;
+ALTER TABLE organizations DROP COLUMN updated_at, DROP COLUMN version;
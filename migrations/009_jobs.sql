@@ -0,0 +1,22 @@
+-- +goose Up
+CREATE TABLE jobs (
+    id UUID PRIMARY KEY,
+    kind VARCHAR(100) NOT NULL,
+    payload JSONB NOT NULL DEFAULT 'null',
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    max_attempts INT NOT NULL,
+    run_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    locked_until TIMESTAMP,
+    last_error TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Workers poll for due jobs and for running jobs whose worker went away
+CREATE INDEX jobs_pending_idx ON jobs (run_at) WHERE status = 'pending';
+CREATE INDEX jobs_running_idx ON jobs (locked_until) WHERE status = 'running';
+CREATE INDEX jobs_status_created_at_idx ON jobs (status, created_at);
+
+-- +goose Down
+DROP TABLE jobs;
@@ -0,0 +1,26 @@
+-- +goose Up
+-- Revoked refresh tokens are kept for the revoked-session retention window
+-- instead of being deleted straight away
+ALTER TABLE refresh_tokens ADD COLUMN revoked_at TIMESTAMP;
+CREATE INDEX refresh_tokens_revoked_at_idx ON refresh_tokens (revoked_at) WHERE revoked_at IS NOT NULL;
+CREATE INDEX refresh_tokens_expires_at_idx ON refresh_tokens (expires_at);
+
+-- Per-organization retention windows that replace the defaults
+ALTER TABLE organizations ADD COLUMN retention JSONB NOT NULL DEFAULT '{}';
+
+-- An anchor records the last audit event removed by retention, so the
+-- chain can still be verified from the first event that remains
+CREATE TABLE audit_anchors (
+    id BIGSERIAL PRIMARY KEY,
+    event_id BIGINT NOT NULL,
+    hash CHAR(64) NOT NULL,
+    signature CHAR(64) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- +goose Down
+DROP TABLE audit_anchors;
+ALTER TABLE organizations DROP COLUMN retention;
+DROP INDEX refresh_tokens_expires_at_idx;
+DELETE FROM refresh_tokens WHERE revoked_at IS NOT NULL;
+ALTER TABLE refresh_tokens DROP COLUMN revoked_at;
@@ -0,0 +1,23 @@
+-- +goose Up
+ALTER TABLE organizations ADD COLUMN seat_overage VARCHAR(20) NOT NULL DEFAULT 'block';
+
+CREATE TABLE seat_usage_records (
+    id UUID PRIMARY KEY,
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL,
+    change INT NOT NULL,
+    seats INT NOT NULL,
+    paid_seats INT NOT NULL,
+    recorded_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX seat_usage_records_organization_id_recorded_at_idx ON seat_usage_records (organization_id, recorded_at);
+
+ALTER TABLE seat_usage_records ENABLE ROW LEVEL SECURITY;
+ALTER TABLE seat_usage_records FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON seat_usage_records
+    USING (NULLIF(current_setting('app.organization_id', true), '') IS NULL
+           OR organization_id = current_setting('app.organization_id', true)::uuid);
+
+-- +goose Down
+DROP TABLE seat_usage_records;
+ALTER TABLE organizations DROP COLUMN seat_overage;
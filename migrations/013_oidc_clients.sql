@@ -0,0 +1,19 @@
+-- +goose Up
+CREATE TABLE oidc_clients (
+    id UUID PRIMARY KEY,
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    redirect_uris JSONB NOT NULL DEFAULT '[]',
+    secret_hash VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX oidc_clients_organization_id_idx ON oidc_clients (organization_id);
+
+ALTER TABLE oidc_clients ENABLE ROW LEVEL SECURITY;
+ALTER TABLE oidc_clients FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON oidc_clients
+    USING (NULLIF(current_setting('app.organization_id', true), '') IS NULL
+           OR organization_id = current_setting('app.organization_id', true)::uuid);
+
+-- +goose Down
+DROP TABLE oidc_clients;
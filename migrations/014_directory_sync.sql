@@ -0,0 +1,42 @@
+-- +goose Up
+CREATE TABLE directory_syncs (
+    organization_id UUID PRIMARY KEY REFERENCES organizations(id) ON DELETE CASCADE,
+    url VARCHAR(2048) NOT NULL,
+    bind_dn TEXT NOT NULL,
+    bind_password TEXT NOT NULL,
+    base_dn TEXT NOT NULL,
+    user_filter TEXT NOT NULL,
+    email_attribute VARCHAR(64) NOT NULL,
+    name_attribute VARCHAR(64) NOT NULL,
+    group_roles JSONB NOT NULL DEFAULT '{}',
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    next_sync_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_result JSONB,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX directory_syncs_due_idx ON directory_syncs (next_sync_at) WHERE enabled;
+
+-- Members a sync imported or matched, which later syncs deactivate once
+-- the directory stops listing them
+CREATE TABLE directory_users (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE
+);
+CREATE INDEX directory_users_organization_id_idx ON directory_users (organization_id);
+
+ALTER TABLE directory_syncs ENABLE ROW LEVEL SECURITY;
+ALTER TABLE directory_syncs FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON directory_syncs
+    USING (NULLIF(current_setting('app.organization_id', true), '') IS NULL
+           OR organization_id = current_setting('app.organization_id', true)::uuid);
+
+ALTER TABLE directory_users ENABLE ROW LEVEL SECURITY;
+ALTER TABLE directory_users FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON directory_users
+    USING (NULLIF(current_setting('app.organization_id', true), '') IS NULL
+           OR organization_id = current_setting('app.organization_id', true)::uuid);
+
+-- +goose Down
+DROP TABLE directory_users;
+DROP TABLE directory_syncs;
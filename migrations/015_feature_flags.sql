@@ -0,0 +1,28 @@
+-- +goose Up
+CREATE TABLE feature_flags (
+    key VARCHAR(64) PRIMARY KEY,
+    description TEXT NOT NULL DEFAULT '',
+    enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    rollout_percent INT NOT NULL DEFAULT 0 CHECK (rollout_percent BETWEEN 0 AND 100),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Organizations a flag is turned on or off for, whatever its rollout
+CREATE TABLE feature_flag_organizations (
+    flag_key VARCHAR(64) NOT NULL REFERENCES feature_flags(key) ON DELETE CASCADE,
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    enabled BOOLEAN NOT NULL,
+    PRIMARY KEY (flag_key, organization_id)
+);
+CREATE INDEX feature_flag_organizations_organization_id_idx ON feature_flag_organizations (organization_id);
+
+ALTER TABLE feature_flag_organizations ENABLE ROW LEVEL SECURITY;
+ALTER TABLE feature_flag_organizations FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON feature_flag_organizations
+    USING (NULLIF(current_setting('app.organization_id', true), '') IS NULL
+           OR organization_id = current_setting('app.organization_id', true)::uuid);
+
+-- +goose Down
+DROP TABLE feature_flag_organizations;
+DROP TABLE feature_flags;
@@ -0,0 +1,16 @@
+-- +goose Up
+-- Encrypted email addresses and names are longer than the plaintext
+ALTER TABLE users ALTER COLUMN email TYPE TEXT;
+ALTER TABLE users ALTER COLUMN name TYPE TEXT;
+
+-- The HMAC of an encrypted user's email address, which is looked up and
+-- kept unique in place of the address
+ALTER TABLE users ADD COLUMN email_hash BYTEA;
+CREATE UNIQUE INDEX users_email_hash_key ON users (email_hash) WHERE deleted_at IS NULL;
+
+-- +goose Down
+DROP INDEX users_email_hash_key;
+ALTER TABLE users DROP COLUMN email_hash;
+
+ALTER TABLE users ALTER COLUMN name TYPE VARCHAR(255);
+ALTER TABLE users ALTER COLUMN email TYPE VARCHAR(255);
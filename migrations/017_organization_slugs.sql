@@ -0,0 +1,9 @@
+-- +goose Up
+-- Slugs name organizations in URLs. They are optional, and unique among
+-- live organizations so that a deleted organization's slug can be reused.
+ALTER TABLE organizations ADD COLUMN slug VARCHAR(63);
+CREATE UNIQUE INDEX organizations_slug_key ON organizations (slug) WHERE deleted_at IS NULL;
+
+-- +goose Down
+DROP INDEX organizations_slug_key;
+ALTER TABLE organizations DROP COLUMN slug;
@@ -0,0 +1,9 @@
+-- +goose Up
+-- An organization may sit under a parent, whose members can then act on it
+-- with the permissions their role grants them.
+ALTER TABLE organizations ADD COLUMN parent_id UUID REFERENCES organizations(id) ON DELETE SET NULL;
+CREATE INDEX organizations_parent_id_idx ON organizations (parent_id) WHERE parent_id IS NOT NULL;
+
+-- +goose Down
+DROP INDEX organizations_parent_id_idx;
+ALTER TABLE organizations DROP COLUMN parent_id;
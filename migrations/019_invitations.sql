@@ -0,0 +1,29 @@
+-- +goose Up
+-- Invitations wait for their invitee to accept them. The email address and
+-- name are encrypted like users' when PII_ENCRYPTION_KEYS is set, and
+-- email_hash finds an address's invitation without decrypting them.
+CREATE TABLE invitations (
+    id UUID PRIMARY KEY,
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    email TEXT NOT NULL,
+    email_hash BYTEA,
+    name TEXT NOT NULL,
+    invited_by UUID NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    reminders_sent INTEGER NOT NULL DEFAULT 0,
+    next_reminder_at TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX invitations_organization_id_idx ON invitations (organization_id);
+CREATE INDEX invitations_next_reminder_at_idx ON invitations (next_reminder_at) WHERE next_reminder_at IS NOT NULL;
+CREATE INDEX invitations_expires_at_idx ON invitations (expires_at);
+
+ALTER TABLE invitations ENABLE ROW LEVEL SECURITY;
+ALTER TABLE invitations FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON invitations
+    USING (NULLIF(current_setting('app.organization_id', true), '') IS NULL
+           OR organization_id = current_setting('app.organization_id', true)::uuid);
+
+-- +goose Down
+DROP TABLE invitations;
@@ -0,0 +1,13 @@
+-- +goose Up
+-- Members can hold more than one session when their organization allows
+-- it, and a session can end at a fixed time however often it is refreshed
+ALTER TABLE refresh_tokens DROP CONSTRAINT refresh_tokens_user_id_key;
+CREATE INDEX refresh_tokens_user_id_idx ON refresh_tokens (user_id);
+ALTER TABLE refresh_tokens ADD COLUMN session_expires_at TIMESTAMP;
+
+-- +goose Down
+ALTER TABLE refresh_tokens DROP COLUMN session_expires_at;
+DROP INDEX refresh_tokens_user_id_idx;
+DELETE FROM refresh_tokens a USING refresh_tokens b
+WHERE a.user_id = b.user_id AND (a.created_at, a.id) < (b.created_at, b.id);
+ALTER TABLE refresh_tokens ADD CONSTRAINT refresh_tokens_user_id_key UNIQUE (user_id);
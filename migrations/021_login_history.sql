@@ -0,0 +1,6 @@
+-- +goose Up
+-- Users list their own sign-ins, which are audit events targeting them
+CREATE INDEX audit_events_logins_idx ON audit_events (target_id, id) WHERE action = 'auth.login';
+
+-- +goose Down
+DROP INDEX audit_events_logins_idx;
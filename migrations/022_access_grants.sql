@@ -0,0 +1,23 @@
+-- +goose Up
+-- Organizations let users from other organizations, such as agencies,
+-- act on them with limited permissions for a limited time
+CREATE TABLE access_grants (
+    id UUID PRIMARY KEY,
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    permissions JSONB NOT NULL DEFAULT '[]',
+    granted_by UUID NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (organization_id, user_id)
+);
+CREATE INDEX access_grants_user_id_idx ON access_grants (user_id);
+
+ALTER TABLE access_grants ENABLE ROW LEVEL SECURITY;
+ALTER TABLE access_grants FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON access_grants
+    USING (NULLIF(current_setting('app.organization_id', true), '') IS NULL
+           OR organization_id = current_setting('app.organization_id', true)::uuid);
+
+-- +goose Down
+DROP TABLE access_grants;
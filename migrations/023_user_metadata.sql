@@ -0,0 +1,6 @@
+-- +goose Up
+-- Integrators attach their own references, such as employee IDs, to users
+ALTER TABLE users ADD COLUMN metadata JSONB NOT NULL DEFAULT '{}';
+
+-- +goose Down
+ALTER TABLE users DROP COLUMN metadata;
@@ -0,0 +1,18 @@
+-- +goose Up
+-- Custom fields platform admins define for CRM and support tooling
+CREATE TABLE organization_fields (
+    key VARCHAR(64) PRIMARY KEY,
+    type VARCHAR(16) NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE organizations ADD COLUMN custom_fields JSONB NOT NULL DEFAULT '{}';
+-- The admin listing filters organizations by their values
+CREATE INDEX organizations_custom_fields_idx ON organizations USING GIN (custom_fields jsonb_path_ops);
+
+-- +goose Down
+DROP INDEX organizations_custom_fields_idx;
+ALTER TABLE organizations DROP COLUMN custom_fields;
+DROP TABLE organization_fields;
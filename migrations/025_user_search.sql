@@ -0,0 +1,10 @@
+-- +goose Up
+-- Trigram indexes let members be searched by any part of their name or
+-- email, in an organization or across the platform
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+CREATE INDEX users_name_trgm_idx ON users USING GIN (name gin_trgm_ops);
+CREATE INDEX users_email_trgm_idx ON users USING GIN (email gin_trgm_ops);
+
+-- +goose Down
+DROP INDEX users_email_trgm_idx;
+DROP INDEX users_name_trgm_idx;
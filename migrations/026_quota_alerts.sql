@@ -0,0 +1,20 @@
+-- +goose Up
+-- The highest quota threshold each organization has been warned about,
+-- per quota. period is the month of a monthly quota, and empty for seats.
+CREATE TABLE organization_quota_alerts (
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    quota TEXT NOT NULL,
+    period TEXT NOT NULL,
+    level INTEGER NOT NULL,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (organization_id, quota)
+);
+
+ALTER TABLE organization_quota_alerts ENABLE ROW LEVEL SECURITY;
+ALTER TABLE organization_quota_alerts FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON organization_quota_alerts
+    USING (NULLIF(current_setting('app.organization_id', true), '') IS NULL
+           OR organization_id = current_setting('app.organization_id', true)::uuid);
+
+-- +goose Down
+DROP TABLE organization_quota_alerts;
@@ -0,0 +1,20 @@
+-- +goose Up
+-- Revoked access tokens, by their jti, kept until they would have expired
+CREATE TABLE token_denylist (
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    token_id UUID NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (organization_id, token_id)
+);
+
+CREATE INDEX token_denylist_expires_at_idx ON token_denylist (expires_at);
+
+ALTER TABLE token_denylist ENABLE ROW LEVEL SECURITY;
+ALTER TABLE token_denylist FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON token_denylist
+    USING (NULLIF(current_setting('app.organization_id', true), '') IS NULL
+           OR organization_id = current_setting('app.organization_id', true)::uuid);
+
+-- +goose Down
+DROP TABLE token_denylist;
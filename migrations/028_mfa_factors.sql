@@ -0,0 +1,13 @@
+-- +goose Up
+-- Members' TOTP authenticators, pending until enabled_at is set. Like
+-- refresh tokens they belong to the user, wherever the user moves.
+CREATE TABLE mfa_factors (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    secret TEXT NOT NULL,
+    last_used_step BIGINT NOT NULL DEFAULT 0,
+    enabled_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- +goose Down
+DROP TABLE mfa_factors;
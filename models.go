@@ -9,22 +9,34 @@ import (
 )
 
 type Organization struct {
-	ID               uuid.UUID `db:"id" json:"id"`
-	Name             string    `db:"name" json:"name"`
-	OwnerID          uuid.UUID `db:"owner_id" json:"owner_id"`
-	SubscriptionTier string    `db:"subscription_tier" json:"subscription_tier"`
-	MaxSubAccounts   int       `db:"max_sub_accounts" json:"max_sub_accounts"`
-	CreatedAt        time.Time `db:"created_at" json:"created_at"`
+	ID               uuid.UUID          `db:"id" json:"id"`
+	Name             string             `db:"name" json:"name"`
+	Slug             *string            `db:"slug" json:"slug,omitempty"`           // names the organization in URLs, if set
+	ParentID         *uuid.UUID         `db:"parent_id" json:"parent_id,omitempty"` // whose members may also act on it
+	OwnerID          uuid.UUID          `db:"owner_id" json:"owner_id"`
+	SubscriptionTier string             `db:"subscription_tier" json:"subscription_tier"`
+	MaxSubAccounts   int                `db:"max_sub_accounts" json:"max_sub_accounts"`
+	SeatOverage      string             `db:"seat_overage" json:"seat_overage"`
+	SuspendedAt      *time.Time         `db:"suspended_at" json:"suspended_at,omitempty"`
+	CustomFields     OrganizationFields `db:"custom_fields" json:"custom_fields,omitempty"` // values for the fields platform admins define
+	CreatedAt        time.Time          `db:"created_at" json:"created_at"`
+	DeletedAt        *time.Time         `db:"deleted_at" json:"deleted_at,omitempty"`
+	Version          int                `db:"version" json:"version"`
+	UpdatedAt        time.Time          `db:"updated_at" json:"updated_at"`
 }
 
 type User struct {
-	ID             uuid.UUID   `db:"id" json:"id"`
-	Email          string      `db:"email" json:"email"`
-	Name           string      `db:"name" json:"name"`
-	OrganizationID uuid.UUID   `db:"organization_id" json:"organization_id"`
-	Role           string      `db:"role" json:"role"`
-	Permissions    Permissions `db:"permissions" json:"permissions"`
-	CreatedAt      time.Time   `db:"created_at" json:"created_at"`
+	ID             uuid.UUID    `db:"id" json:"id"`
+	Email          string       `db:"email" json:"email"`
+	Name           string       `db:"name" json:"name"`
+	OrganizationID uuid.UUID    `db:"organization_id" json:"organization_id"`
+	Role           string       `db:"role" json:"role"`
+	Permissions    Permissions  `db:"permissions" json:"permissions"`
+	Metadata       UserMetadata `db:"metadata" json:"metadata,omitempty"`
+	CreatedAt      time.Time    `db:"created_at" json:"created_at"`
+	DeletedAt      *time.Time   `db:"deleted_at" json:"deleted_at,omitempty"`
+	Version        int          `db:"version" json:"version"`
+	UpdatedAt      time.Time    `db:"updated_at" json:"updated_at"`
 }
 
 type Permissions map[string]bool
@@ -42,3 +54,39 @@ func (p *Permissions) Scan(value interface{}) error {
 	}
 	return json.Unmarshal(value.([]byte), p)
 }
+
+// OrganizationSettings holds the options an organization's owners manage themselves
+type OrganizationSettings struct {
+	// AllowedOrigins lists the exact origins of the organization's own
+	// frontends, which are allowed to call the API cross-origin
+	AllowedOrigins []string `json:"allowed_origins"`
+	// Notifications posts selected events to a chat channel, if set
+	Notifications *ChatNotifications `json:"notifications,omitempty"`
+	// IPAllowlist lists the CIDR ranges members may call the API from;
+	// empty allows any address. Only the owner can change it.
+	IPAllowlist []string `json:"ip_allowlist,omitempty"`
+	// EmailDomains, if set, are the only domains, with their subdomains,
+	// that members can be invited from
+	EmailDomains []string `json:"email_domains,omitempty"`
+	// Sessions sets how long members stay signed in, within the
+	// platform's bounds. Only the owner can change it.
+	Sessions *SessionPolicy `json:"sessions,omitempty"`
+	// UserMetadataSchema, if set, is what members' metadata must fit
+	UserMetadataSchema *MetadataSchema `json:"user_metadata_schema,omitempty"`
+	// Branding is how emails and hosted pages present the organization
+	Branding *Branding `json:"branding,omitempty"`
+}
+
+// Value implements the driver.Valuer interface for OrganizationSettings
+func (s OrganizationSettings) Value() (driver.Value, error) {
+	return json.Marshal(s)
+}
+
+// Scan implements the sql.Scanner interface for OrganizationSettings
+func (s *OrganizationSettings) Scan(value interface{}) error {
+	if value == nil {
+		*s = OrganizationSettings{}
+		return nil
+	}
+	return json.Unmarshal(value.([]byte), s)
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Events organizations can have posted to their chat channel
+const (
+	NotifyMemberJoined        = "member.joined"
+	NotifyLoginFailures       = "login.failures" // a spike of failed logins
+	NotifySubscriptionChanged = "subscription.changed"
+	NotifyQuotaThreshold      = "quota.threshold"
+)
+
+// NotificationEvents lists every event chat notifications can subscribe to
+var NotificationEvents = []string{NotifyMemberJoined, NotifyLoginFailures, NotifySubscriptionChanged, NotifyQuotaThreshold}
+
+// Chat services notifications can be posted to
+const (
+	ChatSlack = "slack"
+	ChatTeams = "teams"
+)
+
+// chatWebhookHosts lists the hosts each chat service issues incoming
+// webhooks on. A leading dot matches any subdomain.
+var chatWebhookHosts = map[string][]string{
+	ChatSlack: {"hooks.slack.com"},
+	ChatTeams: {".webhook.office.com", ".logic.azure.com"},
+}
+
+// ChatNotifications posts selected events to a Slack or Microsoft Teams
+// incoming webhook
+type ChatNotifications struct {
+	Provider string `json:"provider"` // slack or teams
+	// WebhookURL is a credential, so it is only shown to members who may
+	// manage settings
+	WebhookURL string   `json:"webhook_url"`
+	Events     []string `json:"events"`
+}
+
+// ValidateChatNotifications checks a chat notification setting. The
+// webhook URL must be one the provider issues, unless config allows
+// private networks for local development.
+func ValidateChatNotifications(n *ChatNotifications, config *WebhookConfig) error {
+	var providerErr, urlErr error
+	if hosts, ok := chatWebhookHosts[n.Provider]; !ok {
+		providerErr = &ValidationError{Field: "notifications.provider", Message: "expected slack or teams"}
+	} else if err := config.ValidateURL(n.WebhookURL); err != nil {
+		urlErr = asField("notifications.webhook_url", err)
+	} else if !config.AllowPrivateNetworks {
+		u, _ := url.Parse(n.WebhookURL)
+		host := strings.ToLower(u.Hostname())
+		matches := func(h string) bool {
+			return host == h || (strings.HasPrefix(h, ".") && strings.HasSuffix(host, h))
+		}
+		if u.Scheme != "https" || !slices.ContainsFunc(hosts, matches) {
+			urlErr = &ValidationError{Field: "notifications.webhook_url", Message: fmt.Sprintf("expected a %s incoming webhook URL", n.Provider)}
+		}
+	}
+
+	return joinValidationErrors(providerErr, urlErr, validateNotificationEvents(n.Events))
+}
+
+func validateNotificationEvents(events []string) error {
+	if len(events) == 0 {
+		return &ValidationError{Field: "notifications.events", Message: ErrEmptyField.Error()}
+	}
+	for _, event := range events {
+		if !slices.Contains(NotificationEvents, event) {
+			return &ValidationError{Field: "notifications.events", Message: fmt.Sprintf("unknown event %q", event)}
+		}
+	}
+	return nil
+}
+
+// NotificationConfig sets when failed logins count as a spike
+type NotificationConfig struct {
+	LoginFailureThreshold int
+	LoginFailureWindow    time.Duration
+}
+
+// NewNotificationConfig creates a notification configuration from settings
+func NewNotificationConfig(settings Settings) *NotificationConfig {
+	threshold, err := strconv.Atoi(settings.get("NOTIFY_LOGIN_FAILURE_THRESHOLD", "10"))
+	if err != nil || threshold < 1 {
+		threshold = 10
+	}
+	window, err := time.ParseDuration(settings.get("NOTIFY_LOGIN_FAILURE_WINDOW", "10m"))
+	if err != nil || window <= 0 {
+		window = 10 * time.Minute
+	}
+	return &NotificationConfig{LoginFailureThreshold: threshold, LoginFailureWindow: window}
+}
+
+// chatNotificationJob is the kind of job that posts a chat notification
+const chatNotificationJob = "notification.send"
+
+type chatNotificationPayload struct {
+	OrganizationID uuid.UUID         `json:"organization_id"`
+	Event          string            `json:"event"`
+	SubjectID      string            `json:"subject_id,omitempty"`
+	Data           map[string]string `json:"data,omitempty"`
+}
+
+// ChatNotifier posts organizations' selected events to their chat channel.
+// Each notification is sent by a background job, so failed posts are
+// retried with the job runner's backoff.
+//
+// Failed logins are counted per instance: a spike is noticed once one
+// instance sees LoginFailureThreshold of them within LoginFailureWindow,
+// and reported at most once per window.
+type ChatNotifier struct {
+	store  Store
+	jobs   *JobRunner
+	client *http.Client
+	config *NotificationConfig
+	now    func() time.Time
+
+	mu       sync.Mutex
+	failures map[uuid.UUID][]time.Time
+	reported map[uuid.UUID]time.Time
+}
+
+// NewChatNotifier creates a notifier posting with client, which should
+// refuse private addresses like the webhook dispatcher's
+func NewChatNotifier(store Store, jobs *JobRunner, client *http.Client, config *NotificationConfig) *ChatNotifier {
+	n := &ChatNotifier{
+		store:    store,
+		jobs:     jobs,
+		client:   client,
+		config:   config,
+		now:      time.Now,
+		failures: make(map[uuid.UUID][]time.Time),
+		reported: make(map[uuid.UUID]time.Time),
+	}
+	jobs.Register(chatNotificationJob, n.send)
+	return n
+}
+
+// Notify queues a notification for an audited action, if it is one the
+// organization has subscribed its chat channel to
+func (n *ChatNotifier) Notify(ctx context.Context, event *AuditEvent) error {
+	if event.OrganizationID == nil {
+		return nil
+	}
+	orgID := *event.OrganizationID
+
+	payload := chatNotificationPayload{OrganizationID: orgID, SubjectID: event.TargetID}
+	switch event.Action {
+	case "user.added":
+		payload.Event = NotifyMemberJoined
+	case "organization.tier_changed":
+		payload.Event = NotifySubscriptionChanged
+		payload.Data = map[string]string{
+			"subscription_tier": event.Metadata["subscription_tier"],
+			"max_sub_accounts":  event.Metadata["max_sub_accounts"],
+		}
+	case "organization.quota_threshold":
+		payload.Event = NotifyQuotaThreshold
+		payload.SubjectID = ""
+		payload.Data = map[string]string{
+			"quota":     event.Metadata["quota"],
+			"threshold": event.Metadata["threshold"],
+			"used":      event.Metadata["used"],
+			"limit":     event.Metadata["limit"],
+		}
+	case "auth.login_failed":
+		count, spike := n.countLoginFailure(orgID)
+		if !spike {
+			return nil
+		}
+		payload.Event = NotifyLoginFailures
+		payload.SubjectID = ""
+		payload.Data = map[string]string{"count": strconv.Itoa(count)}
+	default:
+		return nil
+	}
+
+	settings, _, err := n.store.GetOrganizationSettings(ctx, orgID)
+	if err != nil {
+		return err
+	}
+	if !subscribesTo(settings.Notifications, payload.Event) {
+		return nil
+	}
+	_, err = n.jobs.Enqueue(ctx, chatNotificationJob, payload, time.Time{})
+	return err
+}
+
+// countLoginFailure counts a failed login and reports whether it makes a
+// spike not yet reported in this window
+func (n *ChatNotifier) countLoginFailure(orgID uuid.UUID) (int, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	now := n.now()
+	since := now.Add(-n.config.LoginFailureWindow)
+	recent := slices.DeleteFunc(n.failures[orgID], func(t time.Time) bool { return t.Before(since) })
+	recent = append(recent, now)
+	n.failures[orgID] = recent
+
+	// Forget quiet organizations rather than keep map entries for each
+	for id, times := range n.failures {
+		if times[len(times)-1].Before(since) {
+			delete(n.failures, id)
+		}
+	}
+	for id, last := range n.reported {
+		if last.Before(since) {
+			delete(n.reported, id)
+		}
+	}
+
+	if len(recent) < n.config.LoginFailureThreshold {
+		return len(recent), false
+	}
+	if _, ok := n.reported[orgID]; ok {
+		return len(recent), false
+	}
+	n.reported[orgID] = now
+	return len(recent), true
+}
+
+func subscribesTo(n *ChatNotifications, event string) bool {
+	return n != nil && slices.Contains(n.Events, event)
+}
+
+// send is the job handler posting a queued notification. The channel is
+// read when sending, so notifications follow settings changes made while
+// they were queued.
+func (n *ChatNotifier) send(ctx context.Context, raw json.RawMessage) error {
+	var payload chatNotificationPayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return err
+	}
+
+	settings, _, err := n.store.GetOrganizationSettings(ctx, payload.OrganizationID)
+	if err == ErrOrganizationNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !subscribesTo(settings.Notifications, payload.Event) {
+		return nil
+	}
+	org, err := n.store.GetOrganization(ctx, payload.OrganizationID)
+	if err != nil {
+		return err
+	}
+
+	text, err := n.message(ctx, org, &payload)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(chatMessage(settings.Notifications.Provider, text))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, settings.Notifications.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "huachuca-notifications/"+currentBuild.Version)
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s webhook returned %s", settings.Notifications.Provider, resp.Status)
+	}
+	return nil
+}
+
+// message renders a notification as text
+func (n *ChatNotifier) message(ctx context.Context, org *Organization, payload *chatNotificationPayload) (string, error) {
+	switch payload.Event {
+	case NotifyMemberJoined:
+		id, err := uuid.Parse(payload.SubjectID)
+		if err != nil {
+			return "", err
+		}
+		user, err := n.store.GetUser(ctx, id)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s (%s) joined %s.", user.Name, user.Email, org.Name), nil
+	case NotifyLoginFailures:
+		return fmt.Sprintf("%s failed logins to %s in the last %s.", payload.Data["count"], org.Name, n.config.LoginFailureWindow), nil
+	case NotifySubscriptionChanged:
+		return fmt.Sprintf("%s moved to the %s plan with %s seats.", org.Name, payload.Data["subscription_tier"], payload.Data["max_sub_accounts"]), nil
+	case NotifyQuotaThreshold:
+		return fmt.Sprintf("%s has used %s%% of its %s (%s of %s).", org.Name, payload.Data["threshold"],
+			quotaDescription(payload.Data["quota"]), payload.Data["used"], payload.Data["limit"]), nil
+	default:
+		return "", fmt.Errorf("unknown notification event %q", payload.Event)
+	}
+}
+
+// chatMessage returns the body each provider's incoming webhooks accept
+func chatMessage(provider, text string) interface{} {
+	if provider == ChatTeams {
+		// Teams workflows and connectors both take an Adaptive Card
+		return map[string]interface{}{
+			"type": "message",
+			"attachments": []map[string]interface{}{{
+				"contentType": "application/vnd.microsoft.card.adaptive",
+				"content": map[string]interface{}{
+					"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+					"type":    "AdaptiveCard",
+					"version": "1.4",
+					"body":    []map[string]interface{}{{"type": "TextBlock", "text": text, "wrap": true}},
+				},
+			}},
+		}
+	}
+	return map[string]string{"text": text}
+}
+
+// notifyChat queues the chat notification for an audited action
+func (s *Server) notifyChat(ctx context.Context, event *AuditEvent) {
+	if s.notifier == nil {
+		return
+	}
+	if err := s.notifier.Notify(ctx, event); err != nil {
+		s.logger.ErrorContext(ctx, "failed to queue chat notification", "action", event.Action, "error", err)
+	}
+}
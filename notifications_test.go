@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateChatNotifications(t *testing.T) {
+	config := &WebhookConfig{}
+	valid := func(provider, url string) *ChatNotifications {
+		return &ChatNotifications{Provider: provider, WebhookURL: url, Events: []string{NotifyMemberJoined}}
+	}
+
+	require.NoError(t, ValidateChatNotifications(valid(ChatSlack, "https://hooks.slack.com/services/T0/B0/x"), config))
+	require.NoError(t, ValidateChatNotifications(valid(ChatTeams, "https://acme.webhook.office.com/webhookb2/x"), config))
+	require.NoError(t, ValidateChatNotifications(valid(ChatTeams, "https://prod-1.westus.logic.azure.com/workflows/x"), config))
+
+	invalid := []*ChatNotifications{
+		valid("discord", "https://hooks.slack.com/services/T0/B0/x"),
+		valid(ChatSlack, "https://acme.webhook.office.com/webhookb2/x"),
+		valid(ChatSlack, "https://hooks.slack.com.evil.test/services/x"),
+		valid(ChatSlack, "http://hooks.slack.com/services/x"),
+		valid(ChatTeams, "https://webhook.office.com.evil.test/x"),
+		{Provider: ChatSlack, WebhookURL: "https://hooks.slack.com/services/x"},
+		{Provider: ChatSlack, WebhookURL: "https://hooks.slack.com/services/x", Events: []string{"user.renamed"}},
+	}
+	for _, n := range invalid {
+		var valErr *ValidationError
+		require.ErrorAs(t, ValidateChatNotifications(n, config), &valErr, "%+v", n)
+	}
+
+	// Local development may post anywhere
+	require.NoError(t, ValidateChatNotifications(valid(ChatSlack, "http://localhost:9000/hook"), &WebhookConfig{AllowPrivateNetworks: true}))
+}
+
+func TestChatMessage(t *testing.T) {
+	body, err := json.Marshal(chatMessage(ChatSlack, "hello"))
+	require.NoError(t, err)
+	require.JSONEq(t, `{"text":"hello"}`, string(body))
+
+	body, err = json.Marshal(chatMessage(ChatTeams, "hello"))
+	require.NoError(t, err)
+	require.Contains(t, string(body), `"contentType":"application/vnd.microsoft.card.adaptive"`)
+	require.Contains(t, string(body), `"text":"hello"`)
+}
+
+func TestChatNotifications(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("WEBHOOK_ALLOW_PRIVATE_NETWORKS", "true")
+	t.Setenv("JOB_POLL_INTERVAL", "5ms")
+	t.Setenv("NOTIFY_LOGIN_FAILURE_THRESHOLD", "3")
+
+	store := NewMemoryStore()
+	srv, err := NewServer(store, newTestConfig(t))
+	require.NoError(t, err)
+	defer srv.jobs.Close(ctx)
+
+	org, err := store.CreateOrganization(ctx, "Acme", "owner@acme.test", "Owner")
+	require.NoError(t, err)
+	owner, err := store.GetUser(ctx, org.OwnerID)
+	require.NoError(t, err)
+	token, err := srv.tokenManager.GenerateToken(owner)
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	var messages []string
+	channel := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Text string `json:"text"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		messages = append(messages, body.Text)
+		mu.Unlock()
+	}))
+	defer channel.Close()
+	received := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), messages...)
+	}
+
+	do := func(method, path, token string, body interface{}, header http.Header) *httptest.ResponseRecorder {
+		var reader io.Reader
+		if body != nil {
+			data, err := json.Marshal(body)
+			require.NoError(t, err)
+			reader = bytes.NewReader(data)
+		}
+		req := httptest.NewRequest(method, path, reader)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		if method != http.MethodGet {
+			addCSRFToken(t, srv, req)
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return w
+	}
+	settingsPath := "/organizations/" + org.ID.String() + "/settings"
+
+	w := do(http.MethodPut, settingsPath, token, OrganizationSettings{
+		Notifications: &ChatNotifications{
+			Provider:   ChatSlack,
+			WebhookURL: channel.URL,
+			Events:     []string{NotifyMemberJoined, NotifyLoginFailures},
+		},
+	}, http.Header{"If-Match": {versionETag(org.Version)}})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	t.Run("New members are announced", func(t *testing.T) {
+		w := do(http.MethodPost, "/organizations/"+org.ID.String()+"/users", token,
+			AddUserRequest{Email: "member@acme.test", Name: "Member"}, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		require.Eventually(t, func() bool { return len(received()) == 1 }, 2*time.Second, 10*time.Millisecond)
+		require.Equal(t, "Member (member@acme.test) joined Acme.", received()[0])
+	})
+
+	t.Run("Members cannot see the webhook URL", func(t *testing.T) {
+		member, err := store.GetUserByEmail(ctx, "member@acme.test")
+		require.NoError(t, err)
+		memberToken, err := srv.tokenManager.GenerateToken(member)
+		require.NoError(t, err)
+
+		w := do(http.MethodGet, settingsPath, memberToken, nil, nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		var settings OrganizationSettings
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&settings))
+		require.Equal(t, ChatSlack, settings.Notifications.Provider)
+		require.Empty(t, settings.Notifications.WebhookURL)
+
+		w = do(http.MethodGet, settingsPath, token, nil, nil)
+		require.Contains(t, w.Body.String(), channel.URL)
+	})
+
+	t.Run("A spike of failed logins is reported once", func(t *testing.T) {
+		for range 5 {
+			require.NoError(t, srv.notifier.Notify(ctx, &AuditEvent{Action: "auth.login_failed", OrganizationID: &org.ID}))
+		}
+
+		require.Eventually(t, func() bool { return len(received()) == 2 }, 2*time.Second, 10*time.Millisecond)
+		require.Equal(t, "3 failed logins to Acme in the last 10m0s.", received()[1])
+	})
+
+	t.Run("Unsubscribed events are not posted", func(t *testing.T) {
+		updated, err := store.UpdateOrganizationTier(ctx, org.ID, org.Version+1, "pro", 0, "")
+		require.NoError(t, err)
+		require.NoError(t, srv.notifier.Notify(ctx, &AuditEvent{
+			Action:         "organization.tier_changed",
+			OrganizationID: &org.ID,
+			TargetID:       org.ID.String(),
+			Metadata:       AuditMetadata{"subscription_tier": updated.SubscriptionTier},
+		}))
+
+		jobs, _, err := store.ListJobs(ctx, "", 100, 0)
+		require.NoError(t, err)
+		for _, job := range jobs {
+			require.NotContains(t, string(job.Payload), NotifySubscriptionChanged)
+		}
+	})
+
+	w = do(http.MethodPut, settingsPath, token, OrganizationSettings{
+		Notifications: &ChatNotifications{Provider: ChatSlack, WebhookURL: channel.URL},
+	}, http.Header{"If-Match": {versionETag(org.Version + 2)}})
+	require.Equal(t, http.StatusBadRequest, w.Code)
+}
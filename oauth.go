@@ -3,7 +3,6 @@ package main
 import (
 	"context"
 	"fmt"
-	"os"
 
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
@@ -15,12 +14,12 @@ type OAuthConfig struct {
 	config *oauth2.Config
 }
 
-func NewOAuthConfig() *OAuthConfig {
+func NewOAuthConfig(settings Settings) *OAuthConfig {
 	return &OAuthConfig{
 		config: &oauth2.Config{
-			ClientID:     os.Getenv("GOOGLE_CLIENT_ID"),
-			ClientSecret: os.Getenv("GOOGLE_CLIENT_SECRET"),
-			RedirectURL:  os.Getenv("GOOGLE_REDIRECT_URL"),
+			ClientID:     settings("GOOGLE_CLIENT_ID"),
+			ClientSecret: settings("GOOGLE_CLIENT_SECRET"),
+			RedirectURL:  settings.get("GOOGLE_REDIRECT_URL", profileFor(settings).OAuthRedirectURL),
 			Scopes: []string{
 				"https://www.googleapis.com/auth/userinfo.email",
 				"https://www.googleapis.com/auth/userinfo.profile",
@@ -42,17 +41,31 @@ func (o *OAuthConfig) GetAuthURL(state string) string {
 }
 
 func (o *OAuthConfig) Exchange(ctx context.Context, code string) (*oauth2.Token, error) {
-	return o.config.Exchange(ctx, code)
+	ctx, span := tracer.Start(ctx, "oauth.Exchange")
+	defer span.End()
+
+	token, err := o.config.Exchange(context.WithValue(ctx, oauth2.HTTPClient, tracedHTTPClient), code)
+	if err != nil {
+		recordSpanError(span, err)
+		return nil, err
+	}
+	return token, nil
 }
 
 func (o *OAuthConfig) GetUserInfo(ctx context.Context, token *oauth2.Token) (*GoogleUser, error) {
-	oauth2Service, err := oauth2api.NewService(ctx, option.WithTokenSource(o.config.TokenSource(ctx, token)))
+	ctx, span := tracer.Start(ctx, "oauth.GetUserInfo")
+	defer span.End()
+
+	client := o.config.Client(context.WithValue(ctx, oauth2.HTTPClient, tracedHTTPClient), token)
+	oauth2Service, err := oauth2api.NewService(ctx, option.WithHTTPClient(client))
 	if err != nil {
+		recordSpanError(span, err)
 		return nil, fmt.Errorf("failed to create oauth2 service: %w", err)
 	}
 
-	userInfo, err := oauth2Service.Userinfo.Get().Do()
+	userInfo, err := oauth2Service.Userinfo.Get().Context(ctx).Do()
 	if err != nil {
+		recordSpanError(span, err)
 		return nil, fmt.Errorf("failed to get user info: %w", err)
 	}
 
@@ -30,31 +30,44 @@ func generateState() (string, error) {
 }
 
 func (s *Server) handleGoogleLogin(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	if !s.checkCaptcha(w, r) {
 		return
 	}
 
 	state, err := generateState()
 	if err != nil {
-		s.logger.Error("failed to generate state", "error", err)
+		s.logger.ErrorContext(r.Context(), "failed to generate state", "error", err)
 		http.Error(w, "Authentication failed", http.StatusInternalServerError)
 		return
 	}
 
+	// Native apps name a loopback redirect to receive a login code on
+	loopback, err := parseLoopbackLogin(r.URL.Query())
+	if err != nil {
+		http.Error(w, "Invalid redirect_uri or code_challenge", http.StatusBadRequest)
+		return
+	}
+	if loopback != nil {
+		state = loopback.appendTo(state)
+	}
+	s.redirectToGoogle(w, r, state)
+}
+
+// redirectToGoogle stores state and sends the browser to the Google login,
+// which returns to handleGoogleCallback with it
+func (s *Server) redirectToGoogle(w http.ResponseWriter, r *http.Request, state string) {
 	// Store state with 5-minute expiration
-	s.stateStore.StoreState(state, 5*time.Minute)
+	if err := s.stateStore.StoreState(r.Context(), state, 5*time.Minute); err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to store state", "error", err)
+		http.Error(w, "Authentication failed", http.StatusInternalServerError)
+		return
+	}
 
 	authURL := s.oauth.GetAuthURL(state)
 	http.Redirect(w, r, authURL, http.StatusTemporaryRedirect)
 }
 
 func (s *Server) handleGoogleCallback(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	state := r.URL.Query().Get("state")
 	if state == "" {
 		http.Error(w, "Missing state parameter", http.StatusBadRequest)
@@ -62,7 +75,15 @@ func (s *Server) handleGoogleCallback(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Validate and delete state atomically
-	if !s.stateStore.ValidateAndDeleteState(state) {
+	valid, err := s.stateStore.ValidateAndDeleteState(r.Context(), state)
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to validate state", "error", err)
+		http.Error(w, "Authentication failed", http.StatusInternalServerError)
+		return
+	}
+	if !valid {
+		s.captcha.RecordFailure(GetClientIPFromContext(r.Context()))
+		s.authLog.Log(r, AuthEventLoginFailed, nil, AuthReasonInvalidState, "provider", "google")
 		http.Error(w, "Invalid or expired state", http.StatusBadRequest)
 		return
 	}
@@ -75,23 +96,26 @@ func (s *Server) handleGoogleCallback(w http.ResponseWriter, r *http.Request) {
 
 	token, err := s.oauth.Exchange(r.Context(), code)
 	if err != nil {
-		s.logger.Error("failed to exchange token", "error", err)
+		s.captcha.RecordFailure(GetClientIPFromContext(r.Context()))
+		s.authLog.Log(r, AuthEventLoginFailed, nil, AuthReasonExchangeFailed, "provider", "google")
+		s.logger.ErrorContext(r.Context(), "failed to exchange token", "error", err)
 		http.Error(w, "Authentication failed", http.StatusInternalServerError)
 		return
 	}
 
 	googleUser, err := s.oauth.GetUserInfo(r.Context(), token)
 	if err != nil {
-		s.logger.Error("failed to get user info", "error", err)
+		s.logger.ErrorContext(r.Context(), "failed to get user info", "error", err)
 		http.Error(w, "Authentication failed", http.StatusInternalServerError)
 		return
 	}
 
 	// Look up user by email
+	googleUser.Email = s.emailPolicy.Normalize(googleUser.Email)
 	var user *User
-	user, err = s.db.GetUserByEmail(r.Context(), googleUser.Email)
+	user, err = s.store.GetUserByEmail(r.Context(), googleUser.Email)
 	if err != nil {
-		s.logger.Error("database error during user lookup", "error", err)
+		s.logger.ErrorContext(r.Context(), "database error during user lookup", "error", err)
 		http.Error(w, "Authentication failed", http.StatusInternalServerError)
 		return
 	}
@@ -120,102 +144,188 @@ func (s *Server) handleGoogleCallback(w http.ResponseWriter, r *http.Request) {
 			ID:               uuid.New(),
 			Name:             fmt.Sprintf("%s's Organization", googleUser.Name),
 			OwnerID:          user.ID,
-			SubscriptionTier: "free",
-			MaxSubAccounts:   5,
+			SubscriptionTier: DefaultSubscriptionTier,
+			MaxSubAccounts:   SubscriptionTiers.DefaultLimit(),
+			SeatOverage:      SeatOverageBlock,
 		}
 
 		user.OrganizationID = org.ID
 
-		if err := s.db.CreateOrganizationWithOwner(r.Context(), org, user); err != nil {
-			s.logger.Error("failed to create organization and user", "error", err)
+		if err := s.store.CreateOrganizationWithOwner(r.Context(), org, user); err != nil {
+			s.logger.ErrorContext(r.Context(), "failed to create organization and user", "error", err)
 			http.Error(w, "Account creation failed", http.StatusInternalServerError)
 			return
 		}
+		s.recordAudit(r, "organization.created", org.ID, org.ID.String(), AuditMetadata{"name": org.Name})
+	}
+
+	// Members of suspended organizations are locked out, as in authenticate
+	if !user.HasPermission(PermPlatformAdmin) {
+		suspended, err := s.store.IsOrganizationSuspended(r.Context(), user.OrganizationID)
+		if err != nil {
+			s.logger.ErrorContext(r.Context(), "failed to check organization suspension", "error", err)
+			http.Error(w, "Authentication failed", http.StatusInternalServerError)
+			return
+		}
+		if suspended {
+			s.captcha.RecordFailure(GetClientIPFromContext(r.Context()))
+			s.recordAudit(r, "auth.login_failed", user.OrganizationID, user.ID.String(), AuditMetadata{
+				"provider": "google",
+				"reason":   AuthReasonOrgSuspended,
+			})
+			s.authLog.Log(r, AuthEventLoginFailed, user, AuthReasonOrgSuspended, "provider", "google")
+			http.Error(w, ErrOrganizationSuspended.Error(), http.StatusForbidden)
+			return
+		}
+	}
+
+	s.continueLogin(w, r, state, user)
+}
+
+// continueLogin signs user in, first asking for a code on the MFA page if
+// they have turned on two-step verification
+func (s *Server) continueLogin(w http.ResponseWriter, r *http.Request, state string, user *User) {
+	factor, err := s.store.GetMFAFactor(r.Context(), user.ID)
+	if err != nil && err != ErrMFAFactorNotFound {
+		s.logger.ErrorContext(r.Context(), "failed to get MFA factor", "error", err)
+		http.Error(w, "Authentication failed", http.StatusInternalServerError)
+		return
+	}
+	if factor != nil && factor.Enabled() {
+		s.renderMFAPage(w, r, http.StatusOK, &mfaChallenge{UserID: user.ID, State: state}, user, "")
+		return
+	}
+	s.completeLogin(w, r, state, user)
+}
+
+// completeLogin signs user in once they have passed every check, finishing
+// the loopback login or OIDC request the OAuth state carries, if any
+func (s *Server) completeLogin(w http.ResponseWriter, r *http.Request, state string, user *User) {
+	s.captcha.RecordSuccess(GetClientIPFromContext(r.Context()))
+	s.recordAudit(r, "auth.login", user.OrganizationID, user.ID.String(), loginMetadata(r, "google"))
+	s.orgMetrics.RecordLogin(user.OrganizationID)
+	s.authLog.Log(r, AuthEventLoginSucceeded, user, "", "provider", "google")
+
+	if loopback := loopbackFromState(state); loopback != nil {
+		s.redirectToLoopback(w, r, loopback, user)
+		return
 	}
+	if authz := oidcFromState(state); authz != nil {
+		s.redirectToOIDCClient(w, r, authz, user)
+		return
+	}
+	s.writeTokens(w, r, user)
+}
 
-	// Generate JWT access token
-	accessToken, err := s.tokenManager.GenerateToken(user)
+// writeTokens responds with a new token pair for user, starting a session
+// on the terms their organization sets
+func (s *Server) writeTokens(w http.ResponseWriter, r *http.Request, user *User) {
+	terms, err := s.sessionTerms(r.Context(), user)
 	if err != nil {
-		s.logger.Error("failed to generate access token", "error", err)
+		s.logger.ErrorContext(r.Context(), "failed to get session policy", "error", err)
 		http.Error(w, "Authentication failed", http.StatusInternalServerError)
 		return
 	}
 
 	// Generate refresh token
-	refreshToken, err := s.db.CreateRefreshToken(r.Context(), user.ID)
+	refreshToken, err := s.store.CreateRefreshToken(r.Context(), user.ID, terms)
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to create refresh token", "error", err)
+		http.Error(w, "Authentication failed", http.StatusInternalServerError)
+		return
+	}
+	s.writeTokenResponse(w, r, user, refreshToken, terms)
+}
+
+// writeTokenResponse responds with refreshToken and a new access token for
+// user that lasts as long as terms say
+func (s *Server) writeTokenResponse(w http.ResponseWriter, r *http.Request, user *User, refreshToken string, terms SessionTerms) {
+	accessToken, err := s.tokenManager.GenerateTokenWithTTL(user, terms.AccessTokenTTL)
 	if err != nil {
-		s.logger.Error("failed to create refresh token", "error", err)
+		s.logger.ErrorContext(r.Context(), "failed to generate access token", "error", err)
 		http.Error(w, "Authentication failed", http.StatusInternalServerError)
 		return
 	}
 
-	// Return tokens
 	response := TokenResponse{
 		AccessToken:  accessToken,
 		RefreshToken: refreshToken,
-		ExpiresIn:    900, // 15 minutes in seconds
+		ExpiresIn:    int(terms.AccessTokenTTL / time.Second),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		s.logger.Error("failed to encode response", "error", err)
+		s.logger.ErrorContext(r.Context(), "failed to encode response", "error", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 }
 
-func (s *Server) handleRefreshToken(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+// handleLogout revokes a refresh token. It answers 204 whether or not the
+// token was valid, so it reveals nothing about tokens it is given.
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	var req RefreshTokenRequest
+	if !decodeJSON(w, r, &req) {
 		return
 	}
+	if req.RefreshToken == "" {
+		writeValidationError(w, &ValidationError{Field: "refresh_token", Message: ErrEmptyField.Error()})
+		return
+	}
+
+	if err := s.store.InvalidateRefreshToken(r.Context(), req.RefreshToken); err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to revoke refresh token", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
 
+func (s *Server) handleRefreshToken(w http.ResponseWriter, r *http.Request) {
 	var req RefreshTokenRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if !decodeJSON(w, r, &req) {
 		return
 	}
 
 	// Validate refresh token
-	user, err := s.db.ValidateRefreshToken(r.Context(), req.RefreshToken)
+	user, err := s.store.ValidateRefreshToken(r.Context(), req.RefreshToken)
 	if err != nil {
 		switch err {
-		case ErrRefreshTokenNotFound, ErrRefreshTokenExpired:
+		case ErrRefreshTokenNotFound:
+			s.authLog.Log(r, AuthEventTokenRefreshFailed, nil, AuthReasonTokenNotFound)
+			http.Error(w, "Invalid or expired refresh token", http.StatusUnauthorized)
+		case ErrRefreshTokenExpired:
+			s.authLog.Log(r, AuthEventTokenRefreshFailed, nil, AuthReasonTokenExpired)
 			http.Error(w, "Invalid or expired refresh token", http.StatusUnauthorized)
 		default:
-			s.logger.Error("failed to validate refresh token", "error", err)
+			s.logger.ErrorContext(r.Context(), "failed to validate refresh token", "error", err)
 			http.Error(w, "Authentication failed", http.StatusInternalServerError)
 		}
 		return
 	}
 
-	// Generate new access token
-	accessToken, err := s.tokenManager.GenerateToken(user)
+	terms, err := s.sessionTerms(r.Context(), user)
 	if err != nil {
-		s.logger.Error("failed to generate access token", "error", err)
+		s.logger.ErrorContext(r.Context(), "failed to get session policy", "error", err)
 		http.Error(w, "Authentication failed", http.StatusInternalServerError)
 		return
 	}
 
-	// Generate new refresh token
-	refreshToken, err := s.db.CreateRefreshToken(r.Context(), user.ID)
+	// Exchange the refresh token for a new one in the same session
+	refreshToken, err := s.store.RotateRefreshToken(r.Context(), req.RefreshToken, terms)
 	if err != nil {
-		s.logger.Error("failed to create refresh token", "error", err)
-		http.Error(w, "Authentication failed", http.StatusInternalServerError)
+		switch err {
+		case ErrRefreshTokenNotFound:
+			// Used by a concurrent refresh
+			s.authLog.Log(r, AuthEventTokenRefreshFailed, user, AuthReasonTokenNotFound)
+			http.Error(w, "Invalid or expired refresh token", http.StatusUnauthorized)
+		default:
+			s.logger.ErrorContext(r.Context(), "failed to rotate refresh token", "error", err)
+			http.Error(w, "Authentication failed", http.StatusInternalServerError)
+		}
 		return
 	}
 
-	// Return new tokens
-	response := TokenResponse{
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
-		ExpiresIn:    900, // 15 minutes in seconds
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		s.logger.Error("failed to encode response", "error", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
+	s.authLog.Log(r, AuthEventTokenRefreshed, user, "")
+	s.writeTokenResponse(w, r, user, refreshToken, terms)
 }
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Huachuca is an OpenID Connect provider for applications organizations
+// register, so their members sign in to them with their Huachuca account.
+// Clients use the authorization code flow: /oidc/authorize sends the
+// browser through the Google login and back to the client's redirect URI
+// with a one-time code, which the client redeems at /oidc/token for an ID
+// token and an access token for /oidc/userinfo. Only members of the
+// client's organization can sign in to it.
+
+var ErrOIDCClientNotFound = errors.New("oidc client not found")
+
+// OIDCScopes lists the scopes clients may request. openid is required;
+// organization adds the member's organization ID and role, and metadata
+// the metadata integrators have attached to them.
+var OIDCScopes = []string{"openid", "profile", "email", "organization", "metadata"}
+
+// oidcCodeTTL bounds how long an authorization code can be redeemed
+const oidcCodeTTL = time.Minute
+
+// oidcTokenTTL is the lifetime of ID tokens and client access tokens
+const oidcTokenTTL = 15 * time.Minute
+
+// OIDCClient is an application an organization has registered to sign its
+// members in
+type OIDCClient struct {
+	ID             uuid.UUID        `db:"id" json:"client_id"`
+	OrganizationID uuid.UUID        `db:"organization_id" json:"organization_id"`
+	Name           string           `db:"name" json:"name"`
+	RedirectURIs   OIDCRedirectURIs `db:"redirect_uris" json:"redirect_uris"`
+	SecretHash     string           `db:"secret_hash" json:"-"`
+	// Secret authenticates the client at /oidc/token. It is only returned
+	// when the client is registered.
+	Secret    string    `db:"-" json:"client_secret,omitempty"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+// OIDCRedirectURIs are the redirect URIs a client may name; each must
+// match exactly
+type OIDCRedirectURIs []string
+
+// Value implements the driver.Valuer interface for OIDCRedirectURIs
+func (u OIDCRedirectURIs) Value() (driver.Value, error) {
+	if u == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(u)
+}
+
+// Scan implements the sql.Scanner interface for OIDCRedirectURIs
+func (u *OIDCRedirectURIs) Scan(value interface{}) error {
+	if value == nil {
+		*u = OIDCRedirectURIs{}
+		return nil
+	}
+	return json.Unmarshal(value.([]byte), u)
+}
+
+type OIDCClientRequest struct {
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirect_uris"`
+}
+
+// ValidateOIDCClientRequest checks a client registration. Redirect URIs
+// must use https, except on loopback addresses for local development.
+func ValidateOIDCClientRequest(req *OIDCClientRequest) error {
+	req.Name = strings.TrimSpace(req.Name)
+	var nameErr error
+	if req.Name == "" {
+		nameErr = &ValidationError{Field: "name", Message: ErrEmptyField.Error()}
+	} else if len(req.Name) > 100 {
+		nameErr = &ValidationError{Field: "name", Message: "must be at most 100 characters"}
+	}
+	return joinValidationErrors(nameErr, validateRedirectURIs(req.RedirectURIs))
+}
+
+func validateRedirectURIs(uris []string) error {
+	if len(uris) == 0 || len(uris) > 10 {
+		return &ValidationError{Field: "redirect_uris", Message: "expected 1 to 10 redirect URIs"}
+	}
+	for _, uri := range uris {
+		u, err := url.Parse(uri)
+		if err != nil || !u.IsAbs() || u.Host == "" || u.Fragment != "" || u.User != nil {
+			return &ValidationError{Field: "redirect_uris", Message: fmt.Sprintf("%q is not an absolute URL without a fragment", uri)}
+		}
+		if u.Scheme != "https" && !(u.Scheme == "http" && isLoopbackHost(u.Hostname())) {
+			return &ValidationError{Field: "redirect_uris", Message: fmt.Sprintf("%q must use https", uri)}
+		}
+	}
+	return nil
+}
+
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func newOIDCClientSecret() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return "oidcsec_" + hex.EncodeToString(b)
+}
+
+// OIDCConfig configures the provider. It is disabled unless OIDC_ISSUER
+// names the public URL the server is reached at.
+type OIDCConfig struct {
+	Issuer string
+	// Consent asks members on a hosted page before signing them in to a
+	// client, OIDC_REQUIRE_CONSENT
+	Consent bool
+}
+
+// NewOIDCConfig creates the provider configuration from settings
+func NewOIDCConfig(settings Settings) (*OIDCConfig, error) {
+	issuer := strings.TrimSuffix(settings("OIDC_ISSUER"), "/")
+	if issuer != "" {
+		u, err := url.Parse(issuer)
+		if err != nil || !u.IsAbs() || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+			return nil, fmt.Errorf("invalid OIDC_ISSUER %q", issuer)
+		}
+	}
+	return &OIDCConfig{Issuer: issuer, Consent: settings.getBool("OIDC_REQUIRE_CONSENT", false)}, nil
+}
+
+// Enabled reports whether the provider endpoints are served
+func (c *OIDCConfig) Enabled() bool {
+	return c.Issuer != ""
+}
+
+// oidcAuthorization is an authorization request a client made, carried
+// through the Google login in the OAuth state and then in the code
+type oidcAuthorization struct {
+	ClientID      uuid.UUID `json:"c"`
+	RedirectURI   string    `json:"r"`
+	Scope         string    `json:"sc"`
+	State         string    `json:"s,omitempty"`
+	Nonce         string    `json:"n,omitempty"`
+	CodeChallenge string    `json:"cc,omitempty"`
+
+	// Set when the code is issued
+	UserID   uuid.UUID `json:"u,omitempty"`
+	AuthTime int64     `json:"t,omitempty"`
+}
+
+// The authorization request follows the OAuth state after a separator
+// neither base64 state nor a loopback login contains. Like a loopback login
+// it is covered by the stored state, so it cannot be altered.
+const oidcStateSeparator = "."
+
+func (a *oidcAuthorization) encode() string {
+	data, _ := json.Marshal(a)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeOIDCAuthorization(encoded string) *oidcAuthorization {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil
+	}
+	var a oidcAuthorization
+	if json.Unmarshal(data, &a) != nil {
+		return nil
+	}
+	return &a
+}
+
+func (a *oidcAuthorization) appendTo(state string) string {
+	return state + oidcStateSeparator + a.encode()
+}
+
+// oidcFromState returns the authorization request carried in a validated
+// state, if any
+func oidcFromState(state string) *oidcAuthorization {
+	_, encoded, ok := strings.Cut(state, oidcStateSeparator)
+	if !ok {
+		return nil
+	}
+	return decodeOIDCAuthorization(encoded)
+}
+
+// newOIDCCode issues the authorization code for a, which carries the
+// request and the signed-in user, followed by a secret that makes it
+// unguessable. Its key in the state store covers the whole code, so it
+// cannot be altered either.
+func newOIDCCode(a *oidcAuthorization) (string, error) {
+	secret, err := generateState()
+	if err != nil {
+		return "", err
+	}
+	return a.encode() + "." + strings.TrimRight(secret, "="), nil
+}
+
+// parseOIDCCode returns the authorization a code carries, without checking
+// it was issued
+func parseOIDCCode(code string) *oidcAuthorization {
+	encoded, _, ok := strings.Cut(code, ".")
+	if !ok {
+		return nil
+	}
+	return decodeOIDCAuthorization(encoded)
+}
+
+func oidcCodeKey(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return "oidc-code:" + hex.EncodeToString(sum[:])
+}
+
+// oidcConsentKey is the state store key of a consent page's ticket, which
+// is made like a code and redeemed once for one
+func oidcConsentKey(ticket string) string {
+	sum := sha256.Sum256([]byte(ticket))
+	return "oidc-consent:" + hex.EncodeToString(sum[:])
+}
+
+// parseOIDCScope keeps the known scopes of a requested scope, in order,
+// and reports whether openid was among them
+func parseOIDCScope(scope string) (string, bool) {
+	var granted []string
+	for _, s := range strings.Fields(scope) {
+		if slices.Contains(OIDCScopes, s) && !slices.Contains(granted, s) {
+			granted = append(granted, s)
+		}
+	}
+	return strings.Join(granted, " "), slices.Contains(granted, "openid")
+}
+
+// OIDCClaims are the claims about a user that scopes grant, in ID tokens
+// and from /oidc/userinfo
+type OIDCClaims struct {
+	Name           string       `json:"name,omitempty"`
+	Email          string       `json:"email,omitempty"`
+	EmailVerified  bool         `json:"email_verified,omitempty"`
+	OrganizationID string       `json:"organization_id,omitempty"`
+	Role           string       `json:"role,omitempty"`
+	Metadata       UserMetadata `json:"metadata,omitempty"`
+}
+
+func oidcClaims(user *User, scope string) OIDCClaims {
+	var claims OIDCClaims
+	for _, s := range strings.Fields(scope) {
+		switch s {
+		case "profile":
+			claims.Name = user.Name
+		case "email":
+			// Members sign in with the Google account of their email
+			claims.Email, claims.EmailVerified = user.Email, true
+		case "organization":
+			claims.OrganizationID, claims.Role = user.OrganizationID.String(), user.Role
+		case "metadata":
+			claims.Metadata = user.Metadata
+		}
+	}
+	return claims
+}
+
+// IDTokenClaims are the claims of an ID token
+type IDTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce    string `json:"nonce,omitempty"`
+	AuthTime int64  `json:"auth_time"`
+	OIDCClaims
+}
+
+// OIDCUserInfo is the /oidc/userinfo response
+type OIDCUserInfo struct {
+	Subject string `json:"sub"`
+	OIDCClaims
+}
+
+// OIDCTokenResponse is the /oidc/token response
+type OIDCTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	IDToken     string `json:"id_token"`
+	Scope       string `json:"scope"`
+}
+
+// OIDCDiscovery is the provider metadata served at
+// /.well-known/openid-configuration
+type OIDCDiscovery struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+func (c *OIDCConfig) discovery() *OIDCDiscovery {
+	return &OIDCDiscovery{
+		Issuer:                            c.Issuer,
+		AuthorizationEndpoint:             c.Issuer + "/oidc/authorize",
+		TokenEndpoint:                     c.Issuer + "/oidc/token",
+		UserinfoEndpoint:                  c.Issuer + "/oidc/userinfo",
+		JWKSURI:                           c.Issuer + "/.well-known/jwks.json",
+		ScopesSupported:                   OIDCScopes,
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{"authorization_code"},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{"RS256"},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		ClaimsSupported: []string{
+			"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce",
+			"name", "email", "email_verified", "organization_id", "role", "metadata",
+		},
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+const oidcClientColumns = `id, organization_id, name, redirect_uris, secret_hash, created_at`
+
+// CreateOIDCClient stores a new client, filling in its creation time
+func (db *DB) CreateOIDCClient(ctx context.Context, client *OIDCClient) error {
+	return db.tenantTx(ctx, client.OrganizationID, func(tx *sqlx.Tx) error {
+		return tx.GetContext(ctx, client, `
+			INSERT INTO oidc_clients (id, organization_id, name, redirect_uris, secret_hash)
+			VALUES ($1, $2, $3, $4, $5)
+			RETURNING `+oidcClientColumns,
+			client.ID, client.OrganizationID, client.Name, client.RedirectURIs, client.SecretHash)
+	})
+}
+
+// GetOIDCClient retrieves a client by its ID, which is all the provider
+// endpoints know it by
+func (db *DB) GetOIDCClient(ctx context.Context, id uuid.UUID) (*OIDCClient, error) {
+	client := &OIDCClient{}
+	err := db.GetContext(ctx, client, `
+		SELECT `+oidcClientColumns+` FROM oidc_clients WHERE id = $1
+	`, id)
+	if err == sql.ErrNoRows {
+		return nil, ErrOIDCClientNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// ListOIDCClients retrieves an organization's clients, oldest first
+func (db *DB) ListOIDCClients(ctx context.Context, orgID uuid.UUID) ([]OIDCClient, error) {
+	clients := []OIDCClient{}
+	err := db.tenantRead(ctx, orgID, func(q sqlx.QueryerContext) error {
+		return sqlx.SelectContext(ctx, q, &clients, `
+			SELECT `+oidcClientColumns+` FROM oidc_clients WHERE organization_id = $1
+			ORDER BY created_at, id
+		`, orgID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return clients, nil
+}
+
+// DeleteOIDCClient removes one of an organization's clients. Codes and
+// tokens already issued to it fail once they are next checked.
+func (db *DB) DeleteOIDCClient(ctx context.Context, orgID, id uuid.UUID) error {
+	return db.tenantTx(ctx, orgID, func(tx *sqlx.Tx) error {
+		result, err := tx.ExecContext(ctx, `
+			DELETE FROM oidc_clients WHERE id = $1 AND organization_id = $2
+		`, id, orgID)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrOIDCClientNotFound
+		}
+		return nil
+	})
+}
@@ -0,0 +1,464 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// handleCreateOIDCClient registers an application. Its client secret is
+// only returned in this response.
+func (s *Server) handleCreateOIDCClient(w http.ResponseWriter, r *http.Request) {
+	orgID := pathOrgID(r)
+
+	var req OIDCClientRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if err := ValidateOIDCClientRequest(&req); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+
+	secret := newOIDCClientSecret()
+	client := &OIDCClient{
+		ID:             uuid.New(),
+		OrganizationID: orgID,
+		Name:           req.Name,
+		RedirectURIs:   req.RedirectURIs,
+		SecretHash:     HashToken(secret),
+	}
+	if err := s.store.CreateOIDCClient(r.Context(), client); err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to create OIDC client", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	client.Secret = secret
+
+	s.recordAudit(r, "oidc_client.created", orgID, client.ID.String(), AuditMetadata{
+		"name":          client.Name,
+		"redirect_uris": strings.Join(client.RedirectURIs, ","),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(client)
+}
+
+func (s *Server) handleListOIDCClients(w http.ResponseWriter, r *http.Request) {
+	clients, err := s.store.ListOIDCClients(r.Context(), pathOrgID(r))
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to list OIDC clients", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(clients)
+}
+
+func (s *Server) handleDeleteOIDCClient(w http.ResponseWriter, r *http.Request) {
+	orgID := pathOrgID(r)
+	clientID, err := uuid.Parse(r.PathValue("clientID"))
+	if err != nil {
+		http.Error(w, "Invalid client ID format", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.store.DeleteOIDCClient(r.Context(), orgID, clientID); err != nil {
+		switch err {
+		case ErrOIDCClientNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			s.logger.ErrorContext(r.Context(), "failed to delete OIDC client", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	s.recordAudit(r, "oidc_client.deleted", orgID, clientID.String(), nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleOIDCDiscovery serves the provider metadata clients configure
+// themselves from
+func (s *Server) handleOIDCDiscovery(w http.ResponseWriter, r *http.Request) {
+	if !s.oidc.Enabled() {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	json.NewEncoder(w).Encode(s.oidc.discovery())
+}
+
+// handleOIDCAuthorize starts a client's authorization request by sending
+// the browser to the Google login, carrying the request in the state.
+// Requests naming an unknown client or redirect URI are refused here;
+// other errors are reported to the client's redirect URI.
+func (s *Server) handleOIDCAuthorize(w http.ResponseWriter, r *http.Request) {
+	if !s.oidc.Enabled() {
+		http.NotFound(w, r)
+		return
+	}
+	q := r.URL.Query()
+
+	clientID, err := uuid.Parse(q.Get("client_id"))
+	if err != nil {
+		http.Error(w, "Invalid client_id", http.StatusBadRequest)
+		return
+	}
+	client, err := s.store.GetOIDCClient(r.Context(), clientID)
+	if err != nil {
+		switch err {
+		case ErrOIDCClientNotFound:
+			http.Error(w, "Invalid client_id", http.StatusBadRequest)
+		default:
+			s.logger.ErrorContext(r.Context(), "failed to get OIDC client", "error", err)
+			http.Error(w, "Authentication failed", http.StatusInternalServerError)
+		}
+		return
+	}
+	redirectURI := q.Get("redirect_uri")
+	if !slices.Contains(client.RedirectURIs, redirectURI) {
+		http.Error(w, "Invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	fail := func(code, description string) {
+		redirectWithParams(w, r, redirectURI, url.Values{
+			"error":             {code},
+			"error_description": {description},
+			"state":             {q.Get("state")},
+		})
+	}
+	if q.Get("response_type") != "code" {
+		fail("unsupported_response_type", "only the code response type is supported")
+		return
+	}
+	scope, ok := parseOIDCScope(q.Get("scope"))
+	if !ok {
+		fail("invalid_scope", "the openid scope is required")
+		return
+	}
+	challenge := q.Get("code_challenge")
+	if challenge != "" && (q.Get("code_challenge_method") != "S256" || len(challenge) != 43) {
+		fail("invalid_request", "code_challenge must be an S256 challenge")
+		return
+	}
+
+	state, err := generateState()
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to generate state", "error", err)
+		http.Error(w, "Authentication failed", http.StatusInternalServerError)
+		return
+	}
+	authz := &oidcAuthorization{
+		ClientID:      client.ID,
+		RedirectURI:   redirectURI,
+		Scope:         scope,
+		State:         q.Get("state"),
+		Nonce:         q.Get("nonce"),
+		CodeChallenge: challenge,
+	}
+	s.redirectToGoogle(w, r, authz.appendTo(state))
+}
+
+// redirectToOIDCClient finishes an authorization request once the Google
+// callback has signed user in, sending the browser back to the client with
+// a code, or first to the consent page when consent is required
+func (s *Server) redirectToOIDCClient(w http.ResponseWriter, r *http.Request, authz *oidcAuthorization, user *User) {
+	client, err := s.store.GetOIDCClient(r.Context(), authz.ClientID)
+	if err != nil {
+		switch err {
+		case ErrOIDCClientNotFound:
+			// Deleted during the login
+			http.Error(w, "Invalid client_id", http.StatusBadRequest)
+		default:
+			s.logger.ErrorContext(r.Context(), "failed to get OIDC client", "error", err)
+			http.Error(w, "Authentication failed", http.StatusInternalServerError)
+		}
+		return
+	}
+	if user.OrganizationID != client.OrganizationID {
+		redirectWithParams(w, r, authz.RedirectURI, url.Values{
+			"error":             {"access_denied"},
+			"error_description": {"the user is not a member of the client's organization"},
+			"state":             {authz.State},
+		})
+		return
+	}
+
+	authz.UserID, authz.AuthTime = user.ID, time.Now().Unix()
+	if s.oidc.Consent {
+		s.renderConsentPage(w, r, authz, client, user)
+		return
+	}
+	s.issueOIDCCode(w, r, authz, client)
+}
+
+// issueOIDCCode sends the browser back to the client with a code for the
+// user authz names
+func (s *Server) issueOIDCCode(w http.ResponseWriter, r *http.Request, authz *oidcAuthorization, client *OIDCClient) {
+	code, err := newOIDCCode(authz)
+	if err == nil {
+		err = s.stateStore.StoreState(r.Context(), oidcCodeKey(code), oidcCodeTTL)
+	}
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to issue authorization code", "error", err)
+		http.Error(w, "Authentication failed", http.StatusInternalServerError)
+		return
+	}
+
+	s.recordAudit(r, "oidc.authorized", client.OrganizationID, authz.UserID.String(), AuditMetadata{"client_id": client.ID.String()})
+	redirectWithParams(w, r, authz.RedirectURI, url.Values{"code": {code}, "state": {authz.State}})
+}
+
+// redirectWithParams redirects to uri with params added to its query,
+// leaving out empty ones
+func redirectWithParams(w http.ResponseWriter, r *http.Request, uri string, params url.Values) {
+	u, _ := url.Parse(uri)
+	q := u.Query()
+	for key, values := range params {
+		if len(values) > 0 && values[0] != "" {
+			q.Set(key, values[0])
+		}
+	}
+	u.RawQuery = q.Encode()
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+// OAuthError is the error body of the token endpoint (RFC 6749 section 5.2)
+type OAuthError struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func writeOAuthError(w http.ResponseWriter, status int, code, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(OAuthError{Error: code, Description: description})
+}
+
+var errInvalidOIDCClient = errors.New("invalid client credentials")
+
+// authenticateOIDCClient checks the client credentials of a token request,
+// given with HTTP basic authentication or in the form
+func (s *Server) authenticateOIDCClient(r *http.Request) (*OIDCClient, error) {
+	id, secret, ok := r.BasicAuth()
+	if ok {
+		// Both are form encoded before basic authentication (RFC 6749
+		// section 2.3.1)
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+
+	clientID, err := uuid.Parse(id)
+	if err != nil {
+		return nil, errInvalidOIDCClient
+	}
+	client, err := s.store.GetOIDCClient(r.Context(), clientID)
+	if err == ErrOIDCClientNotFound {
+		return nil, errInvalidOIDCClient
+	}
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(HashToken(secret)), []byte(client.SecretHash)) != 1 {
+		return nil, errInvalidOIDCClient
+	}
+	return client, nil
+}
+
+// oidcMember returns the user a client signed in, or nil if they have since
+// been deleted, left the client's organization or been locked out by its
+// suspension
+func (s *Server) oidcMember(ctx context.Context, client *OIDCClient, userID uuid.UUID) (*User, error) {
+	user, err := s.store.GetUser(ctx, userID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil || user.OrganizationID != client.OrganizationID {
+		return nil, err
+	}
+	suspended, err := s.store.IsOrganizationSuspended(ctx, client.OrganizationID)
+	if err != nil || suspended {
+		return nil, err
+	}
+	return user, nil
+}
+
+// handleOIDCToken redeems an authorization code for an ID token and an
+// access token for /oidc/userinfo. No refresh tokens are issued; clients
+// send the user through /oidc/authorize again.
+func (s *Server) handleOIDCToken(w http.ResponseWriter, r *http.Request) {
+	if !s.oidc.Enabled() {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "malformed form body")
+		return
+	}
+	client, err := s.authenticateOIDCClient(r)
+	if err != nil {
+		if err == errInvalidOIDCClient {
+			w.Header().Set("WWW-Authenticate", `Basic realm="huachuca"`)
+			writeOAuthError(w, http.StatusUnauthorized, "invalid_client", err.Error())
+			return
+		}
+		s.logger.ErrorContext(r.Context(), "failed to get OIDC client", "error", err)
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		writeOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "only authorization_code is supported")
+		return
+	}
+
+	code := r.PostForm.Get("code")
+	valid, err := s.stateStore.ValidateAndDeleteState(r.Context(), oidcCodeKey(code))
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to validate authorization code", "error", err)
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+	authz := parseOIDCCode(code)
+	if !valid || authz == nil || authz.ClientID != client.ID || authz.RedirectURI != r.PostForm.Get("redirect_uri") {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "invalid or expired code")
+		return
+	}
+	if authz.CodeChallenge != "" {
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if base64.RawURLEncoding.EncodeToString(sum[:]) != authz.CodeChallenge {
+			writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "code_verifier does not match the code_challenge")
+			return
+		}
+	}
+
+	user, err := s.oidcMember(r.Context(), client, authz.UserID)
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to get user", "error", err)
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+	if user == nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "invalid or expired code")
+		return
+	}
+
+	now := time.Now()
+	registered := jwt.RegisteredClaims{
+		Issuer:    s.oidc.Issuer,
+		Subject:   user.ID.String(),
+		Audience:  jwt.ClaimStrings{client.ID.String()},
+		ExpiresAt: jwt.NewNumericDate(now.Add(oidcTokenTTL)),
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+	}
+	idToken, err := s.tokenManager.Sign(IDTokenClaims{
+		RegisteredClaims: registered,
+		Nonce:            authz.Nonce,
+		AuthTime:         authz.AuthTime,
+		OIDCClaims:       oidcClaims(user, authz.Scope),
+	})
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to sign ID token", "error", err)
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+	accessToken, err := s.tokenManager.Sign(Claims{
+		RegisteredClaims: registered,
+		UserID:           user.ID,
+		OrganizationID:   user.OrganizationID,
+		Role:             user.Role,
+		Scope:            authz.Scope,
+	})
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to sign access token", "error", err)
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(OIDCTokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(oidcTokenTTL.Seconds()),
+		IDToken:     idToken,
+		Scope:       authz.Scope,
+	})
+}
+
+// handleOIDCUserInfo returns the claims a client access token's scope
+// grants. Tokens stop working when their client is deleted or their user
+// leaves the client's organization.
+func (s *Server) handleOIDCUserInfo(w http.ResponseWriter, r *http.Request) {
+	if !s.oidc.Enabled() {
+		http.NotFound(w, r)
+		return
+	}
+	invalidToken := func() {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "Authorization header required", http.StatusUnauthorized)
+		return
+	}
+	claims, err := s.tokenManager.ValidateToken(token)
+	if err != nil || len(claims.Audience) != 1 {
+		invalidToken()
+		return
+	}
+	clientID, err := uuid.Parse(claims.Audience[0])
+	if err != nil {
+		invalidToken()
+		return
+	}
+
+	client, err := s.store.GetOIDCClient(r.Context(), clientID)
+	if err == ErrOIDCClientNotFound {
+		invalidToken()
+		return
+	}
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to get OIDC client", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	user, err := s.oidcMember(r.Context(), client, claims.UserID)
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to get user", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		invalidToken()
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(OIDCUserInfo{Subject: user.ID.String(), OIDCClaims: oidcClaims(user, claims.Scope)})
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateOIDCClientRequest(t *testing.T) {
+	valid := []string{"https://app.acme.test/callback", "http://localhost:3000/callback", "http://127.0.0.1:8080/cb"}
+	require.NoError(t, ValidateOIDCClientRequest(&OIDCClientRequest{Name: " App ", RedirectURIs: valid}))
+
+	for _, uris := range [][]string{
+		nil,
+		{"http://app.acme.test/callback"},
+		{"https://app.acme.test/callback#fragment"},
+		{"/callback"},
+		{"https://user@app.acme.test/callback"},
+		make([]string, 11),
+	} {
+		require.Error(t, ValidateOIDCClientRequest(&OIDCClientRequest{Name: "App", RedirectURIs: uris}), uris)
+	}
+	require.Error(t, ValidateOIDCClientRequest(&OIDCClientRequest{Name: " ", RedirectURIs: valid}))
+}
+
+func TestParseOIDCScope(t *testing.T) {
+	scope, ok := parseOIDCScope("email openid unknown email organization")
+	require.True(t, ok)
+	require.Equal(t, "email openid organization", scope)
+
+	_, ok = parseOIDCScope("profile email")
+	require.False(t, ok)
+}
+
+func TestOIDCProvider(t *testing.T) {
+	t.Setenv("OIDC_ISSUER", "https://auth.acme.test/")
+	ctx := context.Background()
+
+	store := NewMemoryStore()
+	srv, err := NewServer(store, newTestConfig(t))
+	require.NoError(t, err)
+	org, err := store.CreateOrganization(ctx, "Acme", "owner@acme.test", "Owner")
+	require.NoError(t, err)
+	owner, err := store.GetUser(ctx, org.OwnerID)
+	require.NoError(t, err)
+	ownerToken, err := srv.tokenManager.GenerateToken(owner)
+	require.NoError(t, err)
+	other, err := store.CreateOrganization(ctx, "Other", "owner@other.test", "Other Owner")
+	require.NoError(t, err)
+	outsider, err := store.GetUser(ctx, other.OwnerID)
+	require.NoError(t, err)
+
+	const redirectURI = "https://app.acme.test/callback"
+	var client OIDCClient
+
+	t.Run("Clients are registered per organization", func(t *testing.T) {
+		body, err := json.Marshal(OIDCClientRequest{Name: "Wiki", RedirectURIs: []string{redirectURI}})
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, "/organizations/"+org.ID.String()+"/oidc/clients", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+ownerToken)
+		req.Header.Set("Content-Type", "application/json")
+		addCSRFToken(t, srv, req)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&client))
+		require.True(t, strings.HasPrefix(client.Secret, "oidcsec_"))
+
+		req = httptest.NewRequest(http.MethodGet, "/organizations/"+org.ID.String()+"/oidc/clients", nil)
+		req.Header.Set("Authorization", "Bearer "+ownerToken)
+		w = httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Contains(t, w.Body.String(), client.ID.String())
+		require.NotContains(t, w.Body.String(), client.Secret)
+	})
+
+	t.Run("Discovery names the endpoints", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/.well-known/openid-configuration", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		var discovery OIDCDiscovery
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&discovery))
+		require.Equal(t, "https://auth.acme.test", discovery.Issuer)
+		require.Equal(t, "https://auth.acme.test/oidc/token", discovery.TokenEndpoint)
+		require.Equal(t, "https://auth.acme.test/.well-known/jwks.json", discovery.JWKSURI)
+	})
+
+	verifier := "a-verifier-of-at-least-forty-three-characters-long"
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	authorize := func(params url.Values) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/oidc/authorize?"+params.Encode(), nil))
+		return w
+	}
+	authorizeParams := func() url.Values {
+		return url.Values{
+			"client_id":             {client.ID.String()},
+			"redirect_uri":          {redirectURI},
+			"response_type":         {"code"},
+			"scope":                 {"openid email organization"},
+			"state":                 {"app-state"},
+			"nonce":                 {"app-nonce"},
+			"code_challenge":        {challenge},
+			"code_challenge_method": {"S256"},
+		}
+	}
+
+	t.Run("Authorization goes through the Google login", func(t *testing.T) {
+		w := authorize(authorizeParams())
+		require.Equal(t, http.StatusTemporaryRedirect, w.Code, w.Body.String())
+		location, err := url.Parse(w.Header().Get("Location"))
+		require.NoError(t, err)
+		authz := oidcFromState(location.Query().Get("state"))
+		require.NotNil(t, authz)
+		require.Equal(t, client.ID, authz.ClientID)
+		require.Equal(t, "app-nonce", authz.Nonce)
+		require.Nil(t, loopbackFromState(location.Query().Get("state")))
+
+		// Unknown clients and redirect URIs are refused without redirecting
+		params := authorizeParams()
+		params.Set("redirect_uri", "https://evil.test/callback")
+		require.Equal(t, http.StatusBadRequest, authorize(params).Code)
+		params = authorizeParams()
+		params.Set("client_id", other.ID.String())
+		require.Equal(t, http.StatusBadRequest, authorize(params).Code)
+
+		// Other errors are sent to the client
+		params = authorizeParams()
+		params.Set("scope", "email")
+		w = authorize(params)
+		require.Equal(t, http.StatusFound, w.Code)
+		location, err = url.Parse(w.Header().Get("Location"))
+		require.NoError(t, err)
+		require.Equal(t, "invalid_scope", location.Query().Get("error"))
+		require.Equal(t, "app-state", location.Query().Get("state"))
+	})
+
+	issueCode := func(user *User) url.Values {
+		w := httptest.NewRecorder()
+		authz := &oidcAuthorization{
+			ClientID:      client.ID,
+			RedirectURI:   redirectURI,
+			Scope:         "openid email organization",
+			State:         "app-state",
+			Nonce:         "app-nonce",
+			CodeChallenge: challenge,
+		}
+		srv.redirectToOIDCClient(w, httptest.NewRequest(http.MethodGet, "/auth/callback/google", nil), authz, user)
+		require.Equal(t, http.StatusFound, w.Code)
+		location, err := url.Parse(w.Header().Get("Location"))
+		require.NoError(t, err)
+		require.Equal(t, "app.acme.test", location.Host)
+		require.Equal(t, "app-state", location.Query().Get("state"))
+		return location.Query()
+	}
+	exchange := func(code, verifier string) *httptest.ResponseRecorder {
+		form := url.Values{
+			"grant_type":    {"authorization_code"},
+			"code":          {code},
+			"redirect_uri":  {redirectURI},
+			"code_verifier": {verifier},
+		}
+		req := httptest.NewRequest(http.MethodPost, "/oidc/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(client.ID.String(), client.Secret)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return w
+	}
+	userinfo := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/oidc/userinfo", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return w
+	}
+
+	var tokens OIDCTokenResponse
+
+	t.Run("The code is exchanged for an ID token once", func(t *testing.T) {
+		code := issueCode(owner).Get("code")
+
+		w := exchange(code, verifier)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&tokens))
+		require.Equal(t, "Bearer", tokens.TokenType)
+
+		var claims IDTokenClaims
+		token, err := jwt.ParseWithClaims(tokens.IDToken, &claims, func(*jwt.Token) (interface{}, error) {
+			return srv.tokenManager.publicKey, nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, jwksKeyID, token.Header["kid"])
+		require.Equal(t, "https://auth.acme.test", claims.Issuer)
+		require.Equal(t, owner.ID.String(), claims.Subject)
+		require.Equal(t, jwt.ClaimStrings{client.ID.String()}, claims.Audience)
+		require.Equal(t, "app-nonce", claims.Nonce)
+		require.Equal(t, "owner@acme.test", claims.Email)
+		require.Equal(t, org.ID.String(), claims.OrganizationID)
+		require.Empty(t, claims.Name)
+
+		w = exchange(code, verifier)
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Contains(t, w.Body.String(), "invalid_grant")
+	})
+
+	t.Run("The code needs the verifier and the client secret", func(t *testing.T) {
+		code := issueCode(owner).Get("code")
+		require.Equal(t, http.StatusBadRequest, exchange(code, "another-verifier").Code)
+
+		code = issueCode(owner).Get("code")
+		secret := client.Secret
+		client.Secret = "oidcsec_wrong"
+		w := exchange(code, verifier)
+		client.Secret = secret
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+		require.Contains(t, w.Body.String(), "invalid_client")
+	})
+
+	t.Run("The access token only works at userinfo", func(t *testing.T) {
+		w := userinfo(tokens.AccessToken)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var info OIDCUserInfo
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&info))
+		require.Equal(t, owner.ID.String(), info.Subject)
+		require.Equal(t, "owner@acme.test", info.Email)
+		require.Equal(t, owner.Role, info.Role)
+
+		req := httptest.NewRequest(http.MethodGet, "/me", nil)
+		req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+		w = httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+
+		// Nor does an API token work at userinfo
+		require.Equal(t, http.StatusUnauthorized, userinfo(ownerToken).Code)
+	})
+
+	t.Run("Only members of the client's organization are signed in", func(t *testing.T) {
+		params := issueCode(outsider)
+		require.Equal(t, "access_denied", params.Get("error"))
+		require.Empty(t, params.Get("code"))
+	})
+
+	t.Run("Deleting the client invalidates its tokens", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodDelete, "/organizations/"+org.ID.String()+"/oidc/clients/"+client.ID.String(), nil)
+		req.Header.Set("Authorization", "Bearer "+ownerToken)
+		addCSRFToken(t, srv, req)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		require.Equal(t, http.StatusNoContent, w.Code)
+
+		require.Equal(t, http.StatusUnauthorized, userinfo(tokens.AccessToken).Code)
+	})
+}
+
+func TestOIDCProviderDisabled(t *testing.T) {
+	t.Setenv("OIDC_ISSUER", "")
+	srv, err := NewServer(NewMemoryStore(), newTestConfig(t))
+	require.NoError(t, err)
+
+	for _, path := range []string{"/.well-known/openid-configuration", "/oidc/authorize", "/oidc/userinfo"} {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		require.Equal(t, http.StatusNotFound, w.Code, path)
+	}
+}
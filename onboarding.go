@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// Onboarding steps, in the order a new organization is expected to take them
+const (
+	// OnboardingOwnerVerified: the owner has signed in, which only a
+	// Google account holding their address can do
+	OnboardingOwnerVerified = "owner_verified"
+	// OnboardingMembersInvited: someone besides the owner has been invited
+	// or added
+	OnboardingMembersInvited = "members_invited"
+	// OnboardingSSOConfigured: an OIDC client is registered, so members
+	// sign in to the organization's applications through the service
+	OnboardingSSOConfigured = "sso_configured"
+	// OnboardingBillingAdded: the organization is on a paid tier
+	OnboardingBillingAdded = "billing_added"
+)
+
+// OnboardingStep is one step of an organization's setup
+type OnboardingStep struct {
+	Step     string `json:"step"`
+	Complete bool   `json:"complete"`
+}
+
+// Onboarding is an organization's progress through its setup
+type Onboarding struct {
+	OrganizationID uuid.UUID        `json:"organization_id"`
+	Steps          []OnboardingStep `json:"steps"`
+	Completed      int              `json:"completed"`
+	Complete       bool             `json:"complete"` // every step is
+}
+
+// onboarding works out orgID's progress from what it has already set up;
+// nothing about onboarding itself is stored
+func (s *Server) onboarding(ctx context.Context, orgID uuid.UUID) (*Onboarding, error) {
+	org, err := s.store.GetOrganization(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	verified, err := s.signedIn(ctx, org.OwnerID)
+	if err != nil {
+		return nil, err
+	}
+	stats, err := s.store.GetOrganizationStats(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	clients, err := s.store.ListOIDCClients(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	onboarding := &Onboarding{OrganizationID: orgID, Steps: []OnboardingStep{
+		{Step: OnboardingOwnerVerified, Complete: verified},
+		{Step: OnboardingMembersInvited, Complete: stats.Members > 1 || stats.PendingInvitations+stats.ExpiredInvitations > 0},
+		{Step: OnboardingSSOConfigured, Complete: len(clients) > 0},
+		{Step: OnboardingBillingAdded, Complete: org.SubscriptionTier != DefaultSubscriptionTier},
+	}}
+	for _, step := range onboarding.Steps {
+		if step.Complete {
+			onboarding.Completed++
+		}
+	}
+	onboarding.Complete = onboarding.Completed == len(onboarding.Steps)
+	return onboarding, nil
+}
+
+// signedIn reports whether userID has ever signed in: a login in the audit
+// log or, on servers without one, a live session
+func (s *Server) signedIn(ctx context.Context, userID uuid.UUID) (bool, error) {
+	history, err := s.audit.LoginHistory(ctx, userID, 1, 0)
+	if err != nil {
+		return false, err
+	}
+	if len(history) > 0 {
+		return true, nil
+	}
+	sessions, err := s.store.ListUserRefreshTokens(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return len(sessions) > 0, nil
+}
+
+// handleGetOnboarding reports which setup steps the organization has
+// completed, so frontends can show its progress
+func (s *Server) handleGetOnboarding(w http.ResponseWriter, r *http.Request) {
+	onboarding, err := s.onboarding(r.Context(), pathOrgID(r))
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			http.Error(w, ErrOrganizationNotFound.Error(), http.StatusNotFound)
+		default:
+			s.logger.ErrorContext(r.Context(), "failed to get onboarding", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(onboarding)
+}
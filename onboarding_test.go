@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOnboarding(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	srv, err := NewServer(store, newTestConfig(t))
+	require.NoError(t, err)
+
+	acme, err := store.CreateOrganization(ctx, "Acme", "owner@acme.test", "Owner")
+	require.NoError(t, err)
+	owner, err := store.GetUser(ctx, acme.OwnerID)
+	require.NoError(t, err)
+	token, err := srv.tokenManager.GenerateToken(owner)
+	require.NoError(t, err)
+
+	get := func(t *testing.T) Onboarding {
+		req := httptest.NewRequest(http.MethodGet, "/organizations/"+acme.ID.String()+"/onboarding", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var onboarding Onboarding
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&onboarding))
+		return onboarding
+	}
+	steps := func(onboarding Onboarding) map[string]bool {
+		complete := map[string]bool{}
+		for _, step := range onboarding.Steps {
+			complete[step.Step] = step.Complete
+		}
+		return complete
+	}
+
+	t.Run("A new organization has completed nothing", func(t *testing.T) {
+		onboarding := get(t)
+		require.Equal(t, acme.ID, onboarding.OrganizationID)
+		require.Equal(t, []OnboardingStep{
+			{Step: OnboardingOwnerVerified},
+			{Step: OnboardingMembersInvited},
+			{Step: OnboardingSSOConfigured},
+			{Step: OnboardingBillingAdded},
+		}, onboarding.Steps)
+		require.Zero(t, onboarding.Completed)
+		require.False(t, onboarding.Complete)
+	})
+
+	t.Run("Steps complete as the organization is set up", func(t *testing.T) {
+		_, err := store.CreateRefreshToken(ctx, owner.ID, DefaultSessionTerms)
+		require.NoError(t, err)
+		require.True(t, steps(get(t))[OnboardingOwnerVerified], "the owner has signed in")
+
+		_, err = srv.invitations.Create(ctx, acme.ID, acme.OwnerID, "new@acme.test", "New")
+		require.NoError(t, err)
+		require.True(t, steps(get(t))[OnboardingMembersInvited])
+
+		client := &OIDCClient{ID: uuid.New(), OrganizationID: acme.ID, Name: "Wiki", RedirectURIs: OIDCRedirectURIs{"https://wiki.acme.test/callback"}}
+		require.NoError(t, store.CreateOIDCClient(ctx, client))
+		require.True(t, steps(get(t))[OnboardingSSOConfigured])
+
+		org, err := store.GetOrganization(ctx, acme.ID)
+		require.NoError(t, err)
+		_, err = store.UpdateOrganizationTier(ctx, org.ID, org.Version, "pro", 10, "")
+		require.NoError(t, err)
+		onboarding := get(t)
+		require.True(t, steps(onboarding)[OnboardingBillingAdded])
+		require.Equal(t, 4, onboarding.Completed)
+		require.True(t, onboarding.Complete)
+	})
+
+	t.Run("Outsiders cannot see it", func(t *testing.T) {
+		other, err := store.CreateOrganization(ctx, "Other", "owner@other.test", "Other")
+		require.NoError(t, err)
+		outsider, err := store.GetUser(ctx, other.OwnerID)
+		require.NoError(t, err)
+		outsiderToken, err := srv.tokenManager.GenerateToken(outsider)
+		require.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodGet, "/organizations/"+acme.ID.String()+"/onboarding", nil)
+		req.Header.Set("Authorization", "Bearer "+outsiderToken)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		require.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("A missing organization is not found", func(t *testing.T) {
+		orphaned, err := NewServer(orphanedStore{store}, newTestConfig(t))
+		require.NoError(t, err)
+		token, err := orphaned.tokenManager.GenerateToken(owner)
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodGet, "/organizations/"+acme.ID.String()+"/onboarding", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		orphaned.ServeHTTP(w, req)
+		require.Equal(t, http.StatusNotFound, w.Code, w.Body.String())
+	})
+}
@@ -0,0 +1,474 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// apiOperation documents a single route for the OpenAPI specification
+type apiOperation struct {
+	Method      string
+	Path        string
+	Summary     string
+	Tag         string
+	Public      bool
+	Request     interface{} // zero value of the JSON request body type, if any
+	Response    interface{} // zero value of the JSON response body type, if any
+	ContentType string      // of the response; defaults to application/json
+	Status      int         // success status; defaults to 200
+	QueryParams []string
+	Versioned   bool // updates require If-Match with the record's version
+	Errors      []int
+}
+
+// apiOperations lists every documented route. TestOpenAPIOperationsAreRouted
+// checks each entry against the router.
+var apiOperations = []apiOperation{
+	{Method: "GET", Path: "/health", Summary: "Service health; the checks are shown only to internal networks and platform admins", Tag: "system", Public: true,
+		Response: HealthResponse{}, Errors: []int{503}},
+	{Method: "GET", Path: "/livez", Summary: "Liveness probe", Tag: "system", Public: true,
+		Response: HealthResponse{}, Errors: []int{503}},
+	{Method: "GET", Path: "/readyz", Summary: "Readiness probe; unavailable until migrations are applied", Tag: "system", Public: true,
+		Response: HealthResponse{}, Errors: []int{503}},
+	{Method: "GET", Path: "/version", Summary: "Build and version details", Tag: "system", Public: true,
+		Response: BuildInfo{}},
+	{Method: "GET", Path: "/.well-known/jwks.json", Summary: "JSON Web Key Set for verifying access tokens", Tag: "auth", Public: true,
+		Response: JWKS{}},
+	{Method: "GET", Path: "/.well-known/openid-configuration", Summary: "OpenID Connect provider metadata, when OIDC_ISSUER is set", Tag: "oidc", Public: true,
+		Response: OIDCDiscovery{}, Errors: []int{404}},
+	{Method: "GET", Path: "/oidc/authorize", Summary: "Start an OpenID Connect authorization code flow through the Google login", Tag: "oidc", Public: true,
+		QueryParams: []string{"client_id", "redirect_uri", "response_type", "scope", "state", "nonce", "code_challenge", "code_challenge_method"}, Status: http.StatusTemporaryRedirect, Errors: []int{400, 404}},
+	{Method: "POST", Path: "/oidc/token", Summary: "Exchange an authorization code for an ID token; the form-encoded body carries grant_type, code, redirect_uri and code_verifier", Tag: "oidc", Public: true,
+		Response: OIDCTokenResponse{}, Errors: []int{400, 401, 404}},
+	{Method: "GET", Path: "/oidc/userinfo", Summary: "Claims about the user an OpenID Connect access token was issued for", Tag: "oidc", Public: true,
+		Response: OIDCUserInfo{}, Errors: []int{401, 404}},
+	{Method: "POST", Path: "/oidc/userinfo", Summary: "Claims about the user an OpenID Connect access token was issued for", Tag: "oidc", Public: true,
+		Response: OIDCUserInfo{}, Errors: []int{401, 404}},
+	{Method: "GET", Path: "/auth/captcha", Summary: "Whether logins from this address must solve a CAPTCHA, and its widget", Tag: "auth", Public: true,
+		Response: CaptchaChallenge{}},
+	{Method: "GET", Path: "/auth/login/google", Summary: "Start Google OAuth login; answers 403 with a CAPTCHA challenge until one is solved, when required", Tag: "auth", Public: true,
+		QueryParams: []string{"redirect_uri", "code_challenge", "code_challenge_method", "state", "captcha_token"}, Status: http.StatusTemporaryRedirect, Errors: []int{400, 403, 503}},
+	{Method: "GET", Path: "/auth/callback/google", Summary: "Complete Google OAuth login; loopback logins are redirected with a login code, and members with two-step verification are asked for a code on the MFA page", Tag: "auth", Public: true,
+		Response: TokenResponse{}, QueryParams: []string{"state", "code"}, Errors: []int{400, 403, 500}},
+	{Method: "POST", Path: "/auth/token", Summary: "Exchange a loopback login code and its PKCE verifier for tokens", Tag: "auth", Public: true,
+		Request: LoginCodeRequest{}, Response: TokenResponse{}, Errors: []int{400}},
+	{Method: "POST", Path: "/auth/validate-batch", Summary: "Validate up to 100 access tokens at once, for API gateways; internal networks and platform admins only", Tag: "auth", Public: true,
+		Request: TokenBatchRequest{}, Response: TokenBatchResponse{}, Errors: []int{400, 403}},
+	{Method: "POST", Path: "/auth/refresh", Summary: "Exchange a refresh token for new tokens", Tag: "auth", Public: true,
+		Request: RefreshTokenRequest{}, Response: TokenResponse{}, Errors: []int{400, 401, 403}},
+	{Method: "POST", Path: "/auth/logout", Summary: "Revoke a refresh token", Tag: "auth", Public: true,
+		Request: RefreshTokenRequest{}, Status: http.StatusNoContent, Errors: []int{400}},
+	{Method: "POST", Path: "/invitations/accept", Summary: "Accept an invitation with the token it was sent with, joining its organization", Tag: "auth", Public: true,
+		Request: AcceptInvitationRequest{}, Response: User{}, Errors: []int{400, 403, 404, 409, 410}},
+	{Method: "GET", Path: "/csrf/token", Summary: "Issue a CSRF token", Tag: "auth", Public: true,
+		Response: CSRFResponse{}},
+	{Method: "GET", Path: "/me", Summary: "The authenticated user", Tag: "users",
+		Response: User{}, Errors: []int{401}},
+	{Method: "DELETE", Path: "/me/sessions", Summary: "Sign out everywhere by revoking all refresh tokens", Tag: "users",
+		Status: http.StatusNoContent, Errors: []int{401, 403}},
+	{Method: "GET", Path: "/me/login-history", Summary: "The authenticated user's recent sign-ins, newest first", Tag: "users",
+		Response: []LoginRecord{}, QueryParams: []string{"limit", "offset"}, Errors: []int{400, 401}},
+	{Method: "GET", Path: "/me/access-grants", Summary: "The authenticated user's live access grants to other organizations", Tag: "users",
+		Response: []AccessGrant{}, Errors: []int{401}},
+	{Method: "POST", Path: "/me/mfa", Summary: "Start setting up two-step verification with a new TOTP secret, replacing any not yet confirmed", Tag: "users",
+		Response: MFAEnrollment{}, Errors: []int{401, 409}},
+	{Method: "POST", Path: "/me/mfa/confirm", Summary: "Turn two-step verification on with a code from the new secret", Tag: "users",
+		Request: MFACodeRequest{}, Status: http.StatusNoContent, Errors: []int{400, 401, 404, 409}},
+	{Method: "DELETE", Path: "/me/mfa", Summary: "Turn two-step verification off", Tag: "users",
+		Status: http.StatusNoContent, Errors: []int{401, 404}},
+	{Method: "GET", Path: "/graphql", Summary: "Run a GraphQL query given as query parameters", Tag: "graphql",
+		Response: GraphQLResponse{}, QueryParams: []string{"query", "operationName", "variables"}, Errors: []int{400, 401, 405}},
+	{Method: "POST", Path: "/graphql", Summary: "Run a GraphQL query or mutation", Tag: "graphql",
+		Request: GraphQLRequest{}, Response: GraphQLResponse{}, Errors: []int{400, 401}},
+	{Method: "POST", Path: "/organizations", Summary: "Create an organization and its owner", Tag: "organizations",
+		Request: CreateOrganizationRequest{}, Response: Organization{}, Errors: []int{400, 401, 403, 409}},
+	{Method: "GET", Path: "/organizations/{orgID}", Summary: "List organization members", Tag: "organizations",
+		Response: []User{}, Errors: []int{400, 401, 403}},
+	{Method: "GET", Path: "/organizations/{orgID}/details", Summary: "Get an organization; cacheable briefly and revalidated by version", Tag: "organizations",
+		Response: Organization{}, Errors: []int{400, 401, 403, 404}},
+	{Method: "PATCH", Path: "/organizations/{orgID}/details", Summary: "Rename an organization", Tag: "organizations",
+		Request: UpdateOrganizationRequest{}, Response: Organization{}, Versioned: true, Errors: []int{400, 401, 403, 404, 409, 428}},
+	{Method: "GET", Path: "/organizations/{orgID}/stats", Summary: "Organization seat and usage statistics", Tag: "organizations",
+		Response: OrganizationStats{}, Errors: []int{400, 401, 403, 404}},
+	{Method: "GET", Path: "/organizations/{orgID}/onboarding", Summary: "Which setup steps the organization has completed", Tag: "organizations",
+		Response: Onboarding{}, Errors: []int{400, 401, 403, 404}},
+	{Method: "GET", Path: "/organizations/{orgID}/billing/usage", Summary: "Paid and used seats, with the seat usage records newest first", Tag: "organizations",
+		Response: SeatUsage{}, QueryParams: []string{"limit", "offset"}, Errors: []int{400, 401, 403, 404}},
+	{Method: "POST", Path: "/organizations/{orgID}/users", Summary: "Add a sub-account to an organization", Tag: "organizations",
+		Request: AddUserRequest{}, Response: User{}, Errors: []int{400, 401, 403, 409}},
+	{Method: "GET", Path: "/organizations/{orgID}/users", Summary: "List organization members by email", Tag: "organizations",
+		Response: []User{}, QueryParams: []string{"limit", "offset"}, Errors: []int{400, 401, 403}},
+	{Method: "GET", Path: "/organizations/{orgID}/users/search", Summary: "Search organization members by name or email, best matches first", Tag: "organizations",
+		Response: []User{}, QueryParams: []string{"q", "limit", "offset"}, Errors: []int{400, 401, 403}},
+	{Method: "POST", Path: "/organizations/{orgID}/invitations", Summary: "Invite someone to join as a sub-account; without email configured the response holds the token to send them", Tag: "organizations",
+		Request: AddUserRequest{}, Response: Invitation{}, Status: http.StatusCreated, Errors: []int{400, 401, 403, 409}},
+	{Method: "GET", Path: "/organizations/{orgID}/invitations", Summary: "List pending and recently expired invitations, newest first", Tag: "organizations",
+		Response: []Invitation{}, Errors: []int{400, 401, 403}},
+	{Method: "DELETE", Path: "/organizations/{orgID}/invitations/{invitationID}", Summary: "Revoke an invitation", Tag: "organizations",
+		Status: http.StatusNoContent, Errors: []int{400, 401, 403, 404}},
+	{Method: "DELETE", Path: "/organizations/{orgID}/users/{userID}", Summary: "Remove a member", Tag: "organizations",
+		Status: http.StatusNoContent, Errors: []int{400, 401, 403, 404, 409}},
+	{Method: "PUT", Path: "/organizations/{orgID}/users/{userID}/role", Summary: "Make a member an admin or a sub-account", Tag: "organizations",
+		Request: UpdateUserRoleRequest{}, Response: User{}, Errors: []int{400, 401, 403, 404, 409}},
+	{Method: "PATCH", Path: "/organizations/{orgID}/users/{userID}/metadata", Summary: "Change a member's metadata as a JSON merge patch; null removes a key", Tag: "organizations",
+		Request: UpdateUserMetadataRequest{}, Response: User{}, Versioned: true, Errors: []int{400, 401, 403, 404, 409, 428}},
+	{Method: "DELETE", Path: "/organizations/{orgID}/users/{userID}/sessions", Summary: "Sign a member out everywhere", Tag: "organizations",
+		Status: http.StatusNoContent, Errors: []int{400, 401, 403, 404}},
+	{Method: "GET", Path: "/organizations/{orgID}/settings", Summary: "Organization settings", Tag: "organizations",
+		Response: OrganizationSettings{}, Errors: []int{400, 401, 403, 404}},
+	{Method: "PUT", Path: "/organizations/{orgID}/settings", Summary: "Replace organization settings", Tag: "organizations",
+		Request: OrganizationSettings{}, Response: OrganizationSettings{}, Versioned: true, Errors: []int{400, 401, 403, 404, 409, 428}},
+	{Method: "GET", Path: "/organizations/{orgID}/features", Summary: "Which feature flags are on for the organization", Tag: "organizations",
+		Response: OrganizationFeatures{}, Errors: []int{400, 401, 403}},
+	{Method: "GET", Path: "/organizations/{orgID}/events/stream", Summary: "Stream security events as Server-Sent Events, each carrying one event as data", Tag: "organizations",
+		Response: SecurityEvent{}, ContentType: "text/event-stream", Errors: []int{400, 401, 403}},
+	{Method: "POST", Path: "/organizations/{orgID}/webhooks", Summary: "Register a webhook; the response holds its signing secret, which is not shown again", Tag: "webhooks",
+		Request: WebhookRequest{}, Response: Webhook{}, Status: http.StatusCreated, Errors: []int{400, 401, 403}},
+	{Method: "GET", Path: "/organizations/{orgID}/webhooks", Summary: "List an organization's webhooks", Tag: "webhooks",
+		Response: []Webhook{}, Errors: []int{400, 401, 403}},
+	{Method: "GET", Path: "/organizations/{orgID}/webhooks/{webhookID}", Summary: "Get a webhook", Tag: "webhooks",
+		Response: Webhook{}, Errors: []int{400, 401, 403, 404}},
+	{Method: "PUT", Path: "/organizations/{orgID}/webhooks/{webhookID}", Summary: "Replace a webhook's URL, events and enabled state", Tag: "webhooks",
+		Request: WebhookRequest{}, Response: Webhook{}, Errors: []int{400, 401, 403, 404}},
+	{Method: "DELETE", Path: "/organizations/{orgID}/webhooks/{webhookID}", Summary: "Delete a webhook and its delivery log", Tag: "webhooks",
+		Status: http.StatusNoContent, Errors: []int{400, 401, 403, 404}},
+	{Method: "GET", Path: "/organizations/{orgID}/webhooks/{webhookID}/deliveries", Summary: "A webhook's delivery log, newest first", Tag: "webhooks",
+		Response: []WebhookDelivery{}, QueryParams: []string{"limit", "offset"}, Errors: []int{400, 401, 403, 404}},
+	{Method: "POST", Path: "/organizations/{orgID}/oidc/clients", Summary: "Register an OpenID Connect client; the response holds its secret, which is not shown again", Tag: "oidc",
+		Request: OIDCClientRequest{}, Response: OIDCClient{}, Status: http.StatusCreated, Errors: []int{400, 401, 403}},
+	{Method: "GET", Path: "/organizations/{orgID}/oidc/clients", Summary: "List an organization's OpenID Connect clients", Tag: "oidc",
+		Response: []OIDCClient{}, Errors: []int{400, 401, 403}},
+	{Method: "DELETE", Path: "/organizations/{orgID}/oidc/clients/{clientID}", Summary: "Delete an OpenID Connect client; tokens issued to it stop working", Tag: "oidc",
+		Status: http.StatusNoContent, Errors: []int{400, 401, 403, 404}},
+	{Method: "POST", Path: "/organizations/{orgID}/access-grants", Summary: "Grant a user from another organization some of an admin's permissions until the grant expires; owner only", Tag: "organizations",
+		Request: AccessGrantRequest{}, Response: AccessGrant{}, Status: http.StatusCreated, Errors: []int{400, 401, 403}},
+	{Method: "GET", Path: "/organizations/{orgID}/access-grants", Summary: "List the organization's live access grants, soonest to expire first", Tag: "organizations",
+		Response: []AccessGrant{}, Errors: []int{400, 401, 403}},
+	{Method: "DELETE", Path: "/organizations/{orgID}/access-grants/{grantID}", Summary: "Revoke an access grant", Tag: "organizations",
+		Status: http.StatusNoContent, Errors: []int{400, 401, 403, 404}},
+	{Method: "POST", Path: "/organizations/{orgID}/guest-tokens", Summary: "Mint a short-lived guest token holding some of the caller's permissions, optionally restricted to some of the organization's paths", Tag: "organizations",
+		Request: GuestTokenRequest{}, Response: GuestToken{}, Status: http.StatusCreated, Errors: []int{400, 401, 403}},
+	{Method: "DELETE", Path: "/organizations/{orgID}/guest-tokens/{tokenID}", Summary: "Revoke a guest token", Tag: "organizations",
+		Status: http.StatusNoContent, Errors: []int{400, 401, 403}},
+	{Method: "POST", Path: "/organizations/{orgID}/exports/{kind}", Summary: "Get a URL that downloads the organization (kind organization) or its audit events (kind audit) once, within minutes", Tag: "organizations",
+		Response: DownloadURL{}, Status: http.StatusCreated, Errors: []int{400, 401, 403, 404}},
+	{Method: "GET", Path: "/downloads/{kind}", Summary: "Download an export from a signed URL; needs no access token", Tag: "organizations", Public: true,
+		QueryParams: []string{"org", "expires", "nonce", "sig"}, Errors: []int{403, 404, 410}},
+	{Method: "GET", Path: "/organizations/{orgID}/directory", Summary: "The LDAP or Active Directory sync configuration and its last result", Tag: "directory",
+		Response: DirectorySync{}, Errors: []int{400, 401, 403, 404}},
+	{Method: "PUT", Path: "/organizations/{orgID}/directory", Summary: "Configure syncing members from LDAP or Active Directory; leaving out bind_password keeps the current one", Tag: "directory",
+		Request: DirectorySyncRequest{}, Response: DirectorySync{}, Errors: []int{400, 401, 403}},
+	{Method: "DELETE", Path: "/organizations/{orgID}/directory", Summary: "Stop syncing; synced members stay", Tag: "directory",
+		Status: http.StatusNoContent, Errors: []int{400, 401, 403, 404}},
+	{Method: "POST", Path: "/organizations/{orgID}/directory/sync", Summary: "Queue a directory sync now", Tag: "directory",
+		Status: http.StatusAccepted, Errors: []int{400, 401, 403, 404}},
+	{Method: "GET", Path: "/admin/organizations", Summary: "List all organizations, or those with the custom field values given as field.<key>=value", Tag: "admin",
+		Response: []Organization{}, QueryParams: []string{"limit", "offset", "include_deleted"}, Errors: []int{400, 401, 403}},
+	{Method: "POST", Path: "/admin/organizations/{orgID}/suspend", Summary: "Suspend an organization", Tag: "admin",
+		Response: Organization{}, Errors: []int{400, 401, 403, 404}},
+	{Method: "POST", Path: "/admin/organizations/{orgID}/unsuspend", Summary: "Reinstate a suspended organization", Tag: "admin",
+		Response: Organization{}, Errors: []int{400, 401, 403, 404}},
+	{Method: "PUT", Path: "/admin/organizations/{orgID}/tier", Summary: "Change an organization's subscription tier", Tag: "admin",
+		Request: UpdateTierRequest{}, Response: Organization{}, Versioned: true, Errors: []int{400, 401, 403, 404, 409, 428}},
+	{Method: "PUT", Path: "/admin/organizations/{orgID}/parent", Summary: "Place an organization under another, whose members may then act on it, or detach it with a null parent_id", Tag: "admin",
+		Request: SetParentRequest{}, Response: Organization{}, Versioned: true, Errors: []int{400, 401, 403, 404, 409, 428}},
+	{Method: "GET", Path: "/admin/organizations/{orgID}/retention", Summary: "Get an organization's retention override", Tag: "admin",
+		Response: RetentionOverride{}, Errors: []int{400, 401, 403, 404}},
+	{Method: "PUT", Path: "/admin/organizations/{orgID}/retention", Summary: "Override an organization's retention windows", Tag: "admin",
+		Request: RetentionOverride{}, Response: RetentionOverride{}, Versioned: true, Errors: []int{400, 401, 403, 404, 409, 428}},
+	{Method: "DELETE", Path: "/admin/organizations/{orgID}", Summary: "Delete an organization and its members", Tag: "admin",
+		Status: http.StatusNoContent, Errors: []int{400, 401, 403, 404}},
+	{Method: "POST", Path: "/admin/organizations/{orgID}/merge", Summary: "Merge an organization into another in the background, or preview the merge with dry_run; a plan with problems is refused with 409", Tag: "admin",
+		Request: MergeRequest{}, Response: MergeResponse{}, Status: http.StatusAccepted, Errors: []int{400, 401, 403, 404, 409}},
+	{Method: "PATCH", Path: "/admin/organizations/{orgID}/custom-fields", Summary: "Set an organization's custom field values with a JSON merge patch", Tag: "admin",
+		Request: UpdateOrganizationFieldsRequest{}, Response: Organization{}, Versioned: true, Errors: []int{400, 401, 403, 404, 409, 428}},
+	{Method: "GET", Path: "/admin/search", Summary: "Find users and organizations by ID, email, name or slug; every search is audited", Tag: "admin",
+		Response: AdminSearchResults{}, QueryParams: []string{"q"}, Errors: []int{400, 401, 403}},
+	{Method: "GET", Path: "/admin/users", Summary: "Search users across organizations", Tag: "admin",
+		Response: []User{}, QueryParams: []string{"q", "limit", "offset", "include_deleted"}, Errors: []int{400, 401, 403}},
+	{Method: "DELETE", Path: "/admin/users/{userID}", Summary: "Delete a sub-account", Tag: "admin",
+		Status: http.StatusNoContent, Errors: []int{400, 401, 403, 404, 409}},
+	{Method: "GET", Path: "/admin/feature-flags", Summary: "List feature flags", Tag: "admin",
+		Response: []FeatureFlag{}, Errors: []int{401, 403}},
+	{Method: "GET", Path: "/admin/feature-flags/{key}", Summary: "Get a feature flag", Tag: "admin",
+		Response: FeatureFlag{}, Errors: []int{401, 403, 404}},
+	{Method: "PUT", Path: "/admin/feature-flags/{key}", Summary: "Create or replace a feature flag, keeping its organizations", Tag: "admin",
+		Request: FeatureFlagRequest{}, Response: FeatureFlag{}, Errors: []int{400, 401, 403}},
+	{Method: "DELETE", Path: "/admin/feature-flags/{key}", Summary: "Delete a feature flag", Tag: "admin",
+		Status: http.StatusNoContent, Errors: []int{401, 403, 404}},
+	{Method: "PUT", Path: "/admin/feature-flags/{key}/organizations/{orgID}", Summary: "Turn a feature flag on or off for an organization, whatever its rollout", Tag: "admin",
+		Request: FeatureFlagOrganizationRequest{}, Response: FeatureFlag{}, Errors: []int{400, 401, 403, 404}},
+	{Method: "DELETE", Path: "/admin/feature-flags/{key}/organizations/{orgID}", Summary: "Return an organization to a feature flag's rollout", Tag: "admin",
+		Response: FeatureFlag{}, Errors: []int{400, 401, 403, 404}},
+	{Method: "GET", Path: "/admin/organization-fields", Summary: "List the custom fields organizations can have", Tag: "admin",
+		Response: []OrganizationField{}, Errors: []int{401, 403}},
+	{Method: "PUT", Path: "/admin/organization-fields/{key}", Summary: "Define a custom field, or change its description; its type cannot change", Tag: "admin",
+		Request: OrganizationFieldRequest{}, Response: OrganizationField{}, Errors: []int{400, 401, 403, 409}},
+	{Method: "DELETE", Path: "/admin/organization-fields/{key}", Summary: "Delete a custom field and every organization's value for it", Tag: "admin",
+		Status: http.StatusNoContent, Errors: []int{401, 403, 404}},
+	{Method: "GET", Path: "/admin/log-level", Summary: "Current log level of this instance", Tag: "admin",
+		Response: LogLevelRequest{}, Errors: []int{401, 403}},
+	{Method: "PUT", Path: "/admin/log-level", Summary: "Change the log level of this instance until it restarts", Tag: "admin",
+		Request: LogLevelRequest{}, Response: LogLevelRequest{}, Errors: []int{400, 401, 403}},
+	{Method: "GET", Path: "/admin/stats", Summary: "Platform statistics", Tag: "admin",
+		Response: PlatformStats{}, Errors: []int{401, 403}},
+	{Method: "GET", Path: "/admin/audit/verify", Summary: "Verify the audit log hash chain", Tag: "admin",
+		Response: AuditVerification{}, Errors: []int{401, 403}},
+	{Method: "GET", Path: "/admin/jobs", Summary: "List background jobs", Tag: "admin",
+		Response: []Job{}, QueryParams: []string{"status", "limit", "offset"}, Errors: []int{400, 401, 403}},
+	{Method: "GET", Path: "/admin/jobs/{jobID}", Summary: "Background job status", Tag: "admin",
+		Response: Job{}, Errors: []int{400, 401, 403, 404}},
+	{Method: "POST", Path: "/admin/jobs/{jobID}/retry", Summary: "Requeue a dead job", Tag: "admin",
+		Response: Job{}, Errors: []int{400, 401, 403, 404, 409}},
+	{Method: "GET", Path: "/openapi.json", Summary: "This OpenAPI document", Tag: "system", Public: true},
+	{Method: "GET", Path: "/docs", Summary: "Interactive API documentation", Tag: "system", Public: true},
+	{Method: "GET", Path: "/pages/login", Summary: "Hosted login page offering the identity providers, in the branding of the organization org names", Tag: "pages", Public: true,
+		ContentType: "text/html", QueryParams: []string{"org", "redirect_uri", "code_challenge", "code_challenge_method", "state"}},
+	{Method: "GET", Path: "/pages/invitation", Summary: "Hosted page showing an invitation, with a form to accept it", Tag: "pages", Public: true,
+		ContentType: "text/html", QueryParams: []string{"token"}, Errors: []int{403, 404, 410}},
+	{Method: "POST", Path: "/pages/invitation", Summary: "Accept an invitation from its hosted page; a form with token and csrf_token", Tag: "pages", Public: true,
+		ContentType: "text/html", Errors: []int{403, 404, 409, 410}},
+	{Method: "POST", Path: "/pages/mfa", Summary: "Answer the MFA page with a code to finish signing in; a form with ticket, code and csrf_token", Tag: "pages", Public: true,
+		ContentType: "text/html", Errors: []int{400, 401}},
+	{Method: "POST", Path: "/pages/oidc/consent", Summary: "Answer the OIDC consent page; a form with ticket, decision (allow or deny) and csrf_token", Tag: "pages", Public: true,
+		ContentType: "text/html", Status: http.StatusFound, Errors: []int{400, 404}},
+}
+
+var pathParamPattern = regexp.MustCompile(`\{(\w+)\}`)
+
+// schemaGenerator converts Go types into OpenAPI schemas, collecting named
+// structs as reusable components
+type schemaGenerator struct {
+	components map[string]interface{}
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	uuidType       = reflect.TypeOf(uuid.UUID{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+	durationType   = reflect.TypeOf(Duration(0))
+)
+
+func (g *schemaGenerator) schemaFor(t reflect.Type) map[string]interface{} {
+	switch t {
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case uuidType:
+		return map[string]interface{}{"type": "string", "format": "uuid"}
+	case rawMessageType:
+		// Any JSON value
+		return map[string]interface{}{}
+	case durationType:
+		return map[string]interface{}{"type": "string", "example": "720h"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		schema := g.schemaFor(t.Elem())
+		if _, isRef := schema["$ref"]; isRef {
+			return schema
+		}
+		schema["nullable"] = true
+		return schema
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case reflect.Int64:
+		if t == reflect.TypeOf(time.Duration(0)) {
+			return map[string]interface{}{"type": "integer", "format": "int64", "description": "nanoseconds"}
+		}
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": g.schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schemaFor(t.Elem())}
+	case reflect.Struct:
+		name := t.Name()
+		if _, ok := g.components[name]; !ok {
+			// Reserve the name first so recursive types terminate
+			g.components[name] = nil
+			g.components[name] = g.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+func (g *schemaGenerator) structSchema(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		// Embedded structs without a JSON name contribute their fields
+		tag := field.Tag.Get("json")
+		name := strings.Split(tag, ",")[0]
+		if field.Anonymous && name == "" {
+			if embedded, ok := g.structSchema(field.Type)["properties"].(map[string]interface{}); ok {
+				for k, v := range embedded {
+					properties[k] = v
+				}
+			}
+			continue
+		}
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = g.schemaFor(field.Type)
+	}
+	return map[string]interface{}{"type": "object", "properties": properties}
+}
+
+// buildOpenAPISpec generates the OpenAPI 3 document from apiOperations
+func buildOpenAPISpec(version string) map[string]interface{} {
+	g := &schemaGenerator{components: make(map[string]interface{})}
+	paths := make(map[string]interface{})
+
+	for _, op := range apiOperations {
+		operation := map[string]interface{}{
+			"summary":     op.Summary,
+			"tags":        []string{op.Tag},
+			"operationId": strings.ToLower(op.Method) + pathParamPattern.ReplaceAllString(strings.NewReplacer("/", "_", ".", "_", "-", "_").Replace(op.Path), "by_$1"),
+		}
+
+		var params []interface{}
+		for _, match := range pathParamPattern.FindAllStringSubmatch(op.Path, -1) {
+			params = append(params, map[string]interface{}{
+				"name": match[1], "in": "path", "required": true,
+				"schema": map[string]interface{}{"type": "string", "format": "uuid"},
+			})
+		}
+		for _, q := range op.QueryParams {
+			params = append(params, map[string]interface{}{
+				"name": q, "in": "query", "schema": map[string]interface{}{"type": "string"},
+			})
+		}
+		if op.Versioned {
+			params = append(params, map[string]interface{}{
+				"name": "If-Match", "in": "header", "required": true,
+				"description": "ETag of the version being updated",
+				"schema":      map[string]interface{}{"type": "string"},
+			})
+		}
+		if len(params) > 0 {
+			operation["parameters"] = params
+		}
+
+		if op.Request != nil {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": g.schemaFor(reflect.TypeOf(op.Request))},
+				},
+			}
+		}
+
+		status := op.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		success := map[string]interface{}{"description": http.StatusText(status)}
+		if op.Response != nil {
+			contentType := op.ContentType
+			if contentType == "" {
+				contentType = "application/json"
+			}
+			success["content"] = map[string]interface{}{
+				contentType: map[string]interface{}{"schema": g.schemaFor(reflect.TypeOf(op.Response))},
+			}
+		}
+		responses := map[string]interface{}{strconv.Itoa(status): success}
+		for _, code := range op.Errors {
+			responses[strconv.Itoa(code)] = map[string]interface{}{
+				"description": http.StatusText(code),
+				"content": map[string]interface{}{
+					"text/plain": map[string]interface{}{"schema": map[string]interface{}{"type": "string"}},
+				},
+			}
+		}
+		operation["responses"] = responses
+
+		if !op.Public {
+			operation["security"] = []interface{}{map[string]interface{}{"bearerAuth": []string{}}}
+		}
+
+		item, _ := paths[op.Path].(map[string]interface{})
+		if item == nil {
+			item = make(map[string]interface{})
+			paths[op.Path] = item
+		}
+		item[strings.ToLower(op.Method)] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "Huachuca",
+			"version": version,
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": g.components,
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]interface{}{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+			},
+		},
+	}
+}
+
+var (
+	openAPIOnce sync.Once
+	openAPIJSON []byte
+)
+
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	openAPIOnce.Do(func() {
+		openAPIJSON, _ = json.Marshal(buildOpenAPISpec(currentBuild.Version))
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPIJSON)
+}
+
+const swaggerUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Huachuca API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>
+    window.onload = function () {
+      SwaggerUIBundle({ url: "/openapi.json", dom_id: "#swagger-ui" });
+    };
+  </script>
+</body>
+</html>
+`
+
+func (s *Server) handleDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(swaggerUIPage))
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenAPIOperationsAreRouted(t *testing.T) {
+	srv, err := NewServer(nil, newTestConfig(t))
+	require.NoError(t, err)
+
+	for _, op := range apiOperations {
+		path := pathParamPattern.ReplaceAllString(op.Path, uuid.NewString())
+		req := httptest.NewRequest(op.Method, path, nil)
+
+		_, pattern := srv.mux.Handler(req)
+		require.Equal(t, op.Method+" "+op.Path, pattern, "documented operation %s %s is not routed", op.Method, op.Path)
+	}
+}
+
+func TestOpenAPISpec(t *testing.T) {
+	srv, err := NewServer(nil, newTestConfig(t))
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/openapi.json", nil)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	var spec struct {
+		OpenAPI string                                       `json:"openapi"`
+		Paths   map[string]map[string]map[string]interface{} `json:"paths"`
+		Comps   struct {
+			Schemas map[string]struct {
+				Properties map[string]map[string]interface{} `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&spec))
+	require.True(t, strings.HasPrefix(spec.OpenAPI, "3."))
+
+	t.Run("Operations are present", func(t *testing.T) {
+		createOrg := spec.Paths["/organizations"]["post"]
+		require.NotNil(t, createOrg)
+		require.NotNil(t, createOrg["requestBody"])
+		require.NotNil(t, createOrg["security"])
+
+		health := spec.Paths["/health"]["get"]
+		require.NotNil(t, health)
+		require.Nil(t, health["security"], "public endpoints must not require auth")
+
+		addUser := spec.Paths["/organizations/{orgID}/users"]["post"]
+		require.NotNil(t, addUser["parameters"])
+	})
+
+	t.Run("Schemas follow JSON tags", func(t *testing.T) {
+		org := spec.Comps.Schemas["Organization"]
+		require.Equal(t, "uuid", org.Properties["id"]["format"])
+		require.Equal(t, "date-time", org.Properties["created_at"]["format"])
+		require.Equal(t, true, org.Properties["suspended_at"]["nullable"])
+		require.Equal(t, "integer", org.Properties["max_sub_accounts"]["type"])
+
+		user := spec.Comps.Schemas["User"]
+		require.Equal(t, "object", user.Properties["permissions"]["type"])
+
+		_, hasHash := spec.Comps.Schemas["RefreshToken"]
+		require.False(t, hasHash, "only referenced types become components")
+	})
+}
+
+func TestDocsPage(t *testing.T) {
+	srv, err := NewServer(nil, newTestConfig(t))
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/docs", nil)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Header().Get("Content-Type"), "text/html")
+	require.Contains(t, w.Body.String(), "/openapi.json")
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+const organizationFieldColumns = `key, type, description, created_at, updated_at`
+
+func (db *DB) ListOrganizationFields(ctx context.Context) ([]OrganizationField, error) {
+	fields := []OrganizationField{}
+	err := db.read(ctx, func(q sqlx.QueryerContext) error {
+		return sqlx.SelectContext(ctx, q, &fields, `
+			SELECT `+organizationFieldColumns+` FROM organization_fields ORDER BY key
+		`)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+func (db *DB) PutOrganizationField(ctx context.Context, field *OrganizationField) error {
+	return db.transact(ctx, func(tx *sqlx.Tx) error {
+		// A field keeps its type, so the organizations' values stay valid
+		err := tx.GetContext(ctx, field, `
+			INSERT INTO organization_fields (key, type, description)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (key) DO UPDATE SET
+				description = EXCLUDED.description, updated_at = NOW()
+			WHERE organization_fields.type = EXCLUDED.type
+			RETURNING `+organizationFieldColumns,
+			field.Key, field.Type, field.Description)
+		if err == sql.ErrNoRows {
+			return ErrOrganizationFieldType
+		}
+		return err
+	})
+}
+
+func (db *DB) DeleteOrganizationField(ctx context.Context, key string) error {
+	return db.transact(ctx, func(tx *sqlx.Tx) error {
+		result, err := tx.ExecContext(ctx, `DELETE FROM organization_fields WHERE key = $1`, key)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrOrganizationFieldNotFound
+		}
+		_, err = tx.ExecContext(ctx, `
+			UPDATE organizations SET custom_fields = custom_fields - $1::text, version = version + 1, updated_at = NOW()
+			WHERE custom_fields ? $1
+		`, key)
+		return err
+	})
+}
+
+// UpdateOrganizationFields replaces an organization's custom field values
+// at expectedVersion
+func (db *DB) UpdateOrganizationFields(ctx context.Context, orgID uuid.UUID, expectedVersion int, fields OrganizationFields) (*Organization, error) {
+	org := &Organization{}
+	err := db.tenantTx(ctx, orgID, func(tx *sqlx.Tx) error {
+		if err := lockOrganizationVersion(ctx, tx, orgID, expectedVersion); err != nil {
+			return err
+		}
+		return tx.GetContext(ctx, org, `
+			UPDATE organizations SET custom_fields = $2, version = version + 1, updated_at = NOW()
+			WHERE id = $1
+			RETURNING id, name, owner_id, subscription_tier, max_sub_accounts, seat_overage, slug, parent_id, suspended_at, custom_fields, created_at, deleted_at, version, updated_at
+		`, orgID, fields)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return org, nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// parseOrganizationFieldFilter reads the field.<key> query parameters,
+// converting each value to its field's type
+func parseOrganizationFieldFilter(r *http.Request, fields []OrganizationField) (OrganizationFields, error) {
+	types := make(map[string]string, len(fields))
+	for _, field := range fields {
+		types[field.Key] = field.Type
+	}
+
+	filter := OrganizationFields{}
+	var errs []error
+	for param, values := range r.URL.Query() {
+		key, ok := strings.CutPrefix(param, organizationFieldFilterPrefix)
+		if !ok {
+			continue
+		}
+		value := values[0]
+		var err error
+		switch types[key] {
+		case "string":
+			filter[key] = value
+		case "number":
+			filter[key], err = strconv.ParseFloat(value, 64)
+		case "boolean":
+			filter[key], err = strconv.ParseBool(value)
+		default:
+			errs = append(errs, &ValidationError{Field: param, Message: ErrOrganizationFieldNotFound.Error()})
+			continue
+		}
+		if err != nil {
+			errs = append(errs, &ValidationError{Field: param, Message: "must be a " + types[key]})
+		}
+	}
+	return filter, joinValidationErrors(errs...)
+}
+
+func (s *Server) handleAdminListOrganizationFields(w http.ResponseWriter, r *http.Request) {
+	fields, err := s.store.ListOrganizationFields(r.Context())
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to list organization fields", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(fields)
+}
+
+// handleAdminPutOrganizationField defines a field, or changes the
+// description of one. Its type is fixed once defined.
+func (s *Server) handleAdminPutOrganizationField(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+
+	var req OrganizationFieldRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if err := joinValidationErrors(ValidateOrganizationFieldKey(key), ValidateOrganizationFieldRequest(&req)); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+
+	field := &OrganizationField{Key: key, Type: req.Type, Description: req.Description}
+	if err := s.store.PutOrganizationField(r.Context(), field); err != nil {
+		switch err {
+		case ErrOrganizationFieldType:
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			s.logger.ErrorContext(r.Context(), "failed to save organization field", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	s.recordAudit(r, "organization_field.updated", uuid.Nil, key, AuditMetadata{"type": field.Type})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(field)
+}
+
+// handleAdminDeleteOrganizationField deletes a field and every
+// organization's value for it
+func (s *Server) handleAdminDeleteOrganizationField(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+
+	if err := s.store.DeleteOrganizationField(r.Context(), key); err != nil {
+		switch err {
+		case ErrOrganizationFieldNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			s.logger.ErrorContext(r.Context(), "failed to delete organization field", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	s.recordAudit(r, "organization_field.deleted", uuid.Nil, key, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAdminUpdateOrganizationFields applies a merge patch to an
+// organization's custom field values
+func (s *Server) handleAdminUpdateOrganizationFields(w http.ResponseWriter, r *http.Request) {
+	orgID := pathOrgID(r)
+
+	expectedVersion, ok := ifMatchVersion(w, r)
+	if !ok {
+		return
+	}
+
+	var req UpdateOrganizationFieldsRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	org, err := s.store.GetOrganization(r.Context(), orgID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, ErrOrganizationNotFound.Error(), http.StatusNotFound)
+			return
+		}
+		s.logger.ErrorContext(r.Context(), "failed to get organization", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if org.Version != expectedVersion {
+		http.Error(w, ErrVersionConflict.Error(), http.StatusConflict)
+		return
+	}
+
+	fields, err := s.store.ListOrganizationFields(r.Context())
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to list organization fields", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	values := mergePatch(org.CustomFields, req.CustomFields)
+	if err := validateOrganizationFields(values, fields); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+
+	org, err = s.store.UpdateOrganizationFields(r.Context(), orgID, expectedVersion, values)
+	if err != nil {
+		switch err {
+		case ErrOrganizationNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case ErrVersionConflict:
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			s.logger.ErrorContext(r.Context(), "failed to update organization fields", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	s.recordAudit(r, "organization.custom_fields_changed", orgID, orgID.String(), AuditMetadata{
+		"keys": strings.Join(slices.Sorted(maps.Keys(req.CustomFields)), ", "),
+	})
+
+	w.Header().Set("ETag", versionETag(org.Version))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(org)
+}
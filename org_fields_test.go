@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateOrganizationFields(t *testing.T) {
+	fields := []OrganizationField{
+		{Key: "crm_id", Type: "string"},
+		{Key: "seats_paid", Type: "number"},
+		{Key: "enterprise", Type: "boolean"},
+	}
+	require.NoError(t, validateOrganizationFields(OrganizationFields{"crm_id": "ACME-1", "seats_paid": 40.0, "enterprise": true}, fields))
+
+	var errs ValidationErrors
+	err := validateOrganizationFields(OrganizationFields{"crm_id": 1.0, "enterprise": "yes", "plan": "gold"}, fields)
+	require.ErrorAs(t, err, &errs)
+	require.Len(t, errs, 3)
+	require.ErrorContains(t, validateOrganizationFields(OrganizationFields{"crm_id": strings.Repeat("x", 257)}, fields), "bytes")
+
+	require.NoError(t, ValidateOrganizationFieldRequest(&OrganizationFieldRequest{Type: "number"}))
+	require.Error(t, ValidateOrganizationFieldRequest(&OrganizationFieldRequest{Type: "date"}))
+	require.Error(t, ValidateOrganizationFieldKey("has space"))
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/organizations?field.crm_id=ACME-1&field.seats_paid=40&field.enterprise=true&limit=5", nil)
+	filter, err := parseOrganizationFieldFilter(req, fields)
+	require.NoError(t, err)
+	require.Equal(t, OrganizationFields{"crm_id": "ACME-1", "seats_paid": 40.0, "enterprise": true}, filter)
+
+	req = httptest.NewRequest(http.MethodGet, "/admin/organizations?field.seats_paid=many&field.plan=gold", nil)
+	_, err = parseOrganizationFieldFilter(req, fields)
+	require.ErrorAs(t, err, &errs)
+	require.Len(t, errs, 2)
+}
+
+func TestOrganizationFieldAPI(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	srv, err := NewServer(store, newTestConfig(t))
+	require.NoError(t, err)
+
+	acme, err := store.CreateOrganization(ctx, "Acme", "owner@acme.test", "Owner")
+	require.NoError(t, err)
+	initech, err := store.CreateOrganization(ctx, "Initech", "owner@initech.test", "Owner")
+	require.NoError(t, err)
+	owner, err := store.GetUser(ctx, acme.OwnerID)
+	require.NoError(t, err)
+	ownerToken, err := srv.tokenManager.GenerateToken(owner)
+	require.NoError(t, err)
+
+	operators, err := store.CreateOrganization(ctx, "Operators", "ops@example.com", "Ops")
+	require.NoError(t, err)
+	store.users[operators.OwnerID].Permissions[string(PermPlatformAdmin)] = true
+	admin, err := store.GetUser(ctx, operators.OwnerID)
+	require.NoError(t, err)
+	adminToken, err := srv.tokenManager.GenerateToken(admin)
+	require.NoError(t, err)
+
+	do := func(method, path, token string, version int, body any) *httptest.ResponseRecorder {
+		var data []byte
+		if body != nil {
+			data, err = json.Marshal(body)
+			require.NoError(t, err)
+		}
+		req := httptest.NewRequest(method, path, bytes.NewReader(data))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		if version > 0 {
+			req.Header.Set("If-Match", versionETag(version))
+		}
+		if method != http.MethodGet {
+			addCSRFToken(t, srv, req)
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return w
+	}
+	setFields := func(org *Organization, fields OrganizationFields) *httptest.ResponseRecorder {
+		w := do(http.MethodPatch, "/admin/organizations/"+org.ID.String()+"/custom-fields", adminToken, org.Version,
+			UpdateOrganizationFieldsRequest{CustomFields: fields})
+		if w.Code == http.StatusOK {
+			*org = Organization{}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), org))
+		}
+		return w
+	}
+	listed := func(query string) []uuid.UUID {
+		w := do(http.MethodGet, "/admin/organizations?"+query, adminToken, 0, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var orgs []Organization
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&orgs))
+		ids := []uuid.UUID{}
+		for _, org := range orgs {
+			ids = append(ids, org.ID)
+		}
+		return ids
+	}
+
+	t.Run("Only platform admins define fields", func(t *testing.T) {
+		w := do(http.MethodPut, "/admin/organization-fields/crm_id", ownerToken, 0, OrganizationFieldRequest{Type: "string"})
+		require.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("Fields are defined with a type", func(t *testing.T) {
+		w := do(http.MethodPut, "/admin/organization-fields/crm_id", adminToken, 0, OrganizationFieldRequest{Type: "string", Description: "Account in the CRM"})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		w = do(http.MethodPut, "/admin/organization-fields/enterprise", adminToken, 0, OrganizationFieldRequest{Type: "boolean"})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		w = do(http.MethodPut, "/admin/organization-fields/crm_id", adminToken, 0, OrganizationFieldRequest{Type: "number"})
+		require.Equal(t, http.StatusConflict, w.Code)
+		w = do(http.MethodPut, "/admin/organization-fields/crm_id", adminToken, 0, OrganizationFieldRequest{Type: "string", Description: "CRM account"})
+		require.Equal(t, http.StatusOK, w.Code)
+
+		w = do(http.MethodGet, "/admin/organization-fields", adminToken, 0, nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		var fields []OrganizationField
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&fields))
+		require.Len(t, fields, 2)
+		require.Equal(t, "CRM account", fields[0].Description)
+	})
+
+	t.Run("Organizations get values", func(t *testing.T) {
+		require.Equal(t, http.StatusPreconditionRequired, do(http.MethodPatch, "/admin/organizations/"+acme.ID.String()+"/custom-fields", adminToken, 0,
+			UpdateOrganizationFieldsRequest{CustomFields: OrganizationFields{"crm_id": "ACME-1"}}).Code)
+		require.Equal(t, http.StatusBadRequest, setFields(acme, OrganizationFields{"crm_id": 1}).Code)
+		require.Equal(t, http.StatusBadRequest, setFields(acme, OrganizationFields{"plan": "gold"}).Code)
+
+		w := setFields(acme, OrganizationFields{"crm_id": "ACME-1", "enterprise": true})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Equal(t, OrganizationFields{"crm_id": "ACME-1", "enterprise": true}, acme.CustomFields)
+		require.Equal(t, versionETag(acme.Version), w.Header().Get("ETag"))
+
+		stale := *initech
+		require.Equal(t, http.StatusOK, setFields(initech, OrganizationFields{"crm_id": "INIT-7", "enterprise": false}).Code)
+		require.Equal(t, http.StatusConflict, setFields(&stale, OrganizationFields{"crm_id": nil}).Code)
+	})
+
+	t.Run("The listing filters by value", func(t *testing.T) {
+		require.Len(t, listed(""), 3)
+		require.Equal(t, []uuid.UUID{acme.ID}, listed("field.enterprise=true"))
+		require.Equal(t, []uuid.UUID{initech.ID}, listed("field.crm_id=INIT-7&field.enterprise=false"))
+		require.Empty(t, listed("field.crm_id=ACME-1&field.enterprise=false"))
+
+		w := do(http.MethodGet, "/admin/organizations?field.plan=gold", adminToken, 0, nil)
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		w = do(http.MethodGet, "/admin/organizations?field.enterprise=maybe", adminToken, 0, nil)
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("Deleting a field clears its values", func(t *testing.T) {
+		w := do(http.MethodDelete, "/admin/organization-fields/enterprise", adminToken, 0, nil)
+		require.Equal(t, http.StatusNoContent, w.Code)
+		w = do(http.MethodDelete, "/admin/organization-fields/enterprise", adminToken, 0, nil)
+		require.Equal(t, http.StatusNotFound, w.Code)
+
+		got, err := store.GetOrganization(ctx, acme.ID)
+		require.NoError(t, err)
+		require.Equal(t, OrganizationFields{"crm_id": "ACME-1"}, got.CustomFields)
+		require.Equal(t, acme.Version+1, got.Version)
+	})
+}
+
+func TestOrganizationFieldStore(t *testing.T) {
+	testdb := setupTestDB(t)
+	defer testdb.teardown(t)
+
+	ctx := context.Background()
+	acme, err := testdb.DB.CreateOrganization(ctx, "Fields Acme", "owner@fields-acme.test", "Owner")
+	require.NoError(t, err)
+	initech, err := testdb.DB.CreateOrganization(ctx, "Fields Initech", "owner@fields-initech.test", "Owner")
+	require.NoError(t, err)
+
+	require.NoError(t, testdb.DB.PutOrganizationField(ctx, &OrganizationField{Key: "crm_id", Type: "string"}))
+	field := &OrganizationField{Key: "seats_paid", Type: "number", Description: "Seats on the contract"}
+	require.NoError(t, testdb.DB.PutOrganizationField(ctx, field))
+	require.False(t, field.CreatedAt.IsZero())
+	require.ErrorIs(t, testdb.DB.PutOrganizationField(ctx, &OrganizationField{Key: "seats_paid", Type: "string"}), ErrOrganizationFieldType)
+
+	fields, err := testdb.DB.ListOrganizationFields(ctx)
+	require.NoError(t, err)
+	require.Len(t, fields, 2)
+	require.Equal(t, "Seats on the contract", fields[1].Description)
+
+	updated, err := testdb.DB.UpdateOrganizationFields(ctx, acme.ID, acme.Version, OrganizationFields{"crm_id": "ACME-1", "seats_paid": 40.0})
+	require.NoError(t, err)
+	require.Equal(t, acme.Version+1, updated.Version)
+	require.Equal(t, OrganizationFields{"crm_id": "ACME-1", "seats_paid": 40.0}, updated.CustomFields)
+	_, err = testdb.DB.UpdateOrganizationFields(ctx, acme.ID, acme.Version, OrganizationFields{})
+	require.ErrorIs(t, err, ErrVersionConflict)
+	_, err = testdb.DB.UpdateOrganizationFields(ctx, initech.ID, initech.Version, OrganizationFields{"crm_id": "INIT-7"})
+	require.NoError(t, err)
+
+	orgs, total, err := testdb.DB.ListOrganizations(ctx, OrganizationFilter{CustomFields: OrganizationFields{"seats_paid": 40.0}}, 10, 0)
+	require.NoError(t, err)
+	require.Equal(t, 1, total)
+	require.Equal(t, acme.ID, orgs[0].ID)
+
+	require.NoError(t, testdb.DB.DeleteOrganizationField(ctx, "seats_paid"))
+	require.ErrorIs(t, testdb.DB.DeleteOrganizationField(ctx, "seats_paid"), ErrOrganizationFieldNotFound)
+	got, err := testdb.DB.GetOrganization(ctx, acme.ID)
+	require.NoError(t, err)
+	require.Equal(t, OrganizationFields{"crm_id": "ACME-1"}, got.CustomFields)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrParentNotFound    = errors.New("parent organization not found")
+	ErrOrganizationCycle = errors.New("an organization cannot be placed under itself or one of its sub-organizations")
+)
+
+// OrgHierarchy holds which organization each sub-organization sits under,
+// reloading it from the database once it is older than ttl. Members of an
+// organization may act on its sub-organizations, at any depth, with the
+// permissions their role grants them.
+type OrgHierarchy struct {
+	mu       sync.Mutex
+	parents  map[uuid.UUID]uuid.UUID
+	loadedAt time.Time
+	ttl      time.Duration
+	load     func(ctx context.Context) (map[uuid.UUID]uuid.UUID, error)
+	logger   *slog.Logger
+	now      func() time.Time
+}
+
+func NewOrgHierarchy(load func(ctx context.Context) (map[uuid.UUID]uuid.UUID, error), ttl time.Duration, logger *slog.Logger) *OrgHierarchy {
+	return &OrgHierarchy{
+		ttl:    ttl,
+		load:   load,
+		logger: logger,
+		now:    time.Now,
+	}
+}
+
+// Contains reports whether orgID is ancestor or one of its sub-organizations.
+// A nil *OrgHierarchy knows of no sub-organizations. If a reload fails the
+// previous hierarchy is kept until the next attempt; it only returns an
+// error when there is none to keep.
+func (h *OrgHierarchy) Contains(ctx context.Context, ancestor, orgID uuid.UUID) (bool, error) {
+	if ancestor == orgID {
+		return true, nil
+	}
+	if h == nil {
+		return false, nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if now := h.now(); h.parents == nil || now.Sub(h.loadedAt) >= h.ttl {
+		// Whatever the outcome, wait a full ttl before querying again
+		h.loadedAt = now
+		parents, err := h.load(ctx)
+		if err != nil {
+			h.logger.ErrorContext(ctx, "failed to load organization hierarchy", "error", err)
+			if h.parents == nil {
+				return false, err
+			}
+		} else {
+			h.parents = parents
+		}
+	}
+
+	// The store refuses cycles, but a hierarchy loaded mid-change is only
+	// walked as far as it has organizations
+	for range len(h.parents) {
+		parent, ok := h.parents[orgID]
+		if !ok {
+			return false, nil
+		}
+		if parent == ancestor {
+			return true, nil
+		}
+		orgID = parent
+	}
+	return false, nil
+}
+
+// Invalidate forces the next lookup to reload from the database
+func (h *OrgHierarchy) Invalidate() {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	h.loadedAt = time.Time{}
+	h.mu.Unlock()
+}
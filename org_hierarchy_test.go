@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrgHierarchy(t *testing.T) {
+	root, child, grandchild, other := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	loads := 0
+	parents := map[uuid.UUID]uuid.UUID{child: root, grandchild: child}
+	var loadErr error
+	hierarchy := NewOrgHierarchy(func(ctx context.Context) (map[uuid.UUID]uuid.UUID, error) {
+		loads++
+		return parents, loadErr
+	}, time.Minute, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	now := time.Now()
+	hierarchy.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	contains := func(ancestor, orgID uuid.UUID) bool {
+		ok, err := hierarchy.Contains(ctx, ancestor, orgID)
+		require.NoError(t, err)
+		return ok
+	}
+	require.True(t, contains(root, root))
+	require.True(t, contains(root, child))
+	require.True(t, contains(root, grandchild), "sub-organizations at any depth")
+	require.False(t, contains(child, root), "members of a sub-organization stay out of its parent")
+	require.False(t, contains(other, child))
+	require.Equal(t, 1, loads)
+
+	// Invalidate reloads on the next check
+	parents = map[uuid.UUID]uuid.UUID{}
+	hierarchy.Invalidate()
+	require.False(t, contains(root, child))
+	require.Equal(t, 2, loads)
+
+	// A failed reload keeps the previous hierarchy
+	loadErr = errors.New("database unavailable")
+	now = now.Add(time.Minute)
+	require.True(t, contains(root, root))
+	require.False(t, contains(root, child))
+
+	var none *OrgHierarchy
+	require.True(t, func() bool { ok, _ := none.Contains(ctx, root, root); return ok }())
+	require.False(t, func() bool { ok, _ := none.Contains(ctx, root, child); return ok }())
+}
+
+func TestSubOrganizationAccess(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	srv, err := NewServer(store, newTestConfig(t))
+	require.NoError(t, err)
+
+	parent, err := store.CreateOrganization(ctx, "Holdings", "owner@holdings.test", "Owner")
+	require.NoError(t, err)
+	child, err := store.CreateOrganization(ctx, "Subsidiary", "owner@subsidiary.test", "Owner")
+	require.NoError(t, err)
+
+	tokenFor := func(id uuid.UUID) string {
+		user, err := store.GetUser(ctx, id)
+		require.NoError(t, err)
+		token, err := srv.tokenManager.GenerateToken(user)
+		require.NoError(t, err)
+		return token
+	}
+	parentToken, childToken := tokenFor(parent.OwnerID), tokenFor(child.OwnerID)
+	store.users[parent.OwnerID].Permissions[string(PermPlatformAdmin)] = true
+	adminToken := tokenFor(parent.OwnerID)
+
+	do := func(method, path, token, body string, version int) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("If-Match", versionETag(version))
+			addCSRFToken(t, srv, req)
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return w
+	}
+	setParent := func(orgID uuid.UUID, parentID string) *httptest.ResponseRecorder {
+		org, err := store.GetOrganization(ctx, orgID)
+		require.NoError(t, err)
+		return do(http.MethodPut, "/admin/organizations/"+orgID.String()+"/parent", adminToken, fmt.Sprintf(`{"parent_id":%s}`, parentID), org.Version)
+	}
+	childUsers := "/organizations/" + child.ID.String() + "/users"
+	parentUsers := "/organizations/" + parent.ID.String() + "/users"
+
+	require.Equal(t, http.StatusForbidden, do(http.MethodGet, childUsers, parentToken, "", 0).Code)
+
+	w := setParent(child.ID, `"`+parent.ID.String()+`"`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.Contains(t, w.Body.String(), `"parent_id":"`+parent.ID.String()+`"`)
+
+	require.Equal(t, http.StatusOK, do(http.MethodGet, childUsers, parentToken, "", 0).Code, "members of the parent reach the sub-organization")
+	require.Equal(t, http.StatusForbidden, do(http.MethodGet, parentUsers, childToken, "", 0).Code, "but not the other way round")
+
+	require.Equal(t, http.StatusBadRequest, setParent(parent.ID, `"`+child.ID.String()+`"`).Code, "cycles are refused")
+	require.Equal(t, http.StatusBadRequest, setParent(parent.ID, `"`+parent.ID.String()+`"`).Code)
+	require.Equal(t, http.StatusBadRequest, setParent(parent.ID, `"`+uuid.NewString()+`"`).Code)
+
+	require.Equal(t, http.StatusOK, setParent(child.ID, "null").Code)
+	require.Equal(t, http.StatusForbidden, do(http.MethodGet, childUsers, parentToken, "", 0).Code)
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const mergeOrganizationsJob = "organization.merge"
+
+var ErrMergeTargetNotFound = errors.New("organization to merge into not found")
+
+// What a merge does with each member of the organization merged away
+const (
+	MergeMove      = "move"      // becomes a member of the target
+	MergeDuplicate = "duplicate" // already a member of the target by email; deleted with the source
+)
+
+// MergeRequest merges the {orgID} organization into another
+type MergeRequest struct {
+	Into uuid.UUID `json:"into"`
+	// OwnerRole is the role the merged organization's owner takes in the
+	// target: admin unless set
+	OwnerRole string `json:"owner_role,omitempty"`
+	// DryRun returns the plan without merging
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+// MergePlan is what merging one organization into another would do
+type MergePlan struct {
+	SourceID uuid.UUID   `json:"source_id"`
+	TargetID uuid.UUID   `json:"target_id"`
+	Users    []MergeUser `json:"users"`
+	// Settings are the target's settings after the merge: allowed origins
+	// combined, and IP allowlists and email domains combined when both
+	// organizations restrict them. Notifications and the session policy
+	// stay the target's.
+	Settings OrganizationSettings `json:"settings"`
+	// SubOrganizations are the source's sub-organizations, which move under
+	// the target
+	SubOrganizations []uuid.UUID `json:"sub_organizations"`
+	SeatsUsed        int         `json:"seats_used"` // the target's, after the merge
+	SeatLimit        int         `json:"seat_limit"`
+	// LeftBehind describes what the source has that is not merged and is
+	// deleted with it
+	LeftBehind []string `json:"left_behind"`
+	// Problems are why the merge cannot go ahead; it can once there are none
+	Problems []string `json:"problems"`
+}
+
+// MergeUser is what a merge does with one member of the source
+type MergeUser struct {
+	UserID  uuid.UUID `json:"user_id"`
+	Email   string    `json:"email"`
+	Role    string    `json:"role"`
+	NewRole string    `json:"new_role,omitempty"`
+	Action  string    `json:"action"`
+	// KeptUserID is the target's member a duplicate is merged into
+	KeptUserID *uuid.UUID `json:"kept_user_id,omitempty"`
+}
+
+// MergeResponse answers a merge request with its plan, and the job carrying
+// it out unless it was a dry run
+type MergeResponse struct {
+	Plan *MergePlan `json:"plan"`
+	Job  *Job       `json:"job,omitempty"`
+}
+
+// mergePayload is the merge job's payload
+type mergePayload struct {
+	SourceID    uuid.UUID  `json:"source_id"`
+	TargetID    uuid.UUID  `json:"target_id"`
+	OwnerRole   string     `json:"owner_role"`
+	RequestedBy *uuid.UUID `json:"requested_by,omitempty"`
+}
+
+// planMerge works out what merging source into target would do, as the
+// organizations stand now
+func (s *Server) planMerge(ctx context.Context, sourceID, targetID uuid.UUID, ownerRole string) (*MergePlan, error) {
+	source, err := s.store.GetOrganization(ctx, sourceID)
+	if err == sql.ErrNoRows {
+		return nil, ErrOrganizationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	target, err := s.store.GetOrganization(ctx, targetID)
+	if err == sql.ErrNoRows {
+		return nil, ErrMergeTargetNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &MergePlan{
+		SourceID:         sourceID,
+		TargetID:         targetID,
+		Users:            []MergeUser{},
+		SubOrganizations: []uuid.UUID{},
+		SeatLimit:        target.MaxSubAccounts,
+		LeftBehind:       []string{},
+		Problems:         []string{},
+	}
+	if sourceID == targetID {
+		plan.Problems = append(plan.Problems, "an organization cannot be merged into itself")
+		return plan, nil
+	}
+
+	parents, err := s.store.ListOrganizationParents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for id := targetID; ; {
+		parent, ok := parents[id]
+		if !ok {
+			break
+		}
+		if parent == sourceID {
+			plan.Problems = append(plan.Problems, "the target is a sub-organization of the source; move it out first")
+			break
+		}
+		id = parent
+		if id == targetID {
+			break
+		}
+	}
+	for child, parent := range parents {
+		if parent == sourceID && child != targetID {
+			plan.SubOrganizations = append(plan.SubOrganizations, child)
+		}
+	}
+	slices.SortFunc(plan.SubOrganizations, func(a, b uuid.UUID) int { return strings.Compare(a.String(), b.String()) })
+
+	members, err := s.store.GetOrganizationUsers(ctx, targetID)
+	if err != nil {
+		return nil, err
+	}
+	byEmail := make(map[string]uuid.UUID, len(members))
+	for _, member := range members {
+		byEmail[strings.ToLower(member.Email)] = member.ID
+		if member.Role == "sub_account" {
+			plan.SeatsUsed++
+		}
+	}
+	users, err := s.store.GetOrganizationUsers(ctx, sourceID)
+	if err != nil {
+		return nil, err
+	}
+	for _, user := range users {
+		planned := MergeUser{UserID: user.ID, Email: user.Email, Role: user.Role, Action: MergeMove, NewRole: user.Role}
+		if kept, ok := byEmail[strings.ToLower(user.Email)]; ok {
+			planned.Action, planned.NewRole, planned.KeptUserID = MergeDuplicate, "", &kept
+		} else if user.Role == "owner" {
+			planned.NewRole = ownerRole
+		}
+		if planned.NewRole == "sub_account" {
+			plan.SeatsUsed++
+		}
+		plan.Users = append(plan.Users, planned)
+	}
+	if target.SeatOverage != SeatOverageAllow && plan.SeatsUsed > target.MaxSubAccounts {
+		plan.Problems = append(plan.Problems, fmt.Sprintf("the target needs %d seats but has %d; raise its seat limit or allow overage first",
+			plan.SeatsUsed, target.MaxSubAccounts))
+	}
+
+	sourceSettings, _, err := s.store.GetOrganizationSettings(ctx, sourceID)
+	if err != nil {
+		return nil, err
+	}
+	targetSettings, _, err := s.store.GetOrganizationSettings(ctx, targetID)
+	if err != nil {
+		return nil, err
+	}
+	plan.Settings = combineSettings(targetSettings, sourceSettings)
+	if err := ValidateOrganizationSettings(&plan.Settings); err != nil {
+		plan.Problems = append(plan.Problems, "the combined settings are invalid: "+err.Error())
+	}
+
+	leftBehind, err := s.mergeLeftBehind(ctx, sourceID)
+	if err != nil {
+		return nil, err
+	}
+	plan.LeftBehind = leftBehind
+	if source.SuspendedAt != nil {
+		plan.LeftBehind = append(plan.LeftBehind, "its suspension; the moved members take the target's standing")
+	}
+	return plan, nil
+}
+
+// combineSettings folds source's settings into target's
+func combineSettings(target, source *OrganizationSettings) OrganizationSettings {
+	combined := *copySettings(target)
+	combined.AllowedOrigins = union(target.AllowedOrigins, source.AllowedOrigins)
+	// An organization without a restriction has nothing to add to one
+	if len(target.IPAllowlist) > 0 && len(source.IPAllowlist) > 0 {
+		combined.IPAllowlist = union(target.IPAllowlist, source.IPAllowlist)
+	}
+	if len(target.EmailDomains) > 0 && len(source.EmailDomains) > 0 {
+		combined.EmailDomains = union(target.EmailDomains, source.EmailDomains)
+	}
+	return combined
+}
+
+// union returns a followed by the entries of b that it lacks
+func union(a, b []string) []string {
+	combined := append([]string{}, a...)
+	for _, entry := range b {
+		if !slices.Contains(combined, entry) {
+			combined = append(combined, entry)
+		}
+	}
+	return combined
+}
+
+// mergeLeftBehind describes what an organization has that a merge does not
+// carry over
+func (s *Server) mergeLeftBehind(ctx context.Context, orgID uuid.UUID) ([]string, error) {
+	leftBehind := []string{}
+	webhooks, err := s.store.ListWebhooks(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	clients, err := s.store.ListOIDCClients(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	grants, err := s.store.ListAccessGrants(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	for _, count := range []struct {
+		n    int
+		what string
+	}{
+		{len(webhooks), "webhooks"},
+		{len(clients), "OIDC clients"},
+		{len(grants), "access grants"},
+	} {
+		if count.n > 0 {
+			leftBehind = append(leftBehind, fmt.Sprintf("%d %s", count.n, count.what))
+		}
+	}
+	switch _, err := s.store.GetDirectorySync(ctx, orgID); err {
+	case nil:
+		leftBehind = append(leftBehind, "its directory sync")
+	case ErrDirectorySyncNotFound:
+	default:
+		return nil, err
+	}
+	return leftBehind, nil
+}
+
+// runMerge is the merge job's handler. Every step can be repeated, so a
+// merge that fails part way carries on from where it stopped when the job
+// is retried. Once the source is deleted the merge is done.
+func (s *Server) runMerge(ctx context.Context, raw json.RawMessage) error {
+	var payload mergePayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return err
+	}
+
+	plan, err := s.planMerge(ctx, payload.SourceID, payload.TargetID, payload.OwnerRole)
+	if err == ErrOrganizationNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(plan.Problems) > 0 {
+		return fmt.Errorf("merge cannot go ahead: %s", strings.Join(plan.Problems, "; "))
+	}
+
+	record := func(orgID uuid.UUID, action, targetID string, metadata AuditMetadata) {
+		metadata["merge_source_id"] = payload.SourceID.String()
+		metadata["merge_target_id"] = payload.TargetID.String()
+		s.recordEvent(ctx, &AuditEvent{OrganizationID: &orgID, ActorID: payload.RequestedBy, Action: action, TargetID: targetID, Metadata: metadata})
+	}
+
+	_, version, err := s.store.GetOrganizationSettings(ctx, payload.TargetID)
+	if err != nil {
+		return err
+	}
+	if _, err := s.store.UpdateOrganizationSettings(ctx, payload.TargetID, version, &plan.Settings); err != nil {
+		return err
+	}
+
+	var moved, duplicates int
+	for _, user := range plan.Users {
+		if user.Action == MergeDuplicate {
+			duplicates++
+			continue
+		}
+		switch _, err := s.store.MoveUser(ctx, user.UserID, payload.SourceID, payload.TargetID, user.NewRole); err {
+		case nil:
+			moved++
+			// Recorded under the target, where the member's history goes on;
+			// their earlier events stay under the source
+			record(payload.TargetID, "user.moved", user.UserID.String(), AuditMetadata{
+				"previous_role": user.Role,
+				"role":          user.NewRole,
+			})
+		case ErrUserNotFound:
+			// Left the source since the plan was made
+		default:
+			return err
+		}
+	}
+
+	for _, childID := range plan.SubOrganizations {
+		child, err := s.store.GetOrganization(ctx, childID)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := s.store.SetOrganizationParent(ctx, childID, child.Version, &payload.TargetID); err != nil {
+			return err
+		}
+		record(childID, "organization.parent_changed", childID.String(), AuditMetadata{"parent_id": payload.TargetID.String()})
+	}
+
+	// Deleting the source deletes the duplicates left in it and signs them out
+	if err := s.store.DeleteOrganization(ctx, payload.SourceID); err != nil && err != ErrOrganizationNotFound {
+		return err
+	}
+	for _, user := range plan.Users {
+		if user.Action == MergeDuplicate {
+			record(payload.SourceID, "user.deleted", user.UserID.String(), AuditMetadata{
+				"source":       "merge",
+				"kept_user_id": user.KeptUserID.String(),
+			})
+		}
+	}
+
+	s.auth.hierarchy.Invalidate()
+	s.auth.allowlists.Invalidate()
+	if s.cors.orgOrigins != nil {
+		s.cors.orgOrigins.Invalidate()
+	}
+
+	// The merge is recorded in both organizations' trails, so each points
+	// to the other
+	for _, orgID := range []uuid.UUID{payload.SourceID, payload.TargetID} {
+		record(orgID, "organization.merged", payload.SourceID.String(), AuditMetadata{
+			"moved":      fmt.Sprint(moved),
+			"duplicates": fmt.Sprint(duplicates),
+		})
+	}
+	s.logger.InfoContext(ctx, "merged organization", "source_id", payload.SourceID, "target_id", payload.TargetID,
+		"moved", moved, "duplicates", duplicates)
+	return nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCombineSettings(t *testing.T) {
+	target := &OrganizationSettings{
+		AllowedOrigins: []string{"https://app.target.test"},
+		EmailDomains:   []string{"target.test"},
+	}
+	source := &OrganizationSettings{
+		AllowedOrigins: []string{"https://app.source.test", "https://app.target.test"},
+		IPAllowlist:    []string{"198.51.100.0/24"},
+	}
+
+	combined := combineSettings(target, source)
+	require.Equal(t, []string{"https://app.target.test", "https://app.source.test"}, combined.AllowedOrigins)
+	require.Empty(t, combined.IPAllowlist, "an unrestricted target stays unrestricted")
+	require.Equal(t, []string{"target.test"}, combined.EmailDomains, "an unrestricted source adds no domains")
+
+	source.EmailDomains = []string{"source.test"}
+	combined = combineSettings(target, source)
+	require.Equal(t, []string{"target.test", "source.test"}, combined.EmailDomains)
+	require.Equal(t, []string{"https://app.target.test"}, target.AllowedOrigins, "the target's settings are left alone")
+}
+
+func TestMergeOrganizations(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	srv, err := NewServer(store, newTestConfig(t))
+	require.NoError(t, err)
+	defer srv.jobs.Close(ctx)
+
+	ops, err := store.CreateOrganization(ctx, "Ops", "ops@platform.test", "Operator")
+	require.NoError(t, err)
+	store.users[ops.OwnerID].Permissions[string(PermPlatformAdmin)] = true
+	operator, err := store.GetUser(ctx, ops.OwnerID)
+	require.NoError(t, err)
+	token, err := srv.tokenManager.GenerateToken(operator)
+	require.NoError(t, err)
+
+	source, err := store.CreateOrganization(ctx, "Acquired", "owner@acquired.test", "Owner")
+	require.NoError(t, err)
+	target, err := store.CreateOrganization(ctx, "Acquirer", "owner@acquirer.test", "Owner")
+	require.NoError(t, err)
+	mover, err := store.AddUserToOrganization(ctx, source.ID, "mover@acquired.test", "Mover")
+	require.NoError(t, err)
+	duplicate, err := store.AddUserToOrganization(ctx, source.ID, "Shared@Partner.test", "Shared")
+	require.NoError(t, err)
+	kept, err := store.AddUserToOrganization(ctx, target.ID, "shared@partner.test", "Shared")
+	require.NoError(t, err)
+	child, err := store.CreateOrganization(ctx, "Acquired EU", "owner@eu.acquired.test", "Owner")
+	require.NoError(t, err)
+	_, err = store.SetOrganizationParent(ctx, child.ID, child.Version, &source.ID)
+	require.NoError(t, err)
+
+	_, version, err := store.GetOrganizationSettings(ctx, source.ID)
+	require.NoError(t, err)
+	_, err = store.UpdateOrganizationSettings(ctx, source.ID, version, &OrganizationSettings{AllowedOrigins: []string{"https://app.acquired.test"}})
+	require.NoError(t, err)
+
+	merge := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/admin/organizations/"+source.ID.String()+"/merge", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		addCSRFToken(t, srv, req)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return w
+	}
+
+	require.Equal(t, http.StatusBadRequest, merge(`{"into":"`+ops.ID.String()+`","owner_role":"owner"}`).Code)
+	require.Equal(t, http.StatusBadRequest, merge(`{"into":"00000000-0000-0000-0000-000000000001"}`).Code)
+	w := merge(`{"into":"` + source.ID.String() + `"}`)
+	require.Equal(t, http.StatusConflict, w.Code, "an organization cannot be merged into itself")
+
+	t.Run("Dry run", func(t *testing.T) {
+		w := merge(`{"into":"` + target.ID.String() + `","dry_run":true}`)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var resp MergeResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+		require.Nil(t, resp.Job)
+
+		plan := resp.Plan
+		require.Empty(t, plan.Problems)
+		require.Len(t, plan.Users, 3)
+		actions := map[string]MergeUser{}
+		for _, user := range plan.Users {
+			actions[user.Email] = user
+		}
+		require.Equal(t, "admin", actions["owner@acquired.test"].NewRole)
+		require.Equal(t, MergeMove, actions[mover.Email].Action)
+		require.Equal(t, MergeDuplicate, actions[duplicate.Email].Action)
+		require.Equal(t, kept.ID, *actions[duplicate.Email].KeptUserID)
+		require.Equal(t, 2, plan.SeatsUsed, "the target's sub-account and the one moving")
+		require.Equal(t, []string{"https://app.acquired.test"}, plan.Settings.AllowedOrigins)
+		require.Len(t, plan.SubOrganizations, 1)
+
+		_, err := store.GetOrganization(ctx, source.ID)
+		require.NoError(t, err, "a dry run changes nothing")
+	})
+
+	t.Run("Plans with problems are refused", func(t *testing.T) {
+		org, err := store.GetOrganization(ctx, target.ID)
+		require.NoError(t, err)
+		limit := org.MaxSubAccounts
+		org, err = store.UpdateOrganizationTier(ctx, target.ID, org.Version, org.SubscriptionTier, 1, SeatOverageBlock)
+		require.NoError(t, err)
+		defer func() {
+			_, err := store.UpdateOrganizationTier(ctx, target.ID, org.Version, org.SubscriptionTier, limit, SeatOverageBlock)
+			require.NoError(t, err)
+		}()
+
+		w := merge(`{"into":"` + target.ID.String() + `"}`)
+		require.Equal(t, http.StatusConflict, w.Code)
+		require.Contains(t, w.Body.String(), "seats")
+	})
+
+	t.Run("Merge", func(t *testing.T) {
+		w := merge(`{"into":"` + target.ID.String() + `"}`)
+		require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+		var resp MergeResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+		require.NotNil(t, resp.Job)
+
+		require.Eventually(t, func() bool {
+			_, err := store.GetOrganization(ctx, source.ID)
+			return err == sql.ErrNoRows
+		}, 10*time.Second, 50*time.Millisecond)
+
+		owner, err := store.GetUser(ctx, source.OwnerID)
+		require.NoError(t, err)
+		require.Equal(t, target.ID, owner.OrganizationID)
+		require.Equal(t, "admin", owner.Role)
+		moved, err := store.GetUser(ctx, mover.ID)
+		require.NoError(t, err)
+		require.Equal(t, target.ID, moved.OrganizationID)
+		require.Equal(t, "sub_account", moved.Role)
+		_, err = store.GetUser(ctx, duplicate.ID)
+		require.Error(t, err, "the duplicate is deleted with the source")
+
+		settings, _, err := store.GetOrganizationSettings(ctx, target.ID)
+		require.NoError(t, err)
+		require.Equal(t, []string{"https://app.acquired.test"}, settings.AllowedOrigins)
+		org, err := store.GetOrganization(ctx, child.ID)
+		require.NoError(t, err)
+		require.Equal(t, target.ID, *org.ParentID)
+
+		// A retried merge whose source is gone is done
+		payload, err := json.Marshal(mergePayload{SourceID: source.ID, TargetID: target.ID, OwnerRole: "admin"})
+		require.NoError(t, err)
+		require.NoError(t, srv.runMerge(ctx, payload))
+	})
+}
+
+func TestMoveUser(t *testing.T) {
+	testdb := setupTestDB(t)
+	defer testdb.teardown(t)
+
+	ctx := context.Background()
+	from, err := testdb.DB.CreateOrganization(ctx, "Move From", "owner@move-from.test", "Owner")
+	require.NoError(t, err)
+	to, err := testdb.DB.CreateOrganization(ctx, "Move To", "owner@move-to.test", "Owner")
+	require.NoError(t, err)
+	member, err := testdb.DB.AddUserToOrganization(ctx, from.ID, "member@move-from.test", "Member")
+	require.NoError(t, err)
+
+	_, err = testdb.DB.MoveUser(ctx, member.ID, to.ID, from.ID, "sub_account")
+	require.ErrorIs(t, err, ErrUserNotFound, "the member is not in the organization named")
+	_, err = testdb.DB.MoveUser(ctx, member.ID, from.ID, to.ID, "owner")
+	require.ErrorIs(t, err, ErrUnknownRole)
+
+	moved, err := testdb.DB.MoveUser(ctx, member.ID, from.ID, to.ID, "admin")
+	require.NoError(t, err)
+	require.Equal(t, to.ID, moved.OrganizationID)
+	require.Equal(t, "admin", moved.Role)
+	require.Equal(t, "member@move-from.test", moved.Email)
+	require.Equal(t, member.Version+1, moved.Version)
+
+	members, err := testdb.DB.GetOrganizationUsers(ctx, from.ID)
+	require.NoError(t, err)
+	require.Len(t, members, 1)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// MaxMetricsOrganizations bounds METRICS_ORGANIZATIONS, and so the number of
+// series per organization metric
+const MaxMetricsOrganizations = 100
+
+// orgMetricsTimeout bounds the queries behind one scrape's gauges
+const orgMetricsTimeout = 5 * time.Second
+
+// MetricsConfig picks the organizations given their own metrics. Labelling
+// series by organization for every tenant would overwhelm Prometheus, so
+// only those listed are.
+type MetricsConfig struct {
+	Organizations []uuid.UUID
+	// GaugeTTL is how long the gauges read from the database are served
+	// before they are read again; 0 reads them on every scrape
+	GaugeTTL time.Duration
+}
+
+// NewMetricsConfig creates a metrics configuration from settings
+func NewMetricsConfig(settings Settings) (*MetricsConfig, error) {
+	config := &MetricsConfig{}
+	for _, entry := range splitList(settings("METRICS_ORGANIZATIONS")) {
+		id, err := uuid.Parse(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid METRICS_ORGANIZATIONS entry %q: expected an organization ID", entry)
+		}
+		config.Organizations = append(config.Organizations, id)
+	}
+	if len(config.Organizations) > MaxMetricsOrganizations {
+		return nil, fmt.Errorf("METRICS_ORGANIZATIONS lists %d organizations, more than %d", len(config.Organizations), MaxMetricsOrganizations)
+	}
+	ttl, err := time.ParseDuration(settings.get("METRICS_CACHE_TTL", "30s"))
+	if err != nil || ttl < 0 {
+		return nil, fmt.Errorf("invalid METRICS_CACHE_TTL %q", settings("METRICS_CACHE_TTL"))
+	}
+	config.GaugeTTL = ttl
+	return config, nil
+}
+
+var (
+	orgMembersDesc = prometheus.NewDesc("huachuca_organization_members",
+		"Members of the organization.", []string{"organization"}, nil)
+	orgSeatsUsedDesc = prometheus.NewDesc("huachuca_organization_seats_used",
+		"Sub-accounts taking seats in the organization.", []string{"organization"}, nil)
+	orgSeatLimitDesc = prometheus.NewDesc("huachuca_organization_seat_limit",
+		"Seats the organization's subscription includes.", []string{"organization"}, nil)
+	orgActiveSessionsDesc = prometheus.NewDesc("huachuca_organization_active_sessions",
+		"Sessions of the organization's members that can still be refreshed.", []string{"organization"}, nil)
+)
+
+// OrgMetrics exports business metrics for the organizations in its
+// allowlist: gauges read from the store at most once per GaugeTTL, and
+// counters of the logins and API calls this instance has seen. A nil
+// OrgMetrics counts nothing.
+type OrgMetrics struct {
+	store    OrgStore
+	orgs     map[uuid.UUID]bool
+	logins   *prometheus.CounterVec
+	apiCalls *prometheus.CounterVec
+	logger   *slog.Logger
+	ttl      time.Duration
+	now      func() time.Time
+
+	// Scrapes wait on mu while one reads the gauges, then share them
+	mu       sync.Mutex
+	gauges   []prometheus.Metric
+	gaugesAt time.Time
+}
+
+func NewOrgMetrics(store OrgStore, config *MetricsConfig, logger *slog.Logger) *OrgMetrics {
+	m := &OrgMetrics{
+		store: store,
+		orgs:  make(map[uuid.UUID]bool, len(config.Organizations)),
+		logins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "huachuca_organization_logins_total",
+			Help: "Successful logins by the organization's members.",
+		}, []string{"organization"}),
+		apiCalls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "huachuca_organization_api_calls_total",
+			Help: "Authenticated API calls by the organization's members.",
+		}, []string{"organization"}),
+		logger: logger,
+		ttl:    config.GaugeTTL,
+		now:    time.Now,
+	}
+	for _, id := range config.Organizations {
+		m.orgs[id] = true
+	}
+	return m
+}
+
+// RecordLogin counts a member's login, if their organization is listed
+func (m *OrgMetrics) RecordLogin(orgID uuid.UUID) {
+	if m != nil && m.orgs[orgID] {
+		m.logins.WithLabelValues(orgID.String()).Inc()
+	}
+}
+
+// RecordAPICall counts a member's API call, if their organization is listed
+func (m *OrgMetrics) RecordAPICall(orgID uuid.UUID) {
+	if m != nil && m.orgs[orgID] {
+		m.apiCalls.WithLabelValues(orgID.String()).Inc()
+	}
+}
+
+func (m *OrgMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.logins.Describe(ch)
+	m.apiCalls.Describe(ch)
+	ch <- orgMembersDesc
+	ch <- orgSeatsUsedDesc
+	ch <- orgSeatLimitDesc
+	ch <- orgActiveSessionsDesc
+}
+
+// Collect reports the counters and each listed organization's gauges
+func (m *OrgMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.logins.Collect(ch)
+	m.apiCalls.Collect(ch)
+	for _, gauge := range m.readGauges() {
+		ch <- gauge
+	}
+}
+
+// readGauges returns the gauges, reading them from the store unless those
+// read last are younger than the TTL. Deleted organizations, and those that
+// cannot be read, are left out.
+func (m *OrgMetrics) readGauges() []prometheus.Metric {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.gauges != nil && m.now().Sub(m.gaugesAt) < m.ttl {
+		return m.gauges
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), orgMetricsTimeout)
+	defer cancel()
+	gauges := []prometheus.Metric{}
+	for id := range m.orgs {
+		stats, err := m.store.GetOrganizationStats(ctx, id)
+		if err == ErrOrganizationNotFound {
+			continue
+		}
+		if err != nil {
+			m.logger.ErrorContext(ctx, "failed to collect organization metrics", "organization_id", id, "error", err)
+			continue
+		}
+		org := id.String()
+		gauges = append(gauges,
+			prometheus.MustNewConstMetric(orgMembersDesc, prometheus.GaugeValue, float64(stats.Members), org),
+			prometheus.MustNewConstMetric(orgSeatsUsedDesc, prometheus.GaugeValue, float64(stats.SeatsUsed), org),
+			prometheus.MustNewConstMetric(orgSeatLimitDesc, prometheus.GaugeValue, float64(stats.SeatLimit), org),
+			prometheus.MustNewConstMetric(orgActiveSessionsDesc, prometheus.GaugeValue, float64(stats.ActiveSessions), org),
+		)
+	}
+	m.gauges, m.gaugesAt = gauges, m.now()
+	return gauges
+}
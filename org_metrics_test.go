@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewMetricsConfig(t *testing.T) {
+	id := uuid.New()
+	config, err := NewMetricsConfig(func(key string) string {
+		return map[string]string{"METRICS_ORGANIZATIONS": id.String() + ", "}[key]
+	})
+	require.NoError(t, err)
+	require.Equal(t, []uuid.UUID{id}, config.Organizations)
+
+	_, err = NewMetricsConfig(func(string) string { return "acme" })
+	require.ErrorContains(t, err, "METRICS_ORGANIZATIONS")
+
+	ids := make([]string, MaxMetricsOrganizations+1)
+	for i := range ids {
+		ids[i] = uuid.NewString()
+	}
+	_, err = NewMetricsConfig(func(string) string { return strings.Join(ids, ",") })
+	require.ErrorContains(t, err, "more than")
+
+	_, err = NewMetricsConfig(func(key string) string {
+		return map[string]string{"METRICS_CACHE_TTL": "-1s"}[key]
+	})
+	require.ErrorContains(t, err, "METRICS_CACHE_TTL")
+}
+
+func TestOrgMetrics(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	listed, err := store.CreateOrganization(ctx, "Acme", "owner@acme.test", "Owner")
+	require.NoError(t, err)
+	_, err = store.AddUserToOrganization(ctx, listed.ID, "member@acme.test", "Member")
+	require.NoError(t, err)
+	unlisted, err := store.CreateOrganization(ctx, "Initech", "owner@initech.test", "Owner")
+	require.NoError(t, err)
+
+	t.Setenv("METRICS_ORGANIZATIONS", listed.ID.String())
+	srv, err := NewServer(store, newTestConfig(t))
+	require.NoError(t, err)
+
+	for _, org := range []*Organization{listed, unlisted} {
+		owner, err := store.GetUser(ctx, org.OwnerID)
+		require.NoError(t, err)
+		token, err := srv.tokenManager.GenerateToken(owner)
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodGet, "/organizations/"+org.ID.String(), nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+	srv.orgMetrics.RecordLogin(listed.ID)
+	srv.orgMetrics.RecordLogin(unlisted.ID)
+
+	scrape := func() string {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.RemoteAddr = "127.0.0.1:1234"
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		return w.Body.String()
+	}
+	body := scrape()
+	for _, line := range []string{
+		`huachuca_organization_members{organization="%s"} 2`,
+		`huachuca_organization_seats_used{organization="%s"} 1`,
+		`huachuca_organization_logins_total{organization="%s"} 1`,
+		`huachuca_organization_api_calls_total{organization="%s"} 1`,
+	} {
+		require.Contains(t, body, fmt.Sprintf(line, listed.ID))
+	}
+	require.NotContains(t, body, unlisted.ID.String(), "only listed organizations get series")
+
+	t.Run("Gauges are cached", func(t *testing.T) {
+		now := time.Now()
+		srv.orgMetrics.now = func() time.Time { return now }
+		srv.orgMetrics.gauges = nil
+		scrape()
+
+		_, err := store.AddUserToOrganization(ctx, listed.ID, "late@acme.test", "Late")
+		require.NoError(t, err)
+		require.Contains(t, scrape(), fmt.Sprintf(`huachuca_organization_members{organization="%s"} 2`, listed.ID))
+
+		now = now.Add(srv.orgMetrics.ttl)
+		require.Contains(t, scrape(), fmt.Sprintf(`huachuca_organization_members{organization="%s"} 3`, listed.ID))
+	})
+}
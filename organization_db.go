@@ -2,9 +2,14 @@ package main
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
 	"errors"
+	"slices"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
 )
 
 var (
@@ -12,160 +17,546 @@ var (
 	ErrUserNotFound         = errors.New("user not found")
 	ErrEmailTaken           = errors.New("email already taken")
 	ErrMaxSubAccounts       = errors.New("maximum sub-accounts reached")
+	ErrUnknownRole          = errors.New("unknown role")
+	ErrOwnerRole            = errors.New("the owner's role cannot be changed")
+	ErrVersionConflict      = errors.New("modified by another request")
 )
 
 // CreateOrganization creates a new organization and its owner
 func (db *DB) CreateOrganization(ctx context.Context, name, ownerEmail, ownerName string) (*Organization, error) {
-	tx, err := db.BeginTxx(ctx, nil)
+	var org *Organization
+	err := db.transact(ctx, func(tx *sqlx.Tx) error {
+		org = &Organization{
+			ID:               uuid.New(),
+			Name:             name,
+			SubscriptionTier: DefaultSubscriptionTier,
+			MaxSubAccounts:   SubscriptionTiers.DefaultLimit(),
+			SeatOverage:      SeatOverageBlock,
+			Version:          1,
+		}
+
+		// Create organization
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO organizations (id, name, owner_id, subscription_tier, max_sub_accounts, seat_overage)
+			VALUES ($1, $2, $3, $4, $5, $6)
+		`, org.ID, org.Name, org.OwnerID, org.SubscriptionTier, org.MaxSubAccounts, org.SeatOverage)
+		if err != nil {
+			return err
+		}
+
+		// Create owner user
+		owner := &User{
+			ID:             uuid.New(),
+			Email:          ownerEmail,
+			Name:           ownerName,
+			OrganizationID: org.ID,
+			Role:           "owner",
+			Permissions:    Permissions{"admin": true},
+		}
+
+		sealed, err := db.sealUser(owner.Email, owner.Name)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO users (id, email, name, email_hash, organization_id, role, permissions)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+		`, owner.ID, sealed.Email, sealed.Name, sealed.EmailHash, owner.OrganizationID, owner.Role, owner.Permissions)
+		// The unique constraints decide races between concurrent signups
+		if isEmailTaken(err) {
+			return ErrEmailTaken
+		}
+		if err != nil {
+			return err
+		}
+
+		// Update organization with owner ID
+		_, err = tx.ExecContext(ctx, `
+			UPDATE organizations SET owner_id = $1 WHERE id = $2
+		`, owner.ID, org.ID)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer tx.Rollback()
 
-	// Check if email is already taken
-	var count int
-	err = tx.GetContext(ctx, &count, "SELECT COUNT(*) FROM users WHERE email = $1", ownerEmail)
+	return org, nil
+}
+
+// GetOrganization retrieves an organization by ID
+func (db *DB) GetOrganization(ctx context.Context, id uuid.UUID) (*Organization, error) {
+	org := &Organization{}
+	err := db.read(ctx, func(q sqlx.QueryerContext) error {
+		return sqlx.GetContext(ctx, q, org, `
+			SELECT id, name, owner_id, subscription_tier, max_sub_accounts, seat_overage, slug, parent_id, suspended_at, custom_fields, created_at, deleted_at, version, updated_at
+			FROM organizations WHERE id = $1 AND deleted_at IS NULL
+		`, id)
+	})
 	if err != nil {
 		return nil, err
 	}
-	if count > 0 {
-		return nil, ErrEmailTaken
-	}
+	return org, nil
+}
 
-	org := &Organization{
-		ID:               uuid.New(),
-		Name:             name,
-		SubscriptionTier: "free",
-		MaxSubAccounts:   5,
+// GetOrganizationsByIDs retrieves the organizations with the given IDs in a
+// single query, in no particular order. IDs that match no organization are
+// left out.
+func (db *DB) GetOrganizationsByIDs(ctx context.Context, ids []uuid.UUID) ([]Organization, error) {
+	orgs := []Organization{}
+	if len(ids) == 0 {
+		return orgs, nil
+	}
+	err := db.read(ctx, func(q sqlx.QueryerContext) error {
+		return sqlx.SelectContext(ctx, q, &orgs, `
+			SELECT id, name, owner_id, subscription_tier, max_sub_accounts, seat_overage, slug, parent_id, suspended_at, custom_fields, created_at, deleted_at, version, updated_at
+			FROM organizations WHERE id = ANY($1) AND deleted_at IS NULL
+		`, ids)
+	})
+	if err != nil {
+		return nil, err
 	}
+	return orgs, nil
+}
 
-	// Create organization
-	_, err = tx.ExecContext(ctx, `
-		INSERT INTO organizations (id, name, owner_id, subscription_tier, max_sub_accounts)
-		VALUES ($1, $2, $3, $4, $5)
-	`, org.ID, org.Name, org.OwnerID, org.SubscriptionTier, org.MaxSubAccounts)
+// GetOrganizationUsers retrieves all users in an organization
+func (db *DB) GetOrganizationUsers(ctx context.Context, orgID uuid.UUID) ([]User, error) {
+	var users []User
+	err := db.tenantRead(ctx, orgID, func(q sqlx.QueryerContext) error {
+		return sqlx.SelectContext(ctx, q, &users, `
+			SELECT id, email, name, organization_id, role, permissions, metadata, created_at, deleted_at, version, updated_at
+			FROM users WHERE organization_id = $1 AND deleted_at IS NULL
+			ORDER BY email
+		`, orgID)
+	})
+	if err == nil {
+		err = db.openUserList(users)
+	}
 	if err != nil {
 		return nil, err
 	}
+	if db.pii != nil {
+		sortUsersByEmail(users)
+	}
+	return users, nil
+}
 
-	// Create owner user
-	owner := &User{
-		ID:             uuid.New(),
-		Email:          ownerEmail,
-		Name:           ownerName,
-		OrganizationID: org.ID,
-		Role:           "owner",
-		Permissions:    Permissions{"admin": true},
+// SearchOrganizationUsers finds a page of an organization's members whose
+// name or email contains query, best matches first, and how many match
+func (db *DB) SearchOrganizationUsers(ctx context.Context, orgID uuid.UUID, query string, limit, offset int) ([]User, int, error) {
+	if db.pii != nil {
+		// Encrypted names and emails cannot be indexed, so the members are
+		// searched once opened
+		members, err := db.GetOrganizationUsers(ctx, orgID)
+		if err != nil {
+			return nil, 0, err
+		}
+		page, total := paginate(rankUsers(members, query), limit, offset)
+		return page, total, nil
 	}
 
-	_, err = tx.ExecContext(ctx, `
-		INSERT INTO users (id, email, name, organization_id, role, permissions)
-		VALUES ($1, $2, $3, $4, $5, $6)
-	`, owner.ID, owner.Email, owner.Name, owner.OrganizationID, owner.Role, owner.Permissions)
+	var rows []struct {
+		User
+		Total int `db:"total"`
+	}
+	err := db.tenantRead(ctx, orgID, func(q sqlx.QueryerContext) error {
+		return sqlx.SelectContext(ctx, q, &rows, `
+			SELECT id, email, name, organization_id, role, permissions, metadata, created_at, deleted_at, version, updated_at,
+				COUNT(*) OVER () AS total
+			FROM users
+			WHERE organization_id = $1 AND deleted_at IS NULL
+			  AND (email ILIKE $3 OR name ILIKE $3)
+			ORDER BY (lower(email) = lower($2) OR lower(name) = lower($2)) DESC,
+				GREATEST(similarity(email, $2), similarity(name, $2)) DESC, email
+			LIMIT $4 OFFSET $5
+		`, orgID, query, likePattern(query), limit, offset)
+	})
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
-	// Update organization with owner ID
-	_, err = tx.ExecContext(ctx, `
-		UPDATE organizations SET owner_id = $1 WHERE id = $2
-	`, owner.ID, org.ID)
+	users := make([]User, len(rows))
+	total := 0
+	for i, row := range rows {
+		users[i], total = row.User, row.Total
+	}
+	return users, total, nil
+}
+
+// AddUserToOrganization adds a new user to an organization
+func (db *DB) AddUserToOrganization(ctx context.Context, orgID uuid.UUID, email, name string) (*User, error) {
+	sealed, err := db.sealUser(email, name)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := tx.Commit(); err != nil {
+	user := &User{}
+	err = db.tenantTx(ctx, orgID, func(tx *sqlx.Tx) error {
+		// Lock the organization so concurrent additions count seats one at a
+		// time. This has to be its own statement: a statement sees the rows
+		// committed before it started, so a count taken alongside the lock
+		// would miss seats filled while waiting for it.
+		var locked int
+		err := tx.GetContext(ctx, &locked, `
+			SELECT 1 FROM organizations WHERE id = $1 AND deleted_at IS NULL FOR UPDATE
+		`, orgID)
+		if err != nil {
+			return err
+		}
+
+		// Count seats and insert only if one is free, or the organization
+		// pays for overage, in one round trip
+		err = tx.GetContext(ctx, user, `
+			INSERT INTO users (id, email, name, email_hash, organization_id, role, permissions)
+			SELECT $1, $2, $3, $4, o.id, 'sub_account', $5
+			FROM organizations o
+			WHERE o.id = $6 AND o.deleted_at IS NULL
+			  AND (o.seat_overage = 'allow' OR (SELECT COUNT(*) FROM users u
+			       WHERE u.organization_id = o.id AND u.role = 'sub_account' AND u.deleted_at IS NULL) < o.max_sub_accounts)
+			RETURNING id, email, name, organization_id, role, permissions, metadata, created_at, deleted_at, version, updated_at
+		`, uuid.New(), sealed.Email, sealed.Name, sealed.EmailHash, Permissions{}, orgID)
+		if isEmailTaken(err) {
+			return ErrEmailTaken
+		}
+		if err == sql.ErrNoRows {
+			return ErrMaxSubAccounts
+		}
+		if err != nil {
+			return err
+		}
+		return recordSeatChange(ctx, tx, orgID, user.ID, 1)
+	})
+	if err == nil {
+		err = db.openUsers(user)
+	}
+	if err != nil {
 		return nil, err
 	}
 
-	return org, nil
+	return user, nil
 }
 
-// GetOrganization retrieves an organization by ID
-func (db *DB) GetOrganization(ctx context.Context, id uuid.UUID) (*Organization, error) {
-	org := &Organization{}
-	err := db.GetContext(ctx, org, `
-		SELECT id, name, owner_id, subscription_tier, max_sub_accounts, created_at
-		FROM organizations WHERE id = $1
-	`, id)
+// UpdateUserRole gives a member of an organization one of the
+// AssignableRoles. Only sub-accounts take seats, so making a member a
+// sub-account fails with ErrMaxSubAccounts when no seat is free and the
+// organization blocks overage.
+func (db *DB) UpdateUserRole(ctx context.Context, orgID, userID uuid.UUID, role string) (*User, error) {
+	if !slices.Contains(AssignableRoles, role) {
+		return nil, ErrUnknownRole
+	}
+
+	user := &User{}
+	err := db.tenantTx(ctx, orgID, func(tx *sqlx.Tx) error {
+		// Lock the organization so seats are counted one change at a time,
+		// as in AddUserToOrganization
+		var org struct {
+			MaxSubAccounts int    `db:"max_sub_accounts"`
+			SeatOverage    string `db:"seat_overage"`
+		}
+		err := tx.GetContext(ctx, &org, `
+			SELECT max_sub_accounts, seat_overage FROM organizations WHERE id = $1 AND deleted_at IS NULL FOR UPDATE
+		`, orgID)
+		if err == sql.ErrNoRows {
+			return ErrUserNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		err = tx.GetContext(ctx, user, `
+			SELECT id, email, name, organization_id, role, permissions, metadata, created_at, deleted_at, version, updated_at
+			FROM users WHERE id = $1 AND organization_id = $2 AND deleted_at IS NULL
+		`, userID, orgID)
+		if err == sql.ErrNoRows {
+			return ErrUserNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if user.Role == "owner" {
+			return ErrOwnerRole
+		}
+		if user.Role == role {
+			return nil
+		}
+
+		if role == "sub_account" && org.SeatOverage != SeatOverageAllow {
+			var seats int
+			err := tx.GetContext(ctx, &seats, `
+				SELECT COUNT(*) FROM users WHERE organization_id = $1 AND role = 'sub_account' AND deleted_at IS NULL
+			`, orgID)
+			if err != nil {
+				return err
+			}
+			if seats >= org.MaxSubAccounts {
+				return ErrMaxSubAccounts
+			}
+		}
+
+		previous := user.Role
+		err = tx.GetContext(ctx, user, `
+			UPDATE users SET role = $2, version = version + 1, updated_at = NOW()
+			WHERE id = $1
+			RETURNING id, email, name, organization_id, role, permissions, metadata, created_at, deleted_at, version, updated_at
+		`, userID, role)
+		if err != nil {
+			return err
+		}
+		if previous == "sub_account" {
+			return recordSeatChange(ctx, tx, orgID, userID, -1)
+		}
+		if role == "sub_account" {
+			return recordSeatChange(ctx, tx, orgID, userID, 1)
+		}
+		return nil
+	})
+	if err == nil {
+		err = db.openUsers(user)
+	}
 	if err != nil {
 		return nil, err
 	}
-	return org, nil
+	return user, nil
 }
 
-// GetOrganizationUsers retrieves all users in an organization
-func (db *DB) GetOrganizationUsers(ctx context.Context, orgID uuid.UUID) ([]User, error) {
-	var users []User
-	err := db.SelectContext(ctx, &users, `
-		SELECT id, email, name, organization_id, role, permissions, created_at
-		FROM users WHERE organization_id = $1
-	`, orgID)
+// OrganizationStats summarizes seat usage and activity for an organization
+type OrganizationStats struct {
+	OrganizationID uuid.UUID `json:"organization_id"`
+	SeatsUsed      int       `json:"seats_used"`
+	SeatLimit      int       `json:"seat_limit"`
+	Members        int       `json:"members"`
+	ActiveSessions int       `json:"active_sessions"`
+	APICalls30d    int64     `json:"api_calls_30d"`
+	// Invitations waiting to be accepted, and those that expired unaccepted
+	// and are still kept
+	PendingInvitations int `json:"pending_invitations"`
+	ExpiredInvitations int `json:"expired_invitations"`
+}
+
+// GetOrganizationStats aggregates seat, session, and API usage counters for an organization
+func (db *DB) GetOrganizationStats(ctx context.Context, orgID uuid.UUID) (*OrganizationStats, error) {
+	stats := &OrganizationStats{OrganizationID: orgID}
+
+	err := db.tenantRead(ctx, orgID, func(q sqlx.QueryerContext) error {
+		return q.QueryRowxContext(ctx, `
+			SELECT
+				o.max_sub_accounts,
+				(SELECT COUNT(*) FROM users u
+				 WHERE u.organization_id = o.id AND u.role = 'sub_account' AND u.deleted_at IS NULL),
+				(SELECT COUNT(*) FROM users u WHERE u.organization_id = o.id AND u.deleted_at IS NULL),
+				(SELECT COUNT(*) FROM refresh_tokens rt
+				 JOIN users u ON u.id = rt.user_id
+				 WHERE u.organization_id = o.id AND rt.expires_at > NOW() AND rt.revoked_at IS NULL),
+				(SELECT COALESCE(SUM(calls), 0) FROM organization_api_usage a
+				 WHERE a.organization_id = o.id AND a.day > CURRENT_DATE - 30),
+				(SELECT COUNT(*) FROM invitations i WHERE i.organization_id = o.id AND i.expires_at > NOW()),
+				(SELECT COUNT(*) FROM invitations i WHERE i.organization_id = o.id AND i.expires_at <= NOW())
+			FROM organizations o
+			WHERE o.id = $1 AND o.deleted_at IS NULL
+		`, orgID).Scan(&stats.SeatLimit, &stats.SeatsUsed, &stats.Members, &stats.ActiveSessions, &stats.APICalls30d,
+			&stats.PendingInvitations, &stats.ExpiredInvitations)
+	})
+	if err == sql.ErrNoRows {
+		return nil, ErrOrganizationNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
-	return users, nil
+
+	return stats, nil
 }
 
-// AddUserToOrganization adds a new user to an organization
-func (db *DB) AddUserToOrganization(ctx context.Context, orgID uuid.UUID, email, name string) (*User, error) {
-	tx, err := db.BeginTxx(ctx, nil)
+// IncrementAPIUsage adds calls to an organization's API usage counter for the given day
+func (db *DB) IncrementAPIUsage(ctx context.Context, orgID uuid.UUID, day time.Time, calls int64) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO organization_api_usage (organization_id, day, calls)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (organization_id, day)
+		DO UPDATE SET calls = organization_api_usage.calls + EXCLUDED.calls
+	`, orgID, day.Format("2006-01-02"), calls)
+	return err
+}
+
+// GetOrganizationSettings retrieves an organization's settings along with
+// the organization's version
+func (db *DB) GetOrganizationSettings(ctx context.Context, orgID uuid.UUID) (*OrganizationSettings, int, error) {
+	settings := &OrganizationSettings{}
+	var version int
+	err := db.tenantRead(ctx, orgID, func(q sqlx.QueryerContext) error {
+		return q.QueryRowxContext(ctx, `
+			SELECT settings, version FROM organizations WHERE id = $1 AND deleted_at IS NULL
+		`, orgID).Scan(settings, &version)
+	})
+	if err == sql.ErrNoRows {
+		return nil, 0, ErrOrganizationNotFound
+	}
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
-	defer tx.Rollback()
+	return settings, version, nil
+}
 
-	// Check if email is already taken
-	var count int
-	err = tx.GetContext(ctx, &count, "SELECT COUNT(*) FROM users WHERE email = $1", email)
+// UpdateOrganizationSettings replaces the settings of an organization at
+// expectedVersion and returns its new version
+func (db *DB) UpdateOrganizationSettings(ctx context.Context, orgID uuid.UUID, expectedVersion int, settings *OrganizationSettings) (int, error) {
+	var version int
+	err := db.tenantTx(ctx, orgID, func(tx *sqlx.Tx) error {
+		if err := lockOrganizationVersion(ctx, tx, orgID, expectedVersion); err != nil {
+			return err
+		}
+		return tx.GetContext(ctx, &version, `
+			UPDATE organizations SET settings = $2, version = version + 1, updated_at = NOW()
+			WHERE id = $1
+			RETURNING version
+		`, orgID, settings)
+	})
 	if err != nil {
-		return nil, err
-	}
-	if count > 0 {
-		return nil, ErrEmailTaken
+		return 0, err
 	}
+	return version, nil
+}
 
-	// Check number of existing sub-accounts
-	err = tx.GetContext(ctx, &count, `
-		SELECT COUNT(*) FROM users
-		WHERE organization_id = $1 AND role = 'sub_account'
-	`, orgID)
+// RenameOrganization renames an organization at expectedVersion
+func (db *DB) RenameOrganization(ctx context.Context, id uuid.UUID, expectedVersion int, name string) (*Organization, error) {
+	org := &Organization{}
+	err := db.tenantTx(ctx, id, func(tx *sqlx.Tx) error {
+		if err := lockOrganizationVersion(ctx, tx, id, expectedVersion); err != nil {
+			return err
+		}
+		return tx.GetContext(ctx, org, `
+			UPDATE organizations SET name = $2, version = version + 1, updated_at = NOW()
+			WHERE id = $1
+			RETURNING id, name, owner_id, subscription_tier, max_sub_accounts, seat_overage, slug, parent_id, suspended_at, custom_fields, created_at, deleted_at, version, updated_at
+		`, id, name)
+	})
 	if err != nil {
 		return nil, err
 	}
+	return org, nil
+}
+
+func (db *DB) UpdateUserMetadata(ctx context.Context, orgID, userID uuid.UUID, expectedVersion int, metadata UserMetadata) (*User, error) {
+	user := &User{}
+	err := db.tenantTx(ctx, orgID, func(tx *sqlx.Tx) error {
+		var version int
+		err := tx.GetContext(ctx, &version, `
+			SELECT version FROM users WHERE id = $1 AND organization_id = $2 AND deleted_at IS NULL FOR UPDATE
+		`, userID, orgID)
+		if err == sql.ErrNoRows {
+			return ErrUserNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if version != expectedVersion {
+			return ErrVersionConflict
+		}
 
-	var maxSubAccounts int
-	err = tx.GetContext(ctx, &maxSubAccounts, `
-		SELECT max_sub_accounts FROM organizations WHERE id = $1
-	`, orgID)
+		return tx.GetContext(ctx, user, `
+			UPDATE users SET metadata = $2, version = version + 1, updated_at = NOW()
+			WHERE id = $1
+			RETURNING id, email, name, organization_id, role, permissions, metadata, created_at, deleted_at, version, updated_at
+		`, userID, metadata)
+	})
+	if err == nil {
+		err = db.openUsers(user)
+	}
 	if err != nil {
 		return nil, err
 	}
+	return user, nil
+}
 
-	if count >= maxSubAccounts {
-		return nil, ErrMaxSubAccounts
+// lockOrganizationVersion locks an organization for the rest of tx and checks
+// that nobody has changed it since the caller read expectedVersion
+func lockOrganizationVersion(ctx context.Context, tx *sqlx.Tx, id uuid.UUID, expectedVersion int) error {
+	var version int
+	err := tx.GetContext(ctx, &version, `
+		SELECT version FROM organizations WHERE id = $1 AND deleted_at IS NULL FOR UPDATE
+	`, id)
+	if err == sql.ErrNoRows {
+		return ErrOrganizationNotFound
+	}
+	if err != nil {
+		return err
 	}
+	if version != expectedVersion {
+		return ErrVersionConflict
+	}
+	return nil
+}
 
-	user := &User{
-		ID:             uuid.New(),
-		Email:          email,
-		Name:           name,
-		OrganizationID: orgID,
-		Role:           "sub_account",
-		Permissions:    Permissions{},
+// ListOrganizationOrigins returns every origin registered by an organization
+// that is not suspended, reading outside any tenant
+func (db *DB) ListOrganizationOrigins(ctx context.Context) ([]string, error) {
+	ctx = unscoped(ctx)
+	origins := []string{}
+	err := db.read(ctx, func(q sqlx.QueryerContext) error {
+		return sqlx.SelectContext(ctx, q, &origins, `
+			SELECT DISTINCT jsonb_array_elements_text(settings->'allowed_origins')
+			FROM organizations
+			WHERE suspended_at IS NULL AND deleted_at IS NULL AND jsonb_typeof(settings->'allowed_origins') = 'array'
+		`)
+	})
+	if err != nil {
+		return nil, err
 	}
+	return origins, nil
+}
 
-	_, err = tx.ExecContext(ctx, `
-		INSERT INTO users (id, email, name, organization_id, role, permissions)
-		VALUES ($1, $2, $3, $4, $5, $6)
-	`, user.ID, user.Email, user.Name, user.OrganizationID, user.Role, user.Permissions)
+// ListOrganizationIPAllowlists returns every organization's allowlist,
+// reading outside any tenant
+func (db *DB) ListOrganizationIPAllowlists(ctx context.Context) (map[uuid.UUID][]string, error) {
+	ctx = unscoped(ctx)
+	var rows []struct {
+		ID     uuid.UUID `db:"id"`
+		Ranges []byte    `db:"ranges"`
+	}
+	err := db.read(ctx, func(q sqlx.QueryerContext) error {
+		return sqlx.SelectContext(ctx, q, &rows, `
+			SELECT id, settings->'ip_allowlist' AS ranges
+			FROM organizations
+			WHERE deleted_at IS NULL AND jsonb_typeof(settings->'ip_allowlist') = 'array'
+			  AND jsonb_array_length(settings->'ip_allowlist') > 0
+		`)
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	if err := tx.Commit(); err != nil {
+	allowlists := make(map[uuid.UUID][]string, len(rows))
+	for _, row := range rows {
+		var ranges []string
+		if err := json.Unmarshal(row.Ranges, &ranges); err != nil {
+			return nil, err
+		}
+		allowlists[row.ID] = ranges
+	}
+	return allowlists, nil
+}
+
+// ListOrganizationParents returns every organization's parent, reading
+// outside any tenant
+func (db *DB) ListOrganizationParents(ctx context.Context) (map[uuid.UUID]uuid.UUID, error) {
+	ctx = unscoped(ctx)
+	var rows []struct {
+		ID       uuid.UUID `db:"id"`
+		ParentID uuid.UUID `db:"parent_id"`
+	}
+	err := db.read(ctx, func(q sqlx.QueryerContext) error {
+		return sqlx.SelectContext(ctx, q, &rows, `
+			SELECT o.id, o.parent_id
+			FROM organizations o
+			JOIN organizations p ON p.id = o.parent_id AND p.deleted_at IS NULL
+			WHERE o.deleted_at IS NULL
+		`)
+	})
+	if err != nil {
 		return nil, err
 	}
 
-	return user, nil
+	parents := make(map[uuid.UUID]uuid.UUID, len(rows))
+	for _, row := range rows {
+		parents[row.ID] = row.ParentID
+	}
+	return parents, nil
 }
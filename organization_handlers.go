@@ -1,18 +1,35 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 )
 
+// OrganizationMaxAge is how long clients may reuse an organization's details
+// before revalidating them
+const OrganizationMaxAge = 30 * time.Second
+
 type CreateOrganizationRequest struct {
 	Name       string `json:"name"`
 	OwnerEmail string `json:"owner_email"`
 	OwnerName  string `json:"owner_name"`
+	// Slug optionally names the organization in URLs; it is lowercased
+	Slug string `json:"slug,omitempty"`
+}
+
+// UpdateOrganizationRequest changes an organization's details
+type UpdateOrganizationRequest struct {
+	Name string `json:"name"`
 }
 
 type AddUserRequest struct {
@@ -20,124 +37,470 @@ type AddUserRequest struct {
 	Name  string `json:"name"`
 }
 
+type UpdateUserRoleRequest struct {
+	Role string `json:"role"` // admin or sub_account
+}
+
 func (s *Server) handleCreateOrganization(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+	var req CreateOrganizationRequest
+	if !decodeJSON(w, r, &req) {
 		return
 	}
 
-	var req CreateOrganizationRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	err := joinValidationErrors(
+		ValidateCreateOrganizationRequest(&req),
+		s.emailPolicy.Check("owner_email", req.OwnerEmail, nil),
+	)
+	if err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	if s.slugs.Reserved[req.Slug] {
+		writeProblem(w, http.StatusConflict, ErrSlugReserved.Error(), &ValidationError{Field: "slug", Message: ErrSlugReserved.Error()})
 		return
 	}
+	req.OwnerEmail = s.emailPolicy.Normalize(req.OwnerEmail)
 
-	if err := ValidateCreateOrganizationRequest(&req); err != nil {
-		var valErr *ValidationError
-		if errors.As(err, &valErr) {
-			http.Error(w, valErr.Error(), http.StatusBadRequest)
-			return
+	org := &Organization{
+		ID:               uuid.New(),
+		Name:             req.Name,
+		SubscriptionTier: DefaultSubscriptionTier,
+		MaxSubAccounts:   SubscriptionTiers.DefaultLimit(),
+		SeatOverage:      SeatOverageBlock,
+		Version:          1,
+	}
+	if req.Slug != "" {
+		org.Slug = &req.Slug
+	}
+	owner := &User{
+		ID:             uuid.New(),
+		Email:          req.OwnerEmail,
+		Name:           req.OwnerName,
+		OrganizationID: org.ID,
+		Role:           "owner",
+		Permissions:    Permissions{"admin": true},
+	}
+	org.OwnerID = owner.ID
+
+	if err := s.store.CreateOrganizationWithOwner(r.Context(), org, owner); err != nil {
+		switch err {
+		case ErrEmailTaken:
+			http.Error(w, err.Error(), http.StatusConflict)
+		case ErrSlugTaken:
+			writeProblem(w, http.StatusConflict, err.Error(), &ValidationError{Field: "slug", Message: err.Error()})
+		default:
+			s.logger.ErrorContext(r.Context(), "failed to create organization", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
 		}
-		http.Error(w, "Invalid request", http.StatusBadRequest)
 		return
 	}
 
-	org, err := s.db.CreateOrganization(r.Context(), req.Name, req.OwnerEmail, req.OwnerName)
+	s.recordAudit(r, "organization.created", org.ID, org.ID.String(), AuditMetadata{"name": org.Name})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(org)
+}
+
+// checkInvitation applies the email policy, and the organization's email
+// domains, to an address being invited to it
+func (s *Server) checkInvitation(ctx context.Context, orgID uuid.UUID, email string) error {
+	settings, _, err := s.store.GetOrganizationSettings(ctx, orgID)
+	if err != nil {
+		return err
+	}
+	return s.emailPolicy.Check("email", email, settings.EmailDomains)
+}
+
+func (s *Server) handleAddUser(w http.ResponseWriter, r *http.Request) {
+	orgID := pathOrgID(r)
+
+	var req AddUserRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	err := joinValidationErrors(ValidateAddUserRequest(&req), s.checkInvitation(r.Context(), orgID, req.Email))
+	var valErr *ValidationError
+	switch {
+	case err == nil:
+	case errors.As(err, &valErr):
+		writeValidationError(w, err)
+		return
+	default:
+		s.logger.ErrorContext(r.Context(), "failed to get organization settings", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	req.Email = s.emailPolicy.Normalize(req.Email)
+
+	user, err := s.store.AddUserToOrganization(r.Context(), orgID, req.Email, req.Name)
 	if err != nil {
 		switch err {
 		case ErrEmailTaken:
 			http.Error(w, err.Error(), http.StatusConflict)
+		case ErrMaxSubAccounts:
+			http.Error(w, err.Error(), http.StatusForbidden)
 		default:
-			s.logger.Error("failed to create organization", "error", err)
+			s.logger.ErrorContext(r.Context(), "failed to add user", "error", err)
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 		}
 		return
 	}
 
+	s.recordAudit(r, "user.added", orgID, user.ID.String(), AuditMetadata{"role": user.Role})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+func (s *Server) handleGetOrganizationUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := s.store.GetOrganizationUsers(r.Context(), pathOrgID(r))
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to get organization users", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(users)
+}
+
+func (s *Server) handleGetOrganization(w http.ResponseWriter, r *http.Request) {
+	org, err := s.store.GetOrganization(r.Context(), pathOrgID(r))
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			http.Error(w, ErrOrganizationNotFound.Error(), http.StatusNotFound)
+		default:
+			s.logger.ErrorContext(r.Context(), "failed to get organization", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Organizations rarely change, so dashboards polling them may reuse a
+	// response briefly and then revalidate it by version
+	w.Header().Set("ETag", versionETag(org.Version))
+	w.Header().Set("Cache-Control", "private, max-age="+strconv.Itoa(int(OrganizationMaxAge.Seconds())))
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(org)
 }
 
-func (s *Server) handleAddUser(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+// handleUpdateOrganization changes the details of an organization at the
+// version its If-Match header names
+func (s *Server) handleUpdateOrganization(w http.ResponseWriter, r *http.Request) {
+	orgID := pathOrgID(r)
+
+	expectedVersion, ok := ifMatchVersion(w, r)
+	if !ok {
 		return
 	}
 
-	// Extract and validate organization ID from URL path
-	parts := strings.Split(r.URL.Path, "/")
-	if len(parts) < 4 {
-		http.Error(w, "Invalid URL", http.StatusBadRequest)
+	var req UpdateOrganizationRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if err := ValidateName(req.Name); err != nil {
+		writeValidationError(w, err)
 		return
 	}
 
-	if err := ValidateUUID(parts[2]); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	org, err := s.store.RenameOrganization(r.Context(), orgID, expectedVersion, req.Name)
+	if err != nil {
+		switch err {
+		case ErrOrganizationNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case ErrVersionConflict:
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			s.logger.ErrorContext(r.Context(), "failed to update organization", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
 		return
 	}
 
-	orgID, _ := uuid.Parse(parts[2]) // Already validated
+	s.recordAudit(r, "organization.updated", orgID, orgID.String(), AuditMetadata{"name": org.Name})
 
-	var req AddUserRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	w.Header().Set("ETag", versionETag(org.Version))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(org)
+}
+
+// handleListOrganizationUsers returns a page of an organization's members,
+// ordered by email
+func (s *Server) handleListOrganizationUsers(w http.ResponseWriter, r *http.Request) {
+	limit, offset, ok := parsePagination(r)
+	if !ok {
+		http.Error(w, "Invalid pagination parameters", http.StatusBadRequest)
 		return
 	}
 
-	if err := ValidateAddUserRequest(&req); err != nil {
-		var valErr *ValidationError
-		if errors.As(err, &valErr) {
-			http.Error(w, valErr.Error(), http.StatusBadRequest)
-			return
+	users, err := s.store.GetOrganizationUsers(r.Context(), pathOrgID(r))
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to get organization users", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	page, _ := paginate(users, limit, offset)
+	if page == nil {
+		page = []User{}
+	}
+
+	w.Header().Set(TotalCountHeader, strconv.Itoa(len(users)))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+func (s *Server) handleRemoveUser(w http.ResponseWriter, r *http.Request) {
+	user := GetResourceFromContext[*User](r.Context())
+
+	if err := s.store.DeleteUser(r.Context(), user.ID); err != nil {
+		switch err {
+		case ErrUserNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case ErrDeleteOwner:
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			s.logger.ErrorContext(r.Context(), "failed to delete user", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
 		}
-		http.Error(w, "Invalid request", http.StatusBadRequest)
 		return
 	}
 
-	user, err := s.db.AddUserToOrganization(r.Context(), orgID, req.Email, req.Name)
+	s.recordAudit(r, "user.deleted", user.OrganizationID, user.ID.String(), nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleUpdateUserRole(w http.ResponseWriter, r *http.Request) {
+	orgID := pathOrgID(r)
+	member := GetResourceFromContext[*User](r.Context())
+
+	var req UpdateUserRoleRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	user, err := s.store.UpdateUserRole(r.Context(), orgID, member.ID, req.Role)
 	if err != nil {
 		switch err {
-		case ErrEmailTaken:
+		case ErrUnknownRole:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case ErrUserNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case ErrOwnerRole:
 			http.Error(w, err.Error(), http.StatusConflict)
 		case ErrMaxSubAccounts:
 			http.Error(w, err.Error(), http.StatusForbidden)
 		default:
-			s.logger.Error("failed to add user", "error", err)
+			s.logger.ErrorContext(r.Context(), "failed to update user role", "error", err)
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 		}
 		return
 	}
 
+	if user.Role != member.Role {
+		s.recordAudit(r, "user.role_changed", orgID, user.ID.String(), AuditMetadata{"from": member.Role, "to": user.Role})
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(user)
 }
 
-func (s *Server) handleGetOrganizationUsers(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+// handleRevokeUserSessions signs a member out everywhere
+func (s *Server) handleRevokeUserSessions(w http.ResponseWriter, r *http.Request) {
+	user := GetResourceFromContext[*User](r.Context())
+
+	if err := s.store.InvalidateUserRefreshTokens(r.Context(), user.ID); err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to revoke sessions", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	s.recordAudit(r, "user.sessions_revoked", user.OrganizationID, user.ID.String(), nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleGetOrganizationStats(w http.ResponseWriter, r *http.Request) {
+	orgID := pathOrgID(r)
+
+	stats, err := s.store.GetOrganizationStats(r.Context(), orgID)
+	if err != nil {
+		switch err {
+		case ErrOrganizationNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			s.logger.ErrorContext(r.Context(), "failed to get organization stats", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
 		return
 	}
 
-	// Extract organization ID from URL path
-	parts := strings.Split(r.URL.Path, "/")
-	if len(parts) != 3 {
-		http.Error(w, "Invalid URL", http.StatusBadRequest)
+	// Include calls recorded since the last flush
+	stats.APICalls30d += s.usage.Pending(orgID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// handleGetBillingUsage reports an organization's paid and used seats, with
+// a page of the seat usage records billing is computed from
+func (s *Server) handleGetBillingUsage(w http.ResponseWriter, r *http.Request) {
+	orgID := pathOrgID(r)
+
+	limit, offset, ok := parsePagination(r)
+	if !ok {
+		http.Error(w, "Invalid pagination parameters", http.StatusBadRequest)
 		return
 	}
 
-	orgID, err := uuid.Parse(parts[2])
+	usage, err := s.store.GetSeatUsage(r.Context(), orgID)
 	if err != nil {
-		http.Error(w, "Invalid organization ID", http.StatusBadRequest)
+		switch err {
+		case ErrOrganizationNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			s.logger.ErrorContext(r.Context(), "failed to get seat usage", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
 		return
 	}
 
-	users, err := s.db.GetOrganizationUsers(r.Context(), orgID)
+	records, total, err := s.store.ListSeatUsageRecords(r.Context(), orgID, limit, offset)
 	if err != nil {
-		s.logger.Error("failed to get organization users", "error", err)
+		s.logger.ErrorContext(r.Context(), "failed to list seat usage records", "error", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
+	usage.Records = records
 
+	w.Header().Set(TotalCountHeader, strconv.Itoa(total))
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(users)
+	json.NewEncoder(w).Encode(usage)
+}
+
+func (s *Server) handleGetOrganizationSettings(w http.ResponseWriter, r *http.Request) {
+	settings, version, err := s.store.GetOrganizationSettings(r.Context(), pathOrgID(r))
+	if err != nil {
+		switch err {
+		case ErrOrganizationNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			s.logger.ErrorContext(r.Context(), "failed to get organization settings", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// The chat webhook URL lets anyone post to the channel
+	if user, err := GetUserFromContext(r.Context()); err == nil && !user.HasPermission(PermManageSettings) &&
+		settings.Notifications != nil {
+		settings.Notifications.WebhookURL = ""
+	}
+
+	w.Header().Set("ETag", versionETag(version))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+func (s *Server) handleUpdateOrganizationSettings(w http.ResponseWriter, r *http.Request) {
+	orgID := pathOrgID(r)
+
+	expectedVersion, ok := ifMatchVersion(w, r)
+	if !ok {
+		return
+	}
+
+	var settings OrganizationSettings
+	if !decodeJSON(w, r, &settings) {
+		return
+	}
+	if settings.AllowedOrigins == nil {
+		settings.AllowedOrigins = []string{}
+	}
+
+	err := ValidateOrganizationSettings(&settings)
+	if settings.Notifications != nil {
+		err = joinValidationErrors(err, ValidateChatNotifications(settings.Notifications, s.webhooks.config))
+	}
+	if settings.Sessions != nil {
+		err = joinValidationErrors(err, ValidateSessionPolicy(settings.Sessions, s.sessions))
+	}
+	if err != nil {
+		writeValidationError(w, err)
+		return
+	}
+
+	// Only the owner can change the IP allowlist, which could lock out
+	// everyone but them, and the session policy
+	current, _, err := s.store.GetOrganizationSettings(r.Context(), orgID)
+	if err != nil {
+		switch err {
+		case ErrOrganizationNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			s.logger.ErrorContext(r.Context(), "failed to get organization settings", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+	allowlistChanged := !slices.Equal(current.IPAllowlist, settings.IPAllowlist)
+	sessionsChanged := !reflect.DeepEqual(current.Sessions, settings.Sessions)
+	if user, _ := GetUserFromContext(r.Context()); user.Role != "owner" {
+		if allowlistChanged {
+			http.Error(w, "Only the owner can change the IP allowlist", http.StatusForbidden)
+			return
+		}
+		if sessionsChanged {
+			http.Error(w, "Only the owner can change the session policy", http.StatusForbidden)
+			return
+		}
+	}
+
+	version, err := s.store.UpdateOrganizationSettings(r.Context(), orgID, expectedVersion, &settings)
+	if err != nil {
+		switch err {
+		case ErrOrganizationNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case ErrVersionConflict:
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			s.logger.ErrorContext(r.Context(), "failed to update organization settings", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Make new origins usable immediately on this instance
+	if s.cors.orgOrigins != nil {
+		s.cors.orgOrigins.Invalidate()
+	}
+	if allowlistChanged {
+		s.auth.allowlists.Invalidate()
+	}
+
+	metadata := AuditMetadata{"allowed_origins": strings.Join(settings.AllowedOrigins, ",")}
+	if allowlistChanged {
+		metadata["ip_allowlist"] = strings.Join(settings.IPAllowlist, ",")
+	}
+	if len(settings.EmailDomains) > 0 {
+		metadata["email_domains"] = strings.Join(settings.EmailDomains, ",")
+	}
+	if n := settings.Notifications; n != nil {
+		metadata["notification_provider"] = n.Provider
+		metadata["notification_events"] = strings.Join(n.Events, ",")
+	}
+	if sessionsChanged {
+		policy, _ := json.Marshal(settings.Sessions)
+		metadata["sessions"] = string(policy)
+	}
+	if settings.Branding != nil {
+		branding, _ := json.Marshal(settings.Branding)
+		metadata["branding"] = string(branding)
+	}
+	s.recordAudit(r, "organization.settings_updated", orgID, orgID.String(), metadata)
+
+	w.Header().Set("ETag", versionETag(version))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
 }
@@ -2,9 +2,14 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
 	"github.com/stretchr/testify/require"
 )
 
@@ -50,12 +55,40 @@ func TestOrganizationOperations(t *testing.T) {
 		require.Equal(t, "sub_account", user.Role)
 		require.Equal(t, org.ID, user.OrganizationID)
 
+		require.False(t, user.CreatedAt.IsZero())
+
 		// Verify users
 		users, err := testdb.DB.GetOrganizationUsers(ctx, org.ID)
 		require.NoError(t, err)
 		require.Len(t, users, 2) // owner + sub-account
 	})
 
+	t.Run("Batch lookups and listing totals", func(t *testing.T) {
+		org, err := testdb.DB.CreateOrganization(ctx, "Test Org Batch", "owner_batch@test.com", "Batch Owner")
+		require.NoError(t, err)
+		sub, err := testdb.DB.AddUserToOrganization(ctx, org.ID, "sub_batch@test.com", "Batch Sub")
+		require.NoError(t, err)
+
+		users, err := testdb.DB.GetUsersByIDs(ctx, []uuid.UUID{org.OwnerID, sub.ID, uuid.New()})
+		require.NoError(t, err)
+		require.Len(t, users, 2)
+
+		orgs, err := testdb.DB.GetOrganizationsByIDs(ctx, []uuid.UUID{org.ID, uuid.New()})
+		require.NoError(t, err)
+		require.Len(t, orgs, 1)
+		require.Equal(t, org.Name, orgs[0].Name)
+
+		found, total, err := testdb.DB.SearchUsers(ctx, "_batch@", false, 1, 0)
+		require.NoError(t, err)
+		require.Len(t, found, 1)
+		require.Equal(t, 2, total)
+
+		// LIKE wildcards in the query match themselves
+		_, total, err = testdb.DB.SearchUsers(ctx, "%batch@", false, 1, 0)
+		require.NoError(t, err)
+		require.Zero(t, total)
+	})
+
 	t.Run("Enforce max sub-accounts limit", func(t *testing.T) {
 		org, err := testdb.DB.CreateOrganization(ctx, "Test Org 4", "owner4@test.com", "Test Owner 4")
 		require.NoError(t, err)
@@ -72,4 +105,202 @@ func TestOrganizationOperations(t *testing.T) {
 		_, err = testdb.DB.AddUserToOrganization(ctx, org.ID, "extra4@test.com", "Extra User")
 		require.ErrorIs(t, err, ErrMaxSubAccounts)
 	})
+
+	t.Run("Concurrent signups with the same email", func(t *testing.T) {
+		errs := make(chan error, 5)
+		var wg sync.WaitGroup
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				_, err := testdb.DB.CreateOrganization(ctx, fmt.Sprintf("Race Org %d", i), "race@test.com", "Racer")
+				errs <- err
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+
+		created := 0
+		for err := range errs {
+			if err == nil {
+				created++
+				continue
+			}
+			require.ErrorIs(t, err, ErrEmailTaken)
+		}
+		require.Equal(t, 1, created)
+	})
+
+	t.Run("Concurrent additions respect the seat limit", func(t *testing.T) {
+		org, err := testdb.DB.CreateOrganization(ctx, "Seat Race Org", "seatrace@test.com", "Seat Owner")
+		require.NoError(t, err)
+
+		errs := make(chan error, 10)
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				_, err := testdb.DB.AddUserToOrganization(ctx, org.ID, fmt.Sprintf("seat%d@test.com", i), "Seat User")
+				errs <- err
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+
+		added := 0
+		for err := range errs {
+			if err == nil {
+				added++
+				continue
+			}
+			require.ErrorIs(t, err, ErrMaxSubAccounts)
+		}
+		require.Equal(t, org.MaxSubAccounts, added)
+	})
+
+	t.Run("Row-level security confines tenant transactions", func(t *testing.T) {
+		orgA, err := testdb.DB.CreateOrganization(ctx, "Tenant A", "tenant-a@test.com", "Tenant A")
+		require.NoError(t, err)
+		orgB, err := testdb.DB.CreateOrganization(ctx, "Tenant B", "tenant-b@test.com", "Tenant B")
+		require.NoError(t, err)
+
+		err = testdb.DB.tenantTx(ctx, orgA.ID, func(tx *sqlx.Tx) error {
+			// No organization filter: the policy supplies it
+			var orgIDs []uuid.UUID
+			require.NoError(t, tx.SelectContext(ctx, &orgIDs, `SELECT DISTINCT organization_id FROM users`))
+			require.Equal(t, []uuid.UUID{orgA.ID}, orgIDs)
+
+			var visible bool
+			require.NoError(t, tx.GetContext(ctx, &visible, `SELECT EXISTS (SELECT 1 FROM organizations WHERE id = $1)`, orgB.ID))
+			require.False(t, visible)
+			return nil
+		})
+		require.NoError(t, err)
+
+		err = testdb.DB.tenantTx(ctx, orgA.ID, func(tx *sqlx.Tx) error {
+			_, err := tx.ExecContext(ctx, `
+				INSERT INTO users (id, email, name, organization_id, role, permissions)
+				VALUES ($1, $2, $3, $4, $5, $6)
+			`, uuid.New(), "intruder@test.com", "Intruder", orgB.ID, "sub_account", Permissions{})
+			return err
+		})
+		require.Error(t, err, "writes into another tenant violate the policy")
+
+		// Outside a tenant transaction every organization is visible again
+		users, err := testdb.DB.GetOrganizationUsers(ctx, orgB.ID)
+		require.NoError(t, err)
+		require.Len(t, users, 1)
+	})
+
+	t.Run("Scoped contexts confine store work", func(t *testing.T) {
+		orgA, err := testdb.DB.CreateOrganization(ctx, "Scope A", "scope-a@test.com", "Scope A")
+		require.NoError(t, err)
+		orgB, err := testdb.DB.CreateOrganization(ctx, "Scope B", "scope-b@test.com", "Scope B")
+		require.NoError(t, err)
+		scoped := WithOrg(ctx, orgA.ID)
+
+		_, err = testdb.DB.GetUser(scoped, orgB.OwnerID)
+		require.ErrorIs(t, err, sql.ErrNoRows)
+		_, err = testdb.DB.GetUser(scoped, orgA.OwnerID)
+		require.NoError(t, err)
+
+		err = testdb.DB.transact(scoped, func(tx *sqlx.Tx) error {
+			var orgIDs []uuid.UUID
+			require.NoError(t, tx.SelectContext(ctx, &orgIDs, `SELECT id FROM organizations`))
+			require.Equal(t, []uuid.UUID{orgA.ID}, orgIDs)
+			return nil
+		})
+		require.NoError(t, err)
+
+		_, err = testdb.DB.GetOrganizationUsers(scoped, orgB.ID)
+		require.ErrorIs(t, err, ErrCrossTenant)
+
+		owner, err := testdb.DB.GetUserByEmail(scoped, "scope-b@test.com")
+		require.NoError(t, err)
+		require.Equal(t, orgB.OwnerID, owner.ID, "email lookups look across organizations")
+	})
+
+	t.Run("Organization settings and origins", func(t *testing.T) {
+		org, err := testdb.DB.CreateOrganization(ctx, "Test Org 5", "owner5@test.com", "Test Owner 5")
+		require.NoError(t, err)
+
+		settings, version, err := testdb.DB.GetOrganizationSettings(ctx, org.ID)
+		require.NoError(t, err)
+		require.Empty(t, settings.AllowedOrigins)
+		require.Equal(t, 1, version)
+
+		newVersion, err := testdb.DB.UpdateOrganizationSettings(ctx, org.ID, version, &OrganizationSettings{
+			AllowedOrigins: []string{"https://app.customer.com"},
+		})
+		require.NoError(t, err)
+		require.Equal(t, 2, newVersion)
+
+		// A writer that read the old version does not clobber the change
+		_, err = testdb.DB.UpdateOrganizationSettings(ctx, org.ID, version, &OrganizationSettings{})
+		require.ErrorIs(t, err, ErrVersionConflict)
+
+		origins, err := testdb.DB.ListOrganizationOrigins(ctx)
+		require.NoError(t, err)
+		require.Contains(t, origins, "https://app.customer.com")
+
+		// Suspended organizations lose cross-origin access
+		_, err = testdb.DB.SetOrganizationSuspended(ctx, org.ID, true)
+		require.NoError(t, err)
+		origins, err = testdb.DB.ListOrganizationOrigins(ctx)
+		require.NoError(t, err)
+		require.NotContains(t, origins, "https://app.customer.com")
+
+		_, err = testdb.DB.UpdateOrganizationSettings(ctx, uuid.New(), 1, &OrganizationSettings{})
+		require.ErrorIs(t, err, ErrOrganizationNotFound)
+	})
+
+	t.Run("Soft deletes and purging", func(t *testing.T) {
+		org, err := testdb.DB.CreateOrganization(ctx, "Test Org 6", "owner6@test.com", "Test Owner 6")
+		require.NoError(t, err)
+		sub, err := testdb.DB.AddUserToOrganization(ctx, org.ID, "sub6@test.com", "Sub User 6")
+		require.NoError(t, err)
+		_, err = testdb.DB.CreateRefreshToken(ctx, sub.ID, DefaultSessionTerms)
+		require.NoError(t, err)
+
+		require.ErrorIs(t, testdb.DB.DeleteUser(ctx, org.OwnerID), ErrDeleteOwner)
+		require.NoError(t, testdb.DB.DeleteUser(ctx, sub.ID))
+		require.ErrorIs(t, testdb.DB.DeleteUser(ctx, sub.ID), ErrUserNotFound)
+
+		users, err := testdb.DB.GetOrganizationUsers(ctx, org.ID)
+		require.NoError(t, err)
+		require.Len(t, users, 1)
+
+		// The partial unique index frees the address of a deleted user
+		_, err = testdb.DB.AddUserToOrganization(ctx, org.ID, "sub6@test.com", "Sub User 6 Again")
+		require.NoError(t, err)
+
+		found, _, err := testdb.DB.SearchUsers(ctx, "sub6@", true, 10, 0)
+		require.NoError(t, err)
+		require.Len(t, found, 2)
+
+		require.NoError(t, testdb.DB.DeleteOrganization(ctx, org.ID))
+		require.ErrorIs(t, testdb.DB.DeleteOrganization(ctx, org.ID), ErrOrganizationNotFound)
+		_, err = testdb.DB.GetOrganization(ctx, org.ID)
+		require.Error(t, err)
+		owner, err := testdb.DB.GetUserByEmail(ctx, "owner6@test.com")
+		require.NoError(t, err)
+		require.Nil(t, owner)
+
+		require.NoError(t, testdb.DB.IncrementAPIUsage(ctx, org.ID, time.Now(), 1))
+		purged, err := testdb.DB.PurgeRetained(ctx, RetentionScope{Except: []uuid.UUID{org.ID}}, RetentionCutoffs{DeletedRecords: time.Now().Add(time.Minute)})
+		require.NoError(t, err)
+		require.Zero(t, purged.Organizations, "outside the scope")
+
+		purged, err = testdb.DB.PurgeRetained(ctx, RetentionScope{OrgID: org.ID}, RetentionCutoffs{DeletedRecords: time.Now().Add(time.Minute)})
+		require.NoError(t, err)
+		require.Equal(t, int64(1), purged.Organizations)
+		require.Equal(t, int64(3), purged.Users)
+
+		listed, _, err := testdb.DB.ListOrganizations(ctx, OrganizationFilter{IncludeDeleted: true}, 1000, 0)
+		require.NoError(t, err)
+		for _, o := range listed {
+			require.NotEqual(t, org.ID, o.ID)
+		}
+	})
 }
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequireOwnership(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	srv, err := NewServer(store, newTestConfig(t))
+	require.NoError(t, err)
+
+	acme, err := store.CreateOrganization(ctx, "Acme", "owner@acme.test", "Owner")
+	require.NoError(t, err)
+	initech, err := store.CreateOrganization(ctx, "Initech", "owner@initech.test", "Owner")
+	require.NoError(t, err)
+	outsider, err := store.AddUserToOrganization(ctx, initech.ID, "member@initech.test", "Member")
+	require.NoError(t, err)
+	hook := &Webhook{ID: uuid.New(), OrganizationID: initech.ID, URL: "https://hooks.initech.test", Events: WebhookEventSet{WebhookUserCreated}, Secret: "whsec_1", Enabled: true}
+	require.NoError(t, store.CreateWebhook(ctx, hook))
+
+	owner, err := store.GetUser(ctx, acme.OwnerID)
+	require.NoError(t, err)
+	token, err := srv.tokenManager.GenerateToken(owner)
+	require.NoError(t, err)
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return w
+	}
+	acmePath := "/organizations/" + acme.ID.String()
+
+	t.Run("Other organizations' resources are not found", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodDelete, acmePath+"/users/"+outsider.ID.String()+"/sessions", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		addCSRFToken(t, srv, req)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		require.Equal(t, http.StatusNotFound, w.Code)
+		require.Contains(t, w.Body.String(), ErrUserNotFound.Error())
+
+		w = get(acmePath + "/webhooks/" + hook.ID.String())
+		require.Equal(t, http.StatusNotFound, w.Code)
+		require.Contains(t, w.Body.String(), ErrWebhookNotFound.Error())
+	})
+
+	t.Run("Malformed IDs are refused", func(t *testing.T) {
+		w := get(acmePath + "/webhooks/not-a-uuid")
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Contains(t, w.Body.String(), "Invalid webhook ID format")
+	})
+
+	t.Run("The loaded resource reaches the handler", func(t *testing.T) {
+		own := &Webhook{ID: uuid.New(), OrganizationID: acme.ID, URL: "https://hooks.acme.test", Events: WebhookEventSet{WebhookUserCreated}, Secret: "whsec_2", Enabled: true}
+		require.NoError(t, store.CreateWebhook(ctx, own))
+
+		w := get(acmePath + "/webhooks/" + own.ID.String())
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var got Webhook
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&got))
+		require.Equal(t, own.ID, got.ID)
+		require.Empty(t, got.Secret)
+	})
+}
@@ -22,6 +22,10 @@ const (
 	PermRemoveUser     Permission = "remove:user"
 	PermUpdateUser     Permission = "update:user"
 	PermManageSettings Permission = "manage:settings"
+
+	// PermPlatformAdmin is never granted by a role; platform operators
+	// receive it through their user-specific permissions.
+	PermPlatformAdmin Permission = "platform:admin"
 )
 
 // RolePermissions defines what permissions each role has
@@ -49,6 +53,10 @@ var RolePermissions = map[string][]Permission{
 	},
 }
 
+// AssignableRoles lists the roles members can be given. Ownership is not
+// transferred this way.
+var AssignableRoles = []string{"admin", "sub_account"}
+
 // HasPermission checks if a user has a specific permission
 func (u *User) HasPermission(perm Permission) bool {
 	// Check role-based permissions
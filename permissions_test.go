@@ -52,6 +52,24 @@ func TestPermissions(t *testing.T) {
 			permission: PermCreateOrg,
 			shouldHave: true,
 		},
+		{
+			name: "Owner does not have platform admin permission",
+			user: User{
+				Role:        "owner",
+				Permissions: Permissions{},
+			},
+			permission: PermPlatformAdmin,
+			shouldHave: false,
+		},
+		{
+			name: "Platform admin granted explicitly",
+			user: User{
+				Role:        "sub_account",
+				Permissions: Permissions{"platform:admin": true},
+			},
+			permission: PermPlatformAdmin,
+			shouldHave: true,
+		},
 	}
 
 	for _, tc := range tests {
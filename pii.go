@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// piiPrefix starts every encrypted value, followed by the ID of the key
+// that sealed it: pii:<key ID>:<base64 nonce and ciphertext>. Values
+// without it were stored before encryption was turned on and are read as
+// they are.
+const piiPrefix = "pii:"
+
+// minPIIHashKeyLen is the shortest PII_HASH_KEY accepted, in bytes
+const minPIIHashKeyLen = 32
+
+var piiKeyIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+// PIICipher encrypts users' email addresses and names before they are
+// stored, with AES-256-GCM. Each value records the key it was sealed
+// with, so keys can be rotated: new values use the first key, and any
+// listed key opens the values already stored. Email addresses are also
+// stored as an HMAC, which is what they are looked up and kept unique by.
+//
+// A nil *PIICipher stores everything in plaintext.
+type PIICipher struct {
+	active  string // ID of the key values are sealed with
+	keys    map[string]cipher.AEAD
+	hashKey []byte
+}
+
+// NewPIICipher reads the keys in PII_ENCRYPTION_KEYS, a list of
+// <key ID>:<base64 32-byte key>, and PII_HASH_KEY. It returns nil, and
+// personal data is stored in plaintext, unless PII_ENCRYPTION_KEYS is set.
+func NewPIICipher(settings Settings) (*PIICipher, error) {
+	entries := splitList(settings("PII_ENCRYPTION_KEYS"))
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	c := &PIICipher{keys: make(map[string]cipher.AEAD, len(entries))}
+	for _, entry := range entries {
+		id, encoded, _ := strings.Cut(entry, ":")
+		if !piiKeyIDPattern.MatchString(id) {
+			return nil, fmt.Errorf("invalid PII_ENCRYPTION_KEYS: key ID %q must be 1-32 letters, digits, - or _", id)
+		}
+		if _, ok := c.keys[id]; ok {
+			return nil, fmt.Errorf("invalid PII_ENCRYPTION_KEYS: key ID %q is listed twice", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("invalid PII_ENCRYPTION_KEYS: key %q must be 32 bytes, base64 encoded", id)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		if c.active == "" {
+			c.active = id
+		}
+		c.keys[id] = aead
+	}
+
+	c.hashKey = []byte(settings("PII_HASH_KEY"))
+	if len(c.hashKey) < minPIIHashKeyLen {
+		return nil, fmt.Errorf("PII_HASH_KEY must be at least %d bytes when PII_ENCRYPTION_KEYS is set", minPIIHashKeyLen)
+	}
+	return c, nil
+}
+
+// Seal encrypts value with the active key
+func (c *PIICipher) Seal(value string) (string, error) {
+	if c == nil {
+		return value, nil
+	}
+	aead := c.keys[c.active]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(value)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(value), []byte(c.active))
+	return piiPrefix + c.active + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a value sealed with any of the keys. Values stored in
+// plaintext are returned as they are.
+func (c *PIICipher) Open(value string) (string, error) {
+	rest, ok := strings.CutPrefix(value, piiPrefix)
+	if !ok {
+		return value, nil
+	}
+	if c == nil {
+		return "", errors.New("personal data is encrypted but PII_ENCRYPTION_KEYS is not set")
+	}
+	id, encoded, _ := strings.Cut(rest, ":")
+	aead, ok := c.keys[id]
+	if !ok {
+		return "", fmt.Errorf("personal data is encrypted with unknown key %q", id)
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("malformed encrypted value under key %q", id)
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(id))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt value under key %q: %w", id, err)
+	}
+	return string(plaintext), nil
+}
+
+// Current reports whether value is sealed with the active key, so does
+// not need to be rotated
+func (c *PIICipher) Current(value string) bool {
+	if c == nil {
+		return !strings.HasPrefix(value, piiPrefix)
+	}
+	return strings.HasPrefix(value, piiPrefix+c.active+":")
+}
+
+// EmailHash is the HMAC-SHA256 of an email address, which finds a user
+// without decrypting every address. It is nil without a cipher.
+func (c *PIICipher) EmailHash(email string) []byte {
+	if c == nil {
+		return nil
+	}
+	mac := hmac.New(sha256.New, c.hashKey)
+	mac.Write([]byte(email))
+	return mac.Sum(nil)
+}
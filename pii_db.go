@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// sealedUser is a user's personal data as it is stored
+type sealedUser struct {
+	Email     string
+	Name      string
+	EmailHash []byte
+}
+
+// sealUser encrypts a user's email address and name for storing
+func (db *DB) sealUser(email, name string) (sealedUser, error) {
+	var (
+		sealed sealedUser
+		err    error
+	)
+	if sealed.Email, err = db.pii.Seal(email); err != nil {
+		return sealedUser{}, err
+	}
+	if sealed.Name, err = db.pii.Seal(name); err != nil {
+		return sealedUser{}, err
+	}
+	sealed.EmailHash = db.pii.EmailHash(email)
+	return sealed, nil
+}
+
+// openUsers decrypts the email addresses and names of users read from the
+// database
+func (db *DB) openUsers(users ...*User) error {
+	for _, user := range users {
+		email, err := db.pii.Open(user.Email)
+		if err != nil {
+			return err
+		}
+		name, err := db.pii.Open(user.Name)
+		if err != nil {
+			return err
+		}
+		user.Email, user.Name = email, name
+	}
+	return nil
+}
+
+// openUserList decrypts a list of users read from the database
+func (db *DB) openUserList(users []User) error {
+	for i := range users {
+		if err := db.openUsers(&users[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// piiRotateBatch is how many users RotatePII re-encrypts per transaction
+const piiRotateBatch = 500
+
+// RotatePII encrypts the email address and name of every user, deleted or
+// not, that is stored in plaintext or under a key other than the active
+// one, and fills in missing email hashes. It returns how many users were
+// rotated. Run after turning encryption on and after adding a key; the old
+// key can be removed once it finishes.
+func (db *DB) RotatePII(ctx context.Context) (int, error) {
+	if db.pii == nil {
+		return 0, errors.New("PII_ENCRYPTION_KEYS is not set")
+	}
+	current := piiPrefix + db.pii.active + ":"
+
+	rotated := 0
+	for {
+		n := 0
+		err := db.transact(ctx, func(tx *sqlx.Tx) error {
+			var users []User
+			err := tx.SelectContext(ctx, &users, `
+				SELECT id, email, name FROM users
+				WHERE email_hash IS NULL OR NOT starts_with(email, $1) OR NOT starts_with(name, $1)
+				ORDER BY id LIMIT $2
+				FOR UPDATE
+			`, current, piiRotateBatch)
+			if err != nil {
+				return err
+			}
+			for i := range users {
+				user := &users[i]
+				if err := db.openUsers(user); err != nil {
+					return fmt.Errorf("user %s: %w", user.ID, err)
+				}
+				sealed, err := db.sealUser(user.Email, user.Name)
+				if err != nil {
+					return err
+				}
+				_, err = tx.ExecContext(ctx, `
+					UPDATE users SET email = $2, name = $3, email_hash = $4 WHERE id = $1
+				`, user.ID, sealed.Email, sealed.Name, sealed.EmailHash)
+				if isEmailTaken(err) {
+					return fmt.Errorf("user %s: %w", user.ID, ErrEmailTaken)
+				}
+				if err != nil {
+					return err
+				}
+			}
+			n = len(users)
+			return nil
+		})
+		if err != nil {
+			return rotated, err
+		}
+		rotated += n
+		if n < piiRotateBatch {
+			return rotated, nil
+		}
+	}
+}
+
+// sortUsersByEmail orders users by email address, which the database
+// cannot do once the addresses are encrypted
+func sortUsersByEmail(users []User) {
+	slices.SortFunc(users, func(a, b User) int { return strings.Compare(a.Email, b.Email) })
+}
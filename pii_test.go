@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testPIIHashKey = "0123456789abcdef0123456789abcdef"
+
+func testPIIKey(b byte) string {
+	return base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string(b), 32)))
+}
+
+func TestNewPIICipher(t *testing.T) {
+	settings := func(values map[string]string) Settings {
+		return func(key string) string { return values[key] }
+	}
+
+	pii, err := NewPIICipher(settings(nil))
+	require.NoError(t, err)
+	require.Nil(t, pii, "without keys personal data is stored in plaintext")
+
+	for name, values := range map[string]map[string]string{
+		"Key not base64":  {"PII_ENCRYPTION_KEYS": "k1:not-base64", "PII_HASH_KEY": testPIIHashKey},
+		"Key too short":   {"PII_ENCRYPTION_KEYS": "k1:" + base64.StdEncoding.EncodeToString([]byte("short")), "PII_HASH_KEY": testPIIHashKey},
+		"Key ID missing":  {"PII_ENCRYPTION_KEYS": testPIIKey('a'), "PII_HASH_KEY": testPIIHashKey},
+		"Key ID repeated": {"PII_ENCRYPTION_KEYS": "k1:" + testPIIKey('a') + ",k1:" + testPIIKey('b'), "PII_HASH_KEY": testPIIHashKey},
+		"Hash key short":  {"PII_ENCRYPTION_KEYS": "k1:" + testPIIKey('a'), "PII_HASH_KEY": "short"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewPIICipher(settings(values))
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestPIICipher(t *testing.T) {
+	newCipher := func(keys string) *PIICipher {
+		pii, err := NewPIICipher(func(key string) string {
+			return map[string]string{"PII_ENCRYPTION_KEYS": keys, "PII_HASH_KEY": testPIIHashKey}[key]
+		})
+		require.NoError(t, err)
+		return pii
+	}
+	old := newCipher("k1:" + testPIIKey('a'))
+	rotated := newCipher("k2:" + testPIIKey('b') + ",k1:" + testPIIKey('a'))
+
+	sealed, err := old.Seal("alice@example.com")
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(sealed, "pii:k1:"))
+	require.NotContains(t, sealed, "alice")
+	again, err := old.Seal("alice@example.com")
+	require.NoError(t, err)
+	require.NotEqual(t, sealed, again, "every value has its own nonce")
+
+	t.Run("Rotated keys open older values", func(t *testing.T) {
+		opened, err := rotated.Open(sealed)
+		require.NoError(t, err)
+		require.Equal(t, "alice@example.com", opened)
+		require.False(t, rotated.Current(sealed))
+
+		resealed, err := rotated.Seal(opened)
+		require.NoError(t, err)
+		require.True(t, rotated.Current(resealed))
+
+		_, err = old.Open(resealed)
+		require.Error(t, err, "a removed key cannot open newer values")
+	})
+
+	t.Run("Plaintext is read as it is", func(t *testing.T) {
+		opened, err := rotated.Open("bob@example.com")
+		require.NoError(t, err)
+		require.Equal(t, "bob@example.com", opened)
+		require.False(t, rotated.Current("bob@example.com"))
+
+		var none *PIICipher
+		opened, err = none.Seal("bob@example.com")
+		require.NoError(t, err)
+		require.Equal(t, "bob@example.com", opened)
+		_, err = none.Open(sealed)
+		require.Error(t, err, "encrypted values need the keys")
+	})
+
+	t.Run("Tampered values are rejected", func(t *testing.T) {
+		tampered := sealed[:len(sealed)-2] + "AA"
+		if tampered == sealed {
+			tampered = sealed[:len(sealed)-2] + "BB"
+		}
+		_, err := old.Open(tampered)
+		require.Error(t, err)
+
+		// A value cannot be passed off as sealed by another key
+		_, err = rotated.Open(strings.Replace(sealed, "pii:k1:", "pii:k2:", 1))
+		require.Error(t, err)
+	})
+
+	t.Run("Email hashes survive key rotation", func(t *testing.T) {
+		require.Equal(t, old.EmailHash("alice@example.com"), rotated.EmailHash("alice@example.com"))
+		require.NotEqual(t, old.EmailHash("alice@example.com"), old.EmailHash("bob@example.com"))
+		require.Len(t, old.EmailHash("alice@example.com"), 32)
+
+		var none *PIICipher
+		require.Nil(t, none.EmailHash("alice@example.com"))
+	})
+}
+
+func TestPIIEncryption(t *testing.T) {
+	t.Setenv("PII_ENCRYPTION_KEYS", "k1:"+testPIIKey('a'))
+	t.Setenv("PII_HASH_KEY", testPIIHashKey)
+	testdb := setupTestDB(t)
+	defer testdb.teardown(t)
+	db := testdb.DB
+	ctx := context.Background()
+
+	org, err := db.CreateOrganization(ctx, "Acme", "owner@acme.test", "Owner")
+	require.NoError(t, err)
+	member, err := db.AddUserToOrganization(ctx, org.ID, "member@acme.test", "Member")
+	require.NoError(t, err)
+	require.Equal(t, "member@acme.test", member.Email)
+
+	var stored struct {
+		Email     string `db:"email"`
+		Name      string `db:"name"`
+		EmailHash []byte `db:"email_hash"`
+	}
+	require.NoError(t, db.GetContext(ctx, &stored, `SELECT email, name, email_hash FROM users WHERE id = $1`, member.ID))
+	require.True(t, strings.HasPrefix(stored.Email, "pii:k1:"))
+	require.True(t, strings.HasPrefix(stored.Name, "pii:k1:"))
+	require.NotEmpty(t, stored.EmailHash)
+
+	found, err := db.GetUserByEmail(ctx, "member@acme.test")
+	require.NoError(t, err)
+	require.Equal(t, member.ID, found.ID)
+	require.Equal(t, "Member", found.Name)
+
+	_, err = db.AddUserToOrganization(ctx, org.ID, "member@acme.test", "Again")
+	require.ErrorIs(t, err, ErrEmailTaken)
+
+	users, total, err := db.SearchUsers(ctx, "member@acme.test", false, 10, 0)
+	require.NoError(t, err)
+	require.Equal(t, 1, total)
+	require.Equal(t, "member@acme.test", users[0].Email)
+
+	t.Run("Rotation", func(t *testing.T) {
+		// A user stored before encryption was turned on
+		_, err := db.ExecContext(ctx, `
+			INSERT INTO users (id, email, name, organization_id, role, permissions)
+			VALUES (gen_random_uuid(), 'legacy@acme.test', 'Legacy', $1, 'sub_account', '{}')
+		`, org.ID)
+		require.NoError(t, err)
+
+		config, err := NewDBConfig(func(key string) string {
+			return map[string]string{
+				"PII_ENCRYPTION_KEYS": "k2:" + testPIIKey('b') + ",k1:" + testPIIKey('a'),
+				"PII_HASH_KEY":        testPIIHashKey,
+			}[key]
+		})
+		require.NoError(t, err)
+		db.pii = config.PII
+
+		rotated, err := db.RotatePII(ctx)
+		require.NoError(t, err)
+		require.Equal(t, 3, rotated)
+		rotated, err = db.RotatePII(ctx)
+		require.NoError(t, err)
+		require.Zero(t, rotated)
+
+		users, err := db.GetOrganizationUsers(ctx, org.ID)
+		require.NoError(t, err)
+		require.Len(t, users, 3)
+		require.Equal(t, []string{"legacy@acme.test", "member@acme.test", "owner@acme.test"},
+			[]string{users[0].Email, users[1].Email, users[2].Email})
+		for _, user := range users {
+			require.NoError(t, db.GetContext(ctx, &stored, `SELECT email, name, email_hash FROM users WHERE id = $1`, user.ID))
+			require.True(t, strings.HasPrefix(stored.Email, "pii:k2:"))
+		}
+	})
+}
@@ -0,0 +1,76 @@
+package main
+
+// Profile is what an ENVIRONMENT implies for the settings a deployment
+// leaves unset. Each can still be set explicitly.
+type Profile struct {
+	// SecureCookies marks the CSRF cookie Secure, so browsers only send it
+	// over HTTPS (CSRF_COOKIE_SECURE)
+	SecureCookies bool
+	// AllowedOrigins are the CORS origins used unless ALLOWED_ORIGINS is set
+	AllowedOrigins []string
+	// OAuthRedirectURL is where Google returns users after login unless
+	// GOOGLE_REDIRECT_URL is set
+	OAuthRedirectURL string
+	// LogFormat is json or text (LOG_FORMAT)
+	LogFormat string
+	// Required are the settings the server will not start without.
+	// Without them there are fallbacks fit only for a workstation: a local
+	// database, random CSRF and audit keys, and no Google login.
+	Required []string
+}
+
+// Environments the server runs in, selected with ENVIRONMENT
+const (
+	EnvironmentDevelopment = "development"
+	EnvironmentTest        = "test"
+	EnvironmentStaging     = "staging"
+	EnvironmentProduction  = "production"
+)
+
+var localOrigins = []string{"http://localhost:*", "http://127.0.0.1:*"}
+
+// deployedRequired are the settings a shared deployment needs
+var deployedRequired = []string{
+	"DATABASE_URL",
+	"GOOGLE_CLIENT_ID",
+	"GOOGLE_CLIENT_SECRET",
+	"GOOGLE_REDIRECT_URL",
+	"CSRF_AUTH_KEY",
+	"AUDIT_SIGNING_KEY",
+}
+
+// profiles are the environments. Development and test run over plain HTTP
+// on localhost; staging and production are deployed behind HTTPS and have
+// no cross-origin access unless it is configured.
+var profiles = map[string]*Profile{
+	EnvironmentDevelopment: {
+		AllowedOrigins:   localOrigins,
+		OAuthRedirectURL: "http://localhost:8080/auth/callback/google",
+		LogFormat:        LogFormatText,
+	},
+	EnvironmentTest: {
+		AllowedOrigins:   localOrigins,
+		OAuthRedirectURL: "http://localhost:8080/auth/callback/google",
+		LogFormat:        LogFormatJSON,
+	},
+	EnvironmentStaging: {
+		SecureCookies: true,
+		LogFormat:     LogFormatJSON,
+		Required:      deployedRequired,
+	},
+	EnvironmentProduction: {
+		SecureCookies: true,
+		LogFormat:     LogFormatJSON,
+		Required:      deployedRequired,
+	},
+}
+
+// profileFor returns the profile of the ENVIRONMENT in settings, which is
+// development if unset. An unknown environment, which NewConfig rejects,
+// gets the production profile.
+func profileFor(settings Settings) *Profile {
+	if profile, ok := profiles[settings.get("ENVIRONMENT", EnvironmentDevelopment)]; ok {
+		return profile
+	}
+	return profiles[EnvironmentProduction]
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProfiles(t *testing.T) {
+	settings := func(environ ...string) Settings {
+		values := map[string]string{}
+		for i := 0; i < len(environ); i += 2 {
+			values[environ[i]] = environ[i+1]
+		}
+		return func(key string) string { return values[key] }
+	}
+
+	t.Run("Development works over HTTP on localhost", func(t *testing.T) {
+		config, err := NewConfig(settings())
+		require.NoError(t, err)
+		require.Equal(t, EnvironmentDevelopment, config.Environment)
+		require.False(t, config.CSRF.Secure)
+		require.Equal(t, []string{"http://localhost:*", "http://127.0.0.1:*"}, config.CORS.AllowedOrigins)
+		require.Equal(t, "http://localhost:8080/auth/callback/google", config.OAuth.config.RedirectURL)
+		require.Equal(t, LogFormatText, config.Log.Format)
+	})
+
+	t.Run("Staging is deployed like production", func(t *testing.T) {
+		_, err := NewConfig(settings("ENVIRONMENT", EnvironmentStaging))
+		require.ErrorContains(t, err, "GOOGLE_REDIRECT_URL is required in staging")
+
+		s := settings(
+			"ENVIRONMENT", EnvironmentStaging,
+			"DATABASE_URL", "memory://",
+			"GOOGLE_CLIENT_ID", "client",
+			"GOOGLE_CLIENT_SECRET", "secret",
+			"GOOGLE_REDIRECT_URL", "https://staging.example.com/auth/callback/google",
+			"CSRF_AUTH_KEY", "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=",
+			"AUDIT_SIGNING_KEY", "audit-key",
+		)
+		config, err := NewConfig(s)
+		require.NoError(t, err)
+		require.True(t, config.CSRF.Secure)
+		require.Empty(t, config.CORS.AllowedOrigins)
+		require.Equal(t, "https://staging.example.com/auth/callback/google", config.OAuth.config.RedirectURL)
+		require.Equal(t, LogFormatJSON, config.Log.Format)
+	})
+
+	t.Run("Settings override the profile", func(t *testing.T) {
+		config, err := NewConfig(settings(
+			"CSRF_COOKIE_SECURE", "true",
+			"LOG_FORMAT", LogFormatJSON,
+			"ALLOWED_ORIGINS", "https://app.example.com",
+			"GOOGLE_REDIRECT_URL", "http://localhost:3000/auth/callback/google",
+		))
+		require.NoError(t, err)
+		require.True(t, config.CSRF.Secure)
+		require.Equal(t, LogFormatJSON, config.Log.Format)
+		require.Equal(t, []string{"https://app.example.com"}, config.CORS.AllowedOrigins)
+		require.Equal(t, "http://localhost:3000/auth/callback/google", config.OAuth.config.RedirectURL)
+	})
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RetentionPolicy says how long each kind of record is kept once it is no
+// longer in use. A zero window keeps records forever.
+type RetentionPolicy struct {
+	RefreshTokens   time.Duration // after the token expires
+	RevokedSessions time.Duration // after the token is revoked
+	AuditEvents     time.Duration // after the event is recorded
+	DeletedRecords  time.Duration // after an organization or user is soft-deleted
+}
+
+// With returns the policy with the windows set in override replacing its own
+func (p RetentionPolicy) With(override *RetentionOverride) RetentionPolicy {
+	if override.RefreshTokens != nil {
+		p.RefreshTokens = time.Duration(*override.RefreshTokens)
+	}
+	if override.RevokedSessions != nil {
+		p.RevokedSessions = time.Duration(*override.RevokedSessions)
+	}
+	if override.AuditEvents != nil {
+		p.AuditEvents = time.Duration(*override.AuditEvents)
+	}
+	if override.DeletedRecords != nil {
+		p.DeletedRecords = time.Duration(*override.DeletedRecords)
+	}
+	return p
+}
+
+// cutoffs turns the policy's windows into the times before which records
+// are removed at now
+func (p RetentionPolicy) cutoffs(now time.Time) RetentionCutoffs {
+	cutoff := func(window time.Duration) time.Time {
+		if window == 0 {
+			return time.Time{}
+		}
+		return now.Add(-window)
+	}
+	return RetentionCutoffs{
+		RefreshTokens:   cutoff(p.RefreshTokens),
+		RevokedSessions: cutoff(p.RevokedSessions),
+		AuditEvents:     cutoff(p.AuditEvents),
+		DeletedRecords:  cutoff(p.DeletedRecords),
+	}
+}
+
+// RetentionCutoffs are the times before which each kind of record is
+// removed. A zero time removes nothing of that kind. The store applies all
+// but AuditEvents, which the audit log enforces itself.
+type RetentionCutoffs struct {
+	RefreshTokens   time.Time
+	RevokedSessions time.Time
+	AuditEvents     time.Time
+	DeletedRecords  time.Time
+}
+
+// RetentionScope selects the organizations a purge applies to: only OrgID
+// when it is set, and otherwise every organization not listed in Except
+type RetentionScope struct {
+	OrgID  uuid.UUID
+	Except []uuid.UUID
+}
+
+// PurgeCounts reports how many records a purge removed
+type PurgeCounts struct {
+	RefreshTokens   int64
+	RevokedSessions int64
+	Organizations   int64
+	Users           int64
+	AuditEvents     int64
+}
+
+func (c *PurgeCounts) add(other *PurgeCounts) {
+	c.RefreshTokens += other.RefreshTokens
+	c.RevokedSessions += other.RevokedSessions
+	c.Organizations += other.Organizations
+	c.Users += other.Users
+	c.AuditEvents += other.AuditEvents
+}
+
+// Duration is a time.Duration written in JSON as a string such as "720h"
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"720h\"")
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// RetentionOverride replaces some of the default retention windows for one
+// organization, typically to meet an enterprise customer's compliance
+// requirements. Windows left unset follow the default, and "0s" keeps
+// records forever.
+type RetentionOverride struct {
+	RefreshTokens   *Duration `json:"refresh_tokens,omitempty"`
+	RevokedSessions *Duration `json:"revoked_sessions,omitempty"`
+	AuditEvents     *Duration `json:"audit_events,omitempty"`
+	DeletedRecords  *Duration `json:"deleted_records,omitempty"`
+}
+
+// Value implements the driver.Valuer interface for RetentionOverride
+func (o RetentionOverride) Value() (driver.Value, error) {
+	return json.Marshal(o)
+}
+
+// Scan implements the sql.Scanner interface for RetentionOverride
+func (o *RetentionOverride) Scan(value interface{}) error {
+	if value == nil {
+		*o = RetentionOverride{}
+		return nil
+	}
+	return json.Unmarshal(value.([]byte), o)
+}
+
+// empty reports whether the override leaves every window at the default
+func (o *RetentionOverride) empty() bool {
+	return o.RefreshTokens == nil && o.RevokedSessions == nil && o.AuditEvents == nil && o.DeletedRecords == nil
+}
+
+// PurgeConfig holds the default retention windows and how often they are enforced
+type PurgeConfig struct {
+	Retention RetentionPolicy
+	Interval  time.Duration // how often the purge job runs
+}
+
+// NewPurgeConfig creates a purge configuration from settings
+func NewPurgeConfig(settings Settings) *PurgeConfig {
+	config := &PurgeConfig{}
+	for _, v := range []struct {
+		key, fallback string
+		dest          *time.Duration
+	}{
+		{"TOKEN_RETENTION", "168h", &config.Retention.RefreshTokens},
+		{"REVOKED_SESSION_RETENTION", "720h", &config.Retention.RevokedSessions},
+		{"AUDIT_RETENTION", "0", &config.Retention.AuditEvents},
+		{"DELETED_RETENTION", "720h", &config.Retention.DeletedRecords},
+	} {
+		d, err := time.ParseDuration(settings.get(v.key, v.fallback))
+		if err != nil || d < 0 {
+			d, _ = time.ParseDuration(v.fallback)
+		}
+		*v.dest = d
+	}
+
+	interval, err := time.ParseDuration(settings.get("PURGE_INTERVAL", "1h"))
+	if err != nil || interval <= 0 {
+		interval = time.Hour
+	}
+	config.Interval = interval
+
+	return config
+}
+
+// Purger enforces data retention, permanently removing expired and revoked
+// refresh tokens, old audit events, and soft-deleted organizations and users
+// once they are past their retention window. Organizations can override the
+// default windows.
+type Purger struct {
+	store    Store
+	audit    *AuditLog // nil or without a database when there is no audit table
+	defaults RetentionPolicy
+	logger   *slog.Logger
+	now      func() time.Time
+}
+
+func NewPurger(store Store, audit *AuditLog, config *PurgeConfig, logger *slog.Logger) *Purger {
+	p := &Purger{
+		store:    store,
+		audit:    audit,
+		defaults: config.Retention,
+		logger:   logger,
+		now:      time.Now,
+	}
+	go p.periodicPurge(config.Interval)
+	return p
+}
+
+func (p *Purger) periodicPurge(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		if err := p.Purge(ctx); err != nil {
+			p.logger.Error("failed to purge records past retention", "error", err)
+		}
+		cancel()
+	}
+}
+
+// Purge removes everything that is past its retention window
+func (p *Purger) Purge(ctx context.Context) error {
+	overrides, err := p.store.GetRetentionOverrides(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Organizations with an override are purged one by one with their own
+	// policy, and everything else in one go with the defaults
+	type scopedPolicy struct {
+		scope  RetentionScope
+		policy RetentionPolicy
+	}
+	defaults := scopedPolicy{policy: p.defaults, scope: RetentionScope{Except: []uuid.UUID{}}}
+	scoped := make([]scopedPolicy, 0, len(overrides)+1)
+	for orgID, override := range overrides {
+		defaults.scope.Except = append(defaults.scope.Except, orgID)
+		scoped = append(scoped, scopedPolicy{
+			scope:  RetentionScope{OrgID: orgID},
+			policy: p.defaults.With(&override),
+		})
+	}
+	scoped = append(scoped, defaults)
+
+	now := p.now()
+	counts := &PurgeCounts{}
+	keepAuditFrom := int64(math.MaxInt64)
+	for _, s := range scoped {
+		cutoffs := s.policy.cutoffs(now)
+		purged, err := p.store.PurgeRetained(ctx, s.scope, cutoffs)
+		if err != nil {
+			return err
+		}
+		counts.add(purged)
+
+		if p.audit.enabled() {
+			first, err := p.audit.firstRetainedEvent(ctx, s.scope, cutoffs.AuditEvents)
+			if err != nil {
+				return err
+			}
+			if first > 0 {
+				keepAuditFrom = min(keepAuditFrom, first)
+			}
+		}
+	}
+
+	if p.audit.enabled() {
+		if counts.AuditEvents, err = p.audit.Truncate(ctx, keepAuditFrom); err != nil {
+			return err
+		}
+	}
+
+	if *counts != (PurgeCounts{}) {
+		p.logger.InfoContext(ctx, "purged records past retention",
+			"refresh_tokens", counts.RefreshTokens,
+			"revoked_sessions", counts.RevokedSessions,
+			"organizations", counts.Organizations,
+			"users", counts.Users,
+			"audit_events", counts.AuditEvents,
+		)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewPurgeConfig(t *testing.T) {
+	config := NewPurgeConfig(os.Getenv)
+	require.Equal(t, RetentionPolicy{
+		RefreshTokens:   7 * 24 * time.Hour,
+		RevokedSessions: 30 * 24 * time.Hour,
+		DeletedRecords:  30 * 24 * time.Hour,
+	}, config.Retention)
+	require.Equal(t, time.Hour, config.Interval)
+
+	t.Setenv("DELETED_RETENTION", "168h")
+	t.Setenv("AUDIT_RETENTION", "8760h")
+	t.Setenv("TOKEN_RETENTION", "-1h")
+	t.Setenv("PURGE_INTERVAL", "-1m")
+	config = NewPurgeConfig(os.Getenv)
+	require.Equal(t, 7*24*time.Hour, config.Retention.DeletedRecords)
+	require.Equal(t, 365*24*time.Hour, config.Retention.AuditEvents)
+	require.Equal(t, 7*24*time.Hour, config.Retention.RefreshTokens, "invalid values fall back to the default")
+	require.Equal(t, time.Hour, config.Interval, "invalid values fall back to the default")
+}
+
+func TestRetentionOverride(t *testing.T) {
+	var override RetentionOverride
+	require.NoError(t, json.Unmarshal([]byte(`{"audit_events":"17520h","deleted_records":"0s"}`), &override))
+	require.Error(t, json.Unmarshal([]byte(`{"audit_events":3600}`), &override))
+
+	data, err := json.Marshal(override)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"audit_events":"17520h0m0s","deleted_records":"0s"}`, string(data))
+
+	policy := RetentionPolicy{RefreshTokens: time.Hour, AuditEvents: time.Hour, DeletedRecords: time.Hour}.With(&override)
+	require.Equal(t, RetentionPolicy{RefreshTokens: time.Hour, AuditEvents: 2 * 365 * 24 * time.Hour}, policy)
+
+	now := time.Now()
+	cutoffs := policy.cutoffs(now)
+	require.Equal(t, now.Add(-time.Hour), cutoffs.RefreshTokens)
+	require.True(t, cutoffs.DeletedRecords.IsZero(), "a zero window keeps records forever")
+}
+
+func TestPurger(t *testing.T) {
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	config := &PurgeConfig{
+		Retention: RetentionPolicy{
+			RefreshTokens:   24 * time.Hour,
+			RevokedSessions: 24 * time.Hour,
+			DeletedRecords:  24 * time.Hour,
+		},
+		Interval: time.Hour,
+	}
+
+	t.Run("Purges deleted records past retention", func(t *testing.T) {
+		store := NewMemoryStore()
+		org, err := store.CreateOrganization(ctx, "Acme", "owner@acme.test", "Owner")
+		require.NoError(t, err)
+		require.NoError(t, store.DeleteOrganization(ctx, org.ID))
+
+		purger := NewPurger(store, nil, config, logger)
+		require.NoError(t, purger.Purge(ctx))
+		orgs, _, err := store.ListOrganizations(ctx, OrganizationFilter{IncludeDeleted: true}, 10, 0)
+		require.NoError(t, err)
+		require.Len(t, orgs, 1, "deleted within the retention window")
+
+		purger.now = func() time.Time { return time.Now().Add(25 * time.Hour) }
+		require.NoError(t, purger.Purge(ctx))
+		orgs, _, err = store.ListOrganizations(ctx, OrganizationFilter{IncludeDeleted: true}, 10, 0)
+		require.NoError(t, err)
+		require.Empty(t, orgs)
+	})
+
+	t.Run("Purges expired and revoked refresh tokens", func(t *testing.T) {
+		store := NewMemoryStore()
+		org, err := store.CreateOrganization(ctx, "Acme", "owner@acme.test", "Owner")
+		require.NoError(t, err)
+		revoked, err := store.CreateRefreshToken(ctx, org.OwnerID, DefaultSessionTerms)
+		require.NoError(t, err)
+		_, err = store.CreateRefreshToken(ctx, org.OwnerID, DefaultSessionTerms)
+		require.NoError(t, err)
+		require.Len(t, store.refreshTokens, 2, "replaced tokens are revoked, not deleted")
+
+		purger := NewPurger(store, nil, config, logger)
+		purger.now = func() time.Time { return time.Now().Add(25 * time.Hour) }
+		require.NoError(t, purger.Purge(ctx))
+		require.Len(t, store.refreshTokens, 1)
+		_, err = store.ValidateRefreshToken(ctx, revoked)
+		require.ErrorIs(t, err, ErrRefreshTokenNotFound)
+
+		// The live token expires after 7 days and is kept for a day after that
+		purger.now = func() time.Time { return time.Now().Add(7*24*time.Hour + 23*time.Hour) }
+		require.NoError(t, purger.Purge(ctx))
+		require.Len(t, store.refreshTokens, 1)
+
+		purger.now = func() time.Time { return time.Now().Add(8*24*time.Hour + time.Hour) }
+		require.NoError(t, purger.Purge(ctx))
+		require.Empty(t, store.refreshTokens)
+	})
+
+	t.Run("Organizations can override the defaults", func(t *testing.T) {
+		store := NewMemoryStore()
+		kept, err := store.CreateOrganization(ctx, "Regulated", "owner@regulated.test", "Owner")
+		require.NoError(t, err)
+		purged, err := store.CreateOrganization(ctx, "Acme", "owner@acme.test", "Owner")
+		require.NoError(t, err)
+
+		year := Duration(365 * 24 * time.Hour)
+		_, err = store.UpdateRetentionOverride(ctx, kept.ID, kept.Version, &RetentionOverride{DeletedRecords: &year})
+		require.NoError(t, err)
+		require.NoError(t, store.DeleteOrganization(ctx, kept.ID))
+		require.NoError(t, store.DeleteOrganization(ctx, purged.ID))
+
+		purger := NewPurger(store, nil, config, logger)
+		purger.now = func() time.Time { return time.Now().Add(48 * time.Hour) }
+		require.NoError(t, purger.Purge(ctx))
+
+		orgs, _, err := store.ListOrganizations(ctx, OrganizationFilter{IncludeDeleted: true}, 10, 0)
+		require.NoError(t, err)
+		require.Len(t, orgs, 1)
+		require.Equal(t, kept.ID, orgs[0].ID)
+
+		purger.now = func() time.Time { return time.Now().Add(366 * 24 * time.Hour) }
+		require.NoError(t, purger.Purge(ctx))
+		orgs, _, err = store.ListOrganizations(ctx, OrganizationFilter{IncludeDeleted: true}, 10, 0)
+		require.NoError(t, err)
+		require.Empty(t, orgs)
+	})
+}
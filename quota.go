@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Quotas are soft: nothing is refused when one runs out. Once an
+// organization reaches a threshold of its seats or of its monthly API
+// calls, its owner is told, once per threshold, by email and through the
+// organization's webhooks and chat channel, and its API responses carry an
+// X-Quota-Warning header until usage falls back below the first threshold.
+
+// Quotas organizations are warned about
+const (
+	QuotaSeats    = "seats"
+	QuotaAPICalls = "api_calls" // per calendar month, UTC
+)
+
+// QuotaThresholds are the percentages of a quota at which owners are
+// warned, lowest first
+var QuotaThresholds = []int{80, 100}
+
+// QuotaWarningHeader carries each quota the caller's organization has used
+// at least the first threshold of, as "quota; used=N; limit=N"
+const QuotaWarningHeader = "X-Quota-Warning"
+
+// quotaRefresh is how long usage read from the store is added to locally
+// before it is read again
+const quotaRefresh = time.Minute
+
+// quotaEmailJob is the kind of job that emails an owner about a threshold
+const quotaEmailJob = "quota.email"
+
+// quotaDescription names a quota in notifications
+func quotaDescription(quota string) string {
+	if quota == QuotaAPICalls {
+		return "API calls this month"
+	}
+	return quota
+}
+
+// quotaLevel returns the highest threshold used reaches of limit, or 0.
+// Organizations without a limit have no level.
+func quotaLevel(used, limit int64) int {
+	level := 0
+	if limit <= 0 {
+		return level
+	}
+	for _, threshold := range QuotaThresholds {
+		if used*100 >= limit*int64(threshold) {
+			level = threshold
+		}
+	}
+	return level
+}
+
+// QuotaConfig sets each subscription tier's monthly API calls
+type QuotaConfig struct {
+	// APICalls maps tiers to their monthly API calls; tiers left out have
+	// no API quota
+	APICalls map[string]int64
+}
+
+// NewQuotaConfig creates a quota configuration from settings. API_QUOTAS
+// is a list such as "free=10000,pro=1000000".
+func NewQuotaConfig(settings Settings) (*QuotaConfig, error) {
+	config := &QuotaConfig{APICalls: map[string]int64{}}
+	for _, entry := range splitList(settings("API_QUOTAS")) {
+		tier, limit, ok := strings.Cut(entry, "=")
+		tier = strings.TrimSpace(tier)
+		n, err := strconv.ParseInt(strings.TrimSpace(limit), 10, 64)
+		if !ok || tier == "" || err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid API_QUOTAS entry %q: expected tier=calls", entry)
+		}
+		config.APICalls[tier] = n
+	}
+	return config, nil
+}
+
+// QuotaUsage is how much of one quota an organization has used
+type QuotaUsage struct {
+	Quota string
+	Used  int64
+	Limit int64
+	Level int // the highest threshold reached, or 0
+}
+
+// quotaState is what a monitor knows of one organization's usage
+type quotaState struct {
+	checkedAt time.Time
+	period    string // the month APICalls counts
+	seats     QuotaUsage
+	apiCalls  QuotaUsage
+	// recorded is the level last recorded in the store for each quota, so
+	// it is only written when the level changes. Copies share it, under mu.
+	recorded map[string]int
+}
+
+// QuotaMonitor evaluates organizations' usage against their quotas as
+// their API calls come in. Usage is read from the store at most once per
+// quotaRefresh, and calls this instance serves in between are added to it.
+// The highest threshold reached is kept in the store, so an organization
+// crossing one is reported once, by whichever instance notices first.
+type QuotaMonitor struct {
+	store  Store
+	jobs   *JobRunner
+	usage  *UsageRecorder
+	mailer Mailer // nil emails nobody
+	config *QuotaConfig
+	record func(ctx context.Context, event *AuditEvent)
+	logger *slog.Logger
+	now    func() time.Time
+
+	mu   sync.Mutex
+	orgs map[uuid.UUID]*quotaState
+}
+
+func NewQuotaMonitor(store Store, jobs *JobRunner, usage *UsageRecorder, mailer Mailer, config *QuotaConfig, record func(context.Context, *AuditEvent), logger *slog.Logger) *QuotaMonitor {
+	q := &QuotaMonitor{
+		store:  store,
+		jobs:   jobs,
+		usage:  usage,
+		mailer: mailer,
+		config: config,
+		record: record,
+		logger: logger,
+		now:    time.Now,
+		orgs:   make(map[uuid.UUID]*quotaState),
+	}
+	jobs.Register(quotaEmailJob, q.email)
+	return q
+}
+
+// Check counts an API call for orgID and returns the quotas it has used at
+// least the first threshold of, reporting the thresholds newly crossed
+func (q *QuotaMonitor) Check(ctx context.Context, orgID uuid.UUID) []QuotaUsage {
+	state, err := q.state(ctx, orgID)
+	if err != nil {
+		q.logger.ErrorContext(ctx, "failed to read quota usage", "organization_id", orgID, "error", err)
+		return nil
+	}
+
+	var warnings []QuotaUsage
+	for _, usage := range []QuotaUsage{state.seats, state.apiCalls} {
+		if usage.Limit <= 0 {
+			continue
+		}
+		q.mu.Lock()
+		level, ok := state.recorded[usage.Quota]
+		q.mu.Unlock()
+		if !ok || level != usage.Level {
+			if err := q.report(ctx, orgID, state.period, usage); err != nil {
+				q.logger.ErrorContext(ctx, "failed to report quota threshold", "organization_id", orgID, "quota", usage.Quota, "error", err)
+			} else {
+				q.mu.Lock()
+				state.recorded[usage.Quota] = usage.Level
+				q.mu.Unlock()
+			}
+		}
+		if usage.Level > 0 {
+			warnings = append(warnings, usage)
+		}
+	}
+	return warnings
+}
+
+// state returns orgID's usage, counting a call, and reading it from the
+// store again once it is older than quotaRefresh or a new month begins
+func (q *QuotaMonitor) state(ctx context.Context, orgID uuid.UUID) (*quotaState, error) {
+	now := q.now().UTC()
+	period := now.Format("2006-01")
+
+	q.mu.Lock()
+	state, ok := q.orgs[orgID]
+	if ok && now.Sub(state.checkedAt) < quotaRefresh && state.period == period {
+		state.apiCalls.Used++
+		state.apiCalls.Level = quotaLevel(state.apiCalls.Used, state.apiCalls.Limit)
+		copied := *state
+		q.mu.Unlock()
+		return &copied, nil
+	}
+	q.mu.Unlock()
+
+	// The store is read unlocked, so a slow read holds up only this
+	// organization's calls
+	seats, err := q.store.GetSeatUsage(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	calls, err := q.store.GetAPIUsage(ctx, orgID, monthStart)
+	if err != nil {
+		return nil, err
+	}
+	// The call being checked is already pending
+	calls += q.usage.Pending(orgID)
+
+	seatUsage := QuotaUsage{Quota: QuotaSeats, Used: int64(seats.SeatsUsed), Limit: int64(seats.PaidSeats)}
+	seatUsage.Level = quotaLevel(seatUsage.Used, seatUsage.Limit)
+	apiUsage := QuotaUsage{Quota: QuotaAPICalls, Used: calls, Limit: q.config.APICalls[seats.SubscriptionTier]}
+	apiUsage.Level = quotaLevel(apiUsage.Used, apiUsage.Limit)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	recorded := map[string]int{}
+	if previous, ok := q.orgs[orgID]; ok && previous.period == period {
+		recorded = previous.recorded
+	}
+	state = &quotaState{checkedAt: now, period: period, seats: seatUsage, apiCalls: apiUsage, recorded: recorded}
+	q.orgs[orgID] = state
+	copied := *state
+	return &copied, nil
+}
+
+// report records usage's level, and reports it if it is higher than the
+// level last recorded for the quota this period. Levels that drop are
+// recorded too, so the thresholds are reported again when next crossed.
+func (q *QuotaMonitor) report(ctx context.Context, orgID uuid.UUID, period string, usage QuotaUsage) error {
+	if usage.Quota == QuotaSeats {
+		// Seats do not renew, so their levels hold until usage drops
+		period = ""
+	}
+	previous, err := q.store.SetQuotaAlertLevel(ctx, orgID, usage.Quota, period, usage.Level)
+	if err != nil || usage.Level <= previous {
+		return err
+	}
+
+	metadata := AuditMetadata{
+		"quota":     usage.Quota,
+		"threshold": strconv.Itoa(usage.Level),
+		"used":      strconv.FormatInt(usage.Used, 10),
+		"limit":     strconv.FormatInt(usage.Limit, 10),
+	}
+	q.record(ctx, &AuditEvent{
+		OrganizationID: &orgID,
+		Action:         "organization.quota_threshold",
+		TargetID:       orgID.String(),
+		Metadata:       metadata,
+	})
+	if q.mailer == nil {
+		return nil
+	}
+	payload := quotaEmailPayload{OrganizationID: orgID, QuotaUsage: usage}
+	_, err = q.jobs.Enqueue(ctx, quotaEmailJob, payload, time.Time{})
+	return err
+}
+
+// quotaActions are the audited actions that change an organization's seats
+// or plan
+var quotaActions = map[string]bool{
+	"user.added":                true,
+	"user.deleted":              true,
+	"user.role_changed":         true,
+	"organization.tier_changed": true,
+}
+
+// Observe drops what is known of the usage of an organization whose seats
+// or plan an audited action changed, so its thresholds are noticed on its
+// next call rather than after quotaRefresh
+func (q *QuotaMonitor) Observe(event *AuditEvent) {
+	if q == nil || event.OrganizationID == nil || !quotaActions[event.Action] {
+		return
+	}
+	q.mu.Lock()
+	delete(q.orgs, *event.OrganizationID)
+	q.mu.Unlock()
+}
+
+type quotaEmailPayload struct {
+	OrganizationID uuid.UUID `json:"organization_id"`
+	QuotaUsage
+}
+
+// email is the job handler telling an organization's owner it has reached
+// a threshold
+func (q *QuotaMonitor) email(ctx context.Context, raw json.RawMessage) error {
+	var payload quotaEmailPayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return err
+	}
+	org, err := q.store.GetOrganization(ctx, payload.OrganizationID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	owner, err := q.store.GetUser(ctx, org.OwnerID)
+	if err != nil {
+		return err
+	}
+	branding, err := organizationBranding(ctx, q.store, org.ID)
+	if err != nil {
+		return err
+	}
+
+	what := quotaDescription(payload.Quota)
+	subject := fmt.Sprintf("%s has used %d%% of its %s", org.Name, payload.Level, what)
+	body := fmt.Sprintf("Hi %s,\n\n%s has used %d of its %d %s. Nothing is blocked, but you may want to review its plan.\n",
+		owner.Name, org.Name, payload.Used, payload.Limit, what)
+	return q.mailer.Send(ctx, &Mail{To: owner.Email, Subject: subject, Body: body, Branding: branding})
+}
+
+// Handler adds the quota warnings of the authenticated user's organization
+// to the response. A nil monitor warns about nothing.
+func (q *QuotaMonitor) Handler(next http.Handler) http.Handler {
+	if q == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, err := GetUserFromContext(r.Context()); err == nil {
+			for _, usage := range q.Check(r.Context(), user.OrganizationID) {
+				w.Header().Add(QuotaWarningHeader, fmt.Sprintf("%s; used=%d; limit=%d", usage.Quota, usage.Used, usage.Limit))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// GetAPIUsage sums an organization's API calls from the day of since
+func (db *DB) GetAPIUsage(ctx context.Context, orgID uuid.UUID, since time.Time) (int64, error) {
+	var calls int64
+	err := db.tenantRead(ctx, orgID, func(q sqlx.QueryerContext) error {
+		return q.QueryRowxContext(ctx, `
+			SELECT COALESCE(SUM(calls), 0) FROM organization_api_usage
+			WHERE organization_id = $1 AND day >= $2
+		`, orgID, since.UTC().Format("2006-01-02")).Scan(&calls)
+	})
+	return calls, err
+}
+
+// SetQuotaAlertLevel records the threshold an organization has reached of
+// a quota in period, returning the one recorded before for that period
+func (db *DB) SetQuotaAlertLevel(ctx context.Context, orgID uuid.UUID, quota, period string, level int) (int, error) {
+	var previous int
+	err := db.tenantTx(ctx, orgID, func(tx *sqlx.Tx) error {
+		// Lock the quota's row, creating it first if need be, so instances
+		// crossing a threshold at once see each other's level
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO organization_quota_alerts (organization_id, quota, period, level)
+			VALUES ($1, $2, $3, 0)
+			ON CONFLICT (organization_id, quota) DO NOTHING
+		`, orgID, quota, period)
+		if err != nil {
+			return err
+		}
+		var recordedPeriod string
+		err = tx.QueryRowxContext(ctx, `
+			SELECT period, level FROM organization_quota_alerts
+			WHERE organization_id = $1 AND quota = $2
+			FOR UPDATE
+		`, orgID, quota).Scan(&recordedPeriod, &previous)
+		if err != nil {
+			return err
+		}
+		if recordedPeriod != period {
+			previous = 0
+		}
+		_, err = tx.ExecContext(ctx, `
+			UPDATE organization_quota_alerts SET period = $3, level = $4, updated_at = NOW()
+			WHERE organization_id = $1 AND quota = $2
+		`, orgID, quota, period, level)
+		return err
+	})
+	return previous, err
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuotaLevel(t *testing.T) {
+	for _, tt := range []struct {
+		used, limit int64
+		level       int
+	}{
+		{0, 10, 0},
+		{7, 10, 0},
+		{8, 10, 80},
+		{10, 10, 100},
+		{12, 10, 100},
+		{5, 0, 0},
+	} {
+		require.Equal(t, tt.level, quotaLevel(tt.used, tt.limit), "%d of %d", tt.used, tt.limit)
+	}
+}
+
+func TestNewQuotaConfig(t *testing.T) {
+	config, err := NewQuotaConfig(func(string) string { return "free=10000, pro=1000000" })
+	require.NoError(t, err)
+	require.Equal(t, map[string]int64{"free": 10000, "pro": 1000000}, config.APICalls)
+
+	config, err = NewQuotaConfig(func(string) string { return "" })
+	require.NoError(t, err)
+	require.Empty(t, config.APICalls)
+
+	_, err = NewQuotaConfig(func(string) string { return "free=0" })
+	require.ErrorContains(t, err, "API_QUOTAS")
+}
+
+func TestQuotaWarnings(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	org, err := store.CreateOrganization(ctx, "Acme", "owner@acme.test", "Owner")
+	require.NoError(t, err)
+	for _, email := range []string{"a@acme.test", "b@acme.test", "c@acme.test"} {
+		_, err := store.AddUserToOrganization(ctx, org.ID, email, "Member")
+		require.NoError(t, err)
+	}
+
+	t.Setenv("API_QUOTAS", "free=10")
+	srv, err := NewServer(store, newTestConfig(t))
+	require.NoError(t, err)
+	var events []*AuditEvent
+	srv.quotas.record = func(ctx context.Context, event *AuditEvent) {
+		events = append(events, event)
+		srv.recordEvent(ctx, event)
+	}
+	mailer := &recordingMailer{}
+	srv.quotas.mailer = mailer
+
+	owner, err := store.GetUser(ctx, org.OwnerID)
+	require.NoError(t, err)
+	token, err := srv.tokenManager.GenerateToken(owner)
+	require.NoError(t, err)
+	call := func() []string {
+		req := httptest.NewRequest(http.MethodGet, "/organizations/"+org.ID.String(), nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		return w.Header().Values(QuotaWarningHeader)
+	}
+
+	for range 7 {
+		require.Empty(t, call())
+	}
+	require.Empty(t, events)
+	require.Equal(t, []string{"api_calls; used=8; limit=10"}, call())
+	require.Len(t, events, 1)
+	require.Equal(t, "organization.quota_threshold", events[0].Action)
+	require.Equal(t, AuditMetadata{"quota": QuotaAPICalls, "threshold": "80", "used": "8", "limit": "10"}, events[0].Metadata)
+	call()
+	require.Len(t, events, 1, "each threshold is reported once")
+
+	t.Run("Seat changes are noticed at once", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/organizations/"+org.ID.String()+"/users",
+			strings.NewReader(`{"email":"d@acme.test","name":"D"}`))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		addCSRFToken(t, srv, req)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		warnings := call()
+		require.Contains(t, warnings, "seats; used=4; limit=5")
+		require.Contains(t, warnings, "api_calls; used=11; limit=10")
+		require.Len(t, events, 3)
+	})
+
+	t.Run("Other instances do not report again", func(t *testing.T) {
+		other := &QuotaMonitor{store: store, usage: &UsageRecorder{},
+			config: srv.quotas.config, record: srv.quotas.record, logger: slog.Default(), now: time.Now,
+			orgs: make(map[uuid.UUID]*quotaState)}
+		require.NoError(t, srv.usage.Flush(ctx))
+		require.Len(t, other.Check(ctx, org.ID), 2)
+		require.Len(t, events, 3)
+	})
+
+	t.Run("The owner is emailed", func(t *testing.T) {
+		require.Eventually(t, func() bool {
+			mailer.mu.Lock()
+			defer mailer.mu.Unlock()
+			return len(mailer.sent) == 3
+		}, 5*time.Second, 10*time.Millisecond)
+		mailer.mu.Lock()
+		defer mailer.mu.Unlock()
+		slices.SortFunc(mailer.sent, func(a, b Mail) int { return strings.Compare(a.Subject, b.Subject) })
+		for _, mail := range mailer.sent {
+			require.Equal(t, "owner@acme.test", mail.To)
+		}
+		require.Equal(t, "Acme has used 100% of its API calls this month", mailer.sent[0].Subject)
+		require.Equal(t, "Acme has used 80% of its seats", mailer.sent[2].Subject)
+	})
+
+	t.Run("Deleted organizations are not emailed", func(t *testing.T) {
+		orphaned := &QuotaMonitor{store: orphanedStore{store}, mailer: mailer, config: srv.quotas.config, logger: slog.Default(), now: time.Now}
+		payload, err := json.Marshal(quotaEmailPayload{OrganizationID: org.ID, QuotaUsage: QuotaUsage{Quota: QuotaSeats, Used: 5, Limit: 5, Level: 100}})
+		require.NoError(t, err)
+		require.NoError(t, orphaned.email(ctx, payload))
+		require.Len(t, mailer.sent, 3)
+	})
+}
+
+func TestQuotaStore(t *testing.T) {
+	testdb := setupTestDB(t)
+	defer testdb.teardown(t)
+
+	ctx := context.Background()
+	org, err := testdb.DB.CreateOrganization(ctx, "Quota Org", "owner@quota.test", "Owner")
+	require.NoError(t, err)
+
+	day := time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, testdb.DB.IncrementAPIUsage(ctx, org.ID, day.AddDate(0, -1, 0), 5))
+	require.NoError(t, testdb.DB.IncrementAPIUsage(ctx, org.ID, day, 7))
+	calls, err := testdb.DB.GetAPIUsage(ctx, org.ID, day.AddDate(0, 0, -15))
+	require.NoError(t, err)
+	require.Equal(t, int64(7), calls)
+
+	previous, err := testdb.DB.SetQuotaAlertLevel(ctx, org.ID, QuotaAPICalls, "2026-10", 80)
+	require.NoError(t, err)
+	require.Equal(t, 0, previous)
+	previous, err = testdb.DB.SetQuotaAlertLevel(ctx, org.ID, QuotaAPICalls, "2026-10", 100)
+	require.NoError(t, err)
+	require.Equal(t, 80, previous)
+	previous, err = testdb.DB.SetQuotaAlertLevel(ctx, org.ID, QuotaAPICalls, "2026-11", 0)
+	require.NoError(t, err)
+	require.Equal(t, 0, previous, "levels start over each period")
+}
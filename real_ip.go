@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+const (
+	clientIPContextKey       contextKey = "client_ip"
+	clientLocationContextKey contextKey = "client_location"
+)
+
+// maxLocationLength bounds the location a proxy may report
+const maxLocationLength = 100
+
+// ProxyConfig lists the load balancers and reverse proxies whose
+// X-Forwarded-For and X-Real-IP headers are believed
+type ProxyConfig struct {
+	TrustedProxies []netip.Prefix
+	// LocationHeader is a header in which trusted proxies give the client's
+	// approximate location, such as CF-IPCountry or
+	// CloudFront-Viewer-Country; empty if none do
+	LocationHeader string
+}
+
+// NewProxyConfig reads TRUSTED_PROXIES, a comma-separated list of CIDRs or
+// single addresses, and CLIENT_LOCATION_HEADER
+func NewProxyConfig(settings Settings) (*ProxyConfig, error) {
+	proxies, err := parsePrefixes(settings("TRUSTED_PROXIES"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid trusted proxy %w", err)
+	}
+	return &ProxyConfig{
+		TrustedProxies: proxies,
+		LocationHeader: http.CanonicalHeaderKey(strings.TrimSpace(settings("CLIENT_LOCATION_HEADER"))),
+	}, nil
+}
+
+// parsePrefixes parses a comma-separated list of CIDRs or single addresses
+func parsePrefixes(value string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range splitList(value) {
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(entry)
+			if addrErr != nil {
+				return nil, fmt.Errorf("%q: expected a CIDR or IP address", entry)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// containsAddr reports whether addr belongs to any of prefixes
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// trusted reports whether addr belongs to a trusted proxy
+func (c *ProxyConfig) trusted(addr netip.Addr) bool {
+	return containsAddr(c.TrustedProxies, addr)
+}
+
+// clientIP determines the address of the client that made r. Forwarding
+// headers are only read when the connection comes from a trusted proxy, and
+// X-Forwarded-For is walked from the right so a client cannot spoof its
+// address by sending the header itself.
+func (c *ProxyConfig) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peer, err := netip.ParseAddr(host)
+	if err != nil || !c.trusted(peer) {
+		return host
+	}
+
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		client := peer
+		for i := len(hops) - 1; i >= 0; i-- {
+			addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+			if err != nil {
+				// Anything left of a malformed hop cannot be trusted
+				break
+			}
+			client = addr
+			if !c.trusted(addr) {
+				break
+			}
+		}
+		return client.Unmap().String()
+	}
+
+	if realIP, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+		return realIP.Unmap().String()
+	}
+	return host
+}
+
+// clientLocation returns the location a trusted proxy gave for the client
+// that made r, or "" if there is none
+func (c *ProxyConfig) clientLocation(r *http.Request) string {
+	if c.LocationHeader == "" {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if peer, err := netip.ParseAddr(host); err != nil || !c.trusted(peer) {
+		return ""
+	}
+	return truncate(strings.TrimSpace(r.Header.Get(c.LocationHeader)), maxLocationLength)
+}
+
+// GetClientIPFromContext returns the client IP, or "" outside a request
+func GetClientIPFromContext(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPContextKey).(string)
+	return ip
+}
+
+// GetClientLocationFromContext returns the client's approximate location,
+// or "" if no trusted proxy gave one
+func GetClientLocationFromContext(ctx context.Context) string {
+	location, _ := ctx.Value(clientLocationContextKey).(string)
+	return location
+}
+
+// RealIP stores the client's IP address, and its location if a trusted
+// proxy gives one, in the request context for logging, rate limiting and
+// audit events
+func RealIP(config *ProxyConfig) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), clientIPContextKey, config.clientIP(r))
+			if location := config.clientLocation(r); location != "" {
+				ctx = context.WithValue(ctx, clientLocationContextKey, location)
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
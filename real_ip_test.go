@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRealIP(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8, 192.168.1.1, fd00::/8")
+	config, err := NewProxyConfig(os.Getenv)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		realIP       string
+		expectedIP   string
+	}{
+		{"Direct connection", "203.0.113.7:5000", nil, "", "203.0.113.7"},
+		{"Untrusted peer cannot spoof", "203.0.113.7:5000", []string{"198.51.100.1"}, "198.51.100.1", "203.0.113.7"},
+		{"Trusted proxy", "10.1.2.3:5000", []string{"198.51.100.1"}, "", "198.51.100.1"},
+		{"Skips trusted hops", "10.1.2.3:5000", []string{"198.51.100.1, 192.168.1.1, 10.9.9.9"}, "", "198.51.100.1"},
+		{"Client-supplied prefix is ignored", "10.1.2.3:5000", []string{"1.1.1.1, 198.51.100.1"}, "", "198.51.100.1"},
+		{"Multiple headers", "10.1.2.3:5000", []string{"198.51.100.1", "10.9.9.9"}, "", "198.51.100.1"},
+		{"Malformed hop stops the walk", "10.1.2.3:5000", []string{"198.51.100.1, garbage"}, "", "10.1.2.3"},
+		{"X-Real-IP", "10.1.2.3:5000", nil, "198.51.100.1", "198.51.100.1"},
+		{"IPv6 proxy", "[fd00::1]:5000", []string{"2001:db8::1"}, "", "2001:db8::1"},
+		{"All hops trusted", "10.1.2.3:5000", []string{"10.4.4.4"}, "", "10.4.4.4"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var clientIP string
+			handler := RealIP(config)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				clientIP = GetClientIPFromContext(r.Context())
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwardedFor {
+				req.Header.Add("X-Forwarded-For", value)
+			}
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			require.Equal(t, tt.expectedIP, clientIP)
+		})
+	}
+
+	t.Run("Invalid configuration", func(t *testing.T) {
+		t.Setenv("TRUSTED_PROXIES", "not-an-ip")
+		_, err := NewProxyConfig(os.Getenv)
+		require.Error(t, err)
+	})
+
+	t.Run("Location from trusted proxies only", func(t *testing.T) {
+		t.Setenv("CLIENT_LOCATION_HEADER", "cf-ipcountry")
+		config, err := NewProxyConfig(os.Getenv)
+		require.NoError(t, err)
+
+		location := func(remoteAddr string) string {
+			var location string
+			handler := RealIP(config)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				location = GetClientLocationFromContext(r.Context())
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = remoteAddr
+			req.Header.Set("CF-IPCountry", "NZ")
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			return location
+		}
+		require.Equal(t, "NZ", location("10.1.2.3:5000"))
+		require.Empty(t, location("203.0.113.7:5000"))
+	})
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// MaxRecordedBodyBytes is how much of each request and response body a
+// recording keeps
+const MaxRecordedBodyBytes = 64 << 10
+
+// Recording is a captured request and the response to it. Headers, query
+// parameters and JSON fields whose names are redacted in logs are redacted
+// here too, and bodies other than JSON are left out.
+type Recording struct {
+	RequestID       string        `json:"request_id"`
+	Time            time.Time     `json:"time"`
+	Method          string        `json:"method"`
+	Path            string        `json:"path"`
+	Query           string        `json:"query,omitempty"`
+	RequestHeaders  http.Header   `json:"request_headers"`
+	RequestBody     string        `json:"request_body,omitempty"`
+	Status          int           `json:"status"`
+	ResponseHeaders http.Header   `json:"response_headers"`
+	ResponseBody    string        `json:"response_body,omitempty"`
+	Duration        time.Duration `json:"duration"`
+}
+
+// Recorder captures a sample of requests, along with any whose request ID it
+// was told to watch for, keeping the most recent in a ring buffer. A nil
+// *Recorder records nothing.
+type Recorder struct {
+	sampleRate float64
+	requestIDs map[string]bool
+	redact     *regexp.Regexp // nil redacts nothing
+
+	mu         sync.Mutex
+	recordings []Recording // a ring buffer; next is the oldest once full
+	next       int
+	full       bool
+}
+
+// NewRecorder creates a recorder from config, redacting the values of
+// redactKeys, or returns nil if recording is off
+func NewRecorder(config *DebugConfig, redactKeys []string) *Recorder {
+	if !config.Record {
+		return nil
+	}
+	rec := &Recorder{
+		sampleRate: config.RecordSampleRate,
+		requestIDs: make(map[string]bool),
+		redact:     redactPattern(redactKeys),
+		recordings: make([]Recording, config.RecordBufferSize),
+	}
+	for _, id := range config.RecordRequestIDs {
+		rec.requestIDs[id] = true
+	}
+	return rec
+}
+
+// Handler records the requests chosen for recording as they are served. It
+// must run after RequestID, and after compression so bodies are recorded
+// as the handlers wrote them.
+func (rec *Recorder) Handler(next http.Handler) http.Handler {
+	if rec == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := GetRequestIDFromContext(r.Context())
+		if !rec.requestIDs[id] && (rec.sampleRate == 0 || rand.Float64() >= rec.sampleRate) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		recording := Recording{
+			RequestID:      id,
+			Time:           time.Now().UTC(),
+			Method:         r.Method,
+			Path:           r.URL.Path,
+			Query:          rec.redactQuery(r.URL.Query()),
+			RequestHeaders: rec.redactHeader(r.Header),
+		}
+		if r.Body != nil && r.Body != http.NoBody {
+			// Read the start of the body and hand the handler all of it
+			start, _ := io.ReadAll(io.LimitReader(r.Body, MaxRecordedBodyBytes+1))
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(start), r.Body), r.Body}
+			recording.RequestBody = rec.redactBody(r.Header.Get("Content-Type"), start)
+		}
+
+		rw := &recordingWriter{ResponseWriter: w}
+		defer func() {
+			recording.Duration = time.Since(recording.Time)
+			recording.Status = rw.status
+			if recording.Status == 0 {
+				recording.Status = http.StatusOK
+			}
+			recording.ResponseHeaders = rec.redactHeader(w.Header())
+			recording.ResponseBody = rec.redactBody(w.Header().Get("Content-Type"), rw.body.Bytes())
+			rec.add(recording)
+		}()
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// add stores recording, replacing the oldest if the buffer is full
+func (rec *Recorder) add(recording Recording) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.recordings[rec.next] = recording
+	rec.next = (rec.next + 1) % len(rec.recordings)
+	if rec.next == 0 {
+		rec.full = true
+	}
+}
+
+// Recordings returns the recordings, newest first, of every request or only
+// of the request with requestID
+func (rec *Recorder) Recordings(requestID string) []Recording {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	n := rec.next
+	if rec.full {
+		n = len(rec.recordings)
+	}
+	recordings := []Recording{}
+	for i := 1; i <= n; i++ {
+		recording := rec.recordings[(rec.next-i+len(rec.recordings))%len(rec.recordings)]
+		if requestID == "" || recording.RequestID == requestID {
+			recordings = append(recordings, recording)
+		}
+	}
+	return recordings
+}
+
+// Clear discards every recording
+func (rec *Recorder) Clear() {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	clear(rec.recordings)
+	rec.next, rec.full = 0, false
+}
+
+func (rec *Recorder) redacted(key string) bool {
+	return rec.redact != nil && rec.redact.MatchString(key)
+}
+
+func (rec *Recorder) redactHeader(header http.Header) http.Header {
+	redacted := header.Clone()
+	for key := range redacted {
+		if rec.redacted(key) {
+			redacted[key] = []string{"[REDACTED]"}
+		}
+	}
+	return redacted
+}
+
+func (rec *Recorder) redactQuery(query url.Values) string {
+	for key := range query {
+		if rec.redacted(key) {
+			query[key] = []string{"[REDACTED]"}
+		}
+	}
+	return query.Encode()
+}
+
+// redactBody returns a JSON body with the redacted fields replaced, at any
+// depth, or a note of what was left out
+func (rec *Recorder) redactBody(contentType string, body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType != "application/json" && mediaType != "application/problem+json" {
+		return fmt.Sprintf("[%d bytes of %q omitted]", len(body), contentType)
+	}
+	if len(body) > MaxRecordedBodyBytes {
+		return fmt.Sprintf("[JSON body over %d bytes omitted]", MaxRecordedBodyBytes)
+	}
+
+	// Streams of JSON values, as some responses are, are redacted one by one
+	var redacted bytes.Buffer
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	for {
+		var v any
+		if err := decoder.Decode(&v); err == io.EOF {
+			break
+		} else if err != nil {
+			return "[malformed JSON body omitted]"
+		}
+		encoded, _ := json.Marshal(rec.redactValue(v))
+		redacted.Write(encoded)
+		redacted.WriteByte('\n')
+	}
+	return redacted.String()
+}
+
+func (rec *Recorder) redactValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			if rec.redacted(key) {
+				v[key] = "[REDACTED]"
+			} else {
+				v[key] = rec.redactValue(value)
+			}
+		}
+	case []any:
+		for i, value := range v {
+			v[i] = rec.redactValue(value)
+		}
+	}
+	return v
+}
+
+// recordingWriter passes a response through, keeping its status and the
+// start of its body
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rw *recordingWriter) WriteHeader(status int) {
+	if rw.status == 0 && status >= 200 {
+		rw.status = status
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *recordingWriter) Write(p []byte) (int, error) {
+	if room := MaxRecordedBodyBytes + 1 - rw.body.Len(); room > 0 {
+		rw.body.Write(p[:min(len(p), room)])
+	}
+	return rw.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (rw *recordingWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// handleAdminListRecordings returns the recorded requests, newest first,
+// optionally only those with the request_id given
+func (s *Server) handleAdminListRecordings(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.recorder.Recordings(r.URL.Query().Get("request_id")))
+}
+
+// handleAdminClearRecordings discards the recorded requests
+func (s *Server) handleAdminClearRecordings(w http.ResponseWriter, r *http.Request) {
+	s.recorder.Clear()
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewDebugConfigRecording(t *testing.T) {
+	config, err := NewDebugConfig(os.Getenv)
+	require.NoError(t, err)
+	require.False(t, config.Record)
+	require.Nil(t, NewRecorder(config, nil))
+
+	for key, value := range map[string]string{
+		"DEBUG_RECORD_SAMPLE_RATE": "1.5",
+		"DEBUG_RECORD_BUFFER_SIZE": "0",
+		"DEBUG_RECORD_REQUEST_IDS": "has space",
+	} {
+		_, err := NewDebugConfig(func(k string) string {
+			return map[string]string{key: value}[k]
+		})
+		require.Error(t, err, key)
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	record := func(config *DebugConfig) *Recorder {
+		config.Record = true
+		return NewRecorder(config, []string{"token", "authorization", "secret"})
+	}
+	handler := func(rec *Recorder) http.Handler {
+		return RequestID(rec.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"echo":` + string(body) + `,"access_token":"abc"}`))
+		})))
+	}
+	serve := func(h http.Handler, id, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/things?state=1&token=xyz", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer secret")
+		req.Header.Set(RequestIDHeader, id)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("Watched requests are recorded and redacted", func(t *testing.T) {
+		rec := record(&DebugConfig{RecordRequestIDs: []string{"watched"}, RecordBufferSize: 10})
+		h := handler(rec)
+
+		w := serve(h, "watched", `{"name":"Acme","client_secret":"s3cret","nested":[{"refresh_token":"r"}]}`)
+		require.Equal(t, http.StatusCreated, w.Code)
+		require.Contains(t, w.Body.String(), `"client_secret":"s3cret"`, "the handler sees the whole body")
+		serve(h, "other", `{}`)
+
+		recordings := rec.Recordings("")
+		require.Len(t, recordings, 1, "nothing is sampled")
+		recording := recordings[0]
+		require.Equal(t, "watched", recording.RequestID)
+		require.Equal(t, http.StatusCreated, recording.Status)
+		require.Equal(t, "state=1&token=%5BREDACTED%5D", recording.Query)
+		require.Equal(t, "[REDACTED]", recording.RequestHeaders.Get("Authorization"))
+		require.JSONEq(t, `{"name":"Acme","client_secret":"[REDACTED]","nested":[{"refresh_token":"[REDACTED]"}]}`, recording.RequestBody)
+		require.Contains(t, recording.ResponseBody, `"access_token":"[REDACTED]"`)
+		require.NotContains(t, recording.ResponseBody, "s3cret")
+	})
+
+	t.Run("Sampled requests fill a ring buffer", func(t *testing.T) {
+		rec := record(&DebugConfig{RecordSampleRate: 1, RecordBufferSize: 2})
+		h := handler(rec)
+		for _, id := range []string{"one", "two", "three"} {
+			serve(h, id, `{}`)
+		}
+
+		var ids []string
+		for _, recording := range rec.Recordings("") {
+			ids = append(ids, recording.RequestID)
+		}
+		require.Equal(t, []string{"three", "two"}, ids, "newest first, oldest dropped")
+		require.Len(t, rec.Recordings("two"), 1)
+
+		rec.Clear()
+		require.Empty(t, rec.Recordings(""))
+	})
+
+	t.Run("Bodies other than JSON are left out", func(t *testing.T) {
+		rec := record(&DebugConfig{RecordSampleRate: 1, RecordBufferSize: 1})
+		req := httptest.NewRequest(http.MethodPost, "/oidc/token", strings.NewReader("code=abc&code_verifier=xyz"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec.Handler(http.NotFoundHandler()).ServeHTTP(httptest.NewRecorder(), req)
+
+		recording := rec.Recordings("")[0]
+		require.NotContains(t, recording.RequestBody, "abc")
+		require.Contains(t, recording.RequestBody, "omitted")
+		require.Equal(t, http.StatusNotFound, recording.Status)
+	})
+}
+
+func TestRecordingsEndpoint(t *testing.T) {
+	t.Setenv("DEBUG_RECORD", "true")
+	t.Setenv("DEBUG_RECORD_REQUEST_IDS", "customer-issue")
+	srv, err := NewServer(NewMemoryStore(), newTestConfig(t))
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/version", nil)
+	req.Header.Set(RequestIDHeader, "customer-issue")
+	srv.ServeHTTP(httptest.NewRecorder(), req)
+
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/debug/recordings", nil))
+	require.Equal(t, http.StatusUnauthorized, w.Code, "platform admins only")
+
+	recordings := srv.recorder.Recordings("customer-issue")
+	require.Len(t, recordings, 1)
+	var build BuildInfo
+	require.NoError(t, json.Unmarshal([]byte(recordings[0].ResponseBody), &build))
+	require.Equal(t, currentBuild, build)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// NewRedisClient creates a client for REDIS_URL, or returns nil if it
+// is unset. With Redis configured, OAuth state and cached users are shared
+// by every server instance instead of living in each process.
+func NewRedisClient(settings Settings) (*redis.Client, error) {
+	url := settings("REDIS_URL")
+	if url == "" {
+		return nil, nil
+	}
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
+	}
+	return redis.NewClient(opts), nil
+}
+
+// RedisStateStore keeps OAuth state parameters in Redis so the callback can
+// land on a different instance than the one that started the login
+type RedisStateStore struct {
+	client *redis.Client
+}
+
+func NewRedisStateStore(client *redis.Client) *RedisStateStore {
+	return &RedisStateStore{client: client}
+}
+
+func redisStateKey(state string) string {
+	return "oauth_state:" + state
+}
+
+func (s *RedisStateStore) StoreState(ctx context.Context, state string, expiration time.Duration) error {
+	return s.client.Set(ctx, redisStateKey(state), 1, expiration).Err()
+}
+
+// ValidateAndDeleteState consumes state; Redis expires it after its lifetime
+// and DEL reports whether it was still there, so each state is used once
+func (s *RedisStateStore) ValidateAndDeleteState(ctx context.Context, state string) (bool, error) {
+	deleted, err := s.client.Del(ctx, redisStateKey(state)).Result()
+	if err != nil {
+		return false, err
+	}
+	return deleted == 1, nil
+}
+
+// RedisUserCache is a UserCache shared between server instances. Redis
+// errors are treated as cache misses so an outage falls back to the store.
+type RedisUserCache struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+func NewRedisUserCache(client *redis.Client, ttl time.Duration) *RedisUserCache {
+	return &RedisUserCache{client: client, ttl: ttl}
+}
+
+func redisUserKey(id uuid.UUID) string {
+	return "user:" + id.String()
+}
+
+func (c *RedisUserCache) Get(ctx context.Context, id uuid.UUID) (*User, bool) {
+	data, err := c.client.Get(ctx, redisUserKey(id)).Bytes()
+	if err != nil {
+		return nil, false
+	}
+	user := &User{}
+	if err := json.Unmarshal(data, user); err != nil {
+		return nil, false
+	}
+	return user, true
+}
+
+func (c *RedisUserCache) Set(ctx context.Context, user *User) {
+	data, err := json.Marshal(user)
+	if err != nil {
+		return
+	}
+	c.client.Set(ctx, redisUserKey(user.ID), data, c.ttl)
+}
+
+func (c *RedisUserCache) Delete(ctx context.Context, id uuid.UUID) {
+	c.client.Del(ctx, redisUserKey(id))
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func setupRedis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	t.Setenv("REDIS_URL", "redis://"+mr.Addr()+"/0")
+	client, err := NewRedisClient(os.Getenv)
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+	return mr, client
+}
+
+func TestRedis(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("REDIS_URL", func(t *testing.T) {
+		t.Setenv("REDIS_URL", "")
+		client, err := NewRedisClient(os.Getenv)
+		require.NoError(t, err)
+		require.Nil(t, client)
+
+		t.Setenv("REDIS_URL", "http://localhost:6379")
+		_, err = NewRedisClient(os.Getenv)
+		require.Error(t, err)
+	})
+
+	t.Run("OAuth state is single use and expires", func(t *testing.T) {
+		mr, client := setupRedis(t)
+		states := NewRedisStateStore(client)
+
+		require.NoError(t, states.StoreState(ctx, "first", time.Minute))
+		valid, err := states.ValidateAndDeleteState(ctx, "first")
+		require.NoError(t, err)
+		require.True(t, valid)
+
+		valid, err = states.ValidateAndDeleteState(ctx, "first")
+		require.NoError(t, err)
+		require.False(t, valid, "state was already consumed")
+
+		require.NoError(t, states.StoreState(ctx, "second", time.Minute))
+		mr.FastForward(2 * time.Minute)
+		valid, err = states.ValidateAndDeleteState(ctx, "second")
+		require.NoError(t, err)
+		require.False(t, valid, "state expired")
+	})
+
+	t.Run("User cache", func(t *testing.T) {
+		mr, client := setupRedis(t)
+		cache := NewRedisUserCache(client, time.Minute)
+
+		user := &User{
+			ID:             uuid.New(),
+			Email:          "cached@example.com",
+			OrganizationID: uuid.New(),
+			Role:           "owner",
+			Permissions:    Permissions{"admin": true},
+		}
+		cache.Set(ctx, user)
+
+		got, ok := cache.Get(ctx, user.ID)
+		require.True(t, ok)
+		require.Equal(t, user.Email, got.Email)
+		require.True(t, got.HasPermission("admin"))
+
+		cache.Delete(ctx, user.ID)
+		_, ok = cache.Get(ctx, user.ID)
+		require.False(t, ok)
+
+		cache.Set(ctx, user)
+		mr.Close()
+		_, ok = cache.Get(ctx, user.ID)
+		require.False(t, ok, "an outage is a cache miss")
+	})
+
+	t.Run("Health check", func(t *testing.T) {
+		mr, client := setupRedis(t)
+		health := NewHealthChecker(currentBuild, nil, nil)
+		health.RegisterCheck("redis", RedisHealthCheck(client), Critical, time.Second)
+
+		require.Equal(t, StatusHealthy, health.CheckReadiness(ctx).Status)
+
+		mr.Close()
+		response := health.CheckReadiness(ctx)
+		require.Equal(t, StatusUnhealthy, response.Status)
+		require.Len(t, response.Checks, 1)
+		check := response.Checks[0]
+		require.Equal(t, "redis", check.Name)
+		require.Equal(t, StatusUnhealthy, check.Status)
+		require.NotEmpty(t, check.Error)
+	})
+}
@@ -4,12 +4,14 @@ import (
 	"context"
 	"crypto/rand"
 	"crypto/sha256"
+	"database/sql"
 	"encoding/base64"
 	"encoding/hex"
 	"errors"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
 )
 
 var (
@@ -22,7 +24,16 @@ type RefreshToken struct {
 	UserID    uuid.UUID `db:"user_id" json:"user_id"`
 	TokenHash string    `db:"token_hash" json:"-"`
 	ExpiresAt time.Time `db:"expires_at" json:"expires_at"`
-	CreatedAt time.Time `db:"created_at" json:"created_at"`
+	// SessionExpiresAt is when the session ends however often it is
+	// refreshed, if its organization limits that
+	SessionExpiresAt *time.Time `db:"session_expires_at" json:"session_expires_at,omitempty"`
+	CreatedAt        time.Time  `db:"created_at" json:"created_at"`
+	RevokedAt        *time.Time `db:"revoked_at" json:"revoked_at,omitempty"`
+}
+
+// live reports whether the token can still be exchanged at now
+func (rt *RefreshToken) live(now time.Time) bool {
+	return rt.RevokedAt == nil && rt.ExpiresAt.After(now)
 }
 
 // GenerateRefreshToken creates a new refresh token string
@@ -40,56 +51,72 @@ func HashToken(token string) string {
 	return hex.EncodeToString(hash[:])
 }
 
-// CreateRefreshToken creates a new refresh token for a user
-func (db *DB) CreateRefreshToken(ctx context.Context, userID uuid.UUID) (string, error) {
-	// First cleanup any expired tokens
-	if err := db.CleanupExpiredTokens(ctx); err != nil {
-		return "", err
-	}
-
-	// Generate the token
+// CreateRefreshToken starts a session for a user on terms, signing them
+// out of their other sessions if the terms allow only one
+func (db *DB) CreateRefreshToken(ctx context.Context, userID uuid.UUID, terms SessionTerms) (string, error) {
 	token, err := GenerateRefreshToken()
 	if err != nil {
 		return "", err
 	}
 
-	// Hash the token for storage
-	tokenHash := HashToken(token)
-
-	// Delete any existing refresh tokens for this user
-	_, err = db.ExecContext(ctx, `
-        DELETE FROM refresh_tokens WHERE user_id = $1
-    `, userID)
+	err = db.transact(ctx, func(tx *sqlx.Tx) error {
+		return insertRefreshToken(ctx, tx, userID, token, terms, terms.sessionEnd(time.Now()))
+	})
 	if err != nil {
 		return "", err
 	}
+	return token, nil
+}
 
-	// Create new refresh token
-	refreshToken := &RefreshToken{
-		ID:        uuid.New(),
-		UserID:    userID,
-		TokenHash: tokenHash,
-		ExpiresAt: time.Now().Add(7 * 24 * time.Hour), // 7 days
+// RotateRefreshToken exchanges a live refresh token for a new one in the
+// same session, which keeps the time it ends
+func (db *DB) RotateRefreshToken(ctx context.Context, token string, terms SessionTerms) (string, error) {
+	next, err := GenerateRefreshToken()
+	if err != nil {
+		return "", err
 	}
 
-	_, err = db.ExecContext(ctx, `
-        INSERT INTO refresh_tokens (id, user_id, token_hash, expires_at)
-        VALUES ($1, $2, $3, $4)
-    `, refreshToken.ID, refreshToken.UserID, refreshToken.TokenHash, refreshToken.ExpiresAt)
+	err = db.transact(ctx, func(tx *sqlx.Tx) error {
+		var rt RefreshToken
+		err := tx.GetContext(ctx, &rt, `
+			UPDATE refresh_tokens SET revoked_at = NOW()
+			WHERE token_hash = $1 AND expires_at > NOW() AND revoked_at IS NULL
+			RETURNING *
+		`, HashToken(token))
+		if err == sql.ErrNoRows {
+			return ErrRefreshTokenNotFound
+		}
+		if err != nil {
+			return err
+		}
+		return insertRefreshToken(ctx, tx, rt.UserID, next, terms, rt.SessionExpiresAt)
+	})
 	if err != nil {
 		return "", err
 	}
+	return next, nil
+}
 
-	return token, nil
+// insertRefreshToken stores token for a session of userID's ending at end
+func insertRefreshToken(ctx context.Context, tx *sqlx.Tx, userID uuid.UUID, token string, terms SessionTerms, end *time.Time) error {
+	if terms.SingleSession {
+		_, err := tx.ExecContext(ctx, `
+			UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL
+		`, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO refresh_tokens (id, user_id, token_hash, expires_at, session_expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, uuid.New(), userID, HashToken(token), terms.refreshExpiry(time.Now(), end), end)
+	return err
 }
 
 // ValidateRefreshToken validates a refresh token and returns the associated user
 func (db *DB) ValidateRefreshToken(ctx context.Context, token string) (*User, error) {
-	// First cleanup expired tokens
-	if err := db.CleanupExpiredTokens(ctx); err != nil {
-		return nil, err
-	}
-
 	tokenHash := HashToken(token)
 
 	var rt RefreshToken
@@ -97,6 +124,7 @@ func (db *DB) ValidateRefreshToken(ctx context.Context, token string) (*User, er
         SELECT * FROM refresh_tokens
         WHERE token_hash = $1
         AND expires_at > NOW()
+        AND revoked_at IS NULL
     `, tokenHash)
 	if err != nil {
 		return nil, ErrRefreshTokenNotFound
@@ -111,28 +139,32 @@ func (db *DB) ValidateRefreshToken(ctx context.Context, token string) (*User, er
 	return user, nil
 }
 
-// InvalidateRefreshToken deletes a refresh token
+// InvalidateRefreshToken revokes a refresh token. Revoked tokens are kept
+// for the revoked-session retention window.
 func (db *DB) InvalidateRefreshToken(ctx context.Context, token string) error {
 	tokenHash := HashToken(token)
 
 	_, err := db.ExecContext(ctx, `
-		DELETE FROM refresh_tokens WHERE token_hash = $1
+		UPDATE refresh_tokens SET revoked_at = NOW() WHERE token_hash = $1 AND revoked_at IS NULL
 	`, tokenHash)
 	return err
 }
 
-// InvalidateUserRefreshTokens deletes all refresh tokens for a user
+// InvalidateUserRefreshTokens revokes all refresh tokens for a user
 func (db *DB) InvalidateUserRefreshTokens(ctx context.Context, userID uuid.UUID) error {
 	_, err := db.ExecContext(ctx, `
-		DELETE FROM refresh_tokens WHERE user_id = $1
+		UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL
 	`, userID)
 	return err
 }
 
-func (db *DB) CleanupExpiredTokens(ctx context.Context) error {
-	_, err := db.ExecContext(ctx, `
-        DELETE FROM refresh_tokens
-        WHERE expires_at <= NOW()
-    `)
-	return err
+// ListUserRefreshTokens retrieves a user's live refresh tokens, newest first
+func (db *DB) ListUserRefreshTokens(ctx context.Context, userID uuid.UUID) ([]RefreshToken, error) {
+	tokens := []RefreshToken{}
+	err := db.SelectContext(ctx, &tokens, `
+		SELECT * FROM refresh_tokens
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		ORDER BY created_at DESC, id
+	`, userID)
+	return tokens, err
 }
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"os"
+	"slices"
+	"time"
+)
+
+// Reload applies the settings that can change while the server runs: the
+// CORS origins, the log level and the subscription tier catalog. Everything
+// else, including the database, keys and listeners, only changes on a
+// restart. In-memory state such as sessions and caches is kept.
+//
+// The log level is only set if LOG_LEVEL itself changed, so a level a
+// platform admin set at runtime survives reloads that leave it alone.
+func (s *Server) Reload(config *Config) {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	var changed []string
+	if !slices.Equal(config.CORS.AllowedOrigins, s.loaded.CORS.AllowedOrigins) {
+		s.cors.SetAllowedOrigins(config.CORS.AllowedOrigins)
+		changed = append(changed, "ALLOWED_ORIGINS")
+	}
+	if config.Log.Level != s.loaded.Log.Level {
+		logLevel.Set(config.Log.Level)
+		changed = append(changed, "LOG_LEVEL")
+	}
+	if !maps.Equal(config.Tiers, s.loaded.Tiers) {
+		SubscriptionTiers.Set(config.Tiers)
+		changed = append(changed, "SUBSCRIPTION_TIERS")
+	}
+	s.loaded = config
+
+	s.logger.Info("configuration reloaded", "changed", changed)
+}
+
+// ReloadConfig loads the configuration file at path and the environment
+// again and applies it. An invalid configuration is logged and the running
+// one kept.
+func (s *Server) ReloadConfig(path string) {
+	config, err := LoadConfig(path, os.Environ())
+	if err != nil {
+		s.logger.Error("failed to reload configuration", "error", err)
+		return
+	}
+	s.Reload(config)
+}
+
+// watchConfigFile calls reload whenever the file at path is modified,
+// checking every interval until ctx is done. A file that is briefly
+// missing, as when an editor or a Kubernetes ConfigMap replaces it, is
+// picked up again once it reappears.
+func watchConfigFile(ctx context.Context, path string, interval time.Duration, reload func()) {
+	stat := func() (time.Time, int64) {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, -1
+		}
+		return info.ModTime(), info.Size()
+	}
+	modTime, size := stat()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t, n := stat()
+			if n < 0 || (t.Equal(modTime) && n == size) {
+				continue
+			}
+			modTime, size = t, n
+			reload()
+		}
+	}
+}
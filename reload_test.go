@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReload(t *testing.T) {
+	defer logLevel.Set(logLevel.Level())
+	t.Cleanup(func() { SubscriptionTiers.Set(defaultSubscriptionTiers) })
+
+	path := writeConfigFile(t, `
+environment: development
+database_url: memory://
+allowed_origins: [https://app.example.com]
+`)
+	config, err := LoadConfig(path, nil)
+	require.NoError(t, err)
+	srv, err := NewServer(nil, config)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	require.True(t, srv.cors.allowed(ctx, "https://app.example.com"))
+	require.False(t, srv.cors.allowed(ctx, "https://admin.example.com"))
+
+	// A platform admin turned on debug logging
+	logLevel.Set(slog.LevelDebug)
+
+	require.NoError(t, os.WriteFile(path, []byte(`
+environment: development
+database_url: memory://
+allowed_origins: [https://admin.example.com]
+subscription_tiers: free=10,pro=50
+`), 0o600))
+	srv.ReloadConfig(path)
+
+	require.False(t, srv.cors.allowed(ctx, "https://app.example.com"))
+	require.True(t, srv.cors.allowed(ctx, "https://admin.example.com"))
+	limit, ok := SubscriptionTiers.Limit("pro")
+	require.True(t, ok)
+	require.Equal(t, 50, limit)
+	_, ok = SubscriptionTiers.Limit("enterprise")
+	require.False(t, ok)
+	require.Equal(t, 10, SubscriptionTiers.DefaultLimit())
+	require.Equal(t, slog.LevelDebug, logLevel.Level(), "LOG_LEVEL did not change")
+
+	t.Run("LOG_LEVEL changes apply", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(path, []byte("environment: development\nsubscription_tiers: free=10\nlog_level: warn\n"), 0o600))
+		srv.ReloadConfig(path)
+		require.Equal(t, slog.LevelWarn, logLevel.Level())
+	})
+
+	t.Run("Invalid configuration is ignored", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(path, []byte("environment: development\nsubscription_tiers: pro=25\nlog_level: error\n"), 0o600))
+		srv.ReloadConfig(path)
+		require.Equal(t, slog.LevelWarn, logLevel.Level())
+		_, ok := SubscriptionTiers.Limit("pro")
+		require.False(t, ok)
+	})
+}
+
+func TestParseTiers(t *testing.T) {
+	tiers, err := parseTiers("free=3, team=15")
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"free": 3, "team": 15}, tiers)
+
+	for _, value := range []string{"pro=25", "free", "free=-1", "free=lots", "=5,free=1"} {
+		_, err := parseTiers(value)
+		require.Error(t, err, value)
+	}
+}
+
+func TestWatchConfigFile(t *testing.T) {
+	path := writeConfigFile(t, "log_level: info\n")
+	reloads := make(chan struct{}, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go watchConfigFile(ctx, path, 10*time.Millisecond, func() { reloads <- struct{}{} })
+
+	// Give the watcher time to stat the original file
+	time.Sleep(30 * time.Millisecond)
+	require.Empty(t, reloads)
+
+	require.NoError(t, os.WriteFile(path, []byte("log_level: debug\n"), 0o600))
+	select {
+	case <-reloads:
+	case <-time.After(time.Second):
+		t.Fatal("the change was not noticed")
+	}
+
+	// A missing file is not a change
+	require.NoError(t, os.Remove(path))
+	time.Sleep(30 * time.Millisecond)
+	require.Empty(t, reloads)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// replicaRetryInterval is how long reads skip a replica that could not be reached
+const replicaRetryInterval = 30 * time.Second
+
+// replica is a read-only standby that pure reads are routed to while it is
+// reachable
+type replica struct {
+	*sqlx.DB
+	downUntil atomic.Int64 // UnixNano before which reads go to the primary
+}
+
+func (r *replica) available() bool {
+	return time.Now().UnixNano() >= r.downUntil.Load()
+}
+
+func (r *replica) markDown() {
+	r.downUntil.Store(time.Now().Add(replicaRetryInterval).UnixNano())
+}
+
+// read runs query against the replica when one is configured and up, and
+// against the primary otherwise. A read that fails because the replica
+// cannot be reached is retried on the primary. A read whose context is
+// confined to an organization runs in a transaction confined to its rows.
+//
+// Replicas lag the primary, so only reads that tolerate slightly stale data
+// belong here; anything that decides what to write next reads the primary.
+func (db *DB) read(ctx context.Context, query func(q sqlx.QueryerContext) error) error {
+	return db.readPool(ctx, func(pool *sqlx.DB) error {
+		if scopedOrg(ctx) == uuid.Nil {
+			return query(pool)
+		}
+		return runTx(ctx, pool, uuid.Nil, func(tx *sqlx.Tx) error {
+			return query(tx)
+		})
+	})
+}
+
+// readPool runs query against the pool read chooses
+func (db *DB) readPool(ctx context.Context, query func(pool *sqlx.DB) error) error {
+	if db.replica != nil && db.replica.available() {
+		err := query(db.replica.DB)
+		if err == nil || ctx.Err() != nil || !isConnectionError(err) {
+			return err
+		}
+		db.replica.markDown()
+	}
+	return query(db.DB)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	RequestIDHeader = "X-Request-ID"
+
+	requestIDContextKey contextKey = "request_id"
+
+	// maxRequestIDLength bounds client-supplied IDs so they cannot bloat logs
+	maxRequestIDLength = 128
+)
+
+// GetRequestIDFromContext returns the request ID, or "" outside a request
+func GetRequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey).(string)
+	return id
+}
+
+// validRequestID accepts IDs made of printable ASCII without spaces, so an
+// incoming header cannot inject anything into logs or response headers
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// RequestID accepts the caller's X-Request-ID or generates one, stores it in
+// the request context and echoes it on the response
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.NewString()
+		}
+
+		// Set before calling next so error responses carry it too
+		w.Header().Set(RequestIDHeader, id)
+
+		ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// contextLogHandler adds the request ID and trace ID from the context to
+// every record logged with one of the slog *Context methods
+type contextLogHandler struct {
+	slog.Handler
+}
+
+func NewContextLogHandler(h slog.Handler) slog.Handler {
+	return &contextLogHandler{Handler: h}
+}
+
+func (h *contextLogHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := GetRequestIDFromContext(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+		record.AddAttrs(slog.String("trace_id", spanContext.TraceID().String()))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h *contextLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextLogHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextLogHandler) WithGroup(name string) slog.Handler {
+	return &contextLogHandler{Handler: h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name       string
+		incoming   string
+		expectKept bool
+	}{
+		{
+			name:       "Generated when absent",
+			incoming:   "",
+			expectKept: false,
+		},
+		{
+			name:       "Caller ID is propagated",
+			incoming:   "support-ticket-1234",
+			expectKept: true,
+		},
+		{
+			name:       "Caller ID with spaces is replaced",
+			incoming:   "bad id",
+			expectKept: false,
+		},
+		{
+			name:       "Oversized caller ID is replaced",
+			incoming:   strings.Repeat("a", maxRequestIDLength+1),
+			expectKept: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen string
+			handler := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = GetRequestIDFromContext(r.Context())
+				http.Error(w, "Forbidden", http.StatusForbidden)
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.incoming != "" {
+				req.Header.Set(RequestIDHeader, tt.incoming)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			require.Equal(t, http.StatusForbidden, w.Code)
+			require.Equal(t, seen, w.Header().Get(RequestIDHeader))
+			if tt.expectKept {
+				require.Equal(t, tt.incoming, seen)
+			} else {
+				_, err := uuid.Parse(seen)
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestContextLogHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewContextLogHandler(slog.NewJSONHandler(&buf, nil))).With("component", "test")
+
+	handler := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.InfoContext(r.Context(), "handled")
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(RequestIDHeader, "abc-123")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	require.Equal(t, "abc-123", entry["request_id"])
+	require.Equal(t, "test", entry["component"])
+}
+
+func TestServerSetsRequestID(t *testing.T) {
+	srv, err := NewServer(nil, newTestConfig(t))
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/does-not-exist", nil))
+
+	require.Equal(t, http.StatusNotFound, w.Code)
+	require.NotEmpty(t, w.Header().Get(RequestIDHeader))
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// inRetentionScope is the condition that the organization ID in column lies
+// in the RetentionScope passed as $1 (OrgID) and $2 (Except)
+func inRetentionScope(column string) string {
+	return `(` + column + ` = $1 OR ($1 = '00000000-0000-0000-0000-000000000000' AND ` + column + ` <> ALL($2)))`
+}
+
+// scopeExcept returns the organizations scope excludes, never nil: a NULL
+// array would make <> ALL exclude every row
+func scopeExcept(scope RetentionScope) []uuid.UUID {
+	if scope.Except == nil {
+		return []uuid.UUID{}
+	}
+	return scope.Except
+}
+
+// GetRetentionOverrides returns the retention override of every organization
+// that has one, deleted organizations included
+func (db *DB) GetRetentionOverrides(ctx context.Context) (map[uuid.UUID]RetentionOverride, error) {
+	var rows []struct {
+		ID        uuid.UUID         `db:"id"`
+		Retention RetentionOverride `db:"retention"`
+	}
+	err := db.SelectContext(ctx, &rows, `
+		SELECT id, retention FROM organizations WHERE retention <> '{}'
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	overrides := make(map[uuid.UUID]RetentionOverride, len(rows))
+	for _, row := range rows {
+		overrides[row.ID] = row.Retention
+	}
+	return overrides, nil
+}
+
+// GetRetentionOverride retrieves an organization's retention override along
+// with the organization's version
+func (db *DB) GetRetentionOverride(ctx context.Context, orgID uuid.UUID) (*RetentionOverride, int, error) {
+	override := &RetentionOverride{}
+	var version int
+	err := db.QueryRowxContext(ctx, `
+		SELECT retention, version FROM organizations WHERE id = $1 AND deleted_at IS NULL
+	`, orgID).Scan(override, &version)
+	if err == sql.ErrNoRows {
+		return nil, 0, ErrOrganizationNotFound
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return override, version, nil
+}
+
+// UpdateRetentionOverride replaces the retention override of an organization
+// at expectedVersion and returns its new version
+func (db *DB) UpdateRetentionOverride(ctx context.Context, orgID uuid.UUID, expectedVersion int, override *RetentionOverride) (int, error) {
+	var version int
+	err := db.transact(ctx, func(tx *sqlx.Tx) error {
+		if err := lockOrganizationVersion(ctx, tx, orgID, expectedVersion); err != nil {
+			return err
+		}
+		return tx.GetContext(ctx, &version, `
+			UPDATE organizations SET retention = $2, version = version + 1, updated_at = NOW()
+			WHERE id = $1
+			RETURNING version
+		`, orgID, override)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// PurgeRetained permanently removes the refresh tokens, organizations and
+// users in scope that are past their cutoffs. Members of a purged
+// organization go with it whenever they were deleted, and so do its usage
+// counters.
+func (db *DB) PurgeRetained(ctx context.Context, scope RetentionScope, cutoffs RetentionCutoffs) (*PurgeCounts, error) {
+	counts := &PurgeCounts{}
+	err := db.transact(ctx, func(tx *sqlx.Tx) error {
+		*counts = PurgeCounts{}
+
+		// purge runs a statement taking the scope and cutoff, unless the
+		// cutoff keeps everything
+		purge := func(removed *int64, cutoff time.Time, query string) error {
+			if cutoff.IsZero() {
+				return nil
+			}
+			result, err := tx.ExecContext(ctx, query, scope.OrgID, scopeExcept(scope), cutoff)
+			if err != nil {
+				return err
+			}
+			if removed != nil {
+				*removed, err = result.RowsAffected()
+			}
+			return err
+		}
+
+		if err := purge(&counts.RefreshTokens, cutoffs.RefreshTokens, `
+			DELETE FROM refresh_tokens rt USING users u
+			WHERE rt.user_id = u.id AND `+inRetentionScope("u.organization_id")+`
+			  AND rt.revoked_at IS NULL AND rt.expires_at < $3
+		`); err != nil {
+			return err
+		}
+
+		if err := purge(&counts.RevokedSessions, cutoffs.RevokedSessions, `
+			DELETE FROM refresh_tokens rt USING users u
+			WHERE rt.user_id = u.id AND `+inRetentionScope("u.organization_id")+`
+			  AND rt.revoked_at < $3
+		`); err != nil {
+			return err
+		}
+
+		if err := purge(nil, cutoffs.DeletedRecords, `
+			DELETE FROM refresh_tokens WHERE user_id IN (
+				SELECT id FROM users
+				WHERE `+inRetentionScope("organization_id")+`
+				  AND (deleted_at < $3 OR organization_id IN (SELECT id FROM organizations WHERE deleted_at < $3))
+			)
+		`); err != nil {
+			return err
+		}
+
+		if err := purge(&counts.Users, cutoffs.DeletedRecords, `
+			DELETE FROM users
+			WHERE `+inRetentionScope("organization_id")+`
+			  AND (deleted_at < $3 OR organization_id IN (SELECT id FROM organizations WHERE deleted_at < $3))
+		`); err != nil {
+			return err
+		}
+
+		if err := purge(nil, cutoffs.DeletedRecords, `
+			DELETE FROM organization_api_usage
+			WHERE `+inRetentionScope("organization_id")+`
+			  AND organization_id IN (SELECT id FROM organizations WHERE deleted_at < $3)
+		`); err != nil {
+			return err
+		}
+
+		return purge(&counts.Organizations, cutoffs.DeletedRecords, `
+			DELETE FROM organizations WHERE `+inRetentionScope("id")+` AND deleted_at < $3
+		`)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	retryAttempts  = 3
+	retryBaseDelay = 20 * time.Millisecond
+	retryMaxDelay  = 500 * time.Millisecond
+
+	pgSerializationFailure = "40001"
+	pgDeadlockDetected     = "40P01"
+)
+
+// isTransient reports whether err is a failure that running the operation
+// again can fix: a serialization failure, a deadlock, or a lost connection
+func isTransient(err error) bool {
+	switch pgErrorCode(err) {
+	case pgSerializationFailure, pgDeadlockDetected:
+		return true
+	}
+	return isConnectionError(err)
+}
+
+// withRetry runs op, retrying transient failures with exponential backoff and
+// full jitter. op must be safe to run again from the start.
+func withRetry(ctx context.Context, op func() error) error {
+	delay := retryBaseDelay
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || attempt == retryAttempts || ctx.Err() != nil || !isTransient(err) {
+			return err
+		}
+
+		timer := time.NewTimer(rand.N(delay) + 1)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		delay = min(2*delay, retryMaxDelay)
+	}
+}
+
+// transact runs fn in a transaction on the primary and commits it, starting
+// over in a new transaction if it fails transiently
+func (db *DB) transact(ctx context.Context, fn func(tx *sqlx.Tx) error) error {
+	return withRetry(ctx, func() error {
+		return runTx(ctx, db.DB, uuid.Nil, fn)
+	})
+}
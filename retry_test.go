@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		attempts int
+	}{
+		{"serialization failure", &pgconn.PgError{Code: pgSerializationFailure}, retryAttempts},
+		{"deadlock", fmt.Errorf("insert: %w", &pgconn.PgError{Code: pgDeadlockDetected}), retryAttempts},
+		{"connection reset", &pgconn.PgError{Code: "08006"}, retryAttempts},
+		{"unique violation", &pgconn.PgError{Code: pgUniqueViolation}, 1},
+		{"domain error", ErrEmailTaken, 1},
+		{"no rows", sql.ErrNoRows, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			err := withRetry(context.Background(), func() error {
+				attempts++
+				return tt.err
+			})
+			require.ErrorIs(t, err, tt.err)
+			require.Equal(t, tt.attempts, attempts)
+		})
+	}
+
+	t.Run("succeeds after a transient failure", func(t *testing.T) {
+		attempts := 0
+		err := withRetry(context.Background(), func() error {
+			attempts++
+			if attempts == 1 {
+				return &pgconn.PgError{Code: pgDeadlockDetected}
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, 2, attempts)
+	})
+
+	t.Run("stops when the context is done", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		attempts := 0
+		err := withRetry(ctx, func() error {
+			attempts++
+			cancel()
+			return &pgconn.PgError{Code: pgSerializationFailure}
+		})
+		require.Error(t, err)
+		require.Equal(t, 1, attempts)
+	})
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Middleware wraps an http.Handler
+type Middleware func(http.Handler) http.Handler
+
+// chain applies middlewares to h so that the first one listed runs first
+func chain(h http.Handler, middlewares ...Middleware) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+// validateOrgID rejects requests whose {orgID} path parameter is not a UUID
+// before any authentication work is done
+func validateOrgID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := uuid.Parse(r.PathValue("orgID")); err != nil {
+			http.Error(w, "Invalid organization ID format", http.StatusBadRequest)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Access says who may call a route. Every route must declare one; the zero
+// value is not a policy.
+type Access int
+
+const (
+	_ Access = iota
+	// Public routes take no access token. Those that act on one, such as
+	// the OIDC userinfo endpoint, check it themselves.
+	Public
+	// Authenticated routes take any valid access token
+	Authenticated
+	// OrgMember routes are limited to members of the {orgID} organization
+	OrgMember
+	// PlatformAdmin routes are limited to platform operators
+	PlatformAdmin
+)
+
+func (a Access) String() string {
+	switch a {
+	case Public:
+		return "public"
+	case Authenticated:
+		return "authenticated"
+	case OrgMember:
+		return "org member"
+	case PlatformAdmin:
+		return "platform admin"
+	}
+	return "undeclared"
+}
+
+// route declares an endpoint and the policy guarding it
+type route struct {
+	pattern     string // method and path, as http.ServeMux takes them
+	handler     http.HandlerFunc
+	access      Access
+	permissions []Permission // required on top of access
+	// csrfExempt routes skip the CSRF check, as they are called with a
+	// token in the body rather than from a browser session
+	csrfExempt  bool
+	middlewares []Middleware // run after authorization
+}
+
+// perms lists the permissions a route requires
+func perms(p ...Permission) []Permission { return p }
+
+// routeTable declares every route the server serves, with who may call it.
+// routes builds the mux from it, so a route cannot be registered without an
+// access policy.
+func (s *Server) routeTable() []route {
+	etag := []Middleware{ETag}
+	member := []Middleware{s.RequireOwnership(s.memberLoader())}
+	webhook := []Middleware{s.RequireOwnership(s.webhookLoader())}
+	table := []route{
+		// Probes, discovery and sign-in
+		{pattern: "GET /health", handler: s.handleHealth, access: Public},
+		{pattern: "GET /livez", handler: s.handleLivez, access: Public},
+		{pattern: "GET /readyz", handler: s.handleReadyz, access: Public},
+		{pattern: "GET /version", handler: s.handleVersion, access: Public},
+		{pattern: "GET /metrics", handler: s.metricsHandler(), access: Public},
+		{pattern: "GET /.well-known/jwks.json", handler: s.handleJWKS, access: Public},
+		{pattern: "GET /.well-known/openid-configuration", handler: s.handleOIDCDiscovery, access: Public},
+		{pattern: "GET /oidc/authorize", handler: s.handleOIDCAuthorize, access: Public},
+		{pattern: "POST /oidc/token", handler: s.handleOIDCToken, access: Public, csrfExempt: true},
+		{pattern: "GET /oidc/userinfo", handler: s.handleOIDCUserInfo, access: Public, csrfExempt: true},
+		{pattern: "POST /oidc/userinfo", handler: s.handleOIDCUserInfo, access: Public, csrfExempt: true},
+		{pattern: "GET /auth/captcha", handler: s.handleGetCaptcha, access: Public},
+		{pattern: "GET /auth/login/google", handler: s.handleGoogleLogin, access: Public},
+		{pattern: "GET /auth/callback/google", handler: s.handleGoogleCallback, access: Public},
+		{pattern: "POST /auth/refresh", handler: s.handleRefreshToken, access: Public, csrfExempt: true},
+		{pattern: "POST /auth/logout", handler: s.handleLogout, access: Public, csrfExempt: true},
+		{pattern: "POST /auth/token", handler: s.handleLoginCode, access: Public, csrfExempt: true},
+		{pattern: "POST /auth/validate-batch", handler: s.handleValidateBatch, access: Public, csrfExempt: true},
+		{pattern: "POST /invitations/accept", handler: s.handleAcceptInvitation, access: Public, csrfExempt: true},
+		{pattern: "GET /csrf/token", handler: s.handleGetCSRFToken, access: Public},
+		{pattern: "GET /openapi.json", handler: s.handleOpenAPI, access: Public},
+		{pattern: "GET /downloads/{kind}", handler: s.handleDownload, access: Public},
+
+		// Hosted pages
+		{pattern: "GET /pages/login", handler: s.handleLoginPage, access: Public},
+		{pattern: "GET /pages/invitation", handler: s.handleInvitationPage, access: Public},
+		{pattern: "POST /pages/invitation", handler: s.handleAcceptInvitationPage, access: Public},
+		{pattern: "POST /pages/mfa", handler: s.handleMFAChallenge, access: Public},
+		{pattern: "POST /pages/oidc/consent", handler: s.handleConsent, access: Public},
+		{pattern: "GET /docs", handler: s.handleDocs, access: Public},
+
+		// The authenticated user
+		{pattern: "GET /me", handler: s.handleGetMe, access: Authenticated},
+		{pattern: "DELETE /me/sessions", handler: s.handleRevokeMySessions, access: Authenticated},
+		{pattern: "GET /me/login-history", handler: s.handleGetLoginHistory, access: Authenticated},
+		{pattern: "GET /me/access-grants", handler: s.handleListMyAccessGrants, access: Authenticated},
+		{pattern: "POST /me/mfa", handler: s.handleEnrollMFA, access: Authenticated},
+		{pattern: "POST /me/mfa/confirm", handler: s.handleConfirmMFA, access: Authenticated},
+		{pattern: "DELETE /me/mfa", handler: s.handleDeleteMFA, access: Authenticated},
+
+		// GraphQL for the dashboard, which checks permissions per field
+		{pattern: "GET /graphql", handler: s.handleGraphQL, access: Authenticated},
+		{pattern: "POST /graphql", handler: s.handleGraphQL, access: Authenticated},
+
+		// Organizations
+		{pattern: "POST /organizations", handler: s.handleCreateOrganization, access: Authenticated, permissions: perms(PermCreateOrg)},
+		{pattern: "GET /organizations/{orgID}", handler: s.handleGetOrganizationUsers, access: OrgMember, permissions: perms(PermReadOrg), middlewares: etag},
+		{pattern: "GET /organizations/{orgID}/details", handler: s.handleGetOrganization, access: OrgMember, permissions: perms(PermReadOrg), middlewares: etag},
+		{pattern: "PATCH /organizations/{orgID}/details", handler: s.handleUpdateOrganization, access: OrgMember, permissions: perms(PermUpdateOrg)},
+		{pattern: "GET /organizations/{orgID}/stats", handler: s.handleGetOrganizationStats, access: OrgMember, permissions: perms(PermReadOrg)},
+		{pattern: "GET /organizations/{orgID}/onboarding", handler: s.handleGetOnboarding, access: OrgMember, permissions: perms(PermReadOrg)},
+		{pattern: "GET /organizations/{orgID}/billing/usage", handler: s.handleGetBillingUsage, access: OrgMember, permissions: perms(PermManageSettings)},
+		{pattern: "POST /organizations/{orgID}/users", handler: s.handleAddUser, access: OrgMember, permissions: perms(PermInviteUser)},
+		{pattern: "GET /organizations/{orgID}/users", handler: s.handleListOrganizationUsers, access: OrgMember, permissions: perms(PermReadOrg), middlewares: etag},
+		{pattern: "GET /organizations/{orgID}/users/search", handler: s.handleSearchOrganizationUsers, access: OrgMember, permissions: perms(PermReadOrg), middlewares: etag},
+		{pattern: "POST /organizations/{orgID}/invitations", handler: s.handleCreateInvitation, access: OrgMember, permissions: perms(PermInviteUser)},
+		{pattern: "GET /organizations/{orgID}/invitations", handler: s.handleListInvitations, access: OrgMember, permissions: perms(PermInviteUser)},
+		{pattern: "DELETE /organizations/{orgID}/invitations/{invitationID}", handler: s.handleDeleteInvitation, access: OrgMember, permissions: perms(PermInviteUser)},
+		{pattern: "DELETE /organizations/{orgID}/users/{userID}", handler: s.handleRemoveUser, access: OrgMember, permissions: perms(PermRemoveUser), middlewares: member},
+		{pattern: "PUT /organizations/{orgID}/users/{userID}/role", handler: s.handleUpdateUserRole, access: OrgMember, permissions: perms(PermUpdateUser), middlewares: member},
+		{pattern: "PATCH /organizations/{orgID}/users/{userID}/metadata", handler: s.handleUpdateUserMetadata, access: OrgMember, permissions: perms(PermUpdateUser), middlewares: member},
+		{pattern: "DELETE /organizations/{orgID}/users/{userID}/sessions", handler: s.handleRevokeUserSessions, access: OrgMember, permissions: perms(PermUpdateUser), middlewares: member},
+		{pattern: "GET /organizations/{orgID}/settings", handler: s.handleGetOrganizationSettings, access: OrgMember, permissions: perms(PermReadOrg), middlewares: etag},
+		{pattern: "PUT /organizations/{orgID}/settings", handler: s.handleUpdateOrganizationSettings, access: OrgMember, permissions: perms(PermManageSettings)},
+		{pattern: "GET /organizations/{orgID}/features", handler: s.handleGetOrganizationFeatures, access: OrgMember, permissions: perms(PermReadOrg)},
+		{pattern: "GET /organizations/{orgID}/events/stream", handler: s.handleEventStream, access: OrgMember, permissions: perms(PermManageSettings)},
+
+		// Webhooks
+		{pattern: "POST /organizations/{orgID}/webhooks", handler: s.handleCreateWebhook, access: OrgMember, permissions: perms(PermManageSettings)},
+		{pattern: "GET /organizations/{orgID}/webhooks", handler: s.handleListWebhooks, access: OrgMember, permissions: perms(PermManageSettings)},
+		{pattern: "GET /organizations/{orgID}/webhooks/{webhookID}", handler: s.handleGetWebhook, access: OrgMember, permissions: perms(PermManageSettings), middlewares: webhook},
+		{pattern: "PUT /organizations/{orgID}/webhooks/{webhookID}", handler: s.handleUpdateWebhook, access: OrgMember, permissions: perms(PermManageSettings), middlewares: webhook},
+		{pattern: "DELETE /organizations/{orgID}/webhooks/{webhookID}", handler: s.handleDeleteWebhook, access: OrgMember, permissions: perms(PermManageSettings), middlewares: webhook},
+		{pattern: "GET /organizations/{orgID}/webhooks/{webhookID}/deliveries", handler: s.handleListWebhookDeliveries, access: OrgMember, permissions: perms(PermManageSettings), middlewares: webhook},
+
+		// OpenID Connect clients
+		{pattern: "POST /organizations/{orgID}/oidc/clients", handler: s.handleCreateOIDCClient, access: OrgMember, permissions: perms(PermManageSettings)},
+		{pattern: "GET /organizations/{orgID}/oidc/clients", handler: s.handleListOIDCClients, access: OrgMember, permissions: perms(PermManageSettings)},
+		{pattern: "DELETE /organizations/{orgID}/oidc/clients/{clientID}", handler: s.handleDeleteOIDCClient, access: OrgMember, permissions: perms(PermManageSettings)},
+
+		// Access granted to users from other organizations; only the owner
+		// can grant it
+		{pattern: "POST /organizations/{orgID}/access-grants", handler: s.handleCreateAccessGrant, access: OrgMember, permissions: perms(PermManageSettings)},
+		{pattern: "GET /organizations/{orgID}/access-grants", handler: s.handleListAccessGrants, access: OrgMember, permissions: perms(PermManageSettings)},
+		{pattern: "DELETE /organizations/{orgID}/access-grants/{grantID}", handler: s.handleDeleteAccessGrant, access: OrgMember, permissions: perms(PermManageSettings)},
+
+		// Share-link style guest tokens
+		{pattern: "POST /organizations/{orgID}/guest-tokens", handler: s.handleCreateGuestToken, access: OrgMember, permissions: perms(PermManageSettings)},
+		{pattern: "DELETE /organizations/{orgID}/guest-tokens/{tokenID}", handler: s.handleRevokeGuestToken, access: OrgMember, permissions: perms(PermManageSettings)},
+
+		// Exports, downloaded from signed single-use URLs
+		{pattern: "POST /organizations/{orgID}/exports/{kind}", handler: s.handleCreateDownload, access: OrgMember, permissions: perms(PermManageSettings)},
+
+		// Directory sync
+		{pattern: "GET /organizations/{orgID}/directory", handler: s.handleGetDirectorySync, access: OrgMember, permissions: perms(PermManageSettings)},
+		{pattern: "PUT /organizations/{orgID}/directory", handler: s.handlePutDirectorySync, access: OrgMember, permissions: perms(PermManageSettings)},
+		{pattern: "DELETE /organizations/{orgID}/directory", handler: s.handleDeleteDirectorySync, access: OrgMember, permissions: perms(PermManageSettings)},
+		{pattern: "POST /organizations/{orgID}/directory/sync", handler: s.handleRunDirectorySync, access: OrgMember, permissions: perms(PermManageSettings)},
+
+		// Platform operator API
+		{pattern: "GET /admin/organizations", handler: s.handleAdminListOrganizations, access: PlatformAdmin, middlewares: etag},
+		{pattern: "POST /admin/organizations/{orgID}/suspend", handler: s.handleAdminSuspendOrganization, access: PlatformAdmin},
+		{pattern: "POST /admin/organizations/{orgID}/unsuspend", handler: s.handleAdminUnsuspendOrganization, access: PlatformAdmin},
+		{pattern: "PUT /admin/organizations/{orgID}/tier", handler: s.handleAdminUpdateTier, access: PlatformAdmin},
+		{pattern: "PUT /admin/organizations/{orgID}/parent", handler: s.handleAdminSetParent, access: PlatformAdmin},
+		{pattern: "GET /admin/organizations/{orgID}/retention", handler: s.handleAdminGetRetention, access: PlatformAdmin},
+		{pattern: "PUT /admin/organizations/{orgID}/retention", handler: s.handleAdminUpdateRetention, access: PlatformAdmin},
+		{pattern: "DELETE /admin/organizations/{orgID}", handler: s.handleAdminDeleteOrganization, access: PlatformAdmin},
+		{pattern: "POST /admin/organizations/{orgID}/merge", handler: s.handleAdminMergeOrganization, access: PlatformAdmin},
+		{pattern: "PATCH /admin/organizations/{orgID}/custom-fields", handler: s.handleAdminUpdateOrganizationFields, access: PlatformAdmin},
+		{pattern: "GET /admin/search", handler: s.handleAdminSearch, access: PlatformAdmin},
+		{pattern: "GET /admin/users", handler: s.handleAdminSearchUsers, access: PlatformAdmin, middlewares: etag},
+		{pattern: "DELETE /admin/users/{userID}", handler: s.handleAdminDeleteUser, access: PlatformAdmin},
+		{pattern: "GET /admin/feature-flags", handler: s.handleAdminListFeatureFlags, access: PlatformAdmin},
+		{pattern: "GET /admin/feature-flags/{key}", handler: s.handleAdminGetFeatureFlag, access: PlatformAdmin},
+		{pattern: "PUT /admin/feature-flags/{key}", handler: s.handleAdminPutFeatureFlag, access: PlatformAdmin},
+		{pattern: "DELETE /admin/feature-flags/{key}", handler: s.handleAdminDeleteFeatureFlag, access: PlatformAdmin},
+		{pattern: "PUT /admin/feature-flags/{key}/organizations/{orgID}", handler: s.handleAdminSetFeatureFlagOrganization, access: PlatformAdmin},
+		{pattern: "DELETE /admin/feature-flags/{key}/organizations/{orgID}", handler: s.handleAdminRemoveFeatureFlagOrganization, access: PlatformAdmin},
+		{pattern: "GET /admin/organization-fields", handler: s.handleAdminListOrganizationFields, access: PlatformAdmin},
+		{pattern: "PUT /admin/organization-fields/{key}", handler: s.handleAdminPutOrganizationField, access: PlatformAdmin},
+		{pattern: "DELETE /admin/organization-fields/{key}", handler: s.handleAdminDeleteOrganizationField, access: PlatformAdmin},
+		{pattern: "GET /admin/log-level", handler: s.handleAdminGetLogLevel, access: PlatformAdmin},
+		{pattern: "PUT /admin/log-level", handler: s.handleAdminSetLogLevel, access: PlatformAdmin},
+		{pattern: "GET /admin/stats", handler: s.handleAdminStats, access: PlatformAdmin},
+		{pattern: "GET /admin/audit/verify", handler: s.handleAdminVerifyAudit, access: PlatformAdmin},
+		{pattern: "GET /admin/jobs", handler: s.handleAdminListJobs, access: PlatformAdmin},
+		{pattern: "GET /admin/jobs/{jobID}", handler: s.handleAdminGetJob, access: PlatformAdmin},
+		{pattern: "POST /admin/jobs/{jobID}/retry", handler: s.handleAdminRetryJob, access: PlatformAdmin},
+	}
+
+	// Profiling and request recordings for platform admins, when enabled
+	if s.debug.AdminRoutes {
+		table = append(table, route{pattern: "/debug/", handler: debugHandler().ServeHTTP, access: PlatformAdmin})
+	}
+	if s.recorder != nil {
+		table = append(table,
+			route{pattern: "GET /admin/debug/recordings", handler: s.handleAdminListRecordings, access: PlatformAdmin},
+			route{pattern: "DELETE /admin/debug/recordings", handler: s.handleAdminClearRecordings, access: PlatformAdmin},
+		)
+	}
+	return table
+}
+
+// routes builds the request multiplexer from the route table, wrapping each
+// handler in the middleware its access policy calls for. The mux answers
+// 405 with an Allow header for known paths requested with the wrong method.
+func (s *Server) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	s.routeIndex = make(map[string]route)
+	for _, rt := range s.routeTable() {
+		mux.Handle(rt.pattern, s.guard(rt))
+		s.routeIndex[rt.pattern] = rt
+	}
+	return mux
+}
+
+// guard wraps a route's handler in the checks its access policy requires.
+// Routes with an {orgID} parameter have it validated before any
+// authentication work is done. It panics on a route without a policy, so
+// one cannot be served unguarded by mistake.
+func (s *Server) guard(rt route) http.Handler {
+	var middlewares []Middleware
+	switch rt.access {
+	case Public:
+		if len(rt.permissions) > 0 {
+			panic("public route " + rt.pattern + " requires permissions")
+		}
+	case Authenticated, OrgMember, PlatformAdmin:
+		middlewares = append(middlewares, s.auth.RequireAuth, s.usage.Handler, s.quotas.Handler)
+		// Membership comes first: users acting under an access grant
+		// only have the grant's permissions
+		if rt.access == OrgMember {
+			if !strings.Contains(rt.pattern, "{orgID}") {
+				panic("org member route " + rt.pattern + " has no {orgID}")
+			}
+			middlewares = append(middlewares, s.auth.RequireSameOrg)
+		}
+		required := rt.permissions
+		if rt.access == PlatformAdmin {
+			required = append([]Permission{PermPlatformAdmin}, required...)
+		}
+		if len(required) > 0 {
+			middlewares = append(middlewares, s.auth.RequirePermissions(required...))
+		}
+	default:
+		panic("route " + rt.pattern + " declares no access policy")
+	}
+
+	handler := chain(rt.handler, append(middlewares, rt.middlewares...)...)
+	if strings.Contains(rt.pattern, "{orgID}") {
+		handler = validateOrgID(handler)
+	}
+	return handler
+}
+
+// pathOrgID returns the already-validated {orgID} path parameter
+func pathOrgID(r *http.Request) uuid.UUID {
+	orgID, _ := uuid.Parse(r.PathValue("orgID"))
+	return orgID
+}
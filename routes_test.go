@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChain(t *testing.T) {
+	var order []string
+	mw := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+
+	handler := chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		order = append(order, "handler")
+	}), mw("first"), mw("second"))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Equal(t, []string{"first", "second", "handler"}, order)
+}
+
+func TestRouting(t *testing.T) {
+	// Routes are exercised up to the authentication layer, so no database is needed
+	srv, err := NewServer(nil, newTestConfig(t))
+	require.NoError(t, err)
+
+	orgPath := "/organizations/" + uuid.New().String()
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		expectedStatus int
+		expectedAllow  []string
+	}{
+		{
+			name:           "Unknown path",
+			method:         http.MethodGet,
+			path:           "/does-not-exist",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Wrong method on public route",
+			method:         http.MethodPost,
+			path:           "/health",
+			expectedStatus: http.StatusMethodNotAllowed,
+			expectedAllow:  []string{http.MethodGet},
+		},
+		{
+			name:           "Wrong method on organization route",
+			method:         http.MethodDelete,
+			path:           orgPath + "/users",
+			expectedStatus: http.StatusMethodNotAllowed,
+			expectedAllow:  []string{http.MethodPost},
+		},
+		{
+			name:           "Invalid organization ID",
+			method:         http.MethodGet,
+			path:           "/organizations/not-a-uuid",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Invalid organization ID on admin route",
+			method:         http.MethodPost,
+			path:           "/admin/organizations/not-a-uuid/suspend",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Protected route without token",
+			method:         http.MethodGet,
+			path:           orgPath,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Stats route without token",
+			method:         http.MethodGet,
+			path:           orgPath + "/stats",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Admin route without token",
+			method:         http.MethodGet,
+			path:           "/admin/stats",
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			if tc.method != http.MethodGet {
+				addCSRFToken(t, srv, req)
+			}
+			w := httptest.NewRecorder()
+
+			srv.ServeHTTP(w, req)
+
+			require.Equal(t, tc.expectedStatus, w.Code)
+			for _, method := range tc.expectedAllow {
+				require.True(t, strings.Contains(w.Header().Get("Allow"), method),
+					"Allow header %q should contain %s", w.Header().Get("Allow"), method)
+			}
+		})
+	}
+}
+
+func TestRouteAccessPolicies(t *testing.T) {
+	t.Setenv("DEBUG_ENDPOINTS", "true")
+	t.Setenv("DEBUG_RECORD", "true")
+	srv, err := NewServer(nil, newTestConfig(t))
+	require.NoError(t, err)
+
+	documented := make(map[string]apiOperation)
+	for _, op := range apiOperations {
+		documented[op.Method+" "+op.Path] = op
+	}
+
+	for _, rt := range srv.routeTable() {
+		require.Contains(t, []Access{Public, Authenticated, OrgMember, PlatformAdmin}, rt.access, "%s declares no access policy", rt.pattern)
+		if op, ok := documented[rt.pattern]; ok {
+			require.Equal(t, op.Public, rt.access == Public, "%s is documented as public: %v", rt.pattern, op.Public)
+		}
+		if rt.access == Public {
+			continue
+		}
+
+		// Every other route turns away requests without a token, before
+		// the handler can reach the (missing) store
+		method, path, found := strings.Cut(rt.pattern, " ")
+		if !found {
+			method, path = http.MethodGet, rt.pattern
+		}
+		req := httptest.NewRequest(method, pathParamPattern.ReplaceAllString(path, uuid.NewString()), nil)
+		w := httptest.NewRecorder()
+		srv.mux.ServeHTTP(w, req)
+		require.Equal(t, http.StatusUnauthorized, w.Code, "%s is served without authentication", rt.pattern)
+	}
+
+	t.Run("CSRF exempt routes skip the check", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/auth/logout", strings.NewReader("{}"))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		require.NotEqual(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("Routes without a policy are refused", func(t *testing.T) {
+		handler := func(w http.ResponseWriter, r *http.Request) {}
+		require.Panics(t, func() { srv.guard(route{pattern: "GET /unguarded", handler: handler}) })
+		require.Panics(t, func() { srv.guard(route{pattern: "GET /things", handler: handler, access: OrgMember}) })
+	})
+}
+
+func TestMiddlewareChain(t *testing.T) {
+	// Every layer answers before a database would be needed
+	srv, err := NewServer(nil, newTestConfig(t))
+	require.NoError(t, err)
+
+	var logs bytes.Buffer
+	srv.logger = slog.New(NewContextLogHandler(slog.NewJSONHandler(&logs, nil)))
+
+	t.Run("CORS answers preflight requests", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodOptions, "/organizations", nil)
+		req.Header.Set("Origin", "http://localhost:3000")
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "http://localhost:3000", w.Header().Get("Access-Control-Allow-Origin"))
+	})
+
+	t.Run("Request ID and logging", func(t *testing.T) {
+		logs.Reset()
+		req := httptest.NewRequest(http.MethodGet, "/does-not-exist", nil)
+		req.Header.Set(RequestIDHeader, "chain-test")
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+
+		require.Equal(t, "chain-test", w.Header().Get(RequestIDHeader))
+		require.Equal(t, "DENY", w.Header().Get("X-Frame-Options"))
+
+		var entry map[string]interface{}
+		require.NoError(t, json.Unmarshal(logs.Bytes(), &entry))
+		require.Equal(t, "received request", entry["msg"])
+		require.Equal(t, "chain-test", entry["request_id"])
+	})
+
+	t.Run("Body limit rejects oversized requests", func(t *testing.T) {
+		body := bytes.Repeat([]byte("a"), MaxRequestBodyBytes+1)
+		req := httptest.NewRequest(http.MethodPost, "/organizations", bytes.NewReader(body))
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	})
+
+	t.Run("CSRF rejects requests without a token", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/organizations", strings.NewReader("{}"))
+		req.Header.Set("Authorization", "Bearer invalid")
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("Auth runs after CSRF", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/organizations", strings.NewReader("{}"))
+		addCSRFToken(t, srv, req)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("Unrouted requests skip CSRF", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/does-not-exist", nil))
+
+		require.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
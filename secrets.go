@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// A setting whose value is a secret reference is replaced by the secret it
+// names when the configuration is loaded:
+//
+//	aws-secretsmanager://<secret name or ARN>[#<JSON key>]
+//	gcp-secretmanager://projects/<project>/secrets/<secret>[/versions/<version>][#<JSON key>]
+//	vault://<path>#<field>
+//
+// The JSON key picks one value out of a secret holding a JSON object.
+const (
+	secretSchemeAWS   = "aws-secretsmanager"
+	secretSchemeGCP   = "gcp-secretmanager"
+	secretSchemeVault = "vault"
+)
+
+// secretsTimeout bounds fetching every secret at startup, or on a refresh
+const secretsTimeout = 30 * time.Second
+
+// secretRef names a secret held by a secrets manager
+type secretRef struct {
+	scheme string
+	name   string
+	key    string // JSON key or Vault field; empty for the whole secret
+}
+
+func (r secretRef) String() string {
+	s := r.scheme + "://" + r.name
+	if r.key != "" {
+		s += "#" + r.key
+	}
+	return s
+}
+
+// parseSecretRef parses value if it is a secret reference. URL parsing is
+// avoided because AWS ARNs are full of colons.
+func parseSecretRef(value string) (secretRef, bool) {
+	scheme, rest, ok := strings.Cut(value, "://")
+	if !ok {
+		return secretRef{}, false
+	}
+	switch scheme {
+	case secretSchemeAWS, secretSchemeGCP, secretSchemeVault:
+	default:
+		return secretRef{}, false
+	}
+	name, key, _ := strings.Cut(rest, "#")
+	return secretRef{scheme: scheme, name: name, key: key}, true
+}
+
+// secretProvider fetches secrets from one secrets manager
+type secretProvider interface {
+	fetch(ctx context.Context, ref secretRef) (string, error)
+}
+
+// secretSettings are settings whose secret references are resolved by the
+// secrets managers that hold them. The fetched values are kept, so lookups
+// stay cheap, and can be refreshed while the server runs.
+type secretSettings struct {
+	settings  Settings
+	refs      map[string]secretRef // setting name → its reference
+	providers map[string]secretProvider
+
+	mu     sync.RWMutex
+	values map[string]string
+}
+
+// newSecretSettings finds the secret references among the named settings
+func newSecretSettings(settings Settings, keys []string) *secretSettings {
+	s := &secretSettings{
+		settings:  settings,
+		refs:      map[string]secretRef{},
+		providers: newSecretProviders(settings),
+		values:    map[string]string{},
+	}
+	for _, key := range keys {
+		if ref, ok := parseSecretRef(settings(key)); ok {
+			s.refs[key] = ref
+		}
+	}
+	return s
+}
+
+// newSecretProviders configures a client for each secrets manager from the
+// plain settings; the credentials they use cannot be secrets themselves
+func newSecretProviders(settings Settings) map[string]secretProvider {
+	client := &http.Client{Timeout: 10 * time.Second}
+	return map[string]secretProvider{
+		secretSchemeAWS: &awsSecretsManager{
+			Region:      settings("AWS_REGION"),
+			Endpoint:    settings("AWS_ENDPOINT_URL_SECRETS_MANAGER"),
+			Credentials: awsCredentialsFromSettings(settings),
+			client:      client,
+		},
+		secretSchemeGCP: &gcpSecretManager{
+			Endpoint: "https://secretmanager.googleapis.com",
+			client:   client,
+		},
+		secretSchemeVault: &vaultSecrets{
+			Addr:      settings("VAULT_ADDR"),
+			Token:     settings("VAULT_TOKEN"),
+			Namespace: settings("VAULT_NAMESPACE"),
+			client:    client,
+		},
+	}
+}
+
+// resolve fetches every referenced secret, reporting every one that fails
+func (s *secretSettings) resolve(ctx context.Context) error {
+	keys := make([]string, 0, len(s.refs))
+	for key := range s.refs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	values := make(map[string]string, len(keys))
+	var errs []error
+	for _, key := range keys {
+		ref := s.refs[key]
+		value, err := s.providers[ref.scheme].fetch(ctx, ref)
+		if err == nil && ref.key != "" && ref.scheme != secretSchemeVault {
+			value, err = secretJSONKey(value, ref.key)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: fetching %s: %w", key, ref, err))
+			continue
+		}
+		values[key] = value
+	}
+
+	s.mu.Lock()
+	for key, value := range values {
+		s.values[key] = value
+	}
+	s.mu.Unlock()
+	return errors.Join(errs...)
+}
+
+// lookup is the Settings with secret references resolved
+func (s *secretSettings) lookup(key string) string {
+	if _, ok := s.refs[key]; !ok {
+		return s.settings(key)
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.values[key]
+}
+
+// refresh fetches the secrets again every interval. A secret that cannot be
+// fetched keeps its last value.
+func (s *secretSettings) refresh(interval time.Duration, logger *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), secretsTimeout)
+		if err := s.resolve(ctx); err != nil {
+			logger.Error("failed to refresh secrets", "error", err)
+		}
+		cancel()
+	}
+}
+
+// secretJSONKey picks key out of a secret holding a JSON object
+func secretJSONKey(secret, key string) (string, error) {
+	var fields map[string]any
+	if err := json.Unmarshal([]byte(secret), &fields); err != nil {
+		return "", errors.New("secret is not a JSON object")
+	}
+	value, ok := fields[key]
+	if !ok {
+		return "", fmt.Errorf("secret has no key %q", key)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	data, err := json.Marshal(value)
+	return string(data), err
+}
+
+// awsSecretsManager fetches secrets from AWS Secrets Manager with static
+// credentials
+type awsSecretsManager struct {
+	Region      string // used unless the secret is named by ARN
+	Endpoint    string // overrides https://secretsmanager.<region>.amazonaws.com
+	Credentials awsCredentials
+	client      *http.Client
+}
+
+func (p *awsSecretsManager) fetch(ctx context.Context, ref secretRef) (string, error) {
+	region := p.Region
+	// arn:aws:secretsmanager:<region>:<account>:secret:<name>
+	if parts := strings.Split(ref.name, ":"); len(parts) > 3 && parts[0] == "arn" {
+		region = parts[3]
+	}
+	if region == "" {
+		return "", errors.New("AWS_REGION is not set")
+	}
+	if p.Credentials.AccessKeyID == "" || p.Credentials.SecretAccessKey == "" {
+		return "", errors.New("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are not set")
+	}
+	endpoint := p.Endpoint
+	if endpoint == "" {
+		endpoint = "https://secretsmanager." + region + ".amazonaws.com"
+	}
+
+	payload, err := json.Marshal(map[string]string{"SecretId": ref.name})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(endpoint, "/")+"/", bytes.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	if err := signAWSRequest(ctx, req, payload, p.Credentials, region, "secretsmanager", time.Now()); err != nil {
+		return "", err
+	}
+
+	var secret struct {
+		SecretString string
+		SecretBinary []byte
+	}
+	if err := doSecretRequest(p.client, req, &secret); err != nil {
+		return "", err
+	}
+	if secret.SecretString != "" {
+		return secret.SecretString, nil
+	}
+	return string(secret.SecretBinary), nil
+}
+
+// gcpSecretManager fetches secrets from Google Cloud Secret Manager
+type gcpSecretManager struct {
+	Endpoint string
+	client   *http.Client
+
+	once   sync.Once
+	tokens oauth2.TokenSource // Application Default Credentials when nil
+	err    error
+}
+
+func (p *gcpSecretManager) fetch(ctx context.Context, ref secretRef) (string, error) {
+	p.once.Do(func() {
+		if p.tokens == nil {
+			p.tokens, p.err = google.DefaultTokenSource(context.Background(), "https://www.googleapis.com/auth/cloud-platform")
+		}
+	})
+	if p.err != nil {
+		return "", p.err
+	}
+	token, err := p.tokens.Token()
+	if err != nil {
+		return "", err
+	}
+
+	name := ref.name
+	if !strings.Contains(name, "/versions/") {
+		name += "/versions/latest"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(p.Endpoint, "/")+"/v1/"+name+":access", nil)
+	if err != nil {
+		return "", err
+	}
+	token.SetAuthHeader(req)
+
+	var version struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}
+	if err := doSecretRequest(p.client, req, &version); err != nil {
+		return "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(version.Payload.Data)
+	if err != nil {
+		return "", fmt.Errorf("invalid secret payload: %w", err)
+	}
+	return string(data), nil
+}
+
+// vaultSecrets reads fields of secrets in HashiCorp Vault's key/value
+// engine, either version, with a token
+type vaultSecrets struct {
+	Addr      string
+	Token     string
+	Namespace string
+	client    *http.Client
+}
+
+func (p *vaultSecrets) fetch(ctx context.Context, ref secretRef) (string, error) {
+	if p.Addr == "" || p.Token == "" {
+		return "", errors.New("VAULT_ADDR and VAULT_TOKEN are not set")
+	}
+	if ref.key == "" {
+		return "", errors.New("vault references need a #field")
+	}
+
+	u, err := url.Parse(strings.TrimSuffix(p.Addr, "/") + "/v1/" + strings.TrimPrefix(ref.name, "/"))
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", p.Token)
+	if p.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", p.Namespace)
+	}
+
+	var secret struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := doSecretRequest(p.client, req, &secret); err != nil {
+		return "", err
+	}
+	// Version 2 of the engine nests the fields beside their metadata
+	fields := secret.Data
+	if nested, ok := fields["data"].(map[string]any); ok {
+		if _, ok := fields["metadata"]; ok {
+			fields = nested
+		}
+	}
+
+	value, ok := fields[ref.key]
+	if !ok {
+		return "", fmt.Errorf("secret has no field %q", ref.key)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	data, err := json.Marshal(value)
+	return string(data), err
+}
+
+// doSecretRequest sends req and decodes a successful JSON response into v.
+// Error responses are reported with their status and a little of the body,
+// which never holds the secret.
+func doSecretRequest(client *http.Client, req *http.Request, v any) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+		return fmt.Errorf("responded with status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
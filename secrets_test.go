@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestParseSecretRef(t *testing.T) {
+	ref, ok := parseSecretRef("aws-secretsmanager://arn:aws:secretsmanager:eu-west-1:123456789012:secret:huachuca-AbCdEf#csrf_auth_key")
+	require.True(t, ok)
+	require.Equal(t, secretRef{
+		scheme: secretSchemeAWS,
+		name:   "arn:aws:secretsmanager:eu-west-1:123456789012:secret:huachuca-AbCdEf",
+		key:    "csrf_auth_key",
+	}, ref)
+
+	ref, ok = parseSecretRef("gcp-secretmanager://projects/acme/secrets/database-url")
+	require.True(t, ok)
+	require.Equal(t, "projects/acme/secrets/database-url", ref.name)
+	require.Empty(t, ref.key)
+
+	for _, value := range []string{"postgres://localhost/huachuca", "memory://", "s3://bucket", "plain"} {
+		_, ok := parseSecretRef(value)
+		require.False(t, ok, value)
+	}
+}
+
+func TestSecretProviders(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("AWS Secrets Manager", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, "secretsmanager.GetSecretValue", r.Header.Get("X-Amz-Target"))
+			require.True(t, strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/"))
+			require.Contains(t, r.Header.Get("Authorization"), "/eu-west-1/secretsmanager/aws4_request")
+
+			var body struct{ SecretId string }
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			if !strings.HasSuffix(body.SecretId, "huachuca") {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"__type":"ResourceNotFoundException"}`))
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{"SecretString": `{"csrf_auth_key":"csrf-secret","port":5432}`})
+		}))
+		defer server.Close()
+
+		p := &awsSecretsManager{
+			Region:      "us-east-1",
+			Endpoint:    server.URL,
+			Credentials: awsCredentials{AccessKeyID: "AKID", SecretAccessKey: "secret"},
+			client:      server.Client(),
+		}
+		ref, _ := parseSecretRef("aws-secretsmanager://arn:aws:secretsmanager:eu-west-1:123456789012:secret:huachuca")
+		value, err := p.fetch(ctx, ref)
+		require.NoError(t, err)
+		value, err = secretJSONKey(value, "csrf_auth_key")
+		require.NoError(t, err)
+		require.Equal(t, "csrf-secret", value)
+
+		_, err = p.fetch(ctx, secretRef{scheme: secretSchemeAWS, name: "arn:aws:secretsmanager:eu-west-1:1:secret:other"})
+		require.ErrorContains(t, err, "ResourceNotFoundException")
+
+		p.Credentials = awsCredentials{}
+		_, err = p.fetch(ctx, ref)
+		require.ErrorContains(t, err, "AWS_ACCESS_KEY_ID")
+	})
+
+	t.Run("Google Cloud Secret Manager", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, "Bearer gcp-token", r.Header.Get("Authorization"))
+			require.Equal(t, "/v1/projects/acme/secrets/database-url/versions/latest:access", r.URL.Path)
+			w.Write([]byte(`{"name":"projects/acme/secrets/database-url/versions/3","payload":{"data":"cG9zdGdyZXM6Ly9kYi9odWFjaHVjYQ=="}}`))
+		}))
+		defer server.Close()
+
+		p := &gcpSecretManager{
+			Endpoint: server.URL,
+			client:   server.Client(),
+			tokens:   oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "gcp-token", TokenType: "Bearer"}),
+		}
+		value, err := p.fetch(ctx, secretRef{scheme: secretSchemeGCP, name: "projects/acme/secrets/database-url"})
+		require.NoError(t, err)
+		require.Equal(t, "postgres://db/huachuca", value)
+	})
+
+	t.Run("Vault", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, "vault-token", r.Header.Get("X-Vault-Token"))
+			switch r.URL.Path {
+			case "/v1/secret/data/huachuca":
+				w.Write([]byte(`{"data":{"data":{"client_secret":"v2-secret"},"metadata":{"version":4}}}`))
+			case "/v1/kv/huachuca":
+				w.Write([]byte(`{"data":{"client_secret":"v1-secret"}}`))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"errors":[]}`))
+			}
+		}))
+		defer server.Close()
+
+		p := &vaultSecrets{Addr: server.URL, Token: "vault-token", client: server.Client()}
+		value, err := p.fetch(ctx, secretRef{scheme: secretSchemeVault, name: "secret/data/huachuca", key: "client_secret"})
+		require.NoError(t, err)
+		require.Equal(t, "v2-secret", value)
+
+		value, err = p.fetch(ctx, secretRef{scheme: secretSchemeVault, name: "kv/huachuca", key: "client_secret"})
+		require.NoError(t, err)
+		require.Equal(t, "v1-secret", value)
+
+		_, err = p.fetch(ctx, secretRef{scheme: secretSchemeVault, name: "kv/huachuca", key: "missing"})
+		require.ErrorContains(t, err, `no field "missing"`)
+		_, err = p.fetch(ctx, secretRef{scheme: secretSchemeVault, name: "kv/huachuca"})
+		require.ErrorContains(t, err, "#field")
+		_, err = p.fetch(ctx, secretRef{scheme: secretSchemeVault, name: "kv/other", key: "client_secret"})
+		require.ErrorContains(t, err, "status 404")
+	})
+}
+
+func TestLoadConfigSecrets(t *testing.T) {
+	var csrfKey atomic.Value
+	csrfKey.Store("first-key")
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{
+			"data":     map[string]string{"database_url": "memory://from-vault", "csrf_auth_key": csrfKey.Load().(string)},
+			"metadata": map[string]any{},
+		}})
+	}))
+	defer vault.Close()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	environ := []string{
+		"ENVIRONMENT=development",
+		"VAULT_ADDR=" + vault.URL,
+		"VAULT_TOKEN=token",
+		"DATABASE_URL=vault://secret/data/huachuca#database_url",
+		"CSRF_AUTH_KEY=vault://secret/data/huachuca#csrf_auth_key",
+		"JWT_PRIVATE_KEY=" + string(pemKey),
+	}
+	config, err := LoadConfig("", environ)
+	require.NoError(t, err)
+	require.Equal(t, "memory://from-vault", config.DatabaseURL)
+	require.Equal(t, "first-key", config.CSRF.AuthKey)
+	require.Equal(t, "memory://from-vault", config.DB.Credentials("DATABASE_URL"))
+	require.True(t, key.Equal(config.JWTKey))
+
+	// Refreshing picks up rotated secrets for settings looked up again
+	csrfKey.Store("second-key")
+	require.NoError(t, config.secrets.resolve(context.Background()))
+	require.Equal(t, "second-key", config.Settings("CSRF_AUTH_KEY"))
+
+	t.Run("Secrets that cannot be fetched stop the server starting", func(t *testing.T) {
+		_, err := LoadConfig("", append(environ, "GOOGLE_CLIENT_SECRET=vault://secret/data/huachuca#client_secret"))
+		require.ErrorContains(t, err, "GOOGLE_CLIENT_SECRET: fetching vault://secret/data/huachuca#client_secret")
+	})
+
+	t.Run("Invalid JWT keys are reported", func(t *testing.T) {
+		_, err := LoadConfig("", []string{"ENVIRONMENT=development", "JWT_PRIVATE_KEY=not a key"})
+		require.ErrorContains(t, err, "invalid JWT_PRIVATE_KEY")
+	})
+}
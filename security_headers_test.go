@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "headers.json")
+	require.NoError(t, os.WriteFile(file, []byte(`{
+		"referrer_policy": "same-origin",
+		"permissions_policy": "camera=()",
+		"routes": {
+			"/docs": {"referrer_policy": "strict-origin"}
+		}
+	}`), 0o600))
+	t.Setenv("SECURITY_HEADERS_FILE", file)
+	t.Setenv("SECURITY_PERMISSIONS_POLICY", "geolocation=()")
+
+	config, err := NewSecurityHeadersConfig(os.Getenv)
+	require.NoError(t, err)
+	handler := SecurityHeadersMiddleware(config)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name     string
+		path     string
+		expected map[string]string
+	}{
+		{
+			name: "Defaults with file and environment overrides",
+			path: "/organizations",
+			expected: map[string]string{
+				"Content-Security-Policy":   "default-src 'none'; frame-ancestors 'none'",
+				"Strict-Transport-Security": "max-age=63072000; includeSubDomains",
+				"Referrer-Policy":           "same-origin",
+				"Permissions-Policy":        "geolocation=()",
+				"X-Frame-Options":           "DENY",
+				"X-Content-Type-Options":    "nosniff",
+			},
+		},
+		{
+			name: "Docs route override",
+			path: "/docs",
+			expected: map[string]string{
+				"Content-Security-Policy": docsContentSecurityPolicy,
+				"Referrer-Policy":         "strict-origin",
+				"Permissions-Policy":      "geolocation=()",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			for header, value := range tt.expected {
+				require.Equal(t, value, w.Header().Get(header), header)
+			}
+		})
+	}
+
+	t.Run("Invalid file", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(file, []byte(`{not json`), 0o600))
+		_, err := NewSecurityHeadersConfig(os.Getenv)
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// ServerConfig holds options for the HTTP listener and response encoding
+type ServerConfig struct {
+	// Addr is where plain HTTP is served unless the server terminates TLS:
+	// host:port, or unix:<path> for a Unix socket, as for a sidecar proxy
+	Addr            string
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration // raised to fit the longest request budget
+	IdleTimeout     time.Duration
+	MaxHeaderBytes  int
+	ShutdownTimeout time.Duration // how long in-flight requests get to finish
+
+	Compression        bool // gzip/br-encode JSON responses for clients that accept it
+	CompressionMinSize int  // responses smaller than this many bytes are sent as is
+	HTTP2              bool // negotiate HTTP/2 over TLS
+	// H2C accepts cleartext HTTP/2. Only enable it behind a trusted proxy that
+	// terminates TLS and speaks HTTP/2 to the backend.
+	H2C bool
+
+	// TLS is served on TLSAddr with either a certificate and key from disk or
+	// certificates obtained from Let's Encrypt for AutocertHosts
+	TLSAddr          string
+	TLSCertFile      string
+	TLSKeyFile       string
+	AutocertHosts    []string
+	AutocertEmail    string
+	AutocertCacheDir string
+	// RedirectAddr serves HTTP→HTTPS redirects, and ACME http-01 challenges
+	// when autocert is enabled. Empty disables the redirect listener.
+	RedirectAddr string
+
+	// RequestTimeout is the default deadline for handling a request.
+	// RouteTimeouts overrides it for paths under a prefix, with the longest
+	// matching prefix winning; zero disables the deadline.
+	RequestTimeout time.Duration
+	RouteTimeouts  map[string]time.Duration
+}
+
+// NewServerConfig creates a server configuration from settings
+func NewServerConfig(settings Settings) (*ServerConfig, error) {
+	minSize, err := strconv.Atoi(settings.get("COMPRESSION_MIN_SIZE", "1024"))
+	if err != nil || minSize < 0 {
+		minSize = 1024
+	}
+
+	requestTimeout, err := time.ParseDuration(settings.get("REQUEST_TIMEOUT", "8s"))
+	if err != nil || requestTimeout < 0 {
+		return nil, fmt.Errorf("invalid REQUEST_TIMEOUT %q", settings("REQUEST_TIMEOUT"))
+	}
+	routeTimeouts, err := parseRouteTimeouts(settings("ROUTE_TIMEOUTS"))
+	if err != nil {
+		return nil, err
+	}
+
+	// PORT is what platforms such as Cloud Run and Heroku set
+	addr := settings("LISTEN_ADDR")
+	if addr == "" {
+		addr = ":" + settings.get("PORT", "8080")
+	}
+	timeouts := map[string]time.Duration{
+		"READ_TIMEOUT":     10 * time.Second,
+		"WRITE_TIMEOUT":    10 * time.Second,
+		"IDLE_TIMEOUT":     60 * time.Second,
+		"SHUTDOWN_TIMEOUT": 30 * time.Second,
+	}
+	for key, defaultValue := range timeouts {
+		d, err := time.ParseDuration(settings.get(key, defaultValue.String()))
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid %s %q", key, settings(key))
+		}
+		timeouts[key] = d
+	}
+	maxHeaderBytes, err := strconv.Atoi(settings.get("MAX_HEADER_BYTES", strconv.Itoa(http.DefaultMaxHeaderBytes)))
+	if err != nil || maxHeaderBytes <= 0 {
+		return nil, fmt.Errorf("invalid MAX_HEADER_BYTES %q", settings("MAX_HEADER_BYTES"))
+	}
+
+	config := &ServerConfig{
+		Addr:               addr,
+		ReadTimeout:        timeouts["READ_TIMEOUT"],
+		WriteTimeout:       timeouts["WRITE_TIMEOUT"],
+		IdleTimeout:        timeouts["IDLE_TIMEOUT"],
+		MaxHeaderBytes:     maxHeaderBytes,
+		ShutdownTimeout:    timeouts["SHUTDOWN_TIMEOUT"],
+		Compression:        settings.getBool("COMPRESSION_ENABLED", true),
+		CompressionMinSize: minSize,
+		HTTP2:              settings.getBool("HTTP2_ENABLED", true),
+		H2C:                settings.getBool("HTTP2_H2C", false),
+		TLSAddr:            settings.get("TLS_ADDR", ":8443"),
+		TLSCertFile:        settings("TLS_CERT_FILE"),
+		TLSKeyFile:         settings("TLS_KEY_FILE"),
+		AutocertHosts:      splitList(settings("TLS_AUTOCERT_HOSTS")),
+		AutocertEmail:      settings("TLS_AUTOCERT_EMAIL"),
+		AutocertCacheDir:   settings.get("TLS_AUTOCERT_CACHE_DIR", "autocert-cache"),
+		RequestTimeout:     requestTimeout,
+		RouteTimeouts:      routeTimeouts,
+	}
+	if settings.getBool("TLS_REDIRECT_HTTP", true) {
+		config.RedirectAddr = ":8080"
+	}
+
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// validate checks the listen address and that at most one certificate
+// source is configured
+func (c *ServerConfig) validate() error {
+	if path, ok := c.unixSocket(); ok {
+		if path == "" {
+			return fmt.Errorf("invalid LISTEN_ADDR %q: expected unix:<path>", c.Addr)
+		}
+	} else if _, _, err := net.SplitHostPort(c.Addr); err != nil {
+		return fmt.Errorf("invalid LISTEN_ADDR %q: expected host:port or unix:<path>", c.Addr)
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if c.TLSCertFile != "" && len(c.AutocertHosts) > 0 {
+		return errors.New("TLS_CERT_FILE and TLS_AUTOCERT_HOSTS are mutually exclusive")
+	}
+	return nil
+}
+
+// TLSEnabled reports whether the server terminates TLS itself
+func (c *ServerConfig) TLSEnabled() bool {
+	return c.TLSCertFile != "" || len(c.AutocertHosts) > 0
+}
+
+// newTLSConfig returns TLS 1.2+ settings restricted to AEAD cipher suites
+// with forward secrecy. TLS 1.3 suites are not configurable and already
+// meet that bar.
+func newTLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:       tls.VersionTLS12,
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		},
+	}
+}
+
+// NewHTTPServers creates the http.Server that serves handler and, when TLS
+// is enabled with a redirect address, a second server that sends plain HTTP
+// clients to HTTPS
+func NewHTTPServers(config *ServerConfig, handler http.Handler) (server, redirect *http.Server) {
+	if config.H2C {
+		handler = h2c.NewHandler(handler, &http2.Server{})
+	}
+
+	server = &http.Server{
+		Addr:           config.Addr,
+		Handler:        handler,
+		ReadTimeout:    config.ReadTimeout,
+		WriteTimeout:   config.writeTimeout(),
+		IdleTimeout:    config.IdleTimeout,
+		MaxHeaderBytes: config.MaxHeaderBytes,
+	}
+	if !config.HTTP2 {
+		// A non-nil empty map turns off the automatic HTTP/2 upgrade over TLS
+		server.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+	}
+	if !config.TLSEnabled() {
+		return server, nil
+	}
+
+	server.Addr = config.TLSAddr
+	server.TLSConfig = newTLSConfig()
+
+	var redirectHandler http.Handler = redirectToHTTPS(config.TLSAddr)
+	if len(config.AutocertHosts) > 0 {
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(config.AutocertHosts...),
+			Cache:      autocert.DirCache(config.AutocertCacheDir),
+			Email:      config.AutocertEmail,
+		}
+		server.TLSConfig.GetCertificate = manager.GetCertificate
+		// Answer tls-alpn-01 challenges; net/http adds h2 and http/1.1
+		server.TLSConfig.NextProtos = []string{acme.ALPNProto}
+		redirectHandler = manager.HTTPHandler(redirectHandler)
+	}
+
+	if config.RedirectAddr == "" {
+		return server, nil
+	}
+	return server, &http.Server{
+		Addr:         config.RedirectAddr,
+		Handler:      redirectHandler,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 5 * time.Second,
+		IdleTimeout:  60 * time.Second,
+	}
+}
+
+// unixSocket returns the socket path if Addr names a Unix socket
+func (c *ServerConfig) unixSocket() (string, bool) {
+	return strings.CutPrefix(c.Addr, "unix:")
+}
+
+// ListenAndServe starts server with TLS if the configuration enables it,
+// or otherwise on Addr, which may be a Unix socket
+func (c *ServerConfig) ListenAndServe(server *http.Server) error {
+	if c.TLSEnabled() {
+		// With autocert both paths are empty and GetCertificate is used
+		return server.ListenAndServeTLS(c.TLSCertFile, c.TLSKeyFile)
+	}
+	path, ok := c.unixSocket()
+	if !ok {
+		return server.ListenAndServe()
+	}
+
+	// A socket left behind by a process that did not shut down cleanly
+	// would make Listen fail
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	// Readable and writable by the group, which a sidecar can share
+	if err := os.Chmod(path, 0o660); err != nil {
+		ln.Close()
+		return err
+	}
+	return server.Serve(ln)
+}
+
+// redirectToHTTPS permanently redirects requests to the same URL over HTTPS
+// on the port of tlsAddr
+func redirectToHTTPS(tlsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(tlsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+
+		// 308 keeps the method and body of non-GET requests
+		status := http.StatusPermanentRedirect
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			status = http.StatusMovedPermanently
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), status)
+	})
+}
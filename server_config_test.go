@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewServerConfig(t *testing.T) {
+	t.Setenv("COMPRESSION_MIN_SIZE", "2048")
+	t.Setenv("HTTP2_ENABLED", "false")
+	t.Setenv("HTTP2_H2C", "true")
+
+	config, err := NewServerConfig(os.Getenv)
+	require.NoError(t, err)
+	require.True(t, config.Compression)
+	require.Equal(t, 2048, config.CompressionMinSize)
+	require.False(t, config.HTTP2)
+	require.True(t, config.H2C)
+	require.False(t, config.TLSEnabled())
+
+	handler := http.NotFoundHandler()
+	server, redirect := NewHTTPServers(config, handler)
+	require.NotNil(t, server.TLSNextProto, "HTTP/2 over TLS is disabled")
+	require.NotEqual(t, "http.HandlerFunc", fmt.Sprintf("%T", server.Handler), "h2c wraps the handler")
+	require.Nil(t, redirect, "no redirect listener without TLS")
+
+	server, _ = NewHTTPServers(&ServerConfig{HTTP2: true}, handler)
+	require.Nil(t, server.TLSNextProto)
+
+	server, _ = NewHTTPServers(config, handler)
+	require.Equal(t, ":8080", server.Addr)
+	require.Equal(t, 10*time.Second, server.ReadTimeout)
+	require.Equal(t, 60*time.Second, server.IdleTimeout)
+	require.Equal(t, http.DefaultMaxHeaderBytes, server.MaxHeaderBytes)
+	require.Equal(t, 30*time.Second, config.ShutdownTimeout)
+
+	t.Run("Listener settings", func(t *testing.T) {
+		t.Setenv("PORT", "9000")
+		config, err := NewServerConfig(os.Getenv)
+		require.NoError(t, err)
+		require.Equal(t, ":9000", config.Addr)
+
+		t.Setenv("LISTEN_ADDR", "127.0.0.1:7000")
+		t.Setenv("WRITE_TIMEOUT", "30s")
+		t.Setenv("MAX_HEADER_BYTES", "16384")
+		t.Setenv("SHUTDOWN_TIMEOUT", "5s")
+		config, err = NewServerConfig(os.Getenv)
+		require.NoError(t, err)
+		server, _ := NewHTTPServers(config, handler)
+		require.Equal(t, "127.0.0.1:7000", server.Addr, "LISTEN_ADDR wins over PORT")
+		require.Equal(t, 30*time.Second, server.WriteTimeout)
+		require.Equal(t, 16384, server.MaxHeaderBytes)
+		require.Equal(t, 5*time.Second, config.ShutdownTimeout)
+
+		for key, value := range map[string]string{
+			"LISTEN_ADDR":      "localhost",
+			"IDLE_TIMEOUT":     "0s",
+			"READ_TIMEOUT":     "soon",
+			"MAX_HEADER_BYTES": "-1",
+		} {
+			t.Run(key, func(t *testing.T) {
+				t.Setenv(key, value)
+				_, err := NewServerConfig(os.Getenv)
+				require.ErrorContains(t, err, "invalid "+key)
+			})
+		}
+	})
+
+	t.Run("Invalid TLS configuration", func(t *testing.T) {
+		t.Setenv("TLS_CERT_FILE", "/etc/huachuca/tls.crt")
+		_, err := NewServerConfig(os.Getenv)
+		require.Error(t, err, "key file missing")
+
+		t.Setenv("TLS_KEY_FILE", "/etc/huachuca/tls.key")
+		t.Setenv("TLS_AUTOCERT_HOSTS", "api.example.com")
+		_, err = NewServerConfig(os.Getenv)
+		require.Error(t, err, "certificate and autocert together")
+	})
+}
+
+func TestTLSServers(t *testing.T) {
+	config := &ServerConfig{
+		HTTP2:            true,
+		TLSAddr:          ":8443",
+		AutocertHosts:    []string{"api.example.com"},
+		AutocertCacheDir: t.TempDir(),
+		RedirectAddr:     ":8080",
+	}
+
+	server, redirect := NewHTTPServers(config, http.NotFoundHandler())
+	require.Equal(t, ":8443", server.Addr)
+	require.NotNil(t, server.TLSConfig.GetCertificate)
+	require.NotNil(t, redirect)
+	require.Equal(t, ":8080", redirect.Addr)
+
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		status   int
+		location string
+	}{
+		{"GET", http.MethodGet, "http://api.example.com:8080/organizations?limit=5", http.StatusMovedPermanently, "https://api.example.com:8443/organizations?limit=5"},
+		{"POST keeps method", http.MethodPost, "http://api.example.com/auth/refresh", http.StatusPermanentRedirect, "https://api.example.com:8443/auth/refresh"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			redirect.Handler.ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, nil))
+			require.Equal(t, tt.status, w.Code)
+			require.Equal(t, tt.location, w.Header().Get("Location"))
+		})
+	}
+
+	t.Run("Standard port is omitted", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		redirectToHTTPS(":443").ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://api.example.com/health", nil))
+		require.Equal(t, "https://api.example.com/health", w.Header().Get("Location"))
+	})
+}
+
+func TestUnixSocketListener(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "huachuca.sock")
+	// A socket left behind by an earlier process is replaced
+	require.NoError(t, os.WriteFile(path, nil, 0o600))
+
+	t.Setenv("LISTEN_ADDR", "unix:"+path)
+	config, err := NewServerConfig(os.Getenv)
+	require.NoError(t, err)
+	server, _ := NewHTTPServers(config, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+
+	errs := make(chan error, 1)
+	go func() { errs <- config.ListenAndServe(server) }()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	require.Eventually(t, func() bool {
+		resp, err := client.Get("http://huachuca/health")
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	}, 2*time.Second, 10*time.Millisecond)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o660), info.Mode().Perm())
+
+	require.NoError(t, server.Shutdown(context.Background()))
+	require.ErrorIs(t, <-errs, http.ErrServerClosed)
+	_, err = os.Stat(path)
+	require.ErrorIs(t, err, os.ErrNotExist, "the socket is removed on shutdown")
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Session defaults, unless ACCESS_TOKEN_TTL and SESSION_IDLE_TIMEOUT say
+// otherwise
+const (
+	DefaultAccessTokenTTL     = 15 * time.Minute
+	DefaultSessionIdleTimeout = 7 * 24 * time.Hour
+)
+
+// DefaultSessionTerms are the terms of a session when neither the platform
+// nor the organization changes them
+var DefaultSessionTerms = SessionTerms{
+	AccessTokenTTL: DefaultAccessTokenTTL,
+	IdleTimeout:    DefaultSessionIdleTimeout,
+	SingleSession:  true,
+}
+
+// SessionConfig holds the platform's session defaults and the bounds within
+// which organizations may set their own
+type SessionConfig struct {
+	AccessTokenTTL    time.Duration // how long access tokens last
+	MinAccessTokenTTL time.Duration // the shortest an organization may set
+	MaxAccessTokenTTL time.Duration // the longest an organization may set
+	IdleTimeout       time.Duration // a session not refreshed for this long ends
+	MaxLifetime       time.Duration // a session ends this long after sign-in; 0 never
+}
+
+// NewSessionConfig creates a session configuration from settings
+func NewSessionConfig(settings Settings) (*SessionConfig, error) {
+	config := &SessionConfig{}
+	for _, d := range []struct {
+		key, fallback string
+		value         *time.Duration
+	}{
+		{"ACCESS_TOKEN_TTL", DefaultAccessTokenTTL.String(), &config.AccessTokenTTL},
+		{"ACCESS_TOKEN_MIN_TTL", "1m", &config.MinAccessTokenTTL},
+		{"ACCESS_TOKEN_MAX_TTL", "1h", &config.MaxAccessTokenTTL},
+		{"SESSION_IDLE_TIMEOUT", DefaultSessionIdleTimeout.String(), &config.IdleTimeout},
+		{"SESSION_MAX_LIFETIME", "0", &config.MaxLifetime},
+	} {
+		value, err := time.ParseDuration(settings.get(d.key, d.fallback))
+		if err != nil || value < 0 {
+			return nil, fmt.Errorf("invalid %s %q", d.key, settings(d.key))
+		}
+		*d.value = value
+	}
+
+	if config.MinAccessTokenTTL <= 0 || config.AccessTokenTTL < config.MinAccessTokenTTL || config.AccessTokenTTL > config.MaxAccessTokenTTL {
+		return nil, fmt.Errorf("ACCESS_TOKEN_TTL %s must be within ACCESS_TOKEN_MIN_TTL %s and ACCESS_TOKEN_MAX_TTL %s, which must be positive",
+			config.AccessTokenTTL, config.MinAccessTokenTTL, config.MaxAccessTokenTTL)
+	}
+	if config.IdleTimeout <= 0 {
+		return nil, fmt.Errorf("invalid SESSION_IDLE_TIMEOUT %q: must be positive", settings("SESSION_IDLE_TIMEOUT"))
+	}
+	return config, nil
+}
+
+// SessionPolicy is an organization's own session rules. Rules left unset
+// follow the platform's.
+type SessionPolicy struct {
+	// AccessTokenTTL is how long access tokens last, within the platform's
+	// bounds
+	AccessTokenTTL *Duration `json:"access_token_ttl,omitempty"`
+	// RefreshTokenTTL ends sessions this long after sign-in, however often
+	// they are refreshed. It can only be shorter than the platform's limit.
+	RefreshTokenTTL *Duration `json:"refresh_token_ttl,omitempty"`
+	// IdleTimeout ends sessions not refreshed for this long. It can only be
+	// shorter than the platform's.
+	IdleTimeout *Duration `json:"idle_timeout,omitempty"`
+	// SingleSession signs members out of their other sessions when they
+	// sign in; it is on unless set to false
+	SingleSession *bool `json:"single_session,omitempty"`
+}
+
+// ValidateSessionPolicy checks policy against the platform's bounds
+func ValidateSessionPolicy(policy *SessionPolicy, config *SessionConfig) error {
+	var errs []error
+	if d := policy.AccessTokenTTL; d != nil && (time.Duration(*d) < config.MinAccessTokenTTL || time.Duration(*d) > config.MaxAccessTokenTTL) {
+		errs = append(errs, &ValidationError{Field: "sessions.access_token_ttl",
+			Message: fmt.Sprintf("must be from %s to %s", config.MinAccessTokenTTL, config.MaxAccessTokenTTL)})
+	}
+	if d := policy.RefreshTokenTTL; d != nil && (*d <= 0 || config.MaxLifetime > 0 && time.Duration(*d) > config.MaxLifetime) {
+		message := "must be positive"
+		if config.MaxLifetime > 0 {
+			message = fmt.Sprintf("must be positive and at most %s", config.MaxLifetime)
+		}
+		errs = append(errs, &ValidationError{Field: "sessions.refresh_token_ttl", Message: message})
+	}
+	if d := policy.IdleTimeout; d != nil && (*d <= 0 || time.Duration(*d) > config.IdleTimeout) {
+		errs = append(errs, &ValidationError{Field: "sessions.idle_timeout",
+			Message: fmt.Sprintf("must be positive and at most %s", config.IdleTimeout)})
+	}
+	return joinValidationErrors(errs...)
+}
+
+// SessionTerms are the session rules in force for a member
+type SessionTerms struct {
+	AccessTokenTTL time.Duration
+	IdleTimeout    time.Duration
+	Lifetime       time.Duration // 0 for sessions that only end when idle
+	SingleSession  bool
+}
+
+// Terms applies an organization's policy, which may be nil, to the
+// platform's defaults
+func (c *SessionConfig) Terms(policy *SessionPolicy) SessionTerms {
+	terms := SessionTerms{
+		AccessTokenTTL: c.AccessTokenTTL,
+		IdleTimeout:    c.IdleTimeout,
+		Lifetime:       c.MaxLifetime,
+		SingleSession:  true,
+	}
+	if policy == nil {
+		return terms
+	}
+	if policy.AccessTokenTTL != nil {
+		terms.AccessTokenTTL = time.Duration(*policy.AccessTokenTTL)
+	}
+	if policy.RefreshTokenTTL != nil {
+		terms.Lifetime = time.Duration(*policy.RefreshTokenTTL)
+	}
+	if policy.IdleTimeout != nil {
+		terms.IdleTimeout = time.Duration(*policy.IdleTimeout)
+	}
+	if policy.SingleSession != nil {
+		terms.SingleSession = *policy.SingleSession
+	}
+	return terms
+}
+
+// sessionEnd returns when a session started at now ends regardless of
+// refreshes, or nil if it only ends when idle
+func (t SessionTerms) sessionEnd(now time.Time) *time.Time {
+	if t.Lifetime <= 0 {
+		return nil
+	}
+	end := now.Add(t.Lifetime)
+	return &end
+}
+
+// refreshExpiry returns when a refresh token issued at now, in a session
+// ending at end, lapses unless it is used
+func (t SessionTerms) refreshExpiry(now time.Time, end *time.Time) time.Time {
+	expires := now.Add(t.IdleTimeout)
+	if end != nil && end.Before(expires) {
+		return *end
+	}
+	return expires
+}
+
+// sessionTerms returns the session terms for user, under their
+// organization's policy
+func (s *Server) sessionTerms(ctx context.Context, user *User) (SessionTerms, error) {
+	settings, _, err := s.store.GetOrganizationSettings(ctx, user.OrganizationID)
+	if err != nil {
+		return SessionTerms{}, err
+	}
+	return s.sessions.Terms(settings.Sessions), nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSessionConfig(t *testing.T) {
+	config, err := NewSessionConfig(func(string) string { return "" })
+	require.NoError(t, err)
+	require.Equal(t, DefaultSessionTerms, config.Terms(nil))
+
+	for key, value := range map[string]string{
+		"ACCESS_TOKEN_TTL":     "2h",
+		"ACCESS_TOKEN_MIN_TTL": "0",
+		"SESSION_IDLE_TIMEOUT": "0",
+		"SESSION_MAX_LIFETIME": "forever",
+	} {
+		_, err := NewSessionConfig(func(k string) string {
+			return map[string]string{key: value}[k]
+		})
+		require.Error(t, err, key)
+	}
+}
+
+func TestValidateSessionPolicy(t *testing.T) {
+	config, err := NewSessionConfig(func(k string) string {
+		return map[string]string{"SESSION_MAX_LIFETIME": "720h"}[k]
+	})
+	require.NoError(t, err)
+	duration := func(d time.Duration) *Duration { v := Duration(d); return &v }
+
+	require.NoError(t, ValidateSessionPolicy(&SessionPolicy{
+		AccessTokenTTL:  duration(5 * time.Minute),
+		RefreshTokenTTL: duration(24 * time.Hour),
+		IdleTimeout:     duration(time.Hour),
+	}, config))
+
+	err = ValidateSessionPolicy(&SessionPolicy{
+		AccessTokenTTL:  duration(2 * time.Hour),
+		RefreshTokenTTL: duration(1000 * time.Hour),
+		IdleTimeout:     duration(8 * 24 * time.Hour),
+	}, config)
+	var errs ValidationErrors
+	require.ErrorAs(t, err, &errs)
+	require.Len(t, errs, 3, "orgs can only tighten the platform's limits")
+}
+
+func TestSessionPolicy(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	srv, err := NewServer(store, newTestConfig(t))
+	require.NoError(t, err)
+
+	org, err := store.CreateOrganization(ctx, "Acme", "owner@acme.test", "Owner")
+	require.NoError(t, err)
+	owner, err := store.GetUser(ctx, org.OwnerID)
+	require.NoError(t, err)
+	admin, err := store.AddUserToOrganization(ctx, org.ID, "admin@acme.test", "Admin")
+	require.NoError(t, err)
+	_, err = store.UpdateUserRole(ctx, org.ID, admin.ID, "admin")
+	require.NoError(t, err)
+	admin, err = store.GetUser(ctx, admin.ID)
+	require.NoError(t, err)
+
+	putSessions := func(user *User, policy string) *httptest.ResponseRecorder {
+		_, version, err := store.GetOrganizationSettings(ctx, org.ID)
+		require.NoError(t, err)
+		token, err := srv.tokenManager.GenerateToken(user)
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPut, "/organizations/"+org.ID.String()+"/settings",
+			strings.NewReader(`{"allowed_origins":[],"sessions":`+policy+`}`))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("If-Match", versionETag(version))
+		addCSRFToken(t, srv, req)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return w
+	}
+	refresh := func(refreshToken string) (*httptest.ResponseRecorder, TokenResponse) {
+		req := httptest.NewRequest(http.MethodPost, "/auth/refresh", strings.NewReader(`{"refresh_token":"`+refreshToken+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		var tokens TokenResponse
+		if w.Code == http.StatusOK {
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&tokens))
+		}
+		return w, tokens
+	}
+
+	policy := `{"access_token_ttl":"5m","refresh_token_ttl":"1h","single_session":false}`
+	require.Equal(t, http.StatusForbidden, putSessions(admin, policy).Code, "only the owner sets the session policy")
+	require.Equal(t, http.StatusBadRequest, putSessions(owner, `{"access_token_ttl":"1s"}`).Code)
+	w := putSessions(owner, policy)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	terms, err := srv.sessionTerms(ctx, owner)
+	require.NoError(t, err)
+	require.Equal(t, SessionTerms{AccessTokenTTL: 5 * time.Minute, IdleTimeout: DefaultSessionIdleTimeout, Lifetime: time.Hour}, terms)
+
+	// Members may hold several sessions, each ending an hour after sign-in
+	first, err := store.CreateRefreshToken(ctx, owner.ID, terms)
+	require.NoError(t, err)
+	second, err := store.CreateRefreshToken(ctx, owner.ID, terms)
+	require.NoError(t, err)
+	sessions, err := store.ListUserRefreshTokens(ctx, owner.ID)
+	require.NoError(t, err)
+	require.Len(t, sessions, 2)
+	end := *sessions[0].SessionExpiresAt
+	require.WithinDuration(t, time.Now().Add(time.Hour), end, time.Minute)
+
+	w, tokens := refresh(first)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.Equal(t, 300, tokens.ExpiresIn)
+	claims, err := srv.tokenManager.ValidateToken(tokens.AccessToken)
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now().Add(5*time.Minute), claims.ExpiresAt.Time, time.Minute)
+
+	w, _ = refresh(first)
+	require.Equal(t, http.StatusUnauthorized, w.Code, "refresh tokens are used once")
+	w, _ = refresh(second)
+	require.Equal(t, http.StatusOK, w.Code, "the other session is untouched")
+
+	sessions, err = store.ListUserRefreshTokens(ctx, owner.ID)
+	require.NoError(t, err)
+	for _, session := range sessions {
+		require.False(t, session.ExpiresAt.After(end), "refreshing does not extend a session past its end")
+	}
+}
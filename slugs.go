@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	ErrSlugTaken    = errors.New("organization slug already taken")
+	ErrSlugReserved = errors.New("organization slug is reserved")
+)
+
+// reservedSlugs are names the service's own pages and hosts use, which
+// organizations cannot take once slugs appear in vanity URLs.
+// ORG_RESERVED_SLUGS adds to them.
+var reservedSlugs = []string{
+	"about", "account", "admin", "api", "app", "assets", "auth", "billing",
+	"blog", "dashboard", "docs", "graphql", "health", "help", "huachuca",
+	"login", "logout", "mail", "metrics", "oauth", "oidc", "root", "settings",
+	"signup", "static", "status", "support", "system", "www",
+}
+
+// slugPattern is a DNS label: lowercase letters, digits and inner hyphens
+var slugPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+const (
+	MinSlugLength = 3
+	MaxSlugLength = 63
+)
+
+// SlugConfig sets which organization slugs are reserved
+type SlugConfig struct {
+	Reserved map[string]bool
+}
+
+// NewSlugConfig creates a slug configuration from settings
+func NewSlugConfig(settings Settings) (*SlugConfig, error) {
+	config := &SlugConfig{Reserved: make(map[string]bool)}
+	for _, slug := range reservedSlugs {
+		config.Reserved[slug] = true
+	}
+	for _, slug := range splitList(settings("ORG_RESERVED_SLUGS")) {
+		slug = strings.ToLower(slug)
+		if ValidateSlug(slug) != nil {
+			return nil, fmt.Errorf("invalid ORG_RESERVED_SLUGS entry %q", slug)
+		}
+		config.Reserved[slug] = true
+	}
+	return config, nil
+}
+
+// ValidateSlug checks the form of an organization slug, which is used in
+// URLs and so must be a lowercase DNS label
+func ValidateSlug(slug string) error {
+	if len(slug) < MinSlugLength || len(slug) > MaxSlugLength {
+		return &ValidationError{Field: "slug", Message: fmt.Sprintf("must be %d to %d characters", MinSlugLength, MaxSlugLength)}
+	}
+	if !slugPattern.MatchString(slug) {
+		return &ValidationError{Field: "slug", Message: "may only contain lowercase letters, digits and hyphens, and cannot start or end with a hyphen"}
+	}
+	return nil
+}
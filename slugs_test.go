@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateSlug(t *testing.T) {
+	for _, slug := range []string{"acme", "acme-corp", "a1b", strings.Repeat("a", MaxSlugLength)} {
+		require.NoError(t, ValidateSlug(slug), slug)
+	}
+	for _, slug := range []string{"ab", "Acme", "-acme", "acme-", "acme.corp", "acme corp", strings.Repeat("a", MaxSlugLength+1)} {
+		require.Error(t, ValidateSlug(slug), slug)
+	}
+
+	config, err := NewSlugConfig(func(key string) string {
+		return map[string]string{"ORG_RESERVED_SLUGS": "Partners, careers"}[key]
+	})
+	require.NoError(t, err)
+	require.True(t, config.Reserved["admin"])
+	require.True(t, config.Reserved["partners"])
+
+	_, err = NewSlugConfig(func(key string) string {
+		return map[string]string{"ORG_RESERVED_SLUGS": "not a slug"}[key]
+	})
+	require.Error(t, err)
+}
+
+func TestCreateOrganizationSlug(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	srv, err := NewServer(store, newTestConfig(t))
+	require.NoError(t, err)
+
+	org, err := store.CreateOrganization(ctx, "Platform", "owner@platform.test", "Owner")
+	require.NoError(t, err)
+	owner, err := store.GetUser(ctx, org.OwnerID)
+	require.NoError(t, err)
+	token, err := srv.tokenManager.GenerateToken(owner)
+	require.NoError(t, err)
+
+	create := func(email, slug string) *httptest.ResponseRecorder {
+		body, err := json.Marshal(CreateOrganizationRequest{Name: "Acme", OwnerEmail: email, OwnerName: "Owner", Slug: slug})
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, "/organizations", strings.NewReader(string(body)))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		addCSRFToken(t, srv, req)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return w
+	}
+
+	w := create("one@acme.test", "Acme-Corp")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var created Organization
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&created))
+	require.Equal(t, "acme-corp", *created.Slug)
+
+	stored, err := store.GetOrganization(ctx, created.ID)
+	require.NoError(t, err)
+	require.Equal(t, "acme-corp", *stored.Slug)
+
+	w = create("two@acme.test", "acme-corp")
+	require.Equal(t, http.StatusConflict, w.Code)
+	require.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+	require.Contains(t, w.Body.String(), ErrSlugTaken.Error())
+
+	w = create("three@acme.test", "billing")
+	require.Equal(t, http.StatusConflict, w.Code)
+	require.Contains(t, w.Body.String(), ErrSlugReserved.Error())
+
+	require.Equal(t, http.StatusBadRequest, create("four@acme.test", "a").Code)
+
+	// Slugs are optional, so any number of organizations can go without
+	require.Equal(t, http.StatusOK, create("five@acme.test", "").Code)
+	require.Equal(t, http.StatusOK, create("six@acme.test", "").Code)
+
+	// A deleted organization's slug can be taken again
+	require.NoError(t, store.DeleteOrganization(ctx, created.ID))
+	require.Equal(t, http.StatusOK, create("seven@acme.test", "acme-corp").Code)
+}
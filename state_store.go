@@ -1,10 +1,18 @@
 package main
 
 import (
+	"context"
 	"sync"
 	"time"
 )
 
+// OAuthStateStore remembers the state parameters of OAuth logins in flight
+type OAuthStateStore interface {
+	StoreState(ctx context.Context, state string, expiration time.Duration) error
+	ValidateAndDeleteState(ctx context.Context, state string) (bool, error)
+}
+
+// StateStore is the in-process OAuthStateStore used without Redis
 type StateStore struct {
 	states          sync.Map
 	cleanupInterval time.Duration
@@ -37,16 +45,17 @@ func (s *StateStore) periodicCleanup() {
 	}
 }
 
-func (s *StateStore) StoreState(state string, expiration time.Duration) {
+func (s *StateStore) StoreState(ctx context.Context, state string, expiration time.Duration) error {
 	s.states.Store(state, stateEntry{
 		expiresAt: time.Now().Add(expiration),
 	})
+	return nil
 }
 
-func (s *StateStore) ValidateAndDeleteState(state string) bool {
+func (s *StateStore) ValidateAndDeleteState(ctx context.Context, state string) (bool, error) {
 	if value, ok := s.states.LoadAndDelete(state); ok {
 		entry := value.(stateEntry)
-		return !time.Now().After(entry.expiresAt)
+		return !time.Now().After(entry.expiresAt), nil
 	}
-	return false
+	return false, nil
 }
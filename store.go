@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UserStore looks up users
+type UserStore interface {
+	GetUser(ctx context.Context, id uuid.UUID) (*User, error)
+	GetUsersByIDs(ctx context.Context, ids []uuid.UUID) ([]User, error)
+	// GetUserByEmail returns nil and no error if no user has the address
+	GetUserByEmail(ctx context.Context, email string) (*User, error)
+	SearchUsers(ctx context.Context, query string, includeDeleted bool, limit, offset int) ([]User, int, error)
+	DeleteUser(ctx context.Context, id uuid.UUID) error
+}
+
+// OrgStore manages organizations, their members, settings and usage.
+// Methods taking expectedVersion fail with ErrVersionConflict if the
+// organization has changed since the caller read that version.
+//
+// Paginated listings here and in the other stores also return the number of
+// matches across all pages. It is 0 when offset is past the last match.
+type OrgStore interface {
+	CreateOrganization(ctx context.Context, name, ownerEmail, ownerName string) (*Organization, error)
+	CreateOrganizationWithOwner(ctx context.Context, org *Organization, owner *User) error
+	GetOrganization(ctx context.Context, id uuid.UUID) (*Organization, error)
+	GetOrganizationsByIDs(ctx context.Context, ids []uuid.UUID) ([]Organization, error)
+	GetOrganizationUsers(ctx context.Context, orgID uuid.UUID) ([]User, error)
+	// SearchOrganizationUsers finds a page of an organization's members
+	// whose name or email contains query, best matches first, and how many
+	// match in total
+	SearchOrganizationUsers(ctx context.Context, orgID uuid.UUID, query string, limit, offset int) ([]User, int, error)
+	AddUserToOrganization(ctx context.Context, orgID uuid.UUID, email, name string) (*User, error)
+	UpdateUserRole(ctx context.Context, orgID, userID uuid.UUID, role string) (*User, error)
+	// UpdateUserMetadata replaces a member's metadata, failing with
+	// ErrVersionConflict if the member has changed since the caller read
+	// expectedVersion
+	UpdateUserMetadata(ctx context.Context, orgID, userID uuid.UUID, expectedVersion int, metadata UserMetadata) (*User, error)
+	GetOrganizationStats(ctx context.Context, orgID uuid.UUID) (*OrganizationStats, error)
+	IncrementAPIUsage(ctx context.Context, orgID uuid.UUID, day time.Time, calls int64) error
+	GetOrganizationSettings(ctx context.Context, orgID uuid.UUID) (*OrganizationSettings, int, error)
+	UpdateOrganizationSettings(ctx context.Context, orgID uuid.UUID, expectedVersion int, settings *OrganizationSettings) (int, error)
+	RenameOrganization(ctx context.Context, id uuid.UUID, expectedVersion int, name string) (*Organization, error)
+	ListOrganizationOrigins(ctx context.Context) ([]string, error)
+	// ListOrganizationIPAllowlists returns the IP allowlist of every
+	// organization that has one
+	ListOrganizationIPAllowlists(ctx context.Context) (map[uuid.UUID][]string, error)
+	// ListOrganizationParents maps every sub-organization to the
+	// organization it sits under, leaving out deleted organizations
+	ListOrganizationParents(ctx context.Context) (map[uuid.UUID]uuid.UUID, error)
+
+	ListOrganizations(ctx context.Context, filter OrganizationFilter, limit, offset int) ([]Organization, int, error)
+	// SearchOrganizations finds at most limit organizations whose slug or
+	// name contains query, exact matches first
+	SearchOrganizations(ctx context.Context, query string, limit int) ([]Organization, error)
+	SetOrganizationSuspended(ctx context.Context, id uuid.UUID, suspended bool) (*Organization, error)
+	IsOrganizationSuspended(ctx context.Context, id uuid.UUID) (bool, error)
+	UpdateOrganizationTier(ctx context.Context, id uuid.UUID, expectedVersion int, tier string, maxSubAccounts int, seatOverage string) (*Organization, error)
+	// SetOrganizationParent places an organization under parentID, or
+	// under no organization if parentID is nil
+	SetOrganizationParent(ctx context.Context, id uuid.UUID, expectedVersion int, parentID *uuid.UUID) (*Organization, error)
+	// MoveUser moves a member of fromOrgID to toOrgID with one of the
+	// AssignableRoles. It is for merging organizations, which bring their
+	// members' seats with them, so it does not check toOrgID's seat limit.
+	MoveUser(ctx context.Context, userID, fromOrgID, toOrgID uuid.UUID, role string) (*User, error)
+	GetPlatformStats(ctx context.Context) (*PlatformStats, error)
+	DeleteOrganization(ctx context.Context, id uuid.UUID) error
+}
+
+// TokenStore persists refresh tokens
+type TokenStore interface {
+	CreateRefreshToken(ctx context.Context, userID uuid.UUID, terms SessionTerms) (string, error)
+	// RotateRefreshToken exchanges a live refresh token for a new one in
+	// the same session
+	RotateRefreshToken(ctx context.Context, token string, terms SessionTerms) (string, error)
+	ValidateRefreshToken(ctx context.Context, token string) (*User, error)
+	InvalidateRefreshToken(ctx context.Context, token string) error
+	InvalidateUserRefreshTokens(ctx context.Context, userID uuid.UUID) error
+	// ListUserRefreshTokens returns a user's live refresh tokens, newest first
+	ListUserRefreshTokens(ctx context.Context, userID uuid.UUID) ([]RefreshToken, error)
+}
+
+// RetentionStore enforces data retention. Overrides replace the default
+// retention windows for single organizations.
+type RetentionStore interface {
+	GetRetentionOverrides(ctx context.Context) (map[uuid.UUID]RetentionOverride, error)
+	GetRetentionOverride(ctx context.Context, orgID uuid.UUID) (*RetentionOverride, int, error)
+	UpdateRetentionOverride(ctx context.Context, orgID uuid.UUID, expectedVersion int, override *RetentionOverride) (int, error)
+	// PurgeRetained permanently removes the records in scope that are past their cutoffs
+	PurgeRetained(ctx context.Context, scope RetentionScope, cutoffs RetentionCutoffs) (*PurgeCounts, error)
+}
+
+// JobStore queues background jobs for the JobRunner
+type JobStore interface {
+	EnqueueJob(ctx context.Context, job *Job) error
+	ClaimJobs(ctx context.Context, limit int, lease time.Duration) ([]Job, error)
+	CompleteJob(ctx context.Context, id uuid.UUID) error
+	FailJob(ctx context.Context, id uuid.UUID, lastError string, retryAt time.Time) error
+	GetJob(ctx context.Context, id uuid.UUID) (*Job, error)
+	ListJobs(ctx context.Context, status string, limit, offset int) ([]Job, int, error)
+	RetryJob(ctx context.Context, id uuid.UUID) (*Job, error)
+}
+
+// WebhookStore manages organizations' webhooks and the log of deliveries
+// to them. Deleting a webhook deletes its deliveries.
+type WebhookStore interface {
+	CreateWebhook(ctx context.Context, webhook *Webhook) error
+	GetWebhook(ctx context.Context, orgID, id uuid.UUID) (*Webhook, error)
+	ListWebhooks(ctx context.Context, orgID uuid.UUID) ([]Webhook, error)
+	UpdateWebhook(ctx context.Context, webhook *Webhook) error
+	DeleteWebhook(ctx context.Context, orgID, id uuid.UUID) error
+
+	CreateWebhookDelivery(ctx context.Context, delivery *WebhookDelivery) error
+	GetWebhookDelivery(ctx context.Context, id uuid.UUID) (*WebhookDelivery, error)
+	// RecordWebhookAttempt counts an attempt at a delivery and its outcome
+	RecordWebhookAttempt(ctx context.Context, id uuid.UUID, status string, responseStatus *int, lastError *string) error
+	ListWebhookDeliveries(ctx context.Context, orgID, webhookID uuid.UUID, limit, offset int) ([]WebhookDelivery, int, error)
+}
+
+// BillingStore reports an organization's billable seats. The usage records
+// are emitted by AddUserToOrganization and DeleteUser as sub-accounts take
+// and free seats.
+type BillingStore interface {
+	// GetSeatUsage returns the seat summary, without records
+	GetSeatUsage(ctx context.Context, orgID uuid.UUID) (*SeatUsage, error)
+	ListSeatUsageRecords(ctx context.Context, orgID uuid.UUID, limit, offset int) ([]SeatUsageRecord, int, error)
+}
+
+// OIDCClientStore manages the applications organizations register with
+// the OIDC provider
+type OIDCClientStore interface {
+	CreateOIDCClient(ctx context.Context, client *OIDCClient) error
+	GetOIDCClient(ctx context.Context, id uuid.UUID) (*OIDCClient, error)
+	ListOIDCClients(ctx context.Context, orgID uuid.UUID) ([]OIDCClient, error)
+	DeleteOIDCClient(ctx context.Context, orgID, id uuid.UUID) error
+}
+
+// AccessGrantStore manages the access organizations grant users from other
+// organizations. Expired grants are neither returned nor honored.
+type AccessGrantStore interface {
+	// PutAccessGrant creates a grant, or replaces the organization's
+	// existing grant to the same user, keeping its ID
+	PutAccessGrant(ctx context.Context, grant *AccessGrant) error
+	// GetAccessGrant returns the live grant of orgID to userID
+	GetAccessGrant(ctx context.Context, orgID, userID uuid.UUID) (*AccessGrant, error)
+	// ListAccessGrants returns an organization's live grants, soonest to
+	// expire first
+	ListAccessGrants(ctx context.Context, orgID uuid.UUID) ([]AccessGrant, error)
+	// ListUserAccessGrants returns the live grants a user holds, soonest to
+	// expire first
+	ListUserAccessGrants(ctx context.Context, userID uuid.UUID) ([]AccessGrant, error)
+	DeleteAccessGrant(ctx context.Context, orgID, id uuid.UUID) error
+}
+
+// DirectoryStore keeps organizations' directory sync configurations and
+// the members their syncs manage
+type DirectoryStore interface {
+	GetDirectorySync(ctx context.Context, orgID uuid.UUID) (*DirectorySync, error)
+	// PutDirectorySync creates or replaces a configuration, keeping its
+	// last result, and makes it due
+	PutDirectorySync(ctx context.Context, sync *DirectorySync) error
+	// DeleteDirectorySync removes a configuration; its members stay
+	DeleteDirectorySync(ctx context.Context, orgID uuid.UUID) error
+	// ClaimDueDirectorySyncs returns the organizations whose enabled sync
+	// is due at now, scheduling their next one after interval
+	ClaimDueDirectorySyncs(ctx context.Context, now time.Time, interval time.Duration) ([]uuid.UUID, error)
+	RecordDirectorySyncResult(ctx context.Context, orgID uuid.UUID, result *DirectorySyncResult) error
+
+	ListDirectoryUsers(ctx context.Context, orgID uuid.UUID) ([]uuid.UUID, error)
+	// SetDirectoryUsers replaces the members an organization's sync manages
+	SetDirectoryUsers(ctx context.Context, orgID uuid.UUID, userIDs []uuid.UUID) error
+}
+
+// FeatureFlagStore manages feature flags and the organizations they are
+// turned on or off for
+type FeatureFlagStore interface {
+	// ListFeatureFlags returns every flag with its organizations, by key
+	ListFeatureFlags(ctx context.Context) ([]FeatureFlag, error)
+	GetFeatureFlag(ctx context.Context, key string) (*FeatureFlag, error)
+	// PutFeatureFlag creates or replaces a flag, keeping its organizations
+	PutFeatureFlag(ctx context.Context, flag *FeatureFlag) error
+	DeleteFeatureFlag(ctx context.Context, key string) error
+	SetFeatureFlagOrganization(ctx context.Context, key string, orgID uuid.UUID, enabled bool) error
+	// RemoveFeatureFlagOrganization returns the organization to the rollout
+	RemoveFeatureFlagOrganization(ctx context.Context, key string, orgID uuid.UUID) error
+}
+
+// OrgFieldStore manages the custom fields organizations can have values for
+type OrgFieldStore interface {
+	// ListOrganizationFields returns every field, by key
+	ListOrganizationFields(ctx context.Context) ([]OrganizationField, error)
+	// PutOrganizationField defines a field or changes its description,
+	// returning ErrOrganizationFieldType if it is defined with another type
+	PutOrganizationField(ctx context.Context, field *OrganizationField) error
+	// DeleteOrganizationField deletes a field and every organization's
+	// value for it
+	DeleteOrganizationField(ctx context.Context, key string) error
+	// UpdateOrganizationFields replaces an organization's field values
+	UpdateOrganizationFields(ctx context.Context, orgID uuid.UUID, expectedVersion int, fields OrganizationFields) (*Organization, error)
+}
+
+// InvitationStore keeps organizations' pending invitations. Tokens are
+// looked up by their hash.
+type InvitationStore interface {
+	// CreateInvitation stores an invitation, replacing any pending for the
+	// same address in the organization
+	CreateInvitation(ctx context.Context, inv *Invitation) error
+	// GetInvitationByToken returns the invitation, expired or not
+	GetInvitationByToken(ctx context.Context, tokenHash string) (*Invitation, error)
+	// ListInvitations returns an organization's invitations, newest first
+	ListInvitations(ctx context.Context, orgID uuid.UUID) ([]Invitation, error)
+	DeleteInvitation(ctx context.Context, orgID, id uuid.UUID) error
+	SetInvitationToken(ctx context.Context, id uuid.UUID, tokenHash string) (*Invitation, error)
+	// ClaimDueInvitationReminders returns the live invitations whose
+	// reminder is due at now, scheduling the next after interval until
+	// maxReminders have been sent
+	ClaimDueInvitationReminders(ctx context.Context, now time.Time, interval time.Duration, maxReminders int) ([]uuid.UUID, error)
+	// DeleteExpiredInvitations deletes invitations that expired before cutoff
+	DeleteExpiredInvitations(ctx context.Context, cutoff time.Time) (int, error)
+}
+
+// QuotaStore reads the usage quotas are evaluated against, and keeps the
+// thresholds organizations have been warned about
+type QuotaStore interface {
+	// GetAPIUsage returns an organization's API calls flushed since the
+	// day of since
+	GetAPIUsage(ctx context.Context, orgID uuid.UUID, since time.Time) (int64, error)
+	// SetQuotaAlertLevel records the threshold an organization has reached
+	// of a quota in period, returning the one recorded before, or 0 if that
+	// was for another period
+	SetQuotaAlertLevel(ctx context.Context, orgID uuid.UUID, quota, period string, level int) (int, error)
+}
+
+// TokenDenylistStore remembers revoked access tokens until they would have
+// expired
+type TokenDenylistStore interface {
+	// DenyToken revokes one of an organization's tokens by its ID (jti)
+	DenyToken(ctx context.Context, orgID, tokenID uuid.UUID, expiresAt time.Time) error
+	IsTokenDenied(ctx context.Context, orgID, tokenID uuid.UUID) (bool, error)
+}
+
+// MFAStore keeps members' TOTP factors, one per user
+type MFAStore interface {
+	GetMFAFactor(ctx context.Context, userID uuid.UUID) (*MFAFactor, error)
+	// PutMFAFactor creates or replaces a user's factor
+	PutMFAFactor(ctx context.Context, factor *MFAFactor) error
+	// UseMFAFactor records that a code of a user's factor for step was
+	// accepted, enabling the factor. It reports false, recording nothing,
+	// if a code for that step or a later one was accepted before.
+	UseMFAFactor(ctx context.Context, userID uuid.UUID, step int64) (bool, error)
+	DeleteMFAFactor(ctx context.Context, userID uuid.UUID) error
+}
+
+// Store is everything the server needs from its data layer. DB implements
+// it on Postgres and MemoryStore in process for tests.
+type Store interface {
+	UserStore
+	OrgStore
+	TokenStore
+	JobStore
+	RetentionStore
+	WebhookStore
+	BillingStore
+	OIDCClientStore
+	AccessGrantStore
+	DirectoryStore
+	FeatureFlagStore
+	OrgFieldStore
+	InvitationStore
+	QuotaStore
+	TokenDenylistStore
+	MFAStore
+}
+
+// OpenStore opens the store named by a DATABASE_URL. A memory:// URL keeps
+// everything in process, so the server runs without Postgres for local
+// development; nothing survives a restart.
+func OpenStore(dsn string, config *DBConfig) (Store, error) {
+	if strings.HasPrefix(dsn, "memory:") {
+		return NewMemoryStore(), nil
+	}
+
+	db, err := NewDB(dsn, config)
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+var (
+	_ Store = (*DB)(nil)
+	_ Store = (*MemoryStore)(nil)
+)
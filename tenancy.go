@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// tenantSetting is the transaction setting the row-level security policies
+// compare organization_id against
+const tenantSetting = "app.organization_id"
+
+const tenantContextKey contextKey = "tenant"
+
+// ErrCrossTenant is returned for store work naming an organization other
+// than the one its context is confined to
+var ErrCrossTenant = errors.New("organization outside the request's scope")
+
+// WithOrg returns a context confined to orgID's rows. Store work done with
+// it behaves as if every query filtered on orgID, so one that forgets to
+// cannot reach another tenant's data, and work naming another organization
+// fails with ErrCrossTenant. RequireSameOrg confines each request to its
+// {orgID} organization once the caller may act on it.
+func WithOrg(ctx context.Context, orgID uuid.UUID) context.Context {
+	return context.WithValue(ctx, tenantContextKey, orgID)
+}
+
+// scopedOrg returns the organization ctx is confined to, or uuid.Nil
+func scopedOrg(ctx context.Context) uuid.UUID {
+	orgID, _ := ctx.Value(tenantContextKey).(uuid.UUID)
+	return orgID
+}
+
+// unscoped lifts ctx's confinement, for the store methods that look across
+// organizations by design, such as finding a user by email
+func unscoped(ctx context.Context) context.Context {
+	if scopedOrg(ctx) == uuid.Nil {
+		return ctx
+	}
+	return WithOrg(ctx, uuid.Nil)
+}
+
+// inScope reports whether ctx may reach orgID's rows
+func inScope(ctx context.Context, orgID uuid.UUID) bool {
+	scope := scopedOrg(ctx)
+	return scope == uuid.Nil || scope == orgID
+}
+
+// runTx runs fn in a transaction on pool and commits it. The transaction is
+// confined to orgID's rows, or when orgID is uuid.Nil to those of the
+// organization ctx is confined to, if any, so a query that forgets its
+// organization filter still cannot reach another tenant's data.
+func runTx(ctx context.Context, pool *sqlx.DB, orgID uuid.UUID, fn func(tx *sqlx.Tx) error) error {
+	if !inScope(ctx, orgID) {
+		return ErrCrossTenant
+	}
+	if orgID == uuid.Nil {
+		orgID = scopedOrg(ctx)
+	}
+
+	tx, err := pool.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if orgID != uuid.Nil {
+		// set_config with is_local behaves like SET LOCAL but takes a parameter
+		if _, err := tx.ExecContext(ctx, `SELECT set_config($1, $2, true)`, tenantSetting, orgID.String()); err != nil {
+			return err
+		}
+	}
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// tenantTx runs fn in a transaction on the primary confined to orgID's rows,
+// retrying transient failures like transact
+func (db *DB) tenantTx(ctx context.Context, orgID uuid.UUID, fn func(tx *sqlx.Tx) error) error {
+	return withRetry(ctx, func() error {
+		return runTx(ctx, db.DB, orgID, fn)
+	})
+}
+
+// tenantRead runs a pure read confined to orgID's rows, on the replica when
+// one is available
+func (db *DB) tenantRead(ctx context.Context, orgID uuid.UUID, query func(q sqlx.QueryerContext) error) error {
+	return db.readPool(ctx, func(pool *sqlx.DB) error {
+		return runTx(ctx, pool, orgID, func(tx *sqlx.Tx) error {
+			return query(tx)
+		})
+	})
+}
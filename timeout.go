@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// parseRouteTimeouts parses a comma-separated list of prefix=duration pairs,
+// e.g. "/admin/=30s,/organizations/{orgID}/import=2m"
+func parseRouteTimeouts(value string) (map[string]time.Duration, error) {
+	timeouts := make(map[string]time.Duration)
+	for _, entry := range splitList(value) {
+		prefix, budget, ok := strings.Cut(entry, "=")
+		prefix = strings.TrimSpace(prefix)
+		d, err := time.ParseDuration(strings.TrimSpace(budget))
+		if !ok || !strings.HasPrefix(prefix, "/") || err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid route timeout %q: expected /prefix=duration", entry)
+		}
+		timeouts[prefix] = d
+	}
+	return timeouts, nil
+}
+
+// requestTimeout returns the budget for path: that of the longest matching
+// route prefix, or the default
+func (c *ServerConfig) requestTimeout(path string) time.Duration {
+	timeout, longest := c.RequestTimeout, -1
+	for prefix, d := range c.RouteTimeouts {
+		if strings.HasPrefix(path, prefix) && len(prefix) > longest {
+			timeout, longest = d, len(prefix)
+		}
+	}
+	return timeout
+}
+
+// writeTimeout gives the connection enough time for the longest request
+// budget, so a slow request is answered with a 503 rather than cut off
+func (c *ServerConfig) writeTimeout() time.Duration {
+	timeout := c.WriteTimeout
+	for _, d := range c.RouteTimeouts {
+		timeout = max(timeout, d+time.Second)
+	}
+	return max(timeout, c.RequestTimeout+time.Second)
+}
+
+// TimeoutMiddleware bounds each request by its route's budget. The deadline
+// is set on the request context, which net/http also cancels when the client
+// disconnects, so database work stops in either case. A handler that has not
+// responded in time is answered with 503 Service Unavailable.
+func TimeoutMiddleware(config *ServerConfig) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			timeout := config.requestTimeout(r.URL.Path)
+			if timeout <= 0 || isEventStream(r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			http.TimeoutHandler(next, timeout, "Request timed out").ServeHTTP(w, r)
+		})
+	}
+}
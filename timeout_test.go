@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeoutMiddleware(t *testing.T) {
+	t.Setenv("REQUEST_TIMEOUT", "20ms")
+	t.Setenv("ROUTE_TIMEOUTS", "/admin/=1s, /admin/stats=0s")
+	config, err := NewServerConfig(os.Getenv)
+	require.NoError(t, err)
+
+	require.Equal(t, 20*time.Millisecond, config.requestTimeout("/organizations"))
+	require.Equal(t, time.Second, config.requestTimeout("/admin/users"))
+	require.Zero(t, config.requestTimeout("/admin/stats"))
+	require.Equal(t, 10*time.Second, config.writeTimeout())
+
+	// The handler waits out its deadline and reports why it stopped
+	ctxErrs := make(chan error, 1)
+	handler := TimeoutMiddleware(config)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(100 * time.Millisecond):
+		}
+		ctxErrs <- r.Context().Err()
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name           string
+		path           string
+		expectedStatus int
+		expectedErr    error
+	}{
+		{"Default budget exceeded", "/organizations", http.StatusServiceUnavailable, context.DeadlineExceeded},
+		{"Longer route budget", "/admin/users", http.StatusOK, nil},
+		{"Budget disabled", "/admin/stats", http.StatusOK, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			require.Equal(t, tt.expectedStatus, w.Code)
+			require.Equal(t, tt.expectedErr, <-ctxErrs)
+		})
+	}
+
+	t.Run("Invalid route timeouts", func(t *testing.T) {
+		for _, value := range []string{"/admin", "admin=1s", "/admin=soon", "/admin=-1s"} {
+			_, err := parseRouteTimeouts(value)
+			require.Error(t, err, value)
+		}
+	})
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// DenyToken revokes one of an organization's tokens until expiresAt,
+// dropping the organization's entries that have run out
+func (db *DB) DenyToken(ctx context.Context, orgID, tokenID uuid.UUID, expiresAt time.Time) error {
+	return db.tenantTx(ctx, orgID, func(tx *sqlx.Tx) error {
+		_, err := tx.ExecContext(ctx, `
+			DELETE FROM token_denylist WHERE organization_id = $1 AND expires_at < NOW()
+		`, orgID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO token_denylist (token_id, organization_id, expires_at)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (organization_id, token_id) DO UPDATE SET expires_at = GREATEST(token_denylist.expires_at, EXCLUDED.expires_at)
+		`, tokenID, orgID, expiresAt.UTC())
+		return err
+	})
+}
+
+// IsTokenDenied reports whether one of an organization's tokens has been
+// revoked. It reads the primary, so a revocation holds at once.
+func (db *DB) IsTokenDenied(ctx context.Context, orgID, tokenID uuid.UUID) (bool, error) {
+	var denied bool
+	err := db.tenantTx(ctx, orgID, func(tx *sqlx.Tx) error {
+		return tx.GetContext(ctx, &denied, `
+			SELECT EXISTS (SELECT 1 FROM token_denylist WHERE organization_id = $1 AND token_id = $2)
+		`, orgID, tokenID)
+	})
+	return denied, err
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// MaxValidateBatch is how many tokens one batch validation may carry
+const MaxValidateBatch = 100
+
+type TokenBatchRequest struct {
+	Tokens []string `json:"tokens"`
+}
+
+// TokenValidation is the outcome for one token of a batch
+type TokenValidation struct {
+	Valid  bool    `json:"valid"`
+	Error  string  `json:"error,omitempty"`  // why an invalid token was refused
+	Claims *Claims `json:"claims,omitempty"` // set for valid tokens
+}
+
+type TokenBatchResponse struct {
+	Results []TokenValidation `json:"results"` // in the order of the tokens
+}
+
+func ValidateTokenBatchRequest(req *TokenBatchRequest) error {
+	switch {
+	case len(req.Tokens) == 0:
+		return &ValidationError{Field: "tokens", Message: ErrEmptyField.Error()}
+	case len(req.Tokens) > MaxValidateBatch:
+		return &ValidationError{Field: "tokens", Message: fmt.Sprintf("at most %d tokens are allowed", MaxValidateBatch)}
+	}
+	return nil
+}
+
+// handleValidateBatch checks many access tokens in one round trip, for API
+// gateways that would otherwise introspect each request's token. A token is
+// valid when the API would accept it: its user still exists and, unless a
+// platform admin, belongs to an organization that is not suspended. Like
+// /metrics it answers internal networks and platform admins only, so it
+// cannot be used to probe tokens from outside.
+func (s *Server) handleValidateBatch(w http.ResponseWriter, r *http.Request) {
+	if !s.internalCaller(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var req TokenBatchRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if err := ValidateTokenBatchRequest(&req); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+
+	resp := TokenBatchResponse{Results: make([]TokenValidation, len(req.Tokens))}
+	for i, token := range req.Tokens {
+		claims, _, err := s.auth.validate(r.Context(), token)
+		switch err {
+		case nil:
+			resp.Results[i] = TokenValidation{Valid: true, Claims: claims}
+		case ErrInvalidToken, ErrUserNotFound, ErrOrganizationSuspended:
+			resp.Results[i] = TokenValidation{Error: err.Error()}
+		default:
+			s.logger.ErrorContext(r.Context(), "failed to validate token", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
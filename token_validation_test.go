@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateTokenBatch(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	srv, err := NewServer(store, newTestConfig(t))
+	require.NoError(t, err)
+
+	acme, err := store.CreateOrganization(ctx, "Acme", "owner@acme.test", "Owner")
+	require.NoError(t, err)
+	initech, err := store.CreateOrganization(ctx, "Initech", "owner@initech.test", "Owner")
+	require.NoError(t, err)
+	_, err = store.SetOrganizationSuspended(ctx, initech.ID, true)
+	require.NoError(t, err)
+
+	acmeOwner, err := store.GetUser(ctx, acme.OwnerID)
+	require.NoError(t, err)
+	valid, err := srv.tokenManager.GenerateToken(acmeOwner)
+	require.NoError(t, err)
+	initechOwner, err := store.GetUser(ctx, initech.OwnerID)
+	require.NoError(t, err)
+	suspended, err := srv.tokenManager.GenerateToken(initechOwner)
+	require.NoError(t, err)
+
+	validate := func(remoteAddr, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/auth/validate-batch", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("Each token is reported in order", func(t *testing.T) {
+		body, err := json.Marshal(TokenBatchRequest{Tokens: []string{valid, "not-a-token", suspended}})
+		require.NoError(t, err)
+		w := validate("127.0.0.1:1234", string(body))
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var resp TokenBatchResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+		require.Len(t, resp.Results, 3)
+		require.True(t, resp.Results[0].Valid)
+		require.Equal(t, acmeOwner.ID, resp.Results[0].Claims.UserID)
+		require.Equal(t, acme.ID, resp.Results[0].Claims.OrganizationID)
+		require.Equal(t, TokenValidation{Error: ErrInvalidToken.Error()}, resp.Results[1])
+		require.Equal(t, TokenValidation{Error: ErrOrganizationSuspended.Error()}, resp.Results[2])
+	})
+
+	t.Run("Batches are bounded", func(t *testing.T) {
+		w := validate("127.0.0.1:1234", `{"tokens":[]}`)
+		require.Equal(t, http.StatusBadRequest, w.Code)
+
+		body, err := json.Marshal(TokenBatchRequest{Tokens: make([]string, MaxValidateBatch+1)})
+		require.NoError(t, err)
+		w = validate("127.0.0.1:1234", string(body))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Contains(t, w.Body.String(), "at most 100 tokens")
+	})
+
+	t.Run("External callers are refused", func(t *testing.T) {
+		w := validate("203.0.113.7:1234", `{"tokens":["x"]}`)
+		require.Equal(t, http.StatusForbidden, w.Code)
+	})
+}
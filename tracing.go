@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates spans for work that is not covered by the HTTP and SQL
+// instrumentation. It resolves against the global provider lazily, so it is
+// safe to use before SetupTracing runs.
+var tracer = otel.Tracer("github.com/mmichie/huachuca")
+
+// TracingConfig holds configuration for OpenTelemetry tracing. The exporter
+// itself is configured with the standard OTEL_EXPORTER_OTLP_* variables and
+// sampling with OTEL_TRACES_SAMPLER / OTEL_TRACES_SAMPLER_ARG.
+type TracingConfig struct {
+	Enabled     bool
+	ServiceName string
+}
+
+// NewTracingConfig enables tracing when an OTLP endpoint is configured
+func NewTracingConfig(settings Settings) *TracingConfig {
+	return &TracingConfig{
+		Enabled: settings("OTEL_EXPORTER_OTLP_ENDPOINT") != "" ||
+			settings("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != "",
+		ServiceName: settings.get("OTEL_SERVICE_NAME", "huachuca"),
+	}
+}
+
+// SetupTracing installs the global tracer provider and W3C propagators. The
+// returned function flushes buffered spans and must be called on shutdown.
+func SetupTracing(ctx context.Context, config *TracingConfig, version string) (func(context.Context) error, error) {
+	if !config.Enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Attributes from OTEL_RESOURCE_ATTRIBUTES take precedence over the defaults
+	res, err := resource.New(ctx,
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+		resource.WithAttributes(
+			attribute.String("service.name", config.ServiceName),
+			attribute.String("service.version", version),
+		),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	return provider.Shutdown, nil
+}
+
+// traceRequests starts a server span for every request except health checks,
+// continuing any trace propagated by the caller
+func traceRequests(next http.Handler) http.Handler {
+	return otelhttp.NewHandler(next, "http.request",
+		otelhttp.WithFilter(func(r *http.Request) bool {
+			switch r.URL.Path {
+			case "/health", "/livez", "/readyz":
+				return false
+			}
+			return true
+		}),
+	)
+}
+
+// traceRoute renames the request span after the matched route pattern, such
+// as "GET /organizations/{orgID}", once the mux has routed the request. It
+// must wrap the mux directly so it sees the request the mux annotates, which
+// it also records for requestRoute.
+func traceRoute(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if routed, ok := r.Context().Value(routedRequestKey{}).(*routedRequest); ok {
+			routed.request = r
+		}
+		next.ServeHTTP(w, r)
+
+		if r.Pattern == "" {
+			return
+		}
+		span := trace.SpanFromContext(r.Context())
+		span.SetName(r.Pattern)
+		if _, route, ok := strings.Cut(r.Pattern, " "); ok {
+			span.SetAttributes(attribute.String("http.route", route))
+		}
+	})
+}
+
+// tracedHTTPClient propagates trace context to, and records spans for,
+// outgoing requests
+var tracedHTTPClient = &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)}
+
+// recordSpanError marks the span as failed
+func recordSpanError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
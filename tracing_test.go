@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTraceRequests(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	var logs bytes.Buffer
+	logger := slog.New(NewContextLogHandler(slog.NewJSONHandler(&logs, nil)))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /organizations/{orgID}", func(w http.ResponseWriter, r *http.Request) {
+		logger.InfoContext(r.Context(), "handled")
+	})
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {})
+	handler := chain(mux, traceRequests, RequestID, traceRoute)
+
+	tests := []struct {
+		name          string
+		path          string
+		expectedSpan  string
+		expectedRoute string
+	}{
+		{
+			name:          "Span named after route pattern",
+			path:          "/organizations/0b0f9f6e-6c5d-4a57-9b4e-1f9c4f3d2a10",
+			expectedSpan:  "GET /organizations/{orgID}",
+			expectedRoute: "/organizations/{orgID}",
+		},
+		{
+			name: "Health checks are not traced",
+			path: "/health",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := len(recorder.Ended())
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			spans := recorder.Ended()[before:]
+			if tt.expectedSpan == "" {
+				require.Empty(t, spans)
+				return
+			}
+			require.Len(t, spans, 1)
+			require.Equal(t, tt.expectedSpan, spans[0].Name())
+			require.Contains(t, spans[0].Attributes(), attribute.String("http.route", tt.expectedRoute))
+
+			var entry map[string]interface{}
+			require.NoError(t, json.Unmarshal(logs.Bytes(), &entry))
+			require.Equal(t, spans[0].SpanContext().TraceID().String(), entry["trace_id"])
+		})
+	}
+}
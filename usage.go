@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UsageRecorder counts authenticated API calls per organization in memory
+// and periodically flushes the totals into organization_api_usage
+type UsageRecorder struct {
+	mu      sync.Mutex
+	pending map[uuid.UUID]int64
+	store   OrgStore
+	metrics *OrgMetrics // also counts calls for the organizations it lists
+	logger  *slog.Logger
+}
+
+func NewUsageRecorder(store OrgStore, logger *slog.Logger, flushInterval time.Duration) *UsageRecorder {
+	u := &UsageRecorder{
+		pending: make(map[uuid.UUID]int64),
+		store:   store,
+		logger:  logger,
+	}
+	go u.periodicFlush(flushInterval)
+	return u
+}
+
+func (u *UsageRecorder) periodicFlush(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := u.Flush(ctx); err != nil {
+			u.logger.Error("failed to flush API usage", "error", err)
+		}
+		cancel()
+	}
+}
+
+// Record counts a single API call for an organization
+func (u *UsageRecorder) Record(orgID uuid.UUID) {
+	u.mu.Lock()
+	u.pending[orgID]++
+	u.mu.Unlock()
+	u.metrics.RecordAPICall(orgID)
+}
+
+// Pending returns the number of calls recorded for an organization but not yet flushed
+func (u *UsageRecorder) Pending(orgID uuid.UUID) int64 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.pending[orgID]
+}
+
+// Flush writes all pending counters to the database. Counters that fail to
+// persist are merged back so they are retried on the next flush.
+func (u *UsageRecorder) Flush(ctx context.Context) error {
+	u.mu.Lock()
+	batch := u.pending
+	u.pending = make(map[uuid.UUID]int64)
+	u.mu.Unlock()
+
+	day := time.Now().UTC()
+	for orgID, calls := range batch {
+		if err := u.store.IncrementAPIUsage(ctx, orgID, day, calls); err != nil {
+			u.mu.Lock()
+			for id, n := range batch {
+				u.pending[id] += n
+			}
+			u.mu.Unlock()
+			return err
+		}
+		delete(batch, orgID)
+	}
+	return nil
+}
+
+// Handler records a call against the authenticated user's organization
+func (u *UsageRecorder) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, err := GetUserFromContext(r.Context()); err == nil {
+			u.Record(user.OrganizationID)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsageRecorder(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	recorder := NewUsageRecorder(nil, logger, time.Hour)
+
+	orgID := uuid.New()
+	otherOrgID := uuid.New()
+
+	t.Run("Record counts per organization", func(t *testing.T) {
+		recorder.Record(orgID)
+		recorder.Record(orgID)
+		recorder.Record(otherOrgID)
+
+		require.Equal(t, int64(2), recorder.Pending(orgID))
+		require.Equal(t, int64(1), recorder.Pending(otherOrgID))
+		require.Equal(t, int64(0), recorder.Pending(uuid.New()))
+	})
+
+	t.Run("Handler records authenticated requests only", func(t *testing.T) {
+		handler := recorder.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+
+		before := recorder.Pending(orgID)
+
+		req := httptest.NewRequest(http.MethodGet, "/organizations/"+orgID.String(), nil)
+		req = req.WithContext(context.WithValue(req.Context(), userContextKey, &User{OrganizationID: orgID}))
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		require.Equal(t, before+1, recorder.Pending(orgID))
+
+		req = httptest.NewRequest(http.MethodGet, "/organizations/"+orgID.String(), nil)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		require.Equal(t, before+1, recorder.Pending(orgID))
+	})
+}
+
+func TestUsageFlush(t *testing.T) {
+	testdb := setupTestDB(t)
+	defer testdb.teardown(t)
+
+	ctx := context.Background()
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	recorder := NewUsageRecorder(testdb.DB, logger, time.Hour)
+
+	org, err := testdb.DB.CreateOrganization(ctx, "Usage Org", "usage@test.com", "Usage Owner")
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		recorder.Record(org.ID)
+	}
+	require.NoError(t, recorder.Flush(ctx))
+	require.Equal(t, int64(0), recorder.Pending(org.ID))
+
+	recorder.Record(org.ID)
+	require.NoError(t, recorder.Flush(ctx))
+
+	stats, err := testdb.DB.GetOrganizationStats(ctx, org.ID)
+	require.NoError(t, err)
+	require.Equal(t, int64(4), stats.APICalls30d)
+	require.Equal(t, 0, stats.SeatsUsed)
+	require.Equal(t, org.MaxSubAccounts, stats.SeatLimit)
+	require.Equal(t, 1, stats.Members)
+
+	_, err = testdb.DB.GetOrganizationStats(ctx, uuid.New())
+	require.ErrorIs(t, err, ErrOrganizationNotFound)
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CacheConfig sizes the caches in front of the store
+type CacheConfig struct {
+	UserCacheSize  int           // users kept in memory; 0 disables the user cache
+	UserCacheTTL   time.Duration // how long a cached user is trusted
+	FeatureFlagTTL time.Duration // how long feature flags are evaluated without reloading
+	IPAllowlistTTL time.Duration // how long organizations' IP allowlists are enforced without reloading
+	HierarchyTTL   time.Duration // how long the organization hierarchy is trusted without reloading
+}
+
+// NewCacheConfig creates a cache configuration from settings, reporting
+// every invalid value
+func NewCacheConfig(settings Settings) (*CacheConfig, error) {
+	var errs []error
+	size, err := strconv.Atoi(settings.get("USER_CACHE_SIZE", "10000"))
+	if err != nil || size < 0 {
+		errs = append(errs, fmt.Errorf("invalid USER_CACHE_SIZE %q: expected a number of users, or 0", settings("USER_CACHE_SIZE")))
+	}
+	config := &CacheConfig{UserCacheSize: size}
+
+	for _, d := range []struct {
+		key      string
+		value    *time.Duration
+		positive bool // 0 is not allowed
+	}{
+		{"USER_CACHE_TTL", &config.UserCacheTTL, true},
+		{"FEATURE_FLAGS_CACHE_TTL", &config.FeatureFlagTTL, false},
+		{"IP_ALLOWLIST_CACHE_TTL", &config.IPAllowlistTTL, false},
+		{"ORG_HIERARCHY_CACHE_TTL", &config.HierarchyTTL, false},
+	} {
+		value, err := time.ParseDuration(settings.get(d.key, "30s"))
+		if err != nil || value < 0 || (d.positive && value == 0) {
+			errs = append(errs, fmt.Errorf("invalid %s %q", d.key, settings(d.key)))
+		}
+		*d.value = value
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// UserCache holds recently loaded users keyed by ID
+type UserCache interface {
+	Get(ctx context.Context, id uuid.UUID) (*User, bool)
+	Set(ctx context.Context, user *User)
+	Delete(ctx context.Context, id uuid.UUID)
+}
+
+type lruEntry struct {
+	user      *User
+	expiresAt time.Time
+}
+
+// LRUUserCache is an in-process UserCache that evicts the least recently
+// used user once it holds capacity entries
+type LRUUserCache struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	order    *list.List // front is most recently used
+	entries  map[uuid.UUID]*list.Element
+	now      func() time.Time
+}
+
+func NewLRUUserCache(capacity int, ttl time.Duration) *LRUUserCache {
+	return &LRUUserCache{
+		capacity: capacity,
+		ttl:      ttl,
+		order:    list.New(),
+		entries:  make(map[uuid.UUID]*list.Element),
+		now:      time.Now,
+	}
+}
+
+func (c *LRUUserCache) Get(ctx context.Context, id uuid.UUID) (*User, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[id]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*lruEntry)
+	if !c.now().Before(entry.expiresAt) {
+		c.order.Remove(elem)
+		delete(c.entries, id)
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return copyUser(entry.user), true
+}
+
+func (c *LRUUserCache) Set(ctx context.Context, user *User) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &lruEntry{user: copyUser(user), expiresAt: c.now().Add(c.ttl)}
+	if elem, ok := c.entries[user.ID]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[user.ID] = c.order.PushFront(entry)
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry).user.ID)
+	}
+}
+
+func (c *LRUUserCache) Delete(ctx context.Context, id uuid.UUID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[id]; ok {
+		c.order.Remove(elem)
+		delete(c.entries, id)
+	}
+}
+
+// CachedStore serves GetUser from a UserCache, which takes the users table
+// off the path of every authenticated request. Everything else goes straight
+// to the underlying Store.
+type CachedStore struct {
+	Store
+	users UserCache
+}
+
+func NewCachedStore(store Store, users UserCache) *CachedStore {
+	return &CachedStore{Store: store, users: users}
+}
+
+func (s *CachedStore) GetUser(ctx context.Context, id uuid.UUID) (*User, error) {
+	if user, ok := s.users.Get(ctx, id); ok {
+		if !inScope(ctx, user.OrganizationID) {
+			return nil, sql.ErrNoRows
+		}
+		return user, nil
+	}
+
+	user, err := s.Store.GetUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	s.users.Set(ctx, user)
+	return user, nil
+}
+
+// DeleteUser deletes the user and evicts it so it is signed out at once
+func (s *CachedStore) DeleteUser(ctx context.Context, id uuid.UUID) error {
+	if err := s.Store.DeleteUser(ctx, id); err != nil {
+		return err
+	}
+	s.users.Delete(ctx, id)
+	return nil
+}
+
+// UpdateUserRole changes the user's role and evicts it so the new role
+// applies at once
+func (s *CachedStore) UpdateUserRole(ctx context.Context, orgID, userID uuid.UUID, role string) (*User, error) {
+	user, err := s.Store.UpdateUserRole(ctx, orgID, userID, role)
+	if err != nil {
+		return nil, err
+	}
+	s.users.Delete(ctx, userID)
+	return user, nil
+}
+
+// UpdateUserMetadata changes the user's metadata and evicts it
+func (s *CachedStore) UpdateUserMetadata(ctx context.Context, orgID, userID uuid.UUID, expectedVersion int, metadata UserMetadata) (*User, error) {
+	user, err := s.Store.UpdateUserMetadata(ctx, orgID, userID, expectedVersion, metadata)
+	if err != nil {
+		return nil, err
+	}
+	s.users.Delete(ctx, userID)
+	return user, nil
+}
+
+// MoveUser moves the user and evicts it so its new organization applies at
+// once
+func (s *CachedStore) MoveUser(ctx context.Context, userID, fromOrgID, toOrgID uuid.UUID, role string) (*User, error) {
+	user, err := s.Store.MoveUser(ctx, userID, fromOrgID, toOrgID, role)
+	if err != nil {
+		return nil, err
+	}
+	s.users.Delete(ctx, userID)
+	return user, nil
+}
+
+// DeleteOrganization deletes the organization and evicts its members
+func (s *CachedStore) DeleteOrganization(ctx context.Context, id uuid.UUID) error {
+	members, err := s.Store.GetOrganizationUsers(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := s.Store.DeleteOrganization(ctx, id); err != nil {
+		return err
+	}
+	for _, u := range members {
+		s.users.Delete(ctx, u.ID)
+	}
+	return nil
+}
+
+// InvalidateUser drops id from the cache; call it whenever a user changes
+func (s *CachedStore) InvalidateUser(ctx context.Context, id uuid.UUID) {
+	s.users.Delete(ctx, id)
+}
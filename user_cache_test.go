@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// countingStore counts the GetUser calls that reach the underlying store
+type countingStore struct {
+	Store
+	gets int
+}
+
+func (s *countingStore) GetUser(ctx context.Context, id uuid.UUID) (*User, error) {
+	s.gets++
+	return s.Store.GetUser(ctx, id)
+}
+
+func TestLRUUserCache(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	cache := NewLRUUserCache(2, time.Minute)
+	cache.now = func() time.Time { return now }
+
+	a := &User{ID: uuid.New(), Email: "a@example.com"}
+	b := &User{ID: uuid.New(), Email: "b@example.com"}
+	c := &User{ID: uuid.New(), Email: "c@example.com"}
+
+	cache.Set(ctx, a)
+	cache.Set(ctx, b)
+	_, ok := cache.Get(ctx, a.ID) // a is now the most recently used
+	require.True(t, ok)
+
+	cache.Set(ctx, c)
+	_, ok = cache.Get(ctx, b.ID)
+	require.False(t, ok, "least recently used entry is evicted")
+
+	got, ok := cache.Get(ctx, a.ID)
+	require.True(t, ok)
+	got.Email = "changed@example.com"
+	got, _ = cache.Get(ctx, a.ID)
+	require.Equal(t, "a@example.com", got.Email, "callers get a copy")
+
+	cache.Delete(ctx, a.ID)
+	_, ok = cache.Get(ctx, a.ID)
+	require.False(t, ok)
+
+	now = now.Add(time.Minute)
+	_, ok = cache.Get(ctx, c.ID)
+	require.False(t, ok, "expired entry")
+}
+
+func TestCachedStore(t *testing.T) {
+	ctx := context.Background()
+	backing := &countingStore{Store: NewMemoryStore()}
+	store := NewCachedStore(backing, NewLRUUserCache(10, time.Minute))
+
+	org, err := store.CreateOrganization(ctx, "Acme", "owner@acme.test", "Owner")
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		user, err := store.GetUser(ctx, org.OwnerID)
+		require.NoError(t, err)
+		require.Equal(t, "owner@acme.test", user.Email)
+	}
+	require.Equal(t, 1, backing.gets)
+
+	store.InvalidateUser(ctx, org.OwnerID)
+	_, err = store.GetUser(ctx, org.OwnerID)
+	require.NoError(t, err)
+	require.Equal(t, 2, backing.gets)
+
+	_, err = store.GetUser(ctx, uuid.New())
+	require.Error(t, err, "misses are not cached")
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// handleGetMe returns the authenticated user
+func (s *Server) handleGetMe(w http.ResponseWriter, r *http.Request) {
+	user, err := GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// handleRevokeMySessions signs the authenticated user out everywhere by
+// revoking their refresh tokens. Access tokens already issued stay valid
+// until they expire.
+func (s *Server) handleRevokeMySessions(w http.ResponseWriter, r *http.Request) {
+	user, err := GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := s.store.InvalidateUserRefreshTokens(r.Context(), user.ID); err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to revoke sessions", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	s.recordAudit(r, "user.sessions_revoked", user.OrganizationID, user.ID.String(), nil)
+	w.WriteHeader(http.StatusNoContent)
+}
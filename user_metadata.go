@@ -0,0 +1,256 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+const (
+	// MaxUserMetadataSize bounds a user's metadata, encoded as JSON
+	MaxUserMetadataSize = 4096
+	// MaxUserMetadataKeys bounds the keys of a user's metadata, and the
+	// fields of a metadata schema
+	MaxUserMetadataKeys = 50
+)
+
+// metadataKeyPattern is what metadata keys look like: short identifiers
+// such as employee_id or crm.account
+var metadataKeyPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_.-]{0,63}$`)
+
+// MetadataFieldTypes are the types a metadata schema can require of a field
+var MetadataFieldTypes = []string{"string", "number", "boolean"}
+
+// UserMetadata is what integrators attach to a user, such as an employee ID
+// or a CRM reference. The service stores it without interpreting it.
+type UserMetadata map[string]interface{}
+
+// Value implements the driver.Valuer interface for UserMetadata
+func (m UserMetadata) Value() (driver.Value, error) {
+	if m == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(m)
+}
+
+// Scan implements the sql.Scanner interface for UserMetadata
+func (m *UserMetadata) Scan(value interface{}) error {
+	if value == nil {
+		*m = UserMetadata{}
+		return nil
+	}
+	return json.Unmarshal(value.([]byte), m)
+}
+
+// MetadataSchema is what an organization requires of its members' metadata.
+// Once set, members' metadata can only hold its fields.
+type MetadataSchema struct {
+	Fields map[string]MetadataField `json:"fields"`
+}
+
+// MetadataField describes one key of a metadata schema
+type MetadataField struct {
+	Type     string `json:"type"` // one of MetadataFieldTypes
+	Required bool   `json:"required,omitempty"`
+	// Pattern is a regular expression string values must match in full
+	Pattern string `json:"pattern,omitempty"`
+}
+
+// UpdateUserMetadataRequest changes a user's metadata as a JSON merge
+// patch (RFC 7396): keys set to null are removed, others are set, and keys
+// left out are kept
+type UpdateUserMetadataRequest struct {
+	Metadata UserMetadata `json:"metadata"`
+}
+
+// mergePatch returns current with a JSON merge patch applied
+func mergePatch[M ~map[string]interface{}](current, patch M) M {
+	patched := maps.Clone(current)
+	if patched == nil {
+		patched = M{}
+	}
+	for key, value := range patch {
+		if value == nil {
+			delete(patched, key)
+		} else {
+			patched[key] = value
+		}
+	}
+	return patched
+}
+
+// ValidateUserMetadata checks a user's metadata against the limits and the
+// organization's schema, if it has one
+func ValidateUserMetadata(metadata UserMetadata, schema *MetadataSchema) error {
+	if len(metadata) > MaxUserMetadataKeys {
+		return &ValidationError{Field: "metadata", Message: fmt.Sprintf("at most %d keys are allowed", MaxUserMetadataKeys)}
+	}
+	encoded, err := json.Marshal(metadata)
+	if err != nil {
+		return &ValidationError{Field: "metadata", Message: err.Error()}
+	}
+	if len(encoded) > MaxUserMetadataSize {
+		return &ValidationError{Field: "metadata", Message: fmt.Sprintf("must encode to at most %d bytes", MaxUserMetadataSize)}
+	}
+
+	var errs []error
+	for _, key := range slices.Sorted(maps.Keys(metadata)) {
+		field := "metadata." + key
+		if !metadataKeyPattern.MatchString(key) {
+			errs = append(errs, &ValidationError{Field: field, Message: "keys start with a letter and hold at most 64 letters, digits, '_', '.' or '-'"})
+			continue
+		}
+		if schema == nil {
+			continue
+		}
+		spec, ok := schema.Fields[key]
+		if !ok {
+			errs = append(errs, &ValidationError{Field: field, Message: "not in the organization's metadata schema"})
+			continue
+		}
+		if err := spec.check(metadata[key]); err != "" {
+			errs = append(errs, &ValidationError{Field: field, Message: err})
+		}
+	}
+	if schema != nil {
+		for _, key := range slices.Sorted(maps.Keys(schema.Fields)) {
+			if _, ok := metadata[key]; !ok && schema.Fields[key].Required {
+				errs = append(errs, &ValidationError{Field: "metadata." + key, Message: ErrEmptyField.Error()})
+			}
+		}
+	}
+	return joinValidationErrors(errs...)
+}
+
+// check returns why value does not fit the field, or "" if it does
+func (f MetadataField) check(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		if f.Type != "string" {
+			return "must be a " + f.Type
+		}
+		if f.Pattern != "" && !regexp.MustCompile(anchored(f.Pattern)).MatchString(v) {
+			return fmt.Sprintf("must match %q", f.Pattern)
+		}
+	case float64:
+		if f.Type != "number" {
+			return "must be a " + f.Type
+		}
+	case bool:
+		if f.Type != "boolean" {
+			return "must be a " + f.Type
+		}
+	default:
+		return "must be a " + f.Type
+	}
+	return ""
+}
+
+func anchored(pattern string) string {
+	return `^(?:` + pattern + `)$`
+}
+
+// validateMetadataSchema checks an organization's metadata schema
+func validateMetadataSchema(schema *MetadataSchema) error {
+	if schema == nil {
+		return nil
+	}
+	if len(schema.Fields) > MaxUserMetadataKeys {
+		return &ValidationError{Field: "user_metadata_schema", Message: fmt.Sprintf("at most %d fields are allowed", MaxUserMetadataKeys)}
+	}
+	for _, key := range slices.Sorted(maps.Keys(schema.Fields)) {
+		field := schema.Fields[key]
+		if !metadataKeyPattern.MatchString(key) {
+			return &ValidationError{Field: "user_metadata_schema", Message: fmt.Sprintf("invalid field name %q", key)}
+		}
+		if !slices.Contains(MetadataFieldTypes, field.Type) {
+			return &ValidationError{Field: "user_metadata_schema", Message: fmt.Sprintf("field %q: type must be one of %v", key, MetadataFieldTypes)}
+		}
+		if field.Pattern == "" {
+			continue
+		}
+		if field.Type != "string" {
+			return &ValidationError{Field: "user_metadata_schema", Message: fmt.Sprintf("field %q: only strings take a pattern", key)}
+		}
+		if _, err := regexp.Compile(anchored(field.Pattern)); err != nil {
+			return &ValidationError{Field: "user_metadata_schema", Message: fmt.Sprintf("field %q: invalid pattern: %s", key, err)}
+		}
+	}
+	return nil
+}
+
+// copyMetadata returns a copy of m that can be changed without changing m.
+// Values are replaced, never changed in place, so they can be shared.
+func copyMetadata(m UserMetadata) UserMetadata {
+	return maps.Clone(m)
+}
+
+// copyMetadataSchema returns a copy of s that shares no state with it
+func copyMetadataSchema(s *MetadataSchema) *MetadataSchema {
+	if s == nil {
+		return nil
+	}
+	return &MetadataSchema{Fields: maps.Clone(s.Fields)}
+}
+
+// handleUpdateUserMetadata applies a merge patch to a member's metadata.
+// The result must fit the organization's metadata schema, if it has one.
+func (s *Server) handleUpdateUserMetadata(w http.ResponseWriter, r *http.Request) {
+	orgID := pathOrgID(r)
+	member := GetResourceFromContext[*User](r.Context())
+
+	expectedVersion, ok := ifMatchVersion(w, r)
+	if !ok {
+		return
+	}
+
+	var req UpdateUserMetadataRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	settings, _, err := s.store.GetOrganizationSettings(r.Context(), orgID)
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to get organization settings", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	// The patch applies to the version the client read; the store checks
+	// it again as it writes
+	if member.Version != expectedVersion {
+		http.Error(w, ErrVersionConflict.Error(), http.StatusConflict)
+		return
+	}
+	metadata := mergePatch(member.Metadata, req.Metadata)
+	if err := ValidateUserMetadata(metadata, settings.UserMetadataSchema); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+
+	user, err := s.store.UpdateUserMetadata(r.Context(), orgID, member.ID, expectedVersion, metadata)
+	if err != nil {
+		switch err {
+		case ErrUserNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case ErrVersionConflict:
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			s.logger.ErrorContext(r.Context(), "failed to update user metadata", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Values can be personal data, so only which keys changed is recorded
+	changed := slices.Sorted(maps.Keys(req.Metadata))
+	s.recordAudit(r, "user.metadata_changed", orgID, user.ID.String(), AuditMetadata{"keys": strings.Join(changed, ", ")})
+
+	w.Header().Set("ETag", versionETag(user.Version))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateUserMetadata(t *testing.T) {
+	require.NoError(t, ValidateUserMetadata(UserMetadata{"employee_id": "E-1042", "crm.account": 7.0, "tags": []interface{}{"a"}}, nil))
+
+	var errs ValidationErrors
+	err := ValidateUserMetadata(UserMetadata{"1st": "x", "has space": "y"}, nil)
+	require.ErrorAs(t, err, &errs)
+	require.Len(t, errs, 2)
+
+	err = ValidateUserMetadata(UserMetadata{"notes": strings.Repeat("x", MaxUserMetadataSize)}, nil)
+	require.ErrorContains(t, err, "bytes")
+
+	schema := &MetadataSchema{Fields: map[string]MetadataField{
+		"employee_id": {Type: "string", Required: true, Pattern: `E-\d+`},
+		"contractor":  {Type: "boolean"},
+	}}
+	require.NoError(t, validateMetadataSchema(schema))
+	require.NoError(t, ValidateUserMetadata(UserMetadata{"employee_id": "E-1042", "contractor": true}, schema))
+
+	err = ValidateUserMetadata(UserMetadata{"employee_id": "1042", "contractor": "yes", "desk": "4F"}, schema)
+	require.ErrorAs(t, err, &errs)
+	require.Len(t, errs, 3, "pattern, type and unknown key")
+	err = ValidateUserMetadata(UserMetadata{}, schema)
+	require.ErrorContains(t, err, "metadata.employee_id")
+
+	require.Error(t, validateMetadataSchema(&MetadataSchema{Fields: map[string]MetadataField{"x": {Type: "date"}}}))
+	require.Error(t, validateMetadataSchema(&MetadataSchema{Fields: map[string]MetadataField{"x": {Type: "number", Pattern: `\d+`}}}))
+	require.Error(t, validateMetadataSchema(&MetadataSchema{Fields: map[string]MetadataField{"x": {Type: "string", Pattern: `(`}}}))
+}
+
+func TestMergePatch(t *testing.T) {
+	current := UserMetadata{"employee_id": "E-1", "team": "ops"}
+	patched := mergePatch(current, UserMetadata{"team": nil, "desk": "4F"})
+	require.Equal(t, UserMetadata{"employee_id": "E-1", "desk": "4F"}, patched)
+	require.Equal(t, UserMetadata{"employee_id": "E-1", "team": "ops"}, current, "the current metadata is left alone")
+	require.Equal(t, UserMetadata{}, mergePatch(nil, UserMetadata{"gone": nil}))
+}
+
+func TestUserMetadata(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	srv, err := NewServer(store, newTestConfig(t))
+	require.NoError(t, err)
+
+	org, err := store.CreateOrganization(ctx, "Acme", "owner@acme.test", "Owner")
+	require.NoError(t, err)
+	member, err := store.AddUserToOrganization(ctx, org.ID, "member@acme.test", "Member")
+	require.NoError(t, err)
+	owner, err := store.GetUser(ctx, org.OwnerID)
+	require.NoError(t, err)
+	token, err := srv.tokenManager.GenerateToken(owner)
+	require.NoError(t, err)
+
+	path := "/organizations/" + org.ID.String() + "/users/" + member.ID.String() + "/metadata"
+	patch := func(version int, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPatch, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		if version > 0 {
+			req.Header.Set("If-Match", versionETag(version))
+		}
+		addCSRFToken(t, srv, req)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return w
+	}
+
+	require.Equal(t, http.StatusPreconditionRequired, patch(0, `{"metadata":{"employee_id":"E-1042"}}`).Code)
+
+	w := patch(member.Version, `{"metadata":{"employee_id":"E-1042","team":"ops"}}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var user User
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&user))
+	require.Equal(t, UserMetadata{"employee_id": "E-1042", "team": "ops"}, user.Metadata)
+	require.Equal(t, versionETag(user.Version), w.Header().Get("ETag"))
+
+	require.Equal(t, http.StatusConflict, patch(member.Version, `{"metadata":{"team":null}}`).Code)
+
+	w = patch(user.Version, `{"metadata":{"team":null}}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	user = User{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&user))
+	require.Equal(t, UserMetadata{"employee_id": "E-1042"}, user.Metadata)
+
+	t.Run("Listings include metadata", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/organizations/"+org.ID.String()+"/users", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Contains(t, w.Body.String(), `"metadata":{"employee_id":"E-1042"}`)
+	})
+
+	t.Run("The organization's schema applies", func(t *testing.T) {
+		_, version, err := store.GetOrganizationSettings(ctx, org.ID)
+		require.NoError(t, err)
+		_, err = store.UpdateOrganizationSettings(ctx, org.ID, version, &OrganizationSettings{
+			UserMetadataSchema: &MetadataSchema{Fields: map[string]MetadataField{
+				"employee_id": {Type: "string", Required: true, Pattern: `E-\d+`},
+			}},
+		})
+		require.NoError(t, err)
+
+		require.Equal(t, http.StatusBadRequest, patch(user.Version, `{"metadata":{"desk":"4F"}}`).Code)
+		require.Equal(t, http.StatusBadRequest, patch(user.Version, `{"metadata":{"employee_id":null}}`).Code)
+		require.Equal(t, http.StatusOK, patch(user.Version, `{"metadata":{"employee_id":"E-7"}}`).Code)
+	})
+
+	t.Run("The metadata scope adds it to claims", func(t *testing.T) {
+		user, err := store.GetUser(ctx, member.ID)
+		require.NoError(t, err)
+		require.Nil(t, oidcClaims(user, "openid profile").Metadata)
+		require.Equal(t, UserMetadata{"employee_id": "E-7"}, oidcClaims(user, "openid metadata").Metadata)
+	})
+}
+
+func TestUserMetadataStore(t *testing.T) {
+	testdb := setupTestDB(t)
+	defer testdb.teardown(t)
+
+	ctx := context.Background()
+	org, err := testdb.DB.CreateOrganization(ctx, "Metadata Org", "owner@metadata.test", "Owner")
+	require.NoError(t, err)
+	member, err := testdb.DB.AddUserToOrganization(ctx, org.ID, "member@metadata.test", "Member")
+	require.NoError(t, err)
+	require.Empty(t, member.Metadata)
+
+	updated, err := testdb.DB.UpdateUserMetadata(ctx, org.ID, member.ID, member.Version, UserMetadata{"employee_id": "E-1042", "level": 3.0})
+	require.NoError(t, err)
+	require.Equal(t, member.Version+1, updated.Version)
+	require.Equal(t, "member@metadata.test", updated.Email)
+
+	_, err = testdb.DB.UpdateUserMetadata(ctx, org.ID, member.ID, member.Version, UserMetadata{})
+	require.ErrorIs(t, err, ErrVersionConflict)
+	_, err = testdb.DB.UpdateUserMetadata(ctx, org.ID, org.OwnerID, 99, UserMetadata{})
+	require.ErrorIs(t, err, ErrVersionConflict)
+
+	got, err := testdb.DB.GetUser(ctx, member.ID)
+	require.NoError(t, err)
+	require.Equal(t, UserMetadata{"employee_id": "E-1042", "level": 3.0}, got.Metadata)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxUserSearchQueryLength bounds the q of a member search, in characters
+const MaxUserSearchQueryLength = 255
+
+// userSearchRank scores how well a user matches a search: 3 if their email
+// or name is the query, 2 if either starts with it, 1 if either contains
+// it, and 0 if neither does. The query must be lowercase.
+func userSearchRank(u *User, query string) int {
+	rank := 0
+	for _, value := range []string{strings.ToLower(u.Email), strings.ToLower(u.Name)} {
+		switch {
+		case value == query:
+			rank = max(rank, 3)
+		case strings.HasPrefix(value, query):
+			rank = max(rank, 2)
+		case strings.Contains(value, query):
+			rank = max(rank, 1)
+		}
+	}
+	return rank
+}
+
+// rankUsers returns the users matching query, best matches first and then
+// by email. Stores that cannot search an index, because they hold users in
+// memory or encrypt them, search this way.
+func rankUsers(users []User, query string) []User {
+	query = strings.ToLower(query)
+	ranks := make(map[*User]int, len(users))
+	matched := make([]*User, 0, len(users))
+	for i := range users {
+		if rank := userSearchRank(&users[i], query); rank > 0 {
+			ranks[&users[i]] = rank
+			matched = append(matched, &users[i])
+		}
+	}
+	slices.SortStableFunc(matched, func(a, b *User) int {
+		if ranks[a] != ranks[b] {
+			return ranks[b] - ranks[a]
+		}
+		return strings.Compare(a.Email, b.Email)
+	})
+
+	ranked := make([]User, len(matched))
+	for i, u := range matched {
+		ranked[i] = *u
+	}
+	return ranked
+}
+
+// likePattern matches values containing s with LIKE, escaping the
+// wildcards in s
+func likePattern(s string) string {
+	return "%" + strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s) + "%"
+}
+
+// handleSearchOrganizationUsers finds the organization's members whose
+// name or email contains q, best matches first
+func (s *Server) handleSearchOrganizationUsers(w http.ResponseWriter, r *http.Request) {
+	limit, offset, ok := parsePagination(r)
+	if !ok {
+		http.Error(w, "Invalid pagination parameters", http.StatusBadRequest)
+		return
+	}
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	switch {
+	case query == "":
+		writeValidationError(w, &ValidationError{Field: "q", Message: ErrEmptyField.Error()})
+		return
+	case utf8.RuneCountInString(query) > MaxUserSearchQueryLength:
+		writeValidationError(w, &ValidationError{Field: "q", Message: ErrFieldTooLong.Error()})
+		return
+	}
+
+	users, total, err := s.store.SearchOrganizationUsers(r.Context(), pathOrgID(r), query, limit, offset)
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to search organization users", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set(TotalCountHeader, strconv.Itoa(total))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(users)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRankUsers(t *testing.T) {
+	users := []User{
+		{Email: "zed@acme.test", Name: "Ann Smith"},
+		{Email: "ann@acme.test", Name: "Ann"},
+		{Email: "joanne@acme.test", Name: "Joanne"},
+		{Email: "annika@acme.test", Name: "Annika"},
+		{Email: "bob@acme.test", Name: "Bob"},
+	}
+	var emails []string
+	for _, u := range rankUsers(users, "ANN") {
+		emails = append(emails, u.Email)
+	}
+	require.Equal(t, []string{"ann@acme.test", "annika@acme.test", "zed@acme.test", "joanne@acme.test"}, emails)
+	require.Empty(t, rankUsers(users, "carol"))
+
+	require.Equal(t, `%50\%\_off\\%`, likePattern(`50%_off\`))
+}
+
+func TestSearchOrganizationUsers(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	srv, err := NewServer(store, newTestConfig(t))
+	require.NoError(t, err)
+
+	org, err := store.CreateOrganization(ctx, "Acme", "owner@acme.test", "Owner")
+	require.NoError(t, err)
+	for _, name := range []string{"Ann", "Annika", "Joanne"} {
+		_, err := store.AddUserToOrganization(ctx, org.ID, name+"@acme.test", name)
+		require.NoError(t, err)
+	}
+	other, err := store.CreateOrganization(ctx, "Initech", "anne@initech.test", "Anne")
+	require.NoError(t, err)
+	owner, err := store.GetUser(ctx, org.OwnerID)
+	require.NoError(t, err)
+	token, err := srv.tokenManager.GenerateToken(owner)
+	require.NoError(t, err)
+
+	search := func(orgID, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/organizations/"+orgID+"/users/search?"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return w
+	}
+
+	w := search(org.ID.String(), "q=ann&limit=2")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.Equal(t, "3", w.Header().Get(TotalCountHeader))
+	var users []User
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&users))
+	require.Len(t, users, 2)
+	require.Equal(t, "Ann", users[0].Name)
+	require.Equal(t, "Annika", users[1].Name)
+
+	w = search(org.ID.String(), "q=ann&offset=2")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), "Joanne")
+	require.NotContains(t, w.Body.String(), "initech", "other organizations' members are not found")
+
+	require.Equal(t, http.StatusBadRequest, search(org.ID.String(), "q=+").Code)
+	require.Equal(t, http.StatusForbidden, search(other.ID.String(), "q=ann").Code)
+}
+
+func TestSearchOrganizationUsersStore(t *testing.T) {
+	testdb := setupTestDB(t)
+	defer testdb.teardown(t)
+
+	ctx := context.Background()
+	org, err := testdb.DB.CreateOrganization(ctx, "Search Org", "owner@search.test", "Owner")
+	require.NoError(t, err)
+	for _, name := range []string{"Ann", "Annika", "Joanne", "Bob"} {
+		_, err := testdb.DB.AddUserToOrganization(ctx, org.ID, name+"@search.test", name)
+		require.NoError(t, err)
+	}
+
+	users, total, err := testdb.DB.SearchOrganizationUsers(ctx, org.ID, "ann", 10, 0)
+	require.NoError(t, err)
+	require.Equal(t, 3, total)
+	require.Equal(t, "Ann", users[0].Name, "exact matches come first")
+
+	users, total, err = testdb.DB.SearchOrganizationUsers(ctx, org.ID, "_", 10, 0)
+	require.NoError(t, err)
+	require.Zero(t, total, "wildcards are matched literally")
+	require.Empty(t, users)
+}
@@ -4,6 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"net/mail"
+	"net/netip"
+	"strings"
 	"unicode/utf8"
 
 	"github.com/google/uuid"
@@ -18,18 +20,75 @@ var (
 )
 
 type ValidationError struct {
-	Field   string
-	Message string
+	Field   string `json:"field"`
+	Message string `json:"message"`
 }
 
 func (e *ValidationError) Error() string {
 	return fmt.Sprintf("%s: %s", e.Field, e.Message)
 }
 
+// ValidationErrors is every field a request got wrong, so that clients can
+// point them all out at once. errors.As finds each *ValidationError.
+type ValidationErrors []*ValidationError
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e ValidationErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+	return errs
+}
+
+// joinValidationErrors collects the field errors among errs, returning nil
+// if there are none. Errors other than validation errors are returned as
+// they are, since they are not the client's to fix.
+func joinValidationErrors(errs ...error) error {
+	var joined ValidationErrors
+	for _, err := range errs {
+		var many ValidationErrors
+		var one *ValidationError
+		switch {
+		case err == nil:
+		case errors.As(err, &many):
+			joined = append(joined, many...)
+		case errors.As(err, &one):
+			joined = append(joined, one)
+		default:
+			return err
+		}
+	}
+	if len(joined) == 0 {
+		return nil
+	}
+	return joined
+}
+
+// asField reports a single-value validator's error against the request
+// field it checked, such as owner_email rather than email
+func asField(field string, err error) error {
+	var valErr *ValidationError
+	if errors.As(err, &valErr) {
+		return &ValidationError{Field: field, Message: valErr.Message}
+	}
+	return err
+}
+
 const (
 	MaxNameLength       = 255
 	MaxEmailLength      = 255
 	MaxRequestBodyBytes = 1 * 1024 * 1024 // 1MB
+	MaxAllowedOrigins   = 20
+	MaxIPAllowlist      = 50
+	MaxWebhookURLLength = 2048
 )
 
 // ValidateEmail checks if an email address is valid
@@ -77,30 +136,73 @@ func ValidateName(name string) error {
 
 // ValidateCreateOrganizationRequest validates the create organization request
 func ValidateCreateOrganizationRequest(req *CreateOrganizationRequest) error {
-	if err := ValidateName(req.Name); err != nil {
-		return err
+	var slugErr error
+	if req.Slug != "" {
+		req.Slug = strings.ToLower(strings.TrimSpace(req.Slug))
+		slugErr = ValidateSlug(req.Slug)
 	}
+	return joinValidationErrors(
+		ValidateName(req.Name),
+		asField("owner_email", ValidateEmail(req.OwnerEmail)),
+		asField("owner_name", ValidateName(req.OwnerName)),
+		slugErr,
+	)
+}
 
-	if err := ValidateEmail(req.OwnerEmail); err != nil {
-		return err
-	}
+// ValidateAddUserRequest validates the add user request
+func ValidateAddUserRequest(req *AddUserRequest) error {
+	return joinValidationErrors(
+		ValidateEmail(req.Email),
+		ValidateName(req.Name),
+	)
+}
 
-	if err := ValidateName(req.OwnerName); err != nil {
-		return err
-	}
+// ValidateOrganizationSettings validates organization settings. Tenants may
+// only register exact origins; wildcards and regular expressions are reserved
+// for the server's own ALLOWED_ORIGINS.
+func ValidateOrganizationSettings(settings *OrganizationSettings) error {
+	return joinValidationErrors(
+		validateAllowedOrigins(settings.AllowedOrigins),
+		validateIPAllowlist(settings.IPAllowlist),
+		validateEmailDomains(settings.EmailDomains),
+		validateMetadataSchema(settings.UserMetadataSchema),
+		validateBranding(settings.Branding),
+	)
+}
 
+func validateAllowedOrigins(origins []string) error {
+	if len(origins) > MaxAllowedOrigins {
+		return &ValidationError{Field: "allowed_origins", Message: fmt.Sprintf("at most %d origins are allowed", MaxAllowedOrigins)}
+	}
+	for _, origin := range origins {
+		p, err := parseOriginPattern(origin)
+		if err != nil || p.exact == "" {
+			return &ValidationError{Field: "allowed_origins", Message: fmt.Sprintf("invalid origin %q: expected scheme://host[:port]", origin)}
+		}
+	}
 	return nil
 }
 
-// ValidateAddUserRequest validates the add user request
-func ValidateAddUserRequest(req *AddUserRequest) error {
-	if err := ValidateEmail(req.Email); err != nil {
-		return err
+func validateIPAllowlist(ranges []string) error {
+	if len(ranges) > MaxIPAllowlist {
+		return &ValidationError{Field: "ip_allowlist", Message: fmt.Sprintf("at most %d ranges are allowed", MaxIPAllowlist)}
 	}
-
-	if err := ValidateName(req.Name); err != nil {
-		return err
+	for _, cidr := range ranges {
+		if _, err := netip.ParsePrefix(cidr); err != nil {
+			return &ValidationError{Field: "ip_allowlist", Message: fmt.Sprintf("invalid range %q: expected CIDR notation such as 203.0.113.0/24", cidr)}
+		}
 	}
+	return nil
+}
 
+func validateEmailDomains(domains []string) error {
+	if len(domains) > MaxEmailDomains {
+		return &ValidationError{Field: "email_domains", Message: fmt.Sprintf("at most %d domains are allowed", MaxEmailDomains)}
+	}
+	for _, domain := range domains {
+		if !isDomainName(domain) {
+			return &ValidationError{Field: "email_domains", Message: fmt.Sprintf("invalid domain %q: expected a name such as acme.com", domain)}
+		}
+	}
 	return nil
 }
@@ -117,4 +117,85 @@ func TestValidation(t *testing.T) {
 			})
 		}
 	})
+
+	t.Run("Organization settings validation", func(t *testing.T) {
+		tests := []struct {
+			name    string
+			origins []string
+			wantErr bool
+		}{
+			{
+				name:    "Exact origins",
+				origins: []string{"https://app.customer.com", "http://localhost:8080"},
+				wantErr: false,
+			},
+			{
+				name:    "Wildcard origin",
+				origins: []string{"https://*.customer.com"},
+				wantErr: true,
+			},
+			{
+				name:    "Regex origin",
+				origins: []string{`regex:https://.*`},
+				wantErr: true,
+			},
+			{
+				name:    "Origin with path",
+				origins: []string{"https://app.customer.com/login"},
+				wantErr: true,
+			},
+			{
+				name:    "Too many origins",
+				origins: make([]string, MaxAllowedOrigins+1),
+				wantErr: true,
+			},
+		}
+
+		for _, tc := range tests {
+			t.Run(tc.name, func(t *testing.T) {
+				err := ValidateOrganizationSettings(&OrganizationSettings{AllowedOrigins: tc.origins})
+				if tc.wantErr {
+					require.Error(t, err)
+				} else {
+					require.NoError(t, err)
+				}
+			})
+		}
+	})
+	t.Run("Branding validation", func(t *testing.T) {
+		require.NoError(t, ValidateOrganizationSettings(&OrganizationSettings{Branding: &Branding{
+			LogoURL: "https://cdn.acme.test/logo.png", PrimaryColor: "#1A73e8", ProductName: "Acme Portal",
+		}}))
+
+		err := ValidateOrganizationSettings(&OrganizationSettings{Branding: &Branding{
+			LogoURL: "http://cdn.acme.test/logo.png", PrimaryColor: "red", ProductName: "Acme\r\nBcc: x",
+		}})
+		var errs ValidationErrors
+		require.ErrorAs(t, err, &errs)
+		var fields []string
+		for _, e := range errs {
+			fields = append(fields, e.Field)
+		}
+		require.Equal(t, []string{"branding.logo_url", "branding.primary_color", "branding.product_name"}, fields)
+	})
+	t.Run("Every invalid field is reported", func(t *testing.T) {
+		err := ValidateCreateOrganizationRequest(&CreateOrganizationRequest{
+			Name:       "Acme",
+			OwnerEmail: "not-an-email",
+			OwnerName:  strings.Repeat("a", MaxNameLength+1),
+		})
+		require.Equal(t, ValidationErrors{
+			{Field: "owner_email", Message: ErrInvalidEmail.Error()},
+			{Field: "owner_name", Message: ErrFieldTooLong.Error()},
+		}, err)
+		require.EqualError(t, err, "owner_email: invalid email format; owner_name: field exceeds maximum length")
+
+		var valErr *ValidationError
+		require.ErrorAs(t, err, &valErr)
+		require.Equal(t, "owner_email", valErr.Field)
+
+		require.NoError(t, ValidateCreateOrganizationRequest(&CreateOrganizationRequest{
+			Name: "Acme", OwnerEmail: "owner@acme.test", OwnerName: "Owner",
+		}))
+	})
 }
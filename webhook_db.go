@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+const webhookColumns = `id, organization_id, url, secret, events, enabled, created_at, updated_at`
+
+const webhookDeliveryColumns = `id, webhook_id, organization_id, event, payload, status, attempts, response_status, last_error, created_at, updated_at`
+
+// CreateWebhook stores a new webhook, filling in its timestamps
+func (db *DB) CreateWebhook(ctx context.Context, webhook *Webhook) error {
+	return db.tenantTx(ctx, webhook.OrganizationID, func(tx *sqlx.Tx) error {
+		return tx.GetContext(ctx, webhook, `
+			INSERT INTO webhooks (id, organization_id, url, secret, events, enabled)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			RETURNING `+webhookColumns,
+			webhook.ID, webhook.OrganizationID, webhook.URL, webhook.Secret, webhook.Events, webhook.Enabled)
+	})
+}
+
+// GetWebhook retrieves one of an organization's webhooks
+func (db *DB) GetWebhook(ctx context.Context, orgID, id uuid.UUID) (*Webhook, error) {
+	webhook := &Webhook{}
+	err := db.tenantRead(ctx, orgID, func(q sqlx.QueryerContext) error {
+		return sqlx.GetContext(ctx, q, webhook, `
+			SELECT `+webhookColumns+` FROM webhooks WHERE id = $1 AND organization_id = $2
+		`, id, orgID)
+	})
+	if err == sql.ErrNoRows {
+		return nil, ErrWebhookNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+// ListWebhooks retrieves an organization's webhooks, oldest first
+func (db *DB) ListWebhooks(ctx context.Context, orgID uuid.UUID) ([]Webhook, error) {
+	webhooks := []Webhook{}
+	err := db.tenantRead(ctx, orgID, func(q sqlx.QueryerContext) error {
+		return sqlx.SelectContext(ctx, q, &webhooks, `
+			SELECT `+webhookColumns+` FROM webhooks WHERE organization_id = $1
+			ORDER BY created_at, id
+		`, orgID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+// UpdateWebhook replaces the URL, events and enabled state of a webhook,
+// filling in the rest of its fields
+func (db *DB) UpdateWebhook(ctx context.Context, webhook *Webhook) error {
+	err := db.tenantTx(ctx, webhook.OrganizationID, func(tx *sqlx.Tx) error {
+		return tx.GetContext(ctx, webhook, `
+			UPDATE webhooks SET url = $3, events = $4, enabled = $5, updated_at = NOW()
+			WHERE id = $1 AND organization_id = $2
+			RETURNING `+webhookColumns,
+			webhook.ID, webhook.OrganizationID, webhook.URL, webhook.Events, webhook.Enabled)
+	})
+	if err == sql.ErrNoRows {
+		return ErrWebhookNotFound
+	}
+	return err
+}
+
+// DeleteWebhook removes a webhook along with its delivery log
+func (db *DB) DeleteWebhook(ctx context.Context, orgID, id uuid.UUID) error {
+	return db.tenantTx(ctx, orgID, func(tx *sqlx.Tx) error {
+		result, err := tx.ExecContext(ctx, `
+			DELETE FROM webhooks WHERE id = $1 AND organization_id = $2
+		`, id, orgID)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrWebhookNotFound
+		}
+		return nil
+	})
+}
+
+// CreateWebhookDelivery stores a new delivery, filling in its timestamps
+func (db *DB) CreateWebhookDelivery(ctx context.Context, delivery *WebhookDelivery) error {
+	return db.tenantTx(ctx, delivery.OrganizationID, func(tx *sqlx.Tx) error {
+		return tx.GetContext(ctx, delivery, `
+			INSERT INTO webhook_deliveries (id, webhook_id, organization_id, event, payload, status)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			RETURNING `+webhookDeliveryColumns,
+			delivery.ID, delivery.WebhookID, delivery.OrganizationID, delivery.Event, delivery.Payload, delivery.Status)
+	})
+}
+
+// GetWebhookDelivery retrieves a delivery for the job sending it
+func (db *DB) GetWebhookDelivery(ctx context.Context, id uuid.UUID) (*WebhookDelivery, error) {
+	delivery := &WebhookDelivery{}
+	err := db.GetContext(ctx, delivery, `
+		SELECT `+webhookDeliveryColumns+` FROM webhook_deliveries WHERE id = $1
+	`, id)
+	if err == sql.ErrNoRows {
+		return nil, ErrWebhookDeliveryNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+// RecordWebhookAttempt counts an attempt at a delivery and its outcome
+func (db *DB) RecordWebhookAttempt(ctx context.Context, id uuid.UUID, status string, responseStatus *int, lastError *string) error {
+	_, err := db.ExecContext(ctx, `
+		UPDATE webhook_deliveries
+		SET status = $2, attempts = attempts + 1, response_status = $3, last_error = $4, updated_at = NOW()
+		WHERE id = $1
+	`, id, status, responseStatus, lastError)
+	return err
+}
+
+// ListWebhookDeliveries retrieves a page of a webhook's deliveries, newest
+// first, and how many there are in total
+func (db *DB) ListWebhookDeliveries(ctx context.Context, orgID, webhookID uuid.UUID, limit, offset int) ([]WebhookDelivery, int, error) {
+	var rows []struct {
+		WebhookDelivery
+		Total int `db:"total"`
+	}
+	err := db.tenantRead(ctx, orgID, func(q sqlx.QueryerContext) error {
+		return sqlx.SelectContext(ctx, q, &rows, `
+			SELECT `+webhookDeliveryColumns+`, COUNT(*) OVER () AS total
+			FROM webhook_deliveries
+			WHERE webhook_id = $1 AND organization_id = $2
+			ORDER BY created_at DESC, id
+			LIMIT $3 OFFSET $4
+		`, webhookID, orgID, limit, offset)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	deliveries := make([]WebhookDelivery, len(rows))
+	total := 0
+	for i, row := range rows {
+		deliveries[i], total = row.WebhookDelivery, row.Total
+	}
+	return deliveries, total, nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+type WebhookRequest struct {
+	URL     string   `json:"url"`
+	Events  []string `json:"events"`
+	Enabled *bool    `json:"enabled,omitempty"` // defaults to true
+}
+
+// decodeWebhookRequest reads and validates a webhook request body, writing
+// the error response if it is invalid
+func (s *Server) decodeWebhookRequest(w http.ResponseWriter, r *http.Request) (*WebhookRequest, bool) {
+	var req WebhookRequest
+	if !decodeJSON(w, r, &req) {
+		return nil, false
+	}
+
+	if err := joinValidationErrors(s.webhooks.config.ValidateURL(req.URL), ValidateWebhookEvents(req.Events)); err != nil {
+		writeValidationError(w, err)
+		return nil, false
+	}
+	return &req, true
+}
+
+// handleCreateWebhook registers a webhook. Its signing secret is only
+// returned in this response.
+func (s *Server) handleCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	orgID := pathOrgID(r)
+
+	req, ok := s.decodeWebhookRequest(w, r)
+	if !ok {
+		return
+	}
+
+	webhook := &Webhook{
+		ID:             uuid.New(),
+		OrganizationID: orgID,
+		URL:            req.URL,
+		Events:         req.Events,
+		Secret:         newWebhookSecret(),
+		Enabled:        req.Enabled == nil || *req.Enabled,
+	}
+	if err := s.store.CreateWebhook(r.Context(), webhook); err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to create webhook", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	s.recordAudit(r, "webhook.created", orgID, webhook.ID.String(), AuditMetadata{
+		"url":    webhook.URL,
+		"events": strings.Join(webhook.Events, ","),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(webhook)
+}
+
+func (s *Server) handleListWebhooks(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := s.store.ListWebhooks(r.Context(), pathOrgID(r))
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to list webhooks", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(webhooks)
+}
+
+func (s *Server) handleGetWebhook(w http.ResponseWriter, r *http.Request) {
+	webhook := GetResourceFromContext[*Webhook](r.Context())
+	webhook.Secret = ""
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(webhook)
+}
+
+func (s *Server) handleUpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	orgID := pathOrgID(r)
+	webhookID := GetResourceFromContext[*Webhook](r.Context()).ID
+
+	req, ok := s.decodeWebhookRequest(w, r)
+	if !ok {
+		return
+	}
+
+	webhook := &Webhook{
+		ID:             webhookID,
+		OrganizationID: orgID,
+		URL:            req.URL,
+		Events:         req.Events,
+		Enabled:        req.Enabled == nil || *req.Enabled,
+	}
+	if err := s.store.UpdateWebhook(r.Context(), webhook); err != nil {
+		switch err {
+		case ErrWebhookNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			s.logger.ErrorContext(r.Context(), "failed to update webhook", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+	webhook.Secret = ""
+
+	s.recordAudit(r, "webhook.updated", orgID, webhookID.String(), AuditMetadata{
+		"url":     webhook.URL,
+		"events":  strings.Join(webhook.Events, ","),
+		"enabled": strconv.FormatBool(webhook.Enabled),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(webhook)
+}
+
+func (s *Server) handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	orgID := pathOrgID(r)
+	webhookID := GetResourceFromContext[*Webhook](r.Context()).ID
+
+	if err := s.store.DeleteWebhook(r.Context(), orgID, webhookID); err != nil {
+		switch err {
+		case ErrWebhookNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			s.logger.ErrorContext(r.Context(), "failed to delete webhook", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	s.recordAudit(r, "webhook.deleted", orgID, webhookID.String(), nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleListWebhookDeliveries returns a webhook's delivery log, newest first
+func (s *Server) handleListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	orgID := pathOrgID(r)
+	// Loaded, so a deleted webhook is told apart from one with no
+	// deliveries yet
+	webhookID := GetResourceFromContext[*Webhook](r.Context()).ID
+
+	limit, offset, ok := parsePagination(r)
+	if !ok {
+		http.Error(w, "Invalid pagination parameters", http.StatusBadRequest)
+		return
+	}
+
+	deliveries, total, err := s.store.ListWebhookDeliveries(r.Context(), orgID, webhookID, limit, offset)
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to list webhook deliveries", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set(TotalCountHeader, strconv.Itoa(total))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deliveries)
+}
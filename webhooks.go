@@ -0,0 +1,399 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrWebhookNotFound         = errors.New("webhook not found")
+	ErrWebhookDeliveryNotFound = errors.New("webhook delivery not found")
+)
+
+// Events organizations can subscribe their webhooks to
+const (
+	WebhookUserCreated = "user.created"
+	WebhookUserRemoved = "user.removed"
+	WebhookOrgUpdated  = "org.updated"
+	WebhookLoginFailed = "login.failed"
+	// WebhookQuotaThreshold is sent when the organization reaches a
+	// threshold of one of its quotas
+	WebhookQuotaThreshold = "quota.threshold"
+)
+
+// WebhookEvents lists every event a webhook can subscribe to
+var WebhookEvents = []string{WebhookUserCreated, WebhookUserRemoved, WebhookOrgUpdated, WebhookLoginFailed, WebhookQuotaThreshold}
+
+// webhookEventActions maps the audited actions that webhooks hear about to
+// the event they are delivered as
+var webhookEventActions = map[string]string{
+	"user.added":                     WebhookUserCreated,
+	"user.deleted":                   WebhookUserRemoved,
+	"organization.settings_updated":  WebhookOrgUpdated,
+	"organization.tier_changed":      WebhookOrgUpdated,
+	"organization.retention_changed": WebhookOrgUpdated,
+	"organization.suspended":         WebhookOrgUpdated,
+	"organization.reinstated":        WebhookOrgUpdated,
+	"auth.login_failed":              WebhookLoginFailed,
+	"organization.quota_threshold":   WebhookQuotaThreshold,
+}
+
+// Webhook is an organization's endpoint for event notifications
+type Webhook struct {
+	ID             uuid.UUID       `db:"id" json:"id"`
+	OrganizationID uuid.UUID       `db:"organization_id" json:"organization_id"`
+	URL            string          `db:"url" json:"url"`
+	Events         WebhookEventSet `db:"events" json:"events"`
+	// Secret signs deliveries. It is only returned when the webhook is created.
+	Secret    string    `db:"secret" json:"secret,omitempty"`
+	Enabled   bool      `db:"enabled" json:"enabled"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt time.Time `db:"updated_at" json:"updated_at"`
+}
+
+// WebhookEventSet is the events a webhook subscribes to
+type WebhookEventSet []string
+
+// Value implements the driver.Valuer interface for WebhookEventSet
+func (e WebhookEventSet) Value() (driver.Value, error) {
+	if e == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(e)
+}
+
+// Scan implements the sql.Scanner interface for WebhookEventSet
+func (e *WebhookEventSet) Scan(value interface{}) error {
+	if value == nil {
+		*e = WebhookEventSet{}
+		return nil
+	}
+	return json.Unmarshal(value.([]byte), e)
+}
+
+// Webhook delivery states. A failed delivery has used up its attempts.
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookDelivery records sending one event to one webhook
+type WebhookDelivery struct {
+	ID             uuid.UUID       `db:"id" json:"id"`
+	WebhookID      uuid.UUID       `db:"webhook_id" json:"webhook_id"`
+	OrganizationID uuid.UUID       `db:"organization_id" json:"organization_id"`
+	Event          string          `db:"event" json:"event"`
+	Payload        json.RawMessage `db:"payload" json:"payload"`
+	Status         string          `db:"status" json:"status"`
+	Attempts       int             `db:"attempts" json:"attempts"`
+	ResponseStatus *int            `db:"response_status" json:"response_status,omitempty"`
+	LastError      *string         `db:"last_error" json:"last_error,omitempty"`
+	CreatedAt      time.Time       `db:"created_at" json:"created_at"`
+	UpdatedAt      time.Time       `db:"updated_at" json:"updated_at"`
+}
+
+// WebhookPayload is the body of a delivery
+type WebhookPayload struct {
+	ID             uuid.UUID     `json:"id"` // the delivery's ID, the same on every attempt
+	Event          string        `json:"event"`
+	OrganizationID uuid.UUID     `json:"organization_id"`
+	ActorID        *uuid.UUID    `json:"actor_id,omitempty"`
+	TargetID       string        `json:"target_id,omitempty"`
+	Metadata       AuditMetadata `json:"metadata,omitempty"`
+	CreatedAt      time.Time     `json:"created_at"`
+}
+
+// Headers sent with every delivery. The signature is the hex HMAC-SHA256,
+// keyed with the webhook's secret, of the timestamp, a dot and the body:
+// receivers recompute it and reject stale timestamps to stop replays.
+const (
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookDeliveryHeader  = "X-Webhook-Delivery"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// SignWebhook returns the signature header value for a delivery body sent
+// at timestamp, in Unix seconds
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// newWebhookSecret returns a random signing secret
+func newWebhookSecret() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return "whsec_" + hex.EncodeToString(b)
+}
+
+// WebhookConfig controls how deliveries are sent
+type WebhookConfig struct {
+	Timeout time.Duration // for each delivery attempt
+	// AllowPrivateNetworks lets webhooks use plain HTTP and reach loopback
+	// and private addresses, for local development. Otherwise tenants
+	// could use webhooks to probe the service's own network.
+	AllowPrivateNetworks bool
+}
+
+// NewWebhookConfig creates a webhook configuration from settings
+func NewWebhookConfig(settings Settings) *WebhookConfig {
+	timeout, err := time.ParseDuration(settings.get("WEBHOOK_TIMEOUT", "10s"))
+	if err != nil || timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	return &WebhookConfig{
+		Timeout:              timeout,
+		AllowPrivateNetworks: settings.getBool("WEBHOOK_ALLOW_PRIVATE_NETWORKS", false),
+	}
+}
+
+// ValidateURL checks that a webhook URL is one deliveries may be sent to
+func (c *WebhookConfig) ValidateURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") {
+		return &ValidationError{Field: "url", Message: "expected an absolute http(s) URL"}
+	}
+	if u.Scheme != "https" && !c.AllowPrivateNetworks {
+		return &ValidationError{Field: "url", Message: "webhooks must use https"}
+	}
+	if len(rawURL) > MaxWebhookURLLength {
+		return &ValidationError{Field: "url", Message: ErrFieldTooLong.Error()}
+	}
+	if u.User != nil {
+		return &ValidationError{Field: "url", Message: "credentials are not allowed in the URL"}
+	}
+	if ip := net.ParseIP(u.Hostname()); ip != nil && !c.AllowPrivateNetworks && !isPublicIP(ip) {
+		return &ValidationError{Field: "url", Message: "webhooks cannot target private addresses"}
+	}
+	return nil
+}
+
+// isPublicIP reports whether ip is routable on the internet
+func isPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast())
+}
+
+// ValidateWebhookEvents checks that events names known events, at least one
+func ValidateWebhookEvents(events []string) error {
+	if len(events) == 0 {
+		return &ValidationError{Field: "events", Message: ErrEmptyField.Error()}
+	}
+	for _, event := range events {
+		if !slices.Contains(WebhookEvents, event) {
+			return &ValidationError{Field: "events", Message: fmt.Sprintf("unknown event %q", event)}
+		}
+	}
+	return nil
+}
+
+// webhookDeliveryJob is the kind of job that sends a delivery
+const webhookDeliveryJob = "webhook.deliver"
+
+type webhookDeliveryPayload struct {
+	DeliveryID uuid.UUID `json:"delivery_id"`
+}
+
+// WebhookDispatcher turns audited actions into webhook deliveries. Each is
+// sent by a background job, so failed deliveries are retried with the job
+// runner's backoff.
+type WebhookDispatcher struct {
+	store       WebhookStore
+	jobs        *JobRunner
+	config      *WebhookConfig
+	client      *http.Client
+	maxAttempts int
+	logger      *slog.Logger
+	now         func() time.Time
+}
+
+func NewWebhookDispatcher(store WebhookStore, jobs *JobRunner, config *WebhookConfig, logger *slog.Logger) *WebhookDispatcher {
+	dialer := &net.Dialer{Timeout: config.Timeout}
+	if !config.AllowPrivateNetworks {
+		// Checked on the resolved address, so DNS cannot point a webhook
+		// inside the network after its URL was validated
+		dialer.Control = func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+				return fmt.Errorf("webhook address %s is not public", host)
+			}
+			return nil
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	transport.Proxy = nil
+
+	d := &WebhookDispatcher{
+		store:  store,
+		jobs:   jobs,
+		config: config,
+		client: &http.Client{
+			Timeout:   config.Timeout,
+			Transport: transport,
+			// A redirect could lead anywhere; receivers must answer directly
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		maxAttempts: jobs.config.MaxAttempts,
+		logger:      logger,
+		now:         time.Now,
+	}
+	jobs.Register(webhookDeliveryJob, d.deliver)
+	return d
+}
+
+// Dispatch queues a delivery of event to every enabled webhook of its
+// organization that subscribes to it
+func (d *WebhookDispatcher) Dispatch(ctx context.Context, event *AuditEvent) error {
+	name, ok := webhookEventActions[event.Action]
+	if !ok || event.OrganizationID == nil {
+		return nil
+	}
+
+	webhooks, err := d.store.ListWebhooks(ctx, *event.OrganizationID)
+	if err != nil {
+		return err
+	}
+
+	createdAt := event.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = d.now().UTC()
+	}
+	for _, webhook := range webhooks {
+		if !webhook.Enabled || !slices.Contains(webhook.Events, name) {
+			continue
+		}
+
+		delivery := &WebhookDelivery{
+			ID:             uuid.New(),
+			WebhookID:      webhook.ID,
+			OrganizationID: webhook.OrganizationID,
+			Event:          name,
+			Status:         DeliveryPending,
+		}
+		delivery.Payload, err = json.Marshal(WebhookPayload{
+			ID:             delivery.ID,
+			Event:          name,
+			OrganizationID: webhook.OrganizationID,
+			ActorID:        event.ActorID,
+			TargetID:       event.TargetID,
+			Metadata:       event.Metadata,
+			CreatedAt:      createdAt,
+		})
+		if err != nil {
+			return err
+		}
+		if err := d.store.CreateWebhookDelivery(ctx, delivery); err != nil {
+			return err
+		}
+		if _, err := d.jobs.Enqueue(ctx, webhookDeliveryJob, webhookDeliveryPayload{DeliveryID: delivery.ID}, time.Time{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deliver sends a queued delivery, returning an error to have it retried
+func (d *WebhookDispatcher) deliver(ctx context.Context, payload json.RawMessage) error {
+	var job webhookDeliveryPayload
+	if err := json.Unmarshal(payload, &job); err != nil {
+		return err
+	}
+
+	delivery, err := d.store.GetWebhookDelivery(ctx, job.DeliveryID)
+	if err == ErrWebhookDeliveryNotFound {
+		return nil // its webhook was deleted
+	}
+	if err != nil {
+		return err
+	}
+	webhook, err := d.store.GetWebhook(ctx, delivery.OrganizationID, delivery.WebhookID)
+	if err == ErrWebhookNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	responseStatus, sendErr := d.send(ctx, webhook, delivery)
+
+	status := DeliverySucceeded
+	var lastError *string
+	if sendErr != nil {
+		status = DeliveryPending
+		if delivery.Attempts+1 >= d.maxAttempts {
+			status = DeliveryFailed
+		}
+		msg := sendErr.Error()
+		lastError = &msg
+	}
+	if err := d.store.RecordWebhookAttempt(ctx, delivery.ID, status, responseStatus, lastError); err != nil {
+		return err
+	}
+	return sendErr
+}
+
+// send posts a delivery to its webhook and returns the response status, if
+// there was a response
+func (d *WebhookDispatcher) send(ctx context.Context, webhook *Webhook, delivery *WebhookDelivery) (*int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return nil, err
+	}
+	timestamp := d.now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "huachuca-webhooks/"+currentBuild.Version)
+	req.Header.Set(WebhookEventHeader, delivery.Event)
+	req.Header.Set(WebhookDeliveryHeader, delivery.ID.String())
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(webhook.Secret, timestamp, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &resp.StatusCode, fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return &resp.StatusCode, nil
+}
+
+// dispatchWebhooks queues the webhook deliveries for an audited action
+func (s *Server) dispatchWebhooks(ctx context.Context, event *AuditEvent) {
+	if s.webhooks == nil {
+		return
+	}
+	if err := s.webhooks.Dispatch(ctx, event); err != nil {
+		s.logger.ErrorContext(ctx, "failed to queue webhook deliveries", "action", event.Action, "error", err)
+	}
+}